@@ -0,0 +1,342 @@
+// Package chiseltest helps testing slices from Go tests, such as the ones of
+// a release repository. Slices are cut into a temporary directory from the
+// release checked out locally, and the resulting tree is checked with the
+// provided assertions:
+//
+//	func TestLibc6(t *testing.T) {
+//	        tree := chiseltest.Cut(t, &chiseltest.Options{Release: ".."}, "libc6_libs")
+//	        tree.FileExists("/usr/lib/x86_64-linux-gnu/libc.so.6")
+//	        tree.ELFDepsSatisfied("/usr/lib/x86_64-linux-gnu/libc.so.6")
+//	}
+//
+// Packages are fetched from the archives of the release, and are cached
+// along with the archive indexes in the same directory the chisel command
+// uses.
+package chiseltest
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/ldcache"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+// Options describe how slices are cut.
+type Options struct {
+	// Release is the directory of the release, with its chisel.yaml file
+	// and slice definitions.
+	Release string
+	// Arch is the architecture of the packages, or the one of the host
+	// if empty.
+	Arch string
+}
+
+// Tree is a tree cut from slices.
+type Tree struct {
+	t testing.TB
+	// Root is the directory the slices were cut into, which is removed
+	// once the test is done.
+	Root string
+}
+
+var archiveOpen = archive.Open
+
+// chrootPath lists the directories commands are looked up in when run in
+// the tree.
+var chrootPath = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// Cut cuts the slices, named as in "libc6_libs", into a temporary directory
+// and returns the resulting tree. The test fails immediately if the slices
+// cannot be cut.
+func Cut(t testing.TB, options *Options, sliceNames ...string) *Tree {
+	t.Helper()
+	release, err := setup.ReadRelease(options.Release)
+	if err != nil {
+		t.Fatalf("cannot cut slices: %s", err)
+	}
+	var sliceKeys []setup.SliceKey
+	for _, name := range sliceNames {
+		sliceKey, err := setup.ParseSliceKey(name)
+		if err != nil {
+			t.Fatalf("cannot cut slices: %s", err)
+		}
+		sliceKeys = append(sliceKeys, sliceKey)
+	}
+	selection, err := setup.Select(release, sliceKeys, options.Arch)
+	if err != nil {
+		t.Fatalf("cannot cut slices: %s", err)
+	}
+
+	archives := make(map[string]archive.Archive)
+	for archiveName, archiveInfo := range release.Archives {
+		openArchive, err := archiveOpen(&archive.Options{
+			Label:      archiveName,
+			Version:    archiveInfo.Version,
+			Arch:       options.Arch,
+			Suites:     archiveInfo.Suites,
+			Components: archiveInfo.Components,
+			Pro:        archiveInfo.Pro,
+			CacheDir:   cache.DefaultDir("chisel"),
+			PubKeys:    archiveInfo.PubKeys,
+			Maintained: archiveInfo.Maintained,
+			OldRelease: archiveInfo.OldRelease,
+			Verify:     archiveInfo.Verify,
+			Mirrors:    archiveInfo.Mirrors,
+		})
+		if err == archive.ErrCredentialsNotFound {
+			continue
+		} else if err != nil {
+			t.Fatalf("cannot cut slices: %s", err)
+		}
+		archives[archiveName] = openArchive
+	}
+
+	root := t.TempDir()
+	err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives:  archives,
+		TargetDir: root,
+	})
+	if err != nil {
+		t.Fatalf("cannot cut slices: %s", err)
+	}
+	return &Tree{t: t, Root: root}
+}
+
+// FileExists checks that the path, relative to the root of the tree, exists
+// and is not a directory. Symlinks must resolve within the tree.
+func (tree *Tree) FileExists(path string) bool {
+	tree.t.Helper()
+	realPath, err := tree.resolve(path)
+	if err != nil {
+		tree.t.Errorf("file %s does not exist: %s", path, err)
+		return false
+	}
+	info, err := os.Stat(filepath.Join(tree.Root, realPath))
+	if err != nil {
+		tree.t.Errorf("file %s does not exist", path)
+		return false
+	}
+	if info.IsDir() {
+		tree.t.Errorf("file %s is a directory", path)
+		return false
+	}
+	return true
+}
+
+// ELFDepsSatisfied checks that the interpreter and all the shared libraries
+// required by the ELF file at relPath, relative to the root of the tree, are
+// found in the tree, as well as the ones required by those libraries.
+// Libraries are looked up as the dynamic linker would with the cache
+// generated from the tree, in addition to the run paths of each file.
+func (tree *Tree) ELFDepsSatisfied(relPath string) bool {
+	tree.t.Helper()
+	libs, err := ldcache.Find(tree.Root)
+	if err != nil {
+		tree.t.Errorf("cannot check dependencies of %s: %s", relPath, err)
+		return false
+	}
+	sonames := make(map[string]string)
+	for _, lib := range libs {
+		if _, ok := sonames[lib.Soname]; !ok {
+			sonames[lib.Soname] = lib.Path
+		}
+	}
+
+	var missing []string
+	seen := map[string]bool{}
+	queue := []string{relPath}
+	for len(queue) > 0 {
+		filePath := queue[0]
+		queue = queue[1:]
+		if seen[filePath] {
+			continue
+		}
+		seen[filePath] = true
+		realPath, err := tree.resolve(filePath)
+		if err != nil {
+			tree.t.Errorf("cannot check dependencies of %s: %s", filePath, err)
+			return false
+		}
+		file, err := elf.Open(filepath.Join(tree.Root, realPath))
+		if err != nil {
+			tree.t.Errorf("cannot check dependencies of %s: %s", filePath, err)
+			return false
+		}
+		interp := elfInterpreter(file)
+		needed, err := file.ImportedLibraries()
+		var runPaths []string
+		if err == nil {
+			runPaths, err = elfRunPaths(file, path.Dir(realPath))
+		}
+		file.Close()
+		if err != nil {
+			tree.t.Errorf("cannot check dependencies of %s: %s", filePath, err)
+			return false
+		}
+		if interp != "" {
+			if _, err := tree.resolve(interp); err != nil {
+				missing = append(missing, interp+" (interpreter of "+filePath+")")
+			}
+		}
+		for _, soname := range needed {
+			libPath, ok := tree.findLib(soname, runPaths, sonames)
+			if !ok {
+				missing = append(missing, soname+" (needed by "+filePath+")")
+				continue
+			}
+			queue = append(queue, libPath)
+		}
+	}
+	if len(missing) > 0 {
+		tree.t.Errorf("dependencies of %s not found in the tree: %s", relPath, strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+// RunsInChroot checks that the command runs successfully with the tree as
+// its root directory. The test is skipped when not run as root, as
+// changing the root directory requires it.
+func (tree *Tree) RunsInChroot(command ...string) bool {
+	tree.t.Helper()
+	if len(command) == 0 {
+		tree.t.Fatalf("cannot run in chroot: no command given")
+	}
+	attr, ok := chrootAttr(tree.Root)
+	if !ok {
+		tree.t.Skip("cannot run in chroot on this system")
+	}
+	if os.Geteuid() != 0 {
+		tree.t.Skip("cannot run in chroot without root privileges")
+	}
+	// The command is looked up in the tree rather than in the host.
+	name := command[0]
+	if !strings.Contains(name, "/") {
+		for _, dir := range chrootPath {
+			if _, err := tree.resolve(path.Join(dir, name)); err == nil {
+				name = path.Join(dir, name)
+				break
+			}
+		}
+	}
+	cmd := &exec.Cmd{
+		Path:        name,
+		Args:        command,
+		Dir:         "/",
+		Env:         []string{"PATH=" + strings.Join(chrootPath, ":")},
+		SysProcAttr: attr,
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if err != nil {
+		tree.t.Errorf("command %q failed in chroot: %s\n%s", command, err, output.String())
+		return false
+	}
+	return true
+}
+
+// findLib returns the path of the library with the soname, looked up in
+// the run paths first and in the cache of the tree next.
+func (tree *Tree) findLib(soname string, runPaths []string, sonames map[string]string) (string, bool) {
+	if strings.Contains(soname, "/") {
+		_, err := tree.resolve(soname)
+		return soname, err == nil
+	}
+	for _, dir := range runPaths {
+		libPath := path.Join(dir, soname)
+		if _, err := tree.resolve(libPath); err == nil {
+			return libPath, true
+		}
+	}
+	libPath, ok := sonames[soname]
+	return libPath, ok
+}
+
+// resolve returns the path, relative to the root of the tree, the given path
+// resolves to once all symlinks are followed within the tree.
+func (tree *Tree) resolve(relPath string) (string, error) {
+	relPath = path.Clean("/" + relPath)
+	for range 40 {
+		resolved := "/"
+		link := ""
+		names := strings.Split(strings.TrimPrefix(relPath, "/"), "/")
+		for i, name := range names {
+			current := path.Join(resolved, name)
+			info, err := os.Lstat(filepath.Join(tree.Root, current))
+			if err != nil {
+				return "", err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				target, err := os.Readlink(filepath.Join(tree.Root, current))
+				if err != nil {
+					return "", err
+				}
+				if !path.IsAbs(target) {
+					target = path.Join(resolved, target)
+				}
+				link = path.Join(append([]string{target}, names[i+1:]...)...)
+				break
+			}
+			resolved = current
+		}
+		if link == "" {
+			return resolved, nil
+		}
+		relPath = path.Clean("/" + link)
+	}
+	return "", errors.New("too many levels of symbolic links")
+}
+
+// elfInterpreter returns the program interpreter of the ELF file, if any.
+func elfInterpreter(file *elf.File) string {
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		_, err := prog.ReadAt(data, 0)
+		if err != nil {
+			return ""
+		}
+		return string(bytes.TrimRight(data, "\x00"))
+	}
+	return ""
+}
+
+// elfRunPaths returns the directories listed in the run paths of the ELF
+// file in dir, with $ORIGIN replaced.
+func elfRunPaths(file *elf.File, dir string) ([]string, error) {
+	var runPaths []string
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := file.DynString(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			for _, runPath := range strings.Split(value, ":") {
+				runPath = strings.ReplaceAll(runPath, "${ORIGIN}", dir)
+				runPath = strings.ReplaceAll(runPath, "$ORIGIN", dir)
+				if runPath != "" && !slices.Contains(runPaths, runPath) {
+					runPaths = append(runPaths, runPath)
+				}
+			}
+		}
+	}
+	return runPaths, nil
+}
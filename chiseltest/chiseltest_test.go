@@ -0,0 +1,225 @@
+package chiseltest_test
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/chiseltest"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+const libDir = "./usr/lib/x86_64-linux-gnu/"
+
+var testRelease = map[string]string{
+	"chisel.yaml": testutil.DefaultChiselYaml,
+	"slices/mypkg.yaml": `
+		package: mypkg
+		slices:
+			libfoo:
+				contents:
+					/usr/lib/x86_64-linux-gnu/libfoo.so.1:
+			libbar:
+				contents:
+					/usr/lib/x86_64-linux-gnu/libbar.so.1:
+			app:
+				essential:
+					- mypkg_libfoo
+					- mypkg_libbar
+				contents:
+					/usr/bin/app:
+			app-nolibs:
+				contents:
+					/usr/bin/app:
+					/usr/bin/link:
+			config:
+				contents:
+					/etc/app.conf:
+					/etc/dangling:
+	`,
+}
+
+var testPackage = &testutil.TestPackage{
+	Name:    "mypkg",
+	Version: "1.0",
+	Hash:    "hash",
+	Arch:    "amd64",
+	Data: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/app.conf", "conf"),
+		testutil.Lnk(0777, "./etc/dangling", "missing"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", string(testutil.MakeELF(elf.EM_X86_64, "", "libfoo.so.1"))),
+		testutil.Lnk(0777, "./usr/bin/link", "app"),
+		testutil.Dir(0755, "./usr/lib/"),
+		testutil.Dir(0755, libDir),
+		testutil.Reg(0644, libDir+"libfoo.so.1", string(testutil.MakeELF(elf.EM_X86_64, "libfoo.so.1", "libbar.so.1"))),
+		testutil.Reg(0644, libDir+"libbar.so.1", string(testutil.MakeELF(elf.EM_X86_64, "libbar.so.1"))),
+	}),
+}
+
+// fakeT records the failures of the assertions run with it.
+type fakeT struct {
+	testing.TB
+	c       *C
+	errors  []string
+	skipped string
+}
+
+type fakeTStop struct{}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) TempDir() string { return t.c.MkDir() }
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Fatalf(format string, args ...any) {
+	t.Errorf(format, args...)
+	panic(fakeTStop{})
+}
+
+func (t *fakeT) Skip(args ...any) {
+	t.skipped = fmt.Sprint(args...)
+	panic(fakeTStop{})
+}
+
+// run runs f as a test with t, stopping it on fatal errors and skips.
+func (t *fakeT) run(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fakeTStop); !ok {
+				panic(r)
+			}
+		}
+	}()
+	f()
+}
+
+func fakeRelease(c *C) (releaseDir string, restore func()) {
+	releaseDir = c.MkDir()
+	for path, data := range testRelease {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	restore = chiseltest.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return &testutil.TestArchive{
+			Opts:     *options,
+			Packages: map[string]*testutil.TestPackage{"mypkg": testPackage},
+		}, nil
+	})
+	return releaseDir, restore
+}
+
+var chiseltestTests = []struct {
+	summary string
+	slices  []string
+	check   func(tree *chiseltest.Tree)
+	errors  []string
+}{{
+	summary: "Files exist",
+	slices:  []string{"mypkg_config", "mypkg_app-nolibs"},
+	check: func(tree *chiseltest.Tree) {
+		tree.FileExists("/etc/app.conf")
+		tree.FileExists("/usr/bin/link")
+	},
+}, {
+	summary: "Missing files and directories",
+	slices:  []string{"mypkg_config"},
+	check: func(tree *chiseltest.Tree) {
+		tree.FileExists("/usr/bin/app")
+		tree.FileExists("/etc/")
+		tree.FileExists("/etc/dangling")
+	},
+	errors: []string{
+		"file /usr/bin/app does not exist: .*",
+		"file /etc/ is a directory",
+		"file /etc/dangling does not exist: .*",
+	},
+}, {
+	summary: "Dependencies satisfied",
+	slices:  []string{"mypkg_app"},
+	check: func(tree *chiseltest.Tree) {
+		tree.ELFDepsSatisfied("/usr/bin/app")
+	},
+}, {
+	summary: "Dependencies missing",
+	slices:  []string{"mypkg_app-nolibs"},
+	check: func(tree *chiseltest.Tree) {
+		tree.ELFDepsSatisfied("/usr/bin/link")
+	},
+	errors: []string{
+		`dependencies of /usr/bin/link not found in the tree: libfoo.so.1 \(needed by /usr/bin/link\)`,
+	},
+}, {
+	summary: "Dependencies of libraries missing",
+	slices:  []string{"mypkg_app-nolibs", "mypkg_libfoo"},
+	check: func(tree *chiseltest.Tree) {
+		tree.ELFDepsSatisfied("/usr/bin/app")
+	},
+	errors: []string{
+		`dependencies of /usr/bin/app not found in the tree: libbar.so.1 \(needed by /usr/lib/x86_64-linux-gnu/libfoo.so.1\)`,
+	},
+}, {
+	summary: "Not an ELF file",
+	slices:  []string{"mypkg_config"},
+	check: func(tree *chiseltest.Tree) {
+		tree.ELFDepsSatisfied("/etc/app.conf")
+	},
+	errors: []string{
+		`cannot check dependencies of /etc/app.conf: .*`,
+	},
+}, {
+	summary: "Unknown slice",
+	slices:  []string{"mypkg_other"},
+	errors: []string{
+		`cannot cut slices: slice mypkg_other not found`,
+	},
+}}
+
+func (s *S) TestChiseltest(c *C) {
+	releaseDir, restore := fakeRelease(c)
+	defer restore()
+
+	for _, test := range chiseltestTests {
+		c.Logf("Summary: %s", test.summary)
+		t := &fakeT{c: c}
+		t.run(func() {
+			tree := chiseltest.Cut(t, &chiseltest.Options{Release: releaseDir, Arch: "amd64"}, test.slices...)
+			test.check(tree)
+		})
+		c.Assert(t.errors, HasLen, len(test.errors), Commentf("%q", t.errors))
+		for i, err := range test.errors {
+			c.Assert(t.errors[i], Matches, err)
+		}
+	}
+}
+
+func (s *S) TestRunsInChroot(c *C) {
+	releaseDir, restore := fakeRelease(c)
+	defer restore()
+
+	t := &fakeT{c: c}
+	t.run(func() {
+		tree := chiseltest.Cut(t, &chiseltest.Options{Release: releaseDir, Arch: "amd64"}, "mypkg_config")
+		tree.RunsInChroot("missing", "--version")
+	})
+	if os.Geteuid() != 0 {
+		c.Assert(t.skipped, Equals, "cannot run in chroot without root privileges")
+		return
+	}
+	c.Assert(t.errors, HasLen, 1)
+	c.Assert(t.errors[0], Matches, `(?s)command \["missing" "--version"\] failed in chroot: .*`)
+}
@@ -0,0 +1,13 @@
+package chiseltest
+
+import (
+	"github.com/canonical/chisel/internal/archive"
+)
+
+func FakeArchiveOpen(f func(options *archive.Options) (archive.Archive, error)) (restore func()) {
+	oldArchiveOpen := archiveOpen
+	archiveOpen = f
+	return func() {
+		archiveOpen = oldArchiveOpen
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package chiseltest
+
+import (
+	"syscall"
+)
+
+// chrootAttr reports that processes cannot be run in a different root
+// directory on this system.
+func chrootAttr(root string) (*syscall.SysProcAttr, bool) {
+	return nil, false
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package chiseltest
+
+import (
+	"syscall"
+)
+
+// chrootAttr returns the attributes of a process run with root as its root
+// directory, and whether it is supported on this system.
+func chrootAttr(root string) (*syscall.SysProcAttr, bool) {
+	return &syscall.SysProcAttr{Chroot: root}, true
+}
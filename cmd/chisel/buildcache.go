@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// buildCache keeps the releases read and the archives opened by the cuts
+// run by commands which serve many of them, so that each release is only
+// parsed once and each archive index is only fetched once.
+type buildCache struct {
+	mu sync.Mutex
+	// ttl is how long entries are kept, or forever if zero, so that
+	// long running commands eventually see updates to the archives.
+	ttl      time.Duration
+	releases map[string]*cacheEntry[*setup.Release]
+	archives map[string]*cacheEntry[map[string]archive.Archive]
+}
+
+type cacheEntry[T any] struct {
+	value T
+	added time.Time
+}
+
+func newBuildCache(ttl time.Duration) *buildCache {
+	return &buildCache{
+		ttl:      ttl,
+		releases: make(map[string]*cacheEntry[*setup.Release]),
+		archives: make(map[string]*cacheEntry[map[string]archive.Archive]),
+	}
+}
+
+// activeCache is the cache used by obtainRelease and openArchives, if any.
+var activeCache *buildCache
+
+func (c *buildCache) fresh(added time.Time) bool {
+	return c.ttl == 0 || time.Since(added) < c.ttl
+}
+
+func (c *buildCache) release(releaseStr string) *setup.Release {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.releases[releaseStr]
+	if !ok || !c.fresh(entry.added) {
+		return nil
+	}
+	return entry.value
+}
+
+func (c *buildCache) addRelease(releaseStr string, release *setup.Release) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releases[releaseStr] = &cacheEntry[*setup.Release]{release, time.Now()}
+}
+
+// openArchives is like the openArchives function, but returns the archives
+// opened before with the same options, if any. As cuts run one at a time,
+// the downloads of the archives are cancelled along with the ctx of the
+// request using them at the time, rather than the one which opened them.
+func (c *buildCache) openArchives(ctx context.Context, release *setup.Release, arch string, refresh bool, httpOverride *archive.HTTPOptions) (map[string]archive.Archive, error) {
+	override, err := json.Marshal(httpOverride)
+	if err != nil {
+		return nil, err
+	}
+	// Cuts may change the suites and components of the archives.
+	var lists []string
+	for _, archiveName := range slices.Sorted(maps.Keys(release.Archives)) {
+		archiveInfo := release.Archives[archiveName]
+		lists = append(lists, archiveName+"="+strings.Join(archiveInfo.Suites, ",")+"/"+strings.Join(archiveInfo.Components, ","))
+	}
+	key := release.Path + "\x00" + arch + "\x00" + string(override) + "\x00" + strings.Join(lists, " ")
+	c.mu.Lock()
+	entry, ok := c.archives[key]
+	c.mu.Unlock()
+	if ok && !refresh && c.fresh(entry.added) {
+		for _, openArchive := range entry.value {
+			openArchive.Options().Context = ctx
+		}
+		return entry.value, nil
+	}
+	archives, err := openArchivesUncached(ctx, release, arch, refresh, httpOverride)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.archives[key]; ok {
+		closeArchives(old.value)
+		delete(c.archives, key)
+	}
+	c.expireArchives()
+	c.archives[key] = &cacheEntry[map[string]archive.Archive]{archives, time.Now()}
+	return archives, nil
+}
+
+// expireArchives closes and drops the archives kept for longer than the
+// ttl. It must be called with the lock held.
+func (c *buildCache) expireArchives() {
+	for key, entry := range c.archives {
+		if !c.fresh(entry.added) {
+			closeArchives(entry.value)
+			delete(c.archives, key)
+		}
+	}
+}
+
+// close closes and drops all the archives kept, once the cache is no
+// longer used.
+func (c *buildCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.archives {
+		closeArchives(entry.value)
+		delete(c.archives, key)
+	}
+}
+
+// closeArchives closes the archives, which may not be used afterwards.
+func closeArchives(archives map[string]archive.Archive) {
+	for _, openArchive := range archives {
+		openArchive.Close()
+	}
+}
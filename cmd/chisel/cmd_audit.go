@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/security"
+)
+
+var shortAuditHelp = "Report known vulnerabilities in a tree"
+var longAuditHelp = `
+The audit command lists the Ubuntu Security Notices (USN) affecting the
+exact package versions recorded in a lockfile or in a manifest, based on
+the OVAL data published by Canonical for the release. The data is fetched
+and cached locally.
+
+Manifests are recognized by the .wall extension, and any other file is
+read as a lockfile.
+
+The release is taken from the lockfile when recorded there. Otherwise it
+defaults to the same Ubuntu version as the current host, unless the
+--release flag is used.
+`
+
+var auditDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"json":    "Output the vulnerabilities in JSON format",
+}
+
+var auditArgDescs = []argDesc{{
+	name: "<lockfile|manifest>",
+	desc: "Lockfile or manifest recording the packages of a tree",
+}}
+
+type cmdAudit struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	JSON    bool   `long:"json"`
+
+	Positional struct {
+		File string `positional-arg-name:"<lockfile|manifest>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("audit", shortAuditHelp, longAuditHelp, func() flags.Commander { return &cmdAudit{} }, auditDescs, auditArgDescs)
+}
+
+var securityFetch = security.Fetch
+
+func (cmd *cmdAudit) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	recorded, releaseName, err := readRecordedPackages(cmd.Positional.File)
+	if err != nil {
+		return err
+	}
+	releaseStr := cmd.Release
+	if releaseStr == "" {
+		releaseStr = releaseName
+	}
+	release, err := obtainRelease(releaseStr)
+	if err != nil {
+		return err
+	}
+
+	pkgs := make([]*archive.PackageInfo, 0, len(recorded))
+	for _, pkg := range recorded {
+		pkgs = append(pkgs, &archive.PackageInfo{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Arch:    pkg.Arch,
+		})
+	}
+	vulns, err := auditPackages(release, pkgs)
+	if err != nil {
+		return err
+	}
+
+	if cmd.JSON {
+		return writeVulnerabilities(Stdout, vulns)
+	}
+	if len(vulns) == 0 {
+		fmt.Fprintf(Stderr, "No known vulnerabilities\n")
+		return nil
+	}
+	w := tabWriter()
+	fmt.Fprintf(w, "Package\tVersion\tFixed\tNotice\tCVEs\n")
+	for _, vuln := range vulns {
+		cves := "-"
+		if len(vuln.CVEs) > 0 {
+			cves = strings.Join(vuln.CVEs, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", vuln.Package, vuln.Version, vuln.FixedVersion, vuln.Notice, cves)
+	}
+	return w.Flush()
+}
+
+// writeVulnerabilities writes the vulnerabilities as a JSON array.
+func writeVulnerabilities(w io.Writer, vulns []*security.Vulnerability) error {
+	if vulns == nil {
+		vulns = []*security.Vulnerability{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(vulns)
+}
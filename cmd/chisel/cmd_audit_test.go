@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/security"
+)
+
+var auditNotices = []*security.Notice{{
+	ID:    "USN-1000-1",
+	Title: "Mypkg vulnerability",
+	URL:   "https://ubuntu.com/security/notices/USN-1000-1",
+	CVEs:  []string{"CVE-2024-0001", "CVE-2024-0002"},
+	Fixed: map[string]string{"mypkg": "1.1"},
+}, {
+	ID:    "USN-900-1",
+	Title: "Old mypkg vulnerability",
+	Fixed: map[string]string{"mypkg": "0.9"},
+}}
+
+var auditJSON = `[
+  {
+    "package": "mypkg",
+    "version": "1.0",
+    "fixed-version": "1.1",
+    "notice": "USN-1000-1",
+    "title": "Mypkg vulnerability",
+    "url": "https://ubuntu.com/security/notices/USN-1000-1",
+    "cves": [
+      "CVE-2024-0001",
+      "CVE-2024-0002"
+    ]
+  }
+]
+`
+
+func (s *ChiselSuite) TestAudit(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+	defer chisel.FakeSecurityFetch(func(options *security.FetchOptions) ([]*security.Notice, error) {
+		c.Assert(options.Codename, Equals, "jammy")
+		return auditNotices, nil
+	})()
+
+	// The report is written along with the cut.
+	dir := c.MkDir()
+	lockPath := filepath.Join(dir, "chisel.lock")
+	reportPath := filepath.Join(dir, "report.json")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--security-report", reportPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(reportPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, auditJSON)
+
+	// Or later on from the lockfile.
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"audit", "--release", releaseDir, lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(s.Stdout()), Equals, strings.Join([]string{
+		"Package  Version  Fixed  Notice      CVEs",
+		"mypkg    1.0      1.1    USN-1000-1  CVE-2024-0001,CVE-2024-0002",
+	}, "\n"))
+
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"audit", "--release", releaseDir, "--json", lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, auditJSON)
+}
+
+func (s *ChiselSuite) TestAuditNoVulnerabilities(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+	defer chisel.FakeSecurityFetch(func(options *security.FetchOptions) ([]*security.Notice, error) {
+		return auditNotices[1:], nil
+	})()
+
+	lockPath := filepath.Join(c.MkDir(), "chisel.lock")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"audit", "--release", releaseDir, lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "No known vulnerabilities\n")
+
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"audit", "--release", releaseDir, "--json", lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "[]\n")
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortBrowseHelp = "Put together a selection of slices at a prompt"
+var longBrowseHelp = `
+The browse command is a line-based prompt which reads one command per line
+from the standard input to explore the slices of a release and put together
+a selection. The number of paths each slice declares is listed, and the
+slices pulled in as essentials may be previewed before cutting.
+
+The size command estimates the size the selection would take in the tree,
+as the dry run of the cut command does: the content of the packages in the
+cache is measured, and the installed size bounds the estimate of the others.
+
+Once done, the equivalent cut command may be printed, or the selection
+saved to a file for later use.
+
+By default it fetches the slices for the same Ubuntu version as the
+current host, unless the --release flag is used.
+`
+
+var browseDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+var browseCommandsHelp = `Commands:
+  find <query>...     Find slices matching all of the query strings
+  info <slice>        Show the contents and essentials of a slice
+  add <slice>...      Add slices to the selection
+  remove <slice>...   Remove slices from the selection
+  show                Show the selection with its essentials
+  size                Estimate the size of the selection per package
+  cut [<dir>]         Print the cut command for the selection
+  save <file>         Save the selection to a file
+  help                Show this help
+  quit                Leave
+`
+
+type cmdBrowse struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+}
+
+func init() {
+	addCommand("browse", shortBrowseHelp, longBrowseHelp, func() flags.Commander { return &cmdBrowse{} }, browseDescs, nil)
+}
+
+type browser struct {
+	cmd      *cmdBrowse
+	release  *setup.Release
+	selected []setup.SliceKey
+	// archives are opened once sizes are first estimated.
+	archives map[string]archive.Archive
+}
+
+func (cmd *cmdBrowse) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+
+	b := &browser{cmd: cmd, release: release}
+	if isStdinTTY {
+		fmt.Fprintf(Stdout, "Type \"help\" for the list of commands.\n")
+	}
+	scanner := bufio.NewScanner(Stdin)
+	for {
+		if isStdinTTY {
+			fmt.Fprintf(Stdout, "chisel> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return nil
+		}
+		err := b.run(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(Stderr, "error: %s\n", err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (b *browser) run(command string, args []string) error {
+	switch command {
+	case "find", "search":
+		return b.find(args)
+	case "info":
+		return b.info(args)
+	case "add":
+		return b.add(args)
+	case "remove":
+		return b.remove(args)
+	case "show":
+		return b.show(args)
+	case "size":
+		return b.size(args)
+	case "cut":
+		return b.cut(args)
+	case "save":
+		return b.save(args)
+	case "help":
+		fmt.Fprint(Stdout, browseCommandsHelp)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, see \"help\"", command)
+}
+
+func (b *browser) find(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("find requires a query")
+	}
+	found, err := findSlices(b.release, args)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(Stdout, "No matching slices for \"%s\"\n", strings.Join(args, " "))
+		return nil
+	}
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tPaths\tSelected\n")
+	for _, slice := range found {
+		selected := "-"
+		if b.isSelected(sliceKey(slice)) {
+			selected = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", slice, len(slice.Contents), selected)
+	}
+	return w.Flush()
+}
+
+func (b *browser) info(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("info requires a single slice name")
+	}
+	slice, err := b.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "Slice: %s\n", slice)
+	if slice.Summary != "" {
+		fmt.Fprintf(Stdout, "Summary: %s\n", slice.Summary)
+	}
+	if len(slice.Owners) > 0 {
+		fmt.Fprintf(Stdout, "Owners: %s\n", strings.Join(slice.Owners, ", "))
+	}
+	if slice.Deprecated != "" {
+		fmt.Fprintf(Stdout, "Deprecated: %s\n", slice.Deprecated)
+	}
+	var essentials []string
+	for key := range slice.Essential {
+		essentials = append(essentials, key.String())
+	}
+	slices.Sort(essentials)
+	if len(essentials) > 0 {
+		fmt.Fprintf(Stdout, "Essential:\n")
+		for _, essential := range essentials {
+			fmt.Fprintf(Stdout, "  %s\n", essential)
+		}
+	}
+	var paths []string
+	for path := range slice.Contents {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	if len(paths) > 0 {
+		fmt.Fprintf(Stdout, "Contents:\n")
+		for _, path := range paths {
+			fmt.Fprintf(Stdout, "  %s\n", path)
+		}
+	}
+	return nil
+}
+
+func (b *browser) add(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("add requires slice names")
+	}
+	for _, arg := range args {
+		slice, err := b.lookup(arg)
+		if err != nil {
+			return err
+		}
+		key := sliceKey(slice)
+		if !b.isSelected(key) {
+			b.selected = append(b.selected, key)
+		}
+	}
+	return nil
+}
+
+func (b *browser) remove(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("remove requires slice names")
+	}
+	for _, arg := range args {
+		key, err := setup.ParseSliceKey(arg)
+		if err != nil {
+			return err
+		}
+		if !b.isSelected(key) {
+			return fmt.Errorf("slice %s is not selected", key)
+		}
+		b.selected = slices.DeleteFunc(b.selected, func(k setup.SliceKey) bool { return k == key })
+	}
+	return nil
+}
+
+func (b *browser) show(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if len(b.selected) == 0 {
+		fmt.Fprintf(Stdout, "No slices selected.\n")
+		return nil
+	}
+	selection, err := setup.Select(b.release, b.selected, b.cmd.Arch)
+	if err != nil {
+		return err
+	}
+	total := 0
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tPaths\tSelected\n")
+	for _, slice := range selection.Slices {
+		selected := "essential"
+		if b.isSelected(sliceKey(slice)) {
+			selected = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", slice, len(slice.Contents), selected)
+		total += len(slice.Contents)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "%d slices, %d paths in total.\n", len(selection.Slices), total)
+	return nil
+}
+
+func (b *browser) size(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if len(b.selected) == 0 {
+		return fmt.Errorf("no slices selected")
+	}
+	selection, err := setup.Select(b.release, b.selected, b.cmd.Arch)
+	if err != nil {
+		return err
+	}
+	if b.archives == nil {
+		archives, err := openArchives(context.Background(), b.release, b.cmd.Arch, false, nil)
+		if err != nil {
+			return err
+		}
+		b.archives = archives
+	}
+	return writeDryRun(b.release, selection, b.archives, nil, true)
+}
+
+func (b *browser) cut(args []string) error {
+	if len(args) > 1 {
+		return ErrExtraArgs
+	}
+	if len(b.selected) == 0 {
+		return fmt.Errorf("no slices selected")
+	}
+	rootDir := "<dir>"
+	if len(args) == 1 {
+		rootDir = args[0]
+	}
+	words := []string{"chisel", "cut"}
+	if b.cmd.Release != "" {
+		words = append(words, "--release", b.cmd.Release)
+	}
+	if b.cmd.Arch != "" {
+		words = append(words, "--arch", b.cmd.Arch)
+	}
+	words = append(words, "--root", rootDir)
+	for _, key := range b.selected {
+		words = append(words, key.String())
+	}
+	fmt.Fprintf(Stdout, "%s\n", strings.Join(words, " "))
+	return nil
+}
+
+func (b *browser) save(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("save requires a single file name")
+	}
+	if len(b.selected) == 0 {
+		return fmt.Errorf("no slices selected")
+	}
+	file := selectionFile{
+		Release: b.cmd.Release,
+		Arch:    b.cmd.Arch,
+	}
+	for _, key := range b.selected {
+		file.Slices = append(file.Slices, key.String())
+	}
+	data, err := yaml.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(args[0], data, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "Selection saved to %s\n", args[0])
+	return nil
+}
+
+func (b *browser) lookup(name string) (*setup.Slice, error) {
+	key, err := setup.ParseSliceKey(name)
+	if err != nil {
+		return nil, err
+	}
+	pkg, ok := b.release.Packages[key.Package]
+	if !ok {
+		return nil, fmt.Errorf("slice %s not found", key)
+	}
+	slice, ok := pkg.Slices[key.Slice]
+	if !ok {
+		return nil, fmt.Errorf("slice %s not found", key)
+	}
+	return slice, nil
+}
+
+func (b *browser) isSelected(key setup.SliceKey) bool {
+	return slices.Contains(b.selected, key)
+}
+
+func sliceKey(slice *setup.Slice) setup.SliceKey {
+	return setup.SliceKey{Package: slice.Package, Slice: slice.Name}
+}
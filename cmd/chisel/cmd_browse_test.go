@@ -0,0 +1,186 @@
+package main_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type browseTest struct {
+	summary string
+	input   string
+	stdout  string
+	stderr  string
+	file    string
+}
+
+var browseTests = []browseTest{{
+	summary: "Find slices",
+	input: `
+		find mypkg*
+	`,
+	stdout: `
+		Slice            Paths  Selected
+		mypkg1_myslice1  1      -
+		mypkg1_myslice2  0      -
+		mypkg2_myslice   1      -
+		mypkg3_myslice   9      -
+	`,
+}, {
+	summary: "Show slice information",
+	input: `
+		info mypkg3_myslice
+	`,
+	stdout: `
+		Slice: mypkg3_myslice
+		Essential:
+		  mypkg1_myslice1
+		  mypkg2_myslice
+		Contents:
+		  /dir/arch-specific*
+		  /dir/copy
+		  /dir/glob*
+		  /dir/mutable
+		  /dir/other-file
+		  /dir/sub-dir/
+		  /dir/symlink
+		  /dir/unfolded
+		  /dir/until
+	`,
+}, {
+	summary: "Preview the selection with its essentials",
+	input: `
+		add mypkg3_myslice
+		find mypkg*
+		show
+	`,
+	stdout: `
+		Slice            Paths  Selected
+		mypkg1_myslice1  1      -
+		mypkg1_myslice2  0      -
+		mypkg2_myslice   1      -
+		mypkg3_myslice   9      yes
+		Slice            Paths  Selected
+		mypkg1_myslice1  1      essential
+		mypkg2_myslice   1      essential
+		mypkg3_myslice   9      yes
+		3 slices, 11 paths in total.
+	`,
+}, {
+	summary: "Print the cut command",
+	input: `
+		add mypkg2_myslice mypkg1_myslice1
+		add mypkg3_myslice
+		remove mypkg2_myslice
+		cut /tmp/rootfs
+		quit
+		show
+	`,
+	stdout: `
+		chisel cut --release <release> --root /tmp/rootfs mypkg1_myslice1 mypkg3_myslice
+	`,
+}, {
+	summary: "Save the selection",
+	input: `
+		add mypkg1_myslice1 mypkg2_myslice
+		save <file>
+	`,
+	stdout: `
+		Selection saved to <file>
+	`,
+	file: `
+		release: <release>
+		slices:
+		    - mypkg1_myslice1
+		    - mypkg2_myslice
+	`,
+}, {
+	summary: "Errors do not end the session",
+	input: `
+		frobnicate
+		add mypkg1_foo
+		remove mypkg2_myslice
+		cut
+		show
+	`,
+	stdout: `
+		No slices selected.
+	`,
+	stderr: `
+		error: unknown command "frobnicate", see "help"
+		error: slice mypkg1_foo not found
+		error: slice mypkg2_myslice is not selected
+		error: no slices selected
+	`,
+}}
+
+func (s *ChiselSuite) TestBrowseCommand(c *C) {
+	for _, test := range browseTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		dir := c.MkDir()
+		for path, data := range infoRelease {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		file := filepath.Join(c.MkDir(), "selection.yaml")
+		replacer := strings.NewReplacer("<release>", dir, "<file>", file)
+
+		s.stdin.WriteString(replacer.Replace(string(testutil.Reindent(test.input))))
+		_, err := chisel.Parser().ParseArgs([]string{"browse", "--release", dir})
+		c.Assert(err, IsNil)
+
+		stdout := replacer.Replace(strings.TrimSpace(string(testutil.Reindent(test.stdout))))
+		c.Assert(strings.TrimSpace(s.Stdout()), Equals, stdout)
+		stderr := strings.TrimSpace(string(testutil.Reindent(test.stderr)))
+		c.Assert(strings.TrimSpace(s.Stderr()), Equals, stderr)
+		if test.file != "" {
+			data, err := os.ReadFile(file)
+			c.Assert(err, IsNil)
+			c.Assert(strings.TrimSpace(string(data)), Equals, replacer.Replace(strings.TrimSpace(string(testutil.Reindent(test.file)))))
+		}
+	}
+}
+
+func (s *ChiselSuite) TestBrowseSize(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	pkg := testArchive.Packages["mypkg"]
+	pkg.InstalledSize = 10240
+	sum := sha256.Sum256(pkg.Data)
+	pkg.Hash = hex.EncodeToString(sum[:])
+
+	// The installed size bounds the estimate of packages not fetched.
+	s.stdin.WriteString("add mypkg_bins\nsize\n")
+	_, err := chisel.Parser().ParseArgs([]string{"browse", "--release", releaseDir})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Version  Archive  Slices  Installed  Estimate\n"+
+		"mypkg    1.0      ubuntu   bins    10.0 KiB   <= 10.0 KiB\n"+
+		"Total                              10.0 KiB   <= 10.0 KiB\n")
+
+	// The paths of packages in the cache are measured.
+	pkgCache := &cache.Cache{Dir: cache.DefaultDir("chisel")}
+	c.Assert(pkgCache.Write(pkg.Hash, pkg.Data), IsNil)
+	s.ResetStdStreams()
+	s.stdin.WriteString("add mypkg_bins mypkg_config\nsize\n")
+	_, err = chisel.Parser().ParseArgs([]string{"browse", "--release", releaseDir})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Version  Archive  Slices       Installed  Estimate\n"+
+		"mypkg    1.0      ubuntu   bins,config  10.0 KiB   7 B\n"+
+		"Total                                   10.0 KiB   7 B\n")
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortCheckSliceHelp = "Check slice definition files"
+var longCheckSliceHelp = `
+The check-slice command parses the slice definition files given and
+validates them against a release, in place of the definitions of the same
+packages in the release, if any. It prints nothing and exits successfully
+if the files are valid, which makes it suitable for editor integrations
+and pre-commit hooks.
+
+Only the definitions of the packages reached through the essentials and
+prefers of each file are read from the release, so conflicts with other
+packages are not detected unless the --full option is used.
+
+By default the release is the one holding the file, found by looking for
+a chisel.yaml file in its parent directories, or otherwise the one for the
+same Ubuntu version as the current host, unless the --release flag is used.
+
+With the --emit-pr-notes option, a Markdown summary of the changes made by
+each file is written for inclusion in pull requests to release repositories,
+listing the slices changed with their size impact, the new and removed
+paths, and the conflicts resolved with prefer. The changes are relative to
+the definitions of the package in the release given with --pr-base, such as
+the target branch of the pull request, or to no definitions at all
+otherwise. The package is fetched from the archives of the release to
+compute the sizes.
+
+With the --watch option, the files are checked again whenever they or the
+files of their release change, until interrupted, for a fast edit and
+validate loop while writing slices. The releases are reused from the cache
+while they do not change. The outcome of every check is printed, with errors
+going to the standard error, and errors do not stop the command.
+`
+
+var checkSliceDescs = map[string]string{
+	"release":       "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"full":          "Validate against all of the slice definitions",
+	"emit-pr-notes": "Write a Markdown summary of the changes for pull requests",
+	"pr-base":       "Chisel release name or directory the changes are relative to",
+	"watch":         "Check the files again whenever they change",
+}
+
+type cmdCheckSlice struct {
+	Release     string `long:"release" value-name:"<branch|dir>"`
+	Full        bool   `long:"full"`
+	EmitPRNotes bool   `long:"emit-pr-notes"`
+	PRBase      string `long:"pr-base" value-name:"<branch|dir>"`
+	Watch       bool   `long:"watch"`
+
+	Positional struct {
+		Files []string `positional-arg-name:"<file>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("check-slice", shortCheckSliceHelp, longCheckSliceHelp, func() flags.Commander { return &cmdCheckSlice{} }, checkSliceDescs, nil)
+}
+
+func (cmd *cmdCheckSlice) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	if cmd.Watch {
+		return cmd.watch()
+	}
+	return cmd.check(false)
+}
+
+// check checks all of the files. With cached, the releases are reused from
+// the cache while they do not change.
+func (cmd *cmdCheckSlice) check(cached bool) error {
+	dirs, err := cmd.releaseDirs()
+	if err != nil {
+		return err
+	}
+	for i, file := range cmd.Positional.Files {
+		var pkg *setup.Package
+		if cached {
+			pkg, err = setup.CheckCachedPackage(dirs[i], "", file, cmd.Full)
+		} else {
+			pkg, err = setup.CheckPackage(dirs[i], file, cmd.Full)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot check %s: %w", file, err)
+		}
+		if cmd.EmitPRNotes {
+			err = cmd.writePRNotes(dirs[i], pkg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseDirs returns the directory of the release each file is checked
+// against.
+func (cmd *cmdCheckSlice) releaseDirs() ([]string, error) {
+	var dirs []string
+	for _, file := range cmd.Positional.Files {
+		releaseStr := cmd.Release
+		if releaseStr == "" {
+			releaseStr = findReleaseDir(file)
+		}
+		dir, err := obtainReleaseDir(releaseStr)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// watchInterval is how often the watched files are looked at for changes.
+var watchInterval = 500 * time.Millisecond
+
+// watchChecked is called once the files are checked in watch mode, with the
+// error found, if any.
+var watchChecked = func(err error) {}
+
+// watch checks the files whenever they or their releases change, until
+// interrupted.
+func (cmd *cmdCheckSlice) watch() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dirs, err := cmd.releaseDirs()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		// The state is taken before checking so that changes made
+		// while checking are noticed too.
+		state := watchState(dirs, cmd.Positional.Files)
+		err := cmd.check(true)
+		if err != nil {
+			fmt.Fprintf(Stderr, "error: %v\n", err)
+		} else {
+			fmt.Fprintf(Stdout, "Slice definitions are valid.\n")
+		}
+		watchChecked(err)
+		logf("Watching for changes...")
+		for maps.Equal(state, watchState(dirs, cmd.Positional.Files)) {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// watchStamp identifies the content of a file without reading it.
+type watchStamp struct {
+	size    int64
+	modTime int64
+}
+
+// watchState returns the stamps of the given files, and of the release
+// definition and slice definition files of the releases in dirs. Files
+// which cannot be read are left out, so that they are noticed once they
+// appear.
+func watchState(dirs []string, files []string) map[string]watchStamp {
+	state := make(map[string]watchStamp)
+	add := func(path string, info fs.FileInfo) {
+		state[path] = watchStamp{info.Size(), info.ModTime().UnixNano()}
+	}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			add(file, info)
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "chisel.yaml")
+		if info, err := os.Stat(path); err == nil {
+			add(path, info)
+		}
+		filepath.WalkDir(filepath.Join(dir, "slices"), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				add(path, info)
+			}
+			return nil
+		})
+	}
+	return state
+}
+
+// writePRNotes writes the summary of the changes to the slices of the
+// package checked against the release in dir.
+func (cmd *cmdCheckSlice) writePRNotes(dir string, pkg *setup.Package) error {
+	var base *setup.Package
+	if cmd.PRBase != "" {
+		baseRelease, err := obtainRelease(cmd.PRBase)
+		if err != nil {
+			return err
+		}
+		base = baseRelease.Packages[pkg.Name]
+	}
+
+	release, err := obtainRelease(dir)
+	if err != nil {
+		return err
+	}
+	arch, err := deb.InferArch()
+	if err != nil {
+		return err
+	}
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return err
+	}
+	pkgArchive, err := slicer.ResolveArchive(release, archives, pkg.Name)
+	if err != nil {
+		return err
+	}
+	var contents []slicer.PackagePath
+	if pkgArchive != nil {
+		reader, _, err := pkgArchive.Fetch(pkg.Name)
+		if err != nil {
+			return err
+		}
+		contents, err = slicer.ListPackage(reader, slicer.PathMapper(release))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read package %q: %w", pkg.Name, err)
+		}
+	} else {
+		logf("Package %q not found in archives, size impact not computed", pkg.Name)
+	}
+	writeSlicePRNotes(Stdout, base, pkg, arch, contents)
+	return nil
+}
+
+// findReleaseDir returns the closest parent directory of the file at path
+// holding a chisel.yaml file, or "" if there is none.
+func findReleaseDir(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "chisel.yaml")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
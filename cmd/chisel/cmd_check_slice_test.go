@@ -0,0 +1,177 @@
+package main_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestCheckSlice(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	// The release holding the file is found by default.
+	pkgPath := filepath.Join(releaseDir, "slices", "newpkg.yaml")
+	err := os.WriteFile(pkgPath, testutil.Reindent(`
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+				contents:
+					/usr/bin/new:
+	`), 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"check-slice", pkgPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+
+	// Files elsewhere are checked against the given release.
+	pkgPath = filepath.Join(c.MkDir(), "newpkg.yaml")
+	err = os.WriteFile(pkgPath, testutil.Reindent(`
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+				contents:
+					/usr/bin/app:
+	`), 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"check-slice", "--release", releaseDir, pkgPath})
+	c.Assert(err, ErrorMatches, `cannot check .*/newpkg.yaml: slices mypkg_bins and newpkg_bins conflict on /usr/bin/app`)
+}
+
+func (s *ChiselSuite) TestCheckSliceWatch(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	checked := make(chan error)
+	defer chisel.FakeWatch(10*time.Millisecond, func(err error) { checked <- err })()
+
+	pkgPath := filepath.Join(releaseDir, "slices", "newpkg.yaml")
+	err := os.WriteFile(pkgPath, testutil.Reindent(`
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+				contents:
+					/usr/bin/tool:
+	`), 0644)
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := chisel.Parser().ParseArgs([]string{"check-slice", "--watch", pkgPath})
+		done <- err
+	}()
+	nextCheck := func() error {
+		select {
+		case err := <-checked:
+			return err
+		case <-time.After(5 * time.Second):
+			c.Fatalf("files not checked")
+		}
+		return nil
+	}
+	c.Assert(nextCheck(), IsNil)
+
+	// Errors are reported and the files are checked again once fixed.
+	err = os.WriteFile(pkgPath, testutil.Reindent(`
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+				contents:
+					/usr/bin/app:
+	`), 0644)
+	c.Assert(err, IsNil)
+	c.Assert(nextCheck(), ErrorMatches, `cannot check .*/newpkg.yaml: slices mypkg_bins and newpkg_bins conflict on /usr/bin/app`)
+
+	// Changes to other files of the release are noticed too.
+	otherPath := filepath.Join(releaseDir, "slices", "mypkg.yaml")
+	data, err := os.ReadFile(otherPath)
+	c.Assert(err, IsNil)
+	data = bytes.ReplaceAll(data, []byte("/usr/bin/app:"), []byte("/usr/bin/other-app:"))
+	err = os.WriteFile(otherPath, data, 0644)
+	c.Assert(err, IsNil)
+	c.Assert(nextCheck(), IsNil)
+
+	p, err := os.FindProcess(os.Getpid())
+	c.Assert(err, IsNil)
+	c.Assert(p.Signal(os.Interrupt), IsNil)
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("watch did not stop")
+	}
+	c.Assert(s.Stdout(), Equals, "Slice definitions are valid.\nSlice definitions are valid.\n")
+	c.Assert(s.Stderr(), Matches, `error: cannot check .*/newpkg.yaml: slices mypkg_bins and newpkg_bins conflict on /usr/bin/app\n`)
+
+	// The release is reused from the cache while it does not change.
+	records, err := filepath.Glob(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "chisel", "parsed", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(records, Not(HasLen), 0)
+}
+
+func (s *ChiselSuite) TestCheckSlicePRNotes(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	pkgPath := filepath.Join(c.MkDir(), "mypkg.yaml")
+	err := os.WriteFile(pkgPath, testutil.Reindent(`
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+					/etc/app.conf:
+			config:
+				contents:
+					/etc/app.conf:
+			manifest:
+				contents:
+					/var/lib/chisel/**: {generate: manifest}
+			machine:
+				contents:
+					/etc/machine/**: {generate: x-machine-id}
+			all:
+				essential:
+					- mypkg_bins
+					- mypkg_config
+			tools:
+				contents:
+					/usr/bin/app:
+	`), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"check-slice", "--release", releaseDir, "--pr-base", releaseDir, "--emit-pr-notes", pkgPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"### Slices of `mypkg`\n\n"+
+		"| Slice | Change | Size impact |\n"+
+		"|-------|--------|-------------|\n"+
+		"| `mypkg_bins` | changed | +4 B |\n"+
+		"| `mypkg_old-bins` | removed | -3 B |\n"+
+		"| `mypkg_tools` | new | +3 B |\n\n"+
+		"New paths:\n\n"+
+		"- `/etc/app.conf` in `mypkg_bins`\n"+
+		"- `/usr/bin/app` in `mypkg_tools`\n\n"+
+		"Removed paths:\n\n"+
+		"- `/usr/bin/app` in `mypkg_old-bins`\n\n")
+
+	// Without a base, all the slices are new.
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"check-slice", "--release", releaseDir, "--emit-pr-notes", pkgPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Matches, "(?s).*\\| `mypkg_all` \\| new \\| 0 B \\|\n\\| `mypkg_bins` \\| new \\| \\+7 B \\|\n.*")
+}
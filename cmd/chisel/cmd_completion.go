@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortCompletionHelp = "Show the shell completion script"
+var longCompletionHelp = `
+The completion command shows the script which enables the completion of
+commands, options and slice names in the given shell, one of bash, zsh or
+fish. For example, in bash:
+
+  $ source <(chisel completion bash)
+
+Slice names are completed from the release given with --release, or the
+one for the same Ubuntu version as the current host, as long as it was
+fetched before by another command.
+`
+
+type cmdCompletion struct {
+	Positional struct {
+		Shell shellName `positional-arg-name:"<shell>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("completion", shortCompletionHelp, longCompletionHelp, func() flags.Commander { return &cmdCompletion{} }, nil, nil)
+}
+
+// The scripts run chisel with GO_FLAGS_COMPLETION set, so that it prints the
+// completions of the last argument instead of running the command.
+var completionScripts = map[shellName]string{
+	"bash": `_chisel() {
+	local IFS=$'\n'
+	COMPREPLY=($(GO_FLAGS_COMPLETION=1 "${COMP_WORDS[0]}" "${COMP_WORDS[@]:1:$COMP_CWORD}"))
+	return 0
+}
+complete -o default -F _chisel chisel
+`,
+	"zsh": `#compdef chisel
+_chisel() {
+	local -a completions
+	completions=("${(@f)$(GO_FLAGS_COMPLETION=1 "${words[1]}" "${(@)words[2,$CURRENT]}")}")
+	compadd -- "${completions[@]}"
+}
+compdef _chisel chisel
+`,
+	"fish": `function __chisel_complete
+	set -l args (commandline -opc)
+	env GO_FLAGS_COMPLETION=1 $args[1] $args[2..-1] (commandline -ct)
+end
+complete -c chisel -f -a '(__chisel_complete)'
+`,
+}
+
+func (cmd *cmdCompletion) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	script, ok := completionScripts[cmd.Positional.Shell]
+	if !ok {
+		return usageErrorf("unsupported shell %q, expected bash, zsh or fish", cmd.Positional.Shell)
+	}
+	fmt.Fprint(Stdout, script)
+	return nil
+}
+
+type shellName string
+
+func (s shellName) Complete(match string) []flags.Completion {
+	var items []flags.Completion
+	for name := range completionScripts {
+		if strings.HasPrefix(string(name), match) {
+			items = append(items, flags.Completion{Item: string(name)})
+		}
+	}
+	return items
+}
+
+// sliceName is the name of a slice, or of a group of slices, given as an
+// argument. It completes the names from the release in the command line.
+type sliceName string
+
+func (s sliceName) Complete(match string) []flags.Completion {
+	release, err := completionRelease(os.Args[1:])
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			names = append(names, slice.String())
+		}
+	}
+	for name := range release.Groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var items []flags.Completion
+	for _, name := range names {
+		if strings.HasPrefix(name, match) {
+			items = append(items, flags.Completion{Item: name})
+		}
+	}
+	return items
+}
+
+// completionRelease returns the release given with --release in args, or
+// the one for the host if none is given, as long as it was fetched before.
+func completionRelease(args []string) (*setup.Release, error) {
+	var releaseStr string
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--release" && i+1 < len(args) {
+			releaseStr = args[i+1]
+		} else if value, ok := strings.CutPrefix(arg, "--release="); ok {
+			releaseStr = value
+		}
+	}
+	dir, err := cachedReleaseDir(releaseStr)
+	if err != nil {
+		return nil, err
+	}
+	return setup.ReadRelease(dir)
+}
+
+func sliceNameStrings(names []sliceName) []string {
+	strs := make([]string, len(names))
+	for i, name := range names {
+		strs[i] = string(name)
+	}
+	return strs
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestCompletionScript(c *C) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		s.ResetStdStreams()
+		_, err := chisel.Parser().ParseArgs([]string{"completion", shell})
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Matches, `(?s).*GO_FLAGS_COMPLETION=1 .*`)
+	}
+
+	_, err := chisel.Parser().ParseArgs([]string{"completion", "csh"})
+	c.Assert(err, ErrorMatches, `unsupported shell "csh", expected bash, zsh or fish`)
+}
+
+// complete returns the completions of the last of args, as the shell
+// would obtain them from the completion script.
+func (s *ChiselSuite) complete(c *C, args ...string) []string {
+	oldArgs := os.Args
+	os.Args = append([]string{"chisel"}, args...)
+	defer func() { os.Args = oldArgs }()
+	os.Setenv("GO_FLAGS_COMPLETION", "1")
+	defer os.Unsetenv("GO_FLAGS_COMPLETION")
+
+	s.ResetStdStreams()
+	_, err := chisel.Parser().ParseArgs(args)
+	c.Assert(err, IsNil)
+	return strings.Fields(s.Stdout())
+}
+
+func (s *ChiselSuite) TestCompleteSliceNames(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	c.Assert(s.complete(c, "cut", "--release", releaseDir, "mypkg_c"), DeepEquals, []string{"mypkg_config"})
+	c.Assert(s.complete(c, "cut", "--release="+releaseDir, "--root", "/tmp", "app-"), DeepEquals, []string{"app-common"})
+	c.Assert(s.complete(c, "info", "--release", releaseDir, "mypkg_b"), DeepEquals, []string{"mypkg_bins"})
+	c.Assert(s.complete(c, "compl"), DeepEquals, []string{"completion"})
+	c.Assert(s.complete(c, "completion", "f"), DeepEquals, []string{"fish"})
+
+	// Releases given by name are only read from the cache.
+	cacheDir := c.MkDir()
+	oldCacheHome := os.Getenv("XDG_CACHE_HOME")
+	s.AddCleanup(func() { os.Setenv("XDG_CACHE_HOME", oldCacheHome) })
+	os.Setenv("XDG_CACHE_HOME", cacheDir)
+	c.Assert(s.complete(c, "cut", "--release", "ubuntu-22.04", "mypkg_c"), HasLen, 0)
+
+	for path, data := range cutRelease {
+		fpath := filepath.Join(cacheDir, "chisel", "releases", "ubuntu-22.04", path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(s.complete(c, "cut", "--release", "ubuntu-22.04", "mypkg_c"), DeepEquals, []string{"mypkg_config"})
+}
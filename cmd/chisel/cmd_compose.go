@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/oci"
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortComposeHelp = "Build a container image from a Chiselfile"
+var longComposeHelp = `
+The compose command builds a container image from the slices, host files
+and image settings declared in a Chiselfile, for images holding little
+more than a single application and the slices it needs. For example:
+
+  release: ubuntu-24.04
+  slices: [base-files_base, ca-certificates_data, libc6_libs]
+  files:
+    /usr/bin/app: {source: ./build/app, mode: 0755}
+  env:
+    APP_HOME: /var/lib/app
+  entrypoint: [/usr/bin/app]
+  user: "1000:1000"
+  labels:
+    org.opencontainers.image.title: app
+  image: app.tar
+
+The release, architecture, conditions to ignore, slices to exclude,
+archive pins and output options are the ones of the selection files of the
+cut command, except for the root and tar outputs. The "files" entries copy
+files from the host, relative to the directory of the Chiselfile, into the
+tree as the --copy option of the cut command does, with mode 0644 unless
+given. The image is configured with the "env", "entrypoint", "cmd",
+"user", "workdir" and "labels" entries, and named with "name", as in
+"app:1.0", if set.
+
+The image is written to the file given with --output, or otherwise in
+"image", as an archive in the OCI image layout with a single layer, which
+may be loaded with "podman load" or copied with skopeo from
+"oci-archive:<file>". Owners and labels of the paths are applied to the
+layer. The creation time of the image is taken from SOURCE_DATE_EPOCH when
+set.
+
+The Chiselfile is read from the current directory unless the --file
+option is used. The --release and --arch options take precedence over the
+ones in the file.
+`
+
+var composeDescs = map[string]string{
+	"file":    "Chiselfile declaring the image (default: Chiselfile)",
+	"output":  "Write the image to the file",
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+type cmdCompose struct {
+	File    string `long:"file" value-name:"<file>"`
+	Output  string `long:"output" value-name:"<file>"`
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+}
+
+func init() {
+	addCommand("compose", shortComposeHelp, longComposeHelp, func() flags.Commander { return &cmdCompose{} }, composeDescs, nil)
+}
+
+// composeFile holds the content of a Chiselfile, which declares a selection
+// of slices along with the host files and settings of the image built with
+// them.
+type composeFile struct {
+	selectionFile `yaml:",inline"`
+
+	Files      map[string]composeHostFile `yaml:"files,omitempty"`
+	Env        map[string]string          `yaml:"env,omitempty"`
+	Entrypoint []string                   `yaml:"entrypoint,omitempty"`
+	Cmd        []string                   `yaml:"cmd,omitempty"`
+	User       string                     `yaml:"user,omitempty"`
+	WorkDir    string                     `yaml:"workdir,omitempty"`
+	Labels     map[string]string          `yaml:"labels,omitempty"`
+	Name       string                     `yaml:"name,omitempty"`
+	Image      string                     `yaml:"image,omitempty"`
+}
+
+type composeHostFile struct {
+	Source string      `yaml:"source"`
+	Mode   composeMode `yaml:"mode,omitempty"`
+}
+
+// composeMode is the mode of a host file, written in octal as in 0755.
+type composeMode uint
+
+func (m *composeMode) UnmarshalYAML(node *yaml.Node) error {
+	var mode uint
+	_, err := fmt.Sscanf(node.Value, "%o", &mode)
+	if err != nil || node.Kind != yaml.ScalarNode || mode > 0777 {
+		return fmt.Errorf("invalid mode: %q", node.Value)
+	}
+	*m = composeMode(mode)
+	return nil
+}
+
+// readCompose reads and validates the Chiselfile at filePath.
+func readCompose(filePath string) (*composeFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Chiselfile: %w", err)
+	}
+	file, err := parseCompose(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse Chiselfile %s: %w", filePath, err)
+	}
+	return file, nil
+}
+
+func parseCompose(data []byte) (*composeFile, error) {
+	file := &composeFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	err = file.validate()
+	if err != nil {
+		return nil, err
+	}
+	if file.Output.Root != "" || file.Output.Tar != "" {
+		return nil, fmt.Errorf("cannot use root or tar outputs")
+	}
+	for filePath, hostFile := range file.Files {
+		if !isHostFilePath(filePath) {
+			return nil, fmt.Errorf("invalid file path: %q", filePath)
+		}
+		if hostFile.Source == "" {
+			return nil, fmt.Errorf("file %s has no source", filePath)
+		}
+	}
+	for name := range file.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return nil, fmt.Errorf("invalid environment variable name: %q", name)
+		}
+	}
+	return file, nil
+}
+
+func (cmd *cmdCompose) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	filePath := cmd.File
+	if filePath == "" {
+		filePath = "Chiselfile"
+	}
+	file, err := readCompose(filePath)
+	if err != nil {
+		return err
+	}
+	outputPath := cmd.Output
+	if outputPath == "" && file.Image != "" {
+		outputPath = filepath.Join(filepath.Dir(filePath), file.Image)
+	}
+	if outputPath == "" {
+		return usageErrorf("the required flag `--output' was not specified")
+	}
+
+	workDir, err := os.MkdirTemp("", "chisel-compose-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	rootDir := filepath.Join(workDir, "root")
+	dbPath := filepath.Join(workDir, "ownership")
+
+	baseDir := filepath.Dir(filePath)
+	var hostFiles []slicer.HostFile
+	for _, targetPath := range slices.Sorted(maps.Keys(file.Files)) {
+		hostFile := file.Files[targetPath]
+		sourcePath := hostFile.Source
+		if !filepath.IsAbs(sourcePath) {
+			sourcePath = filepath.Join(baseDir, sourcePath)
+		}
+		hostFiles = append(hostFiles, slicer.HostFile{
+			Source: sourcePath,
+			Path:   targetPath,
+			Mode:   fs.FileMode(hostFile.Mode),
+		})
+	}
+
+	cut := &cmdCut{
+		Release:     cmd.Release,
+		Arch:        cmd.Arch,
+		RootDir:     rootDir,
+		OwnershipDB: dbPath,
+		hostFiles:   hostFiles,
+	}
+	cut.applySelection(&file.selectionFile)
+	err = cut.Execute(nil)
+	if err != nil {
+		return err
+	}
+
+	db, err := ownership.Read(dbPath)
+	if err != nil {
+		return err
+	}
+	config, err := file.imageConfig(cut.Arch)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("cannot write image: %w", err)
+	}
+	err = oci.Write(output, rootDir, db, config)
+	if err == nil {
+		err = output.Close()
+	} else {
+		output.Close()
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("cannot write image %s: %w", outputPath, err)
+	}
+	logf("Image written to %s", outputPath)
+	return nil
+}
+
+// imageConfig returns the configuration of the image declared in the file
+// for the package architecture arch, or the one of the host if empty.
+func (file *composeFile) imageConfig(arch string) (*oci.Config, error) {
+	var err error
+	if arch == "" {
+		arch, err = deb.InferArch()
+		if err != nil {
+			return nil, err
+		}
+	}
+	goArch, err := deb.GoArch(arch)
+	if err != nil {
+		return nil, err
+	}
+	config := &oci.Config{
+		OS:         "linux",
+		Arch:       goArch,
+		Entrypoint: file.Entrypoint,
+		Cmd:        file.Cmd,
+		User:       file.User,
+		WorkingDir: file.WorkDir,
+		Labels:     file.Labels,
+		Created:    reportTime(),
+		RefName:    file.Name,
+	}
+	if goArch == "arm" {
+		config.Variant = "v7"
+	}
+	for _, name := range slices.Sorted(maps.Keys(file.Env)) {
+		config.Env = append(config.Env, name+"="+file.Env[name])
+	}
+	return config, nil
+}
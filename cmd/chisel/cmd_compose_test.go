@@ -0,0 +1,176 @@
+package main_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+// readImage returns the configuration of the image in the OCI archive at
+// imagePath, along with the entries of its only layer.
+func readImage(c *C, imagePath string) (config map[string]any, entries map[string]string) {
+	data, err := os.ReadFile(imagePath)
+	c.Assert(err, IsNil)
+	blobs := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		content, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		blobs[strings.Replace(header.Name, "blobs/sha256/", "sha256:", 1)] = content
+	}
+	var index struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	c.Assert(json.Unmarshal(blobs["index.json"], &index), IsNil)
+	c.Assert(index.Manifests, HasLen, 1)
+	var imageManifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	c.Assert(json.Unmarshal(blobs[index.Manifests[0].Digest], &imageManifest), IsNil)
+	c.Assert(json.Unmarshal(blobs[imageManifest.Config.Digest], &config), IsNil)
+
+	c.Assert(imageManifest.Layers, HasLen, 1)
+	gzipReader, err := gzip.NewReader(bytes.NewReader(blobs[imageManifest.Layers[0].Digest]))
+	c.Assert(err, IsNil)
+	entries = make(map[string]string)
+	tr = tar.NewReader(gzipReader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		content, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		entries[header.Name] = fmt.Sprintf("%#o %s", header.Mode, content)
+	}
+	return config, entries
+}
+
+func (s *ChiselSuite) TestCompose(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	composeDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(composeDir, "hello"), []byte("hello"), 0600)
+	c.Assert(err, IsNil)
+	chiselfile := strings.ReplaceAll(string(testutil.Reindent(`
+		release: <release>
+		arch: armhf
+		slices: [mypkg_bins]
+		files:
+			/usr/bin/hello: {source: hello, mode: 0755}
+			/etc/hello.conf: {source: hello}
+		env:
+			B: "2"
+			A: "1"
+		entrypoint: [/usr/bin/app]
+		cmd: [--help]
+		user: "1000"
+		workdir: /
+		labels:
+			org.opencontainers.image.title: app
+		image: app.tar
+	`)), "<release>", releaseDir)
+	err = os.WriteFile(filepath.Join(composeDir, "Chiselfile"), []byte(chiselfile), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"compose", "--file", filepath.Join(composeDir, "Chiselfile")})
+	c.Assert(err, IsNil)
+
+	config, entries := readImage(c, filepath.Join(composeDir, "app.tar"))
+	c.Assert(entries, DeepEquals, map[string]string{
+		"./etc/":           "0755 ",
+		"./etc/hello.conf": "0644 hello",
+		"./usr/":           "0755 ",
+		"./usr/bin/":       "0755 ",
+		"./usr/bin/app":    "0755 app",
+		"./usr/bin/hello":  "0755 hello",
+	})
+	c.Assert(config["architecture"], Equals, "arm")
+	c.Assert(config["variant"], Equals, "v7")
+	c.Assert(config["config"], DeepEquals, map[string]any{
+		"User":       "1000",
+		"Env":        []any{"A=1", "B=2"},
+		"Entrypoint": []any{"/usr/bin/app"},
+		"Cmd":        []any{"--help"},
+		"WorkingDir": "/",
+		"Labels":     map[string]any{"org.opencontainers.image.title": "app"},
+	})
+
+	// The output option takes precedence over the image in the file.
+	imagePath := filepath.Join(c.MkDir(), "other.tar")
+	_, err = chisel.Parser().ParseArgs([]string{"compose", "--file", filepath.Join(composeDir, "Chiselfile"),
+		"--output", imagePath})
+	c.Assert(err, IsNil)
+	_, err = os.Stat(imagePath)
+	c.Assert(err, IsNil)
+}
+
+var composeErrorTests = []struct {
+	summary    string
+	chiselfile string
+	err        string
+}{{
+	summary:    "Root outputs are not supported",
+	chiselfile: "slices: [mypkg_bins]\noutput: {root: rootfs}\nimage: app.tar\n",
+	err:        `cannot parse Chiselfile .*: cannot use root or tar outputs`,
+}, {
+	summary:    "File paths must be absolute",
+	chiselfile: "slices: [mypkg_bins]\nfiles: {usr/bin/hello: {source: hello}}\nimage: app.tar\n",
+	err:        `cannot parse Chiselfile .*: invalid file path: "usr/bin/hello"`,
+}, {
+	summary:    "Files need a source",
+	chiselfile: "slices: [mypkg_bins]\nfiles: {/usr/bin/hello: {mode: 0755}}\nimage: app.tar\n",
+	err:        `cannot parse Chiselfile .*: file /usr/bin/hello has no source`,
+}, {
+	summary:    "Invalid file mode",
+	chiselfile: "slices: [mypkg_bins]\nfiles: {/usr/bin/hello: {source: hello, mode: 04755}}\nimage: app.tar\n",
+	err:        `cannot parse Chiselfile .*: invalid mode: "04755"`,
+}, {
+	summary:    "Invalid environment variable",
+	chiselfile: "slices: [mypkg_bins]\nenv: {\"A=B\": x}\nimage: app.tar\n",
+	err:        `cannot parse Chiselfile .*: invalid environment variable name: "A=B"`,
+}, {
+	summary:    "Unknown fields",
+	chiselfile: "slices: [mypkg_bins]\nfoo: bar\n",
+	err:        `cannot parse Chiselfile .*: yaml: unmarshal errors:\n.*field foo not found.*`,
+}, {
+	summary:    "No output",
+	chiselfile: "slices: [mypkg_bins]\n",
+	err:        "the required flag `--output' was not specified",
+}}
+
+func (s *ChiselSuite) TestComposeErrors(c *C) {
+	for _, test := range composeErrorTests {
+		c.Logf("Summary: %s", test.summary)
+		filePath := filepath.Join(c.MkDir(), "Chiselfile")
+		err := os.WriteFile(filePath, []byte(test.chiselfile), 0644)
+		c.Assert(err, IsNil)
+		_, err = chisel.Parser().ParseArgs([]string{"compose", "--file", filePath})
+		c.Assert(err, ErrorMatches, test.err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortCoverageHelp = "Compare slices with the contents of their packages"
+var longCoverageHelp = `
+The coverage command compares the complete contents of each package given
+with the slices defined for it in the release, to keep slice definitions
+in sync with package updates. It reports the files and symlinks of the
+package which are not extracted by any slice as "uncovered", and the paths
+of slices which are not found in the package as "missing".
+
+Directories are not reported, as they are created when extracting their
+contents. Paths of slices restricted to other architectures are ignored.
+
+The command fails if any path is missing, as cutting the slices would fail
+as well.
+
+With the --emit-pr-notes option, the results are written as a Markdown
+summary for inclusion in pull requests to release repositories, with the
+size of the content extracted by each slice.
+
+By default the release is the one for the same Ubuntu version as the
+current host, unless the --release flag is used. The architecture defaults
+to the one of the host.
+`
+
+var coverageDescs = map[string]string{
+	"release":       "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":          "Package architecture",
+	"emit-pr-notes": "Write a Markdown summary for pull requests",
+}
+
+var coverageArgDescs = []argDesc{{
+	name: "<package>",
+	desc: "Package to compare with its slices",
+}}
+
+type cmdCoverage struct {
+	Release     string `long:"release" value-name:"<branch|dir>"`
+	Arch        string `long:"arch" value-name:"<arch>"`
+	EmitPRNotes bool   `long:"emit-pr-notes"`
+
+	Positional struct {
+		Packages []string `positional-arg-name:"<package>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("coverage", shortCoverageHelp, longCoverageHelp, func() flags.Commander { return &cmdCoverage{} }, coverageDescs, coverageArgDescs)
+}
+
+// packageCoverage is how the slices of a package cover its contents.
+type packageCoverage struct {
+	// Files is the number of files and symlinks in the package.
+	Files     int
+	Uncovered []string
+	// Missing maps the paths of slices not found in the package to the
+	// slices listing them.
+	Missing map[string][]string
+	// Size is the size of the regular files in the package, and
+	// CoveredSize the size of the ones extracted by some slice.
+	Size        int64
+	CoveredSize int64
+}
+
+func (cmd *cmdCoverage) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+	arch := cmd.Arch
+	if arch == "" {
+		arch, err = deb.InferArch()
+		if err != nil {
+			return err
+		}
+	}
+	for _, pkgName := range cmd.Positional.Packages {
+		if _, ok := release.Packages[pkgName]; !ok {
+			return fmt.Errorf("slices of package %q not found", pkgName)
+		}
+	}
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	found := false
+	missing := false
+	for _, pkgName := range cmd.Positional.Packages {
+		pkgArchive, err := slicer.ResolveArchive(release, archives, pkgName)
+		if err != nil {
+			return err
+		}
+		if pkgArchive == nil {
+			return fmt.Errorf("cannot find package %q in archive(s)", pkgName)
+		}
+		reader, _, err := pkgArchive.Fetch(pkgName)
+		if err != nil {
+			return err
+		}
+		contents, err := slicer.ListPackage(reader, slicer.PathMapper(release))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read package %q: %w", pkgName, err)
+		}
+		pkg := release.Packages[pkgName]
+		coverage := coverageOf(pkg, arch, contents)
+		if len(coverage.Missing) > 0 {
+			missing = true
+		}
+
+		if cmd.EmitPRNotes {
+			writeCoveragePRNotes(Stdout, pkg, arch, coverage, contents)
+			continue
+		}
+		if !found && (len(coverage.Uncovered) > 0 || len(coverage.Missing) > 0) {
+			fmt.Fprintf(w, "Package\tPath\tIssue\tSlices\n")
+			found = true
+		}
+		for _, path := range coverage.Uncovered {
+			fmt.Fprintf(w, "%s\t%s\tuncovered\t-\n", pkgName, path)
+		}
+		for _, path := range slices.Sorted(maps.Keys(coverage.Missing)) {
+			fmt.Fprintf(w, "%s\t%s\tmissing\t%s\n", pkgName, path, strings.Join(coverage.Missing[path], ","))
+		}
+		fmt.Fprintf(Stderr, "Package %s: %d of %d files covered\n", pkgName, coverage.Files-len(coverage.Uncovered), coverage.Files)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if missing {
+		return errors.New("slices list paths not found in their packages")
+	}
+	return nil
+}
+
+// coverageOf returns how the paths extracted by the slices of the package
+// for the architecture cover its contents, as listed by slicer.ListPackage.
+func coverageOf(pkg *setup.Package, arch string, contents []slicer.PackagePath) *packageCoverage {
+	coverage := &packageCoverage{Missing: map[string][]string{}}
+	covered := make(map[string]bool)
+	for _, sliceName := range slices.Sorted(maps.Keys(pkg.Slices)) {
+		slice := pkg.Slices[sliceName]
+		resolved, missing := slicer.ResolveSlice(slice, arch, contents)
+		for _, path := range resolved {
+			covered[path.Path] = true
+		}
+		for _, path := range slices.Compact(missing) {
+			coverage.Missing[path] = append(coverage.Missing[path], slice.String())
+		}
+	}
+	for _, entry := range contents {
+		if strings.HasSuffix(entry.Path, "/") {
+			continue
+		}
+		coverage.Files++
+		coverage.Size += entry.Size
+		if covered[entry.Path] {
+			coverage.CoveredSize += entry.Size
+		} else {
+			coverage.Uncovered = append(coverage.Uncovered, entry.Path)
+		}
+	}
+	slices.Sort(coverage.Uncovered)
+	for _, sliceNames := range coverage.Missing {
+		slices.Sort(sliceNames)
+	}
+	return coverage
+}
+
+// sliceSize returns the size of the regular files of the package contents
+// extracted by the slice for the architecture.
+func sliceSize(slice *setup.Slice, arch string, contents []slicer.PackagePath) int64 {
+	resolved, _ := slicer.ResolveSlice(slice, arch, contents)
+	var size int64
+	for _, path := range resolved {
+		size += path.Size
+	}
+	return size
+}
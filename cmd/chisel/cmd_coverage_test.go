@@ -0,0 +1,117 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestCoverage(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"coverage", "--release", releaseDir, "--arch", "amd64", "mypkg"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "Package mypkg: 2 of 2 files covered\n")
+}
+
+func (s *ChiselSuite) TestCoverageIssues(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	err := os.WriteFile(filepath.Join(releaseDir, "slices/mypkg.yaml"), testutil.Reindent(`
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+					/usr/bin/gone:
+					/usr/lib/other/**: {arch: arm64}
+			config:
+				contents:
+					/etc/app.conf:
+					/etc/app.d/*.conf:
+					/etc/default: {text: "generated"}
+			docs:
+				contents:
+					/usr/bin/gone:
+	`), 0644)
+	c.Assert(err, IsNil)
+	testArchive.Packages["mypkg"].Data = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/app.conf", "conf"),
+		testutil.Dir(0755, "./etc/app.d/"),
+		testutil.Reg(0644, "./etc/app.d/main.conf", "conf"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+		testutil.Lnk(0777, "./usr/bin/app-link", "app"),
+		testutil.Dir(0755, "./usr/share/"),
+		testutil.Dir(0755, "./usr/share/doc/"),
+		testutil.Reg(0644, "./usr/share/doc/README", "readme"),
+	})
+
+	_, err = chisel.Parser().ParseArgs([]string{"coverage", "--release", releaseDir, "--arch", "amd64", "mypkg"})
+	c.Assert(err, ErrorMatches, "slices list paths not found in their packages")
+	c.Assert(strings.TrimSpace(s.Stdout()), Equals, strings.TrimSpace(`
+Package  Path                   Issue      Slices
+mypkg    /usr/bin/app-link      uncovered  -
+mypkg    /usr/share/doc/README  uncovered  -
+mypkg    /usr/bin/gone          missing    mypkg_bins,mypkg_docs
+`))
+	c.Assert(s.Stderr(), Equals, "Package mypkg: 3 of 5 files covered\n")
+}
+
+func (s *ChiselSuite) TestCoverageUsrMerge(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	chiselYaml := strings.Replace(testutil.DefaultChiselYaml, "archives:", "usrmerge: true\n\tarchives:", 1)
+	err := os.WriteFile(filepath.Join(releaseDir, "chisel.yaml"), testutil.Reindent(chiselYaml), 0644)
+	c.Assert(err, IsNil)
+	testArchive.Packages["mypkg"].Data = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/app.conf", "conf"),
+		testutil.Dir(0755, "./bin/"),
+		testutil.Reg(0755, "./bin/app", "app"),
+	})
+
+	_, err = chisel.Parser().ParseArgs([]string{"coverage", "--release", releaseDir, "--arch", "amd64", "mypkg"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "Package mypkg: 2 of 2 files covered\n")
+}
+
+func (s *ChiselSuite) TestCoverageErrors(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"coverage", "--release", releaseDir, "--arch", "amd64", "otherpkg"})
+	c.Assert(err, ErrorMatches, `slices of package "otherpkg" not found`)
+}
+
+func (s *ChiselSuite) TestCoveragePRNotes(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"coverage", "--release", releaseDir, "--arch", "amd64", "--emit-pr-notes", "mypkg"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"### Coverage of `mypkg`\n\n"+
+		"2 of 2 files covered, 7 B of 7 B.\n\n"+
+		"| Slice | Size |\n"+
+		"|-------|------|\n"+
+		"| `mypkg_all` | 0 B |\n"+
+		"| `mypkg_bins` | 3 B |\n"+
+		"| `mypkg_config` | 4 B |\n"+
+		"| `mypkg_machine` | 0 B |\n"+
+		"| `mypkg_manifest` | 0 B |\n"+
+		"| `mypkg_old-bins` | 3 B |\n\n")
+	c.Assert(s.Stderr(), Equals, "")
+}
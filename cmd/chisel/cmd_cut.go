@@ -1,16 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jessevdk/go-flags"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/lockfile"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/metrics"
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/policy"
+	"github.com/canonical/chisel/internal/remote"
+	"github.com/canonical/chisel/internal/security"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/tarutil"
+	"github.com/canonical/chisel/internal/tracing"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 var shortCutHelp = "Cut a tree with selected slices"
@@ -20,28 +47,439 @@ to create a new filesystem tree in the root location.
 
 By default it fetches the slices for the same Ubuntu version as the
 current host, unless the --release flag is used.
+
+Releases fetched from the release repository are cached along with the
+commit fetched and a digest of their content, and the cut fails if content
+differs from the one fetched before for the same commit. The release may be
+pinned to a commit or tag with --release <label>-<version>@<ref>, such as
+ubuntu-24.04@<sha1>, in which case it is only fetched once, the commit is
+verified, and the cached content is checked against the recorded digest on
+every cut. The --refresh-release option fetches the release again and
+accepts changed content.
+
+The suites and components of the archives in the release may be changed
+for a single cut with the --add-suite, --drop-suite, --add-component and
+--drop-component options, which may be repeated. Their values apply to all
+the archives, or only to the one named before a colon, as in
+"ubuntu:universe". Suites starting with a dash refer to that pocket of the
+release, so that --add-suite=-proposed adds jammy-proposed to archives with
+the jammy suites. The suites and components used are recorded in the
+manifests and in the lockfile.
+
+Releases embedded into the chisel binary when it was built are used with
+--release embedded:<name>, without fetching anything.
+
+The archive indexes are cached locally and revalidated with the archive on
+every run, so they are only downloaded again when they change. Changed
+indexes are obtained by patching the cached ones when the archive publishes
+the patches, or by hash when supported, as apt does. The --refresh option
+downloads them again regardless.
+
+The requests made to the archives use the timeouts, headers and user agent
+configured for each archive in the release. The --http-timeout option sets
+the timeout for small files such as InRelease, --http-bulk-timeout the one
+for indexes and packages, --http-header adds a header in the format
+<name>:<value> and may be repeated, and --user-agent sets the User-Agent.
+Packages of at least the size given with --http-chunk-threshold, such as
+"64MiB", are fetched with concurrent ranged requests of --http-chunk-size
+each, 8MiB by default, when the archive supports them. These take
+precedence over the configuration of all the archives.
+
+Locally built packages may be sliced alongside the archive packages with
+the --install-deb option, which takes the path to a .deb file optionally
+followed by a colon and a comma-separated list of its slices to select
+(e.g. ./mypkg_1.0_amd64.deb:bins,config). Slice definitions for the
+package must exist in the release, and the local package is always used
+instead of any package with the same name in the archives.
+
+Packages declaring a "source" in their slice definitions are obtained
+from that location instead of the archives, and verified against the
+declared digest.
+
+The --dpkg-status option writes a minimal dpkg status database at
+/var/lib/dpkg/status and /var/lib/dpkg/status.d/, listing the packages
+with content in the cut root, for compatibility with tools which do not
+understand the Chisel manifest.
+
+The --locales and --timezones options take comma-separated lists, such as
+"en_US,pt_BR" or "UTC,Europe/Lisbon", and restrict the locale and timezone
+data extracted to the listed entries. Timezones which are symbolic links
+keep the data they point to. Trimmed content is not extracted and so is not
+part of the manifest, which records the lists kept.
+
+The --compile-python option byte-compiles the Python sources in the cut
+root with the given interpreter, as the package maintainer scripts would
+otherwise do. The interpreter must match the Python version in the root.
+Compiled files use hash-based invalidation so that the output is
+reproducible.
+
+The --ldconfig option writes the dynamic linker cache at /etc/ld.so.cache
+for the shared libraries in the cut root, as running ldconfig inside it
+would, except for the creation of missing soname links.
+
+The --sysusers and --tmpfiles options create the users, groups, directories,
+files and symlinks declared by packages in sysusers.d and tmpfiles.d, as
+systemd would do at boot, for images which do not run systemd. Ownership of
+the paths created is not applied.
+
+The root may be located on a remote machine reachable over SSH, using the
+format ssh://[<user>@]<host>[:<port>]/<dir>. The tree is then cut into a
+temporary directory and streamed to tar running on the remote machine,
+which must be available there. The ssh client configuration and
+authentication agent are used as usual.
+
+With the --output option the tree is written as a tar archive to the given
+file instead, with the owners and labels of the paths applied, so it may be
+imported as a container image layer. As no root location is needed, this
+works on hosts other than Linux too, such as macOS and Windows.
+
+The slices to cut may also be listed in a selection file with the
+--selection option, along with the release, architecture, conditions to
+ignore, slices to exclude, archives pinned for particular packages and
+output options, so that the definition of an image may be reviewed and
+reused. Options given on the command line take precedence over the ones in
+the file, and slices given as arguments are added to the ones listed. For
+example:
+
+  release: ubuntu-24.04
+  arch: amd64
+  slices: [base-files_base, ca-certificates_data]
+  pins:
+    ca-certificates: ubuntu
+  output:
+    root: rootfs
+    dpkg-status: true
+    locales: [C, en_GB]
+
+The inputs used to cut the tree may be recorded in a lockfile with the
+--lockfile option: a digest of the release definition and the commit it
+was obtained from when known, the slices selected with their essentials,
+the archives used, and the version, SHA256, suite and component of every
+package fetched. With the --locked option, the lockfile is read instead,
+chisel.lock by default, and the cut fails if any of those inputs differ or
+if packages are recorded which the selection no longer uses. Packages are
+checked before they are fetched.
+
+The --copyright option extracts the copyright file of every package with
+content, even when not listed in the slices selected, and records the
+licenses declared in it in the manifest. Only copyright files in the
+machine-readable format are understood. With --exclude-copyright-files
+the licenses are recorded but the files are not extracted.
+
+The --changelogs option records in the manifest the location of the
+changelog of the version of every package with content, as published in
+changelogs.ubuntu.com, so that the changes pulled in by a refresh of the
+tree may be reviewed. Packages without published changelogs, such as the
+ones from Pro archives, are recorded without one.
+
+Chisel does not apply to the cut tree the owners of the paths extracted
+from packages, so that it may run unprivileged. The --ownership-db option
+records them in the given file instead, with one "<uid> <gid> <path>" line
+for every path not owned by root, so that they may be applied when the tree
+is packed into an image. The SELinux labels carried by the packages or
+declared in the slices are recorded there too, as "<uid> <gid> <label> <path>"
+lines. Trees uploaded to a remote machine have the owners and labels
+applied there.
+
+Slices may be excluded from the selection with the --without option, which
+takes a slice name that may hold "*" and "?" wildcards, as in "*_docs",
+and may be repeated. Excluding a slice which is essential to another one
+in the selection fails, unless the --force option is used. Slices selected
+only as essentials of the excluded ones are left out as well. Patterns
+starting with "/" exclude paths instead, as in "/usr/share/doc/**", which
+are left out of the tree even when matched by the globs of the slices.
+
+Slice names given as arguments or in the selection file may hold "*", "?"
+and "[...]" wildcards to select all the matching slices in the release, as
+in "python3.12_*" or "libfoo*_libs". The slices matched by each pattern are
+listed as the cut starts. Deprecated slices are only selected by name.
+
+Groups of slices defined in the release, such as "runtime-common", may be
+selected by name along with the slices, selecting all the slices in them.
+
+Selecting a slice which is deprecated in the release prints a warning
+with its deprecation notice, or fails with the --strict option.
+
+The --timeout option cancels the cut if it is not done within the given
+duration, as in "10m" or "90s". A cut cancelled by the timeout or by an
+interrupt fails without writing the lockfile, the ownership database, the
+security report or the --output archive.
+
+When the --root directory does not exist or is empty, the tree is cut into
+a temporary directory next to it, which is moved into place once the cut
+is done, so that a failed or interrupted cut leaves nothing behind. Trees
+cut into a directory which already has content, a symlink or a mount point
+are cut in place, and are reported as incomplete when the cut is
+interrupted.
+
+Running the same cut again after an interruption resumes where it stopped:
+packages already downloaded are taken from the cache, and the download of
+the package in progress continues from the bytes already received, when
+the archive supports ranged requests. The tree cut so far is kept as
+well, in the cache when the --root directory does not exist or is empty,
+and the packages already extracted into it are not extracted again, once
+the content of their files is checked against the hashes recorded when
+they were extracted. Running a different cut into the same --root
+directory discards the kept tree.
+
+Concurrent cuts into the same --root directory run one at a time, and the
+cache is safely shared by concurrent processes.
+
+Paths in the slices may be marked with custom "generate" kinds, named with
+the "x-" prefix as in "generate: x-machine-id", for content which is not
+known to Chisel. The --generator option takes the kind and the command
+generating it, as in "x-machine-id=./machine-id.sh", and may be repeated.
+The command is run as "<command> <root> <dir> <output>" for every
+directory marked with the kind, once all the content is extracted, and
+writes the files to create in that directory to the empty output
+directory. Selecting a slice with a custom kind and no generator fails.
+
+Files from the host, such as an application built for the image, may be
+copied into the tree with the --copy option, which takes the path of the
+file, the absolute path to copy it to and optionally its octal mode, 0644
+by default, as in "./build/app:/usr/bin/app:0755", and may be repeated.
+Host files are copied once the mutation scripts ran, and are recorded in
+the manifests with their digest and the path they were copied from, which
+is not possible for files added to the tree after the cut. Paths already
+in the tree are never replaced.
+
+Symlinks extracted from packages may have absolute targets, or relative
+targets going above the root, which point outside of the tree when it is
+used from the host. The --symlink-policy option decides what happens to
+them, as in "absolute=rewrite" or "escaping=reject", and may be repeated
+for each kind of target. Such symlinks are allowed by default, "reject"
+fails the cut, and "rewrite" replaces the target with a relative one
+pointing to the same path within the tree. Rewritten symlinks record their
+original target in the manifests, and the policy is recorded there too.
+Content is never written through symlinks resolving outside of the root,
+whatever the policy, which protects the host when cutting packages from
+untrusted archives.
+
+The permissions of all the paths in the root may be normalized once the
+tree is cut, for images with hardened policies. The --umask option clears
+the permission bits set in the given octal mode, as in "022" to remove the
+write permission of the group and others, and the --strip-setuid option
+clears the setuid and setgid bits. Symlinks are left as they are, and the
+manifests record the final modes.
+
+Files extracted from packages with setuid or setgid bits are recorded in
+the security entry of the manifests, with their mode in the package and
+the slices listing them. The --setuid-policy option decides what happens
+to them: "allow" creates them as they are, which is the default, "warn"
+logs a warning for each of them, "strip" clears the bits, and "fail" fails
+the cut.
+
+Several packages often ship files with identical content, such as license
+or locale files. The --dedup option makes them share their storage to cut
+the size of the tree: "hardlink" replaces the duplicates with hard links to
+the first of them, which the manifests record as such, and "reflink" makes
+them copy-on-write clones, where the filesystem supports it, keeping them
+independent files. Only files with the same mode, owner and label are
+shared, and the mode is recorded in the manifests.
+
+With the --reflink option, packages are kept unpacked in the cache, with
+the content of their files stored once, and later cuts of the same
+packages skip decompressing them and clone the files into the root
+instead of copying them. Cloning requires a filesystem supporting it, such
+as Btrfs or XFS, holding both the cache and the root, and files are copied
+from the cache otherwise. Cuts extracting the same paths from a package
+again replay the previous extraction without reading the package at all,
+once the content in the cache is revalidated against its hash.
+
+Organizations may enforce their own rules on the inputs of the cut with
+the --policy option, which takes a command to run for each check: once
+as "<command> selection" for the slices selected, as "<command> package"
+for every package before it is fetched, and as "<command> licenses" for
+the licenses declared in the copyright file of every package fetched. The
+input of the check is written to its standard input in JSON format, and
+the command denies it by exiting with a non-zero status, which fails the
+cut with the output of the command as the reason.
+
+Manifests are written as jsonwall compressed with zstd by default. The
+--manifest-encoding option writes them compressed with gzip instead, as
+uncompressed jsonwall with "none", or as a single flat JSON document with
+"json", for tools which cannot handle the default encoding. Chisel reads
+manifests in any of these encodings.
+
+Manifests also record how the tree was cut: the release, along with its
+digest and git commit when it is a git checkout, the Chisel version, the
+architecture, the archives and mirrors used, and the command line.
+
+Packages found in more than one of the archives they may be fetched from
+are resolved with the archive strategy of the release. The --verbose option
+logs the archives considered and the one chosen for each package, along
+with other details of the cut.
+
+The --timings option shows once the cut is done the time spent in each of
+its phases, the bytes downloaded from the archives and how often content
+was found in the cache.
+
+When the OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+environment variables are set, the phases of the cut are traced and the
+spans exported there with the OpenTelemetry protocol over HTTP, with JSON
+encoding. The spans belong to the trace given in TRACEPARENT, if set, in
+the format of the W3C traceparent header.
+
+The Ubuntu Security Notices affecting the exact package versions cut may
+be written to a file in JSON format with the --security-report option.
+See the audit command for reporting on a tree cut earlier.
+
+The --list-conffiles option lists once the cut is done the paths in the
+tree which their packages declare as conffiles, with the slices extracting
+them, so that the configuration carried into the tree may be reviewed and
+mutated or excluded as needed. Conffiles are marked in the manifests too.
+
+The --dry-run option shows the packages which would be fetched to cut the
+selection, with their versions, the archives they would be fetched from
+and the slices selected, without fetching or writing anything. Adding the
+--estimate option shows too the installed size of each package, as
+declared in the archive indexes, and an estimate of the size its slices
+would take in the tree, so that combinations of slices may be compared
+before downloading packages. The estimate is exact for packages fetched
+before, which are in the cache, and is otherwise bounded by the installed
+size.
+
+The --report option writes the artifacts of the cut to a directory, with
+the same file names on every cut for CI systems to archive them uniformly:
+the manifest of the tree in flat JSON as "manifest.json", even when no
+slice generates one, SPDX and CycloneDX bills of materials as
+"sbom.spdx.json" and "sbom.cdx.json", the size of the content by package
+and slice as "sizes.json", the setuid and setgid files and, with
+--security-report, the known vulnerabilities as "security.json", and the
+lockfile as "chisel.lock". The creation time recorded in the bills of
+materials is taken from SOURCE_DATE_EPOCH when set.
 `
 
 var cutDescs = map[string]string{
-	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
-	"root":    "Root for generated content",
-	"arch":    "Package architecture",
-	"ignore":  "Conditions to ignore (e.g. unmaintained, unstable)",
+	"release":                 "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"root":                    "Root for generated content",
+	"output":                  "Write the tree as a tar archive to the file",
+	"arch":                    "Package architecture",
+	"ignore":                  "Conditions to ignore (e.g. unmaintained, unstable)",
+	"refresh":                 "Download the archive indexes again",
+	"refresh-release":         "Fetch the release again, accepting changed content",
+	"add-suite":               "Fetch from the [<archive>:]<suite> as well",
+	"drop-suite":              "Do not fetch from the [<archive>:]<suite>",
+	"add-component":           "Fetch from the [<archive>:]<component> as well",
+	"drop-component":          "Do not fetch from the [<archive>:]<component>",
+	"install-deb":             "Local .deb file to slice, optionally with :<slices>",
+	"dpkg-status":             "Write the dpkg status database for the cut packages",
+	"locales":                 "Comma-separated list of locales to keep",
+	"timezones":               "Comma-separated list of timezones to keep",
+	"compile-python":          "Python interpreter used to byte-compile sources",
+	"ldconfig":                "Write the dynamic linker cache for the cut libraries",
+	"sysusers":                "Create the users and groups declared in sysusers.d",
+	"tmpfiles":                "Create the paths declared in tmpfiles.d",
+	"selection":               "YAML or JSON file declaring the slices and options",
+	"lockfile":                "Write the inputs used to the lockfile",
+	"locked":                  "Fail if the inputs differ from the lockfile",
+	"security-report":         "Write the known vulnerabilities in JSON to the file",
+	"report":                  "Write the manifest, SBOMs and other reports to the directory",
+	"list-conffiles":          "List the conffiles of the packages in the tree",
+	"strict":                  "Fail if any selected slice is deprecated",
+	"without":                 "Exclude the slices or paths matching the pattern",
+	"force":                   "Exclude slices even if essential to others",
+	"policy":                  "Command deciding whether the inputs are allowed",
+	"generator":               "Command creating paths with a custom generate kind",
+	"copy":                    "Copy the host file into the tree at the path",
+	"symlink-policy":          "Allow, reject or rewrite the symlinks with the target",
+	"umask":                   "Clear the permission bits of all the paths in the mode",
+	"strip-setuid":            "Clear the setuid and setgid bits of all the paths",
+	"setuid-policy":           "Action on setuid and setgid files (allow, warn, strip, fail)",
+	"dedup":                   "Share the storage of identical files (none, hardlink, reflink)",
+	"reflink":                 "Keep packages unpacked in the cache and clone their files",
+	"copyright":               "Extract copyright files and record their licenses",
+	"exclude-copyright-files": "Record the licenses without the copyright files",
+	"changelogs":              "Record the changelog locations of the packages",
+	"ownership-db":            "Write the owners and labels of the paths to the file",
+	"timeout":                 "Cancel the cut if not done within the duration",
+	"manifest-encoding":       "Encoding of the manifests (zstd, gzip, none, json)",
+	"timings":                 "Show the time spent in each phase of the cut",
+	"verbose":                 "Log the decisions taken while cutting in detail",
+	"dry-run":                 "Show the packages to fetch without cutting",
+	"estimate":                "Estimate the size of the tree with --dry-run",
 }
 
 type cmdCut struct {
 	Release string   `long:"release" value-name:"<dir>"`
-	RootDir string   `long:"root" value-name:"<dir>" required:"yes"`
+	RootDir string   `long:"root" value-name:"<dir>"`
 	Arch    string   `long:"arch" value-name:"<arch>"`
 	Ignore  []string `long:"ignore" choice:"unmaintained" choice:"unstable" value-name:"<cond>"`
+	Debs    []string `long:"install-deb" value-name:"<file>[:<slices>]"`
+	Refresh bool     `long:"refresh"`
+	Without []string `long:"without" value-name:"<pattern>"`
+	Force   bool     `long:"force"`
+
+	RefreshRelease bool `long:"refresh-release"`
+
+	AddSuites      []string `long:"add-suite" value-name:"[<archive>:]<suite>"`
+	DropSuites     []string `long:"drop-suite" value-name:"[<archive>:]<suite>"`
+	AddComponents  []string `long:"add-component" value-name:"[<archive>:]<component>"`
+	DropComponents []string `long:"drop-component" value-name:"[<archive>:]<component>"`
+
+	Timeout time.Duration `long:"timeout" value-name:"<duration>"`
+
+	httpFlags
+
+	DpkgStatus bool   `long:"dpkg-status"`
+	LDConfig   bool   `long:"ldconfig"`
+	Sysusers   bool   `long:"sysusers"`
+	Tmpfiles   bool   `long:"tmpfiles"`
+	Locales    string `long:"locales" value-name:"<list>"`
+	Timezones  string `long:"timezones" value-name:"<list>"`
+
+	CompilePython string `long:"compile-python" value-name:"<interpreter>"`
+	Selection     string `long:"selection" value-name:"<file>"`
+	Lockfile      string `long:"lockfile" value-name:"<file>"`
+	Locked        bool   `long:"locked"`
+
+	SecurityReport string `long:"security-report" value-name:"<file>"`
+	Report         string `long:"report" value-name:"<dir>"`
+	ListConffiles  bool   `long:"list-conffiles"`
+	Strict         bool   `long:"strict"`
+	Policy         string `long:"policy" value-name:"<command>"`
+
+	Generators []string `long:"generator" value-name:"<kind>=<command>"`
+	Copies     []string `long:"copy" value-name:"<file>:<path>[:<mode>]"`
+
+	SymlinkPolicy []string `long:"symlink-policy" value-name:"<target>=<action>"`
+	Umask         string   `long:"umask" value-name:"<mode>"`
+	StripSetuid   bool     `long:"strip-setuid"`
+	SetuidPolicy  string   `long:"setuid-policy" choice:"allow" choice:"warn" choice:"strip" choice:"fail" value-name:"<action>"`
+	Dedup         string   `long:"dedup" choice:"none" choice:"hardlink" choice:"reflink" value-name:"<mode>"`
+	Reflink       bool     `long:"reflink"`
+
+	Copyright             bool `long:"copyright"`
+	ExcludeCopyrightFiles bool `long:"exclude-copyright-files"`
+	Changelogs            bool `long:"changelogs"`
+
+	OwnershipDB string `long:"ownership-db" value-name:"<file>"`
+	Output      string `long:"output" value-name:"<file>"`
+
+	ManifestEncoding string `long:"manifest-encoding" choice:"zstd" choice:"gzip" choice:"none" choice:"json" value-name:"<encoding>"`
+
+	Timings bool `long:"timings"`
+	Verbose bool `long:"verbose"`
+
+	DryRun   bool `long:"dry-run"`
+	Estimate bool `long:"estimate"`
 
 	Positional struct {
-		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+		SliceRefs []sliceName `positional-arg-name:"<slice names>"`
 	} `positional-args:"yes"`
+
+	// pins maps package names to the archive they must be fetched from,
+	// as set by the selection file.
+	pins map[string]string
+	// hostFiles are copied into the tree along with the ones given with
+	// the --copy option.
+	hostFiles []slicer.HostFile
+	// ctx optionally cancels the cut, as when run by the daemon.
+	ctx context.Context
 }
 
 func init() {
-	addCommand("cut", shortCutHelp, longCutHelp, func() flags.Commander { return &cmdCut{} }, cutDescs, nil)
+	addCommand("cut", shortCutHelp, longCutHelp, func() flags.Commander { return &cmdCut{} }, withHTTPDescs(cutDescs), nil)
 }
 
 func (cmd *cmdCut) Execute(args []string) error {
@@ -49,20 +487,206 @@ func (cmd *cmdCut) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
-	sliceKeys := make([]setup.SliceKey, len(cmd.Positional.SliceRefs))
-	for i, sliceRef := range cmd.Positional.SliceRefs {
+	ctx := cmd.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	exporter, err := tracing.ExporterFromEnv(chiselVersion())
+	if err != nil {
+		logf("Warning: Traces are not exported: %v", err)
+	}
+	var recorder *tracing.Recorder
+	if exporter != nil {
+		recorder = tracing.NewRecorder(os.Getenv("TRACEPARENT"))
+		ctx = tracing.WithRecorder(ctx, recorder)
+	}
+	ctx, span := tracing.Start(ctx, "cut")
+
+	start := metrics.Take()
+	metrics.Cuts.Add(1)
+	err = cmd.cut(ctx)
+	if err != nil {
+		metrics.FailedCuts.Add(1)
+	}
+	if cmd.Timings {
+		logTimings(metrics.Take().Since(start))
+	}
+
+	span.SetAttribute("chisel.release", cmd.Release)
+	span.SetAttribute("chisel.arch", cmd.Arch)
+	span.SetAttribute("chisel.slices", strings.Join(sliceNameStrings(cmd.Positional.SliceRefs), ","))
+	span.SetError(err)
+	span.Finish()
+	if recorder != nil {
+		exportErr := exporter.Export(context.Background(), recorder.Spans())
+		if exportErr != nil {
+			logf("Warning: %v", exportErr)
+		}
+	}
+	return err
+}
+
+func (cmd *cmdCut) cut(ctx context.Context) error {
+	if cmd.Selection != "" {
+		selFile, err := readSelection(cmd.Selection)
+		if err != nil {
+			return err
+		}
+		cmd.applySelection(selFile)
+	}
+	if cmd.Estimate && !cmd.DryRun {
+		return usageErrorf("cannot use --estimate without --dry-run")
+	}
+	if cmd.RootDir == "" && cmd.Output == "" && !cmd.DryRun {
+		return usageErrorf("the required flag `--root' was not specified")
+	}
+	if cmd.RootDir != "" && cmd.Output != "" {
+		return usageErrorf("cannot use --root and --output together")
+	}
+	httpOverride, err := cmd.httpFlags.options()
+	if err != nil {
+		return err
+	}
+	if cmd.Verbose {
+		slicer.SetDebug(true)
+		defer slicer.SetDebug(false)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	rootDir := cmd.RootDir
+	target, isRemote, err := remote.ParseTarget(cmd.RootDir)
+	if err != nil {
+		return err
+	}
+	if isRemote || cmd.Output != "" {
+		rootDir, err = os.MkdirTemp("", "chisel-root-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(rootDir)
+	} else if !cmd.DryRun {
+		unlock, err := lockRoot(ctx, cmd.RootDir)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cmd.cancelledError(ctx, false)
+			}
+			return fmt.Errorf("cannot lock %s: %w", cmd.RootDir, err)
+		}
+		defer unlock()
+	}
+
+	var sliceKeys []setup.SliceKey
+	var slicePatterns []string
+	var groupNames []string
+	for _, sliceRef := range sliceNameStrings(cmd.Positional.SliceRefs) {
+		if setup.IsSlicePattern(sliceRef) {
+			slicePatterns = append(slicePatterns, sliceRef)
+			continue
+		}
+		if setup.IsGroupName(sliceRef) {
+			groupNames = append(groupNames, sliceRef)
+			continue
+		}
 		sliceKey, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return &usageError{err}
+		}
+		sliceKeys = append(sliceKeys, sliceKey)
+	}
+
+	var debPaths []string
+	for _, debRef := range cmd.Debs {
+		debPath, sliceNames := parseDebRef(debRef)
+		debPaths = append(debPaths, debPath)
+		if len(sliceNames) == 0 {
+			continue
+		}
+		info, err := archive.ReadDebInfo(debPath)
 		if err != nil {
 			return err
 		}
-		sliceKeys[i] = sliceKey
+		for _, sliceName := range sliceNames {
+			sliceKey, err := setup.ParseSliceKey(info.Name + "_" + sliceName)
+			if err != nil {
+				return usageErrorf("invalid --install-deb %q: %w", debRef, err)
+			}
+			sliceKeys = append(sliceKeys, sliceKey)
+		}
+	}
+	if len(sliceKeys) == 0 && len(slicePatterns) == 0 && len(groupNames) == 0 {
+		return usageErrorf("no slices selected")
 	}
 
-	release, err := obtainRelease(cmd.Release)
+	generators, err := parseGenerators(cmd.Generators)
+	if err != nil {
+		return err
+	}
+	hostFiles := cmd.hostFiles
+	for _, ref := range cmd.Copies {
+		hostFile, err := parseHostFile(ref)
+		if err != nil {
+			return err
+		}
+		hostFiles = append(hostFiles, hostFile)
+	}
+	symlinkPolicy, err := parseSymlinkPolicy(cmd.SymlinkPolicy)
+	if err != nil {
+		return err
+	}
+	normalize, err := cmd.normalizeOptions()
 	if err != nil {
 		return err
 	}
 
+	_, resolveSpan := tracing.Start(ctx, "resolve")
+	defer resolveSpan.Finish()
+	stopPhase := metrics.Start(metrics.PhaseRelease)
+	release, err := obtainFreshRelease(cmd.Release, cmd.RefreshRelease)
+	stopPhase()
+	if err != nil {
+		var changedErr *setup.ChangedError
+		if errors.As(err, &changedErr) {
+			return fmt.Errorf("%w (see --refresh-release)", err)
+		}
+		return err
+	}
+	release, err = cmd.overrideArchives(release)
+	if err != nil {
+		return err
+	}
+	if len(cmd.pins) > 0 {
+		release, err = applyPins(release, cmd.pins)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range groupNames {
+		keys, ok := release.Groups[name]
+		if !ok {
+			return fmt.Errorf("group %q not found in release", name)
+		}
+		sliceKeys = append(sliceKeys, keys...)
+	}
+	for _, pattern := range slicePatterns {
+		keys, err := setup.MatchSlices(release, pattern)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.String()
+		}
+		logf("Slices matching %q: %s", pattern, strings.Join(names, ", "))
+		sliceKeys = append(sliceKeys, keys...)
+	}
+
 	if time.Now().Before(release.Maintenance.Standard) {
 		if slices.Contains(cmd.Ignore, "unstable") {
 			logf(`Warning: This release is in the "unstable" maintenance status. ` +
@@ -73,33 +697,74 @@ func (cmd *cmdCut) Execute(args []string) error {
 		}
 	}
 
+	stopPhase = metrics.Start(metrics.PhaseSelect)
 	selection, err := setup.Select(release, sliceKeys, cmd.Arch)
+	if err == nil && len(cmd.Without) > 0 {
+		err = selection.Exclude(cmd.Without, cmd.Arch, cmd.Force)
+	}
+	stopPhase()
 	if err != nil {
 		return err
 	}
+	if cmd.Strict {
+		for _, slice := range selection.Slices {
+			if slice.Deprecated != "" {
+				return fmt.Errorf("slice %s is deprecated: %s", slice, slice.Deprecated)
+			}
+		}
+	}
+	var cutPolicy policy.Policy
+	if cmd.Policy != "" {
+		cutPolicy = policy.Exec(cmd.Policy)
+	}
 
-	archives := make(map[string]archive.Archive)
-	for archiveName, archiveInfo := range release.Archives {
-		openArchive, err := archive.Open(&archive.Options{
-			Label:      archiveName,
-			Version:    archiveInfo.Version,
-			Arch:       cmd.Arch,
-			Suites:     archiveInfo.Suites,
-			Components: archiveInfo.Components,
-			Pro:        archiveInfo.Pro,
-			CacheDir:   cache.DefaultDir("chisel"),
-			PubKeys:    archiveInfo.PubKeys,
-			Maintained: archiveInfo.Maintained,
-			OldRelease: archiveInfo.OldRelease,
-		})
+	lockPath := cmd.Lockfile
+	if lockPath == "" && cmd.Locked {
+		lockPath = lockfile.DefaultName
+	}
+	var archives map[string]archive.Archive
+	var lock *lockfile.Lockfile
+	var checkPackage func(archiveName string, info *archive.PackageInfo) error
+	if cmd.Locked {
+		lock, err = lockfile.Read(lockPath)
+		if err != nil {
+			return err
+		}
+		err = lock.CheckSelection(selection, releaseCommit(release.Path))
 		if err != nil {
-			if err == archive.ErrCredentialsNotFound {
-				logf("Archive %q ignored: credentials not found", archiveName)
-				continue
+			return fmt.Errorf("cannot cut locked selection: %w", err)
+		}
+		checkPackage = func(archiveName string, info *archive.PackageInfo) error {
+			err := lock.CheckPackage(archiveName, info)
+			if err != nil {
+				return fmt.Errorf("cannot cut locked selection: %w", err)
 			}
+			return nil
+		}
+	} else if lockPath != "" || cmd.Report != "" {
+		var releaseName string
+		if !strings.Contains(cmd.Release, "/") {
+			releaseName = cmd.Release
+		}
+		lock, err = lockfile.New(releaseName, releaseCommit(release.Path), selection)
+		if err != nil {
 			return err
 		}
-		archives[archiveName] = openArchive
+		checkPackage = func(archiveName string, info *archive.PackageInfo) error {
+			lock.AddPackage(release, archiveName, info)
+			if lockArchive, ok := lock.Archives[archiveName]; ok {
+				lockArchive.Mirror = archive.Mirror(archives[archiveName])
+			}
+			return nil
+		}
+	}
+
+	stopPhase = metrics.Start(metrics.PhaseArchives)
+	archives, err = openArchives(ctx, release, cmd.Arch, cmd.Refresh, httpOverride)
+	stopPhase()
+	resolveSpan.Finish()
+	if err != nil {
+		return err
 	}
 
 	hasMaintainedArchive := false
@@ -121,10 +786,783 @@ func (cmd *cmdCut) Execute(args []string) error {
 		}
 	}
 
-	err = slicer.Run(&slicer.RunOptions{
-		Selection: selection,
-		Archives:  archives,
-		TargetDir: cmd.RootDir,
+	var external []archive.ExternalPackage
+	for _, slice := range selection.Slices {
+		pkg := release.Packages[slice.Package]
+		if pkg.Source == nil || slices.ContainsFunc(external, func(e archive.ExternalPackage) bool {
+			return e.Name == pkg.Name
+		}) {
+			continue
+		}
+		var localPath string
+		if pkg.Source.Local != "" {
+			localPath = filepath.Join(release.Path, pkg.Source.Local)
+		}
+		external = append(external, archive.ExternalPackage{
+			Name:   pkg.Name,
+			Path:   localPath,
+			URL:    pkg.Source.URL,
+			SHA256: pkg.Source.SHA256,
+		})
+	}
+
+	var local archive.Archive
+	if len(debPaths) > 0 || len(external) > 0 {
+		local, err = archive.OpenLocal(&archive.LocalOptions{
+			Label:    "local",
+			Arch:     cmd.Arch,
+			Paths:    debPaths,
+			External: external,
+			CacheDir: cache.DefaultDir("chisel"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.DryRun {
+		if cutPolicy != nil {
+			err = cutPolicy.CheckSelection(selection)
+			if err != nil {
+				return err
+			}
+		}
+		return writeDryRun(release, selection, archives, local, cmd.Estimate)
+	}
+
+	var fetched []*archive.PackageInfo
+	if cmd.SecurityReport != "" {
+		check := checkPackage
+		checkPackage = func(archiveName string, info *archive.PackageInfo) error {
+			if check != nil {
+				err := check(archiveName, info)
+				if err != nil {
+					return err
+				}
+			}
+			fetched = append(fetched, info)
+			return nil
+		}
+	}
+
+	build, err := cmd.manifestBuild(release, archives)
+	if err != nil {
+		return err
+	}
+	build.SymlinkPolicy = symlinkPolicy.String()
+	if cmd.Dedup != string(slicer.DedupNone) {
+		build.Dedup = cmd.Dedup
+	}
+	build.Locales = splitList(cmd.Locales)
+	build.Timezones = splitList(cmd.Timezones)
+
+	report := &cutReport{
+		name: build.Release + "-" + build.Arch,
+		lock: lock,
+	}
+	var reportWriter io.Writer
+	if cmd.Report != "" || cmd.ListConffiles {
+		reportWriter = &report.manifest
+	}
+
+	var unpackCache *cache.Cache
+	if cmd.Reflink {
+		unpackCache = &cache.Cache{Dir: cache.DefaultDir("chisel")}
+	}
+
+	staged := false
+	var journal *cutJournal
+	if !isRemote && cmd.Output == "" {
+		id, err := cmd.journalID(build)
+		if err != nil {
+			return err
+		}
+		journal, err = openJournal(cmd.RootDir, id)
+		if err != nil {
+			return err
+		}
+		rootDir, staged, err = stageRoot(cmd.RootDir)
+		if err != nil {
+			return err
+		}
+		if staged {
+			defer os.RemoveAll(rootDir)
+		}
+		err = journal.resume(rootDir, staged)
+		if err != nil {
+			return err
+		}
+	}
+
+	var ownerDB *ownership.DB
+	if cmd.OwnershipDB != "" || isRemote || cmd.Output != "" {
+		ownerDB = ownership.New()
+	}
+
+	runOptions := &slicer.RunOptions{
+		Selection:  selection,
+		Archives:   archives,
+		Local:      local,
+		TargetDir:  rootDir,
+		DpkgStatus: cmd.DpkgStatus,
+		Locales:    splitList(cmd.Locales),
+		Timezones:  splitList(cmd.Timezones),
+
+		PythonInterpreter: cmd.CompilePython,
+		LDConfig:          cmd.LDConfig,
+		Sysusers:          cmd.Sysusers,
+		Tmpfiles:          cmd.Tmpfiles,
+		CheckPackage:      checkPackage,
+		Policy:            cutPolicy,
+		Generators:        generators,
+		HostFiles:         hostFiles,
+
+		Copyright:             cmd.Copyright || cmd.ExcludeCopyrightFiles,
+		ExcludeCopyrightFiles: cmd.ExcludeCopyrightFiles,
+		Changelogs:            cmd.Changelogs,
+		Ownership:             ownerDB,
+		ManifestEncoding:      manifestutil.Encoding(cmd.ManifestEncoding),
+		ManifestBuild:         build,
+		ReportManifest:        reportWriter,
+		SymlinkPolicy:         symlinkPolicy,
+		Normalize:             normalize,
+		SetuidPolicy:          slicer.SetuidPolicy(cmd.SetuidPolicy),
+		Dedup:                 slicer.DedupMode(cmd.Dedup),
+		UnpackCache:           unpackCache,
+		Context:               ctx,
+	}
+	if journal != nil {
+		runOptions.JournalDir = journal.dir
+	}
+	err = slicer.Run(runOptions)
+	if err != nil {
+		if ctx.Err() != nil {
+			if journal != nil {
+				journal.keep(rootDir, staged)
+			}
+			return cmd.cancelledError(ctx, !staged)
+		}
+		if journal != nil {
+			journal.remove()
+		}
+		return err
+	}
+	if journal != nil {
+		journal.remove()
+	}
+
+	if staged {
+		err = commitRoot(rootDir, cmd.RootDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if lockPath != "" && !cmd.Locked {
+		err = lockfile.Write(lockPath, lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.OwnershipDB != "" {
+		err = ownership.Write(cmd.OwnershipDB, ownerDB)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.SecurityReport != "" {
+		report.vulns, err = writeSecurityReport(cmd.SecurityReport, release, fetched)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.Report != "" {
+		err = report.write(cmd.Report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.ListConffiles {
+		err = listConffiles(report.manifest.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	if isRemote {
+		logf("Uploading to %s...", target)
+		return target.Upload(rootDir, ownerDB)
+	}
+	if cmd.Output != "" {
+		return writeOutput(cmd.Output, rootDir, ownerDB)
+	}
+	return nil
+}
+
+// listConffiles lists the conffiles recorded in the manifest, with the
+// slices extracting them.
+func listConffiles(manifestData []byte) error {
+	mfest, err := manifest.Read(bytes.NewReader(manifestData))
+	if err != nil {
+		return err
+	}
+	w := tabWriter()
+	found := false
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		if !path.Conffile {
+			return nil
+		}
+		if !found {
+			fmt.Fprintf(w, "Path\tSlices\n")
+			found = true
+		}
+		fmt.Fprintf(w, "%s\t%s\n", path.Path, strings.Join(path.Slices, ","))
+		return nil
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	if !found {
+		fmt.Fprintf(Stderr, "No conffiles in the tree\n")
+	}
+	return w.Flush()
+}
+
+// logTimings logs the time spent in each phase of a cut, along with the
+// bytes downloaded and the cache lookups done meanwhile.
+func logTimings(s *metrics.Snapshot) {
+	logf("Timings:")
+	total := 0.0
+	for _, phase := range s.Phases() {
+		logf("  %-10s %s", phase, secondsDuration(s.PhaseSeconds[phase]))
+		total += s.PhaseSeconds[phase]
+	}
+	logf("  %-10s %s", "total", secondsDuration(total))
+	logf("Downloaded: %d bytes", s.DownloadedBytes)
+	lookups := s.CacheHits + s.CacheMisses
+	if lookups > 0 {
+		logf("Cache hits: %d of %d (%.0f%%)", s.CacheHits, lookups, float64(s.CacheHits)*100/float64(lookups))
+	} else {
+		logf("Cache hits: none")
+	}
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
+
+// manifestBuild returns how the tree is cut, to be recorded in its manifests:
+// the release, the Chisel version, the archives opened and the command line.
+func (cmd *cmdCut) manifestBuild(release *setup.Release, archives map[string]archive.Archive) (*manifest.Build, error) {
+	digest, err := setup.ReleaseDigest(release.Path)
+	if err != nil {
+		return nil, err
+	}
+	build := &manifest.Build{
+		ChiselVersion: chiselVersion(),
+		Release:       cmd.Release,
+		ReleaseDigest: digest,
+		ReleaseCommit: releaseCommit(release.Path),
+		Arch:          cmd.Arch,
+		Command:       os.Args,
+	}
+	if build.Release == "" || strings.HasPrefix(build.Release, "@") {
+		label, version, err := readReleaseInfo()
+		if err != nil {
+			return nil, err
+		}
+		build.Release = label + "-" + version + build.Release
+	}
+	if build.Arch == "" {
+		build.Arch, err = deb.InferArch()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, archiveName := range slices.Sorted(maps.Keys(archives)) {
+		archiveInfo := release.Archives[archiveName]
+		build.Archives = append(build.Archives, &manifest.BuildArchive{
+			Name:       archiveName,
+			Version:    archiveInfo.Version,
+			Suites:     archiveInfo.Suites,
+			Components: archiveInfo.Components,
+			Priority:   archiveInfo.Priority,
+			Pro:        archiveInfo.Pro,
+			Mirror:     archive.Mirror(archives[archiveName]),
+		})
+	}
+	return build, nil
+}
+
+// cancelledError returns the error reporting that the cut was cancelled
+// through ctx before it was done. With incomplete, the content left in the
+// root directory is reported as such.
+func (cmd *cmdCut) cancelledError(ctx context.Context, incomplete bool) error {
+	reason := "cancelled"
+	if ctx.Err() == context.DeadlineExceeded {
+		reason = fmt.Sprintf("timed out after %s", cmd.Timeout)
+	}
+	_, isRemote, _ := remote.ParseTarget(cmd.RootDir)
+	if !incomplete || cmd.RootDir == "" || isRemote {
+		return fmt.Errorf("cut %s", reason)
+	}
+	return fmt.Errorf("cut %s, content in %s is incomplete", reason, cmd.RootDir)
+}
+
+// lockRoot takes the lock on the root directory of a cut, kept in the cache
+// so that nothing is left next to the tree, and returns the function
+// releasing it. Concurrent cuts into the same directory run one at a time.
+func lockRoot(ctx context.Context, rootDir string) (unlock func(), err error) {
+	absDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	lockPath := filepath.Join(cache.DefaultDir("chisel"), "locks", hex.EncodeToString(sum[:]))
+	return fsutil.Lock(ctx, lockPath, rootDir)
+}
+
+// stageRoot returns the directory to cut the tree into, and whether it is a
+// staging directory next to rootDir which commitRoot moves into place once
+// the cut is done, so that a failed cut leaves rootDir untouched. A rootDir
+// which already has content, is a symlink or is a mount point cannot be
+// replaced by the staging directory, and is cut in place.
+func stageRoot(rootDir string) (stageDir string, staged bool, err error) {
+	info, err := os.Lstat(rootDir)
+	if err == nil {
+		if !info.IsDir() {
+			// Symlinks are followed by the cut, and anything else fails.
+			return rootDir, false, nil
+		}
+		entries, err := os.ReadDir(rootDir)
+		if err != nil {
+			return "", false, err
+		}
+		if len(entries) > 0 {
+			return rootDir, false, nil
+		}
+		mounted, err := isMountPoint(rootDir, info)
+		if err != nil {
+			return "", false, err
+		}
+		if mounted {
+			return rootDir, false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	}
+	parentDir := filepath.Dir(filepath.Clean(rootDir))
+	err = os.MkdirAll(parentDir, 0755)
+	if err != nil {
+		return "", false, err
+	}
+	stageDir, err = os.MkdirTemp(parentDir, "."+filepath.Base(rootDir)+".chisel-")
+	if err != nil {
+		return "", false, err
+	}
+	return stageDir, true, nil
+}
+
+// isMountPoint returns whether the directory at path, described by info, is
+// on a different device than its parent, as mount points are.
+func isMountPoint(path string, info fs.FileInfo) (bool, error) {
+	dev, ok := fsutil.Device(info)
+	if !ok {
+		return false, nil
+	}
+	parentInfo, err := os.Stat(filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return false, err
+	}
+	parentDev, ok := fsutil.Device(parentInfo)
+	return ok && dev != parentDev, nil
+}
+
+// commitRoot moves the tree cut into stageDir to rootDir, replacing the
+// latter if it is an empty directory and keeping its permissions and, when
+// allowed, its owner.
+func commitRoot(stageDir, rootDir string) error {
+	mode := fs.FileMode(0755)
+	info, err := os.Stat(rootDir)
+	if err == nil {
+		mode = info.Mode().Perm()
+		if uid, gid, ok := fsutil.Owner(info); ok {
+			// Only possible with privileges, which cuts into directories
+			// owned by other users would have too.
+			os.Lchown(stageDir, uid, gid)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// The mode is set after the owner, as changing the owner clears the
+	// setuid and setgid bits.
+	err = os.Chmod(stageDir, mode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(stageDir, rootDir)
+	if err != nil && info != nil {
+		// Not all systems allow renaming over an empty directory.
+		err = os.Remove(rootDir)
+		if err == nil {
+			err = os.Rename(stageDir, rootDir)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot move tree into %s: %w", rootDir, err)
+	}
+	return nil
+}
+
+// cutJournal keeps in the cache the state of an interrupted cut into a root
+// directory, for the same cut run again to resume it: the records of the
+// packages already extracted into the tree and, for staged cuts, the tree
+// itself, which is not left next to the root directory.
+type cutJournal struct {
+	dir string
+}
+
+// openJournal returns the journal of the cut into rootDir identified by id,
+// discarding the state left by any other cut into rootDir.
+func openJournal(rootDir, id string) (*cutJournal, error) {
+	absDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	journal := &cutJournal{
+		dir: filepath.Join(cache.DefaultDir("chisel"), "journal", hex.EncodeToString(sum[:])),
+	}
+	idPath := filepath.Join(journal.dir, "cut")
+	data, err := os.ReadFile(idPath)
+	if err == nil && string(data) == id {
+		return journal, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read cut journal: %w", err)
+	}
+	err = os.RemoveAll(journal.dir)
+	if err == nil {
+		err = os.MkdirAll(journal.dir, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(idPath, []byte(id), 0644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot write cut journal: %w", err)
+	}
+	return journal, nil
+}
+
+// resume moves the tree kept by the interrupted cut to rootDir when the cut
+// is staged, or discards it otherwise.
+func (j *cutJournal) resume(rootDir string, staged bool) error {
+	treeDir := filepath.Join(j.dir, "tree")
+	_, err := os.Lstat(treeDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if staged {
+		// The staging directory is empty, and replaced by the tree.
+		err = os.Remove(rootDir)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(treeDir, rootDir)
+		if err == nil {
+			logf("Resuming interrupted cut...")
+			return nil
+		}
+		err = os.Mkdir(rootDir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+	// The packages are extracted again.
+	return os.RemoveAll(treeDir)
+}
+
+// keep keeps the state of the interrupted cut into rootDir for it to be
+// resumed, moving the tree into the journal when the cut is staged.
+func (j *cutJournal) keep(rootDir string, staged bool) {
+	if !staged {
+		return
+	}
+	err := os.Rename(rootDir, filepath.Join(j.dir, "tree"))
+	if err != nil {
+		// The tree is not on the same filesystem as the cache, and is
+		// cut again from scratch.
+		debugf("Cannot keep interrupted cut: %v", err)
+		j.remove()
+	}
+}
+
+// remove discards the journal, once the cut is done or failed.
+func (j *cutJournal) remove() {
+	os.RemoveAll(j.dir)
+}
+
+// journalID identifies the cut in its journal, which is only resumed by the
+// same cut of the same release. Options not affecting the tree cut, such as
+// the timeout, may change.
+func (cmd *cmdCut) journalID(build *manifest.Build) (string, error) {
+	cut := *cmd
+	cut.Timeout = 0
+	cut.Timings = false
+	cut.Verbose = false
+	data, err := json.Marshal(struct {
+		Cut           *cmdCut
+		Pins          map[string]string
+		HostFiles     []slicer.HostFile
+		ReleaseDigest string
+	}{&cut, cmd.pins, cmd.hostFiles, build.ReleaseDigest})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeOutput writes the tree at rootDir as a tar archive to path.
+func writeOutput(path string, rootDir string, db *ownership.DB) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot write output: %w", err)
+	}
+	err = tarutil.Write(file, rootDir, db)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("cannot write output %s: %w", path, err)
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("cannot write output %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeSecurityReport writes the known vulnerabilities affecting the
+// packages to path, in JSON format.
+func writeSecurityReport(path string, release *setup.Release, pkgs []*archive.PackageInfo) ([]*security.Vulnerability, error) {
+	vulns, err := auditPackages(release, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = writeVulnerabilities(&buf, vulns)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write security report: %w", err)
+	}
+	if len(vulns) > 0 {
+		logf("Warning: %d known vulnerabilities found, see %s", len(vulns), path)
+	}
+	return vulns, nil
+}
+
+// splitList returns the non-empty items of a comma-separated list, or nil
+// if the list is empty.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseGenerators parses references in the format "<kind>=<command>" into
+// generators running the respective commands.
+func parseGenerators(refs []string) (map[setup.GenerateKind]slicer.Generator, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	generators := make(map[setup.GenerateKind]slicer.Generator)
+	for _, ref := range refs {
+		kind, command, ok := strings.Cut(ref, "=")
+		if !ok || command == "" || !setup.GenerateKind(kind).IsCustom() {
+			return nil, usageErrorf("invalid generator %q: must be <kind>=<command> with a kind such as x-name", ref)
+		}
+		generators[setup.GenerateKind(kind)] = slicer.ExecGenerator(command)
+	}
+	return generators, nil
+}
+
+// parseHostFile parses a reference in the format "<file>:<path>[:<mode>]"
+// into the host file to copy into the tree, where path is absolute and mode
+// is in octal, as in "./app:/usr/bin/app:0755".
+func parseHostFile(ref string) (slicer.HostFile, error) {
+	source, target, ok := strings.Cut(ref, ":/")
+	if !ok || source == "" {
+		return slicer.HostFile{}, usageErrorf("invalid --copy %q: must be <file>:<path>[:<mode>]", ref)
+	}
+	target, modeStr, hasMode := strings.Cut("/"+target, ":")
+	hostFile := slicer.HostFile{Source: source, Path: target}
+	if !isHostFilePath(target) {
+		return slicer.HostFile{}, usageErrorf("invalid --copy %q: invalid path %q", ref, target)
+	}
+	if hasMode {
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			return slicer.HostFile{}, usageErrorf("invalid --copy %q: invalid mode %q", ref, modeStr)
+		}
+		hostFile.Mode = fs.FileMode(mode)
+	}
+	return hostFile, nil
+}
+
+// isHostFilePath returns whether path is a valid path in the tree for a
+// file copied from the host.
+func isHostFilePath(filePath string) bool {
+	return path.IsAbs(filePath) && path.Clean(filePath) == filePath && filePath != "/"
+}
+
+// parseSymlinkPolicy returns the policy for the symlinks extracted from
+// packages from the given "<target>=<action>" entries.
+func parseSymlinkPolicy(refs []string) (slicer.SymlinkPolicy, error) {
+	var policy slicer.SymlinkPolicy
+	for _, ref := range refs {
+		target, action, _ := strings.Cut(ref, "=")
+		err := policy.Set(slicer.SymlinkTarget(target), slicer.SymlinkAction(action))
+		if err != nil {
+			return policy, usageErrorf("invalid --symlink-policy %q: must be <target>=<action> with a target of absolute or escaping and an action of allow, reject or rewrite", ref)
+		}
+	}
+	return policy, nil
+}
+
+// normalizeOptions returns how the permissions of the tree are normalized
+// according to the --umask and --strip-setuid options, or nil.
+func (cmd *cmdCut) normalizeOptions() (*fsutil.NormalizeOptions, error) {
+	if cmd.Umask == "" && !cmd.StripSetuid {
+		return nil, nil
+	}
+	options := &fsutil.NormalizeOptions{StripSetuid: cmd.StripSetuid}
+	if cmd.Umask != "" {
+		umask, err := strconv.ParseUint(cmd.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return nil, usageErrorf("invalid --umask %q: must be an octal mode such as 022", cmd.Umask)
+		}
+		options.Umask = fs.FileMode(umask)
+	}
+	return options, nil
+}
+
+// parseDebRef splits a reference in the format "<file>[:<slices>]" into the
+// path of the .deb file and the list of slice names.
+func parseDebRef(debRef string) (debPath string, sliceNames []string) {
+	i := strings.LastIndex(debRef, ":")
+	if i < 0 || !strings.HasSuffix(debRef[:i], ".deb") {
+		return debRef, nil
+	}
+	for _, name := range strings.Split(debRef[i+1:], ",") {
+		if name != "" {
+			sliceNames = append(sliceNames, name)
+		}
+	}
+	return debRef[:i], sliceNames
+}
+
+// overrideArchives returns a copy of release with the suites and components
+// of its archives changed as requested with the --add-suite, --drop-suite,
+// --add-component and --drop-component options, or release itself when
+// none is used. Each value is in the format [<archive>:]<name> and applies
+// to all the archives when no archive is given. Suites starting with a dash,
+// such as "-proposed", refer to that pocket of the codename of each archive.
+func (cmd *cmdCut) overrideArchives(release *setup.Release) (*setup.Release, error) {
+	if len(cmd.AddSuites)+len(cmd.DropSuites)+len(cmd.AddComponents)+len(cmd.DropComponents) == 0 {
+		return release, nil
+	}
+	archives := make(map[string]*setup.Archive, len(release.Archives))
+	for archiveName, archiveInfo := range release.Archives {
+		overridden := *archiveInfo
+		overridden.Suites = slices.Clone(archiveInfo.Suites)
+		overridden.Components = slices.Clone(archiveInfo.Components)
+		archives[archiveName] = &overridden
+	}
+	archiveNames := slices.Sorted(maps.Keys(archives))
+
+	suites := func(a *setup.Archive) *[]string { return &a.Suites }
+	components := func(a *setup.Archive) *[]string { return &a.Components }
+	overrides := []struct {
+		option string
+		kind   string
+		values []string
+		drop   bool
+		list   func(a *setup.Archive) *[]string
+	}{
+		{"add-suite", "suite", cmd.AddSuites, false, suites},
+		{"drop-suite", "suite", cmd.DropSuites, true, suites},
+		{"add-component", "component", cmd.AddComponents, false, components},
+		{"drop-component", "component", cmd.DropComponents, true, components},
+	}
+	for _, override := range overrides {
+		for _, value := range override.values {
+			archiveName, name, ok := strings.Cut(value, ":")
+			if !ok {
+				archiveName, name = "", value
+			}
+			if name == "" || name == "-" || strings.ContainsAny(name, " /") {
+				return nil, usageErrorf("invalid --%s: %q", override.option, value)
+			}
+			targets := archiveNames
+			if archiveName != "" {
+				if _, ok := archives[archiveName]; !ok {
+					return nil, fmt.Errorf("invalid --%s: archive %q not found in release", override.option, archiveName)
+				}
+				targets = []string{archiveName}
+			}
+			changed := false
+			for _, targetName := range targets {
+				entry := name
+				if override.kind == "suite" && strings.HasPrefix(name, "-") {
+					codename, _, _ := strings.Cut(release.Archives[targetName].Suites[0], "-")
+					entry = codename + name
+				}
+				list := override.list(archives[targetName])
+				i := slices.Index(*list, entry)
+				if override.drop && i >= 0 {
+					*list = slices.Delete(*list, i, i+1)
+					changed = true
+				} else if !override.drop {
+					if i < 0 {
+						*list = append(*list, entry)
+					}
+					changed = true
+				}
+			}
+			if !changed {
+				return nil, fmt.Errorf("cannot drop %s %q: not used by any archive", override.kind, name)
+			}
+		}
+	}
+
+	for _, archiveName := range archiveNames {
+		archiveInfo := archives[archiveName]
+		if len(archiveInfo.Suites) == 0 {
+			return nil, fmt.Errorf("archive %q has no suites left", archiveName)
+		}
+		if len(archiveInfo.Components) == 0 {
+			return nil, fmt.Errorf("archive %q has no components left", archiveName)
+		}
+		original := release.Archives[archiveName]
+		if !slices.Equal(archiveInfo.Suites, original.Suites) || !slices.Equal(archiveInfo.Components, original.Components) {
+			logf("Archive %q overridden: suites %s, components %s", archiveName,
+				strings.Join(archiveInfo.Suites, ", "), strings.Join(archiveInfo.Components, ", "))
+		}
+	}
+	overridden := *release
+	overridden.Archives = archives
+	return &overridden, nil
 }
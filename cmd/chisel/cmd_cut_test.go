@@ -0,0 +1,1378 @@
+package main_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/lockfile"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+	"github.com/canonical/chisel/public/manifest"
+	"github.com/canonical/chisel/public/release"
+)
+
+type cutTest struct {
+	summary   string
+	args      []string
+	selection string
+	// files lists the paths expected in the root, relative to it.
+	files []string
+	err   string
+}
+
+var cutRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\tapp-common: [mypkg_bins, mypkg_config]\n",
+	"slices/mypkg.yaml": `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+			config:
+				contents:
+					/etc/app.conf:
+			manifest:
+				contents:
+					/var/lib/chisel/**: {generate: manifest}
+			old-bins:
+				deprecated: use mypkg_bins instead
+				contents:
+					/usr/bin/app:
+			machine:
+				contents:
+					/etc/machine/**: {generate: x-machine-id}
+			all:
+				essential:
+					- mypkg_bins
+					- mypkg_config
+	`,
+}
+
+var cutTests = []cutTest{{
+	summary: "Slices from a selection file",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Selection file in JSON",
+	selection: `
+		{"release": "<release>", "slices": ["mypkg_config"], "output": {"root": "<root>"}}
+	`,
+	files: []string{"/etc/app.conf"},
+}, {
+	summary: "Command line options and slices are merged with the file",
+	args:    []string{"--root", "<root>", "mypkg_config"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: /non-existent
+	`,
+	files: []string{"/etc/app.conf", "/usr/bin/app"},
+}, {
+	summary: "Packages may be pinned to an archive",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		pins:
+			mypkg: ubuntu
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Pinned archive must exist",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		pins:
+			mypkg: other
+		output:
+			root: <root>
+	`,
+	err: `cannot pin package "mypkg": archive "other" not found in release`,
+}, {
+	summary: "Pinned package must exist",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		pins:
+			otherpkg: ubuntu
+		output:
+			root: <root>
+	`,
+	err: `cannot pin package "otherpkg": package not found in release`,
+}, {
+	summary: "Deprecated slices may be selected",
+	selection: `
+		release: <release>
+		slices: [mypkg_old-bins]
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Deprecated slices are rejected in strict mode",
+	args:    []string{"--strict"},
+	selection: `
+		release: <release>
+		slices: [mypkg_old-bins]
+		output:
+			root: <root>
+	`,
+	err: `slice mypkg_old-bins is deprecated: use mypkg_bins instead`,
+}, {
+	summary: "Slices may be excluded",
+	args:    []string{"--without", "*_config"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins, mypkg_config]
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Slices may be excluded in the file",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins, mypkg_config]
+		without: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	files: []string{"/etc/app.conf"},
+}, {
+	summary: "Paths may be excluded",
+	args:    []string{"--without", "/etc/*.conf"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins, mypkg_config]
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Essential slices cannot be excluded",
+	args:    []string{"--without", "mypkg_config"},
+	selection: `
+		release: <release>
+		slices: [mypkg_all]
+		output:
+			root: <root>
+	`,
+	err: `cannot exclude slice mypkg_config: essential to slice mypkg_all`,
+}, {
+	summary: "Essential slices may be excluded by force",
+	args:    []string{"--without", "mypkg_config", "--force"},
+	selection: `
+		release: <release>
+		slices: [mypkg_all]
+		output:
+			root: <root>
+	`,
+	files: []string{"/usr/bin/app"},
+}, {
+	summary: "Groups of slices may be selected",
+	selection: `
+		release: <release>
+		slices: [app-common]
+		output:
+			root: <root>
+	`,
+	files: []string{"/etc/app.conf", "/usr/bin/app"},
+}, {
+	summary: "Groups must exist",
+	args:    []string{"app-other"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	err: `group "app-other" not found in release`,
+}, {
+	summary: "Slices may be selected with wildcards",
+	args:    []string{"mypkg_[bc]*"},
+	selection: `
+		release: <release>
+		slices: ["*_config"]
+		output:
+			root: <root>
+	`,
+	files: []string{"/etc/app.conf", "/usr/bin/app"},
+}, {
+	summary: "Wildcards must match some slice",
+	selection: `
+		release: <release>
+		slices: ["otherpkg_*"]
+		output:
+			root: <root>
+	`,
+	err: `no slices match "otherpkg_\*"`,
+}, {
+	summary: "Root is required",
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+	`,
+	err: "the required flag `--root' was not specified",
+}, {
+	summary: "Root and output are exclusive",
+	args:    []string{"--root", "<root>", "--output", "<root>/rootfs.tar"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+	`,
+	err: "cannot use --root and --output together",
+}, {
+	summary: "Generators must name a custom kind",
+	args:    []string{"--generator", "manifest=./generate"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	err: `invalid generator "manifest=./generate": must be <kind>=<command> with a kind such as x-name`,
+}, {
+	summary: "Umask must be an octal mode",
+	args:    []string{"--umask", "0855"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	err: `invalid --umask "0855": must be an octal mode such as 022`,
+}, {
+	summary: "Symlink policy must name a target and an action",
+	args:    []string{"--symlink-policy", "relative=reject"},
+	selection: `
+		release: <release>
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	err: `invalid --symlink-policy "relative=reject": must be <target>=<action> with a target of absolute or escaping and an action of allow, reject or rewrite`,
+}, {
+	summary: "Custom generate kinds require a generator",
+	selection: `
+		release: <release>
+		slices: [mypkg_machine]
+		output:
+			root: <root>
+	`,
+	err: `slice mypkg_machine has no generator for path /etc/machine/\*\*: "x-machine-id"`,
+}, {
+	summary: "Slices are required",
+	selection: `
+		release: <release>
+		output:
+			root: <root>
+	`,
+	err: `cannot parse selection file .*: no slices listed`,
+}, {
+	summary: "Invalid slice name",
+	selection: `
+		slices: [mypkg_a]
+	`,
+	err: `cannot parse selection file .*: invalid slice reference: "mypkg_a"`,
+}, {
+	summary: "Invalid condition to ignore",
+	selection: `
+		ignore: [everything]
+		slices: [mypkg_bins]
+	`,
+	err: `cannot parse selection file .*: invalid condition to ignore: "everything"`,
+}, {
+	summary: "Invalid manifest encoding",
+	selection: `
+		slices: [mypkg_bins]
+		output:
+			manifest-encoding: xz
+	`,
+	err: `cannot parse selection file .*: invalid manifest encoding: "xz"`,
+}, {
+	summary: "Invalid release ref",
+	selection: `
+		release: ubuntu-24.04@-main
+		slices: [mypkg_bins]
+		output:
+			root: <root>
+	`,
+	err: `invalid release ref: "-main"`,
+}, {
+	summary: "Unknown fields are rejected",
+	selection: `
+		slices: [mypkg_bins]
+		output:
+			rot: <root>
+	`,
+	err: `cannot parse selection file .*: yaml: unmarshal errors:\n  line 3: field rot not found in type main.selectionOutput`,
+}}
+
+func (s *ChiselSuite) TestCutSelection(c *C) {
+	for _, test := range cutTests {
+		c.Logf("Summary: %s", test.summary)
+
+		releaseDir, _, restore := fakeCutRelease(c)
+
+		rootDir := c.MkDir()
+		replacer := strings.NewReplacer("<release>", releaseDir, "<root>", rootDir)
+		selectionPath := filepath.Join(c.MkDir(), "selection.yaml")
+		err := os.WriteFile(selectionPath, []byte(replacer.Replace(string(testutil.Reindent(test.selection)))), 0644)
+		c.Assert(err, IsNil)
+
+		args := []string{"cut", "--selection", selectionPath}
+		for _, arg := range test.args {
+			args = append(args, replacer.Replace(arg))
+		}
+		_, err = chisel.Parser().ParseArgs(args)
+		restore()
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		var files []string
+		err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+			c.Assert(err, IsNil)
+			if info.Mode().IsRegular() {
+				files = append(files, strings.TrimPrefix(path, rootDir))
+			}
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(files, DeepEquals, test.files)
+	}
+}
+
+// fakeCutRelease writes cutRelease to a directory and makes the cut command
+// use a fake archive holding its package.
+func fakeCutRelease(c *C) (releaseDir string, testArchive *testutil.TestArchive, restore func()) {
+	releaseDir = c.MkDir()
+	for path, data := range cutRelease {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	release, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+
+	testArchive = &testutil.TestArchive{
+		Opts: archive.Options{
+			Label:      "ubuntu",
+			Version:    release.Archives["ubuntu"].Version,
+			Suites:     release.Archives["ubuntu"].Suites,
+			Components: release.Archives["ubuntu"].Components,
+			Maintained: true,
+		},
+		Packages: map[string]*testutil.TestPackage{
+			"mypkg": {
+				Name:    "mypkg",
+				Version: "1.0",
+				Hash:    "c2b7b0eb1bb4a5f7b2a4a7ffa4d1e2a2a8b1c47f2a2e2d6d6b0f2ab6a7f2b4a1",
+				Arch:    "amd64",
+				Data: testutil.MustMakeDeb([]testutil.TarEntry{
+					testutil.Dir(0755, "./etc/"),
+					testutil.Reg(0644, "./etc/app.conf", "conf"),
+					testutil.Dir(0755, "./usr/"),
+					testutil.Dir(0755, "./usr/bin/"),
+					testutil.Reg(0755, "./usr/bin/app", "app"),
+				}),
+			},
+		},
+	}
+	restore = chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		c.Assert(options.Label, Equals, "ubuntu")
+		return testArchive, nil
+	})
+	return releaseDir, testArchive, restore
+}
+
+func (s *ChiselSuite) TestCutDryRun(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	pkg := testArchive.Packages["mypkg"]
+	pkg.InstalledSize = 10240
+	sum := sha256.Sum256(pkg.Data)
+	pkg.Hash = hex.EncodeToString(sum[:])
+
+	rootDir := filepath.Join(c.MkDir(), "root")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "--dry-run", "mypkg_bins", "mypkg_config"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Version  Archive  Slices\n"+
+		"mypkg    1.0      ubuntu   bins,config\n")
+	_, err = os.Stat(rootDir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// The installed size bounds the estimate of packages not fetched.
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--dry-run", "--estimate", "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Version  Archive  Slices  Installed  Estimate\n"+
+		"mypkg    1.0      ubuntu   bins    10.0 KiB   <= 10.0 KiB\n"+
+		"Total                              10.0 KiB   <= 10.0 KiB\n")
+
+	// The paths of packages in the cache are resolved.
+	pkgCache := &cache.Cache{Dir: cache.DefaultDir("chisel")}
+	c.Assert(pkgCache.Write(pkg.Hash, pkg.Data), IsNil)
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--dry-run", "--estimate", "mypkg_bins", "mypkg_config"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Version  Archive  Slices       Installed  Estimate\n"+
+		"mypkg    1.0      ubuntu   bins,config  10.0 KiB   7 B\n"+
+		"Total                                   10.0 KiB   7 B\n")
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "--estimate", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cannot use --estimate without --dry-run")
+}
+
+func (s *ChiselSuite) TestCutLocked(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	lockPath := filepath.Join(c.MkDir(), "chisel.lock")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(lockPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `(?s)format: v1
+release:
+    digest: [0-9a-f]{64}
+archives:
+    ubuntu:
+        version: "22.04"
+        suites:
+            - jammy
+        components:
+            - main
+            - universe
+slices:
+    - mypkg_bins
+packages:
+    - name: mypkg
+      version: "1.0"
+      arch: amd64
+      sha256: c2b7b0eb.*
+      archive: ubuntu
+      suite: jammy
+      component: main
+`)
+
+	// The same inputs may be cut again.
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--locked", "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	// Other slices cannot.
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--locked", "mypkg_config"})
+	c.Assert(err, ErrorMatches, "cannot cut locked selection: selected slices differ from lockfile: mypkg_config != mypkg_bins")
+
+	// Nor packages found elsewhere in the archive.
+	testArchive.Packages["mypkg"].Component = "universe"
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--locked", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `cannot cut locked selection: package "mypkg" component differs from lockfile: universe != main`)
+	testArchive.Packages["mypkg"].Component = ""
+
+	// Nor archives with other suites.
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--locked", "--add-suite=-updates", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `cannot cut locked selection: archive "ubuntu" suites differ from lockfile: jammy, jammy-updates != jammy`)
+
+	// Nor lockfiles recording packages not used.
+	locked, err := lockfile.Read(lockPath)
+	c.Assert(err, IsNil)
+	locked.Packages = append(locked.Packages, &lockfile.Package{Name: "otherpkg", Archive: "ubuntu"})
+	otherLockPath := filepath.Join(c.MkDir(), "chisel.lock")
+	err = lockfile.Write(otherLockPath, locked)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", otherLockPath, "--locked", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `cannot cut locked selection: package "otherpkg" in lockfile is not used by the selection`)
+
+	// Nor other packages.
+	testArchive.Packages["mypkg"].Version = "1.1"
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--lockfile", lockPath, "--locked", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `cannot cut locked selection: package "mypkg" version differs from lockfile: 1.1 != 1.0`)
+	entries, err := os.ReadDir(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	// Nor a modified release.
+	err = os.WriteFile(filepath.Join(releaseDir, "slices/other.yaml"), []byte("package: other\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "--locked", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cannot cut locked selection: release content differs from lockfile")
+}
+
+func (s *ChiselSuite) TestCutOwnershipDB(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	dbPath := filepath.Join(c.MkDir(), "ownership")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--ownership-db", dbPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	// All the content of the package is owned by root.
+	data, err := os.ReadFile(dbPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "# chisel ownership v1\n")
+}
+
+func (s *ChiselSuite) TestCutListConffiles(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	testArchive.Packages["mypkg"].Data = testutil.MustMakeDebWithControlFiles(map[string]string{
+		"control":   "Package: mypkg\n",
+		"conffiles": "/etc/app.conf\n",
+	}, []testutil.TarEntry{
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/app.conf", "conf"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+	})
+
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--list-conffiles", "mypkg_bins", "mypkg_config"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "Path           Slices\n/etc/app.conf  mypkg_config\n")
+	s.ResetStdStreams()
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--list-conffiles", "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Matches, "(?s).*No conffiles in the tree\n")
+}
+
+func (s *ChiselSuite) TestCutHTTPOptions(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	var opened *archive.Options
+	restoreOpen := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened = options
+		return testArchive, nil
+	})
+	defer restoreOpen()
+
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--http-timeout", "10s", "--http-bulk-timeout", "2m", "--http-header", "X-Token: secret",
+		"--user-agent", "my-agent/1.0", "--http-chunk-threshold", "64MiB", "--http-chunk-size", "4MiB",
+		"mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(opened, NotNil)
+	c.Assert(opened.HTTP, DeepEquals, archive.HTTPOptions{
+		Timeout:        10 * time.Second,
+		BulkTimeout:    2 * time.Minute,
+		Headers:        map[string]string{"X-Token": "secret"},
+		UserAgent:      "my-agent/1.0",
+		ChunkThreshold: 64 << 20,
+		ChunkSize:      4 << 20,
+	})
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--http-header", "X-Token", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `invalid --http-header, expected <name>:<value>: "X-Token"`)
+	c.Assert(chisel.ExitCode(err), Equals, 2)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--http-header", "X Token: secret", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `invalid header name: "X Token"`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--http-chunk-size", "4MB", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `invalid --http-chunk-size: invalid size: "4MB"`)
+}
+
+func (s *ChiselSuite) TestCutManifestEncoding(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	rootDir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--manifest-encoding", "json", "mypkg_bins", "mypkg_manifest"})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `(?s)\{\n  "schema": "2.0",.*`)
+	mfest, err := manifest.Read(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	var paths []string
+	err = mfest.IteratePaths("/usr/bin/", func(path *manifest.Path) error {
+		paths = append(paths, path.Path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/usr/bin/app"})
+}
+
+func (s *ChiselSuite) TestCutManifestBuild(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	// The commit is found through packed refs, as in fresh clones.
+	gitDir := filepath.Join(releaseDir, ".git")
+	err := os.Mkdir(gitDir, 0755)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(gitDir, "packed-refs"), []byte(
+		"# pack-refs with: peeled fully-peeled sorted\n"+
+			"0123456789abcdef0123456789abcdef01234567 refs/heads/main\n"), 0644)
+	c.Assert(err, IsNil)
+	digest, err := setup.ReleaseDigest(releaseDir)
+	c.Assert(err, IsNil)
+	arch, err := deb.InferArch()
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	args := []string{"cut", "--release", releaseDir, "--root", rootDir, "--symlink-policy", "escaping=reject",
+		"--locales", "pt_BR", "--timezones", "UTC,Europe/Lisbon", "mypkg_bins", "mypkg_manifest"}
+	oldArgs := os.Args
+	os.Args = append([]string{"chisel"}, args...)
+	defer func() { os.Args = oldArgs }()
+	_, err = chisel.Parser().ParseArgs(args)
+	c.Assert(err, IsNil)
+
+	f, err := os.Open(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	build, err := mfest.Build()
+	c.Assert(err, IsNil)
+	c.Assert(build, DeepEquals, &manifest.Build{
+		Kind:          "build",
+		ChiselVersion: "unknown",
+		Release:       releaseDir,
+		ReleaseDigest: digest,
+		ReleaseCommit: "0123456789abcdef0123456789abcdef01234567",
+		Arch:          arch,
+		Archives: []*manifest.BuildArchive{{
+			Name:       "ubuntu",
+			Version:    "22.04",
+			Suites:     []string{"jammy"},
+			Components: []string{"main", "universe"},
+		}},
+		Command:       append([]string{"chisel"}, args...),
+		SymlinkPolicy: "absolute=allow,escaping=reject",
+		Locales:       []string{"pt_BR"},
+		Timezones:     []string{"UTC", "Europe/Lisbon"},
+	})
+}
+
+func (s *ChiselSuite) TestCutReport(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	reportDir := filepath.Join(c.MkDir(), "report")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--report", reportDir, "mypkg_bins", "mypkg_config"})
+	c.Assert(err, IsNil)
+
+	entries, err := os.ReadDir(reportDir)
+	c.Assert(err, IsNil)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"chisel.lock", "manifest.json", "sbom.cdx.json", "sbom.spdx.json", "security.json", "sizes.json"})
+
+	// The manifest is written even though no slice generates one.
+	f, err := os.Open(filepath.Join(reportDir, "manifest.json"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	var paths []string
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		paths = append(paths, path.Path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/etc/app.conf", "/usr/bin/app"})
+
+	data, err := os.ReadFile(filepath.Join(reportDir, "sizes.json"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{
+  "total": 7,
+  "files": 2,
+  "packages": {
+    "mypkg": 7
+  },
+  "slices": {
+    "mypkg_bins": 3,
+    "mypkg_config": 4
+  }
+}
+`)
+
+	data, err = os.ReadFile(filepath.Join(reportDir, "security.json"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "{\n  \"privileged\": []\n}\n")
+
+	data, err = os.ReadFile(filepath.Join(reportDir, "sbom.spdx.json"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `(?s).*"created": "2023-11-14T22:13:20Z".*"referenceLocator": "pkg:deb/ubuntu/mypkg@1.0\?arch=amd64".*`)
+
+	data, err = os.ReadFile(filepath.Join(reportDir, "chisel.lock"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `(?s).*mypkg_bins.*mypkg_config.*`)
+}
+
+func (s *ChiselSuite) TestCutArchiveOverrides(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	var opened *archive.Options
+	restoreOpen := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened = options
+		return testArchive, nil
+	})
+	defer restoreOpen()
+
+	rootDir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--add-suite=-proposed", "--add-suite", "ubuntu:jammy-updates", "--drop-component", "universe",
+		"mypkg_bins", "mypkg_manifest"})
+	c.Assert(err, IsNil)
+	c.Assert(opened.Suites, DeepEquals, []string{"jammy", "jammy-proposed", "jammy-updates"})
+	c.Assert(opened.Components, DeepEquals, []string{"main"})
+
+	f, err := os.Open(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	build, err := mfest.Build()
+	c.Assert(err, IsNil)
+	c.Assert(build.Archives, DeepEquals, []*manifest.BuildArchive{{
+		Name:       "ubuntu",
+		Version:    "22.04",
+		Suites:     []string{"jammy", "jammy-proposed", "jammy-updates"},
+		Components: []string{"main"},
+	}})
+
+	var tests = []struct {
+		args  []string
+		error string
+	}{{
+		args:  []string{"--add-suite", "other:jammy-proposed"},
+		error: `invalid --add-suite: archive "other" not found in release`,
+	}, {
+		args:  []string{"--drop-component", "restricted"},
+		error: `cannot drop component "restricted": not used by any archive`,
+	}, {
+		args:  []string{"--drop-suite", "jammy"},
+		error: `archive "ubuntu" has no suites left`,
+	}, {
+		args:  []string{"--add-component", "ubuntu:"},
+		error: `invalid --add-component: "ubuntu:"`,
+	}}
+	for _, test := range tests {
+		args := append([]string{"cut", "--release", releaseDir, "--root", c.MkDir()}, test.args...)
+		_, err = chisel.Parser().ParseArgs(append(args, "mypkg_bins"))
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
+
+func (s *ChiselSuite) TestApplyPinsKeepsRelease(c *C) {
+	release := &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {Name: "ubuntu"},
+			"other":  {Name: "other"},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg":    {Name: "mypkg"},
+			"otherpkg": {Name: "otherpkg"},
+		},
+	}
+	mypkg := release.Packages["mypkg"]
+
+	pinned, err := chisel.ApplyPins(release, map[string]string{"mypkg": "other"})
+	c.Assert(err, IsNil)
+	c.Assert(pinned.Packages["mypkg"].Archives, DeepEquals, []string{"other"})
+	c.Assert(pinned.Packages["otherpkg"], Equals, release.Packages["otherpkg"])
+
+	// The release, which may be shared by other cuts, is left untouched.
+	c.Assert(release.Packages["mypkg"], Equals, mypkg)
+	c.Assert(mypkg.Archives, IsNil)
+}
+
+func (s *ChiselSuite) TestCutTimings(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+	var logs bytes.Buffer
+	chisel.SetLogger(log.New(&logs, "", 0))
+	defer chisel.SetLogger(nil)
+
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--timings", "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(logs.String(), Matches, `(?s)Timings:
+  release +\S+
+  select +\S+
+  archives +\S+
+  fetch +\S+
+  verify +\S+
+  extract +\S+
+  mutate +\S+
+  generate +\S+
+  manifest +\S+
+  total +\S+
+Downloaded: 0 bytes
+Cache hits: none
+`)
+}
+
+func (s *ChiselSuite) TestCutTraces(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v1/traces")
+		c.Check(json.NewDecoder(r.Body).Decode(&request), IsNil)
+	}))
+	defer server.Close()
+	for name, value := range map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL,
+		"TRACEPARENT":                 "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(), "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	c.Assert(request.ResourceSpans, HasLen, 1)
+	c.Assert(request.ResourceSpans[0].ScopeSpans, HasLen, 1)
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	parents := make(map[string]string)
+	ids := make(map[string]string)
+	for _, span := range spans {
+		c.Assert(span.TraceID, Equals, "0af7651916cd43dd8448eb211c80319c")
+		ids[span.Name] = span.SpanID
+		parents[span.Name] = span.ParentSpanID
+	}
+	c.Assert(parents["cut"], Equals, "b7ad6b7169203331")
+	for _, name := range []string{"resolve", "fetch", "verify", "extract", "mutate", "generate", "manifest"} {
+		c.Assert(parents[name], Equals, ids["cut"], Commentf("span %s", name))
+	}
+	c.Assert(parents["fetch-package"], Equals, ids["fetch"])
+}
+
+func (s *ChiselSuite) TestCutEmbeddedRelease(c *C) {
+	_, _, restore := fakeCutRelease(c)
+	defer restore()
+	fsys := fstest.MapFS{}
+	for path, data := range cutRelease {
+		fsys[path] = &fstest.MapFile{Data: testutil.Reindent(data), Mode: 0644}
+	}
+	release.Embed("test-cut", fsys)
+
+	for range 2 {
+		rootDir := c.MkDir()
+		_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", "embedded:test-cut", "--root", rootDir, "mypkg_bins"})
+		c.Assert(err, IsNil)
+		c.Assert(testutil.TreeDump(rootDir)["/usr/bin/app"], Equals, "file 0755 a172cedc")
+	}
+	dirs, err := filepath.Glob(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "chisel/releases/embedded/test-cut-*"))
+	c.Assert(err, IsNil)
+	c.Assert(dirs, HasLen, 1)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", "embedded:missing", "--root", c.MkDir(), "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `no embedded release "missing", embedded releases: .*test-cut.*`)
+}
+
+func (s *ChiselSuite) TestCutTimeout(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	// Nothing is left in empty roots.
+	parentDir := c.MkDir()
+	rootDir := filepath.Join(parentDir, "root")
+	err := os.Mkdir(rootDir, 0755)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--timeout", "1ns", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cut timed out after 1ns")
+	c.Assert(testutil.TreeDump(parentDir), DeepEquals, map[string]string{
+		"/root/": "dir 0755",
+	})
+
+	// Roots which had content are reported as incomplete.
+	err = os.WriteFile(filepath.Join(rootDir, "file"), nil, 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--timeout", "1ns", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cut timed out after 1ns, content in .* is incomplete")
+
+	outputPath := filepath.Join(c.MkDir(), "rootfs.tar")
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--output", outputPath,
+		"--timeout", "1ns", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cut timed out after 1ns")
+	_, err = os.Stat(outputPath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+// cancellingArchive cancels the cut once the package is closed, after its
+// extraction.
+type cancellingArchive struct {
+	*testutil.TestArchive
+	pkgName string
+	cancel  func()
+}
+
+func (a cancellingArchive) Fetch(pkgName string) (io.ReadSeekCloser, *archive.PackageInfo, error) {
+	reader, info, err := a.TestArchive.Fetch(pkgName)
+	if err == nil && pkgName == a.pkgName {
+		reader = &cancellingReader{reader, a.cancel}
+	}
+	return reader, info, err
+}
+
+type cancellingReader struct {
+	io.ReadSeekCloser
+	cancel func()
+}
+
+func (r *cancellingReader) Close() error {
+	r.cancel()
+	return r.ReadSeekCloser.Close()
+}
+
+func (s *ChiselSuite) TestCutResume(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	err := os.WriteFile(filepath.Join(releaseDir, "slices/otherpkg.yaml"), testutil.Reindent(`
+		package: otherpkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/other:
+	`), 0644)
+	c.Assert(err, IsNil)
+	testArchive.Packages["otherpkg"] = &testutil.TestPackage{
+		Name:    "otherpkg",
+		Version: "1.0",
+		Hash:    "4d9ba5bd8f0e5a6d3e2c1b0a9f8e7d6c5b4a39281706f5e4d3c2b1a098f7e6d5",
+		Arch:    "amd64",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/other", "other"),
+		}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelPkg := "mypkg"
+	defer chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return cancellingArchive{testArchive, cancelPkg, cancel}, nil
+	})()
+
+	// The cut is interrupted once mypkg is extracted, and nothing is left
+	// next to the root.
+	parentDir := c.MkDir()
+	rootDir := filepath.Join(parentDir, "root")
+	args := []string{"--release", releaseDir, "--root", rootDir, "mypkg_bins", "otherpkg_bins"}
+	err = chisel.RunCut(ctx, args)
+	c.Assert(err, ErrorMatches, "cut cancelled")
+	c.Assert(testutil.TreeDump(parentDir), DeepEquals, map[string]string{})
+
+	// Running the same cut again keeps the content of mypkg instead of
+	// extracting it again.
+	cancelPkg = ""
+	testArchive.Packages["mypkg"].Data = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", "extracted again"),
+	})
+	err = chisel.RunCut(context.Background(), args)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(rootDir), DeepEquals, map[string]string{
+		"/usr/":          "dir 0755",
+		"/usr/bin/":      "dir 0755",
+		"/usr/bin/app":   "file 0755 a172cedc",
+		"/usr/bin/other": "file 0755 d9298a10",
+	})
+
+	// Once done, the cut is not resumed anymore.
+	err = os.RemoveAll(rootDir)
+	c.Assert(err, IsNil)
+	err = chisel.RunCut(context.Background(), args)
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(filepath.Join(rootDir, "usr/bin/app"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "extracted again")
+}
+
+func (s *ChiselSuite) TestCutLockedRoot(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	rootDir := filepath.Join(c.MkDir(), "root")
+	unlock, err := chisel.LockRoot(context.Background(), rootDir)
+	c.Assert(err, IsNil)
+
+	// Cuts into a root locked by another one wait for it.
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--timeout", "300ms", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cut timed out after 300ms")
+
+	unlock()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_bins"})
+	c.Assert(err, IsNil)
+}
+
+func (s *ChiselSuite) TestCutStagedRoot(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	// The tree is moved into place once cut.
+	parentDir := c.MkDir()
+	rootDir := filepath.Join(parentDir, "root")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(parentDir), DeepEquals, map[string]string{
+		"/root/":            "dir 0755",
+		"/root/usr/":        "dir 0755",
+		"/root/usr/bin/":    "dir 0755",
+		"/root/usr/bin/app": "file 0755 a172cedc",
+	})
+
+	// Failed cuts leave no content behind.
+	rootDir = filepath.Join(parentDir, "other")
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_missing"})
+	c.Assert(err, NotNil)
+	_, err = os.Stat(rootDir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	entries, err := os.ReadDir(parentDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	// Empty roots are replaced, keeping their permissions.
+	rootDir = filepath.Join(parentDir, "empty")
+	err = os.Mkdir(rootDir, 0750)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_config"})
+	c.Assert(err, IsNil)
+	info, err := os.Stat(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode(), Equals, os.ModeDir|0750)
+	c.Assert(testutil.TreeDump(rootDir), DeepEquals, map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/app.conf": "file 0644 0c326c4f",
+	})
+
+	// Roots with content are cut in place, keeping that content.
+	rootDir = filepath.Join(parentDir, "root")
+	err = os.WriteFile(filepath.Join(rootDir, "file"), []byte("data"), 0600)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_config"})
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(rootDir), DeepEquals, map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/app.conf": "file 0644 0c326c4f",
+		"/file":         "file 0600 3a6eb079",
+		"/usr/":         "dir 0755",
+		"/usr/bin/":     "dir 0755",
+		"/usr/bin/app":  "file 0755 a172cedc",
+	})
+
+	// Symlinked roots are cut in place, into the directory they point to.
+	targetDir := c.MkDir()
+	rootDir = filepath.Join(parentDir, "link")
+	err = os.Symlink(targetDir, rootDir)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_config"})
+	c.Assert(err, IsNil)
+	info, err = os.Lstat(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+	c.Assert(testutil.TreeDump(targetDir), DeepEquals, map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/app.conf": "file 0644 0c326c4f",
+	})
+	entries, err = os.ReadDir(parentDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+}
+
+func (s *ChiselSuite) TestCutOutput(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	outputPath := filepath.Join(c.MkDir(), "rootfs.tar")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--output", outputPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	file, err := os.Open(outputPath)
+	c.Assert(err, IsNil)
+	defer file.Close()
+	entries := make(map[string]string)
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		data, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		entries[header.Name] = fmt.Sprintf("%#o %s", header.Mode, data)
+	}
+	c.Assert(entries, DeepEquals, map[string]string{
+		"./usr/":        "0755 ",
+		"./usr/bin/":    "0755 ",
+		"./usr/bin/app": "0755 app",
+	})
+}
+
+func (s *ChiselSuite) TestCutPolicy(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	// The policy denies version 1.1 of any package.
+	policyPath := filepath.Join(c.MkDir(), "policy")
+	logPath := filepath.Join(c.MkDir(), "log")
+	err := os.WriteFile(policyPath, []byte(`#!/bin/sh
+input=$(cat)
+echo "$1 $input" >> `+logPath+`
+case "$input" in
+*'"version":"1.1"'*)
+	echo "version 1.1 is banned"
+	exit 1
+	;;
+esac
+`), 0755)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--policy", policyPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(logPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ``+
+		`selection {"slices":["mypkg_bins"]}`+"\n"+
+		`package {"archive":"ubuntu","name":"mypkg","version":"1.0","arch":"amd64","sha256":"c2b7b0eb1bb4a5f7b2a4a7ffa4d1e2a2a8b1c47f2a2e2d6d6b0f2ab6a7f2b4a1"}`+"\n"+
+		`licenses {"package":"mypkg","licenses":[]}`+"\n")
+
+	testArchive.Packages["mypkg"].Version = "1.1"
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--policy", policyPath, "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "policy denies package mypkg: version 1.1 is banned")
+	entries, err := os.ReadDir(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ChiselSuite) TestCutGenerator(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	generatorPath := filepath.Join(c.MkDir(), "generator")
+	err := os.WriteFile(generatorPath, []byte("#!/bin/sh\nprintf uninitialized > \"$3/machine-id\"\n"), 0755)
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--generator", "x-machine-id=" + generatorPath, "mypkg_machine"})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(filepath.Join(rootDir, "etc/machine/machine-id"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "uninitialized")
+}
+
+func (s *ChiselSuite) TestCutInstallDebSliceNames(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	debPath := filepath.Join(c.MkDir(), "mypkg.deb")
+	data := testutil.MustMakeDebWithControl("Package: mypkg\nVersion: 1.0\nArchitecture: amd64\n", nil)
+	err := os.WriteFile(debPath, data, 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--install-deb", debPath + ":bins,Bad"})
+	c.Assert(err, ErrorMatches, `invalid --install-deb ".*/mypkg.deb:bins,Bad": .*`)
+	c.Assert(chisel.ExitCode(err), Equals, 2)
+}
+
+func (s *ChiselSuite) TestCutCopy(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	sourcePath := filepath.Join(c.MkDir(), "hello")
+	err := os.WriteFile(sourcePath, []byte("hello"), 0600)
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--copy", sourcePath + ":/usr/bin/hello:0755", "--copy", sourcePath + ":/etc/hello.conf",
+		"mypkg_bins", "mypkg_manifest"})
+	c.Assert(err, IsNil)
+
+	info, err := os.Stat(filepath.Join(rootDir, "usr/bin/hello"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0755))
+	info, err = os.Stat(filepath.Join(rootDir, "etc/hello.conf"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0644))
+
+	f, err := os.Open(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	var hostPaths []string
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		if path.HostSource != "" {
+			c.Assert(path.HostSource, Equals, sourcePath)
+			c.Assert(path.SHA256, Equals, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+			c.Assert(path.Slices, HasLen, 0)
+			hostPaths = append(hostPaths, path.Path)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hostPaths, DeepEquals, []string{"/etc/hello.conf", "/usr/bin/hello"})
+
+	for _, test := range []struct{ ref, err string }{
+		{"hello", `invalid --copy "hello": must be <file>:<path>\[:<mode>\]`},
+		{":/usr/bin/hello", `invalid --copy ":/usr/bin/hello": must be <file>:<path>\[:<mode>\]`},
+		{"hello:/usr/../hello", `invalid --copy "hello:/usr/../hello": invalid path "/usr/../hello"`},
+		{"hello:/usr/bin/hello:999", `invalid --copy "hello:/usr/bin/hello:999": invalid mode "999"`},
+	} {
+		_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+			"--copy", test.ref, "mypkg_bins"})
+		c.Assert(err, ErrorMatches, test.err)
+		c.Assert(chisel.ExitCode(err), Equals, 2)
+	}
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--copy", sourcePath + ":/usr/bin/app", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, `cannot copy host file to /usr/bin/app: path already exists in the tree`)
+}
+
+func (s *ChiselSuite) TestCutReflink(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	cacheDir := c.MkDir()
+	oldCacheHome := os.Getenv("XDG_CACHE_HOME")
+	s.AddCleanup(func() { os.Setenv("XDG_CACHE_HOME", oldCacheHome) })
+	os.Setenv("XDG_CACHE_HOME", cacheDir)
+
+	for i := 0; i < 2; i++ {
+		rootDir := c.MkDir()
+		_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+			"--reflink", "mypkg_bins", "mypkg_config"})
+		c.Assert(err, IsNil)
+		data, err := os.ReadFile(filepath.Join(rootDir, "usr/bin/app"))
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "app")
+		data, err = os.ReadFile(filepath.Join(rootDir, "etc/app.conf"))
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "conf")
+	}
+	_, err := os.Stat(filepath.Join(cacheDir, "chisel", "unpacked", testArchive.Packages["mypkg"].Hash))
+	c.Assert(err, IsNil)
+}
+
+func (s *ChiselSuite) TestCutDedup(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	sourcePath := filepath.Join(c.MkDir(), "app")
+	err := os.WriteFile(sourcePath, []byte("app"), 0600)
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"--copy", sourcePath + ":/usr/bin/app-copy:0755", "--dedup", "hardlink", "mypkg_bins", "mypkg_manifest"})
+	c.Assert(err, IsNil)
+
+	info, err := os.Stat(filepath.Join(rootDir, "usr/bin/app"))
+	c.Assert(err, IsNil)
+	copyInfo, err := os.Stat(filepath.Join(rootDir, "usr/bin/app-copy"))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(info, copyInfo), Equals, true)
+
+	f, err := os.Open(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	build, err := mfest.Build()
+	c.Assert(err, IsNil)
+	c.Assert(build.Dedup, Equals, "hardlink")
+	inodes := make(map[string]uint64)
+	err = mfest.IteratePaths("/usr/bin/", func(path *manifest.Path) error {
+		inodes[path.Path] = path.Inode
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(inodes, DeepEquals, map[string]uint64{
+		"/usr/bin/app":      1,
+		"/usr/bin/app-copy": 1,
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/metrics"
+)
+
+var shortDaemonHelp = "Serve cut requests over a Unix socket"
+var longDaemonHelp = `
+The daemon command serves cut requests from many clients, such as the jobs
+of a build farm, over the Unix socket given with --socket. Each connection
+sends requests and receives their events in the same format as the
+serve-build command, and may send any number of requests.
+
+Releases are parsed and archive indexes fetched only once, and kept in
+memory for the duration given with --cache-ttl, one hour by default, so
+that cuts do not repeat that work while updates to the archives are still
+seen eventually. Cuts run one at a time, each with its own options and
+root, and the events of each one are only sent to the client requesting it.
+
+With --metrics-address, such as "localhost:9100", the metrics of the cuts
+run are served over HTTP at /metrics in the Prometheus text format: the
+bytes downloaded, the cache hits and misses, the cuts run and failed, and
+the seconds spent in each phase of the cuts.
+
+The socket is only accessible to the user running the daemon. The daemon
+stops on SIGINT or SIGTERM, cancelling the cut running at the time along
+with its downloads, so that its request fails.
+`
+
+var daemonDescs = map[string]string{
+	"socket":          "Path of the Unix socket to listen on",
+	"cache-ttl":       "How long to keep releases and archive indexes",
+	"metrics-address": "Serve the metrics over HTTP on the address",
+}
+
+const defaultDaemonCacheTTL = time.Hour
+
+type cmdDaemon struct {
+	Socket   string        `long:"socket" value-name:"<path>" required:"yes"`
+	CacheTTL time.Duration `long:"cache-ttl" value-name:"<duration>"`
+
+	MetricsAddress string `long:"metrics-address" value-name:"<address>"`
+}
+
+func init() {
+	addCommand("daemon", shortDaemonHelp, longDaemonHelp, func() flags.Commander { return &cmdDaemon{} }, daemonDescs, nil)
+}
+
+func (cmd *cmdDaemon) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if cmd.CacheTTL < 0 {
+		return usageErrorf("invalid --cache-ttl: %s", cmd.CacheTTL)
+	}
+	ttl := cmd.CacheTTL
+	if ttl == 0 {
+		ttl = defaultDaemonCacheTTL
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := listenUnix(cmd.Socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	if cmd.MetricsAddress != "" {
+		metricsListener, err := net.Listen("tcp", cmd.MetricsAddress)
+		if err != nil {
+			return fmt.Errorf("cannot listen on %s: %w", cmd.MetricsAddress, err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(serveMetrics)}
+		go server.Serve(metricsListener)
+		defer server.Close()
+		logf("Serving metrics on http://%s/metrics...", metricsListener.Addr())
+	}
+
+	activeCache = newBuildCache(ttl)
+	defer func() {
+		activeCache.close()
+		activeCache = nil
+	}()
+
+	logf("Serving cut requests on %s...", cmd.Socket)
+	server := &buildServer{ctx: ctx}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	conns := make(map[net.Conn]bool)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("cannot accept connection: %w", err)
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.serve(conn, conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+
+	// Clients still connected are waiting for their next request.
+	mu.Lock()
+	for conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+	wg.Wait()
+	return nil
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WritePrometheus(w, metrics.Take())
+}
+
+// listenUnix listens on the Unix socket at path, only accessible to the
+// current user. A socket left by a daemon which is not running anymore is
+// replaced.
+func listenUnix(path string) (net.Listener, error) {
+	if _, err := os.Lstat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot listen on %s: socket in use", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen on %s: %w", path, err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %w", path, err)
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot listen on %s: %w", path, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,149 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+// closingArchive counts the times the archive is closed.
+type closingArchive struct {
+	*testutil.TestArchive
+	closed *int
+}
+
+func (a closingArchive) Close() error {
+	*a.closed++
+	return nil
+}
+
+func (s *ChiselSuite) TestDaemon(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	opened := 0
+	closed := 0
+	defer chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened++
+		return closingArchive{testArchive, &closed}, nil
+	})()
+
+	socket := filepath.Join(c.MkDir(), "chisel.sock")
+	conn, done := startDaemon(c, "--socket", socket)
+	defer conn.Close()
+	info, err := os.Stat(socket)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+
+	// Requests on the same connection share the release and archives.
+	reader := bufio.NewReader(conn)
+	for _, id := range []string{"1", "2"} {
+		requestDaemonCut(c, conn, reader, id, releaseDir)
+	}
+	c.Assert(opened, Equals, 1)
+	c.Assert(closed, Equals, 0)
+	// The downloads of the archives followed the context of the last
+	// request, which is done.
+	c.Assert(testArchive.Opts.Context, NotNil)
+	c.Assert(testArchive.Opts.Context.Err(), NotNil)
+
+	// A second daemon cannot take over the socket.
+	_, err = chisel.Parser().ParseArgs([]string{"daemon", "--socket", socket})
+	c.Assert(err, ErrorMatches, `cannot listen on .*: socket in use`)
+
+	stopDaemon(c, done)
+	_, err = os.Stat(socket)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	// The archives are closed once the daemon stops.
+	c.Assert(closed, Equals, 1)
+}
+
+func (s *ChiselSuite) TestDaemonCacheTTL(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	opened := 0
+	closed := 0
+	defer chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened++
+		return closingArchive{testArchive, &closed}, nil
+	})()
+
+	socket := filepath.Join(c.MkDir(), "chisel.sock")
+	conn, done := startDaemon(c, "--socket", socket, "--cache-ttl", "1ns")
+	defer conn.Close()
+
+	// The archives which expired are closed once replaced.
+	reader := bufio.NewReader(conn)
+	for _, id := range []string{"1", "2"} {
+		requestDaemonCut(c, conn, reader, id, releaseDir)
+	}
+	c.Assert(opened, Equals, 2)
+	c.Assert(closed, Equals, 1)
+
+	stopDaemon(c, done)
+	c.Assert(closed, Equals, 2)
+}
+
+// startDaemon runs the daemon command with args, and returns a connection
+// to it along with the channel receiving its result.
+func startDaemon(c *C, args ...string) (net.Conn, chan error) {
+	socket := args[slices.Index(args, "--socket")+1]
+	done := make(chan error, 1)
+	go func() {
+		_, err := chisel.Parser().ParseArgs(append([]string{"daemon"}, args...))
+		done <- err
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		conn, err = net.Dial("unix", socket)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	return conn, done
+}
+
+// requestDaemonCut requests the daemon to cut mypkg_bins, and checks that
+// it succeeds.
+func requestDaemonCut(c *C, conn net.Conn, reader *bufio.Reader, id, releaseDir string) {
+	rootDir := filepath.Join(c.MkDir(), "root")
+	_, err := conn.Write([]byte(`{"id": "` + id + `", "selection": {"release": "` + releaseDir +
+		`", "slices": ["mypkg_bins"], "output": {"root": "` + rootDir + `"}}}` + "\n"))
+	c.Assert(err, IsNil)
+	var event buildEvent
+	for event.Event == "" || event.Event == "log" {
+		line, err := reader.ReadBytes('\n')
+		c.Assert(err, IsNil)
+		event = buildEvent{}
+		c.Assert(json.Unmarshal(line, &event), IsNil)
+	}
+	c.Assert(event, DeepEquals, buildEvent{ID: id, Event: "done", Metrics: &buildMetrics{Cuts: 1}})
+	_, err = os.Stat(filepath.Join(rootDir, "usr/bin/app"))
+	c.Assert(err, IsNil)
+}
+
+// stopDaemon interrupts the daemon and waits for it to stop.
+func stopDaemon(c *C, done chan error) {
+	p, err := os.FindProcess(os.Getpid())
+	c.Assert(err, IsNil)
+	c.Assert(p.Signal(os.Interrupt), IsNil)
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("daemon did not stop")
+	}
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var shortExecHelp = "Run a command inside a cut tree"
+var longExecHelp = `
+The exec command runs a command inside a tree cut earlier, for quick smoke
+tests of freshly cut images:
+
+    chisel exec --root out/ -- /usr/bin/python3 --version
+
+Commands given without a path are looked up in the usual directories of
+the tree, and run with the root of the tree as their root directory and
+working directory. The environment only holds PATH and the variables
+given with --env, which may be repeated.
+
+When run as root, the tree is entered with chroot, and the /proc of the
+host is mounted within it for the duration of the command if the tree has
+a /proc directory. Otherwise, the command runs in a new user namespace
+where the current user is mapped to root, without /proc. Commands which do
+not need /proc run the same way in both cases.
+
+Commands built for another architecture than the one of the host, such as
+arm64 trees on amd64 machines, are run with the qemu user emulator of that
+architecture. If binfmt_misc has an entry for the emulator, the commands run
+by the command are emulated too, otherwise only the command itself is. The
+emulator is copied into the tree for the duration of the command when
+needed, from the binfmt_misc entry, the path given with --emulator, or the
+qemu-<arch>-static command found in PATH.
+
+The exec command exits with the exit status of the command run.
+`
+
+var execDescs = map[string]string{
+	"root":     "Root of the tree to run the command in",
+	"env":      "Set the environment variable of the command",
+	"emulator": "Path of the qemu user emulator for foreign binaries",
+}
+
+var execArgDescs = []argDesc{{
+	name: "<command>",
+	desc: "Command to run and its arguments",
+}}
+
+type cmdExec struct {
+	RootDir  string   `long:"root" value-name:"<dir>" required:"yes"`
+	Env      []string `long:"env" value-name:"<name>=<value>"`
+	Emulator string   `long:"emulator" value-name:"<path>"`
+
+	Positional struct {
+		Command []string `positional-arg-name:"<command>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("exec", shortExecHelp, longExecHelp, func() flags.Commander { return &cmdExec{} }, execDescs, execArgDescs)
+	addHelpCommand("Action", "exec")
+}
+
+// execPath lists the directories commands are looked up in within the
+// tree.
+var execPath = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+func (cmd *cmdExec) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	rootDir, err := filepath.Abs(cmd.RootDir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		return fmt.Errorf("cannot use root: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot use root: %s is not a directory", cmd.RootDir)
+	}
+
+	env := []string{"PATH=" + strings.Join(execPath, ":")}
+	for _, entry := range cmd.Env {
+		name, _, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return usageErrorf("invalid --env %q: must be <name>=<value>", entry)
+		}
+		env = append(env, entry)
+	}
+
+	command := cmd.Positional.Command
+	name, err := lookCommand(rootDir, command[0])
+	if err != nil {
+		return err
+	}
+	emulation, err := prepareEmulation(rootDir, name, cmd.Emulator)
+	if err != nil {
+		return err
+	}
+	runPath, runArgs := name, command
+	if emulation != nil {
+		defer emulation.cleanup(rootDir)
+		runPath, runArgs = emulation.wrap(name, command)
+	}
+	run := &exec.Cmd{
+		Path:   runPath,
+		Args:   runArgs,
+		Dir:    "/",
+		Env:    env,
+		Stdin:  os.Stdin,
+		Stdout: Stdout,
+		Stderr: Stderr,
+	}
+
+	if os.Geteuid() == 0 {
+		run.SysProcAttr = &syscall.SysProcAttr{Chroot: rootDir}
+		err = runWithProc(rootDir, run)
+	} else {
+		debugf("Running %s in a user namespace, without /proc", name)
+		run.SysProcAttr = &syscall.SysProcAttr{
+			Chroot:     rootDir,
+			Cloneflags: syscall.CLONE_NEWUSER,
+			UidMappings: []syscall.SysProcIDMap{{
+				ContainerID: 0,
+				HostID:      os.Getuid(),
+				Size:        1,
+			}},
+			GidMappings: []syscall.SysProcIDMap{{
+				ContainerID: 0,
+				HostID:      os.Getgid(),
+				Size:        1,
+			}},
+		}
+		err = run.Run()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if code < 0 {
+			// Killed by a signal, reported as shells do.
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				code = 128 + int(status.Signal())
+			} else {
+				code = exitFailure
+			}
+		}
+		panic(&exitStatus{code})
+	} else if err != nil {
+		return fmt.Errorf("cannot run %s: %w", command[0], err)
+	}
+	return nil
+}
+
+// lookCommand returns the path of the command within the tree, looking it
+// up in the directories of execPath if it has no slashes.
+func lookCommand(rootDir, name string) (string, error) {
+	if strings.Contains(name, "/") {
+		if _, err := resolveInTree(rootDir, name); err != nil {
+			return "", fmt.Errorf("cannot find %s in %s", name, rootDir)
+		}
+		return name, nil
+	}
+	for _, dir := range execPath {
+		realPath, err := resolveInTree(rootDir, path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(rootDir, realPath))
+		if err == nil && !info.IsDir() {
+			return path.Join(dir, name), nil
+		}
+	}
+	return "", fmt.Errorf("cannot find %s in %s", name, rootDir)
+}
+
+// runWithProc runs the command with the /proc of the host mounted in the
+// /proc directory of the tree, if it has one. The mount is done in a
+// private mount namespace for the command, so that it disappears with it.
+func runWithProc(rootDir string, run *exec.Cmd) error {
+	procDir := filepath.Join(rootDir, "proc")
+	if info, err := os.Lstat(procDir); err != nil || !info.IsDir() {
+		debugf("Running %s without /proc, as the tree has no /proc directory", run.Path)
+		return run.Run()
+	}
+
+	done := make(chan error)
+	go func() {
+		// The thread is left locked so that it is discarded with its mount
+		// namespace once the goroutine ends.
+		runtime.LockOSThread()
+		err := syscall.Unshare(syscall.CLONE_NEWNS)
+		if err == nil {
+			err = syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+		}
+		if err == nil {
+			err = syscall.Mount("/proc", procDir, "", syscall.MS_BIND|syscall.MS_REC, "")
+		}
+		if err != nil {
+			logf("Warning: Running %s without /proc: %s", run.Path, err)
+		}
+		done <- run.Run()
+	}()
+	return <-done
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package main_test
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var execTests = []struct {
+	summary string
+	args    []string
+	error   string
+}{{
+	summary: "Root must exist",
+	args:    []string{"--root", "/non-existent", "--", "true"},
+	error:   `cannot use root: stat /non-existent: no such file or directory`,
+}, {
+	summary: "Command must be in the tree",
+	args:    []string{"--root", "<root>", "--", "true"},
+	error:   `cannot find true in <root>`,
+}, {
+	summary: "Command with a path must be in the tree",
+	args:    []string{"--root", "<root>", "--", "/usr/bin/true"},
+	error:   `cannot find /usr/bin/true in <root>`,
+}, {
+	summary: "Environment variables must have a name",
+	args:    []string{"--root", "<root>", "--env", "=value", "--", "true"},
+	error:   `invalid --env "=value": must be <name>=<value>`,
+}}
+
+func (s *ChiselSuite) TestExecErrors(c *C) {
+	for _, test := range execTests {
+		c.Logf("Summary: %s", test.summary)
+		rootDir := c.MkDir()
+		args := []string{"exec"}
+		for _, arg := range test.args {
+			args = append(args, strings.ReplaceAll(arg, "<root>", rootDir))
+		}
+		_, err := chisel.Parser().ParseArgs(args)
+		c.Assert(err, ErrorMatches, strings.ReplaceAll(test.error, "<root>", rootDir))
+	}
+}
+
+// staticCommand returns the path of a statically linked command of the
+// host which may run in an empty tree, or skips the test.
+func staticCommand(c *C) string {
+	if os.Geteuid() != 0 {
+		c.Skip("exec tests require root privileges")
+	}
+	for _, path := range []string{"/usr/sbin/ldconfig.real", "/sbin/ldconfig.real", "/usr/sbin/ldconfig", "/sbin/ldconfig"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	c.Skip("cannot find a statically linked command")
+	return ""
+}
+
+func (s *ChiselSuite) TestExec(c *C) {
+	command := staticCommand(c)
+	rootDir := c.MkDir()
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/sbin"), 0755)
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(command)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(rootDir, "usr/sbin/ldconfig"), data, 0755)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"exec", "--root", rootDir, "--", "ldconfig", "--version"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Matches, `(?s)ldconfig .*`)
+
+	func() {
+		defer func() {
+			code, ok := chisel.ExitStatusCode(recover())
+			c.Assert(ok, Equals, true)
+			c.Assert(code, Not(Equals), 0)
+		}()
+		chisel.Parser().ParseArgs([]string{"exec", "--root", rootDir, "--", "/usr/sbin/ldconfig", "--bogus"})
+	}()
+}
+
+var execEmulationTests = []struct {
+	summary string
+	files   map[string][]byte
+	args    []string
+	error   string
+}{{
+	summary: "Foreign binaries need an emulator",
+	files: map[string][]byte{
+		"/usr/bin/app": testutil.MakeELF(elf.EM_AARCH64, ""),
+	},
+	args:  []string{"app"},
+	error: `cannot run arm64 binaries: qemu-aarch64-static not found, install qemu-user-static or use --emulator`,
+}, {
+	summary: "Foreign binaries are found through symlinks within the tree",
+	files: map[string][]byte{
+		"/usr/lib/app/app": testutil.MakeELF(elf.EM_AARCH64, ""),
+		"/usr/bin/app":     []byte("symlink:/usr/lib/app/app"),
+	},
+	args:  []string{"/usr/bin/app"},
+	error: `cannot run arm64 binaries: qemu-aarch64-static not found, install qemu-user-static or use --emulator`,
+}, {
+	summary: "Scripts have the architecture of their interpreter",
+	files: map[string][]byte{
+		"/usr/bin/app":    testutil.MakeELF(elf.EM_RISCV, ""),
+		"/usr/bin/script": []byte("#!/usr/bin/app -x\n"),
+	},
+	args:  []string{"script"},
+	error: `cannot run riscv64 binaries: qemu-riscv64-static not found, install qemu-user-static or use --emulator`,
+}, {
+	summary: "Binaries of unknown architectures cannot be emulated",
+	files: map[string][]byte{
+		"/usr/bin/app": testutil.MakeELF(elf.EM_MIPS, ""),
+	},
+	args:  []string{"app"},
+	error: `cannot run MIPS binaries on amd64`,
+}}
+
+func writeExecTree(c *C, rootDir string, files map[string][]byte) {
+	for filePath, data := range files {
+		fullPath := filepath.Join(rootDir, filePath)
+		err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+		c.Assert(err, IsNil)
+		if target, ok := strings.CutPrefix(string(data), "symlink:"); ok {
+			err = os.Symlink(target, fullPath)
+		} else {
+			err = os.WriteFile(fullPath, data, 0755)
+		}
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *ChiselSuite) TestExecEmulation(c *C) {
+	restore := chisel.FakeEmulation("amd64", c.MkDir())
+	defer restore()
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", c.MkDir())
+
+	for _, test := range execEmulationTests {
+		c.Logf("Summary: %s", test.summary)
+		rootDir := c.MkDir()
+		writeExecTree(c, rootDir, test.files)
+		args := append([]string{"exec", "--root", rootDir, "--"}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
+
+func (s *ChiselSuite) TestExecEmulator(c *C) {
+	command := staticCommand(c)
+	restore := chisel.FakeEmulation("amd64", c.MkDir())
+	defer restore()
+
+	// The emulator is faked with a command which rejects the options given
+	// to qemu, proving that it ran in the tree.
+	rootDir := c.MkDir()
+	writeExecTree(c, rootDir, map[string][]byte{
+		"/bin/app": testutil.MakeELF(elf.EM_AARCH64, ""),
+	})
+	args := []string{"exec", "--root", rootDir, "--emulator", command, "--", "app"}
+	func() {
+		defer func() {
+			code, ok := chisel.ExitStatusCode(recover())
+			c.Assert(ok, Equals, true)
+			c.Assert(code, Not(Equals), 0)
+		}()
+		chisel.Parser().ParseArgs(args)
+	}()
+	c.Assert(s.Stderr(), Matches, `(?s)/usr/bin/qemu-aarch64-static: invalid option.*`)
+
+	// The emulator and the directories created for it are removed.
+	entries, err := os.ReadDir(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Name(), Equals, "bin")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortExtractHelp = "Extract paths from a single package"
+var longExtractHelp = `
+The extract command fetches a package from the archives of the release and
+extracts its paths matching the --path options into the --to directory,
+without the need for slice definitions. It is meant for exploring the
+content of packages while writing their slices.
+
+The --path option takes an absolute path which may hold "*", "?", "**" and
+"[...]" wildcards, as in "/usr/lib/**", and may be repeated. All the content of the
+package is extracted when no path is given.
+
+The package is fetched from the archive it is pinned to in the release, if
+any, and otherwise from the archive with the highest priority holding it.
+
+The --http-timeout, --http-bulk-timeout, --http-header, --user-agent,
+--http-chunk-threshold and --http-chunk-size options configure the requests
+made to the archives, as with cut.
+
+By default it fetches the package for the same Ubuntu version as the
+current host, unless the --release flag is used.
+`
+
+var extractDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+	"path":    "Extract the paths matching the pattern",
+	"to":      "Directory to extract the paths into",
+	"refresh": "Download the archive indexes again",
+}
+
+var extractArgDescs = []argDesc{{
+	name: "<package>",
+	desc: "Name of the package to extract",
+}}
+
+type cmdExtract struct {
+	Release string   `long:"release" value-name:"<branch|dir>"`
+	Arch    string   `long:"arch" value-name:"<arch>"`
+	Paths   []string `long:"path" value-name:"<pattern>"`
+	To      string   `long:"to" value-name:"<dir>" required:"yes"`
+	Refresh bool     `long:"refresh"`
+
+	httpFlags
+
+	Positional struct {
+		Package string `positional-arg-name:"<package>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("extract", shortExtractHelp, longExtractHelp, func() flags.Commander { return &cmdExtract{} }, withHTTPDescs(extractDescs), extractArgDescs)
+}
+
+func (cmd *cmdExtract) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		paths = []string{"/**"}
+	}
+	extract := make(map[string][]deb.ExtractInfo)
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return usageErrorf("path must be absolute: %s", path)
+		}
+		extract[path] = []deb.ExtractInfo{{Path: path}}
+	}
+
+	httpOverride, err := cmd.httpFlags.options()
+	if err != nil {
+		return err
+	}
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+	archives, err := openArchives(context.Background(), release, cmd.Arch, cmd.Refresh, httpOverride)
+	if err != nil {
+		return err
+	}
+	pkgName := cmd.Positional.Package
+	pkgArchive, err := findPackageArchive(release, archives, pkgName)
+	if err != nil {
+		return err
+	}
+
+	reader, info, err := pkgArchive.Fetch(pkgName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	err = os.MkdirAll(cmd.To, 0755)
+	if err != nil {
+		return err
+	}
+	logf("Extracting %s %s...", info.Name, info.Version)
+	return deb.Extract(reader, &deb.ExtractOptions{
+		Package:   pkgName,
+		TargetDir: cmd.To,
+		Extract:   extract,
+	})
+}
+
+// findPackageArchive returns the archive holding the package which a cut
+// would fetch it from, as resolved by slicer.ResolveArchive.
+func findPackageArchive(release *setup.Release, archives map[string]archive.Archive, pkgName string) (archive.Archive, error) {
+	pkgArchive, err := slicer.ResolveArchive(release, archives, pkgName)
+	if err != nil || pkgArchive != nil {
+		return pkgArchive, err
+	}
+	if pkg, ok := release.Packages[pkgName]; ok && len(pkg.Archives) > 0 {
+		return nil, fmt.Errorf("cannot find package %q in archive(s) %s", pkgName, strings.Join(pkg.Archives, ", "))
+	}
+	return nil, fmt.Errorf("cannot find package %q in archives", pkgName)
+}
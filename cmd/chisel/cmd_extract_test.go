@@ -0,0 +1,48 @@
+package main_test
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestExtract(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	targetDir := filepath.Join(c.MkDir(), "out")
+	_, err := chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--to", targetDir,
+		"--path", "/usr/bin/**", "mypkg"})
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(targetDir), DeepEquals, map[string]string{
+		"/usr/":        "dir 0755",
+		"/usr/bin/":    "dir 0755",
+		"/usr/bin/app": "file 0755 a172cedc",
+	})
+
+	// All the content is extracted by default.
+	targetDir = c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--to", targetDir, "mypkg"})
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(targetDir), DeepEquals, map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/app.conf": "file 0644 0c326c4f",
+		"/usr/":         "dir 0755",
+		"/usr/bin/":     "dir 0755",
+		"/usr/bin/app":  "file 0755 a172cedc",
+	})
+
+	_, err = chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--to", c.MkDir(),
+		"--path", "/missing", "mypkg"})
+	c.Assert(err, ErrorMatches, `cannot extract from package "mypkg": no content at /missing`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--to", c.MkDir(),
+		"--path", "usr/bin/app", "mypkg"})
+	c.Assert(err, ErrorMatches, `path must be absolute: usr/bin/app`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--to", c.MkDir(), "otherpkg"})
+	c.Assert(err, ErrorMatches, `cannot find package "otherpkg" in archives`)
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/jessevdk/go-flags"
 
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/strdist"
 )
@@ -17,22 +20,34 @@ var longFindHelp = `
 The find command queries the slice definitions for matching slices.
 Globs (* and ?) are allowed in the query.
 
+With --path, the contents index of the archives is queried instead for
+the packages shipping the given path, even when no slice holds it yet,
+along with the slices of those packages which do. Globs are allowed in
+the path as well. The index is fetched on first use, and read from the
+cache afterwards.
+
 By default it fetches the slices for the same Ubuntu version as the
 current host, unless the --release flag is used.
 `
 
 var findDescs = map[string]string{
 	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"path":    "Find the packages shipping the path",
+	"arch":    "Package architecture",
 }
 
 type cmdFind struct {
 	Release string `long:"release" value-name:"<branch|dir>"`
+	Path    string `long:"path" value-name:"<path>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
 
 	Positional struct {
-		Query []string `positional-arg-name:"<query>" required:"yes"`
+		Query []string `positional-arg-name:"<query>"`
 	} `positional-args:"yes"`
 }
 
+var archiveFindPath = archive.FindPath
+
 func init() {
 	addCommand("find", shortFindHelp, longFindHelp, func() flags.Commander { return &cmdFind{} }, findDescs, nil)
 }
@@ -41,11 +56,26 @@ func (cmd *cmdFind) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
+	if cmd.Path != "" {
+		if len(cmd.Positional.Query) > 0 {
+			return usageErrorf("cannot use --path with a query")
+		}
+		if !strings.HasPrefix(cmd.Path, "/") {
+			return usageErrorf("path must be absolute: %s", cmd.Path)
+		}
+	} else if len(cmd.Positional.Query) == 0 {
+		return usageErrorf("the required argument `<query>` was not provided")
+	} else if cmd.Arch != "" {
+		return usageErrorf("cannot use --arch without --path")
+	}
 
 	release, err := obtainRelease(cmd.Release)
 	if err != nil {
 		return err
 	}
+	if cmd.Path != "" {
+		return findPath(release, cmd.Path, cmd.Arch)
+	}
 
 	slices, err := findSlices(release, cmd.Positional.Query)
 	if err != nil {
@@ -59,13 +89,87 @@ func (cmd *cmdFind) Execute(args []string) error {
 	w := tabWriter()
 	fmt.Fprintf(w, "Slice\tSummary\n")
 	for _, s := range slices {
-		fmt.Fprintf(w, "%s\t%s\n", s, "-")
+		summary := s.Summary
+		if summary == "" {
+			summary = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", s, summary)
 	}
 	w.Flush()
 
 	return nil
 }
 
+// findPath writes the packages shipping the paths matching pattern, as
+// found in the contents index of the archives of the release, along with
+// the slices of the release which hold them.
+func findPath(release *setup.Release, pattern, arch string) error {
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return err
+	}
+	archiveNames := make([]string, 0, len(archives))
+	for name := range archives {
+		archiveNames = append(archiveNames, name)
+	}
+	sort.Strings(archiveNames)
+
+	var paths []string
+	packages := make(map[string][]string)
+	for _, name := range archiveNames {
+		found, err := archiveFindPath(archives[name], pattern)
+		if err != nil {
+			return err
+		}
+		for _, entry := range found {
+			if _, ok := packages[entry.Path]; !ok {
+				paths = append(paths, entry.Path)
+			}
+			packages[entry.Path] = append(packages[entry.Path], entry.Packages...)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(Stderr, "No packages ship \"%s\"\n", pattern)
+		return nil
+	}
+	sort.Strings(paths)
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Path\tPackage\tSlices\n")
+	for _, path := range paths {
+		pkgs := packages[path]
+		sort.Strings(pkgs)
+		pkgs = slices.Compact(pkgs)
+		for _, pkg := range pkgs {
+			sliceNames := pathSlices(release, pkg, path)
+			if len(sliceNames) == 0 {
+				sliceNames = []string{"-"}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", path, pkg, strings.Join(sliceNames, ","))
+		}
+	}
+	return w.Flush()
+}
+
+// pathSlices returns the names of the slices of pkg in the release which
+// hold path in their contents, either as is or matched by a glob.
+func pathSlices(release *setup.Release, pkg, path string) []string {
+	var names []string
+	if release.Packages[pkg] == nil {
+		return nil
+	}
+	for _, slice := range release.Packages[pkg].Slices {
+		for slicePath, info := range slice.Contents {
+			if slicePath == path || info.Kind == setup.GlobPath && strdist.GlobPath(slicePath, path) {
+				names = append(names, slice.String())
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 // match reports whether a slice (partially) matches the query.
 func match(slice *setup.Slice, query string) bool {
 	var term string
@@ -1,9 +1,15 @@
 package main_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
 	. "gopkg.in/check.v1"
 
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
 	"github.com/canonical/chisel/internal/testutil"
 
 	chisel "github.com/canonical/chisel/cmd/chisel"
@@ -25,10 +31,10 @@ func makeSamplePackage(pkg string, slices []string) *setup.Package {
 		}
 	}
 	return &setup.Package{
-		Name:    pkg,
-		Path:    "slices/" + pkg,
-		Archive: "ubuntu",
-		Slices:  slicesMap,
+		Name:     pkg,
+		Path:     "slices/" + pkg,
+		Archives: []string{"ubuntu"},
+		Slices:   slicesMap,
 	}
 }
 
@@ -136,3 +142,75 @@ func (s *ChiselSuite) TestFindSlices(c *C) {
 		}
 	}
 }
+
+func (s *ChiselSuite) TestFindCommand(c *C) {
+	dir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml),
+		"slices/mypkg.yaml": `
+			package: mypkg
+			slices:
+				bins:
+					summary: The main binaries
+				config:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	_, err := chisel.Parser().ParseArgs([]string{"find", "--release", dir, "mypkg"})
+	c.Assert(err, IsNil)
+	expected := string(testutil.Reindent(`
+		Slice         Summary
+		mypkg_bins    The main binaries
+		mypkg_config  -
+	`))
+	c.Assert(s.Stdout(), Equals, strings.TrimSpace(expected)+"\n")
+}
+
+func (s *ChiselSuite) TestFindPathCommand(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	restore = chisel.FakeArchiveFindPath(func(a archive.Archive, pattern string) ([]archive.PathPackages, error) {
+		c.Assert(a, Equals, testArchive)
+		var found []archive.PathPackages
+		for _, entry := range []archive.PathPackages{
+			{Path: "/usr/bin/app", Packages: []string{"otherpkg", "mypkg"}},
+			{Path: "/usr/lib/libnew.so.1", Packages: []string{"newpkg"}},
+		} {
+			if strdist.GlobPath(pattern, entry.Path) {
+				found = append(found, entry)
+			}
+		}
+		return found, nil
+	})
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"find", "--release", releaseDir, "--path", "/usr/**"})
+	c.Assert(err, IsNil)
+	expected := string(testutil.Reindent(`
+		Path                  Package   Slices
+		/usr/bin/app          mypkg     mypkg_bins,mypkg_old-bins
+		/usr/bin/app          otherpkg  -
+		/usr/lib/libnew.so.1  newpkg    -
+	`))
+	c.Assert(s.Stdout(), Equals, strings.TrimSpace(expected)+"\n")
+	s.ResetStdStreams()
+
+	_, err = chisel.Parser().ParseArgs([]string{"find", "--release", releaseDir, "--path", "/usr/bin/other"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "No packages ship \"/usr/bin/other\"\n")
+
+	_, err = chisel.Parser().ParseArgs([]string{"find", "--release", releaseDir, "--path", "/usr/bin/app", "mypkg"})
+	c.Assert(err, ErrorMatches, "cannot use --path with a query")
+	_, err = chisel.Parser().ParseArgs([]string{"find", "--release", releaseDir, "--path", "usr/bin/app"})
+	c.Assert(err, ErrorMatches, "path must be absolute: usr/bin/app")
+	_, err = chisel.Parser().ParseArgs([]string{"find", "--release", releaseDir})
+	c.Assert(err, ErrorMatches, "the required argument `<query>` was not provided")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortGraphHelp = "Show the graph of essential slices"
+var longGraphHelp = `
+The graph command shows the essentials of all the slices in the release as
+a graph in the DOT format, which may be rendered with Graphviz. Essentials
+which only apply to some architectures are labeled with them, unless --arch
+is used to show only the essentials of that architecture.
+
+With the --cycles option, the loops in the essentials are shown instead, one
+per line, with the file and line where each slice requires the next one.
+Loops prevent the release from being used, so the release is read without
+being validated. Unless --arch is used, only the essentials which apply to
+all architectures are considered, as when the release is validated.
+`
+
+var graphDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+	"cycles":  "Show the loops in the essentials",
+}
+
+type cmdGraph struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+	Cycles  bool   `long:"cycles"`
+}
+
+func init() {
+	addCommand("graph", shortGraphHelp, longGraphHelp, func() flags.Commander { return &cmdGraph{} }, graphDescs, nil)
+}
+
+func (cmd *cmdGraph) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	dir, err := obtainReleaseDir(cmd.Release)
+	if err != nil {
+		return err
+	}
+	release, err := setup.ParseRelease(dir)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Cycles {
+		cycles, err := release.EssentialCycles(cmd.Arch)
+		if err != nil {
+			return err
+		}
+		for _, links := range cycles {
+			fmt.Fprintln(Stdout, setup.FormatEssentialCycle(links))
+		}
+		return nil
+	}
+	writeEssentialGraph(release, cmd.Arch)
+	return nil
+}
+
+// writeEssentialGraph writes the essentials of all slices in the release as
+// a DOT graph. When arch is set only the essentials for it are written, and
+// otherwise the ones for particular architectures are labeled with them.
+func writeEssentialGraph(release *setup.Release, arch string) {
+	aliases := make(map[setup.SliceKey]setup.SliceKey)
+	var keys []setup.SliceKey
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			key := setup.SliceKey{Package: slice.Package, Slice: slice.Name}
+			keys = append(keys, key)
+			for _, alias := range slice.Provides {
+				aliases[alias] = key
+			}
+		}
+	}
+	slices.SortFunc(keys, func(a, b setup.SliceKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	fmt.Fprintln(Stdout, "digraph essentials {")
+	for _, key := range keys {
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		var lines []string
+		for req, info := range slice.Essential {
+			if arch != "" && len(info.Arch) > 0 && !slices.Contains(info.Arch, arch) {
+				continue
+			}
+			if provider, ok := aliases[req]; ok {
+				req = provider
+			}
+			line := fmt.Sprintf("    %q -> %q", key.String(), req.String())
+			if arch == "" && len(info.Arch) > 0 {
+				line += fmt.Sprintf(" [label=%q]", strings.Join(info.Arch, ", "))
+			}
+			lines = append(lines, line+";")
+		}
+		if len(lines) == 0 {
+			fmt.Fprintf(Stdout, "    %q;\n", key.String())
+			continue
+		}
+		slices.Sort(lines)
+		for _, line := range lines {
+			fmt.Fprintln(Stdout, line)
+		}
+	}
+	fmt.Fprintln(Stdout, "}")
+}
@@ -0,0 +1,138 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var graphRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/pkga.yaml": `
+		package: pkga
+		slices:
+			bins:
+				essential:
+					- pkga_libs
+					- slice: pkgb_libs
+					  when: arch == amd64
+			libs:
+				essential:
+					- pkgb_alias
+	`,
+	"slices/pkgb.yaml": `
+		package: pkgb
+		slices:
+			libs:
+				provides:
+					- pkgb_alias
+	`,
+}
+
+var loopRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/pkga.yaml": `
+		package: pkga
+		slices:
+			bins:
+				essential:
+					- pkga_libs
+			libs:
+				essential:
+					- pkgb_libs
+	`,
+	"slices/pkgb.yaml": `
+		package: pkgb
+		slices:
+			libs:
+				essential:
+					- pkga_bins
+					- slice: pkga_libs
+					  when: arch == arm64
+	`,
+}
+
+var graphTests = []struct {
+	summary string
+	input   map[string]string
+	args    []string
+	stdout  string
+	err     string
+}{{
+	summary: "Essential graph",
+	input:   graphRelease,
+	stdout: `
+		digraph essentials {
+			"pkga_bins" -> "pkga_libs";
+			"pkga_bins" -> "pkgb_libs" [label="amd64"];
+			"pkga_libs" -> "pkgb_libs";
+			"pkgb_libs";
+		}
+	`,
+}, {
+	summary: "Essential graph for an architecture",
+	input:   graphRelease,
+	args:    []string{"--arch", "arm64"},
+	stdout: `
+		digraph essentials {
+			"pkga_bins" -> "pkga_libs";
+			"pkga_libs" -> "pkgb_libs";
+			"pkgb_libs";
+		}
+	`,
+}, {
+	summary: "No essential loops",
+	input:   graphRelease,
+	args:    []string{"--cycles"},
+	stdout:  "",
+}, {
+	summary: "Essential loops",
+	input:   loopRelease,
+	args:    []string{"--cycles"},
+	stdout: `
+		pkga_bins (slices/pkga.yaml:5) -> pkga_libs (slices/pkga.yaml:8) -> pkgb_libs (slices/pkgb.yaml:5) -> pkga_bins
+	`,
+}, {
+	summary: "Essential loops for an architecture",
+	input:   loopRelease,
+	args:    []string{"--cycles", "--arch", "arm64"},
+	stdout: `
+		pkga_bins (slices/pkga.yaml:5) -> pkga_libs (slices/pkga.yaml:8) -> pkgb_libs (slices/pkgb.yaml:5) -> pkga_bins
+		pkga_libs (slices/pkga.yaml:8) -> pkgb_libs (slices/pkgb.yaml:6) -> pkga_libs
+	`,
+}}
+
+func (s *ChiselSuite) TestGraphCommand(c *C) {
+	for _, test := range graphTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		args := append([]string{"graph", "--release", dir}, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		stdout := strings.TrimSpace(string(testutil.Reindent(test.stdout)))
+		if stdout != "" {
+			stdout += "\n"
+		}
+		c.Assert(s.Stdout(), Equals, stdout)
+	}
+}
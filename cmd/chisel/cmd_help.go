@@ -120,7 +120,7 @@ func (cmd cmdHelp) Execute(args []string) error {
 	}
 	if cmd.All {
 		if len(cmd.Positional.Subs) > 0 {
-			return fmt.Errorf("help accepts a command, or '--all', but not both.")
+			return usageErrorf("help accepts a command, or '--all', but not both.")
 		}
 		printLongHelp(cmd.parser)
 		return nil
@@ -139,7 +139,7 @@ func (cmd cmdHelp) Execute(args []string) error {
 			if x := cmd.parser.Command.Active; x != nil && x.Name != "help" {
 				sug = "chisel help " + x.Name
 			}
-			return fmt.Errorf("unknown command %q, see '%s'.", subname, sug)
+			return usageErrorf("unknown command %q, see '%s'.", subname, sug)
 		}
 		c := cmd.parser.Command
 		for c.Active != nil {
@@ -163,13 +163,25 @@ type helpCategory struct {
 var helpCategories = []helpCategory{{
 	Label:       "Basic",
 	Description: "general operations",
-	Commands:    []string{"find", "info", "help", "version"},
+	Commands:    []string{"find", "info", "browse", "outdated", "audit", "check-slice", "coverage", "refresh-slices", "graph", "schema", "completion", "help", "version"},
 }, {
 	Label:       "Action",
 	Description: "make things happen",
-	Commands:    []string{"cut"},
+	Commands:    []string{"cut", "compose", "extract"},
 }}
 
+// addHelpCommand lists the command in the help category with the given
+// label, for commands which are only built on some platforms.
+func addHelpCommand(label, name string) {
+	for i := range helpCategories {
+		if helpCategories[i].Label == label {
+			helpCategories[i].Commands = append(helpCategories[i].Commands, name)
+			return
+		}
+	}
+	panic(fmt.Sprintf("internal error: unknown help category %q", label))
+}
+
 var (
 	longChiselDescription = strings.TrimSpace(`
 Chisel can slice a Linux distribution using a release database
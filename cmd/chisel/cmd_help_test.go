@@ -0,0 +1,15 @@
+package main_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+func (s *ChiselSuite) TestHelpAllListsRegisteredCommands(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"help", "--all"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Matches, `(?s).*Action \(make things happen\):\n\s+cut .*`)
+	// Every command in the help categories is built on this platform.
+	c.Assert(s.Stderr(), Equals, "")
+}
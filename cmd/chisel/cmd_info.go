@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"gopkg.in/yaml.v3"
 
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 var shortInfoHelp = "Show information about package slices"
@@ -22,17 +29,52 @@ the output is a list of YAML documents separated by a "---" line.
 
 Slice definitions are shown verbatim according to their definition in
 the selected release. For example, globs are not expanded.
+
+With the --tree option, the essential slices of the slices given, and
+their own essentials in turn, are shown instead as an indented tree, which
+helps finding out why a selection grows. Slices already expanded earlier
+in the tree are marked with "(*)" and not expanded again. Essentials which
+only apply to some architectures are annotated with them, unless --arch
+is used to show only the essentials of that architecture. The --resolve
+option looks up the packages in the archives of the release and shows
+their versions along with the slices.
+
+Without --tree, the --resolve option shows instead the paths the slices
+given extract from their packages, as found in the packages fetched from
+the archives for the architecture, with globs expanded and the mode, size
+and symlink target of each path, without cutting anything. Paths of the
+slices not found in their package are listed as missing.
+
+With the --prefers option, the prefer relationships of the paths listed by
+the slices given are shown instead, along with all the packages listing
+each path and the package the path is extracted from when cutting the
+slices given. The paths shown may be narrowed down with a pattern, as in
+--prefers=/usr/bin/*.
+
+With the --changelog option, the changelogs of the packages given are
+shown instead, as published in changelogs.ubuntu.com for the versions
+found in the archives of the release for the architecture.
 `
 
 var infoDescs = map[string]string{
-	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"release":   "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"tree":      "Show the essential slices as a tree",
+	"resolve":   "Show the package versions, or the paths extracted",
+	"arch":      "Package architecture",
+	"prefers":   "Show the prefer relationships of the paths",
+	"changelog": "Show the changelogs of the packages",
 }
 
 type infoCmd struct {
-	Release string `long:"release" value-name:"<branch|dir>"`
+	Release   string `long:"release" value-name:"<branch|dir>"`
+	Tree      bool   `long:"tree"`
+	Resolve   bool   `long:"resolve"`
+	Arch      string `long:"arch" value-name:"<arch>"`
+	Prefers   string `long:"prefers" optional:"yes" optional-value:"/**" value-name:"<path>"`
+	Changelog bool   `long:"changelog"`
 
 	Positional struct {
-		Queries []string `positional-arg-name:"<pkg|slice>" required:"yes"`
+		Queries []sliceName `positional-arg-name:"<pkg|slice>" required:"yes"`
 	} `positional-args:"yes"`
 }
 
@@ -45,22 +87,61 @@ func (cmd *infoCmd) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	if cmd.Resolve && cmd.Prefers != "" {
+		return usageErrorf("cannot use --resolve with --prefers")
+	}
+	if cmd.Prefers != "" && cmd.Tree {
+		return usageErrorf("cannot use --prefers with --tree")
+	}
+	if cmd.Changelog && (cmd.Tree || cmd.Resolve || cmd.Prefers != "") {
+		return usageErrorf("cannot use --changelog with --tree, --resolve or --prefers")
+	}
+	if cmd.Prefers != "" && !strings.HasPrefix(cmd.Prefers, "/") {
+		return usageErrorf("path must be absolute: %s", cmd.Prefers)
+	}
+
 	release, err := obtainRelease(cmd.Release)
 	if err != nil {
 		return err
 	}
 
-	packages, notFound := selectPackageSlices(release, cmd.Positional.Queries)
+	packages, notFound := selectPackageSlices(release, sliceNameStrings(cmd.Positional.Queries))
 
-	for i, pkg := range packages {
-		data, err := yaml.Marshal(pkg)
+	if cmd.Tree {
+		var versions map[string]string
+		if cmd.Resolve {
+			versions, err = resolveVersions(release, cmd.Arch)
+			if err != nil {
+				return err
+			}
+		}
+		writeEssentialTree(release, packages, cmd.Arch, versions)
+	} else if cmd.Resolve {
+		err = writeResolvedContents(release, packages, cmd.Arch)
 		if err != nil {
 			return err
 		}
-		if i > 0 {
-			fmt.Fprintln(Stdout, "---")
+	} else if cmd.Prefers != "" {
+		err = writePrefers(release, packages, cmd.Prefers)
+		if err != nil {
+			return err
+		}
+	} else if cmd.Changelog {
+		err = writeChangelogs(release, packages, cmd.Arch)
+		if err != nil {
+			return err
+		}
+	} else {
+		for i, pkg := range packages {
+			data, err := yaml.Marshal(pkg)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				fmt.Fprintln(Stdout, "---")
+			}
+			fmt.Fprint(Stdout, string(data))
 		}
-		fmt.Fprint(Stdout, string(data))
 	}
 
 	if len(notFound) > 0 {
@@ -123,9 +204,9 @@ func selectPackageSlices(release *setup.Release, queries []string) (packages []*
 		} else {
 			releasePkg := release.Packages[pkgName]
 			pkg = &setup.Package{
-				Name:    releasePkg.Name,
-				Archive: releasePkg.Archive,
-				Slices:  make(map[string]*setup.Slice),
+				Name:     releasePkg.Name,
+				Archives: releasePkg.Archives,
+				Slices:   make(map[string]*setup.Slice),
 			}
 			for _, sliceName := range pkgSlices[pkgName] {
 				pkg.Slices[sliceName] = releasePkg.Slices[sliceName]
@@ -135,3 +216,309 @@ func selectPackageSlices(release *setup.Release, queries []string) (packages []*
 	}
 	return packages, notFound
 }
+
+// writeEssentialTree writes the essential slices of the slices in packages
+// as an indented tree. Slices expanded earlier are marked with "(*)". When
+// arch is set only the essentials for it are shown, and otherwise the ones
+// for particular architectures are annotated with them. The versions of the
+// packages are shown when known.
+func writeEssentialTree(release *setup.Release, packages []*setup.Package, arch string, versions map[string]string) {
+	aliases := make(map[setup.SliceKey]setup.SliceKey)
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			for _, alias := range slice.Provides {
+				aliases[alias] = setup.SliceKey{slice.Package, slice.Name}
+			}
+		}
+	}
+
+	expanded := make(map[setup.SliceKey]bool)
+	var write func(key setup.SliceKey, note string, prefix string, branch string, last bool)
+	write = func(key setup.SliceKey, note string, prefix string, branch string, last bool) {
+		line := key.String()
+		if version := versions[key.Package]; version != "" {
+			line += " " + version
+		}
+		if note != "" {
+			line += " " + note
+		}
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		if expanded[key] && len(slice.Essential) > 0 {
+			fmt.Fprintf(Stdout, "%s%s%s (*)\n", prefix, branch, line)
+			return
+		}
+		fmt.Fprintf(Stdout, "%s%s%s\n", prefix, branch, line)
+		expanded[key] = true
+
+		type essential struct {
+			key  setup.SliceKey
+			note string
+		}
+		var essentials []essential
+		for req, info := range slice.Essential {
+			var note string
+			if arch != "" && len(info.Arch) > 0 && !slices.Contains(info.Arch, arch) {
+				continue
+			} else if arch == "" && len(info.Arch) > 0 {
+				note = "(arch: " + strings.Join(info.Arch, ", ") + ")"
+			}
+			if provider, ok := aliases[req]; ok {
+				req = provider
+			}
+			essentials = append(essentials, essential{req, note})
+		}
+		slices.SortFunc(essentials, func(a, b essential) int {
+			return strings.Compare(a.key.String(), b.key.String())
+		})
+		if branch != "" {
+			if last {
+				prefix += "    "
+			} else {
+				prefix += "│   "
+			}
+		}
+		for i, e := range essentials {
+			if i == len(essentials)-1 {
+				write(e.key, e.note, prefix, "└── ", true)
+			} else {
+				write(e.key, e.note, prefix, "├── ", false)
+			}
+		}
+	}
+
+	for _, pkg := range packages {
+		sliceNames := make([]string, 0, len(pkg.Slices))
+		for sliceName := range pkg.Slices {
+			sliceNames = append(sliceNames, sliceName)
+		}
+		slices.Sort(sliceNames)
+		for _, sliceName := range sliceNames {
+			write(setup.SliceKey{pkg.Name, sliceName}, "", "", "", true)
+		}
+	}
+}
+
+// preferInfo is the YAML output of --prefers for a path.
+type preferInfo struct {
+	Packages []string          `yaml:"packages"`
+	Prefers  map[string]string `yaml:"prefers"`
+	Selected []string          `yaml:"selected"`
+	Winner   string            `yaml:"winner"`
+}
+
+// writePrefers writes the prefer relationships of the paths matching pattern
+// which are listed by the slices in packages, as a YAML map indexed by path.
+// Each path holds the packages of the release listing it, the packages they
+// prefer, the packages selected listing it and the one it is extracted from.
+func writePrefers(release *setup.Release, packages []*setup.Package, pattern string) error {
+	pathPrefers, err := release.PathPrefers()
+	if err != nil {
+		return err
+	}
+	selection := &setup.Selection{Release: release}
+	for _, pkg := range packages {
+		for _, slice := range pkg.Slices {
+			selection.Slices = append(selection.Slices, slice)
+		}
+	}
+	output := make(map[string]*preferInfo)
+	for _, slice := range selection.Slices {
+		for path := range slice.Contents {
+			prefers, ok := pathPrefers[path]
+			if !ok || !strdist.GlobPath(pattern, path) {
+				continue
+			}
+			info, ok := output[path]
+			if !ok {
+				info = &preferInfo{
+					Packages: prefers.Packages,
+					Prefers:  prefers.Prefers,
+				}
+				output[path] = info
+			}
+			if !slices.Contains(info.Selected, slice.Package) {
+				info.Selected = append(info.Selected, slice.Package)
+			}
+		}
+	}
+	for path, info := range output {
+		slices.Sort(info.Selected)
+		preferred, err := selection.PreferredPackage(path)
+		if err != nil {
+			return err
+		}
+		info.Winner = preferred.Name
+	}
+	if len(output) == 0 {
+		return fmt.Errorf("no prefer relationships found for %s", pattern)
+	}
+	data, err := yaml.Marshal(output)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(Stdout, string(data))
+	return nil
+}
+
+var archiveFetchChangelog = archive.FetchChangelog
+
+// writeChangelogs writes the changelogs of the packages for the versions
+// found in the archives of the release, separated by an empty line.
+func writeChangelogs(release *setup.Release, packages []*setup.Package, arch string) error {
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return err
+	}
+	for i, pkg := range packages {
+		pkgArchive, err := slicer.ResolveArchive(release, archives, pkg.Name)
+		if err != nil {
+			return err
+		}
+		if pkgArchive == nil {
+			return fmt.Errorf("cannot find package %q in archive(s)", pkg.Name)
+		}
+		changelog, err := archiveFetchChangelog(pkgArchive, pkg.Name)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(Stdout)
+		}
+		fmt.Fprint(Stdout, strings.TrimRight(string(changelog), "\n")+"\n")
+	}
+	return nil
+}
+
+// resolveVersions returns the versions of the packages in the release
+// found in its archives, indexed by package name.
+func resolveVersions(release *setup.Release, arch string) (map[string]string, error) {
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]string)
+	for _, pkg := range release.Packages {
+		pkgArchive, err := slicer.ResolveArchive(release, archives, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if pkgArchive == nil {
+			continue
+		}
+		info, err := pkgArchive.Info(pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		versions[pkg.Name] = info.Version
+	}
+	return versions, nil
+}
+
+// resolvedPackage is the YAML output of --resolve without --tree for a
+// package.
+type resolvedPackage struct {
+	Package string                    `yaml:"package"`
+	Version string                    `yaml:"version"`
+	Arch    string                    `yaml:"arch"`
+	Slices  map[string]*resolvedSlice `yaml:"slices"`
+}
+
+type resolvedSlice struct {
+	Contents map[string]resolvedPath `yaml:"contents"`
+	Missing  []string                `yaml:"missing,omitempty"`
+}
+
+type resolvedPath struct {
+	Mode yamlMode `yaml:"mode"`
+	Size int64    `yaml:"size,omitempty"`
+	Link string   `yaml:"link,omitempty"`
+	From string   `yaml:"from,omitempty"`
+}
+
+// writeResolvedContents writes the paths the slices in packages extract
+// from their packages, as found in the archives of the release for arch.
+func writeResolvedContents(release *setup.Release, packages []*setup.Package, arch string) error {
+	var err error
+	if arch == "" {
+		arch, err = deb.InferArch()
+		if err != nil {
+			return err
+		}
+	}
+	archives, err := openArchives(context.Background(), release, arch, false, nil)
+	if err != nil {
+		return err
+	}
+	for i, pkg := range packages {
+		pkgArchive, err := slicer.ResolveArchive(release, archives, pkg.Name)
+		if err != nil {
+			return err
+		}
+		if pkgArchive == nil {
+			return fmt.Errorf("cannot find package %q in archive(s)", pkg.Name)
+		}
+		info, err := pkgArchive.Info(pkg.Name)
+		if err != nil {
+			return err
+		}
+		reader, _, err := pkgArchive.Fetch(pkg.Name)
+		if err != nil {
+			return err
+		}
+		contents, err := slicer.ListPackage(reader, slicer.PathMapper(release))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read package %q: %w", pkg.Name, err)
+		}
+		output := &resolvedPackage{
+			Package: pkg.Name,
+			Version: info.Version,
+			Arch:    arch,
+			Slices:  make(map[string]*resolvedSlice),
+		}
+		for sliceName, slice := range pkg.Slices {
+			resolved, missing := slicer.ResolveSlice(slice, arch, contents)
+			sliceOutput := &resolvedSlice{
+				Contents: make(map[string]resolvedPath),
+				Missing:  missing,
+			}
+			for _, path := range resolved {
+				entry := resolvedPath{
+					Mode: unixMode(path.Mode),
+					Size: path.Size,
+					Link: path.Link,
+				}
+				if path.Path != path.Target {
+					entry.From = path.Path
+				}
+				sliceOutput.Contents[path.Target] = entry
+			}
+			output.Slices[sliceName] = sliceOutput
+		}
+		data, err := yaml.Marshal(output)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(Stdout, "---")
+		}
+		fmt.Fprint(Stdout, string(data))
+	}
+	return nil
+}
+
+// unixMode returns the permission bits of mode along with the setuid, setgid
+// and sticky bits, as in the mode of the tar header.
+func unixMode(mode fs.FileMode) yamlMode {
+	m := yamlMode(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
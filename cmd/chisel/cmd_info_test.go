@@ -8,6 +8,7 @@ import (
 	. "gopkg.in/check.v1"
 
 	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/testutil"
 )
 
@@ -77,6 +78,53 @@ var infoTests = []infoTest{{
 				contents:
 					/dir/another-file: {}
 	`,
+}, {
+	summary: "Deprecated slice",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml),
+		"slices/mypkg.yaml": `
+			package: mypkg
+			slices:
+				old-bins:
+					deprecated: use mypkg_bins instead
+					contents:
+						/usr/bin/app:
+		`,
+	},
+	query: []string{"mypkg_old-bins"},
+	stdout: `
+		package: mypkg
+		slices:
+			old-bins:
+				deprecated: use mypkg_bins instead
+				contents:
+					/usr/bin/app: {}
+	`,
+}, {
+	summary: "Slice metadata",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml),
+		"slices/mypkg.yaml": `
+			package: mypkg
+			slices:
+				bins:
+					summary: The main binaries
+					owners: [alice@example.com]
+					contents:
+						/usr/bin/app:
+		`,
+	},
+	query: []string{"mypkg_bins"},
+	stdout: `
+		package: mypkg
+		slices:
+			bins:
+				summary: The main binaries
+				owners:
+					- alice@example.com
+				contents:
+					/usr/bin/app: {}
+	`,
 }, {
 	summary: "Package and its slices",
 	input:   infoRelease,
@@ -135,8 +183,176 @@ var infoTests = []infoTest{{
 	input:   infoRelease,
 	query:   []string{"foo_bar_foo", "a_b", "7_c", "a_b c", "a_b x_y"},
 	err:     `no slice definitions found for: "foo_bar_foo", "a_b", "7_c", "a_b c", "a_b x_y"`,
+}, {
+	summary: "Essential tree of a slice",
+	input:   infoRelease,
+	query:   []string{"--tree", "mypkg3_myslice"},
+	stdout: `
+		mypkg3_myslice
+		├── mypkg1_myslice1
+		└── mypkg2_myslice
+	`,
+}, {
+	summary: "Essential tree of a package",
+	input:   infoRelease,
+	query:   []string{"--tree", "mypkg1"},
+	stdout: `
+		mypkg1_myslice1
+		mypkg1_myslice2
+		├── mypkg1_myslice1
+		└── mypkg2_myslice (arch: amd64)
+	`,
+}, {
+	summary: "Essential tree for an architecture",
+	input:   infoRelease,
+	query:   []string{"--tree", "--arch", "arm64", "mypkg1_myslice2"},
+	stdout: `
+		mypkg1_myslice2
+		└── mypkg1_myslice1
+	`,
+}, {
+	summary: "Essential tree with repeated slices",
+	input:   infoTreeRelease,
+	query:   []string{"--tree", "pkga_bins", "pkgb_libs"},
+	stdout: `
+		pkga_bins
+		├── pkga_libs
+		│   └── pkgb_libs
+		│       └── pkgc_libs
+		└── pkgb-new_libs
+		    └── pkgb_libs (*)
+		pkgb_libs (*)
+	`,
+}, {
+	summary: "Resolve cannot be used with prefers",
+	input:   infoRelease,
+	query:   []string{"--resolve", "--prefers", "mypkg1"},
+	err:     "cannot use --resolve with --prefers",
+}, {
+	summary: "Prefer relationships of a selection",
+	input:   infoPrefersRelease,
+	query:   []string{"--prefers", "pkga", "pkgb"},
+	stdout: `
+		/usr/bin/app:
+		    packages:
+		        - pkga
+		        - pkgb
+		        - pkgc
+		    prefers:
+		        pkga: pkgb
+		        pkgb: pkgc
+		    selected:
+		        - pkga
+		        - pkgb
+		    winner: pkgb
+		/usr/bin/tool:
+		    packages:
+		        - pkga
+		        - pkgc
+		    prefers:
+		        pkgc: pkga
+		    selected:
+		        - pkga
+		    winner: pkga
+	`,
+}, {
+	summary: "Prefer relationships of a path",
+	input:   infoPrefersRelease,
+	query:   []string{"--prefers=/usr/bin/a*", "pkga", "pkgc"},
+	stdout: `
+		/usr/bin/app:
+		    packages:
+		        - pkga
+		        - pkgb
+		        - pkgc
+		    prefers:
+		        pkga: pkgb
+		        pkgb: pkgc
+		    selected:
+		        - pkga
+		        - pkgc
+		    winner: pkgc
+	`,
+}, {
+	summary: "No prefer relationships for the selection",
+	input:   infoPrefersRelease,
+	query:   []string{"--prefers=/usr/lib/**", "pkga"},
+	err:     `no prefer relationships found for /usr/lib/\*\*`,
+}, {
+	summary: "Prefers requires an absolute path",
+	input:   infoPrefersRelease,
+	query:   []string{"--prefers=usr/bin/app", "pkga"},
+	err:     `path must be absolute: usr/bin/app`,
+}, {
+	summary: "Prefers cannot be used with the tree",
+	input:   infoPrefersRelease,
+	query:   []string{"--prefers", "--tree", "pkga"},
+	err:     `cannot use --prefers with --tree`,
 }}
 
+var infoPrefersRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/pkga.yaml": `
+		package: pkga
+		slices:
+			bins:
+				contents:
+					/usr/bin/app: {prefer: pkgb}
+					/usr/bin/tool:
+	`,
+	"slices/pkgb.yaml": `
+		package: pkgb
+		slices:
+			bins:
+				contents:
+					/usr/bin/app: {prefer: pkgc}
+	`,
+	"slices/pkgc.yaml": `
+		package: pkgc
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+					/usr/bin/tool: {prefer: pkga}
+	`,
+}
+
+var infoTreeRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/pkga.yaml": `
+		package: pkga
+		slices:
+			bins:
+				essential:
+					- pkga_libs
+					- pkgold_libs
+			libs:
+				essential:
+					- pkgb_libs
+	`,
+	"slices/pkgb.yaml": `
+		package: pkgb
+		slices:
+			libs:
+				essential:
+					- pkgc_libs
+	`,
+	"slices/pkgb-new.yaml": `
+		package: pkgb-new
+		slices:
+			libs:
+				provides:
+					- pkgold_libs
+				essential:
+					- pkgb_libs
+	`,
+	"slices/pkgc.yaml": `
+		package: pkgc
+		slices:
+			libs:
+	`,
+}
+
 var infoRelease = map[string]string{
 	"chisel.yaml": string(testutil.DefaultChiselYaml),
 	"slices/mypkg1.yaml": `
@@ -210,3 +426,75 @@ func (s *ChiselSuite) TestInfoCommand(c *C) {
 		c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
 	}
 }
+
+func (s *ChiselSuite) TestInfoTreeResolve(c *C) {
+	dir := c.MkDir()
+	for path, data := range infoTreeRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	testArchive := &testutil.TestArchive{
+		Opts: archive.Options{Label: "ubuntu"},
+		Packages: map[string]*testutil.TestPackage{
+			"pkga": {Name: "pkga", Version: "1.0"},
+			"pkgb": {Name: "pkgb", Version: "2.0-1"},
+		},
+	}
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		c.Assert(options.Arch, Equals, "amd64")
+		return testArchive, nil
+	})
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"info", "--release", dir, "--tree", "--resolve", "--arch", "amd64", "pkga_libs"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"pkga_libs 1.0\n"+
+		"└── pkgb_libs 2.0-1\n"+
+		"    └── pkgc_libs\n")
+}
+
+func (s *ChiselSuite) TestInfoResolveContents(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"info", "--release", releaseDir, "--resolve", "--arch", "amd64", "mypkg_bins", "mypkg_config"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, strings.TrimSpace(string(testutil.Reindent(`
+		package: mypkg
+		version: "1.0"
+		arch: amd64
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+						mode: 0755
+						size: 3
+			config:
+				contents:
+					/etc/app.conf:
+						mode: 0644
+						size: 4
+	`)))+"\n")
+}
+
+func (s *ChiselSuite) TestInfoChangelog(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	restore = chisel.FakeArchiveFetchChangelog(func(a archive.Archive, pkg string) ([]byte, error) {
+		c.Assert(a, Equals, testArchive)
+		return []byte(pkg + " (1.0) noble; urgency=medium\n\n  * Initial release.\n"), nil
+	})
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"info", "--release", releaseDir, "--changelog", "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "mypkg (1.0) noble; urgency=medium\n\n  * Initial release.\n")
+
+	_, err = chisel.Parser().ParseArgs([]string{"info", "--release", releaseDir, "--changelog", "--tree", "mypkg"})
+	c.Assert(err, ErrorMatches, "cannot use --changelog with --tree, --resolve or --prefers")
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortLSPHelp = "Run a language server for slice definitions"
+var longLSPHelp = `
+The lsp command runs a server implementing the Language Server Protocol
+over standard input and output, so that editors validate and complete the
+slice definition files of a release as they are written.
+
+Files are validated as with the check-slice command whenever they change,
+and the errors found are reported as diagnostics on the line they refer to,
+when known. The slices and packages referred to by essentials and prefers
+may be followed to their definitions, and the names of the packages and
+slices of the release are offered as completions.
+
+The release of a file is the one holding it, found by looking for a
+chisel.yaml file in its parent directories, unless the --release flag is
+used. Releases are read again once any file is saved.
+`
+
+var lspDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"full":    "Validate against all of the slice definitions",
+}
+
+type cmdLSP struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Full    bool   `long:"full"`
+}
+
+func init() {
+	addCommand("lsp", shortLSPHelp, longLSPHelp, func() flags.Commander { return &cmdLSP{} }, lspDescs, nil)
+}
+
+func (cmd *cmdLSP) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	server := &lspServer{
+		release:  cmd.Release,
+		full:     cmd.Full,
+		writer:   Stdout,
+		docs:     make(map[string]string),
+		releases: make(map[string]*setup.Release),
+	}
+	return server.serve(bufio.NewReader(Stdin))
+}
+
+// lspMessage is a request, response or notification of the protocol.
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	lspParseError     = -32700
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label string `json:"label"`
+	Kind  int    `json:"kind"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text,omitempty"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	Position       lspPosition     `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+const (
+	lspSeverityError   = 1
+	lspKindModule      = 9
+	lspKindReference   = 18
+	lspTextDocFullSync = 1
+)
+
+// lspServer holds the state of one session with an editor. Messages are
+// handled one at a time, in the order they are received.
+type lspServer struct {
+	release string
+	full    bool
+	writer  io.Writer
+	// docs holds the content of the open documents, by URI.
+	docs map[string]string
+	// releases holds the releases read so far, by directory.
+	releases map[string]*setup.Release
+}
+
+// serve handles the messages read from reader until the editor asks the
+// server to exit or reader is closed.
+func (s *lspServer) serve(reader *bufio.Reader) error {
+	for {
+		data, err := readLSPMessage(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cannot read language server message: %w", err)
+		}
+		var msg lspMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			s.send(&lspMessage{ID: rawNull(), Error: &lspError{lspParseError, err.Error()}})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			// Notifications have no response.
+			continue
+		}
+		reply := &lspMessage{ID: msg.ID}
+		var lspErr *lspError
+		if errors.As(err, &lspErr) {
+			reply.Error = lspErr
+		} else if err != nil {
+			reply.Error = &lspError{lspInvalidParams, err.Error()}
+		} else {
+			reply.Result, err = json.Marshal(result)
+			if err != nil {
+				return err
+			}
+		}
+		s.send(reply)
+	}
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+func (s *lspServer) handle(method string, params json.RawMessage) (any, error) {
+	var doc lspDocumentParams
+	if len(params) > 0 {
+		err := json.Unmarshal(params, &doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   lspTextDocFullSync,
+				"definitionProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"_"},
+				},
+			},
+			"serverInfo": map[string]any{
+				"name":    "chisel",
+				"version": chiselVersion(),
+			},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		s.docs[doc.TextDocument.URI] = doc.TextDocument.Text
+		s.publishDiagnostics(doc.TextDocument.URI)
+	case "textDocument/didChange":
+		if n := len(doc.ContentChanges); n > 0 {
+			s.docs[doc.TextDocument.URI] = doc.ContentChanges[n-1].Text
+		}
+		s.publishDiagnostics(doc.TextDocument.URI)
+	case "textDocument/didSave":
+		// The saved file may change the definitions of the release.
+		clear(s.releases)
+		s.publishDiagnostics(doc.TextDocument.URI)
+	case "textDocument/didClose":
+		delete(s.docs, doc.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri":         doc.TextDocument.URI,
+			"diagnostics": []lspDiagnostic{},
+		})
+	case "textDocument/definition":
+		return s.definition(doc.TextDocument.URI, doc.Position), nil
+	case "textDocument/completion":
+		return s.completion(doc.TextDocument.URI, doc.Position), nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+	default:
+		return nil, &lspError{lspMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+	}
+	return nil, nil
+}
+
+// readLSPMessage reads the content of the next message, which is preceded
+// by headers holding its length.
+func readLSPMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" && length < 0 {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid content length: %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing content length")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(reader, data)
+	return data, err
+}
+
+func (s *lspServer) send(msg *lspMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *lspServer) notify(method string, params any) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+	s.send(&lspMessage{Method: method, Params: data})
+}
+
+func rawNull() *json.RawMessage {
+	null := json.RawMessage("null")
+	return &null
+}
+
+// releaseDir returns the directory of the release the file at path belongs
+// to.
+func (s *lspServer) releaseDir(path string) (string, error) {
+	releaseStr := s.release
+	if releaseStr == "" {
+		releaseStr = findReleaseDir(path)
+	}
+	return obtainReleaseDir(releaseStr)
+}
+
+// readRelease returns the release the document at uri belongs to, reading
+// it if not done already.
+func (s *lspServer) readRelease(uri string) (*setup.Release, error) {
+	path, err := uriPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.releaseDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if release, ok := s.releases[dir]; ok {
+		return release, nil
+	}
+	release, err := obtainRelease(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.releases[dir] = release
+	return release, nil
+}
+
+// publishDiagnostics validates the document at uri and reports the error
+// found, if any, to the editor.
+func (s *lspServer) publishDiagnostics(uri string) {
+	text, ok := s.docs[uri]
+	if !ok {
+		return
+	}
+	diagnostics := []lspDiagnostic{}
+	err := s.check(uri, text)
+	if err != nil {
+		line := errorLine(text, err)
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    lineRange(text, line),
+			Severity: lspSeverityError,
+			Source:   "chisel",
+			Message:  err.Error(),
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+func (s *lspServer) check(uri, text string) error {
+	path, err := uriPath(uri)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(path) == "chisel.yaml" {
+		// The release definition is only validated along with the
+		// slice definitions, once saved.
+		return nil
+	}
+	dir, err := s.releaseDir(path)
+	if err != nil {
+		return err
+	}
+	_, err = setup.CheckPackageData(dir, path, []byte(text), s.full)
+	return err
+}
+
+var errorLineExp = regexp.MustCompile(`\bline (\d+)\b`)
+
+// errorLine returns the line of text, counting from zero, which err refers
+// to. That is the line given in the error when parsing fails, or otherwise
+// the first line mentioning the paths, or else the slices, named in the
+// error, or the first line if there is none.
+func errorLine(text string, err error) int {
+	msg := err.Error()
+	if m := errorLineExp.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return max(line-1, 0)
+	}
+	// Paths are looked for first, as they pinpoint the issue better
+	// than the slices involved.
+	lines := strings.Split(text, "\n")
+	for _, isName := range []func(string) bool{
+		func(name string) bool { return strings.HasPrefix(name, "/") },
+		func(name string) bool { return strings.Contains(name, "_") },
+	} {
+		for _, field := range strings.Fields(msg) {
+			name := strings.Trim(field, `"'.,:;()`)
+			if len(name) < 2 || !isName(name) {
+				continue
+			}
+			for i, line := range lines {
+				if strings.Contains(line, name) {
+					return i
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// lineRange returns the range covering the content of the line of text.
+func lineRange(text string, line int) lspRange {
+	lines := strings.Split(text, "\n")
+	if line >= len(lines) {
+		line = max(len(lines)-1, 0)
+	}
+	content := strings.TrimRight(lines[line], "\r")
+	start := len(content) - len(strings.TrimLeft(content, " \t"))
+	return lspRange{
+		Start: lspPosition{line, utf16Len(content[:start])},
+		End:   lspPosition{line, utf16Len(content)},
+	}
+}
+
+// utf16Len returns the length of s in UTF-16 code units, in which the
+// protocol measures positions.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// isNameChar reports whether c may be part of the name of a package or
+// slice, or of a reference to a slice.
+func isNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("+-._", c) >= 0
+}
+
+// nameAt returns the name at pos in text, along with the part of it before
+// pos.
+func nameAt(text string, pos lspPosition) (name, prefix string) {
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return "", ""
+	}
+	line := lines[pos.Line]
+	// Names only hold ASCII characters, so the character offset matches
+	// the byte offset in the part of the line that matters.
+	end := min(max(pos.Character, 0), len(line))
+	start := end
+	for start > 0 && isNameChar(line[start-1]) {
+		start--
+	}
+	prefix = line[start:end]
+	for end < len(line) && isNameChar(line[end]) {
+		end++
+	}
+	return strings.TrimRight(line[start:end], "."), prefix
+}
+
+// definition returns the location where the slice or package at pos in the
+// document at uri is defined, or nil if not known.
+func (s *lspServer) definition(uri string, pos lspPosition) *lspLocation {
+	text, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+	name, _ := nameAt(text, pos)
+	if name == "" {
+		return nil
+	}
+	release, err := s.readRelease(uri)
+	if err != nil {
+		return nil
+	}
+	pkgName, sliceName := name, ""
+	if key, err := setup.ParseSliceKey(name); err == nil {
+		pkgName, sliceName = key.Package, key.Slice
+	}
+	pkg, ok := release.Packages[pkgName]
+	if !ok || sliceName != "" && pkg.Slices[sliceName] == nil {
+		return nil
+	}
+	path := pkg.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(release.Path, path)
+	}
+	defURI := pathURI(path)
+	defText, ok := s.docs[defURI]
+	if !ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		defText = string(data)
+	}
+	line, column := definitionPosition(defText, sliceName)
+	return &lspLocation{
+		URI: defURI,
+		Range: lspRange{
+			Start: lspPosition{line, column},
+			End:   lspPosition{line, column + len(sliceName)},
+		},
+	}
+}
+
+// definitionPosition returns the line and column, counting from zero, where
+// the slice is defined in the slice definitions in text, or where the
+// package is named if slice is empty or cannot be found.
+func definitionPosition(text, slice string) (line, column int) {
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte(text), &node)
+	if err != nil || node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		return 0, 0
+	}
+	doc := node.Content[0]
+	keyNode := func(mapping *yaml.Node, key string) *yaml.Node {
+		if mapping == nil || mapping.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if mapping.Content[i].Value == key {
+				return mapping.Content[i]
+			}
+		}
+		return nil
+	}
+	var found *yaml.Node
+	if slice != "" {
+		found = keyNode(mappingValue(doc, "slices"), slice)
+	}
+	if found == nil {
+		found = keyNode(doc, "package")
+	}
+	if found == nil {
+		return 0, 0
+	}
+	return found.Line - 1, found.Column - 1
+}
+
+// mappingValue returns the value of key in the mapping node, or nil if node
+// is not a mapping or does not hold key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// completion returns the names of the packages, or of the slices of the
+// package, starting with the name before pos in the document at uri.
+func (s *lspServer) completion(uri string, pos lspPosition) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	text, ok := s.docs[uri]
+	if !ok {
+		return items
+	}
+	_, prefix := nameAt(text, pos)
+	release, err := s.readRelease(uri)
+	if err != nil {
+		return items
+	}
+	if pkgName, _, ok := strings.Cut(prefix, "_"); ok {
+		if pkg, ok := release.Packages[pkgName]; ok {
+			for sliceName := range pkg.Slices {
+				label := pkgName + "_" + sliceName
+				if strings.HasPrefix(label, prefix) {
+					items = append(items, lspCompletionItem{label, lspKindReference})
+				}
+			}
+		}
+	} else {
+		for pkgName := range release.Packages {
+			if strings.HasPrefix(pkgName, prefix) {
+				items = append(items, lspCompletionItem{pkgName, lspKindModule})
+			}
+		}
+	}
+	slices.SortFunc(items, func(a, b lspCompletionItem) int {
+		return strings.Compare(a.Label, b.Label)
+	})
+	return items
+}
+
+// uriPath returns the path of the file at the file URI.
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported document URI: %q", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathURI returns the file URI of the file at path.
+func pathURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
@@ -0,0 +1,206 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type lspTestMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+func (s *ChiselSuite) writeLSPMessage(c *C, id int, method string, params any) {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		msg["id"] = id
+	}
+	data, err := json.Marshal(msg)
+	c.Assert(err, IsNil)
+	fmt.Fprintf(s.stdin, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *ChiselSuite) readLSPMessages(c *C) []lspTestMessage {
+	var msgs []lspTestMessage
+	reader := bufio.NewReader(strings.NewReader(s.Stdout()))
+	for {
+		header, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return msgs
+		}
+		c.Assert(err, IsNil)
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+		c.Assert(err, IsNil)
+		_, err = reader.ReadString('\n')
+		c.Assert(err, IsNil)
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		c.Assert(err, IsNil)
+		var msg lspTestMessage
+		c.Assert(json.Unmarshal(data, &msg), IsNil)
+		msgs = append(msgs, msg)
+	}
+}
+
+func (s *ChiselSuite) TestLSP(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	pkgPath := filepath.Join(releaseDir, "slices", "newpkg.yaml")
+	uri := (&url.URL{Scheme: "file", Path: pkgPath}).String()
+	valid := string(testutil.Reindent(`
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+				contents:
+					/usr/bin/new:
+	`))
+	conflict := strings.Replace(valid, "/usr/bin/new:", "/usr/bin/app:", 1)
+	err := os.WriteFile(pkgPath, []byte(valid), 0644)
+	c.Assert(err, IsNil)
+
+	s.writeLSPMessage(c, 1, "initialize", map[string]any{"capabilities": map[string]any{}})
+	s.writeLSPMessage(c, 0, "initialized", map[string]any{})
+	s.writeLSPMessage(c, 0, "textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "yaml", "version": 1, "text": valid},
+	})
+	s.writeLSPMessage(c, 0, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": conflict}},
+	})
+	s.writeLSPMessage(c, 0, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 3},
+		"contentChanges": []map[string]any{{"text": "package: newpkg\nslices: [\n"}},
+	})
+	s.writeLSPMessage(c, 2, "textDocument/definition", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 4, "character": 16},
+	})
+	s.writeLSPMessage(c, 0, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 4},
+		"contentChanges": []map[string]any{{"text": valid}},
+	})
+	s.writeLSPMessage(c, 3, "textDocument/definition", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 4, "character": 16},
+	})
+	s.writeLSPMessage(c, 4, "textDocument/completion", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 4, "character": 16},
+	})
+	s.writeLSPMessage(c, 5, "textDocument/completion", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": 4, "character": 20},
+	})
+	s.writeLSPMessage(c, 6, "unknown/method", map[string]any{})
+	s.writeLSPMessage(c, 7, "shutdown", nil)
+	s.writeLSPMessage(c, 0, "exit", nil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"lsp"})
+	c.Assert(err, IsNil)
+
+	msgs := s.readLSPMessages(c)
+	c.Assert(msgs, HasLen, 11)
+
+	c.Assert(*msgs[0].ID, Equals, 1)
+	var initResult struct {
+		Capabilities struct {
+			DefinitionProvider bool `json:"definitionProvider"`
+		} `json:"capabilities"`
+	}
+	c.Assert(json.Unmarshal(msgs[0].Result, &initResult), IsNil)
+	c.Assert(initResult.Capabilities.DefinitionProvider, Equals, true)
+
+	type diagnostics struct {
+		URI         string `json:"uri"`
+		Diagnostics []struct {
+			Range struct {
+				Start struct {
+					Line      int `json:"line"`
+					Character int `json:"character"`
+				} `json:"start"`
+			} `json:"range"`
+			Message string `json:"message"`
+		} `json:"diagnostics"`
+	}
+	var diags []diagnostics
+	for _, msg := range msgs[1:4] {
+		c.Assert(msg.Method, Equals, "textDocument/publishDiagnostics")
+		var d diagnostics
+		c.Assert(json.Unmarshal(msg.Params, &d), IsNil)
+		c.Assert(d.URI, Equals, uri)
+		diags = append(diags, d)
+	}
+	// The valid document has no diagnostics.
+	c.Assert(diags[0].Diagnostics, HasLen, 0)
+	// Conflicts are reported on the line of the path.
+	c.Assert(diags[1].Diagnostics, HasLen, 1)
+	c.Assert(diags[1].Diagnostics[0].Message, Matches, `slices mypkg_bins and newpkg_bins conflict on /usr/bin/app`)
+	c.Assert(diags[1].Diagnostics[0].Range.Start.Line, Equals, 6)
+	c.Assert(diags[1].Diagnostics[0].Range.Start.Character, Equals, 12)
+	// Parse errors are reported on the line given.
+	c.Assert(diags[2].Diagnostics, HasLen, 1)
+	c.Assert(diags[2].Diagnostics[0].Message, Matches, `cannot parse package "newpkg" slice definitions: .*`)
+	c.Assert(diags[2].Diagnostics[0].Range.Start.Line, Equals, 1)
+
+	// Nothing is defined past the end of the document.
+	c.Assert(*msgs[4].ID, Equals, 2)
+	c.Assert(string(msgs[4].Result), Equals, "null")
+
+	c.Assert(msgs[5].Method, Equals, "textDocument/publishDiagnostics")
+
+	// Essentials lead to the definition of the slice.
+	c.Assert(*msgs[6].ID, Equals, 3)
+	var location struct {
+		URI   string `json:"uri"`
+		Range struct {
+			Start struct {
+				Line      int `json:"line"`
+				Character int `json:"character"`
+			} `json:"start"`
+		} `json:"range"`
+	}
+	c.Assert(json.Unmarshal(msgs[6].Result, &location), IsNil)
+	mypkgURI := (&url.URL{Scheme: "file", Path: filepath.Join(releaseDir, "slices", "mypkg.yaml")}).String()
+	c.Assert(location.URI, Equals, mypkgURI)
+	c.Assert(location.Range.Start.Line, Equals, 2)
+	c.Assert(location.Range.Start.Character, Equals, 4)
+
+	// Packages and slices are completed.
+	type completion struct {
+		Label string `json:"label"`
+	}
+	var packages, slices []completion
+	c.Assert(*msgs[7].ID, Equals, 4)
+	c.Assert(json.Unmarshal(msgs[7].Result, &packages), IsNil)
+	c.Assert(packages, DeepEquals, []completion{{"mypkg"}})
+	c.Assert(*msgs[8].ID, Equals, 5)
+	c.Assert(json.Unmarshal(msgs[8].Result, &slices), IsNil)
+	c.Assert(slices, DeepEquals, []completion{
+		{"mypkg_all"}, {"mypkg_bins"}, {"mypkg_config"}, {"mypkg_machine"}, {"mypkg_manifest"}, {"mypkg_old-bins"},
+	})
+
+	c.Assert(*msgs[9].ID, Equals, 6)
+	c.Assert(msgs[9].Error.Code, Equals, -32601)
+	c.Assert(*msgs[10].ID, Equals, 7)
+	c.Assert(string(msgs[10].Result), Equals, "null")
+}
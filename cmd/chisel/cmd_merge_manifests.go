@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var shortMergeManifestsHelp = "Merge the manifests of several cuts"
+var longMergeManifestsHelp = `
+The merge-manifests command merges the manifests written by several cuts
+into the same root, such as the stages of a container build, into a single
+manifest describing the whole tree.
+
+Packages and paths recorded by more than one manifest must agree, or the
+manifests are not merged. Paths recorded for slices in several manifests
+are listed for all of them. The record of how each tree was cut is not
+kept, as the merged manifest is not the result of a single cut.
+
+The merged manifest is written as jsonwall compressed with zstd, unless
+the --manifest-encoding option selects another encoding as in the cut
+command. The output file may be one of the manifests being merged.
+`
+
+var mergeManifestsDescs = map[string]string{
+	"output":            "Write the merged manifest to the file",
+	"manifest-encoding": "Encoding of the merged manifest (zstd, gzip, none, json)",
+}
+
+var mergeManifestsArgDescs = []argDesc{{
+	name: "<manifest>",
+	desc: "Manifest written by a cut",
+}}
+
+type cmdMergeManifests struct {
+	Output           string `long:"output" value-name:"<file>" required:"yes"`
+	ManifestEncoding string `long:"manifest-encoding" choice:"zstd" choice:"gzip" choice:"none" choice:"json" value-name:"<encoding>"`
+
+	Positional struct {
+		Manifests []string `positional-arg-name:"<manifest>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("merge-manifests", shortMergeManifestsHelp, longMergeManifestsHelp, func() flags.Commander { return &cmdMergeManifests{} }, mergeManifestsDescs, mergeManifestsArgDescs)
+}
+
+func (cmd *cmdMergeManifests) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var mfests []*manifest.Manifest
+	for _, path := range cmd.Positional.Manifests {
+		mfest, err := readManifest(path)
+		if err != nil {
+			return err
+		}
+		mfests = append(mfests, mfest)
+	}
+
+	// The manifests are merged in memory first, so that the output may
+	// replace one of them and is left untouched when they conflict.
+	var buf bytes.Buffer
+	err := manifestutil.Merge(mfests, manifestutil.Encoding(cmd.ManifestEncoding), &buf)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(cmd.Output, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write output: %w", err)
+	}
+	return nil
+}
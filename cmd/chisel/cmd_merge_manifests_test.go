@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+func (s *ChiselSuite) TestMergeManifests(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	// Two stages cut different slices of the same package.
+	var manifests []string
+	for _, slice := range []string{"mypkg_bins", "mypkg_config"} {
+		rootDir := c.MkDir()
+		_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+			slice, "mypkg_manifest"})
+		c.Assert(err, IsNil)
+		manifests = append(manifests, filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+	}
+
+	output := manifests[0]
+	_, err := chisel.Parser().ParseArgs(append([]string{"merge-manifests", "--output", output}, manifests...))
+	c.Assert(err, IsNil)
+
+	f, err := os.Open(output)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	mfest, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	var sliceNames []string
+	err = mfest.IterateSlices("", func(slice *manifest.Slice) error {
+		sliceNames = append(sliceNames, slice.Name)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(sliceNames, DeepEquals, []string{"mypkg_bins", "mypkg_config", "mypkg_manifest"})
+	var paths []string
+	err = mfest.IteratePaths("/", func(path *manifest.Path) error {
+		if !strings.HasSuffix(path.Path, "/") {
+			paths = append(paths, path.Path)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"/etc/app.conf", "/usr/bin/app", "/var/lib/chisel/manifest.wall"})
+	build, err := mfest.Build()
+	c.Assert(err, IsNil)
+	c.Assert(build, IsNil)
+}
+
+func (s *ChiselSuite) TestMergeManifestsConflict(c *C) {
+	dir := c.MkDir()
+	var manifests []string
+	for i, version := range []string{"1.0", "1.1"} {
+		path := filepath.Join(dir, []string{"a.wall", "b.wall"}[i])
+		data := `{"jsonwall":"1.0","schema":"2.0","count":1}` + "\n" +
+			`{"kind":"package","name":"mypkg","version":"` + version + `","sha256":"hash","arch":"amd64"}` + "\n"
+		err := os.WriteFile(path, []byte(data), 0644)
+		c.Assert(err, IsNil)
+		manifests = append(manifests, path)
+	}
+
+	output := filepath.Join(dir, "merged.wall")
+	_, err := chisel.Parser().ParseArgs(append([]string{"merge-manifests", "--output", output}, manifests...))
+	c.Assert(err, ErrorMatches, `cannot merge manifests: package mypkg has diverging versions: 1.0 \(amd64\) != 1.1 \(amd64\)`)
+	_, err = os.Stat(output)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = chisel.Parser().ParseArgs([]string{"merge-manifests", "--output", output, filepath.Join(dir, "missing.wall")})
+	c.Assert(err, ErrorMatches, `cannot read manifest: open .*missing.wall: no such file or directory`)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/lockfile"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var shortOutdatedHelp = "Report packages with newer versions available"
+var longOutdatedHelp = `
+The outdated command compares the package versions recorded in a lockfile
+or in a manifest with the versions currently available in the archives of
+the release, and reports the packages which may be upgraded along with the
+location of their changelogs when known.
+
+The Ubuntu Security Notices (USN) fixed by upgrading are reported as well,
+based on the same OVAL data used by the audit command. The notices are
+omitted, with a warning, when that data cannot be obtained.
+
+Manifests are recognized by the .wall extension, and any other file is
+read as a lockfile.
+
+The release is taken from the lockfile when recorded there. Otherwise it
+defaults to the same Ubuntu version as the current host, unless the
+--release flag is used. The architecture defaults to the one of the
+packages recorded.
+
+The archive indexes are revalidated with the archive when cached, unless
+the --refresh option is used to download them again.
+`
+
+var outdatedDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+	"refresh": "Download the archive indexes again",
+}
+
+var outdatedArgDescs = []argDesc{{
+	name: "<lockfile|manifest>",
+	desc: "Lockfile or manifest recording the packages of a tree",
+}}
+
+type cmdOutdated struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+	Refresh bool   `long:"refresh"`
+
+	Positional struct {
+		File string `positional-arg-name:"<lockfile|manifest>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("outdated", shortOutdatedHelp, longOutdatedHelp, func() flags.Commander { return &cmdOutdated{} }, outdatedDescs, outdatedArgDescs)
+}
+
+// recordedPackage is a package recorded in a lockfile or manifest.
+type recordedPackage struct {
+	Name    string
+	Version string
+	Arch    string
+	// Archive is the name of the archive the package was fetched from,
+	// when known.
+	Archive string
+}
+
+func (cmd *cmdOutdated) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	pkgs, releaseName, err := readRecordedPackages(cmd.Positional.File)
+	if err != nil {
+		return err
+	}
+	releaseStr := cmd.Release
+	if releaseStr == "" {
+		releaseStr = releaseName
+	}
+
+	arch := cmd.Arch
+	if arch == "" {
+		for _, pkg := range pkgs {
+			if pkg.Arch != "" && pkg.Arch != "all" {
+				arch = pkg.Arch
+				break
+			}
+		}
+	}
+
+	release, err := obtainRelease(releaseStr)
+	if err != nil {
+		return err
+	}
+	archives, err := openArchives(context.Background(), release, arch, cmd.Refresh, nil)
+	if err != nil {
+		return err
+	}
+	var outdated []*archive.PackageInfo
+	available := make(map[string]string)
+	changelogs := make(map[string]string)
+	for _, pkg := range pkgs {
+		pkgArchive := archives[pkg.Archive]
+		if pkgArchive == nil || !pkgArchive.Exists(pkg.Name) {
+			pkgArchive, err = slicer.ResolveArchive(release, archives, pkg.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if pkgArchive == nil {
+			logf("Package %q not found in archives", pkg.Name)
+			continue
+		}
+		info, err := pkgArchive.Info(pkg.Name)
+		if err != nil {
+			return err
+		}
+		if deb.CompareVersions(info.Version, pkg.Version) <= 0 {
+			continue
+		}
+		outdated = append(outdated, &archive.PackageInfo{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Arch:    pkg.Arch,
+		})
+		available[pkg.Name] = info.Version
+		changelogs[pkg.Name] = archive.ChangelogURL(pkgArchive, pkg.Name)
+	}
+	if len(outdated) == 0 {
+		fmt.Fprintf(Stderr, "All packages are up to date\n")
+		return nil
+	}
+
+	// The notices fixed by the available versions are only informative,
+	// so the report is still useful without them.
+	notices := make(map[string][]string)
+	vulns, err := auditPackages(release, outdated)
+	if err != nil {
+		logf("Cannot look up security notices: %v", err)
+	}
+	for _, vuln := range vulns {
+		if deb.CompareVersions(available[vuln.Package], vuln.FixedVersion) >= 0 {
+			notices[vuln.Package] = append(notices[vuln.Package], vuln.Notice)
+		}
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Package\tCurrent\tAvailable\tNotices\tChangelog\n")
+	for _, pkg := range outdated {
+		fixed := "-"
+		if len(notices[pkg.Name]) > 0 {
+			fixed = strings.Join(notices[pkg.Name], ",")
+		}
+		changelog := changelogs[pkg.Name]
+		if changelog == "" {
+			changelog = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, available[pkg.Name], fixed, changelog)
+	}
+	return w.Flush()
+}
+
+// readRecordedPackages returns the packages recorded in the lockfile or
+// manifest at path, sorted by name, and the name of the release if known.
+// Manifests are recognized by their extension.
+func readRecordedPackages(path string) (pkgs []*recordedPackage, releaseName string, err error) {
+	if strings.HasSuffix(path, ".wall") {
+		pkgs, err = readManifestPackages(path)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		lock, err := lockfile.Read(path)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, pkg := range lock.Packages {
+			pkgs = append(pkgs, &recordedPackage{
+				Name:    pkg.Name,
+				Version: pkg.Version,
+				Arch:    pkg.Arch,
+				Archive: pkg.Archive,
+			})
+		}
+		releaseName = lock.Release.Name
+	}
+	slices.SortFunc(pkgs, func(a, b *recordedPackage) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pkgs, releaseName, nil
+}
+
+// readManifestPackages returns the packages recorded in the manifest at
+// path.
+func readManifestPackages(path string) ([]*recordedPackage, error) {
+	mfest, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*recordedPackage
+	err = mfest.IteratePackages(func(pkg *manifest.Package) error {
+		pkgs = append(pkgs, &recordedPackage{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Arch:    pkg.Arch,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest %s: %w", path, err)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,70 @@
+package main_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/security"
+)
+
+func (s *ChiselSuite) TestOutdatedLockfile(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	defer chisel.FakeSecurityFetch(func(options *security.FetchOptions) ([]*security.Notice, error) {
+		c.Assert(options.Codename, Equals, "jammy")
+		return auditNotices, nil
+	})()
+
+	lockPath := filepath.Join(c.MkDir(), "chisel.lock")
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(),
+		"--lockfile", lockPath, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"outdated", "--release", releaseDir, lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "All packages are up to date\n")
+
+	s.ResetStdStreams()
+	testArchive.Packages["mypkg"].Version = "1.1"
+	_, err = chisel.Parser().ParseArgs([]string{"outdated", "--release", releaseDir, lockPath})
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(s.Stdout()), Equals, strings.Join([]string{
+		"Package  Current  Available  Notices     Changelog",
+		"mypkg    1.0      1.1        USN-1000-1  -",
+	}, "\n"))
+}
+
+func (s *ChiselSuite) TestOutdatedManifest(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+	defer chisel.FakeSecurityFetch(func(options *security.FetchOptions) ([]*security.Notice, error) {
+		return nil, errors.New("no network")
+	})()
+
+	rootDir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir,
+		"mypkg_bins", "mypkg_manifest"})
+	c.Assert(err, IsNil)
+
+	manifestPath := filepath.Join(rootDir, "var/lib/chisel/manifest.wall")
+	testArchive.Packages["mypkg"].Version = "1:0.9"
+	_, err = chisel.Parser().ParseArgs([]string{"outdated", "--release", releaseDir, manifestPath})
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(s.Stdout()), Equals, strings.Join([]string{
+		"Package  Current  Available  Notices  Changelog",
+		"mypkg    1.0      1:0.9      -        -",
+	}, "\n"))
+}
+
+func (s *ChiselSuite) TestOutdatedErrors(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"outdated", filepath.Join(c.MkDir(), "chisel.lock")})
+	c.Assert(err, ErrorMatches, "cannot read lockfile: .*: no such file or directory")
+
+	_, err = chisel.Parser().ParseArgs([]string{"outdated", filepath.Join(c.MkDir(), "manifest.wall")})
+	c.Assert(err, ErrorMatches, "cannot read manifest: .*: no such file or directory")
+}
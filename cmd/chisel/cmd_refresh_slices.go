@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/refresh"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortRefreshSlicesHelp = "Suggest slice changes for a package update"
+var longRefreshSlicesHelp = `
+The refresh-slices command compares the contents of two versions of a
+package and proposes the changes its slice definitions need as a patch
+for the slice definition file, to be reviewed and applied with "git apply"
+or "patch -p1" from the release directory:
+
+    chisel refresh-slices --release ./ libssl3_3.0.2_amd64.deb > update.patch
+
+The old version is given as a .deb file. The new version is the one given
+as a second .deb file, or otherwise the one currently in the archives of
+the release.
+
+The changes proposed are:
+
+  - Paths of slices not found in the new version are removed.
+  - Shared libraries whose soname changed are renamed, as in libfoo.so.1
+    becoming libfoo.so.2.
+  - Binaries new to the package are added to the slice with the most paths
+    in the same directory, unless a glob of a slice covers them already.
+
+The changes are also described in the standard error, along with the new
+binaries for which no slice could be chosen.
+
+By default the release is the one for the same Ubuntu version as the
+current host, unless the --release flag is used. The architecture defaults
+to the one of the old package.
+`
+
+var refreshSlicesDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+var refreshSlicesArgDescs = []argDesc{{
+	name: "<old.deb>",
+	desc: "Old version of the package",
+}, {
+	name: "<new.deb>",
+	desc: "New version of the package, instead of the one in the archives",
+}}
+
+type cmdRefreshSlices struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+
+	Positional struct {
+		Old string `positional-arg-name:"<old.deb>" required:"yes"`
+		New string `positional-arg-name:"<new.deb>"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("refresh-slices", shortRefreshSlicesHelp, longRefreshSlicesHelp, func() flags.Commander { return &cmdRefreshSlices{} }, refreshSlicesDescs, refreshSlicesArgDescs)
+}
+
+func (cmd *cmdRefreshSlices) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	oldInfo, err := archive.ReadDebInfo(cmd.Positional.Old)
+	if err != nil {
+		return err
+	}
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+	pkg, ok := release.Packages[oldInfo.Name]
+	if !ok {
+		return fmt.Errorf("slices of package %q not found", oldInfo.Name)
+	}
+	arch := cmd.Arch
+	if arch == "" {
+		arch = oldInfo.Arch
+	}
+	if arch == "" || arch == "all" {
+		arch, err = deb.InferArch()
+		if err != nil {
+			return err
+		}
+	}
+
+	oldPaths, err := debContents(release, cmd.Positional.Old)
+	if err != nil {
+		return err
+	}
+	var newVersion string
+	var newPaths []string
+	if cmd.Positional.New != "" {
+		newInfo, err := archive.ReadDebInfo(cmd.Positional.New)
+		if err != nil {
+			return err
+		}
+		if newInfo.Name != oldInfo.Name {
+			return fmt.Errorf("cannot compare different packages: %s and %s", oldInfo.Name, newInfo.Name)
+		}
+		newVersion = newInfo.Version
+		newPaths, err = debContents(release, cmd.Positional.New)
+		if err != nil {
+			return err
+		}
+	} else {
+		archives, err := openArchives(context.Background(), release, arch, false, nil)
+		if err != nil {
+			return err
+		}
+		pkgArchive, err := slicer.ResolveArchive(release, archives, pkg.Name)
+		if err != nil {
+			return err
+		}
+		if pkgArchive == nil {
+			return fmt.Errorf("cannot find package %q in archive(s)", pkg.Name)
+		}
+		reader, info, err := pkgArchive.Fetch(pkg.Name)
+		if err != nil {
+			return err
+		}
+		newVersion = info.Version
+		contents, err := slicer.ListPackage(reader, slicer.PathMapper(release))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read package %q: %w", pkg.Name, err)
+		}
+		newPaths = packagePaths(contents)
+	}
+
+	changes := refresh.Suggest(pkg, arch, oldPaths, newPaths)
+	if len(changes) == 0 {
+		fmt.Fprintf(Stderr, "Slices of package %s are up to date with version %s\n", pkg.Name, newVersion)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Fprintf(Stderr, "%s\n", change)
+	}
+
+	data, err := os.ReadFile(filepath.Join(release.Path, pkg.Path))
+	if err != nil {
+		return err
+	}
+	changed, err := refresh.Apply(data, changes)
+	if err != nil {
+		return fmt.Errorf("cannot change %s: %w", pkg.Path, err)
+	}
+	_, err = io.WriteString(Stdout, refresh.Diff(filepath.ToSlash(pkg.Path), data, changed))
+	return err
+}
+
+// debContents returns the paths in the data of the .deb file, mapped to
+// the paths the slices of the release list.
+func debContents(release *setup.Release, debPath string) ([]string, error) {
+	file, err := os.Open(debPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contents, err := slicer.ListPackage(file, slicer.PathMapper(release))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", filepath.Base(debPath), err)
+	}
+	return packagePaths(contents), nil
+}
+
+// packagePaths returns the paths of the package contents, with the paths
+// of directories ending in "/".
+func packagePaths(contents []slicer.PackagePath) []string {
+	paths := make([]string, 0, len(contents))
+	for _, entry := range contents {
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+const refreshControl = "Package: mypkg\nVersion: 0.9\nArchitecture: amd64\n"
+
+func (s *ChiselSuite) TestRefreshSlices(c *C) {
+	releaseDir, testArchive, restore := fakeCutRelease(c)
+	defer restore()
+
+	oldDeb := filepath.Join(c.MkDir(), "mypkg_0.9_amd64.deb")
+	err := os.WriteFile(oldDeb, testutil.MustMakeDebWithControl(refreshControl, []testutil.TarEntry{
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/app.conf", "conf"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+	}), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"refresh-slices", "--release", releaseDir, oldDeb})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "Slices of package mypkg are up to date with version 1.0\n")
+
+	s.ResetStdStreams()
+	testArchive.Packages["mypkg"].Data = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+		testutil.Reg(0755, "./usr/bin/app-helper", "helper"),
+	})
+	_, err = chisel.Parser().ParseArgs([]string{"refresh-slices", "--release", releaseDir, oldDeb})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stderr(), Equals, ""+
+		"Remove /etc/app.conf from slice config\n"+
+		"Add /usr/bin/app-helper to slice bins\n")
+	c.Assert(s.Stdout(), Equals, ""+
+		"--- a/slices/mypkg.yaml\n"+
+		"+++ b/slices/mypkg.yaml\n"+
+		"@@ -3,9 +3,9 @@\n"+
+		"     bins:\n"+
+		"         contents:\n"+
+		"             /usr/bin/app:\n"+
+		"+            /usr/bin/app-helper:\n"+
+		"     config:\n"+
+		"         contents:\n"+
+		"-            /etc/app.conf:\n"+
+		"     manifest:\n"+
+		"         contents:\n"+
+		"             /var/lib/chisel/**: {generate: manifest}\n")
+}
+
+func (s *ChiselSuite) TestRefreshSlicesNewDeb(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	debDir := c.MkDir()
+	oldDeb := filepath.Join(debDir, "old.deb")
+	err := os.WriteFile(oldDeb, testutil.MustMakeDebWithControl(refreshControl, []testutil.TarEntry{
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+	}), 0644)
+	c.Assert(err, IsNil)
+	newDeb := filepath.Join(debDir, "new.deb")
+	err = os.WriteFile(newDeb, testutil.MustMakeDebWithControl("Package: otherpkg\nVersion: 1.0\nArchitecture: amd64\n", nil), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"refresh-slices", "--release", releaseDir, oldDeb, newDeb})
+	c.Assert(err, ErrorMatches, "cannot compare different packages: mypkg and otherpkg")
+
+	err = os.WriteFile(newDeb, testutil.MustMakeDebWithControl(refreshControl, []testutil.TarEntry{
+		testutil.Reg(0755, "./usr/bin/app", "app"),
+	}), 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"refresh-slices", "--release", releaseDir, oldDeb, newDeb})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stderr(), Equals, "Slices of package mypkg are up to date with version 0.9\n")
+}
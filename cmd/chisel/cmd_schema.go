@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortSchemaHelp = "Show the JSON Schema of release files"
+var longSchemaHelp = `
+The schema command shows the JSON Schema of the chisel.yaml file with the
+"release" argument, or of the slice definition files with the "slices"
+argument. The schema is generated from the same definitions Chisel uses to
+parse the files, so editors may use it for completion and validation.
+`
+
+type cmdSchema struct {
+	Positional struct {
+		Kind string `positional-arg-name:"<release|slices>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("schema", shortSchemaHelp, longSchemaHelp, func() flags.Commander { return &cmdSchema{} }, nil, nil)
+}
+
+func (cmd *cmdSchema) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var data []byte
+	var err error
+	switch cmd.Positional.Kind {
+	case "release":
+		data, err = setup.ReleaseSchema()
+	case "slices":
+		data, err = setup.PackageSchema()
+	default:
+		return usageErrorf(`invalid schema %q, expected "release" or "slices"`, cmd.Positional.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = Stdout.Write(data)
+	return err
+}
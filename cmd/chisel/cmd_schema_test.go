@@ -0,0 +1,26 @@
+package main_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *ChiselSuite) TestSchema(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"schema", "release"})
+	c.Assert(err, IsNil)
+	schema, err := setup.ReleaseSchema()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, string(schema))
+
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"schema", "slices"})
+	c.Assert(err, IsNil)
+	schema, err = setup.PackageSchema()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, string(schema))
+
+	_, err = chisel.Parser().ParseArgs([]string{"schema", "other"})
+	c.Assert(err, ErrorMatches, `invalid schema "other", expected "release" or "slices"`)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/metrics"
+)
+
+var shortServeBuildHelp = "Serve cut requests over standard input"
+var longServeBuildHelp = `
+The serve-build command runs cuts requested by another program, such as an
+image builder, without parsing the output of the cut command. Requests are
+read from standard input as JSON objects, one per line, holding an "id"
+chosen by the caller and a "selection" in the format of the selection files
+of the cut command:
+
+  {"id": "1", "selection": {"release": "ubuntu-24.04", "slices": ["base-files_base"], "output": {"root": "rootfs"}}}
+
+Requests are run one at a time, and their progress and result are written
+to standard output as JSON objects, one per line, with the "id" of the
+request and the "event", which is one of:
+
+  log:   a progress message, in "message"
+  done:  the cut succeeded
+  error: the cut failed, with the reason in "error" and the exit code the
+         cut command would have used in "exit-code"
+
+The "done" and "error" events also hold in "metrics" the bytes downloaded,
+the cache lookups and the seconds spent in each phase of the cut.
+
+Releases are read and archives opened only once and kept for the following
+requests, so changes to release directories are not seen until the command
+is run again. The command exits once standard input is closed. See also the
+daemon command, which serves requests from many clients.
+`
+
+type cmdServeBuild struct{}
+
+func init() {
+	addCommand("serve-build", shortServeBuildHelp, longServeBuildHelp, func() flags.Commander { return &cmdServeBuild{} }, nil, nil)
+}
+
+type buildRequest struct {
+	ID        string          `json:"id"`
+	Selection json.RawMessage `json:"selection"`
+}
+
+type buildEvent struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit-code,omitempty"`
+
+	Metrics *metrics.Snapshot `json:"metrics,omitempty"`
+}
+
+// buildEvents writes the events of the requests read from one connection,
+// attributing the messages logged meanwhile to the current request.
+type buildEvents struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	id      string
+}
+
+func (e *buildEvents) send(event *buildEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.encoder.Encode(event)
+}
+
+func (e *buildEvents) setID(id string) {
+	e.mu.Lock()
+	e.id = id
+	e.mu.Unlock()
+}
+
+func (e *buildEvents) Write(data []byte) (int, error) {
+	e.mu.Lock()
+	id := e.id
+	e.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		e.send(&buildEvent{ID: id, Event: "log", Message: line})
+	}
+	return len(data), nil
+}
+
+// buildServer runs the cuts requested through any number of connections,
+// one at a time, as the log and the caches are shared by all of them.
+type buildServer struct {
+	mu sync.Mutex
+	// ctx optionally cancels the cuts run.
+	ctx context.Context
+}
+
+func (cmd *cmdServeBuild) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	activeCache = newBuildCache(0)
+	defer func() {
+		activeCache.close()
+		activeCache = nil
+	}()
+
+	server := &buildServer{}
+	return server.serve(Stdin, Stdout)
+}
+
+// serve runs the requests read from reader, writing their events to writer,
+// until reader is closed.
+func (server *buildServer) serve(reader io.Reader, writer io.Writer) error {
+	events := &buildEvents{encoder: json.NewEncoder(writer)}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req buildRequest
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&req)
+		if err == nil && len(req.Selection) == 0 {
+			err = fmt.Errorf("no selection")
+		}
+		if err != nil {
+			events.send(&buildEvent{
+				ID:       req.ID,
+				Event:    "error",
+				Error:    fmt.Sprintf("invalid request: %v", err),
+				ExitCode: exitUsage,
+			})
+			continue
+		}
+
+		done, err := server.run(req.ID, req.Selection, events)
+		if err != nil {
+			events.send(&buildEvent{ID: req.ID, Event: "error", Error: err.Error(), ExitCode: exitCode(err), Metrics: done})
+		} else {
+			events.send(&buildEvent{ID: req.ID, Event: "done", Metrics: done})
+		}
+	}
+	return scanner.Err()
+}
+
+// run runs the cut for the selection of a request, sending the messages
+// logged meanwhile as its events. It returns the change in the metrics
+// while the cut ran, if it did.
+func (server *buildServer) run(id string, data []byte, events *buildEvents) (*metrics.Snapshot, error) {
+	// JSON is also YAML, so selections are parsed as selection files.
+	file, err := parseSelection(data)
+	if err != nil {
+		return nil, usageErrorf("invalid selection: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// Cuts run one at a time, so the metrics change only due to this one.
+	start := metrics.Take()
+
+	// Log messages are sent through the standard logger, which is turned
+	// into events for as long as the request runs.
+	events.setID(id)
+	defer events.setID("")
+	oldWriter, oldFlags := log.Writer(), log.Flags()
+	log.SetOutput(events)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(oldWriter)
+		log.SetFlags(oldFlags)
+	}()
+
+	cut := &cmdCut{ctx: server.ctx}
+	cut.applySelection(file)
+	err = cut.Execute(nil)
+	return metrics.Take().Since(start), err
+}
@@ -0,0 +1,73 @@
+package main_test
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type buildEvent struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	Message  string `json:"message"`
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit-code"`
+
+	Metrics *buildMetrics `json:"metrics"`
+}
+
+type buildMetrics struct {
+	Cuts       int64 `json:"cuts"`
+	FailedCuts int64 `json:"failed-cuts"`
+}
+
+func (s *ChiselSuite) TestServeBuild(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+	slicer.SetLogger(log.Default())
+	defer slicer.SetLogger(nil)
+
+	rootDir := filepath.Join(c.MkDir(), "root")
+	requests := []string{
+		`{"id": "1", "selection": {"release": "` + releaseDir + `", "slices": ["mypkg_bins", "mypkg_manifest"], "output": {"root": "` + rootDir + `"}}}`,
+		`{"id": "2", "selection": {"release": "` + releaseDir + `", "slices": ["mypkg_missing"], "output": {"root": "` + rootDir + `"}}}`,
+		`{"id": "3", "selection": {"slices": []}}`,
+		`{"id": "4"}`,
+		`not json`,
+	}
+	s.stdin.WriteString(strings.Join(requests, "\n") + "\n")
+
+	_, err := chisel.Parser().ParseArgs([]string{"serve-build"})
+	c.Assert(err, IsNil)
+
+	var events []*buildEvent
+	logged := false
+	for _, line := range strings.Split(strings.TrimSpace(s.Stdout()), "\n") {
+		var event buildEvent
+		err := json.Unmarshal([]byte(line), &event)
+		c.Assert(err, IsNil)
+		if event.Event == "log" {
+			c.Assert(event.ID, Equals, "1")
+			c.Assert(event.Message, Not(Equals), "")
+			logged = true
+			continue
+		}
+		events = append(events, &event)
+	}
+	c.Assert(logged, Equals, true)
+	c.Assert(events, DeepEquals, []*buildEvent{
+		{ID: "1", Event: "done", Metrics: &buildMetrics{Cuts: 1}},
+		{ID: "2", Event: "error", Error: `slice mypkg_missing not found`, ExitCode: 4, Metrics: &buildMetrics{Cuts: 1, FailedCuts: 1}},
+		{ID: "3", Event: "error", Error: `invalid selection: no slices listed`, ExitCode: 2},
+		{ID: "4", Event: "error", Error: `invalid request: no selection`, ExitCode: 2},
+		{Event: "error", Error: `invalid request: invalid character 'o' in literal null (expecting 'u')`, ExitCode: 2},
+	})
+	c.Assert(testutil.TreeDump(rootDir)["/usr/bin/app"], Equals, "file 0755 a172cedc")
+}
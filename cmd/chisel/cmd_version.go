@@ -28,6 +28,10 @@ func (cmd cmdVersion) Execute(args []string) error {
 }
 
 func printVersions() error {
-	fmt.Fprintf(Stdout, "%s\n", cmd.Version)
+	fmt.Fprintf(Stdout, "%s\n", chiselVersion())
 	return nil
 }
+
+func chiselVersion() string {
+	return cmd.Version
+}
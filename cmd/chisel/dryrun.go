@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+// dryRunPackage is a package which would be fetched by a cut.
+type dryRunPackage struct {
+	name    string
+	info    *archive.PackageInfo
+	archive string
+	slices  []*setup.Slice
+	// estimate is the size of the content extracted by the slices, which
+	// is exact when the package is in the cache, and otherwise the size
+	// of the whole package once installed.
+	estimate int64
+	exact    bool
+}
+
+// writeDryRun writes the packages which would be fetched to cut the
+// selection, along with the archive they would be fetched from and the
+// slices selected. With estimate, the size the content of every package
+// would take in the tree is estimated too, without fetching the packages.
+func writeDryRun(release *setup.Release, selection *setup.Selection, archives map[string]archive.Archive, local archive.Archive, estimate bool) error {
+	var packages []*dryRunPackage
+	byName := make(map[string]*dryRunPackage)
+	for _, slice := range selection.Slices {
+		pkg, ok := byName[slice.Package]
+		if !ok {
+			pkg = &dryRunPackage{name: slice.Package}
+			byName[slice.Package] = pkg
+			packages = append(packages, pkg)
+		}
+		pkg.slices = append(pkg.slices, slice)
+	}
+	slices.SortFunc(packages, func(a, b *dryRunPackage) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	pkgCache := &cache.Cache{Dir: cache.DefaultDir("chisel")}
+	for _, pkg := range packages {
+		var pkgArchive archive.Archive
+		if local != nil && local.Exists(pkg.name) {
+			pkgArchive = local
+			pkg.archive = local.Options().Label
+		} else {
+			var err error
+			pkgArchive, err = slicer.ResolveArchive(release, archives, pkg.name)
+			if err != nil {
+				return err
+			}
+			if pkgArchive == nil {
+				return fmt.Errorf("cannot find package %q in archive(s)", pkg.name)
+			}
+			pkg.archive = pkgArchive.Options().Label
+		}
+		info, err := pkgArchive.Info(pkg.name)
+		if err != nil {
+			return err
+		}
+		pkg.info = info
+		if estimate {
+			err = estimatePackage(release, pkg, pkgArchive.Options().Arch, pkgCache)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w := tabWriter()
+	if estimate {
+		fmt.Fprintf(w, "Package\tVersion\tArchive\tSlices\tInstalled\tEstimate\n")
+	} else {
+		fmt.Fprintf(w, "Package\tVersion\tArchive\tSlices\n")
+	}
+	var totalInstalled, totalEstimate int64
+	allExact := true
+	for _, pkg := range packages {
+		sliceNames := make([]string, len(pkg.slices))
+		for i, slice := range pkg.slices {
+			sliceNames[i] = slice.Name
+		}
+		slices.Sort(sliceNames)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s", pkg.name, pkg.info.Version, pkg.archive, strings.Join(sliceNames, ","))
+		if estimate {
+			fmt.Fprintf(w, "\t%s\t%s", formatInstalledSize(pkg.info.InstalledSize), formatEstimate(pkg.estimate, pkg.exact))
+			totalInstalled += pkg.info.InstalledSize
+			totalEstimate += pkg.estimate
+			allExact = allExact && pkg.exact
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if estimate {
+		fmt.Fprintf(w, "Total\t\t\t\t%s\t%s\n", formatInstalledSize(totalInstalled), formatEstimate(totalEstimate, allExact))
+	}
+	return w.Flush()
+}
+
+// estimatePackage estimates the size of the content the slices of pkg
+// extract. The paths are resolved from the package contents when the
+// package is already in the cache, as nothing is fetched, and otherwise
+// the size of the package once installed is used as an upper bound.
+func estimatePackage(release *setup.Release, pkg *dryRunPackage, arch string, pkgCache *cache.Cache) error {
+	pkg.estimate = pkg.info.InstalledSize
+	reader, err := pkgCache.Open(pkg.info.SHA256)
+	if err == cache.MissErr {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+	contents, err := slicer.ListPackage(reader, slicer.PathMapper(release))
+	if err != nil {
+		return fmt.Errorf("cannot read package %q: %w", pkg.name, err)
+	}
+	extracted := make(map[string]bool)
+	pkg.estimate = 0
+	for _, slice := range pkg.slices {
+		resolved, _ := slicer.ResolveSlice(slice, arch, contents)
+		for _, path := range resolved {
+			if !extracted[path.Target] {
+				extracted[path.Target] = true
+				pkg.estimate += path.Size
+			}
+		}
+	}
+	pkg.exact = true
+	return nil
+}
+
+func formatInstalledSize(size int64) string {
+	if size == 0 {
+		return "-"
+	}
+	return formatSize(size)
+}
+
+// formatEstimate formats the estimated size, which is an upper bound when
+// not exact.
+func formatEstimate(size int64, exact bool) string {
+	if exact {
+		return formatSize(size)
+	}
+	if size == 0 {
+		return "-"
+	}
+	return "<= " + formatSize(size)
+}
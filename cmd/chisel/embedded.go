@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/release"
+)
+
+// embeddedPrefix marks the names of the releases embedded in the binary.
+const embeddedPrefix = "embedded:"
+
+// embeddedReleaseDir writes the release embedded with the given name into
+// the cache, if not there already, and returns the directory holding it.
+// The directory is named after the digest of the release, so that binaries
+// embedding different content under the same name do not clash.
+func embeddedReleaseDir(name string) (string, error) {
+	fsys, ok := release.Embedded(name)
+	if !ok {
+		names := release.EmbeddedNames()
+		if len(names) == 0 {
+			return "", usageErrorf("no embedded release %q: this binary embeds no releases", name)
+		}
+		return "", usageErrorf("no embedded release %q, embedded releases: %s", name, strings.Join(names, ", "))
+	}
+	digest, err := setup.ReleaseDigestFS(fsys)
+	if err != nil {
+		return "", fmt.Errorf("cannot read embedded release %q: %w", name, err)
+	}
+	dir := filepath.Join(cache.DefaultDir("chisel"), "releases", "embedded", name+"-"+digest[:16])
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	// The release is written aside and then moved into place, so that it is
+	// never seen partially written by other processes.
+	err = os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return "", fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-"+name+"-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	err = os.CopyFS(tmpDir, fsys)
+	if err != nil {
+		return "", fmt.Errorf("cannot write embedded release %q: %w", name, err)
+	}
+	err = os.Rename(tmpDir, dir)
+	if err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			// Written meanwhile by another process.
+			return dir, nil
+		}
+		return "", fmt.Errorf("cannot write embedded release %q: %w", name, err)
+	}
+	return dir, nil
+}
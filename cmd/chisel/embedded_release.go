@@ -0,0 +1,33 @@
+//go:build embedrelease
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/canonical/chisel/public/release"
+)
+
+// embeddedFS holds the releases embedded when building with the
+// "embedrelease" tag, one in each directory within embedded/.
+//
+//go:embed all:embedded
+var embeddedFS embed.FS
+
+func init() {
+	entries, err := fs.ReadDir(embeddedFS, "embedded")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fsys, err := fs.Sub(embeddedFS, "embedded/"+entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		release.Embed(entry.Name(), fsys)
+	}
+}
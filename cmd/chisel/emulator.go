@@ -0,0 +1,313 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type emulatedArch struct {
+	goArch  string
+	machine elf.Machine
+	class   elf.Class
+	data    elf.Data
+	qemu    string
+}
+
+var emulatedArchs = []emulatedArch{
+	{"386", elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB, "i386"},
+	{"amd64", elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB, "x86_64"},
+	{"arm", elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, "arm"},
+	{"arm64", elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB, "aarch64"},
+	{"ppc64le", elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB, "ppc64le"},
+	{"riscv64", elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB, "riscv64"},
+	{"s390x", elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB, "s390x"},
+}
+
+// nativeArchs lists the architectures run natively by the host in addition
+// to its own.
+var nativeArchs = map[string][]string{
+	"amd64": {"386"},
+}
+
+var hostGoArch = runtime.GOARCH
+
+var binfmtDir = "/proc/sys/fs/binfmt_misc"
+
+// binfmtEntry is an entry of binfmt_misc registering an interpreter for
+// binaries of an architecture.
+type binfmtEntry struct {
+	enabled     bool
+	interpreter string
+	// fixed reports whether the kernel opened the interpreter when it was
+	// registered, so that it need not exist within the tree.
+	fixed bool
+}
+
+// readBinfmt returns the binfmt_misc entry for the qemu emulator of the
+// architecture, or nil if there is none.
+func readBinfmt(qemuArch string) (*binfmtEntry, error) {
+	status, err := os.ReadFile(filepath.Join(binfmtDir, "status"))
+	if err != nil || strings.TrimSpace(string(status)) != "enabled" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(binfmtDir, "qemu-"+qemuArch))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read binfmt_misc entry: %w", err)
+	}
+	entry := &binfmtEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "enabled":
+			entry.enabled = true
+		case "interpreter":
+			entry.interpreter = value
+		case "flags:":
+			entry.fixed = strings.Contains(value, "F")
+		}
+	}
+	return entry, nil
+}
+
+// emulation describes how commands of a foreign architecture are run in a
+// tree, with the files installed in the tree for that purpose.
+type emulation struct {
+	// wrapper is the path of the emulator within the tree when it must be
+	// run explicitly, for lack of a binfmt_misc entry.
+	wrapper string
+	// created lists the paths created in the tree, in creation order.
+	created []string
+}
+
+// prepareEmulation checks whether the command at cmdPath within the tree
+// is built for an architecture the host runs natively and, if not,
+// installs the qemu user emulator needed to run it. It returns nil if no
+// emulation is needed.
+func prepareEmulation(rootDir, cmdPath, emulator string) (*emulation, error) {
+	arch, err := commandArch(rootDir, cmdPath)
+	if err != nil || arch == nil {
+		return nil, err
+	}
+	debugf("Running %s binaries on %s with qemu-%s", arch.goArch, hostGoArch, arch.qemu)
+
+	entry, err := readBinfmt(arch.qemu)
+	if err != nil {
+		return nil, err
+	}
+	e := &emulation{}
+	if entry != nil && entry.enabled {
+		if entry.fixed {
+			debugf("Using the emulator registered in binfmt_misc: %s", entry.interpreter)
+			return e, nil
+		}
+		err = e.install(rootDir, entry.interpreter, entry.interpreter)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	if emulator == "" {
+		emulator, err = exec.LookPath("qemu-" + arch.qemu + "-static")
+		if err != nil {
+			return nil, fmt.Errorf("cannot run %s binaries: qemu-%s-static not found, install qemu-user-static or use --emulator", arch.goArch, arch.qemu)
+		}
+	}
+	logf("Warning: No binfmt_misc entry for qemu-%s, commands run by %s will not be emulated", arch.qemu, cmdPath)
+	e.wrapper = "/usr/bin/qemu-" + arch.qemu + "-static"
+	err = e.install(rootDir, emulator, e.wrapper)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// wrap returns the path and arguments running the command with the
+// emulator, if it must be run explicitly.
+func (e *emulation) wrap(cmdPath string, args []string) (string, []string) {
+	if e.wrapper == "" {
+		return cmdPath, args
+	}
+	wrapped := []string{e.wrapper, "-0", args[0], cmdPath}
+	return e.wrapper, append(wrapped, args[1:]...)
+}
+
+// install copies the emulator at hostPath to treePath within the tree,
+// unless the tree has it already. Symlinks in treePath are followed within
+// the tree.
+func (e *emulation) install(rootDir, hostPath, treePath string) error {
+	if _, err := resolveInTree(rootDir, treePath); err == nil {
+		return nil
+	}
+	// Find the closest parent directory existing in the tree, and create
+	// the missing ones under it.
+	var missing []string
+	dir := path.Dir(treePath)
+	realDir, err := resolveInTree(rootDir, dir)
+	for errors.Is(err, fs.ErrNotExist) {
+		missing = append([]string{path.Base(dir)}, missing...)
+		dir = path.Dir(dir)
+		realDir, err = resolveInTree(rootDir, dir)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot install emulator: %w", err)
+	}
+	for _, name := range missing {
+		realDir = path.Join(realDir, name)
+		err := os.Mkdir(filepath.Join(rootDir, realDir), 0755)
+		if err != nil {
+			return fmt.Errorf("cannot install emulator: %w", err)
+		}
+		e.created = append(e.created, realDir)
+	}
+
+	source, err := os.Open(hostPath)
+	if err != nil {
+		return fmt.Errorf("cannot install emulator: %w", err)
+	}
+	defer source.Close()
+	realPath := path.Join(realDir, path.Base(treePath))
+	target, err := os.OpenFile(filepath.Join(rootDir, realPath), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot install emulator: %w", err)
+	}
+	e.created = append(e.created, realPath)
+	_, err = io.Copy(target, source)
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot install emulator: %w", err)
+	}
+	debugf("Installed emulator %s as %s", hostPath, treePath)
+	return nil
+}
+
+// cleanup removes the files installed in the tree.
+func (e *emulation) cleanup(rootDir string) {
+	for i := len(e.created) - 1; i >= 0; i-- {
+		err := os.Remove(filepath.Join(rootDir, e.created[i]))
+		if err != nil {
+			logf("Warning: Cannot remove %s from the tree: %s", e.created[i], err)
+		}
+	}
+}
+
+// commandArch returns the architecture of the command at cmdPath within
+// the tree, or nil if it runs natively or is not an ELF binary. Scripts
+// have the architecture of their interpreter.
+func commandArch(rootDir, cmdPath string) (*emulatedArch, error) {
+	realPath, err := resolveInTree(rootDir, cmdPath)
+	if err != nil {
+		return nil, err
+	}
+	interp, err := scriptInterpreter(filepath.Join(rootDir, realPath))
+	if err != nil {
+		return nil, err
+	}
+	if interp != "" {
+		realPath, err = resolveInTree(rootDir, interp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file, err := elf.Open(filepath.Join(rootDir, realPath))
+	if err != nil {
+		// Not an ELF binary, left to the kernel.
+		return nil, nil
+	}
+	defer file.Close()
+	for i, arch := range emulatedArchs {
+		if arch.machine != file.Machine || arch.class != file.Class || arch.data != file.Data {
+			continue
+		}
+		if arch.goArch == hostGoArch {
+			return nil, nil
+		}
+		for _, native := range nativeArchs[hostGoArch] {
+			if arch.goArch == native {
+				return nil, nil
+			}
+		}
+		return &emulatedArchs[i], nil
+	}
+	return nil, fmt.Errorf("cannot run %s binaries on %s", strings.TrimPrefix(file.Machine.String(), "EM_"), hostGoArch)
+}
+
+// scriptInterpreter returns the interpreter in the first line of the script
+// at filePath, or an empty string if it is not a script.
+func scriptInterpreter(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadSlice('\n')
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	if !bytes.HasPrefix(line, []byte("#!")) {
+		return "", nil
+	}
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// resolveInTree returns the path relPath resolves to within the tree, with
+// all symlinks followed as if the tree were the root directory.
+func resolveInTree(rootDir, relPath string) (string, error) {
+	resolved := "/"
+	pending := strings.Split(strings.Trim(path.Clean("/"+relPath), "/"), "/")
+	hops := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(rootDir, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		hops++
+		if hops > 40 {
+			return "", fmt.Errorf("cannot resolve %s: too many levels of symbolic links", relPath)
+		}
+		target, err := os.Readlink(filepath.Join(rootDir, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+func FakeEmulation(hostArch, binfmt string) (restore func()) {
+	oldHostGoArch := hostGoArch
+	oldBinfmtDir := binfmtDir
+	hostGoArch = hostArch
+	binfmtDir = binfmt
+	return func() {
+		hostGoArch = oldHostGoArch
+		binfmtDir = oldBinfmtDir
+	}
+}
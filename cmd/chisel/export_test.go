@@ -1,9 +1,21 @@
 package main
 
-import "github.com/canonical/chisel/internal/archive"
+import (
+	"context"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/security"
+)
 
 var RunMain = run
 
+var ExitCode = exitCode
+
+var LockRoot = lockRoot
+
 func FakeIsStdoutTTY(t bool) (restore func()) {
 	oldIsStdoutTTY := isStdoutTTY
 	isStdoutTTY = t
@@ -22,6 +34,19 @@ func FakeIsStdinTTY(t bool) (restore func()) {
 
 var FindSlices = findSlices
 
+var ApplyPins = applyPins
+
+// RunCut runs the cut command with args, cancelled through ctx as when run
+// by the daemon.
+func RunCut(ctx context.Context, args []string) error {
+	cmd := &cmdCut{ctx: ctx}
+	rest, err := flags.ParseArgs(cmd, args)
+	if err != nil {
+		return err
+	}
+	return cmd.Execute(rest)
+}
+
 func FakeArchiveOpen(f func(_ *archive.Options) (archive.Archive, error)) (restore func()) {
 	oldArchiveOpen := archiveOpen
 	archiveOpen = f
@@ -29,3 +54,47 @@ func FakeArchiveOpen(f func(_ *archive.Options) (archive.Archive, error)) (resto
 		archiveOpen = oldArchiveOpen
 	}
 }
+
+func FakeSecurityFetch(f func(options *security.FetchOptions) ([]*security.Notice, error)) (restore func()) {
+	oldSecurityFetch := securityFetch
+	securityFetch = f
+	return func() {
+		securityFetch = oldSecurityFetch
+	}
+}
+
+// ExitStatusCode returns the exit code of a value recovered from a panic
+// with an exit status.
+func ExitStatusCode(v any) (code int, ok bool) {
+	if e, ok := v.(*exitStatus); ok {
+		return e.code, true
+	}
+	return 0, false
+}
+
+func FakeWatch(interval time.Duration, checked func(err error)) (restore func()) {
+	oldInterval := watchInterval
+	oldChecked := watchChecked
+	watchInterval = interval
+	watchChecked = checked
+	return func() {
+		watchInterval = oldInterval
+		watchChecked = oldChecked
+	}
+}
+
+func FakeArchiveFindPath(f func(a archive.Archive, pattern string) ([]archive.PathPackages, error)) (restore func()) {
+	oldArchiveFindPath := archiveFindPath
+	archiveFindPath = f
+	return func() {
+		archiveFindPath = oldArchiveFindPath
+	}
+}
+
+func FakeArchiveFetchChangelog(f func(a archive.Archive, pkg string) ([]byte, error)) (restore func()) {
+	oldArchiveFetchChangelog := archiveFetchChangelog
+	archiveFetchChangelog = f
+	return func() {
+		archiveFetchChangelog = oldArchiveFetchChangelog
+	}
+}
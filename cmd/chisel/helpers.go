@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/security"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 // TODO These need testing
@@ -16,7 +25,7 @@ var releaseExp = regexp.MustCompile(`^([a-z](?:-?[a-z0-9]){2,})-([0-9]+(?:\.?[0-
 func parseReleaseInfo(release string) (label, version string, err error) {
 	match := releaseExp.FindStringSubmatch(release)
 	if match == nil {
-		return "", "", fmt.Errorf("invalid release reference: %q", release)
+		return "", "", usageErrorf("invalid release reference: %q", release)
 	}
 	return match[1], match[2], nil
 }
@@ -38,7 +47,7 @@ func readReleaseInfo() (label, version string, err error) {
 			}
 		}
 	}
-	return "", "", fmt.Errorf("cannot infer release via /etc/lsb-release, see the --release option")
+	return "", "", usageErrorf("cannot infer release via /etc/lsb-release, see the --release option")
 }
 
 // obtainRelease returns the Chisel release information matching the provided string,
@@ -47,25 +56,347 @@ func readReleaseInfo() (label, version string, err error) {
 // * the path to a directory containing a previously fetched release,
 // * "" and Chisel will attempt to read the release label from the host.
 func obtainRelease(releaseStr string) (release *setup.Release, err error) {
+	return obtainFreshRelease(releaseStr, false)
+}
+
+// obtainFreshRelease is like obtainRelease, but fetches the release again
+// if refresh is true, even if pinned to a ref, accepting content which
+// changed since it was fetched before.
+func obtainFreshRelease(releaseStr string, refresh bool) (release *setup.Release, err error) {
+	if activeCache != nil && !refresh {
+		if release := activeCache.release(releaseStr); release != nil {
+			return release, nil
+		}
+	}
+	dir, err := fetchReleaseDir(releaseStr, refresh)
+	if err != nil {
+		return nil, err
+	}
+	release, err = setup.ReadCachedRelease(dir, cache.DefaultDir("chisel"))
+	if err != nil {
+		return nil, err
+	}
+	if activeCache != nil {
+		activeCache.addRelease(releaseStr, release)
+	}
+	return release, nil
+}
+
+// obtainReleaseDir is like obtainRelease, but returns the directory holding
+// the release instead of reading it.
+func obtainReleaseDir(releaseStr string) (dir string, err error) {
+	return fetchReleaseDir(releaseStr, false)
+}
+
+func fetchReleaseDir(releaseStr string, refresh bool) (dir string, err error) {
+	if name, ok := strings.CutPrefix(releaseStr, embeddedPrefix); ok {
+		return embeddedReleaseDir(name)
+	}
 	if strings.Contains(releaseStr, "/") {
-		release, err = setup.ReadRelease(releaseStr)
+		return releaseStr, nil
+	}
+	options, err := releaseFetchOptions(releaseStr)
+	if err != nil {
+		return "", err
+	}
+	options.Refresh = refresh
+	return setup.FetchReleaseDir(options)
+}
+
+// cachedReleaseDir is like obtainReleaseDir, but never fetches the release.
+// The directory returned may not exist if it was not fetched before.
+func cachedReleaseDir(releaseStr string) (dir string, err error) {
+	if name, ok := strings.CutPrefix(releaseStr, embeddedPrefix); ok {
+		return embeddedReleaseDir(name)
+	}
+	if strings.Contains(releaseStr, "/") {
+		return releaseStr, nil
+	}
+	options, err := releaseFetchOptions(releaseStr)
+	if err != nil {
+		return "", err
+	}
+	return setup.ReleaseCacheDir(options), nil
+}
+
+// releaseFetchOptions returns the options for fetching the release named
+// <label>-<version>, optionally followed by @<commit> or @<tag> to pin it,
+// or the release of the host if no name is given.
+func releaseFetchOptions(releaseStr string) (*setup.FetchOptions, error) {
+	var label, version string
+	var err error
+	releaseStr, ref, pinned := strings.Cut(releaseStr, "@")
+	if pinned && !releaseRefExp.MatchString(ref) {
+		return nil, usageErrorf("invalid release ref: %q", ref)
+	}
+	if releaseStr == "" {
+		label, version, err = readReleaseInfo()
 	} else {
-		var label, version string
-		if releaseStr == "" {
-			label, version, err = readReleaseInfo()
-		} else {
-			label, version, err = parseReleaseInfo(releaseStr)
+		label, version, err = parseReleaseInfo(releaseStr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &setup.FetchOptions{
+		Label:   label,
+		Version: version,
+		Ref:     ref,
+	}, nil
+}
+
+var releaseRefExp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// httpFlags holds the command line options overriding the configuration of
+// the requests made to all the archives of the release.
+type httpFlags struct {
+	HTTPTimeout     time.Duration `long:"http-timeout" value-name:"<duration>"`
+	HTTPBulkTimeout time.Duration `long:"http-bulk-timeout" value-name:"<duration>"`
+	HTTPHeaders     []string      `long:"http-header" value-name:"<name>:<value>"`
+	UserAgent       string        `long:"user-agent" value-name:"<agent>"`
+	ChunkThreshold  string        `long:"http-chunk-threshold" value-name:"<size>"`
+	ChunkSize       string        `long:"http-chunk-size" value-name:"<size>"`
+}
+
+var httpDescs = map[string]string{
+	"http-timeout":         "Timeout of the requests for small archive files",
+	"http-bulk-timeout":    "Timeout of the requests for indexes and packages",
+	"http-header":          "Header added to the requests made to the archives",
+	"user-agent":           "User-Agent of the requests made to the archives",
+	"http-chunk-threshold": "Fetch packages of this size in concurrent chunks",
+	"http-chunk-size":      "Size of the chunks packages are fetched in",
+}
+
+// withHTTPDescs returns the option descriptions of a command along with the
+// ones of httpFlags.
+func withHTTPDescs(descs map[string]string) map[string]string {
+	all := make(map[string]string, len(descs)+len(httpDescs))
+	maps.Copy(all, descs)
+	maps.Copy(all, httpDescs)
+	return all
+}
+
+// options returns the HTTP options set on the command line, or nil if none.
+func (f *httpFlags) options() (*archive.HTTPOptions, error) {
+	options := &archive.HTTPOptions{
+		Timeout:     f.HTTPTimeout,
+		BulkTimeout: f.HTTPBulkTimeout,
+		UserAgent:   f.UserAgent,
+	}
+	for _, header := range f.HTTPHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, usageErrorf("invalid --http-header, expected <name>:<value>: %q", header)
+		}
+		if options.Headers == nil {
+			options.Headers = make(map[string]string)
 		}
+		options.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	var err error
+	if f.ChunkThreshold != "" {
+		options.ChunkThreshold, err = archive.ParseSize(f.ChunkThreshold)
 		if err != nil {
-			return nil, err
+			return nil, usageErrorf("invalid --http-chunk-threshold: %v", err)
+		}
+	}
+	if f.ChunkSize != "" {
+		options.ChunkSize, err = archive.ParseSize(f.ChunkSize)
+		if err != nil {
+			return nil, usageErrorf("invalid --http-chunk-size: %v", err)
+		}
+	}
+	err = options.Validate()
+	if err != nil {
+		return nil, usageErrorf("%v", err)
+	}
+	if options.Timeout == 0 && options.BulkTimeout == 0 && options.UserAgent == "" && options.Headers == nil &&
+		options.ChunkThreshold == 0 && options.ChunkSize == 0 {
+		return nil, nil
+	}
+	return options, nil
+}
+
+// mergeHTTP returns the HTTP options of an archive with the ones set in
+// override, if any, taking precedence.
+func mergeHTTP(base archive.HTTPOptions, override *archive.HTTPOptions) archive.HTTPOptions {
+	if override == nil {
+		return base
+	}
+	if override.Timeout != 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.BulkTimeout != 0 {
+		base.BulkTimeout = override.BulkTimeout
+	}
+	if override.UserAgent != "" {
+		base.UserAgent = override.UserAgent
+	}
+	if override.ChunkThreshold != 0 {
+		base.ChunkThreshold = override.ChunkThreshold
+	}
+	if override.ChunkSize != 0 {
+		base.ChunkSize = override.ChunkSize
+	}
+	if len(override.Headers) > 0 {
+		headers := maps.Clone(base.Headers)
+		if headers == nil {
+			headers = make(map[string]string)
 		}
-		release, err = setup.FetchRelease(&setup.FetchOptions{
-			Label:   label,
-			Version: version,
+		maps.Copy(headers, override.Headers)
+		base.Headers = headers
+	}
+	return base
+}
+
+// openArchives opens the archives of the release for the provided
+// architecture, indexed by name. Archives for which credentials are not
+// found are skipped. With refresh, the archive indexes are downloaded again.
+// The requests made by the archives are cancelled along with ctx, and are
+// configured by the HTTP options of each archive along with the ones in
+// httpOverride, if any.
+//
+// With an active build cache, the archives opened before for the same
+// release and options are returned instead, with their requests cancelled
+// along with ctx from then on.
+func openArchives(ctx context.Context, release *setup.Release, arch string, refresh bool, httpOverride *archive.HTTPOptions) (map[string]archive.Archive, error) {
+	if activeCache != nil {
+		return activeCache.openArchives(ctx, release, arch, refresh, httpOverride)
+	}
+	return openArchivesUncached(ctx, release, arch, refresh, httpOverride)
+}
+
+func openArchivesUncached(ctx context.Context, release *setup.Release, arch string, refresh bool, httpOverride *archive.HTTPOptions) (map[string]archive.Archive, error) {
+	archives := make(map[string]archive.Archive)
+	for archiveName, archiveInfo := range release.Archives {
+		openArchive, err := archiveOpen(&archive.Options{
+			Label:      archiveName,
+			Version:    archiveInfo.Version,
+			Arch:       arch,
+			Suites:     archiveInfo.Suites,
+			Components: archiveInfo.Components,
+			Pro:        archiveInfo.Pro,
+			CacheDir:   cache.DefaultDir("chisel"),
+			PubKeys:    archiveInfo.PubKeys,
+			Maintained: archiveInfo.Maintained,
+			OldRelease: archiveInfo.OldRelease,
+			Refresh:    refresh,
+			Context:    ctx,
+			Verify:     archiveInfo.Verify,
+			HTTP:       mergeHTTP(archiveInfo.HTTP, httpOverride),
+			Mirrors:    archiveInfo.Mirrors,
 		})
+		if err != nil {
+			if err == archive.ErrCredentialsNotFound {
+				logf("Archive %q ignored: credentials not found", archiveName)
+				continue
+			}
+			closeArchives(archives)
+			return nil, err
+		}
+		archives[archiveName] = openArchive
+	}
+	return archives, nil
+}
+
+// releaseCodename returns the codename of the Ubuntu release, as found in
+// the suites of its archives (e.g. "jammy" for "jammy-updates").
+func releaseCodename(release *setup.Release) (string, error) {
+	archives := make([]*setup.Archive, 0, len(release.Archives))
+	for _, archiveInfo := range release.Archives {
+		archives = append(archives, archiveInfo)
+	}
+	slices.SortFunc(archives, func(a, b *setup.Archive) int {
+		return b.Priority - a.Priority
+	})
+	for _, archiveInfo := range archives {
+		for _, suite := range archiveInfo.Suites {
+			codename, _, _ := strings.Cut(suite, "-")
+			if codename != "" {
+				return codename, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot find release codename in archive suites")
+}
+
+// auditPackages returns the known vulnerabilities affecting the packages,
+// based on the security notices published for the release.
+func auditPackages(release *setup.Release, pkgs []*archive.PackageInfo) ([]*security.Vulnerability, error) {
+	codename, err := releaseCodename(release)
+	if err != nil {
+		return nil, err
 	}
+	notices, err := securityFetch(&security.FetchOptions{
+		Codename: codename,
+		CacheDir: cache.DefaultDir("chisel"),
+	})
 	if err != nil {
 		return nil, err
 	}
-	return release, nil
+	return security.Audit(notices, pkgs), nil
+}
+
+// releaseCommit returns the commit of the release repository in dir, either
+// fetched into the cache or checked out, or "" if it cannot be found.
+func releaseCommit(dir string) string {
+	if commit, err := setup.ReleaseCommit(dir); err == nil && commit != "" {
+		return commit
+	}
+	return gitCommit(dir)
+}
+
+// gitCommit returns the commit checked out in the git repository at dir, or
+// "" if dir is not the top of a git checkout or the commit cannot be found.
+func gitCommit(dir string) string {
+	gitDir := filepath.Join(dir, ".git")
+	if data, err := os.ReadFile(gitDir); err == nil {
+		// Worktrees and submodules point to the repository elsewhere.
+		path, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return ""
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		gitDir = path
+	}
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(head, "ref: ")
+	if !ok {
+		return head
+	}
+	data, err = os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref)))
+	if err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	data, err = os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		commit, name, ok := strings.Cut(line, " ")
+		if ok && name == ref {
+			return commit
+		}
+	}
+	return ""
+}
+
+// readManifest reads the manifest at path, in any of the encodings it may
+// be written in.
+func readManifest(path string) (*manifest.Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer file.Close()
+	mfest, err := manifest.Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest %s: %w", path, err)
+	}
+	return mfest, nil
 }
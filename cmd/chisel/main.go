@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
 	//"github.com/canonical/chisel/internal/logger"
@@ -171,6 +173,13 @@ func Parser() *flags.Parser {
 		version.Description = "Print the version and exit"
 		version.Hidden = true
 	}
+	// print the completions requested via GO_FLAGS_COMPLETION to Stdout,
+	// instead of having go-flags print them and exit
+	parser.CompletionHandler = func(items []flags.Completion) {
+		for _, item := range items {
+			fmt.Fprintln(Stdout, item.Item)
+		}
+	}
 	// add --help like what go-flags would do for us, but hidden
 	err := addHelp(parser)
 	if err != nil {
@@ -306,7 +315,7 @@ func main() {
 
 	if err := run(); err != nil {
 		fmt.Fprintf(Stderr, errorPrefix+"%v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -322,12 +331,66 @@ func (e *exitStatus) Error() string {
 	return fmt.Sprintf("internal error: exitStatus{%d} being handled as normal error", e.code)
 }
 
+// Exit codes telling the kinds of failure apart, so that scripts may act
+// on them.
+const (
+	exitFailure    = 1
+	exitUsage      = 2
+	exitRelease    = 3
+	exitValidation = 4
+	exitNetwork    = 5
+	exitSignature  = 6
+	exitExtraction = 7
+)
+
+// usageError reports an invalid command line.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code for the kind of failure reported by err.
+func exitCode(err error) int {
+	var flagsErr *flags.Error
+	var usageErr *usageError
+	var parseErr *setup.ParseError
+	var validationErr *setup.ValidationError
+	var fetchErr *archive.FetchError
+	var verifyErr *archive.VerifyError
+	var extractErr *slicer.ExtractError
+	var changedErr *setup.ChangedError
+	switch {
+	case errors.As(err, &flagsErr), errors.As(err, &usageErr), errors.Is(err, ErrExtraArgs):
+		return exitUsage
+	case errors.As(err, &parseErr):
+		return exitRelease
+	case errors.As(err, &validationErr):
+		return exitValidation
+	case errors.As(err, &verifyErr), errors.As(err, &changedErr):
+		return exitSignature
+	case errors.As(err, &fetchErr):
+		return exitNetwork
+	case errors.As(err, &extractErr):
+		return exitExtraction
+	}
+	return exitFailure
+}
+
 func run() error {
-	archive.SetLogger(log.Default())
-	deb.SetLogger(log.Default())
-	setup.SetLogger(log.Default())
-	slicer.SetLogger(log.Default())
-	SetLogger(log.Default())
+	// Logs would garble the completions shown by the shell.
+	if os.Getenv("GO_FLAGS_COMPLETION") == "" {
+		archive.SetLogger(log.Default())
+		deb.SetLogger(log.Default())
+		fsutil.SetLogger(log.Default())
+		setup.SetLogger(log.Default())
+		slicer.SetLogger(log.Default())
+		SetLogger(log.Default())
+	}
 
 	parser := Parser()
 	xtra, err := parser.Parse()
@@ -349,7 +412,7 @@ func run() error {
 						sug = "chisel help " + x.Name
 					}
 				}
-				return fmt.Errorf("unknown command %q, see '%s'.", sub, sug)
+				return usageErrorf("unknown command %q, see '%s'.", sub, sug)
 			}
 		}
 		return err
@@ -2,6 +2,8 @@ package main_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 
@@ -9,6 +11,9 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/cmd"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
 	"github.com/canonical/chisel/internal/testutil"
 
 	chisel "github.com/canonical/chisel/cmd/chisel"
@@ -44,6 +49,11 @@ func (s *BaseChiselSuite) SetUpTest(c *C) {
 
 	s.AddCleanup(chisel.FakeIsStdoutTTY(false))
 	s.AddCleanup(chisel.FakeIsStdinTTY(false))
+
+	// Keep the locks and anything else cached out of the user's cache.
+	oldCacheHome := os.Getenv("XDG_CACHE_HOME")
+	s.AddCleanup(func() { os.Setenv("XDG_CACHE_HOME", oldCacheHome) })
+	os.Setenv("XDG_CACHE_HOME", c.MkDir())
 }
 
 func (s *BaseChiselSuite) TearDownTest(c *C) {
@@ -80,3 +90,26 @@ type ChiselSuite struct {
 }
 
 var _ = Suite(&ChiselSuite{})
+
+func (s *ChiselSuite) TestExitCode(c *C) {
+	releaseDir, _, restore := fakeCutRelease(c)
+	defer restore()
+
+	exitCode := func(args ...string) int {
+		_, err := chisel.Parser().ParseArgs(args)
+		c.Assert(err, NotNil)
+		return chisel.ExitCode(err)
+	}
+	c.Assert(exitCode("cut", "--unknown"), Equals, 2)
+	c.Assert(exitCode("cut", "--release", releaseDir, "mypkg_bins"), Equals, 2)
+	c.Assert(exitCode("info", "--resolve", "mypkg"), Equals, 2)
+	c.Assert(exitCode("cut", "--release", c.MkDir(), "--root", c.MkDir(), "mypkg_bins"), Equals, 3)
+	c.Assert(exitCode("cut", "--release", releaseDir, "--root", c.MkDir(), "mypkg_none"), Equals, 4)
+
+	c.Assert(chisel.ExitCode(&archive.FetchError{Err: errors.New("cannot talk to archive")}), Equals, 5)
+	c.Assert(chisel.ExitCode(&archive.VerifyError{Err: errors.New("cannot verify signature")}), Equals, 6)
+	c.Assert(chisel.ExitCode(fmt.Errorf("%w (see --refresh-release)", &setup.ChangedError{Release: "ubuntu-24.04", Commit: "0123"})), Equals, 6)
+	c.Assert(chisel.ExitCode(&slicer.ExtractError{Package: "mypkg", Err: errors.New("cannot extract")}), Equals, 7)
+	c.Assert(chisel.ExitCode(fmt.Errorf("cannot cut: %w", &slicer.ExtractError{Err: errors.New("cannot extract")})), Equals, 7)
+	c.Assert(chisel.ExitCode(errors.New("other")), Equals, 1)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+// writeSlicePRNotes writes a Markdown summary of the changes from the base
+// slice definitions of the package, if any, to the new ones, formatted for
+// pull requests to release repositories. The size impact of the changes is
+// included when the package contents are known.
+func writeSlicePRNotes(w io.Writer, base, pkg *setup.Package, arch string, contents []slicer.PackagePath) {
+	var baseSlices map[string]*setup.Slice
+	if base != nil {
+		baseSlices = base.Slices
+	}
+	sized := contents != nil
+	emptySlice := &setup.Slice{}
+
+	var table, newPaths, removedPaths, resolved []string
+	names := slices.Sorted(maps.Keys(pkg.Slices))
+	for name := range baseSlices {
+		if pkg.Slices[name] == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		slice, baseSlice := pkg.Slices[name], baseSlices[name]
+		var change string
+		switch {
+		case baseSlice == nil:
+			change = "new"
+			baseSlice = emptySlice
+		case slice == nil:
+			change = "removed"
+			slice = emptySlice
+		case !reflect.DeepEqual(slice.Essential, baseSlice.Essential) ||
+			!reflect.DeepEqual(slice.Contents, baseSlice.Contents) ||
+			slice.Scripts != baseSlice.Scripts:
+			change = "changed"
+		default:
+			continue
+		}
+		sliceName := pkg.Name + "_" + name
+		row := fmt.Sprintf("| `%s` | %s |", sliceName, change)
+		if sized {
+			impact := sliceSize(slice, arch, contents) - sliceSize(baseSlice, arch, contents)
+			row += fmt.Sprintf(" %s |", formatSizeChange(impact))
+		}
+		table = append(table, row)
+
+		for _, path := range slices.Sorted(maps.Keys(slice.Contents)) {
+			pathInfo := slice.Contents[path]
+			basePathInfo, ok := baseSlice.Contents[path]
+			if !ok {
+				newPaths = append(newPaths, fmt.Sprintf("- `%s` in `%s`", path, sliceName))
+			}
+			if pathInfo.Prefer != "" && (!ok || basePathInfo.Prefer != pathInfo.Prefer) {
+				resolved = append(resolved, fmt.Sprintf("- `%s` is taken from `%s`", path, pathInfo.Prefer))
+			}
+		}
+		for _, path := range slices.Sorted(maps.Keys(baseSlice.Contents)) {
+			if _, ok := slice.Contents[path]; !ok {
+				removedPaths = append(removedPaths, fmt.Sprintf("- `%s` in `%s`", path, sliceName))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "### Slices of `%s`\n\n", pkg.Name)
+	if len(table) == 0 {
+		fmt.Fprintf(w, "No changes.\n\n")
+		return
+	}
+	if sized {
+		fmt.Fprintf(w, "| Slice | Change | Size impact |\n|-------|--------|-------------|\n")
+	} else {
+		fmt.Fprintf(w, "| Slice | Change |\n|-------|--------|\n")
+	}
+	fmt.Fprintf(w, "%s\n\n", strings.Join(table, "\n"))
+	writePRNotesList(w, "New paths", newPaths)
+	writePRNotesList(w, "Removed paths", removedPaths)
+	writePRNotesList(w, "Conflicts resolved", resolved)
+}
+
+// writeCoveragePRNotes writes a Markdown summary of how the slices of the
+// package cover its contents, formatted for pull requests to release
+// repositories.
+func writeCoveragePRNotes(w io.Writer, pkg *setup.Package, arch string, coverage *packageCoverage, contents []slicer.PackagePath) {
+	fmt.Fprintf(w, "### Coverage of `%s`\n\n", pkg.Name)
+	fmt.Fprintf(w, "%d of %d files covered, %s of %s.\n\n", coverage.Files-len(coverage.Uncovered), coverage.Files,
+		formatSize(coverage.CoveredSize), formatSize(coverage.Size))
+	fmt.Fprintf(w, "| Slice | Size |\n|-------|------|\n")
+	for _, name := range slices.Sorted(maps.Keys(pkg.Slices)) {
+		size := sliceSize(pkg.Slices[name], arch, contents)
+		fmt.Fprintf(w, "| `%s_%s` | %s |\n", pkg.Name, name, formatSize(size))
+	}
+	fmt.Fprintf(w, "\n")
+
+	var uncovered, missing []string
+	for _, path := range coverage.Uncovered {
+		uncovered = append(uncovered, fmt.Sprintf("- `%s`", path))
+	}
+	for _, path := range slices.Sorted(maps.Keys(coverage.Missing)) {
+		missing = append(missing, fmt.Sprintf("- `%s` in `%s`", path, strings.Join(coverage.Missing[path], "`, `")))
+	}
+	writePRNotesList(w, "Uncovered paths", uncovered)
+	writePRNotesList(w, "Missing paths", missing)
+}
+
+func writePRNotesList(w io.Writer, title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n\n%s\n\n", title, strings.Join(items, "\n"))
+}
+
+// formatSize returns the size in bytes in a human readable form.
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1024*1024))
+}
+
+// formatSizeChange is like formatSize, but signed.
+func formatSizeChange(size int64) string {
+	switch {
+	case size > 0:
+		return "+" + formatSize(size)
+	case size < 0:
+		return "-" + formatSize(-size)
+	}
+	return "0 B"
+}
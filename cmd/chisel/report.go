@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/canonical/chisel/internal/apacheutil"
+	"github.com/canonical/chisel/internal/lockfile"
+	"github.com/canonical/chisel/internal/sbom"
+	"github.com/canonical/chisel/internal/security"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+// Names of the files written to the report directory of a cut.
+const (
+	reportManifest  = "manifest.json"
+	reportSPDX      = "sbom.spdx.json"
+	reportCycloneDX = "sbom.cdx.json"
+	reportSizes     = "sizes.json"
+	reportSecurity  = "security.json"
+	reportLockfile  = lockfile.DefaultName
+)
+
+// cutReport holds the artifacts of a cut written to its report directory.
+type cutReport struct {
+	// manifest is the manifest of the tree in flat JSON encoding.
+	manifest bytes.Buffer
+	// name identifies the tree in the bills of materials.
+	name string
+	lock *lockfile.Lockfile
+	// vulns are the known vulnerabilities of the packages, when audited.
+	vulns []*security.Vulnerability
+}
+
+// sizeReport is the content of the size report.
+type sizeReport struct {
+	// Total is the size of the content of all the regular files, with
+	// hard links counted once.
+	Total    uint64            `json:"total"`
+	Files    int               `json:"files"`
+	Packages map[string]uint64 `json:"packages"`
+	Slices   map[string]uint64 `json:"slices"`
+}
+
+// securityReport is the content of the security report.
+type securityReport struct {
+	SetuidPolicy    string                     `json:"setuid-policy,omitempty"`
+	Privileged      []*manifest.PrivilegedPath `json:"privileged"`
+	Vulnerabilities []*security.Vulnerability  `json:"vulnerabilities,omitempty"`
+}
+
+// write writes all the artifacts of the report to dir, which is created if
+// needed.
+func (r *cutReport) write(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot write report: %w", err)
+	}
+	mfest, err := manifest.Read(bytes.NewReader(r.manifest.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	files := map[string]func(w *bytes.Buffer) error{
+		reportManifest: func(w *bytes.Buffer) error {
+			_, err := w.Write(r.manifest.Bytes())
+			return err
+		},
+		reportSizes: func(w *bytes.Buffer) error {
+			sizes, err := reportSizesOf(mfest)
+			if err != nil {
+				return err
+			}
+			return writeReportJSON(w, sizes)
+		},
+		reportSecurity: func(w *bytes.Buffer) error {
+			report := &securityReport{
+				Privileged:      []*manifest.PrivilegedPath{},
+				Vulnerabilities: r.vulns,
+			}
+			info, err := mfest.Security()
+			if err != nil {
+				return err
+			}
+			if info != nil {
+				report.SetuidPolicy = info.SetuidPolicy
+				if info.Privileged != nil {
+					report.Privileged = info.Privileged
+				}
+			}
+			return writeReportJSON(w, report)
+		},
+	}
+	pkgs, err := sbom.Packages(mfest)
+	if err != nil {
+		return err
+	}
+	sbomOptions := &sbom.Options{
+		Name:    r.name,
+		Created: reportTime(),
+	}
+	files[reportSPDX] = func(w *bytes.Buffer) error {
+		return sbom.WriteSPDX(w, pkgs, sbomOptions)
+	}
+	files[reportCycloneDX] = func(w *bytes.Buffer) error {
+		return sbom.WriteCycloneDX(w, pkgs, sbomOptions)
+	}
+
+	for name, generate := range files {
+		var buf bytes.Buffer
+		err := generate(&buf)
+		if err != nil {
+			return fmt.Errorf("cannot write report %s: %w", name, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644)
+		if err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+	}
+	if r.lock != nil {
+		err = lockfile.Write(filepath.Join(dir, reportLockfile), r.lock)
+		if err != nil {
+			return err
+		}
+	}
+	logf("Report written to %s", dir)
+	return nil
+}
+
+// reportSizesOf returns the sizes of the content recorded in the manifest,
+// in total and by package and slice.
+func reportSizesOf(mfest *manifest.Manifest) (*sizeReport, error) {
+	sizes := &sizeReport{
+		Packages: map[string]uint64{},
+		Slices:   map[string]uint64{},
+	}
+	inodes := map[uint64]bool{}
+	err := mfest.IteratePaths("", func(path *manifest.Path) error {
+		if path.SHA256 == "" {
+			// Not a regular file.
+			return nil
+		}
+		if path.Inode != 0 {
+			if inodes[path.Inode] {
+				return nil
+			}
+			inodes[path.Inode] = true
+		}
+		sizes.Total += path.Size
+		sizes.Files++
+		pkgs := map[string]bool{}
+		for _, slice := range path.Slices {
+			sizes.Slices[slice] += path.Size
+			sk, err := apacheutil.ParseSliceKey(slice)
+			if err != nil {
+				return err
+			}
+			if !pkgs[sk.Package] {
+				pkgs[sk.Package] = true
+				sizes.Packages[sk.Package] += path.Size
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// reportTime returns the time the report is created at, which is the one
+// in SOURCE_DATE_EPOCH when set, for reproducible reports.
+func reportTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0)
+	}
+	return time.Now()
+}
+
+func writeReportJSON(w *bytes.Buffer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// selectionFile holds the content of a selection file, which declares the
+// slices to cut along with the options to cut them with. As JSON is valid
+// YAML, selection files may be written in either format.
+type selectionFile struct {
+	Release string   `yaml:"release,omitempty"`
+	Arch    string   `yaml:"arch,omitempty"`
+	Ignore  []string `yaml:"ignore,omitempty"`
+	Slices  []string `yaml:"slices"`
+	// Without lists patterns of slices to exclude from the selection.
+	Without []string `yaml:"without,omitempty"`
+	// Pins maps package names to the archive they must be fetched from.
+	Pins   map[string]string `yaml:"pins,omitempty"`
+	Output selectionOutput   `yaml:"output,omitempty"`
+}
+
+type selectionOutput struct {
+	Root          string   `yaml:"root,omitempty"`
+	Tar           string   `yaml:"tar,omitempty"`
+	DpkgStatus    bool     `yaml:"dpkg-status,omitempty"`
+	LDConfig      bool     `yaml:"ldconfig,omitempty"`
+	Sysusers      bool     `yaml:"sysusers,omitempty"`
+	Tmpfiles      bool     `yaml:"tmpfiles,omitempty"`
+	Locales       []string `yaml:"locales,omitempty"`
+	Timezones     []string `yaml:"timezones,omitempty"`
+	CompilePython string   `yaml:"compile-python,omitempty"`
+	Lockfile      string   `yaml:"lockfile,omitempty"`
+	OwnershipDB   string   `yaml:"ownership-db,omitempty"`
+
+	ManifestEncoding string `yaml:"manifest-encoding,omitempty"`
+
+	Copyright             bool `yaml:"copyright,omitempty"`
+	ExcludeCopyrightFiles bool `yaml:"exclude-copyright-files,omitempty"`
+	Changelogs            bool `yaml:"changelogs,omitempty"`
+}
+
+// readSelection reads and validates the selection file at path.
+func readSelection(path string) (*selectionFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read selection file: %w", err)
+	}
+	file, err := parseSelection(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse selection file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func parseSelection(data []byte) (*selectionFile, error) {
+	file := &selectionFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	err = file.validate()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// validate checks the slices, conditions and options in the file.
+func (file *selectionFile) validate() error {
+	if len(file.Slices) == 0 {
+		return fmt.Errorf("no slices listed")
+	}
+	for _, sliceRef := range file.Slices {
+		if setup.IsSlicePattern(sliceRef) || setup.IsGroupName(sliceRef) {
+			continue
+		}
+		_, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return err
+		}
+	}
+	for _, cond := range file.Ignore {
+		if cond != "unmaintained" && cond != "unstable" {
+			return fmt.Errorf("invalid condition to ignore: %q", cond)
+		}
+	}
+	switch manifestutil.Encoding(file.Output.ManifestEncoding) {
+	case "", manifestutil.EncodingZstd, manifestutil.EncodingGzip, manifestutil.EncodingNone, manifestutil.EncodingJSON:
+	default:
+		return fmt.Errorf("invalid manifest encoding: %q", file.Output.ManifestEncoding)
+	}
+	for pkg, archive := range file.Pins {
+		if pkg == "" || archive == "" {
+			return fmt.Errorf("invalid pin %q: %q", pkg, archive)
+		}
+	}
+	return nil
+}
+
+// applySelection sets the options of the cut command from the selection
+// file. Options given on the command line take precedence, and slices are
+// added to the ones given as arguments.
+func (cmd *cmdCut) applySelection(file *selectionFile) {
+	if cmd.Release == "" {
+		cmd.Release = file.Release
+	}
+	if cmd.Arch == "" {
+		cmd.Arch = file.Arch
+	}
+	for _, cond := range file.Ignore {
+		if !slices.Contains(cmd.Ignore, cond) {
+			cmd.Ignore = append(cmd.Ignore, cond)
+		}
+	}
+	for _, sliceRef := range file.Slices {
+		cmd.Positional.SliceRefs = append(cmd.Positional.SliceRefs, sliceName(sliceRef))
+	}
+	cmd.Without = append(cmd.Without, file.Without...)
+	cmd.pins = file.Pins
+
+	output := &file.Output
+	if cmd.RootDir == "" && cmd.Output == "" {
+		cmd.RootDir = output.Root
+		cmd.Output = output.Tar
+	}
+	cmd.DpkgStatus = cmd.DpkgStatus || output.DpkgStatus
+	cmd.LDConfig = cmd.LDConfig || output.LDConfig
+	cmd.Sysusers = cmd.Sysusers || output.Sysusers
+	cmd.Tmpfiles = cmd.Tmpfiles || output.Tmpfiles
+	if cmd.Locales == "" && len(output.Locales) > 0 {
+		cmd.Locales = strings.Join(output.Locales, ",")
+	}
+	if cmd.Timezones == "" && len(output.Timezones) > 0 {
+		cmd.Timezones = strings.Join(output.Timezones, ",")
+	}
+	if cmd.CompilePython == "" {
+		cmd.CompilePython = output.CompilePython
+	}
+	if cmd.Lockfile == "" {
+		cmd.Lockfile = output.Lockfile
+	}
+	if cmd.OwnershipDB == "" {
+		cmd.OwnershipDB = output.OwnershipDB
+	}
+	if cmd.ManifestEncoding == "" {
+		cmd.ManifestEncoding = output.ManifestEncoding
+	}
+	cmd.Copyright = cmd.Copyright || output.Copyright
+	cmd.ExcludeCopyrightFiles = cmd.ExcludeCopyrightFiles || output.ExcludeCopyrightFiles
+	cmd.Changelogs = cmd.Changelogs || output.Changelogs
+}
+
+// applyPins returns a copy of release where the packages given in pins are
+// fetched from the respective archives. The release itself is left
+// untouched, as it may be shared by other cuts.
+func applyPins(release *setup.Release, pins map[string]string) (*setup.Release, error) {
+	pkgNames := make([]string, 0, len(pins))
+	for pkgName := range pins {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	slices.Sort(pkgNames)
+	packages := maps.Clone(release.Packages)
+	for _, pkgName := range pkgNames {
+		archiveName := pins[pkgName]
+		pkg, ok := release.Packages[pkgName]
+		if !ok {
+			return nil, fmt.Errorf("cannot pin package %q: package not found in release", pkgName)
+		}
+		if _, ok := release.Archives[archiveName]; !ok {
+			return nil, fmt.Errorf("cannot pin package %q: archive %q not found in release", pkgName, archiveName)
+		}
+		pinned := *pkg
+		pinned.Archives = []string{archiveName}
+		packages[pkgName] = &pinned
+	}
+	pinned := *release
+	pinned.Packages = packages
+	return &pinned, nil
+}
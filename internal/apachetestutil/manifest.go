@@ -13,6 +13,8 @@ type ManifestContents struct {
 	Packages []*manifest.Package
 	Slices   []*manifest.Slice
 	Contents []*manifest.Content
+	Build    *manifest.Build
+	Security *manifest.Security
 }
 
 func DumpManifestContents(c *check.C, mfest *manifest.Manifest) *ManifestContents {
@@ -44,11 +46,19 @@ func DumpManifestContents(c *check.C, mfest *manifest.Manifest) *ManifestContent
 	})
 	c.Assert(err, check.IsNil)
 
+	build, err := mfest.Build()
+	c.Assert(err, check.IsNil)
+
+	security, err := mfest.Security()
+	c.Assert(err, check.IsNil)
+
 	mc := ManifestContents{
 		Paths:    paths,
 		Packages: pkgs,
 		Slices:   slices,
 		Contents: contents,
+		Build:    build,
+		Security: security,
 	}
 	return &mc
 }
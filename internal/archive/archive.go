@@ -1,11 +1,21 @@
 package archive
 
 import (
+	"bufio"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,7 +24,9 @@ import (
 	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/control"
 	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/metrics"
 	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 type Archive interface {
@@ -22,6 +34,9 @@ type Archive interface {
 	Fetch(pkg string) (io.ReadSeekCloser, *PackageInfo, error)
 	Exists(pkg string) bool
 	Info(pkg string) (*PackageInfo, error)
+	// Close releases the resources held by the archive, such as the index
+	// files read on demand. The archive must not be used afterwards.
+	Close() error
 }
 
 type PackageInfo struct {
@@ -29,6 +44,24 @@ type PackageInfo struct {
 	Version string
 	Arch    string
 	SHA256  string
+	// Source and SourceVersion identify the source package the package is
+	// built from, which are the package's own name and version unless
+	// noted otherwise in its control data.
+	Source        string
+	SourceVersion string
+	// InstalledSize is the approximate size in bytes of the package once
+	// installed, as declared in the archive index in KiB, or zero if not
+	// declared.
+	InstalledSize int64
+	// Conflicts, Breaks and Replaces hold the relationship fields of the
+	// package with other packages, as declared in its control data.
+	Conflicts []Relation
+	Breaks    []Relation
+	Replaces  []Relation
+	// Suite and Component identify the index the package was found in, when
+	// the archive has them.
+	Suite     string
+	Component string
 }
 
 type Options struct {
@@ -45,6 +78,86 @@ type Options struct {
 	// OldRelease is set for Ubuntu releases which are moved from the regular
 	// archive which happens after the release's end of life date.
 	OldRelease bool
+	// Refresh forces the indexes to be downloaded again instead of being
+	// revalidated against the copies in the cache.
+	Refresh bool
+	// Context optionally cancels the downloads from the archive, both when
+	// it is opened and when packages are fetched later on.
+	Context context.Context
+	// Verify holds the policy for verifying the content fetched.
+	Verify VerifyOptions
+	// HTTP configures the requests made to the archive.
+	HTTP HTTPOptions
+	// Mirrors optionally lists base URLs serving the same content as the
+	// archive, or AutoMirrors. The fastest of them and the default location
+	// to respond is used for all the requests made to the archive. Mirrors
+	// are not used for Pro archives nor for old releases.
+	Mirrors []string
+}
+
+// HTTPOptions configures the requests made to an archive.
+type HTTPOptions struct {
+	// Timeout limits the requests fetching small files, such as the
+	// InRelease file, 30 seconds by default.
+	Timeout time.Duration
+	// BulkTimeout limits the requests fetching the package indexes and
+	// the packages, 5 minutes by default.
+	BulkTimeout time.Duration
+	// Headers are added to every request, e.g. to pass tokens to a CDN.
+	Headers map[string]string
+	// UserAgent replaces the default User-Agent of the requests.
+	UserAgent string
+	// ChunkThreshold enables fetching packages of at least this many bytes
+	// with concurrent ranged requests, when the archive supports them.
+	ChunkThreshold int64
+	// ChunkSize is the number of bytes fetched by each of the ranged
+	// requests, 8MiB by default.
+	ChunkSize int64
+}
+
+const (
+	defaultTimeout     = 30 * time.Second
+	defaultBulkTimeout = 5 * time.Minute
+)
+
+var headerNameExp = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+// Validate returns an error if the options cannot be used for requests.
+func (o *HTTPOptions) Validate() error {
+	if o.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", o.Timeout)
+	}
+	if o.BulkTimeout < 0 {
+		return fmt.Errorf("invalid bulk timeout: %s", o.BulkTimeout)
+	}
+	for name, value := range o.Headers {
+		if !headerNameExp.MatchString(name) {
+			return fmt.Errorf("invalid header name: %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for header %s: %q", name, value)
+		}
+	}
+	if strings.ContainsAny(o.UserAgent, "\r\n") {
+		return fmt.Errorf("invalid user agent: %q", o.UserAgent)
+	}
+	if o.ChunkThreshold < 0 {
+		return fmt.Errorf("invalid chunk threshold: %d", o.ChunkThreshold)
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("invalid chunk size: %d", o.ChunkSize)
+	}
+	return nil
+}
+
+// apply sets the headers and user agent of the options on req.
+func (o *HTTPOptions) apply(req *http.Request) {
+	for name, value := range o.Headers {
+		req.Header.Set(name, value)
+	}
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
 }
 
 func Open(options *Options) (Archive, error) {
@@ -63,22 +176,54 @@ func Open(options *Options) (Archive, error) {
 type fetchFlags uint
 
 const (
-	fetchBulk    fetchFlags = 1 << iota
-	fetchDefault fetchFlags = 0
+	fetchBulk fetchFlags = 1 << iota
+	// fetchIndex marks archive indexes, which are refreshed on request.
+	// Indexes fetched without a digest are cached along with the HTTP
+	// validators of the response, to revalidate them on later fetches.
+	fetchIndex
+	// fetchGzip marks compressed data whose path has no .gz extension, as
+	// the ones acquired by hash.
+	fetchGzip
+	// fetchRaw marks compressed data which is cached as is, and only
+	// decompressed when read, as the contents indexes.
+	fetchRaw
 )
 
-var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+var errNotFound = fmt.Errorf("cannot find archive data")
+
+// FetchError reports a failure to download content, such as when the server
+// cannot be reached or responds with an error.
+type FetchError struct {
+	Err error
 }
 
-var httpDo = httpClient.Do
+func (e *FetchError) Error() string { return e.Err.Error() }
+func (e *FetchError) Unwrap() error { return e.Err }
 
-var bulkClient = &http.Client{
-	Timeout: 5 * time.Minute,
+// VerifyError reports content which fails the verification of its
+// signature.
+type VerifyError struct {
+	Err error
 }
 
+func (e *VerifyError) Error() string { return e.Err.Error() }
+func (e *VerifyError) Unwrap() error { return e.Err }
+
+// The timeouts of the requests are set on their contexts, so that they may
+// be configured for every archive.
+var httpClient = &http.Client{}
+
+var httpDo = httpClient.Do
+
+var bulkClient = &http.Client{}
+
 var bulkDo = bulkClient.Do
 
+// countDownload counts the bytes of the body of resp as they are read.
+func countDownload(resp *http.Response) {
+	resp.Body = &metrics.CountingReader{ReadCloser: resp.Body, Counter: &metrics.DownloadedBytes}
+}
+
 type ubuntuArchive struct {
 	options Options
 	indexes []*ubuntuIndex
@@ -86,6 +231,9 @@ type ubuntuArchive struct {
 	pubKeys []*packet.PublicKey
 	baseURL string
 	creds   *credentials
+	// mirror is the base URL of the mirror selected, if any, in which case
+	// it is also the baseURL.
+	mirror string
 }
 
 type ubuntuIndex struct {
@@ -96,13 +244,30 @@ type ubuntuIndex struct {
 	component string
 	release   control.Section
 	packages  control.File
-	archive   *ubuntuArchive
+	// packagesFile is the file packages is read from on demand, if any.
+	packagesFile io.Closer
+	archive      *ubuntuArchive
 }
 
 func (a *ubuntuArchive) Options() *Options {
 	return &a.options
 }
 
+func (a *ubuntuArchive) Close() error {
+	var firstErr error
+	for _, index := range a.indexes {
+		if index.packagesFile == nil {
+			continue
+		}
+		err := index.packagesFile.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		index.packagesFile = nil
+	}
+	return firstErr
+}
+
 func (a *ubuntuArchive) Exists(pkg string) bool {
 	_, _, err := a.selectPackage(pkg)
 	return err == nil
@@ -134,25 +299,225 @@ func (a *ubuntuArchive) Fetch(pkg string) (io.ReadSeekCloser, *PackageInfo, erro
 	if err != nil {
 		return nil, nil, err
 	}
+	err = index.checkPackageDigests(section)
+	if err != nil {
+		return nil, nil, err
+	}
 	suffix := section.Get("Filename")
 	logf("Fetching %s...", suffix)
-	reader, err := index.fetch("../../"+suffix, section.Get("SHA256"), fetchBulk)
+	var reader io.ReadSeekCloser
+	size, _ := strconv.ParseInt(section.Get("Size"), 10, 64)
+	if threshold := a.options.HTTP.ChunkThreshold; threshold > 0 && size >= threshold {
+		reader, err = index.fetchChunked("../../"+suffix, section.Get("SHA256"), size)
+	} else {
+		reader, err = index.fetchResumable("../../"+suffix, section.Get("SHA256"))
+	}
 	if err != nil {
 		return nil, nil, err
 	}
+	err = index.checkPackage(section, reader)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
 	info := sectionPackageInfo(section)
+	info.Suite = index.suite
+	info.Component = index.component
 	return reader, info, nil
 }
 
 func (a *ubuntuArchive) Info(pkg string) (*PackageInfo, error) {
-	section, _, err := a.selectPackage(pkg)
+	section, index, err := a.selectPackage(pkg)
 	if err != nil {
 		return nil, err
 	}
 	info := sectionPackageInfo(section)
+	info.Suite = index.suite
+	info.Component = index.component
 	return info, nil
 }
 
+const ubuntuChangelogsURL = "https://changelogs.ubuntu.com/changelogs/"
+
+// ChangelogURL returns the location of the changelog for the version of the
+// package available in the archive, or "" if it is not known. Changelogs are
+// only published for the packages in the regular Ubuntu archives.
+func ChangelogURL(a Archive, pkg string) string {
+	ubuntu, ok := a.(*ubuntuArchive)
+	if !ok || ubuntu.options.Pro != "" {
+		return ""
+	}
+	section, _, err := ubuntu.selectPackage(pkg)
+	if err != nil {
+		return ""
+	}
+	// The pool directory is named after the source package, as in
+	// "pool/main/o/openssl/libssl3_3.0.2-0ubuntu1_amd64.deb".
+	poolDir := path.Dir(section.Get("Filename"))
+	if !strings.HasPrefix(poolDir, "pool/") {
+		return ""
+	}
+	_, version := sectionSource(section)
+	if _, noEpoch, ok := strings.Cut(version, ":"); ok {
+		version = noEpoch
+	}
+	return ubuntuChangelogsURL + poolDir + "/" + path.Base(poolDir) + "_" + version + "/changelog"
+}
+
+// FetchChangelog returns the changelog for the version of the package
+// available in the archive, downloaded from the location reported by
+// ChangelogURL.
+func FetchChangelog(a Archive, pkg string) ([]byte, error) {
+	url := ChangelogURL(a, pkg)
+	if url == "" {
+		return nil, fmt.Errorf("no changelog published for package %q", pkg)
+	}
+	_, index, err := a.(*ubuntuArchive).selectPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+	logf("Fetching changelog of %s...", pkg)
+	ctx, cancel := index.requestContext(0)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create HTTP request: %v", err)
+	}
+	resp, err := httpDo(req)
+	if err != nil {
+		return nil, &FetchError{fmt.Errorf("cannot talk to changelog server: %v", err)}
+	}
+	countDownload(resp)
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		// ok
+	case 404:
+		return nil, fmt.Errorf("no changelog published for package %q", pkg)
+	default:
+		return nil, &FetchError{fmt.Errorf("error from changelog server: %v", resp.Status)}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &FetchError{fmt.Errorf("cannot fetch changelog: %v", err)}
+	}
+	return data, nil
+}
+
+// PathPackages is a path listed in the contents index of an archive, along
+// with the packages shipping it.
+type PathPackages struct {
+	Path     string
+	Packages []string
+}
+
+// FindPath returns the paths matching pattern in the contents index of the
+// archive, along with the packages shipping them, sorted by path. Globs
+// are allowed in pattern. Only files are listed in the index, which is
+// fetched on first use and kept in the cache afterwards.
+func FindPath(a Archive, pattern string) ([]PathPackages, error) {
+	ubuntu, ok := a.(*ubuntuArchive)
+	if !ok {
+		return nil, fmt.Errorf("archive %q has no contents index", a.Options().Label)
+	}
+	return ubuntu.findPath(pattern)
+}
+
+func (a *ubuntuArchive) findPath(pattern string) ([]PathPackages, error) {
+	found := make(map[string][]string)
+	searched := make(map[string]bool)
+	for _, index := range a.indexes {
+		contentsPath, digest := index.contentsPath()
+		if contentsPath == "" || searched[index.suite+"/"+contentsPath] {
+			continue
+		}
+		searched[index.suite+"/"+contentsPath] = true
+		err := index.findPath(contentsPath, digest, pattern, found)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(searched) == 0 {
+		return nil, fmt.Errorf("archive %q has no contents index", a.options.Label)
+	}
+	result := make([]PathPackages, 0, len(found))
+	for filePath, packages := range found {
+		slices.Sort(packages)
+		result = append(result, PathPackages{
+			Path:     filePath,
+			Packages: slices.Compact(packages),
+		})
+	}
+	slices.SortFunc(result, func(a, b PathPackages) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return result, nil
+}
+
+// contentsPath returns the path of the compressed contents index for the
+// component of the index, or for the whole suite when the archive has no
+// index per component, along with its digest. It returns "" if the
+// archive has neither.
+func (index *ubuntuIndex) contentsPath() (contentsPath, digest string) {
+	digests := index.release.Get("SHA256")
+	for _, contentsPath := range []string{
+		fmt.Sprintf("%s/Contents-%s.gz", index.component, index.arch),
+		fmt.Sprintf("Contents-%s.gz", index.arch),
+	} {
+		digest, _, _ := control.ParsePathInfo(digests, contentsPath)
+		if digest != "" {
+			return contentsPath, digest
+		}
+	}
+	return "", ""
+}
+
+// findPath adds the paths matching pattern in the contents index at
+// contentsPath to found, along with the packages shipping them.
+func (index *ubuntuIndex) findPath(contentsPath, digest, pattern string, found map[string][]string) error {
+	logf("Fetching contents index for %s %s %s suite...", index.displayName(), index.version, index.suite)
+	reader, err := index.fetch(contentsPath, digest, fetchBulk|fetchIndex|fetchRaw)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	gzReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("cannot decompress contents index: %v", err)
+	}
+	defer gzReader.Close()
+
+	glob := strings.ContainsAny(pattern, "*?[")
+	scanner := bufio.NewScanner(gzReader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Each line holds a path without the leading slash, followed by
+		// the comma-separated list of the packages shipping it, as in
+		// "usr/bin/ls  utils/coreutils".
+		line := scanner.Text()
+		i := strings.LastIndexAny(line, " \t")
+		if i < 0 {
+			continue
+		}
+		filePath := "/" + strings.TrimSpace(line[:i])
+		if glob {
+			if !strdist.GlobPath(pattern, filePath) {
+				continue
+			}
+		} else if filePath != pattern {
+			continue
+		}
+		for _, location := range strings.Split(line[i+1:], ",") {
+			pkg := location[strings.LastIndex(location, "/")+1:]
+			found[filePath] = append(found[filePath], pkg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read contents index: %v", err)
+	}
+	return nil
+}
+
 const ubuntuURL = "http://archive.ubuntu.com/ubuntu/"
 const ubuntuOldReleasesURL = "http://old-releases.ubuntu.com/ubuntu/"
 const ubuntuPortsURL = "http://ports.ubuntu.com/ubuntu-ports/"
@@ -219,11 +584,32 @@ func openUbuntu(options *Options) (Archive, error) {
 	if len(options.Version) == 0 {
 		return nil, fmt.Errorf("archive options missing version")
 	}
+	err := ValidateDigest(options.Verify.MinDigest)
+	if err != nil {
+		return nil, err
+	}
+	err = options.HTTP.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mirror := range options.Mirrors {
+		err = ValidateMirror(mirror)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	baseURL, creds, err := archiveURL(options.Pro, options.Arch, options.OldRelease)
 	if err != nil {
 		return nil, err
 	}
+	var mirror string
+	if len(options.Mirrors) > 0 && options.Pro == "" && !options.OldRelease {
+		if selected := selectMirror(options, baseURL); selected != baseURL {
+			baseURL, mirror = selected, selected
+		}
+	}
 
 	archive := &ubuntuArchive{
 		options: *options,
@@ -233,6 +619,7 @@ func openUbuntu(options *Options) (Archive, error) {
 		pubKeys: options.PubKeys,
 		baseURL: baseURL,
 		creds:   creds,
+		mirror:  mirror,
 	}
 
 	for _, suite := range options.Suites {
@@ -250,6 +637,7 @@ func openUbuntu(options *Options) (Archive, error) {
 			if release == nil {
 				err := index.fetchRelease()
 				if err != nil {
+					archive.Close()
 					return nil, err
 				}
 				release = index.release
@@ -260,11 +648,13 @@ func openUbuntu(options *Options) (Archive, error) {
 				}
 				err = index.checkComponents(options.Components)
 				if err != nil {
+					archive.Close()
 					return nil, err
 				}
 			}
 			err := index.fetchIndex()
 			if err != nil {
+				archive.Close()
 				return nil, err
 			}
 			archive.indexes = append(archive.indexes, index)
@@ -276,7 +666,7 @@ func openUbuntu(options *Options) (Archive, error) {
 
 func (index *ubuntuIndex) fetchRelease() error {
 	logf("Fetching %s %s %s suite details...", index.displayName(), index.version, index.suite)
-	reader, err := index.fetch("InRelease", "", fetchDefault)
+	reader, err := index.fetch("InRelease", "", fetchIndex)
 	if err != nil {
 		return err
 	}
@@ -295,11 +685,11 @@ func (index *ubuntuIndex) fetchRelease() error {
 	// https://salsa.debian.org/apt-team/apt/-/blob/4e344a4/methods/gpgv.cc#L553-557
 	sigs, canonicalBody, err := pgputil.DecodeClearSigned(data)
 	if err != nil {
-		return fmt.Errorf("cannot decode clearsigned InRelease file: %v", err)
+		return &VerifyError{fmt.Errorf("cannot decode clearsigned InRelease file: %v", err)}
 	}
 	err = pgputil.VerifyAnySignature(index.archive.pubKeys, sigs, canonicalBody)
 	if err != nil {
-		return fmt.Errorf("cannot verify signature of the InRelease file")
+		return &VerifyError{fmt.Errorf("cannot verify signature of the InRelease file")}
 	}
 
 	// canonicalBody has <CR><LF> line endings, reverting that to match the
@@ -321,7 +711,7 @@ func (index *ubuntuIndex) fetchRelease() error {
 	logf("Release date: %s", section.Get("Date"))
 
 	index.release = section
-	return nil
+	return index.checkRelease()
 }
 
 func (index *ubuntuIndex) fetchIndex() error {
@@ -333,19 +723,115 @@ func (index *ubuntuIndex) fetchIndex() error {
 	}
 
 	logf("Fetching index for %s %s %s %s component...", index.displayName(), index.version, index.suite, index.component)
-	reader, err := index.fetch(packagesPath+".gz", digest, fetchBulk)
+	reader, err := index.fetchPackages(packagesPath, digest)
 	if err != nil {
 		return err
 	}
-	ctrl, err := control.ParseReader("Package", reader)
+	err = index.checkIndex(packagesPath, reader)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	// The index is read from the cache file on demand rather than held in
+	// memory, as the ones of components such as universe are large. The
+	// file is thus kept open until the archive is closed.
+	var ctrl control.File
+	var packagesFile io.Closer
+	if readerAt, ok := reader.(io.ReaderAt); ok {
+		var size int64
+		size, err = reader.Seek(0, io.SeekEnd)
+		if err == nil {
+			ctrl, err = control.ParseReaderAt("Package", readerAt, size)
+		}
+		if err != nil {
+			reader.Close()
+		} else {
+			packagesFile = reader
+		}
+	} else {
+		ctrl, err = control.ParseReader("Package", reader)
+		reader.Close()
+	}
 	if err != nil {
 		return fmt.Errorf("parsing archive Package file: %v", err)
 	}
 
+	// Record the index fetched, so that later changes to it may be
+	// obtained by patching it.
+	err = index.archive.writeCachedIndex(index.packagesURL(packagesPath), &cachedIndex{SHA256: digest})
+	if err != nil {
+		if packagesFile != nil {
+			packagesFile.Close()
+		}
+		return err
+	}
+
 	index.packages = ctrl
+	index.packagesFile = packagesFile
 	return nil
 }
 
+// packagesURL returns the URL identifying the uncompressed Packages file
+// at packagesPath, which is not fetched as such.
+func (index *ubuntuIndex) packagesURL(packagesPath string) string {
+	return index.archive.baseURL + "dists/" + index.suite + "/" + packagesPath
+}
+
+// fetchPackages returns the content of the Packages file at packagesPath,
+// with the given digest. When not in the cache, it is obtained by patching
+// an earlier version in the cache when the archive provides the patches,
+// or otherwise downloaded, by hash when the archive supports it.
+func (index *ubuntuIndex) fetchPackages(packagesPath, digest string) (io.ReadSeekCloser, error) {
+	byHash := index.archive.options.Verify.RequireByHash
+	if !index.archive.options.Refresh {
+		reader, err := index.archive.cache.Open(digest)
+		if err == nil {
+			return reader, nil
+		} else if err != cache.MissErr {
+			return nil, err
+		}
+		if byHash {
+			// Patches are not fetched by hash.
+			return index.fetchByHash(packagesPath, digest)
+		}
+		reader, err = index.fetchPatched(packagesPath, digest)
+		if err == nil {
+			return reader, nil
+		} else if err != errNoPatches {
+			logf("Cannot patch index, fetching it whole: %v", err)
+		}
+	}
+
+	if byHash {
+		return index.fetchByHash(packagesPath, digest)
+	}
+	if index.release.Get("Acquire-By-Hash") == "yes" {
+		reader, err := index.fetchByHash(packagesPath, digest)
+		if err != errNotFound {
+			return reader, err
+		}
+	}
+	return index.fetch(packagesPath+".gz", digest, fetchBulk|fetchIndex)
+}
+
+// fetchByHash downloads the compressed Packages file at packagesPath from
+// the by-hash directory next to it, returning errNotFound if it is not
+// there, or a VerifyError if the policy requires fetching by hash.
+func (index *ubuntuIndex) fetchByHash(packagesPath, digest string) (io.ReadSeekCloser, error) {
+	gzPath := packagesPath + ".gz"
+	gzDigest, _, _ := control.ParsePathInfo(index.release.Get("SHA256"), gzPath)
+	var reader io.ReadSeekCloser
+	err := errNotFound
+	if gzDigest != "" {
+		byHashPath := path.Dir(packagesPath) + "/by-hash/SHA256/" + gzDigest
+		reader, err = index.fetch(byHashPath, digest, fetchBulk|fetchIndex|fetchGzip)
+	}
+	if err == errNotFound && index.archive.options.Verify.RequireByHash {
+		return nil, &VerifyError{fmt.Errorf("cannot fetch %s by hash", gzPath)}
+	}
+	return reader, err
+}
+
 // supportsArch returns true if the Architectures field in the index release
 // contains "arch". Per the Debian wiki [1], index release files should list the
 // supported architectures in the "Architectures" field.
@@ -369,28 +855,35 @@ func (index *ubuntuIndex) checkComponents(components []string) error {
 }
 
 func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.ReadSeekCloser, error) {
-	reader, err := index.archive.cache.Open(digest)
-	if err == nil {
-		return reader, nil
-	} else if err != cache.MissErr {
-		return nil, err
-	}
-
-	baseURL, creds := index.archive.baseURL, index.archive.creds
-
-	var url string
-	if strings.HasPrefix(suffix, "pool/") {
-		url = baseURL + suffix
-	} else {
-		url = baseURL + "dists/" + index.suite + "/" + suffix
+	refresh := flags&fetchIndex != 0 && index.archive.options.Refresh
+	if !refresh {
+		reader, err := index.archive.cache.Open(digest)
+		if err == nil {
+			return reader, nil
+		} else if err != cache.MissErr {
+			return nil, err
+		}
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	url := index.url(suffix)
+	ctx, cancel := index.requestContext(flags)
+	defer cancel()
+	req, err := index.newRequest(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create HTTP request: %v", err)
+		return nil, err
 	}
-	if creds != nil && !creds.Empty() {
-		req.SetBasicAuth(creds.Username, creds.Password)
+	revalidate := flags&fetchIndex != 0 && digest == ""
+	var cached *cachedIndex
+	if revalidate && !refresh {
+		cached = index.archive.readCachedIndex(url)
+		if cached != nil {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
 	}
 	var resp *http.Response
 	if flags&fetchBulk != 0 {
@@ -399,23 +892,30 @@ func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.Rea
 		resp, err = httpDo(req)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot talk to archive: %v", err)
+		return nil, &FetchError{fmt.Errorf("cannot talk to archive: %v", err)}
 	}
+	countDownload(resp)
 	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case 200:
 		// ok
-	case 401:
-		return nil, fmt.Errorf("cannot fetch from %q: unauthorized", index.label)
-	case 404:
-		return nil, fmt.Errorf("cannot find archive data")
+	case 304:
+		if cached != nil {
+			reader, err := index.archive.cache.Open(cached.SHA256)
+			if err == nil {
+				return reader, nil
+			} else if err != cache.MissErr {
+				return nil, err
+			}
+		}
+		return nil, &FetchError{fmt.Errorf("error from archive: %v", resp.Status)}
 	default:
-		return nil, fmt.Errorf("error from archive: %v", resp.Status)
+		return nil, index.responseError(resp)
 	}
 
 	body := resp.Body
-	if strings.HasSuffix(suffix, ".gz") {
+	if (strings.HasSuffix(suffix, ".gz") || flags&fetchGzip != 0) && flags&fetchRaw == 0 {
 		reader, err := gzip.NewReader(body)
 		if err != nil {
 			return nil, fmt.Errorf("cannot decompress data: %v", err)
@@ -432,19 +932,196 @@ func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.Rea
 		err = writer.Close()
 	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot fetch from archive: %v", err)
+		return nil, &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+	}
+
+	if revalidate {
+		err = index.archive.writeCachedIndex(url, &cachedIndex{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			SHA256:       writer.Digest(),
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return index.archive.cache.Open(writer.Digest())
 }
 
+// url returns the location of the file at suffix, relative to the
+// directory of the suite unless it is in the pool.
+func (index *ubuntuIndex) url(suffix string) string {
+	if strings.HasPrefix(suffix, "pool/") {
+		return index.archive.baseURL + suffix
+	}
+	return index.archive.baseURL + "dists/" + index.suite + "/" + suffix
+}
+
+// requestContext returns the context for a request fetching a file with
+// the given flags, limited by the timeout configured for it.
+func (index *ubuntuIndex) requestContext(flags fetchFlags) (context.Context, context.CancelFunc) {
+	ctx := index.archive.options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	httpOptions := &index.archive.options.HTTP
+	timeout := httpOptions.Timeout
+	if flags&fetchBulk != 0 {
+		timeout = httpOptions.BulkTimeout
+		if timeout == 0 {
+			timeout = defaultBulkTimeout
+		}
+	} else if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// newRequest returns a GET request for url configured as the archive
+// requires, with its credentials if any.
+func (index *ubuntuIndex) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create HTTP request: %v", err)
+	}
+	index.archive.options.HTTP.apply(req)
+	creds := index.archive.creds
+	if creds != nil && !creds.Empty() {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	return req, nil
+}
+
+// responseError returns the error for an unexpected response status.
+func (index *ubuntuIndex) responseError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case 401:
+		return &FetchError{fmt.Errorf("cannot fetch from %q: unauthorized", index.label)}
+	case 404:
+		return errNotFound
+	default:
+		return &FetchError{fmt.Errorf("error from archive: %v", resp.Status)}
+	}
+}
+
+// cachedIndex records the digest of an index fetched from the archive,
+// whose content is in the cache, along with the HTTP validators of the
+// response which delivered it, if any.
+type cachedIndex struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last-modified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+// cachedIndexPath returns the path of the file recording the index at url
+// in the cache.
+func (a *ubuntuArchive) cachedIndexPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(a.cache.Dir, "index", hex.EncodeToString(sum[:]))
+}
+
+// readCachedIndex returns the record of the index at url, or nil if it is
+// unknown or its content is no longer in the cache.
+func (a *ubuntuArchive) readCachedIndex(url string) *cachedIndex {
+	if a.cache.Dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.cachedIndexPath(url))
+	if err != nil {
+		return nil
+	}
+	cached := &cachedIndex{}
+	err = json.Unmarshal(data, cached)
+	if err != nil {
+		return nil
+	}
+	reader, err := a.cache.Open(cached.SHA256)
+	if err != nil {
+		return nil
+	}
+	reader.Close()
+	return cached
+}
+
+// writeCachedIndex records the index at url.
+func (a *ubuntuArchive) writeCachedIndex(url string, cached *cachedIndex) error {
+	if a.cache.Dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	cpath := a.cachedIndexPath(url)
+	err = os.MkdirAll(filepath.Dir(cpath), 0755)
+	if err == nil {
+		// Rename the complete record into place so that concurrent
+		// processes never read a partial one.
+		var file *os.File
+		file, err = os.CreateTemp(filepath.Dir(cpath), "tmp.*")
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(file.Name(), cpath)
+			}
+			if err != nil {
+				os.Remove(file.Name())
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot record index in cache: %v", err)
+	}
+	return nil
+}
+
 func sectionPackageInfo(section control.Section) *PackageInfo {
+	source, sourceVersion := sectionSource(section)
 	return &PackageInfo{
-		Name:    section.Get("Package"),
-		Version: section.Get("Version"),
-		Arch:    section.Get("Architecture"),
-		SHA256:  section.Get("SHA256"),
+		Name:          section.Get("Package"),
+		Version:       section.Get("Version"),
+		Arch:          section.Get("Architecture"),
+		SHA256:        section.Get("SHA256"),
+		Source:        source,
+		SourceVersion: sourceVersion,
+		InstalledSize: parseInstalledSize(section.Get("Installed-Size")),
+		Conflicts:     parseRelations(section.Get("Conflicts")),
+		Breaks:        parseRelations(section.Get("Breaks")),
+		Replaces:      parseRelations(section.Get("Replaces")),
+	}
+}
+
+// parseInstalledSize returns the size in bytes of the Installed-Size field,
+// which is in KiB, or zero if it is missing or invalid.
+func parseInstalledSize(value string) int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size * 1024
+}
+
+// sectionSource returns the name and version of the source package of the
+// package described by section. The Source field is only present when the
+// name differs, and notes the version when it differs as well, as in
+// "openssl (3.0.2-0ubuntu1)".
+func sectionSource(section control.Section) (name, version string) {
+	name = section.Get("Package")
+	version = section.Get("Version")
+	source := strings.TrimSpace(section.Get("Source"))
+	if source == "" {
+		return name, version
+	}
+	name, rest, ok := strings.Cut(source, "(")
+	name = strings.TrimSpace(name)
+	if ok {
+		version = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), ")"))
 	}
+	return name, version
 }
 
 func (index *ubuntuIndex) displayName() string {
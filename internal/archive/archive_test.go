@@ -4,6 +4,9 @@ import (
 	"golang.org/x/crypto/openpgp/packet"
 	. "gopkg.in/check.v1"
 
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"debug/elf"
 	"errors"
 	"flag"
@@ -14,11 +17,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/archive/testarchive"
+	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/testutil"
 )
 
@@ -32,6 +40,7 @@ type httpSuite struct {
 	err       error
 	header    http.Header
 	status    int
+	statuses  map[string]int
 	restore   func()
 	privKey   *packet.PrivateKey
 	pubKey    *packet.PublicKey
@@ -58,6 +67,7 @@ func (s *httpSuite) SetUpTest(c *C) {
 	s.responses = make(map[string][]byte)
 	s.header = nil
 	s.status = 200
+	s.statuses = make(map[string]int)
 	s.restore = archive.FakeDo(s.Do)
 	s.privKey = key1.PrivKey
 	s.pubKey = key1.PubKey
@@ -79,10 +89,14 @@ func (s *httpSuite) Do(req *http.Request) (*http.Response, error) {
 	if response, ok := s.responses[path.Clean(req.URL.Path)]; ok {
 		body = string(response)
 	}
+	status := s.status
+	if pathStatus, ok := s.statuses[path.Clean(req.URL.Path)]; ok {
+		status = pathStatus
+	}
 	rsp := &http.Response{
 		Body:       io.NopCloser(strings.NewReader(body)),
 		Header:     s.header,
-		StatusCode: s.status,
+		StatusCode: status,
 	}
 	return rsp, s.err
 }
@@ -101,6 +115,214 @@ func (s *httpSuite) TestDoError(c *C) {
 
 	_, err := archive.Open(&options)
 	c.Check(err, ErrorMatches, "cannot talk to archive: BAM")
+	var fetchErr *archive.FetchError
+	c.Check(errors.As(err, &fetchErr), Equals, true)
+}
+
+type contextKey struct{}
+
+func (s *httpSuite) TestContext(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey{}, "value"))
+	defer cancel()
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		Context:    ctx,
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, Not(HasLen), 0)
+	for _, req := range s.requests {
+		c.Assert(req.Context().Value(contextKey{}), Equals, "value")
+	}
+
+	s.requests = nil
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 1)
+	c.Assert(s.requests[0].Context().Value(contextKey{}), Equals, "value")
+}
+
+func (s *httpSuite) TestHTTPOptions(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		HTTP: archive.HTTPOptions{
+			Timeout:     time.Minute,
+			BulkTimeout: time.Hour,
+			Headers:     map[string]string{"X-Token": "secret"},
+			UserAgent:   "test-agent/1.0",
+		},
+	}
+	checkRequest := func(req *http.Request, timeout time.Duration) {
+		c.Assert(req.Header.Get("X-Token"), Equals, "secret")
+		c.Assert(req.Header.Get("User-Agent"), Equals, "test-agent/1.0")
+		deadline, ok := req.Context().Deadline()
+		c.Assert(ok, Equals, true)
+		c.Assert(time.Until(deadline) > timeout-time.Minute/2, Equals, true)
+		c.Assert(time.Until(deadline) <= timeout, Equals, true)
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, Not(HasLen), 0)
+	for _, req := range s.requests {
+		if strings.HasSuffix(req.URL.Path, "/InRelease") {
+			checkRequest(req, time.Minute)
+		} else {
+			checkRequest(req, time.Hour)
+		}
+	}
+
+	s.requests = nil
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 1)
+	checkRequest(s.requests[0], time.Hour)
+}
+
+var httpOptionsErrorTests = []struct {
+	options archive.HTTPOptions
+	error   string
+}{{
+	options: archive.HTTPOptions{Timeout: -time.Second},
+	error:   `invalid timeout: -1s`,
+}, {
+	options: archive.HTTPOptions{BulkTimeout: -time.Second},
+	error:   `invalid bulk timeout: -1s`,
+}, {
+	options: archive.HTTPOptions{Headers: map[string]string{"X Token": "secret"}},
+	error:   `invalid header name: "X Token"`,
+}, {
+	options: archive.HTTPOptions{Headers: map[string]string{"X-Token": "a\nb"}},
+	error:   `invalid value for header X-Token: "a\\nb"`,
+}, {
+	options: archive.HTTPOptions{UserAgent: "agent\r\n"},
+	error:   `invalid user agent: "agent\\r\\n"`,
+}, {
+	options: archive.HTTPOptions{ChunkThreshold: -1},
+	error:   `invalid chunk threshold: -1`,
+}, {
+	options: archive.HTTPOptions{ChunkSize: -1},
+	error:   `invalid chunk size: -1`,
+}}
+
+func (s *httpSuite) TestHTTPOptionsError(c *C) {
+	for _, test := range httpOptionsErrorTests {
+		c.Assert(test.options.Validate(), ErrorMatches, test.error)
+	}
+}
+
+var mirrorTests = []struct {
+	summary string
+	mirrors []string
+	// delays holds how long each host takes to respond.
+	delays map[string]time.Duration
+	// down holds the hosts which cannot be reached.
+	down       []string
+	mirrorList string
+	mirror     string
+}{{
+	summary: "Fastest mirror is selected",
+	mirrors: []string{"http://fast.example.com/ubuntu", "http://slow.example.com/ubuntu/"},
+	delays: map[string]time.Duration{
+		"archive.ubuntu.com": 200 * time.Millisecond,
+		"slow.example.com":   200 * time.Millisecond,
+	},
+	mirror: "http://fast.example.com/ubuntu/",
+}, {
+	summary: "Default location is kept when fastest",
+	mirrors: []string{"http://slow.example.com/ubuntu/"},
+	delays: map[string]time.Duration{
+		"slow.example.com": 200 * time.Millisecond,
+	},
+	mirror: "",
+}, {
+	summary: "Unreachable mirrors are not selected",
+	mirrors: []string{"http://down.example.com/ubuntu/"},
+	delays: map[string]time.Duration{
+		"archive.ubuntu.com": 200 * time.Millisecond,
+	},
+	down:   []string{"down.example.com"},
+	mirror: "",
+}, {
+	summary:    "Mirrors suggested by mirrors.ubuntu.com",
+	mirrors:    []string{"auto"},
+	mirrorList: "http://slow.example.com/ubuntu/\nhttp://fast.example.com/ubuntu/\n",
+	delays: map[string]time.Duration{
+		"archive.ubuntu.com": 200 * time.Millisecond,
+		"slow.example.com":   200 * time.Millisecond,
+	},
+	mirror: "http://fast.example.com/ubuntu/",
+}}
+
+func (s *httpSuite) TestMirrors(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	s.base = ""
+
+	// Mirrors are probed concurrently.
+	var mu sync.Mutex
+	for _, test := range mirrorTests {
+		c.Logf("Summary: %s", test.summary)
+
+		restore := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+			if req.URL.String() == "http://mirrors.ubuntu.com/mirrors.txt" {
+				return &http.Response{
+					Body:       io.NopCloser(strings.NewReader(test.mirrorList)),
+					StatusCode: 200,
+				}, nil
+			}
+			if slices.Contains(test.down, req.URL.Host) {
+				return nil, errors.New("unreachable")
+			}
+			time.Sleep(test.delays[req.URL.Host])
+			mu.Lock()
+			defer mu.Unlock()
+			return s.Do(req)
+		})
+		s.requests = nil
+
+		options := archive.Options{
+			Label:      "ubuntu",
+			Version:    "22.04",
+			Arch:       "amd64",
+			Suites:     []string{"jammy"},
+			Components: []string{"main"},
+			CacheDir:   c.MkDir(),
+			PubKeys:    []*packet.PublicKey{s.pubKey},
+			Mirrors:    test.mirrors,
+		}
+		testArchive, err := archive.Open(&options)
+		restore()
+		c.Assert(err, IsNil)
+		c.Assert(archive.Mirror(testArchive), Equals, test.mirror)
+
+		// Everything other than the probes is fetched from the selection.
+		expected := test.mirror
+		if expected == "" {
+			expected = "http://archive.ubuntu.com/ubuntu/"
+		}
+		for _, req := range s.requests {
+			if req.Method == "HEAD" {
+				continue
+			}
+			c.Assert(strings.HasPrefix(req.URL.String(), expected), Equals, true, Commentf("%s", req.URL))
+		}
+	}
 }
 
 func (s *httpSuite) prepareArchive(suite, version, arch string, components []string) *testarchive.Release {
@@ -191,6 +413,26 @@ var optionErrorTests = []optionErrorTest{{
 		Pro:        "invalid",
 	},
 	error: `invalid pro value: "invalid"`,
+}, {
+	options: archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		Verify:     archive.VerifyOptions{MinDigest: "md5"},
+	},
+	error: `invalid digest algorithm: "md5"`,
+}, {
+	options: archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		Mirrors:    []string{"ftp://mirror.example.com/ubuntu/"},
+	},
+	error: `invalid mirror: "ftp://mirror.example.com/ubuntu/"`,
 }}
 
 func (s *httpSuite) TestOptionErrors(c *C) {
@@ -225,10 +467,15 @@ func (s *httpSuite) TestFetchPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "amd64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:          "mypkg1",
+		Version:       "1.1",
+		Arch:          "amd64",
+		SHA256:        "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Source:        "mypkg1",
+		SourceVersion: "1.1",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "main",
 	})
 	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
 
@@ -236,14 +483,492 @@ func (s *httpSuite) TestFetchPackage(c *C) {
 	pkg, info, err = testArchive.Fetch("mypkg4")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg4",
-		Version: "1.4",
-		Arch:    "amd64",
-		SHA256:  "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Name:          "mypkg4",
+		Version:       "1.4",
+		Arch:          "amd64",
+		SHA256:        "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Source:        "mypkg4",
+		SourceVersion: "1.4",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "universe",
 	})
 	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
 }
 
+func (s *httpSuite) TestFetchPackageChunked(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	// Ranged requests are honored when ranged is set, and are otherwise
+	// answered with the whole file. Chunks are fetched concurrently.
+	var mu sync.Mutex
+	var ranges []string
+	ranged := true
+	restore := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		rsp, err := s.Do(req)
+		header := req.Header.Get("Range")
+		if err != nil || header == "" {
+			return rsp, err
+		}
+		ranges = append(ranges, header)
+		if !ranged {
+			return rsp, nil
+		}
+		var start, end int
+		_, err = fmt.Sscanf(header, "bytes=%d-%d", &start, &end)
+		c.Assert(err, IsNil)
+		body, err := io.ReadAll(rsp.Body)
+		c.Assert(err, IsNil)
+		return &http.Response{
+			Body: io.NopCloser(bytes.NewReader(body[start : end+1])),
+			Header: http.Header{
+				"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", start, end, len(body))},
+			},
+			StatusCode: 206,
+		}, nil
+	})
+	defer restore()
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		HTTP: archive.HTTPOptions{
+			ChunkThreshold: 10,
+			ChunkSize:      4,
+		},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	slices.Sort(ranges)
+	c.Assert(ranges, DeepEquals, []string{"bytes=0-3", "bytes=12-14", "bytes=4-7", "bytes=8-11"})
+
+	// Packages below the threshold are fetched as a whole.
+	ranges = nil
+	options.HTTP.ChunkThreshold = 100
+	options.CacheDir = c.MkDir()
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	pkg, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(ranges, HasLen, 0)
+
+	// Archives which do not support ranged requests send the whole file.
+	ranged = false
+	options.HTTP.ChunkThreshold = 10
+	options.CacheDir = c.MkDir()
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	pkg, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(ranges, DeepEquals, []string{"bytes=0-3"})
+}
+
+// failingReader returns the data and then fails.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (s *httpSuite) TestFetchPackageResume(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	// The first download of the package fails after a few bytes, and
+	// ranged requests are honored when ranged is set.
+	var ranges []string
+	interrupt := true
+	ranged := true
+	restore := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		rsp, err := s.Do(req)
+		if err != nil || !strings.HasSuffix(req.URL.Path, ".deb") {
+			return rsp, err
+		}
+		body, err := io.ReadAll(rsp.Body)
+		c.Assert(err, IsNil)
+		if interrupt {
+			interrupt = false
+			rsp.Body = io.NopCloser(&failingReader{data: body[:5]})
+			return rsp, nil
+		}
+		header := req.Header.Get("Range")
+		ranges = append(ranges, header)
+		if header == "" || !ranged {
+			rsp.Body = io.NopCloser(bytes.NewReader(body))
+			return rsp, nil
+		}
+		var start int
+		_, err = fmt.Sscanf(header, "bytes=%d-", &start)
+		c.Assert(err, IsNil)
+		return &http.Response{
+			Body: io.NopCloser(bytes.NewReader(body[start:])),
+			Header: http.Header{
+				"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body))},
+			},
+			StatusCode: 206,
+		}, nil
+	})
+	defer restore()
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, "cannot fetch from archive: connection reset")
+
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(ranges, DeepEquals, []string{"bytes=5-"})
+	partial, err := os.ReadDir(filepath.Join(options.CacheDir, "partial"))
+	c.Assert(err, IsNil)
+	c.Assert(partial, HasLen, 0)
+
+	// Archives which do not support ranged requests send the whole file.
+	ranges = nil
+	interrupt = true
+	ranged = false
+	_, _, err = testArchive.Fetch("mypkg2")
+	c.Assert(err, NotNil)
+	pkg, _, err = testArchive.Fetch("mypkg2")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
+	c.Assert(ranges, DeepEquals, []string{"bytes=5-"})
+}
+
+func (s *httpSuite) TestFetchPackageChunkedResume(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	// The third chunk fails the first time it is requested.
+	var mu sync.Mutex
+	var ranges []string
+	interrupt := true
+	restore := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		rsp, err := s.Do(req)
+		header := req.Header.Get("Range")
+		if err != nil || header == "" {
+			return rsp, err
+		}
+		ranges = append(ranges, header)
+		if header == "bytes=8-11" && interrupt {
+			interrupt = false
+			return nil, errors.New("connection reset")
+		}
+		var start, end int
+		_, err = fmt.Sscanf(header, "bytes=%d-%d", &start, &end)
+		c.Assert(err, IsNil)
+		body, err := io.ReadAll(rsp.Body)
+		c.Assert(err, IsNil)
+		return &http.Response{
+			Body: io.NopCloser(bytes.NewReader(body[start : end+1])),
+			Header: http.Header{
+				"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", start, end, len(body))},
+			},
+			StatusCode: 206,
+		}, nil
+	})
+	defer restore()
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		HTTP: archive.HTTPOptions{
+			ChunkThreshold: 10,
+			ChunkSize:      4,
+		},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, "cannot talk to archive: connection reset")
+
+	// The chunks written before the failure are not fetched again.
+	ranges = nil
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(ranges, DeepEquals, []string{"bytes=8-11", "bytes=12-14"})
+	partial, err := os.ReadDir(filepath.Join(options.CacheDir, "partial"))
+	c.Assert(err, IsNil)
+	c.Assert(partial, HasLen, 0)
+}
+
+func (s *httpSuite) TestFetchPackageWaitsForLock(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		Context:    ctx,
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	// Another process is downloading the same package.
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("mypkg1 1.1 data")))
+	unlock, err := fsutil.Lock(context.Background(), filepath.Join(options.CacheDir, "partial", digest+".lock"), "test")
+	c.Assert(err, IsNil)
+
+	time.AfterFunc(200*time.Millisecond, cancel)
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, Equals, context.Canceled)
+
+	// Once the other process is done, the package is found in the cache
+	// without being downloaded again.
+	cache := &cache.Cache{Dir: options.CacheDir}
+	c.Assert(cache.Write(digest, []byte("mypkg1 1.1 data")), IsNil)
+	unlock()
+	options.Context = nil
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	s.requests = nil
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	for _, req := range s.requests {
+		c.Assert(strings.HasSuffix(req.URL.Path, ".deb"), Equals, false)
+	}
+}
+
+var parseSizeTests = []struct {
+	size   string
+	result int64
+	error  string
+}{
+	{size: "0", result: 0},
+	{size: "1024", result: 1024},
+	{size: "4KiB", result: 4 << 10},
+	{size: "64MiB", result: 64 << 20},
+	{size: "2 GiB", result: 2 << 30},
+	{size: "64MB", error: `invalid size: "64MB"`},
+	{size: "-1", error: `invalid size: "-1"`},
+	{size: "", error: `invalid size: ""`},
+}
+
+func (s *httpSuite) TestParseSize(c *C) {
+	for _, test := range parseSizeTests {
+		result, err := archive.ParseSize(test.size)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(result, Equals, test.result)
+	}
+}
+
+func (s *httpSuite) TestRevalidateIndexes(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+	s.header = http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Thu, 21 Apr 2022 17:16:08 GMT"},
+	}
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	_, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 3)
+
+	// The release is revalidated, and the package indexes are found
+	// in the cache by their digests.
+	s.requests = nil
+	s.status = 304
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 1)
+	c.Assert(s.requests[0].URL.Path, Equals, "/ubuntu/dists/jammy/InRelease")
+	c.Assert(s.requests[0].Header.Get("If-None-Match"), Equals, `"v1"`)
+	c.Assert(s.requests[0].Header.Get("If-Modified-Since"), Equals, "Thu, 21 Apr 2022 17:16:08 GMT")
+	c.Assert(testArchive.Exists("mypkg1"), Equals, true)
+
+	// Refreshing downloads all the indexes again.
+	s.requests = nil
+	s.status = 200
+	options.Refresh = true
+	_, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 3)
+	c.Assert(s.requests[0].Header.Get("If-None-Match"), Equals, "")
+}
+
+func (s *httpSuite) TestFetchIndexByHash(c *C) {
+	release := s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(r *testarchive.Release) {
+		r.AcquireByHash = true
+	})
+	// The index is only available by hash.
+	delete(s.responses, "/ubuntu/dists/jammy/main/binary-amd64/Packages.gz")
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(testArchive.Exists("mypkg1"), Equals, true)
+	gzIndex := release.Items[1].Content()
+	c.Assert(s.requests[1].URL.Path, Equals, "/ubuntu/dists/jammy/main/binary-amd64/by-hash/SHA256/"+fmt.Sprintf("%x", sha256.Sum256(gzIndex)))
+
+	// Without the file by hash, the index is fetched by name.
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	for p := range s.responses {
+		if strings.Contains(p, "/by-hash/") {
+			s.statuses[p] = 404
+		}
+	}
+	s.requests = nil
+	options.CacheDir = c.MkDir()
+	options.PubKeys = []*packet.PublicKey{s.pubKey}
+	_, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(s.requests, HasLen, 2)
+	c.Assert(s.requests[1].URL.Path, Equals, "/ubuntu/dists/jammy/main/binary-amd64/Packages.gz")
+}
+
+func (s *httpSuite) TestFetchPatchedIndex(c *C) {
+	oldIndex := &testarchive.PackageIndex{
+		Component: "main",
+		Arch:      "amd64",
+		Packages: []testarchive.Item{
+			&testarchive.Package{Name: "mypkg1", Version: "1.1", Arch: "amd64", Component: "main"},
+			&testarchive.Package{Name: "mypkg2", Version: "1.2", Arch: "amd64", Component: "main"},
+		},
+	}
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", nil, func(r *testarchive.Release) {
+		r.Items = []testarchive.Item{oldIndex, &testarchive.Gzip{oldIndex}}
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	_, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	// A package is added to the index, and a patch is published to update
+	// the earlier version.
+	newPkg := &testarchive.Package{Name: "mypkg3", Version: "1.3", Arch: "amd64", Component: "main"}
+	newIndex := &testarchive.PackageIndex{
+		Component: "main",
+		Arch:      "amd64",
+		Packages:  append(oldIndex.Packages, newPkg),
+	}
+	oldContent := oldIndex.Content()
+	newContent := newIndex.Content()
+	script := []byte(fmt.Sprintf("%da\n%s.\n", bytes.Count(oldContent, []byte("\n")), newPkg.Section()))
+	patchName := "2024-01-01-0000.00"
+	diffIndex := fmt.Sprintf("SHA256-Current: %x %d\n"+
+		"SHA256-History:\n %x %d %s\n"+
+		"SHA256-Patches:\n %x %d %s\n",
+		sha256.Sum256(newContent), len(newContent),
+		sha256.Sum256(oldContent), len(oldContent), patchName,
+		sha256.Sum256(script), len(script), patchName)
+	s.responses = make(map[string][]byte)
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", nil, func(r *testarchive.Release) {
+		r.Items = []testarchive.Item{
+			newIndex,
+			&testarchive.Gzip{newIndex},
+			&testarchive.File{Name: "main/binary-amd64/Packages.diff/Index", Data: []byte(diffIndex)},
+			&testarchive.Gzip{&testarchive.File{Name: "main/binary-amd64/Packages.diff/" + patchName, Data: script}},
+		}
+	})
+	// The new index can only be obtained by patching.
+	delete(s.responses, "/ubuntu/dists/jammy/main/binary-amd64/Packages.gz")
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(testArchive.Exists("mypkg3"), Equals, true)
+
+	// Patching falls back to fetching the whole index when it fails.
+	patchPath := "/ubuntu/dists/jammy/main/binary-amd64/Packages.diff/" + patchName + ".gz"
+	newResponses := s.responses
+	s.responses = make(map[string][]byte)
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", nil, func(r *testarchive.Release) {
+		r.Items = []testarchive.Item{oldIndex, &testarchive.Gzip{oldIndex}}
+	})
+	options.CacheDir = c.MkDir()
+	_, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	s.responses = newResponses
+	s.responses["/ubuntu/dists/jammy/main/binary-amd64/Packages.gz"] = (&testarchive.Gzip{newIndex}).Content()
+	s.statuses[patchPath] = 404
+	s.requests = nil
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(testArchive.Exists("mypkg3"), Equals, true)
+	var paths []string
+	for _, req := range s.requests {
+		paths = append(paths, req.URL.Path)
+	}
+	c.Assert(paths, DeepEquals, []string{
+		"/ubuntu/dists/jammy/InRelease",
+		"/ubuntu/dists/jammy/main/binary-amd64/Packages.diff/Index",
+		patchPath,
+		"/ubuntu/dists/jammy/main/binary-amd64/Packages.gz",
+	})
+}
+
 func (s *httpSuite) TestFetchPortsPackage(c *C) {
 
 	s.base = "http://ports.ubuntu.com/ubuntu-ports/"
@@ -267,10 +992,15 @@ func (s *httpSuite) TestFetchPortsPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "arm64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:          "mypkg1",
+		Version:       "1.1",
+		Arch:          "arm64",
+		SHA256:        "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Source:        "mypkg1",
+		SourceVersion: "1.1",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "main",
 	})
 	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
 
@@ -278,10 +1008,15 @@ func (s *httpSuite) TestFetchPortsPackage(c *C) {
 	pkg, info, err = testArchive.Fetch("mypkg4")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg4",
-		Version: "1.4",
-		Arch:    "arm64",
-		SHA256:  "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Name:          "mypkg4",
+		Version:       "1.4",
+		Arch:          "arm64",
+		SHA256:        "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Source:        "mypkg4",
+		SourceVersion: "1.4",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "universe",
 	})
 	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
 }
@@ -316,20 +1051,30 @@ func (s *httpSuite) TestFetchSecurityPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1.2.2",
-		Arch:    "amd64",
-		SHA256:  "5448585bdd916e5023eff2bc1bc3b30bcc6ee9db9c03e531375a6a11ddf0913c",
+		Name:          "mypkg1",
+		Version:       "1.1.2.2",
+		Arch:          "amd64",
+		SHA256:        "5448585bdd916e5023eff2bc1bc3b30bcc6ee9db9c03e531375a6a11ddf0913c",
+		Source:        "mypkg1",
+		SourceVersion: "1.1.2.2",
+		InstalledSize: 10240,
+		Suite:         "jammy-security",
+		Component:     "main",
 	})
 	c.Assert(read(pkg), Equals, "package from jammy-security")
 
 	pkg, info, err = testArchive.Fetch("mypkg2")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg2",
-		Version: "1.2",
-		Arch:    "amd64",
-		SHA256:  "a4b4f3f3a8fa09b69e3ba23c60a41a1f8144691fd371a2455812572fd02e6f79",
+		Name:          "mypkg2",
+		Version:       "1.2",
+		Arch:          "amd64",
+		SHA256:        "a4b4f3f3a8fa09b69e3ba23c60a41a1f8144691fd371a2455812572fd02e6f79",
+		Source:        "mypkg2",
+		SourceVersion: "1.2",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "main",
 	})
 	c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
 }
@@ -556,12 +1301,110 @@ func (s *httpSuite) TestVerifyArchiveRelease(c *C) {
 		_, err := archive.Open(&options)
 		if test.error != "" {
 			c.Assert(err, ErrorMatches, test.error)
+			var verifyErr *archive.VerifyError
+			c.Assert(errors.As(err, &verifyErr), Equals, true)
 		} else {
 			c.Assert(err, IsNil)
 		}
 	}
 }
 
+var verifyPolicyTests = []struct {
+	summary    string
+	adjust     func(r *testarchive.Release)
+	verify     archive.VerifyOptions
+	openError  string
+	fetchError string
+}{{
+	summary: "Minimum SHA512 digests",
+	adjust: func(r *testarchive.Release) {
+		r.SHA512 = true
+		adjustPackages(r, func(p *testarchive.Package) { p.SHA512 = true })
+	},
+	verify: archive.VerifyOptions{MinDigest: archive.DigestSHA512},
+}, {
+	summary:   "Minimum SHA512 digests missing from release",
+	verify:    archive.VerifyOptions{MinDigest: archive.DigestSHA512},
+	openError: "archive jammy suite has no SHA512 digests",
+}, {
+	summary: "Minimum SHA512 digests missing from package",
+	adjust: func(r *testarchive.Release) {
+		r.SHA512 = true
+	},
+	verify:     archive.VerifyOptions{MinDigest: archive.DigestSHA512},
+	fetchError: `package "mypkg1" has no SHA512 digest`,
+}, {
+	summary: "Require by-hash",
+	adjust: func(r *testarchive.Release) {
+		r.AcquireByHash = true
+	},
+	verify: archive.VerifyOptions{RequireByHash: true},
+}, {
+	summary:   "Require by-hash unsupported",
+	verify:    archive.VerifyOptions{RequireByHash: true},
+	openError: "archive jammy suite does not support fetching indexes by hash",
+}, {
+	summary: "MD5-only packages are fetched by default",
+	adjust: func(r *testarchive.Release) {
+		adjustPackages(r, func(p *testarchive.Package) { p.MD5Only = true })
+	},
+}, {
+	summary: "Reject MD5-only packages",
+	adjust: func(r *testarchive.Release) {
+		adjustPackages(r, func(p *testarchive.Package) { p.MD5Only = true })
+	},
+	verify:     archive.VerifyOptions{RejectMD5Only: true},
+	fetchError: `package "mypkg1" has only MD5 digests`,
+}}
+
+func adjustPackages(r *testarchive.Release, f func(p *testarchive.Package)) {
+	for _, item := range r.Items {
+		if index, ok := item.(*testarchive.PackageIndex); ok {
+			for _, pkg := range index.Packages {
+				f(pkg.(*testarchive.Package))
+			}
+		}
+	}
+}
+
+func (s *httpSuite) TestVerifyPolicy(c *C) {
+	for _, test := range verifyPolicyTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.responses = make(map[string][]byte)
+		s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, test.adjust)
+
+		options := archive.Options{
+			Label:      "ubuntu",
+			Version:    "22.04",
+			Arch:       "amd64",
+			Suites:     []string{"jammy"},
+			Components: []string{"main"},
+			CacheDir:   c.MkDir(),
+			PubKeys:    []*packet.PublicKey{s.pubKey},
+			Verify:     test.verify,
+		}
+		var verifyErr *archive.VerifyError
+
+		testArchive, err := archive.Open(&options)
+		if test.openError != "" {
+			c.Assert(err, ErrorMatches, test.openError)
+			c.Assert(errors.As(err, &verifyErr), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		pkg, _, err := testArchive.Fetch("mypkg1")
+		if test.fetchError != "" {
+			c.Assert(err, ErrorMatches, test.fetchError)
+			c.Assert(errors.As(err, &verifyErr), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	}
+}
+
 var packageInfoTests = []struct {
 	summary string
 	pkg     string
@@ -571,10 +1414,15 @@ var packageInfoTests = []struct {
 	summary: "Basic",
 	pkg:     "mypkg1",
 	info: &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "amd64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:          "mypkg1",
+		Version:       "1.1",
+		Arch:          "amd64",
+		SHA256:        "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Source:        "mypkg1",
+		SourceVersion: "1.1",
+		InstalledSize: 10240,
+		Suite:         "jammy",
+		Component:     "main",
 	},
 }, {
 	summary: "Package not found in archive",
@@ -609,6 +1457,231 @@ func (s *httpSuite) TestPackageInfo(c *C) {
 	}
 }
 
+func (s *httpSuite) TestClose(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	c.Assert(testArchive.Exists("mypkg1"), Equals, true)
+
+	// The index files read on demand are closed with the archive.
+	err = testArchive.Close()
+	c.Assert(err, IsNil)
+	c.Assert(testArchive.Exists("mypkg1"), Equals, false)
+	err = testArchive.Close()
+	c.Assert(err, IsNil)
+}
+
+func (s *httpSuite) TestPackageSource(c *C) {
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(r *testarchive.Release) {
+		adjustPackages(r, func(p *testarchive.Package) {
+			switch p.Name {
+			case "mypkg1":
+				p.Source = "mysrc"
+			case "mypkg2":
+				p.Source = "mysrc (2:1.0-1)"
+			}
+		})
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	// The source version is the package one unless noted.
+	info, err := testArchive.Info("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Source, Equals, "mysrc")
+	c.Assert(info.SourceVersion, Equals, "1.1")
+
+	info, err = testArchive.Info("mypkg2")
+	c.Assert(err, IsNil)
+	c.Assert(info.Source, Equals, "mysrc")
+	c.Assert(info.SourceVersion, Equals, "2:1.0-1")
+}
+
+func (s *httpSuite) TestPackageRelations(c *C) {
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(r *testarchive.Release) {
+		adjustPackages(r, func(p *testarchive.Package) {
+			if p.Name == "mypkg1" {
+				p.Fields = "Conflicts: mypkg2 (<< 2.0), mypkg3:any | mypkg4\n" +
+					"Breaks: mypkg5 (>= 1.0~)\n" +
+					"Replaces: mypkg2, invalid entry\n"
+			}
+		})
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	info, err := testArchive.Info("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Conflicts, DeepEquals, []archive.Relation{
+		{Name: "mypkg2", Op: "<<", Version: "2.0"},
+		{Name: "mypkg3"},
+		{Name: "mypkg4"},
+	})
+	c.Assert(info.Breaks, DeepEquals, []archive.Relation{{Name: "mypkg5", Op: ">=", Version: "1.0~"}})
+	c.Assert(info.Replaces, DeepEquals, []archive.Relation{{Name: "mypkg2"}})
+
+	c.Assert(info.Conflicts[0].Matches("mypkg2", "1.9"), Equals, true)
+	c.Assert(info.Conflicts[0].Matches("mypkg2", "2.0"), Equals, false)
+	c.Assert(info.Conflicts[0].Matches("mypkg3", "1.0"), Equals, false)
+	c.Assert(info.Breaks[0].Matches("mypkg5", "1.0"), Equals, true)
+	c.Assert(info.Conflicts[0].String(), Equals, "mypkg2 (<< 2.0)")
+
+	info, err = testArchive.Info("mypkg2")
+	c.Assert(err, IsNil)
+	c.Assert(info.Conflicts, IsNil)
+}
+
+func (s *httpSuite) TestChangelogURL(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	url := archive.ChangelogURL(testArchive, "mypkg1")
+	c.Assert(url, Equals, "https://changelogs.ubuntu.com/changelogs/pool/main/m/mypkg1/mypkg1_1.1/changelog")
+
+	url = archive.ChangelogURL(testArchive, "mypkg99")
+	c.Assert(url, Equals, "")
+}
+
+func (s *httpSuite) TestFetchChangelog(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	s.base = "https://changelogs.ubuntu.com/"
+	s.responses["/changelogs/pool/main/m/mypkg1/mypkg1_1.1/changelog"] = []byte("mypkg1 (1.1) jammy; urgency=medium\n")
+	changelog, err := archive.FetchChangelog(testArchive, "mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(string(changelog), Equals, "mypkg1 (1.1) jammy; urgency=medium\n")
+
+	s.statuses["/changelogs/pool/main/m/mypkg2/mypkg2_1.2/changelog"] = 404
+	_, err = archive.FetchChangelog(testArchive, "mypkg2")
+	c.Assert(err, ErrorMatches, `no changelog published for package "mypkg2"`)
+
+	_, err = archive.FetchChangelog(testArchive, "mypkg99")
+	c.Assert(err, ErrorMatches, `no changelog published for package "mypkg99"`)
+}
+
+func (s *httpSuite) TestFindPath(c *C) {
+	contents := &testarchive.Gzip{Item: &testarchive.File{
+		Name: "Contents-amd64",
+		Data: testutil.Reindent(`
+			usr/bin/mypkg1                  admin/mypkg1
+			usr/lib/libfoo.so.1             libs/mypkg2,universe/libs/mypkg3
+			usr/lib/libfoo.so.1.0           libs/mypkg2
+			usr/share/doc/mypkg1/copyright  doc/mypkg1
+		`),
+	}}
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main", "universe"}, func(release *testarchive.Release) {
+		release.Items = append(release.Items, contents)
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	found, err := archive.FindPath(testArchive, "/usr/lib/libfoo.so.1")
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []archive.PathPackages{
+		{Path: "/usr/lib/libfoo.so.1", Packages: []string{"mypkg2", "mypkg3"}},
+	})
+
+	// The index is read from the cache afterwards.
+	delete(s.responses, "/ubuntu/dists/jammy/Contents-amd64.gz")
+	found, err = archive.FindPath(testArchive, "/usr/lib/libfoo.so.*")
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []archive.PathPackages{
+		{Path: "/usr/lib/libfoo.so.1", Packages: []string{"mypkg2", "mypkg3"}},
+		{Path: "/usr/lib/libfoo.so.1.0", Packages: []string{"mypkg2"}},
+	})
+
+	found, err = archive.FindPath(testArchive, "/usr/bin/other")
+	c.Assert(err, IsNil)
+	c.Assert(found, HasLen, 0)
+}
+
+func (s *httpSuite) TestFindPathNoContents(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	_, err = archive.FindPath(testArchive, "/usr/bin/mypkg1")
+	c.Assert(err, ErrorMatches, `archive "ubuntu" has no contents index`)
+}
+
 func read(r io.Reader) string {
 	data, err := io.ReadAll(r)
 	if err != nil {
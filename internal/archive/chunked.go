@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultChunkSize = 8 << 20
+
+// maxChunkFetches limits the ranged requests in flight for a package, which
+// also bounds the memory holding the chunks not yet written to the cache.
+const maxChunkFetches = 4
+
+// ParseSize parses a number of bytes, optionally followed by one of the
+// KiB, MiB or GiB units, as in "64MiB".
+func ParseSize(s string) (int64, error) {
+	number, unit := s, int64(1)
+	for suffix, size := range map[string]int64{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			number, unit = n, size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * unit, nil
+}
+
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// fetchChunked fetches the package at suffix, of the given size, with
+// concurrent ranged requests of the configured chunk size. If the archive
+// does not support ranged requests the package is fetched as a whole. The
+// chunks are appended in order to the partial file of the package, so that
+// an interrupted download continues from the chunks already fetched.
+func (index *ubuntuIndex) fetchChunked(suffix, digest string, size int64) (io.ReadSeekCloser, error) {
+	return index.fetchPartial(suffix, digest, func(partial *os.File) error {
+		return index.downloadChunks(suffix, size, partial)
+	})
+}
+
+// downloadChunks appends to the partial file the rest of the file at
+// suffix, of the given size, in chunks.
+func (index *ubuntuIndex) downloadChunks(suffix string, size int64, partial *os.File) error {
+	offset, err := partial.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset >= size {
+		// Complete, or not part of the file at all.
+		if offset == size {
+			return nil
+		}
+		offset, err = 0, partial.Truncate(0)
+		if err != nil {
+			return err
+		}
+	}
+	if offset > 0 {
+		logf("Resuming %s after %d bytes...", strings.TrimPrefix(suffix, "../../"), offset)
+	}
+
+	chunkSize := index.archive.options.HTTP.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	url := index.url(suffix)
+	ctx, cancel := index.requestContext(fetchBulk)
+	defer cancel()
+
+	// The response to the first chunk tells whether the archive supports
+	// ranged requests at all.
+	resp, err := index.fetchRange(ctx, url, offset, min(offset+chunkSize, size))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		err = partial.Truncate(0)
+		if err == nil {
+			_, err = partial.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			_, err = io.Copy(partial, resp.Body)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+		}
+		return nil
+	}
+	first := readChunk(resp, offset, min(offset+chunkSize, size), size)
+	if first.err != nil {
+		return first.err
+	}
+	_, err = partial.Write(first.data)
+	if err != nil {
+		return &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+	}
+
+	var pending []chan chunk
+	next := offset + chunkSize
+	start := func() {
+		result := make(chan chunk, 1)
+		pending = append(pending, result)
+		go func(start, end int64) {
+			resp, err := index.fetchRange(ctx, url, start, end)
+			if err == nil && resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
+				err = &FetchError{fmt.Errorf("error from archive: ranged request not honored: %v", resp.Status)}
+			}
+			if err != nil {
+				result <- chunk{err: err}
+				return
+			}
+			result <- readChunk(resp, start, end, size)
+		}(next, min(next+chunkSize, size))
+		next += chunkSize
+	}
+	for next < size && len(pending) < maxChunkFetches {
+		start()
+	}
+	for len(pending) > 0 {
+		result := <-pending[0]
+		pending = pending[1:]
+		if result.err != nil {
+			return result.err
+		}
+		_, err = partial.Write(result.data)
+		if err != nil {
+			return &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+		}
+		if next < size {
+			start()
+		}
+	}
+	return nil
+}
+
+// fetchRange requests the bytes from start to end, exclusive, of the file
+// at url. The response is either the requested range or the whole file.
+func (index *ubuntuIndex) fetchRange(ctx context.Context, url string, start, end int64) (*http.Response, error) {
+	req, err := index.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := bulkDo(req)
+	if err != nil {
+		return nil, &FetchError{fmt.Errorf("cannot talk to archive: %v", err)}
+	}
+	countDownload(resp)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, index.responseError(resp)
+	}
+	return resp, nil
+}
+
+// readChunk reads the range from start to end, exclusive, of a file of
+// the given size from the ranged response, and closes it.
+func readChunk(resp *http.Response, start, end, size int64) chunk {
+	defer resp.Body.Close()
+	contentRange := fmt.Sprintf("bytes %d-%d/%d", start, end-1, size)
+	if got := resp.Header.Get("Content-Range"); got != contentRange {
+		return chunk{err: &FetchError{fmt.Errorf("error from archive: unexpected content range %q, expected %q", got, contentRange)}}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, end-start+1))
+	if err == nil && int64(len(data)) != end-start {
+		err = fmt.Errorf("got %d bytes of range %d-%d", len(data), start, end-1)
+	}
+	if err != nil {
+		return chunk{err: &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}}
+	}
+	return chunk{data: data}
+}
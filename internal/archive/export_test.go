@@ -21,3 +21,5 @@ var FindCredentials = findCredentials
 var FindCredentialsInDir = findCredentialsInDir
 
 var ProArchiveInfo = proArchiveInfo
+
+var ApplyEdScript = applyEdScript
@@ -0,0 +1,262 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/control"
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// LocalOptions holds the details for opening an archive made of .deb files
+// which are not obtained from apt archives.
+type LocalOptions struct {
+	Label string
+	Arch  string
+	// Paths lists .deb files found in the local filesystem.
+	Paths []string
+	// External lists packages distributed outside of apt archives. Packages
+	// provided via Paths take precedence over these.
+	External []ExternalPackage
+	CacheDir string
+}
+
+// ExternalPackage describes a .deb file obtained either from the local
+// filesystem or from a URL, and which must match the provided digest.
+type ExternalPackage struct {
+	Name   string
+	Path   string
+	URL    string
+	SHA256 string
+}
+
+type localArchive struct {
+	options  Options
+	cache    *cache.Cache
+	packages map[string]*localPackage
+}
+
+type localPackage struct {
+	path string
+	info *PackageInfo
+}
+
+// OpenLocal returns an Archive that serves the .deb files listed in the
+// options. Packages must be built for the archive architecture or "all".
+func OpenLocal(options *LocalOptions) (Archive, error) {
+	var err error
+	arch := options.Arch
+	if arch == "" {
+		arch, err = deb.InferArch()
+	} else {
+		err = deb.ValidateArch(arch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &localArchive{
+		options: Options{
+			Label:      options.Label,
+			Arch:       arch,
+			Maintained: true,
+		},
+		cache: &cache.Cache{
+			Dir: options.CacheDir,
+		},
+		packages: make(map[string]*localPackage),
+	}
+	for _, path := range options.Paths {
+		info, err := ReadDebInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Arch != arch && info.Arch != "all" {
+			return nil, fmt.Errorf("cannot use %s: package architecture %q does not match %q", path, info.Arch, arch)
+		}
+		if old, ok := archive.packages[info.Name]; ok {
+			return nil, fmt.Errorf("package %q provided twice: %s and %s", info.Name, old.path, path)
+		}
+		archive.packages[info.Name] = &localPackage{path: path, info: info}
+	}
+	for _, external := range options.External {
+		if _, ok := archive.packages[external.Name]; ok {
+			continue
+		}
+		path, err := archive.externalPath(&external)
+		if err != nil {
+			return nil, err
+		}
+		info, err := ReadDebInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.SHA256 != external.SHA256 {
+			return nil, fmt.Errorf("cannot use package %q: expected digest %s, got %s", external.Name, external.SHA256, info.SHA256)
+		}
+		if info.Name != external.Name {
+			return nil, fmt.Errorf("cannot use package %q: %s contains package %q", external.Name, filepath.Base(path), info.Name)
+		}
+		if info.Arch != arch && info.Arch != "all" {
+			return nil, fmt.Errorf("cannot use package %q: package architecture %q does not match %q", external.Name, info.Arch, arch)
+		}
+		archive.packages[info.Name] = &localPackage{path: path, info: info}
+	}
+	return archive, nil
+}
+
+// externalPath returns the path of the .deb file for the external package,
+// downloading it into the cache first if necessary.
+func (a *localArchive) externalPath(external *ExternalPackage) (string, error) {
+	if external.Path != "" {
+		return external.Path, nil
+	}
+	if external.URL == "" {
+		return "", fmt.Errorf("package %q has no source location", external.Name)
+	}
+	reader, err := a.cache.Open(external.SHA256)
+	if err == nil {
+		return cachedPath(reader)
+	} else if err != cache.MissErr {
+		return "", err
+	}
+
+	logf("Fetching %s...", external.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultBulkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", external.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot create HTTP request: %v", err)
+	}
+	resp, err := bulkDo(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch package %q: %v", external.Name, err)
+	}
+	countDownload(resp)
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("cannot fetch package %q: %v", external.Name, resp.Status)
+	}
+
+	writer := a.cache.Create(external.SHA256)
+	defer writer.Close()
+	_, err = io.Copy(writer, resp.Body)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch package %q: %v", external.Name, err)
+	}
+	reader, err = a.cache.Open(external.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return cachedPath(reader)
+}
+
+func cachedPath(reader io.ReadSeekCloser) (string, error) {
+	defer reader.Close()
+	file, ok := reader.(*os.File)
+	if !ok {
+		return "", fmt.Errorf("internal error: cache entry is not a file")
+	}
+	return file.Name(), nil
+}
+
+// ReadDebInfo returns the package information of the .deb file at path.
+func ReadDebInfo(path string) (*PackageInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := deb.ReadControl(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read control data of %s: %w", filepath.Base(path), err)
+	}
+	// The control data holds a single section, indexed by a key of its own
+	// as the package name is not known yet.
+	ctrl, err := control.ParseString("Control", "Control: deb\n"+string(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse control data of %s: %w", filepath.Base(path), err)
+	}
+	section := ctrl.Section("deb")
+	if section == nil || section.Get("Package") == "" {
+		return nil, fmt.Errorf("cannot parse control data of %s: missing Package field", filepath.Base(path))
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return nil, err
+	}
+
+	source, sourceVersion := sectionSource(section)
+	info := &PackageInfo{
+		Name:          section.Get("Package"),
+		Version:       section.Get("Version"),
+		Arch:          section.Get("Architecture"),
+		SHA256:        hex.EncodeToString(h.Sum(nil)),
+		Source:        source,
+		SourceVersion: sourceVersion,
+		InstalledSize: parseInstalledSize(section.Get("Installed-Size")),
+		Conflicts:     parseRelations(section.Get("Conflicts")),
+		Breaks:        parseRelations(section.Get("Breaks")),
+		Replaces:      parseRelations(section.Get("Replaces")),
+	}
+	if info.Version == "" {
+		return nil, fmt.Errorf("package %q in %s is missing version", info.Name, filepath.Base(path))
+	}
+	if info.Arch == "" {
+		return nil, fmt.Errorf("package %q in %s is missing architecture", info.Name, filepath.Base(path))
+	}
+	return info, nil
+}
+
+func (a *localArchive) Options() *Options {
+	return &a.options
+}
+
+func (a *localArchive) Close() error {
+	return nil
+}
+
+func (a *localArchive) Exists(pkg string) bool {
+	_, ok := a.packages[pkg]
+	return ok
+}
+
+func (a *localArchive) Info(pkg string) (*PackageInfo, error) {
+	local, ok := a.packages[pkg]
+	if !ok {
+		return nil, fmt.Errorf("cannot find package %q in local archive", pkg)
+	}
+	info := *local.info
+	return &info, nil
+}
+
+func (a *localArchive) Fetch(pkg string) (io.ReadSeekCloser, *PackageInfo, error) {
+	local, ok := a.packages[pkg]
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot find package %q in local archive", pkg)
+	}
+	logf("Reading %s...", local.path)
+	file, err := os.Open(local.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := *local.info
+	return file, &info, nil
+}
@@ -0,0 +1,183 @@
+package archive_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var localDebControl = `Package: mypkg
+Version: 1.0
+Architecture: amd64
+Description: Local package
+`
+
+var localDebEntries = []testutil.TarEntry{
+	testutil.Dir(0755, "./"),
+	testutil.Reg(0644, "./file", "data"),
+}
+
+func (s *S) TestOpenLocal(c *C) {
+	data := testutil.MustMakeDebWithControl(localDebControl, localDebEntries)
+	debPath := filepath.Join(c.MkDir(), "mypkg_1.0_amd64.deb")
+	err := os.WriteFile(debPath, data, 0644)
+	c.Assert(err, IsNil)
+
+	local, err := archive.OpenLocal(&archive.LocalOptions{
+		Label: "local",
+		Arch:  "amd64",
+		Paths: []string{debPath},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(local.Options().Arch, Equals, "amd64")
+	c.Assert(local.Exists("mypkg"), Equals, true)
+	c.Assert(local.Exists("other"), Equals, false)
+
+	digest := sha256.Sum256(data)
+	expected := &archive.PackageInfo{
+		Name:          "mypkg",
+		Version:       "1.0",
+		Arch:          "amd64",
+		SHA256:        hex.EncodeToString(digest[:]),
+		Source:        "mypkg",
+		SourceVersion: "1.0",
+	}
+	info, err := local.Info("mypkg")
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, expected)
+
+	reader, info, err := local.Fetch("mypkg")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	c.Assert(info, DeepEquals, expected)
+	c.Assert(read(reader), Equals, string(data))
+
+	_, _, err = local.Fetch("other")
+	c.Assert(err, ErrorMatches, `cannot find package "other" in local archive`)
+}
+
+func (s *S) TestOpenLocalErrors(c *C) {
+	dir := c.MkDir()
+	writeDeb := func(name, control string) string {
+		var data []byte
+		if control == "" {
+			data = testutil.MustMakeDeb(localDebEntries)
+		} else {
+			data = testutil.MustMakeDebWithControl(control, localDebEntries)
+		}
+		debPath := filepath.Join(dir, name)
+		err := os.WriteFile(debPath, data, 0644)
+		c.Assert(err, IsNil)
+		return debPath
+	}
+
+	debPath := writeDeb("mypkg.deb", localDebControl)
+	_, err := archive.OpenLocal(&archive.LocalOptions{
+		Arch:  "arm64",
+		Paths: []string{debPath},
+	})
+	c.Assert(err, ErrorMatches, `cannot use .*/mypkg.deb: package architecture "amd64" does not match "arm64"`)
+
+	otherPath := writeDeb("other.deb", localDebControl)
+	_, err = archive.OpenLocal(&archive.LocalOptions{
+		Arch:  "amd64",
+		Paths: []string{debPath, otherPath},
+	})
+	c.Assert(err, ErrorMatches, `package "mypkg" provided twice: .*/mypkg.deb and .*/other.deb`)
+
+	noControlPath := writeDeb("nocontrol.deb", "")
+	_, err = archive.OpenLocal(&archive.LocalOptions{
+		Arch:  "amd64",
+		Paths: []string{noControlPath},
+	})
+	c.Assert(err, ErrorMatches, `cannot read control data of nocontrol.deb: no control payload`)
+
+	noVersionPath := writeDeb("noversion.deb", "Package: mypkg\nArchitecture: all\n")
+	_, err = archive.OpenLocal(&archive.LocalOptions{
+		Arch:  "amd64",
+		Paths: []string{noVersionPath},
+	})
+	c.Assert(err, ErrorMatches, `package "mypkg" in noversion.deb is missing version`)
+
+	noPackagePath := writeDeb("nopackage.deb", "Version: 1.0\nArchitecture: all\n")
+	_, err = archive.OpenLocal(&archive.LocalOptions{
+		Arch:  "amd64",
+		Paths: []string{noPackagePath},
+	})
+	c.Assert(err, ErrorMatches, `cannot parse control data of nopackage.deb: missing Package field`)
+}
+
+func (s *S) TestOpenLocalExternal(c *C) {
+	data := testutil.MustMakeDebWithControl(localDebControl, localDebEntries)
+	digest := sha256.Sum256(data)
+	hexDigest := hex.EncodeToString(digest[:])
+
+	var requests []string
+	restore := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.URL.String())
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+		}, nil
+	})
+	defer restore()
+
+	options := &archive.LocalOptions{
+		Arch: "amd64",
+		External: []archive.ExternalPackage{{
+			Name:   "mypkg",
+			URL:    "https://example.com/mypkg_1.0_amd64.deb",
+			SHA256: hexDigest,
+		}},
+		CacheDir: c.MkDir(),
+	}
+	local, err := archive.OpenLocal(options)
+	c.Assert(err, IsNil)
+	info, err := local.Info("mypkg")
+	c.Assert(err, IsNil)
+	c.Assert(info.SHA256, Equals, hexDigest)
+	c.Assert(requests, DeepEquals, []string{"https://example.com/mypkg_1.0_amd64.deb"})
+
+	// The second time the package is obtained from the cache.
+	_, err = archive.OpenLocal(options)
+	c.Assert(err, IsNil)
+	c.Assert(requests, HasLen, 1)
+
+	// Digest mismatch.
+	options.CacheDir = c.MkDir()
+	options.External[0].SHA256 = strings.Repeat("a", 64)
+	_, err = archive.OpenLocal(options)
+	c.Assert(err, ErrorMatches, `cannot fetch package "mypkg": expected digest a+, got `+hexDigest)
+
+	// Local file with digest mismatch.
+	debPath := filepath.Join(c.MkDir(), "mypkg.deb")
+	err = os.WriteFile(debPath, data, 0644)
+	c.Assert(err, IsNil)
+	options.External[0].URL = ""
+	options.External[0].Path = debPath
+	_, err = archive.OpenLocal(options)
+	c.Assert(err, ErrorMatches, `cannot use package "mypkg": expected digest a+, got `+hexDigest)
+
+	// Package name mismatch.
+	options.External[0].Name = "other"
+	options.External[0].SHA256 = hexDigest
+	_, err = archive.OpenLocal(options)
+	c.Assert(err, ErrorMatches, `cannot use package "other": mypkg.deb contains package "mypkg"`)
+
+	// Paths take precedence over external packages.
+	options.External[0].Name = "mypkg"
+	options.External[0].Path = "/non-existent.deb"
+	options.Paths = []string{debPath}
+	_, err = archive.OpenLocal(options)
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AutoMirrors is the entry of Options.Mirrors standing for the mirrors which
+// mirrors.ubuntu.com suggests for the location of the host.
+const AutoMirrors = "auto"
+
+const ubuntuMirrorsURL = "http://mirrors.ubuntu.com/mirrors.txt"
+
+// mirrorProbeTimeout limits how long a mirror may take to respond to be
+// considered at all, so that unreachable mirrors do not hold the selection.
+const mirrorProbeTimeout = 5 * time.Second
+
+// ValidateMirror returns an error if mirror is neither AutoMirrors nor the
+// base URL of an archive, such as "http://mirror.example.com/ubuntu/".
+func ValidateMirror(mirror string) error {
+	if mirror == AutoMirrors {
+		return nil
+	}
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid mirror: %q", mirror)
+	}
+	return nil
+}
+
+// Mirror returns the base URL of the mirror selected for the archive, or ""
+// if the archive is fetched from its default location.
+func Mirror(a Archive) string {
+	ubuntu, ok := a.(*ubuntuArchive)
+	if !ok {
+		return ""
+	}
+	return ubuntu.mirror
+}
+
+type mirrorProbe struct {
+	url     string
+	latency time.Duration
+	err     error
+}
+
+// selectMirror returns the base URL among the default one and the mirrors
+// in the options which responds the fastest to a request for the InRelease
+// file of the first suite. The default URL is returned if no mirror responds.
+func selectMirror(options *Options, defaultURL string) string {
+	candidates := []string{defaultURL}
+	addCandidate := func(mirror string) {
+		if !strings.HasSuffix(mirror, "/") {
+			mirror += "/"
+		}
+		if !slices.Contains(candidates, mirror) {
+			candidates = append(candidates, mirror)
+		}
+	}
+	for _, mirror := range options.Mirrors {
+		if mirror != AutoMirrors {
+			addCandidate(mirror)
+			continue
+		}
+		if defaultURL != ubuntuURL {
+			logf("Archive %q ignores %q mirrors: only listed for the main Ubuntu archive", options.Label, AutoMirrors)
+			continue
+		}
+		mirrors, err := fetchAutoMirrors(options)
+		if err != nil {
+			logf("Archive %q ignores %q mirrors: %v", options.Label, AutoMirrors, err)
+			continue
+		}
+		for _, mirror := range mirrors {
+			addCandidate(mirror)
+		}
+	}
+	if len(candidates) == 1 {
+		return defaultURL
+	}
+
+	probes := make([]mirrorProbe, len(candidates))
+	done := make(chan struct{})
+	for i, candidate := range candidates {
+		go func() {
+			latency, err := probeMirror(options, candidate)
+			probes[i] = mirrorProbe{url: candidate, latency: latency, err: err}
+			done <- struct{}{}
+		}()
+	}
+	for range candidates {
+		<-done
+	}
+
+	var best *mirrorProbe
+	for i := range probes {
+		probe := &probes[i]
+		if probe.err != nil {
+			logf("Mirror %s not used: %v", probe.url, probe.err)
+			continue
+		}
+		if best == nil || probe.latency < best.latency {
+			best = probe
+		}
+	}
+	if best == nil {
+		return defaultURL
+	}
+	logf("Archive %q using mirror %s (%dms)", options.Label, best.url, best.latency.Milliseconds())
+	return best.url
+}
+
+// probeMirror returns how long the mirror takes to start responding to a
+// request for the InRelease file of the first suite of the archive.
+func probeMirror(options *Options, baseURL string) (time.Duration, error) {
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", baseURL+"dists/"+options.Suites[0]+"/InRelease", nil)
+	if err != nil {
+		return 0, err
+	}
+	options.HTTP.apply(req)
+	start := time.Now()
+	resp, err := httpDo(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// fetchAutoMirrors returns the mirrors which mirrors.ubuntu.com suggests
+// for the location of the host, one per line.
+func fetchAutoMirrors(options *Options) ([]string, error) {
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ubuntuMirrorsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	options.HTTP.apply(req)
+	resp, err := httpDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch mirror list: status %d", resp.StatusCode)
+	}
+	var mirrors []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && ValidateMirror(line) == nil && line != AutoMirrors {
+			mirrors = append(mirrors, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read mirror list: %v", err)
+	}
+	return mirrors, nil
+}
@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/chisel/internal/control"
+)
+
+// errNoPatches reports that an index cannot be patched, because no earlier
+// version of it is known or the archive does not provide patches for it.
+var errNoPatches = fmt.Errorf("no index patches available")
+
+// fetchPatched obtains the Packages file at packagesPath with the given
+// digest by applying the patches listed in its Packages.diff/Index file to
+// the version fetched earlier, as apt does.
+func (index *ubuntuIndex) fetchPatched(packagesPath, digest string) (io.ReadSeekCloser, error) {
+	previous := index.archive.readCachedIndex(index.packagesURL(packagesPath))
+	if previous == nil {
+		return nil, errNoPatches
+	}
+	diffPath := packagesPath + ".diff/Index"
+	diffDigest, _, _ := control.ParsePathInfo(index.release.Get("SHA256"), diffPath)
+	if diffDigest == "" {
+		return nil, errNoPatches
+	}
+	reader, err := index.fetch(diffPath, diffDigest, fetchIndex)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	patches, err := parseDiffIndex(data, previous.SHA256, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := index.archive.cache.Read(previous.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		reader, err := index.fetch(packagesPath+".diff/"+patch.name+".gz", patch.digest, fetchBulk)
+		if err != nil {
+			return nil, err
+		}
+		script, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		content, err = applyEdScript(content, script)
+		if err != nil {
+			return nil, fmt.Errorf("cannot apply patch %s: %w", patch.name, err)
+		}
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf("patched index has unexpected digest")
+	}
+	err = index.archive.cache.Write(digest, content)
+	if err != nil {
+		return nil, err
+	}
+	return index.archive.cache.Open(digest)
+}
+
+type diffPatch struct {
+	name   string
+	digest string
+}
+
+// parseDiffIndex returns the patches listed in the Packages.diff/Index
+// content which lead from the version of the index with digest from to the
+// one with digest to, in the order they must be applied.
+func parseDiffIndex(data []byte, from, to string) ([]diffPatch, error) {
+	ctrl, err := control.ParseString("Diff-Index", "Diff-Index: index\n"+string(data))
+	if err != nil {
+		return nil, err
+	}
+	section := ctrl.Section("index")
+	current := strings.Fields(section.Get("SHA256-Current"))
+	if len(current) == 0 || current[0] != to {
+		return nil, errNoPatches
+	}
+	patchDigests := make(map[string]string)
+	for _, line := range strings.Split(section.Get("SHA256-Patches"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			patchDigests[fields[2]] = fields[0]
+		}
+	}
+	var patches []diffPatch
+	for _, line := range strings.Split(section.Get("SHA256-History"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		// Each entry in the history holds the digest of the index before
+		// the patch with the same name is applied.
+		if len(patches) == 0 && fields[0] != from {
+			continue
+		}
+		patchDigest, ok := patchDigests[fields[2]]
+		if !ok {
+			return nil, fmt.Errorf("index patch %s has no digest", fields[2])
+		}
+		patches = append(patches, diffPatch{name: fields[2], digest: patchDigest})
+	}
+	if len(patches) == 0 {
+		return nil, errNoPatches
+	}
+	if section.Get("X-Patch-Precedence") == "merged" {
+		// Merged patches lead straight to the current version.
+		patches = patches[:1]
+	}
+	return patches, nil
+}
+
+var edCommandExp = regexp.MustCompile(`^([0-9]+)(?:,([0-9]+))?([acd])$`)
+
+// applyEdScript applies to content the ed script produced by "diff --ed",
+// which has its commands sorted from the last line to the first, so that
+// the line numbers of each command are not affected by the earlier ones.
+func applyEdScript(content, script []byte) ([]byte, error) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	commands := bytes.SplitAfter(script, []byte("\n"))
+	for i := 0; i < len(commands); i++ {
+		command := string(bytes.TrimSuffix(commands[i], []byte("\n")))
+		if command == "" {
+			continue
+		}
+		match := edCommandExp.FindStringSubmatch(command)
+		if match == nil {
+			return nil, fmt.Errorf("unsupported command: %q", command)
+		}
+		start, _ := strconv.Atoi(match[1])
+		end := start
+		if match[2] != "" {
+			end, _ = strconv.Atoi(match[2])
+		}
+		var text [][]byte
+		if match[3] != "d" {
+			for i++; ; i++ {
+				if i == len(commands) {
+					return nil, fmt.Errorf("unterminated text for command: %q", command)
+				}
+				if string(commands[i]) == ".\n" {
+					break
+				}
+				text = append(text, commands[i])
+			}
+		}
+		switch match[3] {
+		case "a":
+			if start > len(lines) {
+				return nil, fmt.Errorf("line %d out of range", start)
+			}
+			lines = append(lines[:start], append(text, lines[start:]...)...)
+		case "c", "d":
+			if start < 1 || end < start || end > len(lines) {
+				return nil, fmt.Errorf("lines %d,%d out of range", start, end)
+			}
+			lines = append(lines[:start-1], append(text, lines[end:]...)...)
+		}
+	}
+	return bytes.Join(lines, nil), nil
+}
@@ -0,0 +1,53 @@
+package archive_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+)
+
+var edScriptTests = []struct {
+	summary string
+	content string
+	script  string
+	result  string
+	error   string
+}{{
+	summary: "Commands are applied from the last line to the first",
+	content: "a\nb\nc\nd\ne\n",
+	script:  "5c\nE\n.\n3,4d\n1a\nA1\nA2\n.\n0a\nstart\n.\n",
+	result:  "start\na\nA1\nA2\nb\nE\n",
+}, {
+	summary: "Changes may replace ranges",
+	content: "a\nb\nc\n",
+	script:  "1,2c\nx\n.\n",
+	result:  "x\nc\n",
+}, {
+	summary: "Unsupported commands",
+	content: "a\n",
+	script:  "s/.//\n",
+	error:   `unsupported command: "s/.//"`,
+}, {
+	summary: "Unterminated text",
+	content: "a\n",
+	script:  "1a\nb\n",
+	error:   `unterminated text for command: "1a"`,
+}, {
+	summary: "Lines out of range",
+	content: "a\n",
+	script:  "2,3d\n",
+	error:   `lines 2,3 out of range`,
+}}
+
+func (s *S) TestApplyEdScript(c *C) {
+	for _, test := range edScriptTests {
+		c.Logf("Summary: %s", test.summary)
+		result, err := archive.ApplyEdScript([]byte(test.content), []byte(test.script))
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(string(result), Equals, test.result)
+	}
+}
@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// Relation is an entry of the fields relating a package to other packages,
+// such as Conflicts, in the format "<name> [(<op> <version>)]".
+type Relation struct {
+	Name string
+	// Op and Version restrict the versions of the package the relation
+	// applies to, when set. Op is one of <<, <=, =, >= and >>.
+	Op      string
+	Version string
+}
+
+var relationExp = regexp.MustCompile(`^([a-z0-9][a-z0-9+.-]*)(?::[a-z0-9-]+)?\s*(?:\(\s*(<<|<=|=|>=|>>)\s*([^\s)]+)\s*\))?$`)
+
+// parseRelations parses a relationship field of the control data of a
+// package. Alternatives are split into separate relations, and malformed
+// entries are ignored.
+func parseRelations(field string) []Relation {
+	var relations []Relation
+	for _, entry := range strings.Split(field, ",") {
+		for _, alternative := range strings.Split(entry, "|") {
+			m := relationExp.FindStringSubmatch(strings.TrimSpace(alternative))
+			if m == nil {
+				continue
+			}
+			relations = append(relations, Relation{Name: m[1], Op: m[2], Version: m[3]})
+		}
+	}
+	return relations
+}
+
+// Matches returns whether the relation applies to the given version of the
+// named package.
+func (r Relation) Matches(name, version string) bool {
+	if r.Name != name {
+		return false
+	}
+	if r.Op == "" {
+		return true
+	}
+	cmp := deb.CompareVersions(version, r.Version)
+	switch r.Op {
+	case "<<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "=":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case ">>":
+		return cmp > 0
+	}
+	return false
+}
+
+func (r Relation) String() string {
+	if r.Op == "" {
+		return r.Name
+	}
+	return r.Name + " (" + r.Op + " " + r.Version + ")"
+}
@@ -0,0 +1,155 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+// fetchResumable fetches the package at suffix, keeping the content
+// downloaded so far in the cache when the download is interrupted, so that
+// the next fetch of the same package continues from where it stopped.
+func (index *ubuntuIndex) fetchResumable(suffix, digest string) (io.ReadSeekCloser, error) {
+	return index.fetchPartial(suffix, digest, func(partial *os.File) error {
+		return index.downloadPartial(suffix, partial)
+	})
+}
+
+// fetchPartial fetches the package at suffix with download, which
+// completes the partial file of the package kept in the cache. The partial
+// file is locked while in use, so that processes sharing the cache wait for
+// each other instead of writing to it at once, and it is only removed once
+// the package is in the cache.
+func (index *ubuntuIndex) fetchPartial(suffix, digest string, download func(partial *os.File) error) (io.ReadSeekCloser, error) {
+	reader, err := index.archive.cache.Open(digest)
+	if err == nil {
+		return reader, nil
+	} else if err != cache.MissErr {
+		return nil, err
+	}
+	if index.archive.cache.Dir == "" || digest == "" {
+		return index.fetch(suffix, digest, fetchBulk)
+	}
+
+	partialPath := filepath.Join(index.archive.cache.Dir, "partial", digest)
+	lockPath := partialPath + ".lock"
+	ctx := index.archive.options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	unlock, err := fsutil.Lock(ctx, lockPath, "download of "+path.Base(suffix))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// The package may have been fetched by the process holding the lock.
+	reader, err = index.archive.cache.Open(digest)
+	if err == nil {
+		return reader, nil
+	} else if err != cache.MissErr {
+		return nil, err
+	}
+
+	partial, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer partial.Close()
+
+	err = download(partial)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = partial.Seek(0, io.SeekStart)
+	if err == nil {
+		writer := index.archive.cache.Create(digest)
+		_, err = io.Copy(writer, partial)
+		if err == nil {
+			err = writer.Close()
+		} else {
+			writer.Close()
+		}
+	}
+	// Content which does not match the digest is not worth resuming.
+	os.Remove(partialPath)
+	if err != nil {
+		return nil, &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+	}
+	// Processes waiting for the lock find the package in the cache once
+	// they take it, so the lock file is not needed anymore.
+	os.Remove(lockPath)
+	return index.archive.cache.Open(digest)
+}
+
+// downloadPartial appends to the partial file the rest of the file at
+// suffix, or downloads it again from the start if the archive does not
+// support continuing it.
+func (index *ubuntuIndex) downloadPartial(suffix string, partial *os.File) error {
+	offset, err := partial.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := index.requestContext(fetchBulk)
+	defer cancel()
+	for {
+		req, err := index.newRequest(ctx, index.url(suffix))
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			logf("Resuming %s after %d bytes...", strings.TrimPrefix(suffix, "../../"), offset)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err := bulkDo(req)
+		if err != nil {
+			return &FetchError{fmt.Errorf("cannot talk to archive: %v", err)}
+		}
+		countDownload(resp)
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+				resp.Body.Close()
+				return &FetchError{fmt.Errorf("error from archive: unexpected content range %q", resp.Header.Get("Content-Range"))}
+			}
+		case http.StatusOK:
+			offset = 0
+		case http.StatusRequestedRangeNotSatisfiable:
+			// The partial content is not part of the file, start over.
+			resp.Body.Close()
+			if offset == 0 {
+				return index.responseError(resp)
+			}
+			offset = 0
+			err = partial.Truncate(0)
+			if err != nil {
+				return err
+			}
+			continue
+		default:
+			resp.Body.Close()
+			return index.responseError(resp)
+		}
+		err = partial.Truncate(offset)
+		if err == nil {
+			_, err = partial.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			_, err = io.Copy(partial, resp.Body)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return &FetchError{fmt.Errorf("cannot fetch from archive: %v", err)}
+		}
+		return nil
+	}
+}
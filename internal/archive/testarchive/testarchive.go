@@ -3,7 +3,9 @@ package testarchive
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/md5"
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
 	"path"
 	"strings"
@@ -63,6 +65,15 @@ type Package struct {
 	Arch      string
 	Component string
 	Data      []byte
+	// SHA512 lists the SHA512 digest of the package as well.
+	SHA512 bool
+	// MD5Only lists the MD5 digest of the package instead of SHA256.
+	MD5Only bool
+	// Source is the value of the Source field, if any.
+	Source string
+	// Fields holds additional fields of the package, such as
+	// "Conflicts: foo", one per line.
+	Fields string
 }
 
 func (p *Package) Path() string {
@@ -86,14 +97,31 @@ func (p *Package) Section() []byte {
 		Installed-Size: 10
 		Filename: %s
 		Size: %d
-		SHA256: %s
+		%s
 		Description: Description of %s
 		Task: minimal
 
-	`)), p.Name, p.Arch, p.Version, p.Path(), len(content), makeSha256(content), p.Name)
+	`)), p.Name, p.Arch, p.Version, p.Path(), len(content), p.digests(content), p.Name)
+	if p.Source != "" {
+		section = "Source: " + p.Source + "\n" + section
+	}
+	if p.Fields != "" {
+		section = strings.TrimSuffix(p.Fields, "\n") + "\n" + section
+	}
 	return []byte(section)
 }
 
+func (p *Package) digests(content []byte) string {
+	if p.MD5Only {
+		return fmt.Sprintf("MD5sum: %x", md5.Sum(content))
+	}
+	digests := "SHA256: " + makeSha256(content)
+	if p.SHA512 {
+		digests += "\nSHA512: " + makeSha512(content)
+	}
+	return digests
+}
+
 func (p *Package) Content() []byte {
 	if len(p.Data) == 0 {
 		return []byte(p.Name + " " + p.Version + " data")
@@ -107,6 +135,10 @@ type Release struct {
 	Label   string
 	Items   []Item
 	PrivKey *packet.PrivateKey
+	// AcquireByHash makes the indexes available by hash as well.
+	AcquireByHash bool
+	// SHA512 lists the SHA512 digests of the indexes as well.
+	SHA512 bool
 }
 
 func (r *Release) Walk(f func(Item) error) error {
@@ -127,6 +159,17 @@ func (r *Release) Content() []byte {
 		content := item.Content()
 		digests.WriteString(fmt.Sprintf(" %s  %d  %s\n", makeSha256(content), len(content), item.Path()))
 	}
+	if r.SHA512 {
+		digests.WriteString("SHA512:\n")
+		for _, item := range r.Items {
+			content := item.Content()
+			digests.WriteString(fmt.Sprintf(" %s  %d  %s\n", makeSha512(content), len(content), item.Path()))
+		}
+	}
+	var byHash string
+	if r.AcquireByHash {
+		byHash = "Acquire-By-Hash: yes\n"
+	}
 	content := fmt.Sprintf(string(testutil.Reindent(`
 		Origin: Ubuntu
 		Label: %s
@@ -137,9 +180,9 @@ func (r *Release) Content() []byte {
 		Architectures: amd64 arm64 armhf i386 ppc64el riscv64 s390x
 		Components: main restricted universe multiverse
 		Description: Ubuntu %s
-		SHA256:
+		%sSHA256:
 		%s
-	`)), r.Label, r.Suite, r.Version, r.Version, digests.String())
+	`)), r.Label, r.Suite, r.Version, r.Version, byHash, digests.String())
 
 	var buf bytes.Buffer
 	writer, err := clearsign.Encode(&buf, r.PrivKey, nil)
@@ -164,6 +207,10 @@ func (r *Release) Render(prefix string, content map[string][]byte) error {
 			itemPath = path.Join(prefix, itemPath)
 		} else {
 			itemPath = path.Join(prefix, "dists", r.Suite, itemPath)
+			if r.AcquireByHash {
+				hashPath := path.Join(path.Dir(itemPath), "by-hash/SHA256", makeSha256(item.Content()))
+				content[hashPath] = item.Content()
+			}
 		}
 		content[itemPath] = item.Content()
 		return nil
@@ -200,10 +247,36 @@ func (pi *PackageIndex) Content() []byte {
 	return MergeSections(pi.Packages)
 }
 
+// File is an arbitrary file in the archive, such as an index patch.
+type File struct {
+	Name string
+	Data []byte
+}
+
+func (f *File) Path() string {
+	return f.Name
+}
+
+func (f *File) Walk(fn func(Item) error) error {
+	return CallWalkFunc(f, fn)
+}
+
+func (f *File) Section() []byte {
+	return nil
+}
+
+func (f *File) Content() []byte {
+	return f.Data
+}
+
 func makeSha256(b []byte) string {
 	return fmt.Sprintf("%x", sha256.Sum256(b))
 }
 
+func makeSha512(b []byte) string {
+	return fmt.Sprintf("%x", sha512.Sum512(b))
+}
+
 func makeGzip(b []byte) []byte {
 	var buf bytes.Buffer
 	gz := gzip.NewWriter(&buf)
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/canonical/chisel/internal/control"
+)
+
+const (
+	DigestSHA256 = "sha256"
+	DigestSHA512 = "sha512"
+)
+
+// VerifyOptions holds the policy applied when verifying the content fetched
+// from an archive, on top of the signature of its InRelease file. Content
+// which does not comply with it is reported with a VerifyError.
+type VerifyOptions struct {
+	// MinDigest is the weakest digest algorithm accepted for the indexes
+	// and the packages, either DigestSHA256, the default, or DigestSHA512.
+	MinDigest string
+	// RequireByHash fails unless the archive supports fetching the indexes
+	// by hash, and fetches them only that way.
+	RequireByHash bool
+	// RejectMD5Only fails to fetch the packages whose entries in the index
+	// only hold MD5 digests, which are otherwise fetched unverified.
+	RejectMD5Only bool
+}
+
+// ValidateDigest returns an error if the digest algorithm is unknown.
+func ValidateDigest(digest string) error {
+	switch digest {
+	case "", DigestSHA256, DigestSHA512:
+		return nil
+	}
+	return fmt.Errorf("invalid digest algorithm: %q", digest)
+}
+
+func (v *VerifyOptions) wantSHA512() bool {
+	return v.MinDigest == DigestSHA512
+}
+
+// checkRelease verifies that the InRelease section lists the digests and
+// features the policy requires.
+func (index *ubuntuIndex) checkRelease() error {
+	verify := &index.archive.options.Verify
+	if verify.RequireByHash && index.release.Get("Acquire-By-Hash") != "yes" {
+		return &VerifyError{fmt.Errorf("archive %s suite does not support fetching indexes by hash", index.suite)}
+	}
+	if verify.wantSHA512() && index.release.Get("SHA512") == "" {
+		return &VerifyError{fmt.Errorf("archive %s suite has no SHA512 digests", index.suite)}
+	}
+	return nil
+}
+
+// checkIndex verifies the Packages file at packagesPath, read from reader,
+// against the stronger digests the policy requires, if any.
+func (index *ubuntuIndex) checkIndex(packagesPath string, reader io.ReadSeeker) error {
+	if !index.archive.options.Verify.wantSHA512() {
+		return nil
+	}
+	digest, _, _ := control.ParsePathInfo(index.release.Get("SHA512"), packagesPath)
+	if digest == "" {
+		return &VerifyError{fmt.Errorf("%s is missing from %s %s component SHA512 digests", packagesPath, index.suite, index.component)}
+	}
+	err := checkSHA512(reader, digest)
+	if err != nil {
+		return &VerifyError{fmt.Errorf("cannot verify %s: %w", packagesPath, err)}
+	}
+	return nil
+}
+
+// checkPackage verifies the content of the package, read from reader,
+// against the stronger digests the policy requires, if any.
+func (index *ubuntuIndex) checkPackage(section control.Section, reader io.ReadSeeker) error {
+	if !index.archive.options.Verify.wantSHA512() {
+		return nil
+	}
+	err := checkSHA512(reader, section.Get("SHA512"))
+	if err != nil {
+		return &VerifyError{fmt.Errorf("cannot verify package %q: %w", section.Get("Package"), err)}
+	}
+	return nil
+}
+
+// checkPackageDigests verifies that the index entry of the package holds
+// the digests the policy requires, before the package is fetched.
+func (index *ubuntuIndex) checkPackageDigests(section control.Section) error {
+	verify := &index.archive.options.Verify
+	pkg := section.Get("Package")
+	if verify.wantSHA512() && section.Get("SHA512") == "" {
+		return &VerifyError{fmt.Errorf("package %q has no SHA512 digest", pkg)}
+	}
+	if section.Get("SHA256") == "" && section.Get("SHA512") == "" {
+		if verify.RejectMD5Only {
+			return &VerifyError{fmt.Errorf("package %q has only MD5 digests", pkg)}
+		}
+		logf("Warning: package %q has only MD5 digests, fetching unverified", pkg)
+	}
+	return nil
+}
+
+// checkSHA512 verifies that the content of reader has the given SHA512
+// digest, leaving reader at the start of the content.
+func checkSHA512(reader io.ReadSeeker, digest string) error {
+	_, err := reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	hash := sha512.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(hash.Sum(nil))
+	if got != digest {
+		return fmt.Errorf("expected SHA512 digest %s, got %s", digest, got)
+	}
+	return nil
+}
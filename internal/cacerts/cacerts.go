@@ -0,0 +1,251 @@
+// Package cacerts implements the generation of the certificate bundle and
+// hashed symlinks which update-ca-certificates would otherwise create.
+package cacerts
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// BundleName is the name of the file holding all certificates concatenated.
+const BundleName = "ca-certificates.crt"
+
+// SourceDirs lists the directories, relative to the root, where certificates
+// are looked up.
+var SourceDirs = []string{
+	"/usr/share/ca-certificates/",
+	"/usr/local/share/ca-certificates/",
+}
+
+// Cert is a certificate found under one of the SourceDirs.
+type Cert struct {
+	// Path is relative to the root.
+	Path string
+	// Hash is the OpenSSL subject name hash of the certificate.
+	Hash uint32
+	Data []byte
+}
+
+// Find returns the certificates with a .crt extension found under
+// SourceDirs in rootDir, ordered by path.
+func Find(rootDir string) ([]*Cert, error) {
+	var certs []*Cert
+	for _, dir := range SourceDirs {
+		absDir := filepath.Join(rootDir, dir)
+		err := filepath.WalkDir(absDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == absDir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".crt") {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			relPath := "/" + filepath.ToSlash(strings.TrimPrefix(path, filepath.Clean(rootDir)+string(filepath.Separator)))
+			hash, err := pemSubjectHash(data)
+			if err != nil {
+				return fmt.Errorf("cannot parse certificate %s: %w", relPath, err)
+			}
+			certs = append(certs, &Cert{Path: relPath, Hash: hash, Data: data})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(certs, func(a, b *Cert) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return certs, nil
+}
+
+// Bundle returns the concatenation of the certificates, ensuring each one of
+// them ends in a newline.
+func Bundle(certs []*Cert) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		buf.Write(cert.Data)
+		if len(cert.Data) > 0 && cert.Data[len(cert.Data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// Links returns a map from the names of the symlinks which OpenSSL uses to
+// look certificates up ("<hash>.<n>") to the names of the .pem symlinks
+// pointing to the certificates, as update-ca-certificates does.
+func Links(certs []*Cert) map[string]string {
+	links := make(map[string]string)
+	counts := make(map[uint32]int)
+	for _, cert := range certs {
+		pemName := PemName(cert)
+		n := counts[cert.Hash]
+		counts[cert.Hash] = n + 1
+		links[fmt.Sprintf("%08x.%d", cert.Hash, n)] = pemName
+	}
+	return links
+}
+
+// PemName returns the name of the .pem symlink pointing to the certificate.
+func PemName(cert *Cert) string {
+	return strings.TrimSuffix(filepath.Base(cert.Path), ".crt") + ".pem"
+}
+
+func pemSubjectHash(data []byte) (uint32, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return 0, fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return 0, err
+	}
+	return SubjectHash(cert.RawSubject)
+}
+
+// SubjectHash returns the hash of the DER encoded subject name, compatible
+// with the one computed by "openssl x509 -subject_hash".
+func SubjectHash(rawSubject []byte) (uint32, error) {
+	canon, err := canonicalName(rawSubject)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha1.Sum(canon)
+	return uint32(sum[0]) | uint32(sum[1])<<8 | uint32(sum[2])<<16 | uint32(sum[3])<<24, nil
+}
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// canonicalName returns the canonical encoding of the name used by OpenSSL
+// for hashing: string values are converted to lowercase UTF8String with
+// whitespace normalized, and the outer SEQUENCE is omitted.
+func canonicalName(rawName []byte) ([]byte, error) {
+	var rdns []asn1.RawValue
+	rest, err := asn1.Unmarshal(rawName, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after name")
+	}
+	var canon []byte
+	for _, rdn := range rdns {
+		var atvs []attributeTypeAndValue
+		_, err := asn1.UnmarshalWithParams(rdn.FullBytes, &atvs, "set")
+		if err != nil {
+			return nil, err
+		}
+		var encoded [][]byte
+		for _, atv := range atvs {
+			if value, ok := decodeString(&atv.Value); ok {
+				atv.Value = asn1.RawValue{
+					Class: asn1.ClassUniversal,
+					Tag:   asn1.TagUTF8String,
+					Bytes: []byte(canonicalString(value)),
+				}
+			}
+			data, err := asn1.Marshal(atv)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, data)
+		}
+		// DER sorts the elements of a SET OF by their encoding.
+		slices.SortFunc(encoded, bytes.Compare)
+		set, err := asn1.Marshal(asn1.RawValue{
+			Class:      asn1.ClassUniversal,
+			Tag:        asn1.TagSet,
+			IsCompound: true,
+			Bytes:      bytes.Join(encoded, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		canon = append(canon, set...)
+	}
+	return canon, nil
+}
+
+const (
+	tagT61String       = 20
+	tagUniversalString = 28
+	tagBMPString       = 30
+)
+
+func decodeString(value *asn1.RawValue) (string, bool) {
+	if value.Class != asn1.ClassUniversal {
+		return "", false
+	}
+	data := value.Bytes
+	switch value.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, 26 /* VisibleString */ :
+		return string(data), true
+	case tagT61String:
+		// Treated as Latin-1, as OpenSSL does.
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), true
+	case tagBMPString:
+		if len(data)%2 != 0 {
+			return "", false
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+		return string(utf16.Decode(units)), true
+	case tagUniversalString:
+		if len(data)%4 != 0 {
+			return "", false
+		}
+		runes := make([]rune, len(data)/4)
+		for i := range runes {
+			runes[i] = rune(data[4*i])<<24 | rune(data[4*i+1])<<16 | rune(data[4*i+2])<<8 | rune(data[4*i+3])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// canonicalString strips leading and trailing whitespace, collapses internal
+// whitespace into a single space, and lowercases ASCII letters.
+func canonicalString(s string) string {
+	var buf strings.Builder
+	space := false
+	for _, r := range strings.Trim(s, " \t\n\v\f\r") {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\v' || r == '\f' || r == '\r':
+			space = true
+			continue
+		case r >= 'A' && r <= 'Z':
+			r += 'a' - 'A'
+		}
+		if space {
+			buf.WriteByte(' ')
+			space = false
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
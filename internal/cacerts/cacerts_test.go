@@ -0,0 +1,78 @@
+package cacerts_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/cacerts"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestSubjectHash(c *C) {
+	for name, testCert := range testutil.TestCerts {
+		c.Logf("Certificate: %s", name)
+		block, _ := pem.Decode([]byte(testCert.PEM))
+		c.Assert(block, NotNil)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		c.Assert(err, IsNil)
+		hash, err := cacerts.SubjectHash(cert.RawSubject)
+		c.Assert(err, IsNil)
+		c.Assert(fmt.Sprintf("%08x", hash), Equals, testCert.Hash)
+	}
+}
+
+func (s *S) TestFind(c *C) {
+	rootDir := c.MkDir()
+	files := map[string]string{
+		"/usr/share/ca-certificates/mozilla/Root_1.crt": testutil.TestCerts["root1"].PEM,
+		"/usr/local/share/ca-certificates/root2.crt":    testutil.TestCerts["root2"].PEM,
+		"/usr/share/ca-certificates/README":             "not a certificate",
+	}
+	for path, data := range files {
+		fpath := filepath.Join(rootDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	certs, err := cacerts.Find(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(certs, HasLen, 2)
+	c.Assert(certs[0].Path, Equals, "/usr/local/share/ca-certificates/root2.crt")
+	c.Assert(certs[1].Path, Equals, "/usr/share/ca-certificates/mozilla/Root_1.crt")
+
+	c.Assert(string(cacerts.Bundle(certs)), Equals, testutil.TestCerts["root2"].PEM+testutil.TestCerts["root1"].PEM)
+	c.Assert(cacerts.Links(certs), DeepEquals, map[string]string{
+		testutil.TestCerts["root1"].Hash + ".0": "Root_1.pem",
+		testutil.TestCerts["root2"].Hash + ".0": "root2.pem",
+	})
+}
+
+func (s *S) TestLinksCollision(c *C) {
+	certs := []*cacerts.Cert{
+		{Path: "/usr/share/ca-certificates/a.crt", Hash: 0x1234},
+		{Path: "/usr/share/ca-certificates/b.crt", Hash: 0x1234},
+	}
+	c.Assert(cacerts.Links(certs), DeepEquals, map[string]string{
+		"00001234.0": "a.pem",
+		"00001234.1": "b.pem",
+	})
+}
+
+func (s *S) TestFindInvalidCertificate(c *C) {
+	rootDir := c.MkDir()
+	fpath := filepath.Join(rootDir, "/usr/share/ca-certificates/bad.crt")
+	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(fpath, []byte("bad"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = cacerts.Find(rootDir)
+	c.Assert(err, ErrorMatches, `cannot parse certificate /usr/share/ca-certificates/bad.crt: no PEM certificate found`)
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/canonical/chisel/internal/metrics"
 )
 
 func DefaultDir(suffix string) string {
@@ -105,12 +107,9 @@ func (c *Cache) Create(digest string) *Writer {
 	if err != nil {
 		return &Writer{err: fmt.Errorf("cannot create cache directory: %v", err)}
 	}
-	var file *os.File
-	if digest == "" {
-		file, err = os.CreateTemp(c.filePath(""), "tmp.*")
-	} else {
-		file, err = os.Create(c.filePath(digest + ".tmp"))
-	}
+	// Each writer has its own temporary file, which is renamed into place
+	// once complete, so that concurrent processes may share the cache.
+	file, err := os.CreateTemp(c.filePath(""), "tmp.*")
 	if err != nil {
 		return &Writer{err: fmt.Errorf("cannot create cache file: %v", err)}
 	}
@@ -139,6 +138,7 @@ func (c *Cache) Open(digest string) (io.ReadSeekCloser, error) {
 	filePath := c.filePath(digest)
 	file, err := os.Open(filePath)
 	if os.IsNotExist(err) {
+		metrics.CacheMisses.Add(1)
 		return nil, MissErr
 	} else if err != nil {
 		return nil, fmt.Errorf("cannot open cache file: %v", err)
@@ -148,9 +148,31 @@ func (c *Cache) Open(digest string) (io.ReadSeekCloser, error) {
 	if err := os.Chtimes(filePath, now, now); err != nil {
 		return nil, fmt.Errorf("cannot update cached file timestamp: %v", err)
 	}
+	metrics.CacheHits.Add(1)
 	return file, nil
 }
 
+// Path returns the path of the file with the given digest in the cache, or
+// MissErr if it is not there. The file is shared and must not be modified.
+func (c *Cache) Path(digest string) (string, error) {
+	if c.Dir == "" || digest == "" {
+		return "", MissErr
+	}
+	filePath := c.filePath(digest)
+	_, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return "", MissErr
+	} else if err != nil {
+		return "", fmt.Errorf("cannot open cache file: %v", err)
+	}
+	// Use mtime as last reuse time.
+	now := time.Now()
+	if err := os.Chtimes(filePath, now, now); err != nil {
+		return "", fmt.Errorf("cannot update cached file timestamp: %v", err)
+	}
+	return filePath, nil
+}
+
 func (c *Cache) Read(digest string) ([]byte, error) {
 	file, err := c.Open(digest)
 	if err != nil {
@@ -151,3 +151,20 @@ func (s *S) TestCacheOpen(c *C) {
 
 	c.Assert(string(data1), Equals, "data1")
 }
+
+func (s *S) TestCachePath(c *C) {
+	cc := cache.Cache{Dir: c.MkDir()}
+
+	_, err := cc.Path(data1Digest)
+	c.Assert(err, Equals, cache.MissErr)
+
+	err = cc.Write(data1Digest, []byte("data1"))
+	c.Assert(err, IsNil)
+
+	path, err := cc.Path(data1Digest)
+	c.Assert(err, IsNil)
+	c.Assert(path, Equals, filepath.Join(cc.Dir, "sha256", data1Digest))
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data1")
+}
@@ -1,6 +1,7 @@
 package control
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"strings"
@@ -142,3 +143,79 @@ func ParseString(sectionKey, content string) (File, error) {
 		sectionKey: sectionKey,
 	}, nil
 }
+
+type readerAtFile struct {
+	content  io.ReaderAt
+	sections map[string]ctrlPos
+}
+
+func (f *readerAtFile) Section(key string) Section {
+	pos, ok := f.sections[key]
+	if !ok {
+		return nil
+	}
+	data := make([]byte, pos.end-pos.start)
+	_, err := f.content.ReadAt(data, int64(pos.start))
+	if err != nil && err != io.EOF {
+		// The content was indexed successfully before, so this is not
+		// expected to happen.
+		return nil
+	}
+	return &ctrlSection{string(data)}
+}
+
+// ParseReaderAt scans the size bytes of content once to index its sections,
+// as ParseString does, but keeps in memory only their positions. Sections
+// are read from content on retrieval, so it must remain readable while the
+// returned File is used. This is appropriate for large files, such as the
+// package indexes of the universe component.
+func ParseReaderAt(sectionKey string, content io.ReaderAt, size int64) (File, error) {
+	skey := sectionKey + ": "
+	sections := make(map[string]ctrlPos)
+	reader := bufio.NewReaderSize(io.NewSectionReader(content, 0, size), 64*1024)
+	var name string
+	inSection := false
+	// Whether the line being read is longer than the buffer. Such lines
+	// cannot be section keys nor empty lines, so their content does not
+	// matter.
+	longLine := false
+	start := 0
+	pos := 0
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			pos += len(line)
+			longLine = true
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if longLine {
+			longLine = false
+		} else if inSection {
+			if len(line) == 1 && line[0] == '\n' {
+				sections[name] = ctrlPos{start, pos - 1}
+				inSection = false
+				start = pos + 1
+			}
+		} else if len(line) > len(skey) && string(line[:len(skey)]) == skey {
+			name = strings.TrimSuffix(string(line[len(skey):]), "\n")
+			inSection = true
+		}
+		pos += len(line)
+		if err == io.EOF {
+			break
+		}
+	}
+	if inSection {
+		sections[name] = ctrlPos{start, pos}
+	}
+	return &readerAtFile{
+		content:  content,
+		sections: sections,
+	}, nil
+}
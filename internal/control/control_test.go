@@ -5,6 +5,7 @@ import (
 
 	"bytes"
 	"os"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -114,3 +115,23 @@ func BenchmarkSectionGet(b *testing.B) {
 		}
 	}
 }
+
+func (s *S) TestParseReaderAt(c *C) {
+	file, err := control.ParseReaderAt("Section", bytes.NewReader([]byte(testFile)), int64(len(testFile)))
+	c.Assert(err, IsNil)
+
+	for skey, svalues := range testFileResults {
+		section := file.Section(skey)
+		for key, value := range svalues {
+			c.Assert(section.Get(key), Equals, value, Commentf("Section %q / Key %q", skey, key))
+		}
+	}
+	c.Assert(file.Section("five"), IsNil)
+
+	// Lines longer than the buffer used for reading are skipped over.
+	long := "Section: one\nLong: " + strings.Repeat("x", 100000) + "\n\nSection: two\nLine: line for two\n"
+	file, err = control.ParseReaderAt("Section", strings.NewReader(long), int64(len(long)))
+	c.Assert(err, IsNil)
+	c.Assert(file.Section("one").Get("Long"), HasLen, 100000)
+	c.Assert(file.Section("two").Get("Line"), Equals, "line for two")
+}
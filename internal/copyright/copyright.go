@@ -0,0 +1,91 @@
+// Package copyright implements looking up the licenses declared in the
+// copyright files shipped by Debian packages.
+package copyright
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// Path returns the location of the copyright file of the package.
+func Path(pkg string) string {
+	return "/usr/share/doc/" + pkg + "/copyright"
+}
+
+// Licenses returns the short names of the licenses declared in the
+// "License" fields of a copyright file in the machine-readable format
+// (DEP-5), sorted and without duplicates. License expressions combining
+// several licenses with "and" or "or" are split into their parts.
+//
+// Files which are not in the machine-readable format declare no licenses
+// that can be reliably parsed, and nil is returned for them.
+func Licenses(data []byte) []string {
+	var licenses []string
+	first := true
+	machineReadable := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if machineReadable {
+				first = false
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			// Continuation lines hold the license text.
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if first && name == "format" {
+			machineReadable = true
+			continue
+		}
+		if !machineReadable {
+			// The format must be declared in the header paragraph.
+			return nil
+		}
+		if name != "license" || value == "" {
+			continue
+		}
+		for _, license := range splitExpression(value) {
+			if !slices.Contains(licenses, license) {
+				licenses = append(licenses, license)
+			}
+		}
+	}
+	slices.Sort(licenses)
+	return licenses
+}
+
+// splitExpression splits a license expression such as "GPL-2+ or MIT"
+// into the licenses it refers to. Exceptions, as in "GPL-2+ with OpenSSL
+// exception", are kept along with the license.
+func splitExpression(expr string) []string {
+	var licenses []string
+	var current []string
+	for _, word := range strings.Fields(expr) {
+		word = strings.TrimSuffix(word, ",")
+		if word == "and" || word == "or" {
+			if len(current) > 0 {
+				licenses = append(licenses, strings.Join(current, " "))
+			}
+			current = nil
+			continue
+		}
+		if word != "" {
+			current = append(current, word)
+		}
+	}
+	if len(current) > 0 {
+		licenses = append(licenses, strings.Join(current, " "))
+	}
+	return licenses
+}
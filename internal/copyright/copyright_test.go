@@ -0,0 +1,71 @@
+package copyright_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/copyright"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var licensesTests = []struct {
+	summary  string
+	data     string
+	licenses []string
+}{{
+	summary: "Machine-readable file",
+	data: `
+		Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+		Upstream-Name: mypkg
+		Source: https://example.com/mypkg
+
+		Files: *
+		Copyright: 2020 Someone
+		License: MIT
+
+		Files: debian/*
+		Copyright: 2021 Someone Else
+		License: GPL-2+
+		 This program is free software; you can redistribute it
+		 .
+		 License: not a field
+
+		License: MIT
+		 Permission is hereby granted, free of charge, ...
+	`,
+	licenses: []string{"GPL-2+", "MIT"},
+}, {
+	summary: "License expressions",
+	data: `
+		Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+		Files: *
+		License: GPL-2+ or Artistic-1.0, and BSD-3-clause
+
+		Files: lib/*
+		License: GPL-2+ with OpenSSL exception
+	`,
+	licenses: []string{"Artistic-1.0", "BSD-3-clause", "GPL-2+", "GPL-2+ with OpenSSL exception"},
+}, {
+	summary: "Free-form file",
+	data: `
+		This package was debianized by Someone.
+
+		License: GPL-2
+	`,
+	licenses: nil,
+}, {
+	summary: "Empty file",
+	data:    ``,
+}}
+
+func (s *S) TestLicenses(c *C) {
+	for _, test := range licensesTests {
+		c.Logf("Summary: %s", test.summary)
+		licenses := copyright.Licenses(testutil.Reindent(test.data))
+		c.Assert(licenses, DeepEquals, test.licenses)
+	}
+}
+
+func (s *S) TestPath(c *C) {
+	c.Assert(copyright.Path("libssl3"), Equals, "/usr/share/doc/libssl3/copyright")
+}
@@ -0,0 +1,81 @@
+package deb
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadControl takes a Reader for the ar file belonging to a Debian package and
+// returns the content of the control file found in its control tarball.
+func ReadControl(pkgReader io.ReadSeeker) ([]byte, error) {
+	controlReader, err := ControlReader(pkgReader)
+	if err != nil {
+		return nil, err
+	}
+	defer controlReader.Close()
+
+	data, ok, err := readTarFile(controlReader, "control")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no control file in control payload")
+	}
+	return data, nil
+}
+
+// ReadConffiles takes a Reader for the ar file belonging to a Debian package
+// and returns the paths listed in the conffiles file of its control tarball,
+// which dpkg preserves across upgrades when changed locally. Packages
+// without conffiles or without a control tarball have none.
+func ReadConffiles(pkgReader io.ReadSeeker) ([]string, error) {
+	controlReader, err := ControlReader(pkgReader)
+	var noPayload *noPayloadError
+	if errors.As(err, &noPayload) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer controlReader.Close()
+
+	data, _, err := readTarFile(controlReader, "conffiles")
+	if err != nil {
+		return nil, err
+	}
+	var conffiles []string
+	for _, line := range strings.Split(string(data), "\n") {
+		// Entries may be preceded by flags, as in "remove-on-upgrade".
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		path := fields[len(fields)-1]
+		if strings.HasPrefix(path, "/") {
+			conffiles = append(conffiles, path)
+		}
+	}
+	return conffiles, nil
+}
+
+// readTarFile returns the content of the named file at the top of the
+// tarball, and whether it was found.
+func readTarFile(reader io.Reader, name string) ([]byte, bool, error) {
+	tarReader := tar.NewReader(reader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if tarHeader.Name == "./"+name || tarHeader.Name == name {
+			data, err := io.ReadAll(tarReader)
+			return data, err == nil, err
+		}
+	}
+}
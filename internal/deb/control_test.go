@@ -0,0 +1,36 @@
+package deb_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestReadConffiles(c *C) {
+	data := testutil.MustMakeDebWithControlFiles(map[string]string{
+		"control":   "Package: mypkg\n",
+		"conffiles": "/etc/mypkg.conf\n\nremove-on-upgrade /etc/old.conf\n/etc/mypkg.d/extra.conf\n",
+	}, []testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+	})
+	conffiles, err := deb.ReadConffiles(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(conffiles, DeepEquals, []string{"/etc/mypkg.conf", "/etc/old.conf", "/etc/mypkg.d/extra.conf"})
+
+	data = testutil.MustMakeDebWithControl("Package: mypkg\n", []testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+	})
+	conffiles, err = deb.ReadConffiles(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(conffiles, IsNil)
+
+	data = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+	})
+	conffiles, err = deb.ReadConffiles(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(conffiles, IsNil)
+}
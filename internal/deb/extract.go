@@ -4,19 +4,22 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
-	"syscall"
 
 	"github.com/blakesmith/ar"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 
+	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/strdist"
 )
@@ -29,6 +32,31 @@ type ExtractOptions struct {
 	// extractInfos is set to the matching entries in Extract, and is nil in cases where
 	// the created entry is implicit and unlisted (for example, parent directories).
 	Create func(extractInfos []ExtractInfo, options *fsutil.CreateOptions) error
+	// Context optionally cancels the extraction, which then stops before
+	// the next entry of the package is processed.
+	Context context.Context
+	// MapPath optionally maps the paths of the package, such as "/bin/sh",
+	// to the paths they are matched against Extract and created at.
+	MapPath func(path string) string
+	// MapPathKey identifies MapPath, as the extractions replayed from the
+	// Cache must map the paths in the same way. Extractions with MapPath
+	// set are only replayed when it is set.
+	MapPathKey string
+	// Cache optionally keeps the package unpacked, with the content of its
+	// regular files stored once as blobs shared by all packages. Later
+	// extractions of the package with the same Digest read its entries
+	// from the cache instead of decompressing it, and clone the content of
+	// the blobs where the filesystem supports it. Extractions of the same
+	// paths are also recorded, and replayed later without reading the
+	// entries at all once the content of the blobs is revalidated.
+	Cache  *cache.Cache
+	Digest string
+	// JournalDir optionally keeps the records of the extractions into
+	// TargetDir, along with Digest, so that extracting the same paths into
+	// TargetDir again, as when resuming an interrupted cut, keeps the
+	// content already there instead of reading the package, once the
+	// content is revalidated by its hash.
+	JournalDir string
 }
 
 type ExtractInfo struct {
@@ -40,7 +68,7 @@ type ExtractInfo struct {
 
 func getValidOptions(options *ExtractOptions) (*ExtractOptions, error) {
 	for extractPath, extractInfos := range options.Extract {
-		isGlob := strings.ContainsAny(extractPath, "*?")
+		isGlob := strdist.ContainsWildcard(extractPath)
 		if isGlob {
 			for _, extractInfo := range extractInfos {
 				if extractInfo.Path != extractPath || extractInfo.Mode != 0 {
@@ -50,16 +78,20 @@ func getValidOptions(options *ExtractOptions) (*ExtractOptions, error) {
 		}
 	}
 
-	if options.Create == nil {
-		validOpts := *options
+	validOpts := *options
+	if validOpts.Create == nil {
 		validOpts.Create = func(_ []ExtractInfo, o *fsutil.CreateOptions) error {
 			_, err := fsutil.Create(o)
 			return err
 		}
-		return &validOpts, nil
 	}
-
-	return options, nil
+	if validOpts.Context == nil {
+		validOpts.Context = context.Background()
+	}
+	if validOpts.MapPath == nil {
+		validOpts.MapPath = func(path string) string { return path }
+	}
+	return &validOpts, nil
 }
 
 func Extract(pkgReader io.ReadSeeker, options *ExtractOptions) (err error) {
@@ -83,20 +115,69 @@ func Extract(pkgReader io.ReadSeeker, options *ExtractOptions) (err error) {
 		return err
 	}
 
-	return extractData(pkgReader, validOpts)
+	var recorder *extractRecorder
+	key := extractKey(options)
+	if key != "" && options.JournalDir != "" {
+		resumed, err := resumeExtraction(key, validOpts)
+		if err != nil || resumed {
+			return err
+		}
+		recorder = &extractRecorder{key: key, dirs: []string{options.JournalDir}}
+	}
+	if key != "" && options.Cache != nil && options.Cache.Dir != "" {
+		replayed, err := replayExtraction(key, validOpts)
+		if err != nil {
+			return err
+		}
+		if replayed {
+			if options.JournalDir != "" {
+				return journalRecord(validOpts, key)
+			}
+			return nil
+		}
+		if recorder == nil {
+			recorder = &extractRecorder{key: key}
+		}
+		recorder.dirs = append(recorder.dirs, options.Cache.Dir)
+	}
+	err = extractData(pkgReader, validOpts, recorder)
+	if err == nil && recorder != nil {
+		err = recorder.save()
+	}
+	return err
 }
 
-func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
-	dataReader, err := DataReader(pkgReader)
+func extractData(pkgReader io.ReadSeeker, options *ExtractOptions, recorder *extractRecorder) error {
+	entries, err := openEntries(pkgReader, options)
 	if err != nil {
 		return err
 	}
-	defer dataReader.Close()
-
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+	defer entries.Close()
+
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
+
+	// create creates the entry and records it for the extraction to be
+	// replayed, along with the references to the extract infos.
+	create := func(extractInfos []ExtractInfo, refs []infoRef, o *fsutil.CreateOptions) error {
+		created := *o
+		digest := o.CloneSHA256
+		var h hash.Hash
+		if recorder != nil && digest == "" && o.Mode.IsRegular() && o.Link == "" && o.Data != nil {
+			// The content read is hashed for it to be revalidated when
+			// the extraction is replayed.
+			h = sha256.New()
+			o.Data = io.TeeReader(o.Data, h)
+		}
+		err := options.Create(extractInfos, o)
+		if err == nil && recorder != nil {
+			if h != nil {
+				digest = hex.EncodeToString(h.Sum(nil))
+			}
+			recorder.add(options, extractInfos != nil, refs, &created, digest)
+		}
+		return err
+	}
 
 	pendingPaths := make(map[string]bool)
 	for extractPath, extractInfos := range options.Extract {
@@ -118,15 +199,17 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 	pendingHardLinks := make(map[string][]pendingHardLink)
 
 	// When creating a file we will iterate through its parent directories and
-	// create them with the permissions defined in the tarball.
+	// create them with the permissions and owners defined in the tarball.
 	//
 	// The assumption is that the tar entries of the parent directories appear
 	// before the entry for the file itself. This is the case for .deb files but
 	// not for all tarballs.
-	tarDirMode := make(map[string]fs.FileMode)
-	tarReader := tar.NewReader(dataReader)
+	tarDirHeader := make(map[string]*tar.Header)
 	for {
-		tarHeader, err := tarReader.Next()
+		if err := options.Context.Err(); err != nil {
+			return err
+		}
+		tarHeader, err := entries.Next()
 		if err == io.EOF {
 			break
 		}
@@ -138,27 +221,35 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 		if !ok {
 			continue
 		}
+		sourcePath = options.MapPath(sourcePath)
 
 		sourceIsDir := sourcePath[len(sourcePath)-1] == '/'
 		if sourceIsDir {
-			tarDirMode[sourcePath] = tarHeader.FileInfo().Mode()
+			// Keep a copy as the mode of the header may be changed below.
+			dirHeader := *tarHeader
+			tarDirHeader[sourcePath] = &dirHeader
 		}
 
 		// Find all globs and copies that require this source, and map them by
 		// their target paths on disk.
 		targetPaths := map[string][]ExtractInfo{}
+		targetRefs := map[string][]infoRef{}
 		for extractPath, extractInfos := range options.Extract {
 			if extractPath == "" {
 				continue
 			}
-			if strings.ContainsAny(extractPath, "*?") {
+			if strdist.ContainsWildcard(extractPath) {
 				if strdist.GlobPath(extractPath, sourcePath) {
 					targetPaths[sourcePath] = append(targetPaths[sourcePath], extractInfos...)
+					for i := range extractInfos {
+						targetRefs[sourcePath] = append(targetRefs[sourcePath], infoRef{extractPath, i})
+					}
 					delete(pendingPaths, extractPath)
 				}
 			} else if extractPath == sourcePath {
-				for _, extractInfo := range extractInfos {
+				for i, extractInfo := range extractInfos {
 					targetPaths[extractInfo.Path] = append(targetPaths[extractInfo.Path], extractInfo)
+					targetRefs[extractInfo.Path] = append(targetRefs[extractInfo.Path], infoRef{extractPath, i})
 				}
 				delete(pendingPaths, extractPath)
 			}
@@ -169,24 +260,29 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 		}
 
 		var contentCache []byte
-		var contentIsCached = len(targetPaths) > 1 && !sourceIsDir
+		var contentIsCached = len(targetPaths) > 1 && !sourceIsDir && entries.blobPath == ""
 		if contentIsCached {
 			// Read and cache the content so it may be reused.
 			// As an alternative, to avoid having an entire file in
 			// memory at once this logic might open the first file
 			// written and copy it every time. For now, the choice
 			// is speed over memory efficiency.
-			data, err := io.ReadAll(tarReader)
+			data, err := io.ReadAll(entries.tarReader)
 			if err != nil {
 				return err
 			}
 			contentCache = data
 		}
 
-		var pathReader io.Reader = tarReader
 		for targetPath, extractInfos := range targetPaths {
+			var pathReader io.ReadCloser
 			if contentIsCached {
-				pathReader = bytes.NewReader(contentCache)
+				pathReader = io.NopCloser(bytes.NewReader(contentCache))
+			} else {
+				pathReader, err = entries.Content()
+				if err != nil {
+					return err
+				}
 			}
 			mode := extractInfos[0].Mode
 			for _, extractInfo := range extractInfos {
@@ -206,26 +302,33 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 				if path == "/" {
 					continue
 				}
-				mode, ok := tarDirMode[path]
+				dirHeader, ok := tarDirHeader[path]
 				if !ok {
 					continue
 				}
-				delete(tarDirMode, path)
+				delete(tarDirHeader, path)
 
 				createOptions := &fsutil.CreateOptions{
 					Root:        options.TargetDir,
 					Path:        path,
-					Mode:        mode,
+					Mode:        dirHeader.FileInfo().Mode(),
 					MakeParents: true,
+					UID:         dirHeader.Uid,
+					GID:         dirHeader.Gid,
+					Label:       selinuxLabel(dirHeader),
 				}
-				err := options.Create(nil, createOptions)
+				err := create(nil, nil, createOptions)
 				if err != nil {
+					pathReader.Close()
 					return err
 				}
 			}
 			link := tarHeader.Linkname
 			if tarHeader.Typeflag == tar.TypeLink {
 				// A hard link requires the real path of the target file.
+				if relLink, ok := sanitizeTarPath(link); ok {
+					link = options.MapPath(relLink)
+				}
 				link = filepath.Join(options.TargetDir, link)
 			}
 
@@ -238,8 +341,14 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 				Link:         link,
 				MakeParents:  true,
 				OverrideMode: true,
+				UID:          tarHeader.Uid,
+				GID:          tarHeader.Gid,
+				Label:        selinuxLabel(tarHeader),
+				CloneFrom:    entries.blobPath,
+				CloneSHA256:  entries.blobDigest,
 			}
-			err := options.Create(extractInfos, createOptions)
+			err := create(extractInfos, targetRefs[targetPath], createOptions)
+			pathReader.Close()
 			if err != nil && os.IsNotExist(err) && tarHeader.Typeflag == tar.TypeLink {
 				// The hard link could not be created because the content
 				// was not extracted previously. Add this hard link entry
@@ -248,9 +357,11 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 				if !ok {
 					return fmt.Errorf("invalid link target %s", tarHeader.Linkname)
 				}
+				relLinkPath = options.MapPath(relLinkPath)
 				info := pendingHardLink{
 					path:         targetPath,
 					extractInfos: extractInfos,
+					refs:         targetRefs[targetPath],
 				}
 				pendingHardLinks[relLinkPath] = append(pendingHardLinks[relLinkPath], info)
 			} else if err != nil {
@@ -264,6 +375,7 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 		extractHardLinkOptions := &extractHardLinkOptions{
 			ExtractOptions: options,
 			pendingLinks:   pendingHardLinks,
+			create:         create,
 		}
 		_, err := pkgReader.Seek(0, io.SeekStart)
 		if err != nil {
@@ -294,25 +406,29 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 type pendingHardLink struct {
 	path         string
 	extractInfos []ExtractInfo
+	refs         []infoRef
 }
 
 type extractHardLinkOptions struct {
 	*ExtractOptions
 	pendingLinks map[string][]pendingHardLink
+	create       func(extractInfos []ExtractInfo, refs []infoRef, o *fsutil.CreateOptions) error
 }
 
 // extractHardLinks iterates through the tarball a second time to extract the
 // hard links that were not extracted in the first pass.
 func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) error {
-	dataReader, err := DataReader(pkgReader)
+	entries, err := openEntries(pkgReader, opts.ExtractOptions)
 	if err != nil {
 		return err
 	}
-	defer dataReader.Close()
+	defer entries.Close()
 
-	tarReader := tar.NewReader(dataReader)
 	for {
-		tarHeader, err := tarReader.Next()
+		if err := opts.Context.Err(); err != nil {
+			return err
+		}
+		tarHeader, err := entries.Next()
 		if err == io.EOF {
 			break
 		}
@@ -324,6 +440,7 @@ func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) err
 		if !ok {
 			continue
 		}
+		sourcePath = opts.MapPath(sourcePath)
 
 		links := opts.pendingLinks[sourcePath]
 		if len(links) == 0 {
@@ -335,14 +452,24 @@ func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) err
 		// the remaining ones will be created as hard links with the newly
 		// created file as their target.
 		absLink := filepath.Join(opts.TargetDir, links[0].path)
+		content, err := entries.Content()
+		if err != nil {
+			return err
+		}
 		// Extract the content to the first hard link path.
 		createOptions := &fsutil.CreateOptions{
-			Root: opts.TargetDir,
-			Path: links[0].path,
-			Mode: tarHeader.FileInfo().Mode(),
-			Data: tarReader,
+			Root:        opts.TargetDir,
+			Path:        links[0].path,
+			Mode:        tarHeader.FileInfo().Mode(),
+			Data:        content,
+			UID:         tarHeader.Uid,
+			GID:         tarHeader.Gid,
+			Label:       selinuxLabel(tarHeader),
+			CloneFrom:   entries.blobPath,
+			CloneSHA256: entries.blobDigest,
 		}
-		err = opts.Create(links[0].extractInfos, createOptions)
+		err = opts.create(links[0].extractInfos, links[0].refs, createOptions)
+		content.Close()
 		if err != nil {
 			return err
 		}
@@ -354,9 +481,12 @@ func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) err
 				Path: link.path,
 				Mode: tarHeader.FileInfo().Mode(),
 				// Link to the first file extracted for the hard links.
-				Link: absLink,
+				Link:  absLink,
+				UID:   tarHeader.Uid,
+				GID:   tarHeader.Gid,
+				Label: selinuxLabel(tarHeader),
 			}
-			err := opts.Create(link.extractInfos, createOptions)
+			err := opts.create(link.extractInfos, link.refs, createOptions)
 			if err != nil {
 				return err
 			}
@@ -382,39 +512,59 @@ func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) err
 // DataReader takes a Reader for the ar file belonging to a Debian package and
 // returns a Reader to the inner tarball.
 func DataReader(pkgReader io.ReadSeeker) (io.ReadCloser, error) {
+	return memberReader(pkgReader, "data")
+}
+
+// ControlReader takes a Reader for the ar file belonging to a Debian package
+// and returns a Reader to the inner control tarball.
+func ControlReader(pkgReader io.ReadSeeker) (io.ReadCloser, error) {
+	return memberReader(pkgReader, "control")
+}
+
+// memberReader returns a Reader to the decompressed content of the
+// "<name>.tar.*" member of the ar file belonging to a Debian package.
+func memberReader(pkgReader io.ReadSeeker, name string) (io.ReadCloser, error) {
 	arReader := ar.NewReader(pkgReader)
-	var dataReader io.ReadCloser
-	for dataReader == nil {
+	var tarReader io.ReadCloser
+	for tarReader == nil {
 		arHeader, err := arReader.Next()
 		if err == io.EOF {
-			return nil, fmt.Errorf("no data payload")
+			return nil, &noPayloadError{name}
 		}
 		if err != nil {
 			return nil, err
 		}
 		switch arHeader.Name {
-		case "data.tar.gz":
+		case name + ".tar.gz":
 			gzipReader, err := gzip.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = gzipReader
-		case "data.tar.xz":
+			tarReader = gzipReader
+		case name + ".tar.xz":
 			xzReader, err := xz.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = io.NopCloser(xzReader)
-		case "data.tar.zst":
+			tarReader = io.NopCloser(xzReader)
+		case name + ".tar.zst":
 			zstdReader, err := zstd.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = zstdReader.IOReadCloser()
+			tarReader = zstdReader.IOReadCloser()
 		}
 	}
 
-	return dataReader, nil
+	return tarReader, nil
+}
+
+type noPayloadError struct {
+	name string
+}
+
+func (e *noPayloadError) Error() string {
+	return fmt.Sprintf("no %s payload", e.name)
 }
 
 func parentDirs(path string) []string {
@@ -438,3 +588,14 @@ func sanitizeTarPath(path string) (string, bool) {
 	}
 	return path[1:], true
 }
+
+// selinuxXattr is the PAX record holding the SELinux security context of
+// an entry, as written by GNU tar with --selinux.
+const selinuxXattr = "SCHILY.xattr.security.selinux"
+
+// selinuxLabel returns the SELinux security context carried by the tar
+// entry, if any.
+func selinuxLabel(header *tar.Header) string {
+	// The value may be terminated by a NUL byte, as stored in the xattr.
+	return strings.TrimRight(header.PAXRecords[selinuxXattr], "\x00")
+}
@@ -2,6 +2,7 @@ package deb_test
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"path"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 
 	. "gopkg.in/check.v1"
 
+	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/testutil"
@@ -486,6 +488,49 @@ var extractTests = []extractTest{{
 		},
 	},
 	error: `cannot extract from package "test-package": cannot create path /[a-z0-9\-\/]*/file outside of root /[a-z0-9\-\/]*`,
+}, {
+	summary: "Cancelled extraction",
+	pkgdata: testutil.PackageData["test-package"],
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	hackopt: func(c *C, o *deb.ExtractOptions) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		o.Context = ctx
+	},
+	error: `cannot extract from package "test-package": context canceled`,
+}, {
+	summary: "Paths of the package are mapped before being matched",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./bin/"),
+		testutil.Reg(0755, "./bin/file", "data"),
+		testutil.Hrd(0755, "./bin/link", "./bin/file"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/usr/bin/*": []deb.ExtractInfo{{
+				Path: "/usr/bin/*",
+			}},
+		},
+		MapPath: func(path string) string {
+			if strings.HasPrefix(path, "/bin/") {
+				return "/usr" + path
+			}
+			return path
+		},
+	},
+	result: map[string]string{
+		"/usr/":         "dir 0755",
+		"/usr/bin/":     "dir 0755",
+		"/usr/bin/file": "file 0755 3a6eb079 <1>",
+		"/usr/bin/link": "file 0755 3a6eb079 <1>",
+	},
 }}
 
 func (s *S) TestExtract(c *C) {
@@ -628,3 +673,143 @@ func (s *S) TestExtractCreateCallback(c *C) {
 		c.Assert(createExtractInfos, DeepEquals, test.calls)
 	}
 }
+
+func (s *S) TestExtractCache(c *C) {
+	for _, test := range extractTests {
+		if test.error != "" {
+			continue
+		}
+		c.Logf("Test: %s", test.summary)
+		blobs := &cache.Cache{Dir: c.MkDir()}
+		for _, pkgdata := range [][]byte{test.pkgdata, []byte("not a package")} {
+			// The second extraction reads the unpacked package from the
+			// cache, so the package is not used at all.
+			dir := c.MkDir()
+			options := test.options
+			options.Package = "test-package"
+			options.TargetDir = dir
+			options.Cache = blobs
+			options.Digest = "test-digest"
+			if test.hackopt != nil {
+				test.hackopt(c, &options)
+			}
+			err := deb.Extract(bytes.NewReader(pkgdata), &options)
+			c.Assert(err, IsNil)
+			c.Assert(testutil.TreeDump(dir), DeepEquals, test.result)
+		}
+	}
+}
+
+func (s *S) TestExtractCacheExpired(c *C) {
+	blobs := &cache.Cache{Dir: c.MkDir()}
+	options := deb.ExtractOptions{
+		Package: "test-package",
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file": {{Path: "/dir/file"}},
+		},
+		Cache:  blobs,
+		Digest: "test-digest",
+	}
+	options.TargetDir = c.MkDir()
+	err := deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+
+	// Once the blobs expire, the package is unpacked again.
+	err = blobs.Expire(0)
+	c.Assert(err, IsNil)
+	options.TargetDir = c.MkDir()
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": .*`)
+	err = deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	})
+}
+
+func (s *S) TestExtractReplay(c *C) {
+	blobs := &cache.Cache{Dir: c.MkDir()}
+	options := deb.ExtractOptions{
+		Package: "test-package",
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file":    {{Path: "/dir/file"}},
+			"/dir/nested/": {{Path: "/dir/nested/"}},
+		},
+		Cache:  blobs,
+		Digest: "test-digest",
+	}
+	options.TargetDir = c.MkDir()
+	err := deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+	result := testutil.TreeDump(options.TargetDir)
+
+	// Without the unpacked package, the extraction of the same paths is
+	// replayed from its record alone.
+	err = os.RemoveAll(filepath.Join(blobs.Dir, "unpacked"))
+	c.Assert(err, IsNil)
+	options.TargetDir = c.MkDir()
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, result)
+
+	// Other paths are not replayed.
+	otherOptions := options
+	otherOptions.Extract = map[string][]deb.ExtractInfo{
+		"/dir/file": {{Path: "/dir/file"}},
+	}
+	otherOptions.TargetDir = c.MkDir()
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &otherOptions)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": .*`)
+
+	// Modified content is detected, and the package extracted again.
+	blobPath, err := blobs.Path("cc55e2ecf36e40171ded57167c38e1025c99dc8f8bcdd6422368385a977ae1fe")
+	c.Assert(err, IsNil)
+	err = os.WriteFile(blobPath, []byte("modified"), 0644)
+	c.Assert(err, IsNil)
+	options.TargetDir = c.MkDir()
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": .*`)
+	options.TargetDir = c.MkDir()
+	err = deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, result)
+}
+
+func (s *S) TestExtractResume(c *C) {
+	options := deb.ExtractOptions{
+		Package: "test-package",
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file":    {{Path: "/dir/file"}},
+			"/dir/nested/": {{Path: "/dir/nested/"}},
+		},
+		Digest:     "test-digest",
+		TargetDir:  c.MkDir(),
+		JournalDir: c.MkDir(),
+	}
+	err := deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+	result := testutil.TreeDump(options.TargetDir)
+
+	// The extraction of the same paths into the same directory keeps the
+	// content already there, reporting the entries created again.
+	var created []string
+	options.Create = func(_ []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+		created = append(created, o.Path)
+		_, err := fsutil.Create(o)
+		return err
+	}
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, result)
+	c.Assert(created, DeepEquals, []string{"/dir/", "/dir/file", "/dir/nested/"})
+
+	// Modified content is detected, and the package extracted again.
+	err = os.WriteFile(filepath.Join(options.TargetDir, "dir/file"), []byte("modified"), 0644)
+	c.Assert(err, IsNil)
+	err = deb.Extract(bytes.NewReader([]byte("not a package")), &options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": .*`)
+	err = deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, result)
+}
@@ -39,3 +39,23 @@ func ValidateArch(debArch string) error {
 	}
 	return fmt.Errorf("invalid package architecture: %s", debArch)
 }
+
+// Archs returns the names of the known package architectures.
+func Archs() []string {
+	archs := make([]string, len(knownArchs))
+	for i, arch := range knownArchs {
+		archs[i] = arch.debArch
+	}
+	return archs
+}
+
+// GoArch returns the name of the package architecture in the terms of Go,
+// as in "arm" for "armhf", which container images use too.
+func GoArch(debArch string) (string, error) {
+	for _, arch := range knownArchs {
+		if arch.debArch == debArch {
+			return arch.goArch, nil
+		}
+	}
+	return "", fmt.Errorf("invalid package architecture: %s", debArch)
+}
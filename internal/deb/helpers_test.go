@@ -42,3 +42,18 @@ func (s *S) TestValidateArch(c *C) {
 	c.Assert(deb.ValidateArch("i3866"), Not(IsNil))
 	c.Assert(deb.ValidateArch(""), Not(IsNil))
 }
+
+func (s *S) TestArchs(c *C) {
+	c.Assert(deb.Archs(), DeepEquals, []string{"i386", "amd64", "armhf", "arm64", "ppc64el", "riscv64", "s390x"})
+}
+
+func (s *S) TestGoArch(c *C) {
+	goArch, err := deb.GoArch("armhf")
+	c.Assert(err, IsNil)
+	c.Assert(goArch, Equals, "arm")
+	goArch, err = deb.GoArch("ppc64el")
+	c.Assert(err, IsNil)
+	c.Assert(goArch, Equals, "ppc64le")
+	_, err = deb.GoArch("foo")
+	c.Assert(err, ErrorMatches, "invalid package architecture: foo")
+}
@@ -0,0 +1,358 @@
+package deb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+// extractedDir is the directory of the cache holding the records of the
+// extractions, named by the key of the package and paths extracted.
+const extractedDir = "extracted"
+
+// extractRecord holds the entries created by an extraction, in order, so
+// that the extraction may be replayed without reading the package.
+type extractRecord struct {
+	Entries []*recordedEntry `json:"entries"`
+}
+
+type recordedEntry struct {
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	// Link is relative to the target directory for hard links.
+	Link         string `json:"link,omitempty"`
+	HardLink     bool   `json:"hard-link,omitempty"`
+	MakeParents  bool   `json:"make-parents,omitempty"`
+	OverrideMode bool   `json:"override-mode,omitempty"`
+	UID          int    `json:"uid,omitempty"`
+	GID          int    `json:"gid,omitempty"`
+	Label        string `json:"label,omitempty"`
+	// SHA256 is the digest of the blob with the content of regular files.
+	SHA256 string `json:"sha256,omitempty"`
+	// Infos references the extract infos the entry was created for, which
+	// are none for implicit parent directories.
+	Infos []infoRef `json:"infos,omitempty"`
+}
+
+// infoRef references an extract info by its extract path and its index in
+// the extract infos of the path in the options.
+type infoRef struct {
+	Path  string `json:"path"`
+	Index int    `json:"index"`
+}
+
+// extractKey returns the key identifying the extraction of the paths in
+// options from the package, in the cache or the journal, or an empty string
+// if the extraction cannot be replayed.
+func extractKey(options *ExtractOptions) string {
+	if options.Digest == "" {
+		return ""
+	}
+	if options.MapPath != nil && options.MapPathKey == "" {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", options.Digest, options.MapPathKey)
+	for _, extractPath := range slices.Sorted(maps.Keys(options.Extract)) {
+		for _, info := range options.Extract[extractPath] {
+			fmt.Fprintf(h, "%q %q %o %t\n", extractPath, info.Path, info.Mode, info.Optional)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayExtraction replays the extraction recorded with key, and returns
+// whether it was found. The content of the blobs used is revalidated by
+// their hash first, and the extraction is not replayed if any of them is
+// missing or modified.
+func replayExtraction(key string, options *ExtractOptions) (bool, error) {
+	blobPaths := make(map[string]string)
+	content := func(entry *recordedEntry) (string, error) {
+		if blobPath, ok := blobPaths[entry.SHA256]; ok {
+			return blobPath, nil
+		}
+		blobPath, err := validBlob(options.Cache, entry.SHA256)
+		if err != nil {
+			return "", err
+		}
+		blobPaths[entry.SHA256] = blobPath
+		return blobPath, nil
+	}
+	recordPath := filepath.Join(options.Cache.Dir, extractedDir, key)
+	return replayRecord(recordPath, options, content)
+}
+
+// resumeExtraction replays the extraction recorded with key in the journal
+// of the target directory, keeping the content already extracted there,
+// and returns whether it was found. The content of the regular files is
+// revalidated by their hash first, and the extraction is not replayed if
+// any of them is missing or modified.
+func resumeExtraction(key string, options *ExtractOptions) (bool, error) {
+	content := func(entry *recordedEntry) (string, error) {
+		return validContent(filepath.Join(options.TargetDir, entry.Path), entry.SHA256)
+	}
+	recordPath := filepath.Join(options.JournalDir, extractedDir, key)
+	return replayRecord(recordPath, options, content)
+}
+
+// replayRecord replays the extraction recorded at recordPath, and returns
+// whether it was replayed. The content of regular files is read from the
+// path returned by content for their entry, which is empty if the content
+// is missing or modified.
+func replayRecord(recordPath string, options *ExtractOptions, content func(entry *recordedEntry) (string, error)) (bool, error) {
+	data, err := os.ReadFile(recordPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("cannot read extraction record: %w", err)
+	}
+	record := &extractRecord{}
+	err = json.Unmarshal(data, record)
+	if err != nil {
+		debugf("Discarding invalid extraction record of package %q: %v", options.Package, err)
+		os.Remove(recordPath)
+		return false, nil
+	}
+
+	contentPaths := make(map[*recordedEntry]string)
+	for _, entry := range record.Entries {
+		for _, ref := range entry.Infos {
+			if ref.Index < 0 || ref.Index >= len(options.Extract[ref.Path]) {
+				os.Remove(recordPath)
+				return false, nil
+			}
+		}
+		if entry.SHA256 == "" {
+			continue
+		}
+		contentPath, err := content(entry)
+		if err != nil {
+			return false, err
+		}
+		if contentPath == "" {
+			debugf("Cannot replay extraction of package %q: content of %s missing or modified", options.Package, entry.Path)
+			os.Remove(recordPath)
+			return false, nil
+		}
+		contentPaths[entry] = contentPath
+	}
+
+	debugf("Replaying extraction of package %q", options.Package)
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
+	for _, entry := range record.Entries {
+		if err := options.Context.Err(); err != nil {
+			return true, err
+		}
+		var extractInfos []ExtractInfo
+		for _, ref := range entry.Infos {
+			extractInfos = append(extractInfos, options.Extract[ref.Path][ref.Index])
+		}
+		createOptions := &fsutil.CreateOptions{
+			Root:         options.TargetDir,
+			Path:         entry.Path,
+			Mode:         entry.Mode,
+			Link:         entry.Link,
+			MakeParents:  entry.MakeParents,
+			OverrideMode: entry.OverrideMode,
+			UID:          entry.UID,
+			GID:          entry.GID,
+			Label:        entry.Label,
+		}
+		if entry.HardLink {
+			createOptions.Link = filepath.Join(options.TargetDir, entry.Link)
+		}
+		var file *os.File
+		if contentPath := contentPaths[entry]; contentPath == filepath.Join(options.TargetDir, entry.Path) {
+			// The file is truncated before it is written again, which
+			// keeps the hard links to it.
+			data, err := os.ReadFile(contentPath)
+			if err != nil {
+				return true, err
+			}
+			createOptions.Data = bytes.NewReader(data)
+		} else if contentPath != "" {
+			file, err = os.Open(contentPath)
+			if err != nil {
+				return true, err
+			}
+			createOptions.Data = file
+			createOptions.CloneFrom = contentPath
+			createOptions.CloneSHA256 = entry.SHA256
+		}
+		err := options.Create(extractInfos, createOptions)
+		if file != nil {
+			file.Close()
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// validBlob returns the path of the blob with the given digest in the
+// cache, or an empty string if it is missing or its content does not match
+// the digest, in which case it is removed.
+func validBlob(blobs *cache.Cache, digest string) (string, error) {
+	blobPath, err := blobs.Path(digest)
+	if err == cache.MissErr {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		os.Remove(blobPath)
+		return "", nil
+	}
+	return blobPath, nil
+}
+
+// validContent returns path if it is a regular file with content matching
+// the digest, or an empty string otherwise.
+func validContent(path, digest string) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		return "", nil
+	}
+	return path, nil
+}
+
+// extractRecorder records the entries created by an extraction.
+type extractRecorder struct {
+	key string
+	// dirs lists the directories the record is saved in.
+	dirs   []string
+	record extractRecord
+	// incomplete is set when some entry cannot be replayed.
+	incomplete bool
+}
+
+// add records the entry created with the options for the extract infos
+// referenced, if explicit. The digest is the one of the content of regular
+// files.
+func (r *extractRecorder) add(options *ExtractOptions, explicit bool, refs []infoRef, o *fsutil.CreateOptions, digest string) {
+	entry := &recordedEntry{
+		Path:         o.Path,
+		Mode:         o.Mode,
+		Link:         o.Link,
+		MakeParents:  o.MakeParents,
+		OverrideMode: o.OverrideMode,
+		UID:          o.UID,
+		GID:          o.GID,
+		Label:        o.Label,
+	}
+	if explicit {
+		entry.Infos = refs
+		if len(refs) == 0 {
+			r.incomplete = true
+		}
+	}
+	if o.Mode.IsRegular() {
+		if o.Link != "" {
+			relLink, err := filepath.Rel(options.TargetDir, o.Link)
+			if err != nil {
+				r.incomplete = true
+			}
+			entry.HardLink = true
+			entry.Link = relLink
+		} else if digest != "" {
+			entry.SHA256 = digest
+		} else {
+			r.incomplete = true
+		}
+	}
+	r.record.Entries = append(r.record.Entries, entry)
+}
+
+// save writes the record to each of its directories, unless incomplete.
+func (r *extractRecorder) save() error {
+	if r.incomplete {
+		return nil
+	}
+	data, err := json.Marshal(&r.record)
+	if err != nil {
+		return err
+	}
+	for _, dir := range r.dirs {
+		err := writeRecord(filepath.Join(dir, extractedDir), r.key, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalRecord copies the record of the extraction replayed from the cache
+// with key to the journal, so that it is resumed as well.
+func journalRecord(options *ExtractOptions, key string) error {
+	data, err := os.ReadFile(filepath.Join(options.Cache.Dir, extractedDir, key))
+	if err != nil {
+		return fmt.Errorf("cannot read extraction record: %w", err)
+	}
+	return writeRecord(filepath.Join(options.JournalDir, extractedDir), key, data)
+}
+
+func writeRecord(dir, key string, data []byte) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "tmp.*")
+	if err != nil {
+		return fmt.Errorf("cannot create cache file: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("cannot write extraction record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package deb
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/chisel/internal/cache"
+)
+
+// Packages unpacked in a cache have the content of their regular files
+// stored there as blobs named by their SHA256, which are shared by all the
+// packages, and the entries of their data recorded in an uncompressed tar
+// where regular files have no content but the digest of their blob in the
+// blobRecord PAX record. Later extractions of the same package iterate over
+// the record instead of decompressing the package.
+const blobRecord = "CHISEL.blob"
+
+// unpackedDir is the directory of the cache holding the records of the
+// packages unpacked, named by the SHA256 of the packages.
+const unpackedDir = "unpacked"
+
+// entryReader iterates over the entries of the data of a package, reading
+// them from the package itself, from the record of the package unpacked in
+// a cache or, while unpacking it, from the package while the record is
+// written.
+type entryReader struct {
+	tarReader *tar.Reader
+	closer    io.Closer
+	cache     *cache.Cache
+
+	// record receives the entries while unpacking the package, and is
+	// renamed to recordPath once complete.
+	record     *tar.Writer
+	recordFile *os.File
+	recordPath string
+
+	// blobPath and blobDigest identify the blob with the content of the
+	// current entry, if it is a regular file of an unpacked package.
+	blobPath   string
+	blobDigest string
+}
+
+// openEntries returns a reader for the entries of the data of the package,
+// which uses the cache in the options if set along with the digest.
+func openEntries(pkgReader io.ReadSeeker, options *ExtractOptions) (*entryReader, error) {
+	if options.Cache == nil || options.Cache.Dir == "" || options.Digest == "" {
+		dataReader, err := DataReader(pkgReader)
+		if err != nil {
+			return nil, err
+		}
+		return &entryReader{tarReader: tar.NewReader(dataReader), closer: dataReader}, nil
+	}
+
+	recordPath := filepath.Join(options.Cache.Dir, unpackedDir, options.Digest)
+	file, err := os.Open(recordPath)
+	if err == nil {
+		ok, err := recordComplete(file, options.Cache)
+		if err == nil && ok {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if ok {
+			debugf("Reading unpacked package %q from the cache", options.Package)
+			return &entryReader{tarReader: tar.NewReader(file), closer: file, cache: options.Cache}, nil
+		}
+		// Some blobs expired, so the package is unpacked again.
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot open unpacked package: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(recordPath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	// As with other files in the cache, the record is written to its own
+	// temporary file so that concurrent processes may share the cache.
+	recordFile, err := os.CreateTemp(filepath.Dir(recordPath), "tmp.*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cache file: %w", err)
+	}
+	dataReader, err := DataReader(pkgReader)
+	if err != nil {
+		recordFile.Close()
+		os.Remove(recordFile.Name())
+		return nil, err
+	}
+	return &entryReader{
+		tarReader:  tar.NewReader(dataReader),
+		closer:     dataReader,
+		cache:      options.Cache,
+		record:     tar.NewWriter(recordFile),
+		recordFile: recordFile,
+		recordPath: recordPath,
+	}, nil
+}
+
+// recordComplete returns whether all the blobs referenced in the record of
+// an unpacked package are in the cache.
+func recordComplete(file *os.File, blobs *cache.Cache) (bool, error) {
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("cannot read unpacked package: %w", err)
+		}
+		digest, ok := header.PAXRecords[blobRecord]
+		if !ok {
+			continue
+		}
+		_, err = blobs.Path(digest)
+		if err == cache.MissErr {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Next advances to the next entry, returning io.EOF at the end.
+func (r *entryReader) Next() (*tar.Header, error) {
+	r.blobPath = ""
+	r.blobDigest = ""
+	header, err := r.tarReader.Next()
+	if err == io.EOF && r.record != nil {
+		err = r.finishRecord()
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.cache == nil {
+		return header, nil
+	}
+
+	if r.record == nil {
+		if digest, ok := header.PAXRecords[blobRecord]; ok {
+			r.blobDigest = digest
+			r.blobPath, err = r.cache.Path(digest)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read unpacked content of %s: %w", header.Name, err)
+			}
+			info, err := os.Stat(r.blobPath)
+			if err != nil {
+				return nil, err
+			}
+			header.Size = info.Size()
+		}
+		return header, nil
+	}
+
+	recordHeader := *header
+	// Let the writer pick a format able to hold the PAX records.
+	recordHeader.Format = tar.FormatUnknown
+	if header.Typeflag == tar.TypeReg {
+		writer := r.cache.Create("")
+		_, err := io.Copy(writer, r.tarReader)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot unpack content of %s: %w", header.Name, err)
+		}
+		r.blobDigest = writer.Digest()
+		r.blobPath, err = r.cache.Path(r.blobDigest)
+		if err != nil {
+			return nil, err
+		}
+		recordHeader.Size = 0
+		recordHeader.PAXRecords = maps.Clone(header.PAXRecords)
+		if recordHeader.PAXRecords == nil {
+			recordHeader.PAXRecords = make(map[string]string)
+		}
+		recordHeader.PAXRecords[blobRecord] = r.blobDigest
+	}
+	err = r.record.WriteHeader(&recordHeader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write unpacked package: %w", err)
+	}
+	return header, nil
+}
+
+// Content returns the content of the current entry, which must be closed
+// by the caller.
+func (r *entryReader) Content() (io.ReadCloser, error) {
+	if r.blobPath != "" {
+		return os.Open(r.blobPath)
+	}
+	return io.NopCloser(r.tarReader), nil
+}
+
+func (r *entryReader) finishRecord() error {
+	err := r.record.Close()
+	if err == nil {
+		err = r.recordFile.Close()
+	}
+	if err == nil {
+		err = os.Rename(r.recordFile.Name(), r.recordPath)
+	}
+	r.record = nil
+	if err != nil {
+		os.Remove(r.recordFile.Name())
+		return fmt.Errorf("cannot write unpacked package: %w", err)
+	}
+	r.recordFile = nil
+	return nil
+}
+
+// Close releases the reader, discarding the record unless complete.
+func (r *entryReader) Close() error {
+	if r.recordFile != nil {
+		r.recordFile.Close()
+		os.Remove(r.recordFile.Name())
+		r.recordFile = nil
+	}
+	return r.closer.Close()
+}
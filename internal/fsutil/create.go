@@ -3,6 +3,7 @@ package fsutil
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -27,6 +28,18 @@ type CreateOptions struct {
 	// If OverrideMode is true and entry already exists, update the mode. Does
 	// not affect symlinks.
 	OverrideMode bool
+	// UID and GID are the owners the entry is meant to have. They are not
+	// applied by Create, and are left for the caller to record.
+	UID int
+	GID int
+	// Label is the SELinux security context the entry is meant to have.
+	// As with the owners, it is not applied by Create.
+	Label string
+	// CloneFrom optionally names a file holding the same content as Data,
+	// with the SHA256 in CloneSHA256, which is cloned into a regular file
+	// rather than copied from Data where the filesystem supports it.
+	CloneFrom   string
+	CloneSHA256 string
 }
 
 type Entry struct {
@@ -56,7 +69,12 @@ func Create(options *CreateOptions) (*Entry, error) {
 		return nil, err
 	}
 
+	if err := checkParent(o.Root, path); err != nil {
+		return nil, err
+	}
+
 	var hash string
+	var cloned bool
 	if o.MakeParents {
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return nil, err
@@ -74,8 +92,14 @@ func Create(options *CreateOptions) (*Entry, error) {
 			}
 			err = createHardLink(o)
 		} else {
-			err = createFile(o)
+			cloned, err = cloneFile(o)
+			if err == nil && !cloned {
+				err = createFile(o)
+			}
 			hash = hex.EncodeToString(rp.h.Sum(nil))
+			if cloned {
+				hash = o.CloneSHA256
+			}
 		}
 	case fs.ModeDir:
 		err = createDir(o)
@@ -110,11 +134,15 @@ func Create(options *CreateOptions) (*Entry, error) {
 		mode = o.Mode
 	}
 
+	size := rp.size
+	if cloned {
+		size = int(s.Size())
+	}
 	entry := &Entry{
 		Path:   path,
 		Mode:   mode,
 		SHA256: hash,
-		Size:   rp.size,
+		Size:   size,
 		Link:   o.Link,
 	}
 	return entry, nil
@@ -137,13 +165,16 @@ func CreateWriter(options *CreateOptions) (io.WriteCloser, *Entry, error) {
 	if !o.Mode.IsRegular() {
 		return nil, nil, fmt.Errorf("unsupported file type: %s", path)
 	}
+	if err := checkParent(o.Root, path); err != nil {
+		return nil, nil, err
+	}
 	if o.MakeParents {
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return nil, nil, err
 		}
 	}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, o.Mode)
+	file, err := openFile(path, o.Mode)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -179,7 +210,7 @@ func createFile(o *CreateOptions) error {
 	if err != nil {
 		return err
 	}
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, o.Mode)
+	file, err := openFile(path, o.Mode)
 	if err != nil {
 		return err
 	}
@@ -191,6 +222,50 @@ func createFile(o *CreateOptions) error {
 	return err
 }
 
+// cloneFile creates the file as a clone of o.CloneFrom, if set, and returns
+// whether it was cloned, as not all filesystems support it.
+func cloneFile(o *CreateOptions) (bool, error) {
+	if o.CloneFrom == "" {
+		return false, nil
+	}
+	path, err := absPath(o.Root, o.Path)
+	if err != nil {
+		return false, err
+	}
+	file, err := openFile(path, o.Mode)
+	if err != nil {
+		return false, err
+	}
+	err = file.Close()
+	if err != nil {
+		return false, err
+	}
+	err = Reflink(o.CloneFrom, path)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	debugf("Cloning file: %s (mode %#o)", o.Path, o.Mode)
+	return true, nil
+}
+
+// openFile creates or truncates the regular file at path for writing. A
+// symlink at path is replaced rather than followed, as it could otherwise
+// point outside of the root.
+func openFile(path string, mode fs.FileMode) (*os.File, error) {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|oNoFollow, mode)
+}
+
 func createSymlink(o *CreateOptions) error {
 	debugf("Creating symlink: %s => %s", o.Path, o.Link)
 	path, err := absPath(o.Root, o.Path)
@@ -262,6 +337,47 @@ func absPath(root, relPath string) (string, error) {
 	return path, nil
 }
 
+// checkParent returns an error if the parent directory of path, which is
+// within root, resolves outside of root because of symlinks, as writing to
+// path would then change content outside of root. Missing parents are
+// checked up to the closest one which exists.
+func checkParent(root, path string) error {
+	if root == "/" {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	for {
+		if !strings.HasPrefix(dir+"/", root) || dir+"/" == root {
+			// Only the paths within root may be symlinks to worry about.
+			return nil
+		}
+		_, err := os.Lstat(dir)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Dangling symlinks are created as directories by MakeParents.
+			return fmt.Errorf("cannot create path %s: %s is a dangling symlink", path, dir)
+		}
+		return err
+	}
+	if realDir != realRoot && !strings.HasPrefix(realDir, filepath.Clean(realRoot)+"/") {
+		return fmt.Errorf("cannot create path %s: %s resolves to %s outside of root %s", path, dir, realDir, root)
+	}
+	return nil
+}
+
 // readerProxy implements the io.Reader interface proxying the calls to its
 // inner io.Reader. On each read, the proxy keeps track of the file size and hash.
 type readerProxy struct {
@@ -6,7 +6,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
 
 	. "gopkg.in/check.v1"
 
@@ -298,13 +297,46 @@ var createTests = []createTest{{
 		Mode: 0666,
 	},
 	error: `invalid hardlink /foo/system-file target: /foobar is outside of root /foo/`,
+}, {
+	summary: "Cannot create a file through an absolute symlink escaping Root",
+	options: fsutil.CreateOptions{
+		Path:        "link/sub/file",
+		Mode:        0644,
+		Data:        bytes.NewBufferString("data"),
+		MakeParents: true,
+	},
+	hackopt: func(c *C, dir string, opts *fsutil.CreateOptions) {
+		c.Assert(os.Symlink(c.MkDir(), filepath.Join(dir, "link")), IsNil)
+	},
+	error: `cannot create path /[^ ]*/link/sub/file: /[^ ]*/link resolves to /[^ ]* outside of root /[^ ]*`,
+}, {
+	summary: "Cannot create a file through a relative symlink escaping Root",
+	options: fsutil.CreateOptions{
+		Path: "link/file",
+		Mode: 0644,
+		Data: bytes.NewBufferString("data"),
+	},
+	hackopt: func(c *C, dir string, opts *fsutil.CreateOptions) {
+		c.Assert(os.Symlink("../../..", filepath.Join(dir, "link")), IsNil)
+	},
+	error: `cannot create path /[^ ]*/link/file: /[^ ]*/link resolves to /[^ ]* outside of root /[^ ]*`,
+}, {
+	summary: "Cannot create a file through a dangling symlink",
+	options: fsutil.CreateOptions{
+		Path:        "link/file",
+		Mode:        0644,
+		Data:        bytes.NewBufferString("data"),
+		MakeParents: true,
+	},
+	hackopt: func(c *C, dir string, opts *fsutil.CreateOptions) {
+		c.Assert(os.Symlink("missing", filepath.Join(dir, "link")), IsNil)
+	},
+	error: `cannot create path /[^ ]*/link/file: /[^ ]*/link is a dangling symlink`,
 }}
 
 func (s *S) TestCreate(c *C) {
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
 
 	for _, test := range createTests {
 		c.Logf("Test: %s", test.summary)
@@ -414,10 +446,8 @@ var createWriterTests = []createWriterTest{{
 }}
 
 func (s *S) TestCreateWriter(c *C) {
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
 
 	for _, test := range createWriterTests {
 		if test.result == nil {
@@ -470,3 +500,71 @@ func (s *S) TestCreateEmptyRoot(c *C) {
 	_, _, err = fsutil.CreateWriter(options)
 	c.Assert(err, ErrorMatches, "internal error: CreateOptions.Root is unset")
 }
+
+func (s *S) TestCreateClone(c *C) {
+	source := filepath.Join(c.MkDir(), "source")
+	c.Assert(os.WriteFile(source, []byte("data"), 0600), IsNil)
+
+	// The content is cloned where supported, and copied from Data
+	// otherwise, with the same result.
+	dir := c.MkDir()
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Root:        dir,
+		Path:        "file",
+		Mode:        0644,
+		Data:        bytes.NewBufferString("data"),
+		CloneFrom:   source,
+		CloneSHA256: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entry.SHA256, Equals, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7")
+	c.Assert(entry.Size, Equals, 4)
+	c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+		"/file": "file 0644 3a6eb079",
+	})
+}
+
+func (s *S) TestCreateOverEscapingSymlink(c *C) {
+	outside := filepath.Join(c.MkDir(), "outside")
+	c.Assert(os.WriteFile(outside, []byte("outside"), 0644), IsNil)
+	source := filepath.Join(c.MkDir(), "source")
+	c.Assert(os.WriteFile(source, []byte("data"), 0600), IsNil)
+
+	create := func(options *fsutil.CreateOptions) error {
+		_, err := fsutil.Create(options)
+		return err
+	}
+	createWriter := func(options *fsutil.CreateOptions) error {
+		writer, _, err := fsutil.CreateWriter(options)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte("data"))
+		c.Assert(err, IsNil)
+		return writer.Close()
+	}
+	clone := func(options *fsutil.CreateOptions) error {
+		options.CloneFrom = source
+		options.CloneSHA256 = "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+		return create(options)
+	}
+	for _, createFunc := range []func(*fsutil.CreateOptions) error{create, createWriter, clone} {
+		dir := c.MkDir()
+		c.Assert(os.Symlink(outside, filepath.Join(dir, "file")), IsNil)
+		err := createFunc(&fsutil.CreateOptions{
+			Root: dir,
+			Path: "file",
+			Mode: 0644,
+			Data: bytes.NewBufferString("data"),
+		})
+		c.Assert(err, IsNil)
+
+		// The symlink is replaced and the file it pointed to is unchanged.
+		c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+			"/file": "file 0644 3a6eb079",
+		})
+		data, err := os.ReadFile(outside)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "outside")
+	}
+}
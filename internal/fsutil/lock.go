@@ -0,0 +1,47 @@
+package fsutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/fslock"
+)
+
+// lockRetryInterval is how often a busy lock is tried again.
+var lockRetryInterval = 100 * time.Millisecond
+
+// Lock takes the advisory lock on the file at path, creating the file and
+// its parent directories if needed, and returns the function releasing it.
+// While another process holds the lock, a message naming what is locked is
+// logged once and the lock is tried again until it is free or ctx is done.
+func Lock(ctx context.Context, path string, what string) (unlock func(), err error) {
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	lock := fslock.New(path)
+	waiting := false
+	for {
+		err = lock.TryLock()
+		if err == nil {
+			if waiting {
+				logf("Lock on %s acquired.", what)
+			}
+			return func() { lock.Unlock() }, nil
+		}
+		if err != fslock.ErrLocked {
+			return nil, err
+		}
+		if !waiting {
+			logf("Waiting for lock on %s...", what)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
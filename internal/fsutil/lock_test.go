@@ -0,0 +1,38 @@
+package fsutil_test
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+func (s *S) TestLock(c *C) {
+	path := filepath.Join(c.MkDir(), "locks", "root")
+
+	unlock, err := fsutil.Lock(context.Background(), path, "test")
+	c.Assert(err, IsNil)
+
+	// A busy lock is waited for until the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = fsutil.Lock(ctx, path, "test")
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	c.Assert(c.GetTestLog(), Matches, `(?s).*Waiting for lock on test\.\.\..*`)
+
+	// The lock is taken once released.
+	done := make(chan error)
+	go func() {
+		unlock, err := fsutil.Lock(context.Background(), path, "test")
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(150 * time.Millisecond)
+	unlock()
+	c.Assert(<-done, IsNil)
+}
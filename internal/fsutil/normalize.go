@@ -0,0 +1,84 @@
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// NormalizeOptions describes how the permissions of the paths in a tree
+// are normalized, such as for images with hardened policies.
+type NormalizeOptions struct {
+	// Umask clears the permission bits set in it, as in 022 to remove
+	// the write permission of the group and others.
+	Umask fs.FileMode
+	// StripSetuid clears the setuid and setgid bits.
+	StripSetuid bool
+}
+
+// Mode returns mode normalized according to the options. Symlinks are
+// left as they are, as their permissions are not used.
+func (o *NormalizeOptions) Mode(mode fs.FileMode) fs.FileMode {
+	if o == nil || mode&fs.ModeSymlink != 0 {
+		return mode
+	}
+	mode &^= o.Umask & fs.ModePerm
+	if o.StripSetuid {
+		mode &^= fs.ModeSetuid | fs.ModeSetgid
+	}
+	return mode
+}
+
+// Normalize applies the options to the permissions of all the files and
+// directories under root, other than root itself. It returns the modes of
+// the paths changed, indexed by their path relative to root, as in
+// "/usr/bin/" for directories and "/usr/bin/su" for other entries.
+func Normalize(root string, options *NormalizeOptions) (map[string]fs.FileMode, error) {
+	type change struct {
+		path string
+		mode fs.FileMode
+	}
+	var changes []change
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := options.Mode(info.Mode())
+		if mode != info.Mode() {
+			changes = append(changes, change{path, mode})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	modes := make(map[string]fs.FileMode, len(changes))
+	// Directories are changed after their content, so that they remain
+	// accessible while the content is changed.
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		debugf("Normalizing mode of %s: %s", c.path, c.mode)
+		err := os.Chmod(c.path, c.mode)
+		if err != nil {
+			return nil, err
+		}
+		relPath, err := filepath.Rel(root, c.path)
+		if err != nil {
+			return nil, err
+		}
+		relPath = "/" + filepath.ToSlash(relPath)
+		if c.mode.IsDir() {
+			relPath += "/"
+		}
+		modes[relPath] = c.mode
+	}
+	return modes, nil
+}
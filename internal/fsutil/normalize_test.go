@@ -0,0 +1,102 @@
+package fsutil_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var normalizeTests = []struct {
+	summary string
+	options fsutil.NormalizeOptions
+	result  map[string]string
+	changed map[string]fs.FileMode
+}{{
+	summary: "Nothing to normalize",
+	result: map[string]string{
+		"/dir/":         "dir 0777",
+		"/dir/file":     "file 0666 empty",
+		"/dir/link":     "symlink file",
+		"/dir/setuid":   "file 0755 empty",
+		"/dir/setgid":   "file 0755 empty",
+		"/dir/private/": "dir 0700",
+	},
+	changed: map[string]fs.FileMode{},
+}, {
+	summary: "Apply umask",
+	options: fsutil.NormalizeOptions{Umask: 022},
+	result: map[string]string{
+		"/dir/":         "dir 0755",
+		"/dir/file":     "file 0644 empty",
+		"/dir/link":     "symlink file",
+		"/dir/setuid":   "file 0755 empty",
+		"/dir/setgid":   "file 0755 empty",
+		"/dir/private/": "dir 0700",
+	},
+	changed: map[string]fs.FileMode{
+		"/dir/":     fs.ModeDir | 0755,
+		"/dir/file": 0644,
+	},
+}, {
+	// The setuid and setgid bits are not part of tree dumps.
+	summary: "Strip setuid and setgid bits",
+	options: fsutil.NormalizeOptions{StripSetuid: true},
+	result: map[string]string{
+		"/dir/":         "dir 0777",
+		"/dir/file":     "file 0666 empty",
+		"/dir/link":     "symlink file",
+		"/dir/setuid":   "file 0755 empty",
+		"/dir/setgid":   "file 0755 empty",
+		"/dir/private/": "dir 0700",
+	},
+	changed: map[string]fs.FileMode{
+		"/dir/setuid": 0755,
+		"/dir/setgid": 0755,
+	},
+}, {
+	summary: "Directories are changed after their content",
+	options: fsutil.NormalizeOptions{Umask: 0777},
+	changed: map[string]fs.FileMode{
+		"/dir/":         fs.ModeDir,
+		"/dir/file":     0,
+		"/dir/setuid":   fs.ModeSetuid,
+		"/dir/setgid":   fs.ModeSetgid,
+		"/dir/private/": fs.ModeDir,
+	},
+}}
+
+func (s *S) TestNormalize(c *C) {
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
+
+	for _, test := range normalizeTests {
+		c.Logf("Summary: %s", test.summary)
+		root := c.MkDir()
+		dir := filepath.Join(root, "dir")
+		c.Assert(os.Mkdir(dir, 0777), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(dir, "file"), nil, 0666), IsNil)
+		c.Assert(os.Symlink("file", filepath.Join(dir, "link")), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(dir, "setuid"), nil, 0755), IsNil)
+		c.Assert(os.Chmod(filepath.Join(dir, "setuid"), fs.ModeSetuid|0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(dir, "setgid"), nil, 0755), IsNil)
+		c.Assert(os.Chmod(filepath.Join(dir, "setgid"), fs.ModeSetgid|0755), IsNil)
+		c.Assert(os.Mkdir(filepath.Join(dir, "private"), 0700), IsNil)
+
+		changed, err := fsutil.Normalize(root, &test.options)
+		c.Assert(err, IsNil)
+		c.Assert(changed, DeepEquals, test.changed)
+
+		if test.result == nil {
+			// Restore access to the tree so that it may be removed.
+			c.Assert(os.Chmod(dir, 0700), IsNil)
+			c.Assert(os.Chmod(filepath.Join(dir, "private"), 0700), IsNil)
+			continue
+		}
+		c.Assert(testutil.TreeDump(root), DeepEquals, test.result)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl, which makes a file share the content of
+// another one.
+const ficlone = 0x40049409
+
+// Reflink makes the existing file at path share the content of the one at
+// source as a copy-on-write clone, keeping its own mode and owner. The error
+// matches errors.ErrUnsupported when the filesystem cannot clone files.
+func Reflink(source, path string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), ficlone, sourceFile.Fd())
+	switch errno {
+	case 0:
+		return file.Close()
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EINVAL, syscall.EXDEV:
+		return fmt.Errorf("cannot reflink %s: %w", path, errors.ErrUnsupported)
+	}
+	return fmt.Errorf("cannot reflink %s: %w", path, errno)
+}
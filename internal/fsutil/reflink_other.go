@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reflink reports cloning files as unsupported, as it is only implemented
+// on Linux.
+func Reflink(source, path string) error {
+	return fmt.Errorf("cannot reflink %s: %w", path, errors.ErrUnsupported)
+}
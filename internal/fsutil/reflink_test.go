@@ -0,0 +1,37 @@
+package fsutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+func (s *S) TestReflink(c *C) {
+	dir := c.MkDir()
+	source := filepath.Join(dir, "source")
+	path := filepath.Join(dir, "path")
+	c.Assert(os.WriteFile(source, []byte("data"), 0644), IsNil)
+	c.Assert(os.WriteFile(path, []byte("data"), 0600), IsNil)
+
+	err := fsutil.Reflink(source, path)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.Skip("filesystem cannot clone files")
+	}
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *S) TestReflinkMissing(c *C) {
+	dir := c.MkDir()
+	err := fsutil.Reflink(filepath.Join(dir, "source"), filepath.Join(dir, "path"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package fsutil
+
+import (
+	"io/fs"
+)
+
+// oNoFollow is not supported on this system, where symlinks are removed
+// before opening files instead.
+const oNoFollow = 0
+
+// ClearUmask does nothing, as there is no umask on this system.
+func ClearUmask() (restore func()) {
+	return func() {}
+}
+
+// Inode reports the inode of the entry as unknown, as it is not exposed on
+// this system. Hard links are then seen as independent files.
+func Inode(info fs.FileInfo) (ino uint64, nlink uint64, ok bool) {
+	return 0, 0, false
+}
+
+// Owner reports the owner of the entry as unknown, as it is not exposed on
+// this system.
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// Device reports the device holding the entry as unknown, as it is not
+// exposed on this system.
+func Device(info fs.FileInfo) (dev uint64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package fsutil
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// oNoFollow makes opening a file fail when it is a symlink.
+const oNoFollow = syscall.O_NOFOLLOW
+
+// ClearUmask clears the umask of the process, so that entries are created
+// with the exact modes requested, and returns a function restoring it.
+func ClearUmask() (restore func()) {
+	oldUmask := syscall.Umask(0)
+	return func() {
+		syscall.Umask(oldUmask)
+	}
+}
+
+// Inode returns the inode number and the number of links of the entry
+// described by info, and whether they are known.
+func Inode(info fs.FileInfo) (ino uint64, nlink uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Ino), uint64(stat.Nlink), true
+}
+
+// Owner returns the user and group owning the entry described by info, and
+// whether they are known.
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// Device returns the device holding the entry described by info, and
+// whether it is known.
+func Device(info fs.FileInfo) (dev uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
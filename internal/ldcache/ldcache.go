@@ -0,0 +1,453 @@
+// Package ldcache implements the generation of the cache used by the
+// dynamic linker to look shared libraries up, which ldconfig would otherwise
+// create.
+package ldcache
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CachePath is the location of the cache relative to the root.
+const CachePath = "/etc/ld.so.cache"
+
+const confPath = "/etc/ld.so.conf"
+
+// builtinDirs are searched after the directories listed in ld.so.conf, as
+// ldconfig does with its trusted directories.
+var builtinDirs = []string{
+	"/lib/x86_64-linux-gnu",
+	"/usr/lib/x86_64-linux-gnu",
+	"/lib/i386-linux-gnu",
+	"/usr/lib/i386-linux-gnu",
+	"/lib/aarch64-linux-gnu",
+	"/usr/lib/aarch64-linux-gnu",
+	"/lib/arm-linux-gnueabihf",
+	"/usr/lib/arm-linux-gnueabihf",
+	"/lib/powerpc64le-linux-gnu",
+	"/usr/lib/powerpc64le-linux-gnu",
+	"/lib/riscv64-linux-gnu",
+	"/usr/lib/riscv64-linux-gnu",
+	"/lib/s390x-linux-gnu",
+	"/usr/lib/s390x-linux-gnu",
+	"/lib",
+	"/usr/lib",
+}
+
+// Lib is a shared library found in one of the library directories.
+type Lib struct {
+	// Soname is the name under which the library is looked up.
+	Soname string
+	// Path is the path of the library relative to the root, which is the
+	// soname in the directory where the library was found.
+	Path string
+	// Flags identifies the type and ABI of the library.
+	Flags int32
+	order binary.ByteOrder
+}
+
+// Flags as defined by glibc for libraries in the cache.
+const (
+	flagELFLibc6         = 0x0003
+	flagX8664Lib64       = 0x0300
+	flagS390Lib64        = 0x0400
+	flagPowerPCLib64     = 0x0500
+	flagX8664LibX32      = 0x0800
+	flagARMLibHF         = 0x0900
+	flagAArch64Lib64     = 0x0a00
+	flagARMLibSF         = 0x0b00
+	flagRISCVFloatSoft   = 0x0f00
+	flagRISCVFloatDouble = 0x1000
+)
+
+// Find returns the shared libraries found in the directories listed in
+// the ld.so.conf of rootDir and in the trusted directories, ordered as they
+// must appear in the cache. Directories are only considered once, even when
+// reached via different paths. The soname links are not created, so
+// libraries are only considered if the link is present.
+func Find(rootDir string) ([]*Lib, error) {
+	dirs, err := readConf(rootDir, confPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	dirs = append(dirs, builtinDirs...)
+
+	var libs []*Lib
+	seenDirs := make(map[string]bool)
+	for _, dir := range dirs {
+		realDir, err := resolve(rootDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		if realDir == "" || seenDirs[realDir] {
+			continue
+		}
+		seenDirs[realDir] = true
+		dirLibs, err := findInDir(rootDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, dirLibs...)
+	}
+	// The dynamic linker does a binary search expecting the entries to be
+	// in descending order. For entries with the same soname, the first one
+	// found takes precedence.
+	slices.SortStableFunc(libs, func(a, b *Lib) int {
+		return libcmp(b.Soname, a.Soname)
+	})
+	return libs, nil
+}
+
+func findInDir(rootDir, dir string) ([]*Lib, error) {
+	realDir, err := resolve(rootDir, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(rootDir, realDir))
+	if err != nil {
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var libs []*Lib
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isDSO(name) || entry.Type()&^(fs.ModeSymlink) != 0 {
+			continue
+		}
+		lib, err := readLib(rootDir, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if lib == nil {
+			continue
+		}
+		// As done by ldconfig, links named after the soname or development
+		// links such as libfoo.so, pointing to libfoo.so.1, are cached
+		// under their own name.
+		if entry.Type() == fs.ModeSymlink && (name == lib.Soname ||
+			strings.HasSuffix(name, ".so") && strings.HasPrefix(lib.Soname, name)) {
+			lib.Soname = name
+		}
+		key := fmt.Sprintf("%s %d", lib.Soname, lib.Flags)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		lib.Path = path.Join(dir, lib.Soname)
+		realPath, err := resolve(rootDir, lib.Path)
+		if err != nil {
+			return nil, err
+		}
+		if realPath == "" {
+			continue
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// isDSO reports whether the file name looks like a shared library.
+func isDSO(name string) bool {
+	return (strings.HasPrefix(name, "lib") || strings.HasPrefix(name, "ld-")) &&
+		strings.Contains(name, ".so")
+}
+
+// readLib returns the library at relPath, or nil if it is not a shared
+// library that can be cached.
+func readLib(rootDir, relPath string) (*Lib, error) {
+	realPath, err := resolve(rootDir, relPath)
+	if err != nil || realPath == "" {
+		return nil, err
+	}
+	osFile, err := os.Open(filepath.Join(rootDir, realPath))
+	if err != nil {
+		return nil, err
+	}
+	defer osFile.Close()
+	file, err := elf.NewFile(osFile)
+	if err != nil {
+		// Linker scripts, broken files, directories, etc.
+		return nil, nil
+	}
+	if file.Type != elf.ET_DYN {
+		return nil, nil
+	}
+	// The processor specific flags are not exposed by debug/elf.
+	var eflags [4]byte
+	eflagsOffset := int64(36)
+	if file.Class == elf.ELFCLASS64 {
+		eflagsOffset = 48
+	}
+	_, err = osFile.ReadAt(eflags[:], eflagsOffset)
+	if err != nil {
+		return nil, nil
+	}
+	flags, ok := libFlags(&file.FileHeader, file.ByteOrder.Uint32(eflags[:]))
+	if !ok {
+		return nil, nil
+	}
+	soname := path.Base(relPath)
+	if sonames, err := file.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+		soname = sonames[0]
+	}
+	return &Lib{Soname: soname, Flags: flags, order: file.ByteOrder}, nil
+}
+
+// ELF processor specific flags used to tell ABIs apart.
+const (
+	efARMABIFloatSoft   = 0x200
+	efARMABIFloatHard   = 0x400
+	efRISCVFloatABIMask = 0x6
+	efRISCVFloatSoft    = 0x0
+	efRISCVFloatDouble  = 0x4
+)
+
+func libFlags(header *elf.FileHeader, eflags uint32) (int32, bool) {
+	is64 := header.Class == elf.ELFCLASS64
+	switch header.Machine {
+	case elf.EM_X86_64:
+		if is64 {
+			return flagELFLibc6 | flagX8664Lib64, true
+		}
+		return flagELFLibc6 | flagX8664LibX32, true
+	case elf.EM_386:
+		return flagELFLibc6, true
+	case elf.EM_AARCH64:
+		return flagELFLibc6 | flagAArch64Lib64, true
+	case elf.EM_ARM:
+		switch {
+		case eflags&efARMABIFloatHard != 0:
+			return flagELFLibc6 | flagARMLibHF, true
+		case eflags&efARMABIFloatSoft != 0:
+			return flagELFLibc6 | flagARMLibSF, true
+		}
+		return flagELFLibc6, true
+	case elf.EM_PPC64:
+		return flagELFLibc6 | flagPowerPCLib64, true
+	case elf.EM_S390:
+		if is64 {
+			return flagELFLibc6 | flagS390Lib64, true
+		}
+		return flagELFLibc6, true
+	case elf.EM_RISCV:
+		if !is64 {
+			return 0, false
+		}
+		switch eflags & efRISCVFloatABIMask {
+		case efRISCVFloatSoft:
+			return flagELFLibc6 | flagRISCVFloatSoft, true
+		case efRISCVFloatDouble:
+			return flagELFLibc6 | flagRISCVFloatDouble, true
+		}
+	}
+	return 0, false
+}
+
+// readConf returns the directories listed in the configuration file at
+// confPath, following include directives.
+func readConf(rootDir, confPath string, depth int) ([]string, error) {
+	if depth > 10 {
+		return nil, fmt.Errorf("cannot read %s: too many levels of includes", confPath)
+	}
+	realPath, err := resolve(rootDir, confPath)
+	if err != nil || realPath == "" {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(rootDir, realPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ':'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				if !path.IsAbs(pattern) {
+					pattern = path.Join(path.Dir(confPath), pattern)
+				}
+				matches, err := filepath.Glob(filepath.Join(rootDir, pattern))
+				if err != nil {
+					return nil, fmt.Errorf("cannot read %s: %w", confPath, err)
+				}
+				slices.Sort(matches)
+				for _, match := range matches {
+					relPath := "/" + filepath.ToSlash(strings.TrimPrefix(match, filepath.Clean(rootDir)+string(filepath.Separator)))
+					included, err := readConf(rootDir, relPath, depth+1)
+					if err != nil {
+						return nil, err
+					}
+					dirs = append(dirs, included...)
+				}
+			}
+		case "hwcap":
+			// Obsolete and ignored by recent versions of ldconfig.
+		default:
+			for _, dir := range fields {
+				// Library type suffixes such as "=libc6" are ignored.
+				dir, _, _ = strings.Cut(dir, "=")
+				if path.IsAbs(dir) {
+					dirs = append(dirs, path.Clean(dir))
+				}
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// resolve returns the path of relPath with all the symlinks resolved
+// within rootDir, or an empty string if the path does not exist.
+func resolve(rootDir, relPath string) (string, error) {
+	resolved := "/"
+	pending := strings.Split(strings.Trim(path.Clean(relPath), "/"), "/")
+	hops := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(rootDir, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		hops++
+		if hops > 40 {
+			return "", fmt.Errorf("cannot resolve %s: too many levels of symbolic links", relPath)
+		}
+		target, err := os.Readlink(filepath.Join(rootDir, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// libcmp compares library names as the dynamic linker does, with sequences
+// of digits compared numerically.
+func libcmp(a, b string) int {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	i, j := 0, 0
+	for i < len(a) {
+		switch {
+		case isDigit(a[i]):
+			if j >= len(b) || !isDigit(b[j]) {
+				return 1
+			}
+			var va, vb int
+			for ; i < len(a) && isDigit(a[i]); i++ {
+				va = va*10 + int(a[i]-'0')
+			}
+			for ; j < len(b) && isDigit(b[j]); j++ {
+				vb = vb*10 + int(b[j]-'0')
+			}
+			if va != vb {
+				return va - vb
+			}
+		case j < len(b) && isDigit(b[j]):
+			return -1
+		case j >= len(b):
+			return 1
+		case a[i] != b[j]:
+			return int(a[i]) - int(b[j])
+		default:
+			i++
+			j++
+		}
+	}
+	if j < len(b) {
+		return -1
+	}
+	return 0
+}
+
+const (
+	cacheMagic       = "glibc-ld.so.cache1.1"
+	headerSize       = 48
+	entrySize        = 24
+	flagLittleEndian = 2
+	flagBigEndian    = 3
+)
+
+// Cache returns the content of the cache listing the libraries, which must
+// be ordered as returned by Find. Only the current cache format is
+// generated, with the byte order of the libraries.
+func Cache(libs []*Lib) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if len(libs) > 0 && libs[0].order != nil {
+		order = libs[0].order
+	}
+
+	// String offsets are relative to the start of the cache.
+	stringsStart := headerSize + len(libs)*entrySize
+	var stringTable bytes.Buffer
+	offsets := make(map[string]uint32)
+	addString := func(s string) uint32 {
+		if offset, ok := offsets[s]; ok {
+			return offset
+		}
+		offset := uint32(stringsStart + stringTable.Len())
+		stringTable.WriteString(s)
+		stringTable.WriteByte(0)
+		offsets[s] = offset
+		return offset
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(cacheMagic)
+	endianFlag := byte(flagLittleEndian)
+	if order == binary.BigEndian {
+		endianFlag = flagBigEndian
+	}
+	var header [headerSize - len(cacheMagic)]byte
+	order.PutUint32(header[0:], uint32(len(libs)))
+	header[8] = endianFlag
+	entries := make([]byte, len(libs)*entrySize)
+	for i, lib := range libs {
+		entry := entries[i*entrySize:]
+		order.PutUint32(entry[0:], uint32(lib.Flags))
+		order.PutUint32(entry[4:], addString(lib.Soname))
+		order.PutUint32(entry[8:], addString(lib.Path))
+	}
+	order.PutUint32(header[4:], uint32(stringTable.Len()))
+	buf.Write(header[:])
+	buf.Write(entries)
+	buf.Write(stringTable.Bytes())
+	return buf.Bytes()
+}
@@ -0,0 +1,118 @@
+package ldcache_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/ldcache"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var amd64Dir = "/usr/lib/x86_64-linux-gnu/"
+
+var libsFiles = map[string]string{
+	"/etc/ld.so.conf":                 "include /etc/ld.so.conf.d/*.conf\n",
+	"/etc/ld.so.conf.d/amd64.conf":    "# Multiarch support\n/usr/local/lib\n/lib/x86_64-linux-gnu\n/usr/lib/x86_64-linux-gnu\n",
+	amd64Dir + "libfoo.so.1.2":        string(testutil.MakeSharedLib(elf.EM_X86_64, "libfoo.so.1")),
+	amd64Dir + "libbar.so.9":          string(testutil.MakeSharedLib(elf.EM_X86_64, "libbar.so.9")),
+	amd64Dir + "libbar.so.10":         string(testutil.MakeSharedLib(elf.EM_X86_64, "libbar.so.10")),
+	amd64Dir + "ld-linux-x86-64.so.2": string(testutil.MakeSharedLib(elf.EM_X86_64, "")),
+	// Not an ELF file.
+	amd64Dir + "libc.so": "/* GNU ld script */",
+	// Missing soname link.
+	amd64Dir + "libnolink.so.2.0": string(testutil.MakeSharedLib(elf.EM_X86_64, "libnolink.so.2")),
+	// Found via the /lib symlink.
+	"/usr/lib/libarm.so.1": string(testutil.MakeSharedLib(elf.EM_AARCH64, "libarm.so.1")),
+	// Not a library name.
+	amd64Dir + "notalib.so": string(testutil.MakeSharedLib(elf.EM_X86_64, "notalib.so")),
+}
+
+var libsLinks = map[string]string{
+	"/lib":                   "usr/lib",
+	amd64Dir + "libfoo.so.1": "libfoo.so.1.2",
+	amd64Dir + "libfoo.so":   "libfoo.so.1",
+}
+
+func makeRoot(c *C) string {
+	rootDir := c.MkDir()
+	for path, data := range libsFiles {
+		fpath := filepath.Join(rootDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	for path, target := range libsLinks {
+		err := os.Symlink(target, filepath.Join(rootDir, path))
+		c.Assert(err, IsNil)
+	}
+	return rootDir
+}
+
+type cacheEntry struct {
+	soname string
+	path   string
+	flags  int32
+}
+
+var expectedEntries = []cacheEntry{
+	{"libfoo.so.1", "/lib/x86_64-linux-gnu/libfoo.so.1", 0x0303},
+	{"libfoo.so", "/lib/x86_64-linux-gnu/libfoo.so", 0x0303},
+	{"libbar.so.10", "/lib/x86_64-linux-gnu/libbar.so.10", 0x0303},
+	{"libbar.so.9", "/lib/x86_64-linux-gnu/libbar.so.9", 0x0303},
+	{"libarm.so.1", "/lib/libarm.so.1", 0x0a03},
+	{"ld-linux-x86-64.so.2", "/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2", 0x0303},
+}
+
+func (s *S) TestFind(c *C) {
+	rootDir := makeRoot(c)
+	libs, err := ldcache.Find(rootDir)
+	c.Assert(err, IsNil)
+	var entries []cacheEntry
+	for _, lib := range libs {
+		entries = append(entries, cacheEntry{lib.Soname, lib.Path, lib.Flags})
+	}
+	c.Assert(entries, DeepEquals, expectedEntries)
+}
+
+func (s *S) TestFindEmpty(c *C) {
+	libs, err := ldcache.Find(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(libs, HasLen, 0)
+}
+
+func (s *S) TestCache(c *C) {
+	rootDir := makeRoot(c)
+	libs, err := ldcache.Find(rootDir)
+	c.Assert(err, IsNil)
+	data := ldcache.Cache(libs)
+
+	c.Assert(string(data[:20]), Equals, "glibc-ld.so.cache1.1")
+	order := binary.LittleEndian
+	nlibs := int(order.Uint32(data[20:]))
+	lenStrings := int(order.Uint32(data[24:]))
+	c.Assert(data[28], Equals, byte(2))
+	c.Assert(nlibs, Equals, len(expectedEntries))
+	c.Assert(len(data), Equals, 48+nlibs*24+lenStrings)
+
+	readString := func(offset uint32) string {
+		end := bytes.IndexByte(data[offset:], 0)
+		c.Assert(end >= 0, Equals, true)
+		return string(data[offset : int(offset)+end])
+	}
+	var entries []cacheEntry
+	for i := 0; i < nlibs; i++ {
+		entry := data[48+i*24:]
+		entries = append(entries, cacheEntry{
+			flags:  int32(order.Uint32(entry[0:])),
+			soname: readString(order.Uint32(entry[4:])),
+			path:   readString(order.Uint32(entry[8:])),
+		})
+	}
+	c.Assert(entries, DeepEquals, expectedEntries)
+}
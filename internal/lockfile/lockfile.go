@@ -0,0 +1,231 @@
+// Package lockfile implements reading and writing lockfiles, which record
+// the inputs used to cut a tree so that the same inputs may be enforced
+// later on.
+package lockfile
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+const Format = "v1"
+
+// DefaultName is the name of the lockfile when none is provided.
+const DefaultName = "chisel.lock"
+
+type Lockfile struct {
+	Format   string              `yaml:"format"`
+	Release  Release             `yaml:"release"`
+	Archives map[string]*Archive `yaml:"archives"`
+	Slices   []string            `yaml:"slices"`
+	Packages []*Package          `yaml:"packages"`
+}
+
+type Release struct {
+	// Name is the reference the release was obtained with, if any.
+	Name string `yaml:"name,omitempty"`
+	// Digest is the SHA256 of the files defining the release.
+	Digest string `yaml:"digest"`
+	// Commit is the commit of the release repository the release was
+	// obtained from, when known.
+	Commit string `yaml:"commit,omitempty"`
+}
+
+// Archive identifies the content of an archive. Packages are looked up in
+// the listed suites and components.
+type Archive struct {
+	Version    string   `yaml:"version"`
+	Suites     []string `yaml:"suites"`
+	Components []string `yaml:"components"`
+	Pro        string   `yaml:"pro,omitempty"`
+	// Mirror is the location the archive was fetched from when a mirror
+	// was selected instead of its default location.
+	Mirror string `yaml:"mirror,omitempty"`
+}
+
+type Package struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Arch    string `yaml:"arch"`
+	SHA256  string `yaml:"sha256"`
+	Archive string `yaml:"archive"`
+	// Suite and Component identify where in the archive the package was
+	// found, when the archive has them.
+	Suite     string `yaml:"suite,omitempty"`
+	Component string `yaml:"component,omitempty"`
+}
+
+// New returns a lockfile with the release information and the selected
+// slices. The commit of the release is recorded when not empty. Packages
+// are added with AddPackage as they are fetched.
+func New(name, commit string, selection *setup.Selection) (*Lockfile, error) {
+	digest, err := setup.ReleaseDigest(selection.Release.Path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lockfile{
+		Format: Format,
+		Release: Release{
+			Name:   name,
+			Digest: digest,
+			Commit: commit,
+		},
+		Archives: make(map[string]*Archive),
+	}
+	for _, slice := range selection.Slices {
+		lock.Slices = append(lock.Slices, slice.String())
+	}
+	slices.Sort(lock.Slices)
+	return lock, nil
+}
+
+// AddPackage records the package fetched from the named archive of the
+// release.
+func (l *Lockfile) AddPackage(release *setup.Release, archiveName string, info *archive.PackageInfo) {
+	if releaseArchive, ok := release.Archives[archiveName]; ok {
+		l.Archives[archiveName] = &Archive{
+			Version:    releaseArchive.Version,
+			Suites:     releaseArchive.Suites,
+			Components: releaseArchive.Components,
+			Pro:        releaseArchive.Pro,
+		}
+	}
+	l.Packages = append(l.Packages, &Package{
+		Name:      info.Name,
+		Version:   info.Version,
+		Arch:      info.Arch,
+		SHA256:    info.SHA256,
+		Archive:   archiveName,
+		Suite:     info.Suite,
+		Component: info.Component,
+	})
+	slices.SortFunc(l.Packages, func(a, b *Package) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// Package returns the package with the given name, or nil if it is not
+// recorded.
+func (l *Lockfile) Package(name string) *Package {
+	for _, pkg := range l.Packages {
+		if pkg.Name == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// CheckSelection returns an error if the release, its commit when both
+// are known, the archives or the slices selected differ from the ones
+// recorded, or if packages are recorded which the selection does not use.
+func (l *Lockfile) CheckSelection(selection *setup.Selection, commit string) error {
+	digest, err := setup.ReleaseDigest(selection.Release.Path)
+	if err != nil {
+		return err
+	}
+	if digest != l.Release.Digest {
+		return fmt.Errorf("release content differs from lockfile")
+	}
+	if commit != "" && l.Release.Commit != "" && commit != l.Release.Commit {
+		return fmt.Errorf("release commit differs from lockfile: %s != %s", commit, l.Release.Commit)
+	}
+	for _, archiveName := range slices.Sorted(maps.Keys(l.Archives)) {
+		lockArchive := l.Archives[archiveName]
+		releaseArchive, ok := selection.Release.Archives[archiveName]
+		if !ok {
+			return fmt.Errorf("archive %q not found in release", archiveName)
+		}
+		switch {
+		case releaseArchive.Version != lockArchive.Version:
+			return fmt.Errorf("archive %q version differs from lockfile: %s != %s", archiveName, releaseArchive.Version, lockArchive.Version)
+		case !slices.Equal(releaseArchive.Suites, lockArchive.Suites):
+			return fmt.Errorf("archive %q suites differ from lockfile: %s != %s", archiveName,
+				strings.Join(releaseArchive.Suites, ", "), strings.Join(lockArchive.Suites, ", "))
+		case !slices.Equal(releaseArchive.Components, lockArchive.Components):
+			return fmt.Errorf("archive %q components differ from lockfile: %s != %s", archiveName,
+				strings.Join(releaseArchive.Components, ", "), strings.Join(lockArchive.Components, ", "))
+		case releaseArchive.Pro != lockArchive.Pro:
+			return fmt.Errorf("archive %q pro value differs from lockfile: %q != %q", archiveName, releaseArchive.Pro, lockArchive.Pro)
+		}
+	}
+	var selected []string
+	used := make(map[string]bool)
+	for _, slice := range selection.Slices {
+		selected = append(selected, slice.String())
+		used[slice.Package] = true
+	}
+	slices.Sort(selected)
+	if !slices.Equal(selected, l.Slices) {
+		return fmt.Errorf("selected slices differ from lockfile: %s != %s",
+			strings.Join(selected, ", "), strings.Join(l.Slices, ", "))
+	}
+	for _, pkg := range l.Packages {
+		if !used[pkg.Name] {
+			return fmt.Errorf("package %q in lockfile is not used by the selection", pkg.Name)
+		}
+	}
+	return nil
+}
+
+// CheckPackage returns an error if the package about to be fetched from
+// the named archive differs from the one recorded.
+func (l *Lockfile) CheckPackage(archiveName string, info *archive.PackageInfo) error {
+	pkg := l.Package(info.Name)
+	if pkg == nil {
+		return fmt.Errorf("package %q not found in lockfile", info.Name)
+	}
+	switch {
+	case pkg.Archive != archiveName:
+		return fmt.Errorf("package %q archive differs from lockfile: %s != %s", info.Name, archiveName, pkg.Archive)
+	case pkg.Suite != info.Suite:
+		return fmt.Errorf("package %q suite differs from lockfile: %s != %s", info.Name, info.Suite, pkg.Suite)
+	case pkg.Component != info.Component:
+		return fmt.Errorf("package %q component differs from lockfile: %s != %s", info.Name, info.Component, pkg.Component)
+	case pkg.Version != info.Version:
+		return fmt.Errorf("package %q version differs from lockfile: %s != %s", info.Name, info.Version, pkg.Version)
+	case pkg.Arch != info.Arch:
+		return fmt.Errorf("package %q architecture differs from lockfile: %s != %s", info.Name, info.Arch, pkg.Arch)
+	case pkg.SHA256 != info.SHA256:
+		return fmt.Errorf("package %q hash differs from lockfile: %s != %s", info.Name, info.SHA256, pkg.SHA256)
+	}
+	return nil
+}
+
+func Read(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lockfile: %w", err)
+	}
+	lock := &Lockfile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(lock)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse lockfile %s: %w", path, err)
+	}
+	if lock.Format != Format {
+		return nil, fmt.Errorf("cannot parse lockfile %s: unknown format %q", path, lock.Format)
+	}
+	return lock, nil
+}
+
+func Write(path string, lock *Lockfile) error {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write lockfile: %w", err)
+	}
+	return nil
+}
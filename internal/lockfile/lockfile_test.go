@@ -0,0 +1,209 @@
+package lockfile_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/lockfile"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var testRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/mypkg.yaml": `
+		package: mypkg
+		essential:
+			- mypkg_base
+		slices:
+			base:
+				contents:
+					/etc/base:
+			app:
+				contents:
+					/usr/bin/app:
+	`,
+}
+
+func writeRelease(c *C, files map[string]string) string {
+	dir := c.MkDir()
+	for path, data := range files {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	return dir
+}
+
+func selectSlices(c *C, releaseDir string, refs ...string) *setup.Selection {
+	release, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	var keys []setup.SliceKey
+	for _, ref := range refs {
+		key, err := setup.ParseSliceKey(ref)
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+	}
+	selection, err := setup.Select(release, keys, "")
+	c.Assert(err, IsNil)
+	return selection
+}
+
+var testInfo = &archive.PackageInfo{
+	Name:      "mypkg",
+	Version:   "1.0",
+	Arch:      "amd64",
+	SHA256:    "e2bd07ec8f9dc3b1d6c6a2ae9a6a8cdab1f0bbfe60b51b5f3b0fa81c2b5b4d12",
+	Suite:     "jammy",
+	Component: "main",
+}
+
+func (s *S) TestWriteRead(c *C) {
+	releaseDir := writeRelease(c, testRelease)
+	selection := selectSlices(c, releaseDir, "mypkg_app")
+
+	lock, err := lockfile.New("ubuntu-22.04", "0123abcd", selection)
+	c.Assert(err, IsNil)
+	lock.AddPackage(selection.Release, "ubuntu", testInfo)
+
+	path := filepath.Join(c.MkDir(), "chisel.lock")
+	err = lockfile.Write(path, lock)
+	c.Assert(err, IsNil)
+
+	read, err := lockfile.Read(path)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, lock)
+	c.Assert(read.Format, Equals, "v1")
+	c.Assert(read.Release.Name, Equals, "ubuntu-22.04")
+	c.Assert(read.Release.Commit, Equals, "0123abcd")
+	c.Assert(read.Slices, DeepEquals, []string{"mypkg_app", "mypkg_base"})
+	c.Assert(read.Archives, DeepEquals, map[string]*lockfile.Archive{
+		"ubuntu": {
+			Version:    "22.04",
+			Suites:     []string{"jammy"},
+			Components: []string{"main", "universe"},
+		},
+	})
+	c.Assert(read.Packages, DeepEquals, []*lockfile.Package{{
+		Name:      "mypkg",
+		Version:   "1.0",
+		Arch:      "amd64",
+		SHA256:    testInfo.SHA256,
+		Archive:   "ubuntu",
+		Suite:     "jammy",
+		Component: "main",
+	}})
+}
+
+func (s *S) TestReadErrors(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "chisel.lock")
+
+	_, err := lockfile.Read(path)
+	c.Assert(err, ErrorMatches, "cannot read lockfile: .*: no such file or directory")
+
+	err = os.WriteFile(path, []byte("format: v0\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = lockfile.Read(path)
+	c.Assert(err, ErrorMatches, `cannot parse lockfile .*: unknown format "v0"`)
+
+	err = os.WriteFile(path, []byte("format: v1\nfoo: bar\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = lockfile.Read(path)
+	c.Assert(err, ErrorMatches, `(?s)cannot parse lockfile .*: yaml: unmarshal errors:.*field foo not found.*`)
+}
+
+func (s *S) TestCheckSelection(c *C) {
+	releaseDir := writeRelease(c, testRelease)
+	selection := selectSlices(c, releaseDir, "mypkg_app")
+	lock, err := lockfile.New("", "0123abcd", selection)
+	c.Assert(err, IsNil)
+	lock.AddPackage(selection.Release, "ubuntu", testInfo)
+
+	err = lock.CheckSelection(selection, "0123abcd")
+	c.Assert(err, IsNil)
+
+	// The commit is only compared when known.
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, IsNil)
+	err = lock.CheckSelection(selection, "4567cdef")
+	c.Assert(err, ErrorMatches, "release commit differs from lockfile: 4567cdef != 0123abcd")
+
+	other := selectSlices(c, releaseDir, "mypkg_base")
+	err = lock.CheckSelection(other, "")
+	c.Assert(err, ErrorMatches, "selected slices differ from lockfile: mypkg_base != mypkg_app, mypkg_base")
+
+	lock.Archives["ubuntu"].Suites = []string{"jammy", "jammy-updates"}
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, ErrorMatches, `archive "ubuntu" suites differ from lockfile: jammy != jammy, jammy-updates`)
+	lock.Archives["ubuntu"].Suites = []string{"jammy"}
+	lock.Archives["ubuntu"].Components = []string{"main"}
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, ErrorMatches, `archive "ubuntu" components differ from lockfile: main, universe != main`)
+	lock.Archives["ubuntu"].Components = []string{"main", "universe"}
+
+	lock.AddPackage(selection.Release, "ubuntu", &archive.PackageInfo{Name: "unused"})
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, ErrorMatches, `package "unused" in lockfile is not used by the selection`)
+	lock.Packages = lock.Packages[:1]
+
+	// Hidden files, such as the cache tag of fetched releases, are ignored.
+	err = os.WriteFile(filepath.Join(releaseDir, ".etag"), []byte("tag"), 0644)
+	c.Assert(err, IsNil)
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, IsNil)
+
+	err = os.WriteFile(filepath.Join(releaseDir, "slices/other.yaml"), []byte("package: other\n"), 0644)
+	c.Assert(err, IsNil)
+	err = lock.CheckSelection(selection, "")
+	c.Assert(err, ErrorMatches, "release content differs from lockfile")
+}
+
+func (s *S) TestCheckPackage(c *C) {
+	releaseDir := writeRelease(c, testRelease)
+	selection := selectSlices(c, releaseDir, "mypkg_app")
+	lock, err := lockfile.New("", "", selection)
+	c.Assert(err, IsNil)
+	lock.AddPackage(selection.Release, "ubuntu", testInfo)
+
+	err = lock.CheckPackage("ubuntu", testInfo)
+	c.Assert(err, IsNil)
+
+	err = lock.CheckPackage("other", testInfo)
+	c.Assert(err, ErrorMatches, `package "mypkg" archive differs from lockfile: other != ubuntu`)
+
+	info := *testInfo
+	info.Suite = "jammy-updates"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "mypkg" suite differs from lockfile: jammy-updates != jammy`)
+
+	info = *testInfo
+	info.Component = "universe"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "mypkg" component differs from lockfile: universe != main`)
+
+	info = *testInfo
+	info.Version = "1.1"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "mypkg" version differs from lockfile: 1.1 != 1.0`)
+
+	info = *testInfo
+	info.Arch = "arm64"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "mypkg" architecture differs from lockfile: arm64 != amd64`)
+
+	info = *testInfo
+	info.SHA256 = "0000"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "mypkg" hash differs from lockfile: 0000 != e2bd.*`)
+
+	info = *testInfo
+	info.Name = "other"
+	err = lock.CheckPackage("ubuntu", &info)
+	c.Assert(err, ErrorMatches, `package "other" not found in lockfile`)
+}
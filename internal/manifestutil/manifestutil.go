@@ -1,6 +1,8 @@
 package manifestutil
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/fs"
@@ -9,6 +11,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/canonical/chisel/internal/apacheutil"
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/setup"
@@ -36,8 +40,19 @@ func FindPaths(slices []*setup.Slice) map[string][]*setup.Slice {
 
 type WriteOptions struct {
 	PackageInfo []*archive.PackageInfo
-	Selection   []*setup.Slice
-	Report      *Report
+	// Licenses optionally maps package names to the licenses declared in
+	// their copyright files.
+	Licenses map[string][]string
+	// Changelogs optionally maps package names to the location of their
+	// changelogs.
+	Changelogs map[string]string
+	Selection  []*setup.Slice
+	Report     *Report
+	// Build optionally records how the manifest was produced.
+	Build *manifest.Build
+	// SetuidPolicy optionally names the policy applied to the paths
+	// extracted with setuid or setgid bits, which is recorded with them.
+	SetuidPolicy string
 }
 
 func Write(options *WriteOptions, writer io.Writer) error {
@@ -50,7 +65,21 @@ func Write(options *WriteOptions, writer io.Writer) error {
 		return err
 	}
 
-	err = manifestAddPackages(dbw, options.PackageInfo)
+	if options.Build != nil {
+		build := *options.Build
+		build.Kind = "build"
+		err = dbw.Add(&build)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = manifestAddSecurity(dbw, options.Report, options.SetuidPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = manifestAddPackages(dbw, options.PackageInfo, options.Licenses, options.Changelogs)
 	if err != nil {
 		return err
 	}
@@ -69,14 +98,84 @@ func Write(options *WriteOptions, writer io.Writer) error {
 	return err
 }
 
-func manifestAddPackages(dbw *jsonwall.DBWriter, infos []*archive.PackageInfo) error {
+// Encoding is the format a manifest is written in.
+type Encoding string
+
+const (
+	// EncodingZstd is jsonwall compressed with zstd, the default.
+	EncodingZstd Encoding = "zstd"
+	// EncodingGzip is jsonwall compressed with gzip.
+	EncodingGzip Encoding = "gzip"
+	// EncodingNone is uncompressed jsonwall.
+	EncodingNone Encoding = "none"
+	// EncodingJSON is a single flat JSON document, as written by
+	// manifest.WriteJSON, for tools which cannot read jsonwall.
+	EncodingJSON Encoding = "json"
+)
+
+// WriteEncoded writes the manifest to writer in the given encoding, or in
+// EncodingZstd if it is empty. Manifests in all of the encodings may be
+// read back with manifest.Read.
+func WriteEncoded(options *WriteOptions, encoding Encoding, writer io.Writer) error {
+	return writeEncoded(encoding, writer, func(w io.Writer) error {
+		return Write(options, w)
+	})
+}
+
+// writeEncoded writes to writer in the given encoding the jsonwall manifest
+// written by write.
+func writeEncoded(encoding Encoding, writer io.Writer, write func(io.Writer) error) error {
+	switch encoding {
+	case "", EncodingZstd:
+		w, err := zstd.NewWriter(writer)
+		if err != nil {
+			return err
+		}
+		err = write(w)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	case EncodingGzip:
+		w := gzip.NewWriter(writer)
+		err := write(w)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	case EncodingNone:
+		return write(writer)
+	case EncodingJSON:
+		var buf bytes.Buffer
+		err := write(&buf)
+		if err != nil {
+			return err
+		}
+		mfest, err := manifest.Read(&buf)
+		if err != nil {
+			return err
+		}
+		return mfest.WriteJSON(writer)
+	default:
+		return fmt.Errorf("invalid manifest encoding: %q", encoding)
+	}
+}
+
+func manifestAddPackages(dbw *jsonwall.DBWriter, infos []*archive.PackageInfo, licenses map[string][]string, changelogs map[string]string) error {
 	for _, info := range infos {
 		err := dbw.Add(&manifest.Package{
-			Kind:    "package",
-			Name:    info.Name,
-			Version: info.Version,
-			Digest:  info.SHA256,
-			Arch:    info.Arch,
+			Kind:     "package",
+			Name:     info.Name,
+			Version:  info.Version,
+			Digest:   info.SHA256,
+			Arch:     info.Arch,
+			Licenses: licenses[info.Name],
+
+			Source:        info.Source,
+			SourceVersion: info.SourceVersion,
+			Changelog:     changelogs[info.Name],
 		})
 		if err != nil {
 			return err
@@ -113,16 +212,25 @@ func manifestAddReport(dbw *jsonwall.DBWriter, report *Report) error {
 			sliceNames = append(sliceNames, slice.String())
 		}
 		sort.Strings(sliceNames)
+		finalSHA256 := entry.FinalSHA256
+		if finalSHA256 == "" {
+			// Unmutated files have the same final content.
+			finalSHA256 = entry.SHA256
+		}
 		err := dbw.Add(&manifest.Path{
-			Kind:        "path",
-			Path:        entry.Path,
-			Mode:        fmt.Sprintf("0%o", unixPerm(entry.Mode)),
-			Slices:      sliceNames,
-			SHA256:      entry.SHA256,
-			FinalSHA256: entry.FinalSHA256,
-			Size:        uint64(entry.Size),
-			Link:        entry.Link,
-			Inode:       entry.Inode,
+			Kind:         "path",
+			Path:         entry.Path,
+			Mode:         fmt.Sprintf("0%o", unixPerm(entry.Mode)),
+			Slices:       sliceNames,
+			SHA256:       entry.SHA256,
+			FinalSHA256:  finalSHA256,
+			Size:         uint64(entry.Size),
+			Link:         entry.Link,
+			OriginalLink: entry.OriginalLink,
+			DivertedFrom: entry.DivertedFrom,
+			Conffile:     entry.Conffile,
+			HostSource:   entry.HostSource,
+			Inode:        entry.Inode,
 		})
 		if err != nil {
 			return err
@@ -131,8 +239,48 @@ func manifestAddReport(dbw *jsonwall.DBWriter, report *Report) error {
 	return nil
 }
 
+// manifestAddSecurity records the paths of the report which were extracted
+// with setuid or setgid bits, if there are any or a policy is set.
+func manifestAddSecurity(dbw *jsonwall.DBWriter, report *Report, setuidPolicy string) error {
+	security := &manifest.Security{
+		Kind:         "security",
+		SetuidPolicy: setuidPolicy,
+	}
+	for path, mode := range report.Privileged {
+		entry, ok := report.Entries[path]
+		if !ok {
+			// Not part of the final tree.
+			continue
+		}
+		sliceNames := []string{}
+		for slice := range entry.Slices {
+			sliceNames = append(sliceNames, slice.String())
+		}
+		sort.Strings(sliceNames)
+		security.Privileged = append(security.Privileged, &manifest.PrivilegedPath{
+			Path:     path,
+			Mode:     fmt.Sprintf("0%o", unixPerm(mode)),
+			Slices:   sliceNames,
+			Stripped: entry.Mode&(fs.ModeSetuid|fs.ModeSetgid) == 0,
+		})
+	}
+	if len(security.Privileged) == 0 && setuidPolicy == "" {
+		return nil
+	}
+	sort.Slice(security.Privileged, func(i, j int) bool {
+		return security.Privileged[i].Path < security.Privileged[j].Path
+	})
+	return dbw.Add(security)
+}
+
 func unixPerm(mode fs.FileMode) (perm uint32) {
 	perm = uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
 	if mode&fs.ModeSticky != 0 {
 		perm |= 01000
 	}
@@ -243,9 +391,12 @@ func validateReportEntry(entry *ReportEntry) (err error) {
 		return fmt.Errorf("unsupported file type: %s", entry.Path)
 	}
 
-	if len(entry.Slices) == 0 {
+	if len(entry.Slices) == 0 && entry.HostSource == "" {
 		return fmt.Errorf("slices is empty")
 	}
+	if len(entry.Slices) > 0 && entry.HostSource != "" {
+		return fmt.Errorf("host source set for path of slices")
+	}
 
 	return nil
 }
@@ -318,9 +469,19 @@ func Validate(mfest *manifest.Manifest) (err error) {
 	done := map[string]bool{}
 	err = mfest.IteratePaths("", func(path *manifest.Path) error {
 		pathSlices, ok := pathToSlices[path.Path]
+		if path.HostSource != "" {
+			// Files copied from the host are not part of any slice.
+			if ok || len(path.Slices) > 0 {
+				return fmt.Errorf("path %s copied from the host has slices", path.Path)
+			}
+			return nil
+		}
 		if !ok {
 			return fmt.Errorf("path %s has no matching entry in contents", path.Path)
 		}
+		if path.SHA256 != "" && path.FinalSHA256 == "" && mfest.Schema() != manifest.SchemaV1 {
+			return fmt.Errorf("path %s has no final_sha256", path.Path)
+		}
 		slices.Sort(pathSlices)
 		slices.Sort(path.Slices)
 		if !slices.Equal(pathSlices, path.Slices) {
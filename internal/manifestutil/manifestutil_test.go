@@ -123,6 +123,7 @@ var generateManifestTests = []struct {
 	summary     string
 	report      *manifestutil.Report
 	packageInfo []*archive.PackageInfo
+	changelogs  map[string]string
 	selection   []*setup.Slice
 	expected    *apachetestutil.ManifestContents
 	error       string
@@ -149,16 +150,21 @@ var generateManifestTests = []struct {
 		},
 	},
 	packageInfo: []*archive.PackageInfo{{
-		Name:    "package1",
-		Version: "v1",
-		Arch:    "a1",
-		SHA256:  "s1",
+		Name:          "package1",
+		Version:       "v1",
+		Arch:          "a1",
+		SHA256:        "s1",
+		Source:        "source1",
+		SourceVersion: "sv1",
 	}, {
 		Name:    "package2",
 		Version: "v2",
 		Arch:    "a2",
 		SHA256:  "s2",
 	}},
+	changelogs: map[string]string{
+		"package1": "https://changelogs.example.com/package1_v1/changelog",
+	},
 	expected: &apachetestutil.ManifestContents{
 		Paths: []*manifest.Path{{
 			Kind:        "path",
@@ -176,11 +182,14 @@ var generateManifestTests = []struct {
 			Slices: []string{"package1_slice1", "package2_slice2"},
 		}},
 		Packages: []*manifest.Package{{
-			Kind:    "package",
-			Name:    "package1",
-			Version: "v1",
-			Digest:  "s1",
-			Arch:    "a1",
+			Kind:          "package",
+			Name:          "package1",
+			Version:       "v1",
+			Digest:        "s1",
+			Arch:          "a1",
+			Source:        "source1",
+			SourceVersion: "sv1",
+			Changelog:     "https://changelogs.example.com/package1_v1/changelog",
 		}, {
 			Kind:    "package",
 			Name:    "package2",
@@ -255,6 +264,20 @@ var generateManifestTests = []struct {
 		},
 	},
 	error: `internal error: invalid manifest: path "/file" has invalid options: slices is empty`,
+}, {
+	summary: "Invalid path: host source set for path of slices",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file": {
+				Path:       "/file",
+				Mode:       0456,
+				Slices:     map[*setup.Slice]bool{slice1: true},
+				HostSource: "./file",
+			},
+		},
+	},
+	error: `internal error: invalid manifest: path "/file" has invalid options: host source set for path of slices`,
 }, {
 	summary: "Invalid path: link set for symlink",
 	report: &manifestutil.Report{
@@ -543,6 +566,7 @@ func (s *S) TestGenerateManifests(c *C) {
 
 		options := &manifestutil.WriteOptions{
 			PackageInfo: test.packageInfo,
+			Changelogs:  test.changelogs,
 			Selection:   test.selection,
 			Report:      test.report,
 		}
@@ -562,6 +586,51 @@ func (s *S) TestGenerateManifests(c *C) {
 	}
 }
 
+func (s *S) TestWriteEncoded(c *C) {
+	test := generateManifestTests[0]
+	c.Assert(test.error, Equals, "")
+	options := &manifestutil.WriteOptions{
+		PackageInfo: test.packageInfo,
+		Changelogs:  test.changelogs,
+		Selection:   test.selection,
+		Report:      test.report,
+		Build: &manifest.Build{
+			ChiselVersion: "v1.0.0",
+			Release:       "ubuntu-24.04",
+			Archives:      []*manifest.BuildArchive{{Name: "ubuntu", Version: "24.04"}},
+			Command:       []string{"chisel", "cut", "package1_slice1"},
+		},
+	}
+	expected := *test.expected
+	expected.Build = &manifest.Build{
+		Kind:          "build",
+		ChiselVersion: "v1.0.0",
+		Release:       "ubuntu-24.04",
+		Archives:      []*manifest.BuildArchive{{Name: "ubuntu", Version: "24.04"}},
+		Command:       []string{"chisel", "cut", "package1_slice1"},
+	}
+	encodings := []manifestutil.Encoding{
+		"",
+		manifestutil.EncodingZstd,
+		manifestutil.EncodingGzip,
+		manifestutil.EncodingNone,
+		manifestutil.EncodingJSON,
+	}
+	for _, encoding := range encodings {
+		c.Logf("Encoding: %q", encoding)
+		var buffer bytes.Buffer
+		err := manifestutil.WriteEncoded(options, encoding, &buffer)
+		c.Assert(err, IsNil)
+		mfest, err := manifest.Read(&buffer)
+		c.Assert(err, IsNil)
+		c.Assert(manifestutil.Validate(mfest), IsNil)
+		c.Assert(apachetestutil.DumpManifestContents(c, mfest), DeepEquals, &expected)
+	}
+
+	err := manifestutil.WriteEncoded(options, "xz", io.Discard)
+	c.Assert(err, ErrorMatches, `invalid manifest encoding: "xz"`)
+}
+
 func (s *S) TestGenerateNoManifests(c *C) {
 	report, err := manifestutil.NewReport("/")
 	c.Assert(err, IsNil)
@@ -624,6 +693,26 @@ var validateManifestTests = []struct {
 		{"kind":"slice","name":"pkg1_myslice"}
 	`,
 	error: `invalid manifest: content path /dir/ has no matching entry in paths`,
+}, {
+	summary: "Missing final digest in schema 2.0",
+	input: `
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg1_myslice","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	error: `invalid manifest: path /file has no final_sha256`,
+}, {
+	summary: "Host files have no slices",
+	input: `
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg1_myslice","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","final_sha256":"hash","size":3,"host_source":"./file"}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	error: `invalid manifest: path /file copied from the host has slices`,
 }, {
 	summary: "Malformed jsonwall",
 	input: `
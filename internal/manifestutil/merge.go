@@ -0,0 +1,201 @@
+package manifestutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/public/jsonwall"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+// hardLinkGroup identifies a group of hard linked paths, whose ids are only
+// unique within the manifest recording them.
+type hardLinkGroup struct {
+	manifest int
+	inode    uint64
+}
+
+type mergedPath struct {
+	path  *manifest.Path
+	group hardLinkGroup
+}
+
+// Merge writes to writer, in the given encoding, a single manifest holding
+// the entries of all the manifests, as recorded by several cuts into the
+// same root. Packages and paths recorded in more than one manifest must
+// agree, and the slices of the paths in common are combined. Build entries
+// are not kept, as the merged manifest is not the result of a single cut.
+func Merge(mfests []*manifest.Manifest, encoding Encoding, writer io.Writer) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cannot merge manifests: %s", err)
+		}
+	}()
+
+	if len(mfests) == 0 {
+		return fmt.Errorf("no manifests provided")
+	}
+
+	pkgs := make(map[string]*manifest.Package)
+	sliceNames := make(map[string]bool)
+	paths := make(map[string]*mergedPath)
+	contents := make(map[manifest.Content]bool)
+	for i, mfest := range mfests {
+		err := mfest.IteratePackages(func(pkg *manifest.Package) error {
+			prev, ok := pkgs[pkg.Name]
+			if !ok {
+				pkgs[pkg.Name] = pkg
+				return nil
+			}
+			if prev.Version != pkg.Version || prev.Arch != pkg.Arch {
+				return fmt.Errorf("package %s has diverging versions: %s (%s) != %s (%s)",
+					pkg.Name, prev.Version, prev.Arch, pkg.Version, pkg.Arch)
+			}
+			if prev.Digest != pkg.Digest {
+				return fmt.Errorf("package %s has diverging digests: %s != %s", pkg.Name, prev.Digest, pkg.Digest)
+			}
+			// Older manifests may lack the source and licenses.
+			if prev.Source == "" {
+				prev.Source, prev.SourceVersion = pkg.Source, pkg.SourceVersion
+			}
+			if len(prev.Licenses) == 0 {
+				prev.Licenses = pkg.Licenses
+			}
+			if prev.Changelog == "" {
+				prev.Changelog = pkg.Changelog
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = mfest.IterateSlices("", func(slice *manifest.Slice) error {
+			sliceNames[slice.Name] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = mfest.IterateContents("", func(content *manifest.Content) error {
+			contents[*content] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = mfest.IteratePaths("", func(path *manifest.Path) error {
+			if path.SHA256 != "" && path.FinalSHA256 == "" && mfest.Schema() == manifest.SchemaV1 {
+				// Schema "1.0" only records the final digest of mutated files.
+				path.FinalSHA256 = path.SHA256
+			}
+			prev, ok := paths[path.Path]
+			if !ok {
+				paths[path.Path] = &mergedPath{path: path, group: hardLinkGroup{i, path.Inode}}
+				return nil
+			}
+			p := prev.path
+			if p.Mode != path.Mode || p.Link != path.Link || p.SHA256 != path.SHA256 ||
+				p.FinalSHA256 != path.FinalSHA256 || p.Size != path.Size {
+				return fmt.Errorf("path %s has diverging contents", path.Path)
+			}
+			for _, slice := range path.Slices {
+				if !slices.Contains(p.Slices, slice) {
+					p.Slices = append(p.Slices, slice)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	renumberHardLinks(paths)
+
+	dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{
+		Schema: manifest.Schema,
+	})
+	for _, pkg := range pkgs {
+		err := dbw.Add(pkg)
+		if err != nil {
+			return err
+		}
+	}
+	for name := range sliceNames {
+		err := dbw.Add(&manifest.Slice{Kind: "slice", Name: name})
+		if err != nil {
+			return err
+		}
+	}
+	for _, merged := range paths {
+		slices.Sort(merged.path.Slices)
+		err := dbw.Add(merged.path)
+		if err != nil {
+			return err
+		}
+	}
+	for content := range contents {
+		err := dbw.Add(&content)
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	_, err = dbw.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+
+	// The manifests may be consistent on their own but not together, such
+	// as when a path is listed for a slice recorded in another manifest.
+	data := buf.Bytes()
+	mfest, err := manifest.Read(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	err = Validate(mfest)
+	if err != nil {
+		return err
+	}
+	return writeEncoded(encoding, writer, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// renumberHardLinks assigns new ids to the groups of hard linked paths, so
+// that they are unique across the merged manifests. Paths left without any
+// other path in their group are not hard linked anymore.
+func renumberHardLinks(paths map[string]*mergedPath) {
+	groups := make(map[hardLinkGroup][]*manifest.Path)
+	for _, merged := range paths {
+		if merged.path.Inode != 0 {
+			groups[merged.group] = append(groups[merged.group], merged.path)
+		}
+	}
+	var linked [][]*manifest.Path
+	for _, group := range groups {
+		if len(group) == 1 {
+			group[0].Inode = 0
+			continue
+		}
+		slices.SortFunc(group, func(a, b *manifest.Path) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+		linked = append(linked, group)
+	}
+	slices.SortFunc(linked, func(a, b []*manifest.Path) int {
+		return strings.Compare(a[0].Path, b[0].Path)
+	})
+	for i, group := range linked {
+		for _, path := range group {
+			path.Inode = uint64(i + 1)
+		}
+	}
+}
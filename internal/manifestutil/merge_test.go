@@ -0,0 +1,181 @@
+package manifestutil_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/apachetestutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var mergeManifestsTests = []struct {
+	summary  string
+	inputs   []string
+	expected *apachetestutil.ManifestContents
+	error    string
+}{{
+	summary: "Disjoint manifests",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":5}
+		{"kind":"build","chisel-version":"v1.0.0"}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file1"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+		{"kind":"path","path":"/dir/file1","mode":"0644","slices":["pkg1_myslice"],"sha256":"sha1","final_sha256":"sha1","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`, `
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg2_myslice","path":"/dir/file2"}
+		{"kind":"package","name":"pkg2","version":"v2","sha256":"hash2","arch":"amd64"}
+		{"kind":"path","path":"/dir/file2","mode":"0644","slices":["pkg2_myslice"],"sha256":"sha2","final_sha256":"sha2","size":3}
+		{"kind":"slice","name":"pkg2_myslice"}
+	`},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/file1", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "sha1", FinalSHA256: "sha1", Size: 3},
+			{Kind: "path", Path: "/dir/file2", Mode: "0644", Slices: []string{"pkg2_myslice"}, SHA256: "sha2", FinalSHA256: "sha2", Size: 3},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "amd64"},
+			{Kind: "package", Name: "pkg2", Version: "v2", Digest: "hash2", Arch: "amd64"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+			{Kind: "slice", Name: "pkg2_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/file1"},
+			{Kind: "content", Slice: "pkg2_myslice", Path: "/dir/file2"},
+		},
+	},
+}, {
+	summary: "Common paths and packages",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":7}
+		{"kind":"content","slice":"pkg1_one","path":"/dir/"}
+		{"kind":"content","slice":"pkg1_one","path":"/dir/file"}
+		{"kind":"content","slice":"pkg1_one","path":"/dir/hardlink"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+		{"kind":"path","path":"/dir/","mode":"0755","slices":["pkg1_one"]}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_one"],"sha256":"sha1","final_sha256":"sha1","size":3,"inode":1}
+		{"kind":"path","path":"/dir/hardlink","mode":"0644","slices":["pkg1_one"],"sha256":"sha1","final_sha256":"sha1","size":3,"inode":1}
+		{"kind":"slice","name":"pkg1_one"}
+	`, `
+		{"jsonwall":"1.0","schema":"1.0","count":7}
+		{"kind":"content","slice":"pkg1_two","path":"/dir/"}
+		{"kind":"content","slice":"pkg1_two","path":"/other/file"}
+		{"kind":"content","slice":"pkg1_two","path":"/other/hardlink"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64","licenses":["MIT"]}
+		{"kind":"path","path":"/dir/","mode":"0755","slices":["pkg1_two"]}
+		{"kind":"path","path":"/other/file","mode":"0644","slices":["pkg1_two"],"sha256":"sha2","size":3,"inode":1}
+		{"kind":"path","path":"/other/hardlink","mode":"0644","slices":["pkg1_two"],"sha256":"sha2","size":3,"inode":1}
+		{"kind":"slice","name":"pkg1_two"}
+	`},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/", Mode: "0755", Slices: []string{"pkg1_one", "pkg1_two"}},
+			{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_one"}, SHA256: "sha1", FinalSHA256: "sha1", Size: 3, Inode: 1},
+			{Kind: "path", Path: "/dir/hardlink", Mode: "0644", Slices: []string{"pkg1_one"}, SHA256: "sha1", FinalSHA256: "sha1", Size: 3, Inode: 1},
+			{Kind: "path", Path: "/other/file", Mode: "0644", Slices: []string{"pkg1_two"}, SHA256: "sha2", FinalSHA256: "sha2", Size: 3, Inode: 2},
+			{Kind: "path", Path: "/other/hardlink", Mode: "0644", Slices: []string{"pkg1_two"}, SHA256: "sha2", FinalSHA256: "sha2", Size: 3, Inode: 2},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "amd64", Licenses: []string{"MIT"}},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_one"},
+			{Kind: "slice", Name: "pkg1_two"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_one", Path: "/dir/"},
+			{Kind: "content", Slice: "pkg1_one", Path: "/dir/file"},
+			{Kind: "content", Slice: "pkg1_one", Path: "/dir/hardlink"},
+			{Kind: "content", Slice: "pkg1_two", Path: "/dir/"},
+			{Kind: "content", Slice: "pkg1_two", Path: "/other/file"},
+			{Kind: "content", Slice: "pkg1_two", Path: "/other/hardlink"},
+		},
+	},
+}, {
+	summary: "Diverging package versions",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+	`, `
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v2","sha256":"hash2","arch":"amd64"}
+	`},
+	error: `cannot merge manifests: package pkg1 has diverging versions: v1 \(amd64\) != v2 \(amd64\)`,
+}, {
+	summary: "Diverging package digests",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+	`, `
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash2","arch":"amd64"}
+	`},
+	error: `cannot merge manifests: package pkg1 has diverging digests: hash1 != hash2`,
+}, {
+	summary: "Diverging path contents",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg1_one","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_one"],"sha256":"sha1","final_sha256":"sha1","size":3}
+		{"kind":"slice","name":"pkg1_one"}
+	`, `
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg1_two","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"amd64"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_two"],"sha256":"sha1","final_sha256":"sha2","size":3}
+		{"kind":"slice","name":"pkg1_two"}
+	`},
+	error: `cannot merge manifests: path /file has diverging contents`,
+}, {
+	summary: "Inconsistent result",
+	inputs: []string{`
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"slice","name":"pkg1_one"}
+	`},
+	error: `cannot merge manifests: invalid manifest: slice pkg1_one refers to missing package "pkg1"`,
+}, {
+	summary: "No manifests",
+	error:   `cannot merge manifests: no manifests provided`,
+}}
+
+func (s *S) TestMergeManifests(c *C) {
+	for _, test := range mergeManifestsTests {
+		c.Logf("Summary: %s", test.summary)
+
+		var mfests []*manifest.Manifest
+		for _, input := range test.inputs {
+			lines := strings.Split(strings.TrimSpace(input), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimLeft(line, "\t")
+			}
+			mfest, err := manifest.Read(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+			c.Assert(err, IsNil)
+			mfests = append(mfests, mfest)
+		}
+
+		var buffer bytes.Buffer
+		err := manifestutil.Merge(mfests, manifestutil.EncodingNone, &buffer)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		mfest, err := manifest.Read(&buffer)
+		c.Assert(err, IsNil)
+		c.Assert(mfest.Schema(), Equals, manifest.Schema)
+		c.Assert(apachetestutil.DumpManifestContents(c, mfest), DeepEquals, test.expected)
+	}
+
+	mfest, err := manifest.Read(strings.NewReader(`{"jsonwall":"1.0","schema":"2.0","count":0}` + "\n"))
+	c.Assert(err, IsNil)
+	err = manifestutil.Merge([]*manifest.Manifest{mfest}, "xz", io.Discard)
+	c.Assert(err, ErrorMatches, `cannot merge manifests: invalid manifest encoding: "xz"`)
+}
@@ -18,6 +18,18 @@ type ReportEntry struct {
 	Slices      map[*setup.Slice]bool
 	Link        string
 	FinalSHA256 string
+	// OriginalLink is the target a symlink had in its package when it was
+	// rewritten while cutting.
+	OriginalLink string
+	// DivertedFrom is the path the content was moved from by a diversion
+	// of another package.
+	DivertedFrom string
+	// Conffile is set for the paths listed in the conffiles of the package
+	// they were extracted from.
+	Conffile bool
+	// HostSource is the path on the host the file was copied from, for
+	// files which are not part of any slice.
+	HostSource string
 	// If Inode is greater than 0, all entries represent hard links to the same
 	// inode.
 	Inode uint64
@@ -30,6 +42,9 @@ type Report struct {
 	Root string
 	// Entries holds all reported content, indexed by their path.
 	Entries map[string]ReportEntry
+	// Privileged holds the mode in their packages of the paths extracted
+	// with setuid or setgid bits, indexed by their path.
+	Privileged map[string]fs.FileMode
 	// lastInode is used internally to allocate unique Inode for hard
 	// links.
 	lastInode uint64
@@ -46,8 +61,9 @@ func NewReport(root string) (*Report, error) {
 		root = filepath.Clean(root) + "/"
 	}
 	report := &Report{
-		Root:    root,
-		Entries: make(map[string]ReportEntry),
+		Root:       root,
+		Entries:    make(map[string]ReportEntry),
+		Privileged: make(map[string]fs.FileMode),
 	}
 	return report, nil
 }
@@ -104,6 +120,52 @@ func (r *Report) Add(slice *setup.Slice, fsEntry *fsutil.Entry) error {
 	return nil
 }
 
+// AddHost adds to the report a file copied from the host at source, which
+// is not part of any slice.
+func (r *Report) AddHost(fsEntry *fsutil.Entry, source string) error {
+	relPath, err := r.sanitizeAbsPath(fsEntry.Path, fsEntry.Mode.IsDir())
+	if err != nil {
+		return fmt.Errorf("cannot add path to report: %s", err)
+	}
+	if _, ok := r.Entries[relPath]; ok {
+		return fmt.Errorf("cannot add host file %s to report: path already reported", relPath)
+	}
+	r.Entries[relPath] = ReportEntry{
+		Path:       relPath,
+		Mode:       fsEntry.Mode,
+		SHA256:     fsEntry.SHA256,
+		Size:       fsEntry.Size,
+		Slices:     map[*setup.Slice]bool{},
+		HostSource: source,
+	}
+	return nil
+}
+
+// Link records the regular files at paths, which must have been previously
+// added and not be hard links yet, as hard links to the same inode, as when
+// files with identical content are deduplicated.
+func (r *Report) Link(paths []string) error {
+	if len(paths) < 2 {
+		return fmt.Errorf("cannot link paths in report: need at least two paths")
+	}
+	for _, path := range paths {
+		entry, ok := r.Entries[path]
+		if !ok {
+			return fmt.Errorf("cannot link path in report: %s not previously added", path)
+		}
+		if !entry.Mode.IsRegular() || entry.Inode != 0 {
+			return fmt.Errorf("cannot link path in report: %s is not a regular file without links", path)
+		}
+	}
+	r.lastInode += 1
+	for _, path := range paths {
+		entry := r.Entries[path]
+		entry.Inode = r.lastInode
+		r.Entries[path] = entry
+	}
+	return nil
+}
+
 // Mutate updates the FinalSHA256 and Size of an existing path entry.
 func (r *Report) Mutate(fsEntry *fsutil.Entry) error {
 	relPath, err := r.sanitizeAbsPath(fsEntry.Path, fsEntry.Mode.IsDir())
@@ -132,7 +194,7 @@ func (r *Report) sanitizeAbsPath(path string, isDir bool) (relPath string, err e
 	if !strings.HasPrefix(path, r.Root) {
 		return "", fmt.Errorf("%s outside of root %s", path, r.Root)
 	}
-	relPath = filepath.Clean("/" + strings.TrimPrefix(path, r.Root))
+	relPath = filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(path, r.Root)))
 	if isDir {
 		relPath = relPath + "/"
 	}
@@ -386,3 +386,34 @@ func (s *S) TestRootOnlySlash(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(report.Root, Equals, "/")
 }
+
+func (s *S) TestReportLink(c *C) {
+	report, err := manifestutil.NewReport("/base/")
+	c.Assert(err, IsNil)
+	otherFile := sampleFile
+	otherFile.Path = "/base/other-file"
+	for _, entry := range []fsutil.Entry{sampleDir, sampleFile, otherFile, sampleHardLink} {
+		err = report.Add(oneSlice, &entry)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(report.Entries["/example-file"].Inode, Equals, uint64(1))
+
+	err = report.Link([]string{"/other-file", "/example-dir/"})
+	c.Assert(err, ErrorMatches, `cannot link path in report: /example-dir/ is not a regular file without links`)
+	err = report.Link([]string{"/other-file", "/example-file"})
+	c.Assert(err, ErrorMatches, `cannot link path in report: /example-file is not a regular file without links`)
+	err = report.Link([]string{"/other-file", "/missing"})
+	c.Assert(err, ErrorMatches, `cannot link path in report: /missing not previously added`)
+	err = report.Link([]string{"/other-file"})
+	c.Assert(err, ErrorMatches, `cannot link paths in report: need at least two paths`)
+	c.Assert(report.Entries["/other-file"].Inode, Equals, uint64(0))
+
+	thirdFile := sampleFile
+	thirdFile.Path = "/base/third-file"
+	err = report.Add(otherSlice, &thirdFile)
+	c.Assert(err, IsNil)
+	err = report.Link([]string{"/other-file", "/third-file"})
+	c.Assert(err, IsNil)
+	c.Assert(report.Entries["/other-file"].Inode, Equals, uint64(2))
+	c.Assert(report.Entries["/third-file"].Inode, Equals, uint64(2))
+}
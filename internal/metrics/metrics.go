@@ -0,0 +1,187 @@
+// Package metrics records how much work Chisel does and how long it takes,
+// for tracking the performance of cuts.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a count of events which only increases.
+type Counter struct {
+	value atomic.Int64
+}
+
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+var (
+	// DownloadedBytes counts the bytes received from archives.
+	DownloadedBytes Counter
+	// CacheHits and CacheMisses count the lookups of content in the cache.
+	CacheHits   Counter
+	CacheMisses Counter
+	// Cuts and FailedCuts count the cuts run.
+	Cuts       Counter
+	FailedCuts Counter
+)
+
+// Phases of a cut whose durations are recorded.
+const (
+	PhaseRelease  = "release"
+	PhaseSelect   = "select"
+	PhaseArchives = "archives"
+	PhaseFetch    = "fetch"
+	PhaseVerify   = "verify"
+	PhaseExtract  = "extract"
+	PhaseMutate   = "mutate"
+	PhaseGenerate = "generate"
+	PhaseManifest = "manifest"
+)
+
+var phaseOrder = []string{
+	PhaseRelease,
+	PhaseSelect,
+	PhaseArchives,
+	PhaseFetch,
+	PhaseVerify,
+	PhaseExtract,
+	PhaseMutate,
+	PhaseGenerate,
+	PhaseManifest,
+}
+
+var phasesLock sync.Mutex
+var phases = make(map[string]time.Duration)
+
+// Start starts timing the phase, and returns the function which stops it.
+// The time of all the runs of a phase is added up.
+func Start(phase string) (stop func()) {
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		phasesLock.Lock()
+		phases[phase] += elapsed
+		phasesLock.Unlock()
+	}
+}
+
+// Snapshot holds the values of all the metrics at some point, or the
+// difference between two such points.
+type Snapshot struct {
+	DownloadedBytes int64 `json:"downloaded-bytes"`
+	CacheHits       int64 `json:"cache-hits"`
+	CacheMisses     int64 `json:"cache-misses"`
+	Cuts            int64 `json:"cuts"`
+	FailedCuts      int64 `json:"failed-cuts"`
+	// PhaseSeconds maps phases to the time spent in them, in seconds.
+	PhaseSeconds map[string]float64 `json:"phase-seconds"`
+}
+
+// Take returns the current values of all the metrics.
+func Take() *Snapshot {
+	s := &Snapshot{
+		DownloadedBytes: DownloadedBytes.Value(),
+		CacheHits:       CacheHits.Value(),
+		CacheMisses:     CacheMisses.Value(),
+		Cuts:            Cuts.Value(),
+		FailedCuts:      FailedCuts.Value(),
+		PhaseSeconds:    make(map[string]float64),
+	}
+	phasesLock.Lock()
+	for phase, d := range phases {
+		s.PhaseSeconds[phase] = d.Seconds()
+	}
+	phasesLock.Unlock()
+	return s
+}
+
+// Since returns the change in the metrics from prev to s.
+func (s *Snapshot) Since(prev *Snapshot) *Snapshot {
+	diff := &Snapshot{
+		DownloadedBytes: s.DownloadedBytes - prev.DownloadedBytes,
+		CacheHits:       s.CacheHits - prev.CacheHits,
+		CacheMisses:     s.CacheMisses - prev.CacheMisses,
+		Cuts:            s.Cuts - prev.Cuts,
+		FailedCuts:      s.FailedCuts - prev.FailedCuts,
+		PhaseSeconds:    make(map[string]float64),
+	}
+	for phase, seconds := range s.PhaseSeconds {
+		if d := seconds - prev.PhaseSeconds[phase]; d > 0 {
+			diff.PhaseSeconds[phase] = d
+		}
+	}
+	return diff
+}
+
+// Phases returns the phases with time recorded, in the order they run.
+func (s *Snapshot) Phases() []string {
+	var result []string
+	for _, phase := range phaseOrder {
+		if _, ok := s.PhaseSeconds[phase]; ok {
+			result = append(result, phase)
+		}
+	}
+	var others []string
+	for phase := range s.PhaseSeconds {
+		if !slices.Contains(phaseOrder, phase) {
+			others = append(others, phase)
+		}
+	}
+	slices.Sort(others)
+	return append(result, others...)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func WritePrometheus(w io.Writer, s *Snapshot) error {
+	counters := []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"chisel_downloaded_bytes_total", "Bytes received from archives.", s.DownloadedBytes},
+		{"chisel_cache_hits_total", "Lookups of content found in the cache.", s.CacheHits},
+		{"chisel_cache_misses_total", "Lookups of content not found in the cache.", s.CacheMisses},
+		{"chisel_cuts_total", "Cuts run.", s.Cuts},
+		{"chisel_failed_cuts_total", "Cuts which failed.", s.FailedCuts},
+	}
+	for _, c := range counters {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# HELP chisel_phase_seconds_total Time spent in each phase of the cuts.\n"+
+		"# TYPE chisel_phase_seconds_total counter\n")
+	if err != nil {
+		return err
+	}
+	for _, phase := range s.Phases() {
+		_, err := fmt.Fprintf(w, "chisel_phase_seconds_total{phase=%q} %g\n", phase, s.PhaseSeconds[phase])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountingReader counts the bytes read through it in a counter.
+type CountingReader struct {
+	io.ReadCloser
+	Counter *Counter
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.Counter.Add(int64(n))
+	return n, err
+}
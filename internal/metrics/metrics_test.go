@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/metrics"
+)
+
+func (s *S) TestSince(c *C) {
+	prev := metrics.Take()
+	metrics.DownloadedBytes.Add(10)
+	metrics.CacheHits.Add(2)
+	metrics.Cuts.Add(1)
+	reader := &metrics.CountingReader{
+		ReadCloser: io.NopCloser(strings.NewReader("content")),
+		Counter:    &metrics.DownloadedBytes,
+	}
+	_, err := io.ReadAll(reader)
+	c.Assert(err, IsNil)
+	stop := metrics.Start(metrics.PhaseFetch)
+	time.Sleep(time.Millisecond)
+	stop()
+
+	diff := metrics.Take().Since(prev)
+	c.Assert(diff.PhaseSeconds[metrics.PhaseFetch] > 0, Equals, true)
+	diff.PhaseSeconds = nil
+	c.Assert(diff, DeepEquals, &metrics.Snapshot{
+		DownloadedBytes: 17,
+		CacheHits:       2,
+		Cuts:            1,
+	})
+}
+
+func (s *S) TestWritePrometheus(c *C) {
+	var buf bytes.Buffer
+	err := metrics.WritePrometheus(&buf, &metrics.Snapshot{
+		DownloadedBytes: 1024,
+		CacheHits:       3,
+		CacheMisses:     1,
+		Cuts:            2,
+		FailedCuts:      1,
+		PhaseSeconds: map[string]float64{
+			"custom":             0.25,
+			metrics.PhaseExtract: 1.5,
+			metrics.PhaseRelease: 0.5,
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `# HELP chisel_downloaded_bytes_total Bytes received from archives.
+# TYPE chisel_downloaded_bytes_total counter
+chisel_downloaded_bytes_total 1024
+# HELP chisel_cache_hits_total Lookups of content found in the cache.
+# TYPE chisel_cache_hits_total counter
+chisel_cache_hits_total 3
+# HELP chisel_cache_misses_total Lookups of content not found in the cache.
+# TYPE chisel_cache_misses_total counter
+chisel_cache_misses_total 1
+# HELP chisel_cuts_total Cuts run.
+# TYPE chisel_cuts_total counter
+chisel_cuts_total 2
+# HELP chisel_failed_cuts_total Cuts which failed.
+# TYPE chisel_failed_cuts_total counter
+chisel_failed_cuts_total 1
+# HELP chisel_phase_seconds_total Time spent in each phase of the cuts.
+# TYPE chisel_phase_seconds_total counter
+chisel_phase_seconds_total{phase="release"} 0.5
+chisel_phase_seconds_total{phase="extract"} 1.5
+chisel_phase_seconds_total{phase="custom"} 0.25
+`)
+}
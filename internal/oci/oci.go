@@ -0,0 +1,245 @@
+// Package oci implements writing container images in the OCI image layout,
+// as archives which container tools such as podman and skopeo import.
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/tarutil"
+)
+
+const (
+	mediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// Config describes how the image is run.
+type Config struct {
+	// OS and Arch are the platform of the image, in the terms of Go, as
+	// in "linux" and "arm". Variant distinguishes versions of the
+	// architecture, as in "v7".
+	OS      string
+	Arch    string
+	Variant string
+	// Env holds variables in the "<name>=<value>" format.
+	Env        []string
+	Entrypoint []string
+	Cmd        []string
+	User       string
+	WorkingDir string
+	Labels     map[string]string
+	// Created is the time recorded as the creation of the image.
+	Created time.Time
+	// RefName names the image in the layout, as in "app:1.0", if set.
+	RefName string
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type imageIndex struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	MediaType     string        `json:"mediaType"`
+	Manifests     []*descriptor `json:"manifests"`
+}
+
+type imageManifest struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	MediaType     string        `json:"mediaType"`
+	Config        *descriptor   `json:"config"`
+	Layers        []*descriptor `json:"layers"`
+}
+
+type imageConfig struct {
+	Created      string          `json:"created,omitempty"`
+	Architecture string          `json:"architecture"`
+	Variant      string          `json:"variant,omitempty"`
+	OS           string          `json:"os"`
+	Config       containerConfig `json:"config"`
+	RootFS       rootFS          `json:"rootfs"`
+}
+
+type containerConfig struct {
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+type rootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// Write writes to w an archive in the OCI image layout holding an image
+// with a single layer with the content of dir. Owners and labels recorded
+// in db, which may be nil, are applied to the layer as tarutil.Write does.
+func Write(w io.Writer, dir string, db *ownership.DB, config *Config) error {
+	layerFile, err := os.CreateTemp("", "chisel-layer-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+
+	// The layer is identified by the digest of its compressed content, and
+	// recorded in the configuration by the one of its tar stream.
+	layerHash := sha256.New()
+	diffHash := sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(layerFile, layerHash))
+	err = tarutil.Write(io.MultiWriter(gzipWriter, diffHash), dir, db)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write image layer: %w", err)
+	}
+	layerSize, err := layerFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = layerFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	imgConfig := &imageConfig{
+		Architecture: config.Arch,
+		Variant:      config.Variant,
+		OS:           config.OS,
+		Config: containerConfig{
+			User:       config.User,
+			Env:        config.Env,
+			Entrypoint: config.Entrypoint,
+			Cmd:        config.Cmd,
+			WorkingDir: config.WorkingDir,
+			Labels:     config.Labels,
+		},
+		RootFS: rootFS{
+			Type:    "layers",
+			DiffIDs: []string{digestOf(diffHash)},
+		},
+	}
+	if imgConfig.OS == "" {
+		imgConfig.OS = "linux"
+	}
+	if !config.Created.IsZero() {
+		imgConfig.Created = config.Created.UTC().Format(time.RFC3339)
+	}
+	configData, err := json.Marshal(imgConfig)
+	if err != nil {
+		return err
+	}
+	configDesc := blobDescriptor(mediaTypeConfig, configData)
+	layerDesc := &descriptor{
+		MediaType: mediaTypeLayer,
+		Digest:    digestOf(layerHash),
+		Size:      layerSize,
+	}
+
+	manifestData, err := json.Marshal(&imageManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        configDesc,
+		Layers:        []*descriptor{layerDesc},
+	})
+	if err != nil {
+		return err
+	}
+	manifestDesc := blobDescriptor(mediaTypeManifest, manifestData)
+	if config.RefName != "" {
+		manifestDesc.Annotations = map[string]string{refNameAnnotation: config.RefName}
+	}
+	indexData, err := json.Marshal(&imageIndex{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeIndex,
+		Manifests:     []*descriptor{manifestDesc},
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	writeDir := func(name string) error {
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     name,
+			Mode:     0755,
+			ModTime:  config.Created,
+		})
+	}
+	writeFile := func(name string, size int64, data io.Reader) error {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  config.Created,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, data)
+		return err
+	}
+	writeBlob := func(desc *descriptor, data io.Reader) error {
+		return writeFile("blobs/sha256/"+desc.Digest[len("sha256:"):], desc.Size, data)
+	}
+	layoutData := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	if err := writeFile("oci-layout", int64(len(layoutData)), bytes.NewReader(layoutData)); err != nil {
+		return err
+	}
+	if err := writeFile("index.json", int64(len(indexData)), bytes.NewReader(indexData)); err != nil {
+		return err
+	}
+	if err := writeDir("blobs/"); err != nil {
+		return err
+	}
+	if err := writeDir("blobs/sha256/"); err != nil {
+		return err
+	}
+	if err := writeBlob(manifestDesc, bytes.NewReader(manifestData)); err != nil {
+		return err
+	}
+	if err := writeBlob(configDesc, bytes.NewReader(configData)); err != nil {
+		return err
+	}
+	if err := writeBlob(layerDesc, layerFile); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func blobDescriptor(mediaType string, data []byte) *descriptor {
+	sum := sha256.Sum256(data)
+	return &descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+}
+
+func digestOf(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,145 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/oci"
+	"github.com/canonical/chisel/internal/ownership"
+)
+
+// readArchive returns the files in the archive by name.
+func readArchive(c *C, data []byte) map[string][]byte {
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		files[header.Name] = content
+	}
+	return files
+}
+
+func blob(c *C, files map[string][]byte, digest string) []byte {
+	c.Assert(digest, Matches, "sha256:[0-9a-f]{64}")
+	data, ok := files["blobs/sha256/"+digest[len("sha256:"):]]
+	c.Assert(ok, Equals, true)
+	sum := sha256.Sum256(data)
+	c.Assert("sha256:"+hex.EncodeToString(sum[:]), Equals, digest)
+	return data
+}
+
+func (s *S) TestWrite(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/bin"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/app"), []byte("app"), 0755), IsNil)
+	db := ownership.New()
+	db.Set("/usr/bin/app", ownership.Owner{UID: 1000, GID: 1000})
+
+	var buf bytes.Buffer
+	err := oci.Write(&buf, dir, db, &oci.Config{
+		Arch:       "arm",
+		Variant:    "v7",
+		Env:        []string{"A=1"},
+		Entrypoint: []string{"/usr/bin/app"},
+		User:       "1000",
+		Labels:     map[string]string{"org.example": "value"},
+		Created:    time.Unix(1700000000, 0),
+		RefName:    "app:1.0",
+	})
+	c.Assert(err, IsNil)
+
+	files := readArchive(c, buf.Bytes())
+	c.Assert(string(files["oci-layout"]), Equals, `{"imageLayoutVersion":"1.0.0"}`)
+
+	var index struct {
+		Manifests []struct {
+			MediaType   string            `json:"mediaType"`
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"manifests"`
+	}
+	c.Assert(json.Unmarshal(files["index.json"], &index), IsNil)
+	c.Assert(index.Manifests, HasLen, 1)
+	c.Assert(index.Manifests[0].MediaType, Equals, "application/vnd.oci.image.manifest.v1+json")
+	c.Assert(index.Manifests[0].Annotations, DeepEquals, map[string]string{"org.opencontainers.image.ref.name": "app:1.0"})
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	c.Assert(json.Unmarshal(blob(c, files, index.Manifests[0].Digest), &manifest), IsNil)
+	c.Assert(manifest.Layers, HasLen, 1)
+	c.Assert(manifest.Layers[0].MediaType, Equals, "application/vnd.oci.image.layer.v1.tar+gzip")
+
+	var config map[string]any
+	c.Assert(json.Unmarshal(blob(c, files, manifest.Config.Digest), &config), IsNil)
+	rootfs := config["rootfs"].(map[string]any)
+	delete(config, "rootfs")
+	c.Assert(config, DeepEquals, map[string]any{
+		"created":      "2023-11-14T22:13:20Z",
+		"architecture": "arm",
+		"variant":      "v7",
+		"os":           "linux",
+		"config": map[string]any{
+			"User":       "1000",
+			"Env":        []any{"A=1"},
+			"Entrypoint": []any{"/usr/bin/app"},
+			"Labels":     map[string]any{"org.example": "value"},
+		},
+	})
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(blob(c, files, manifest.Layers[0].Digest)))
+	c.Assert(err, IsNil)
+	layer, err := io.ReadAll(gzipReader)
+	c.Assert(err, IsNil)
+	sum := sha256.Sum256(layer)
+	c.Assert(rootfs, DeepEquals, map[string]any{
+		"type":     "layers",
+		"diff_ids": []any{"sha256:" + hex.EncodeToString(sum[:])},
+	})
+
+	tr := tar.NewReader(bytes.NewReader(layer))
+	entries := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		data, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		entries[header.Name] = string(data)
+		if header.Name == "./usr/bin/app" {
+			c.Assert(header.Uid, Equals, 1000)
+		}
+	}
+	c.Assert(entries, DeepEquals, map[string]string{
+		"./usr/":        "",
+		"./usr/bin/":    "",
+		"./usr/bin/app": "app",
+	})
+}
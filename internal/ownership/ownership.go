@@ -0,0 +1,167 @@
+// Package ownership implements a database recording the owners and the
+// SELinux security labels of the paths in a tree, for when they cannot be
+// applied to the filesystem, as when cutting the tree without privileges.
+// They are applied later on, when the tree is exported.
+package ownership
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// header is the first line of the database files.
+const header = "# chisel ownership v1"
+
+type Owner struct {
+	UID int
+	GID int
+}
+
+// DB maps the paths in a tree to their owners and labels. Paths owned by
+// root and without a label are not recorded.
+type DB struct {
+	owners map[string]Owner
+	labels map[string]string
+}
+
+func New() *DB {
+	return &DB{
+		owners: make(map[string]Owner),
+		labels: make(map[string]string),
+	}
+}
+
+// cleanPath returns path as absolute within the tree, without a trailing
+// slash for directories.
+func cleanPath(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// Set records the owner of path, which is absolute within the tree.
+func (db *DB) Set(path string, owner Owner) {
+	path = cleanPath(path)
+	if owner == (Owner{}) {
+		delete(db.owners, path)
+		return
+	}
+	db.owners[path] = owner
+}
+
+// Owner returns the owner of path, which is root if none was recorded.
+func (db *DB) Owner(path string) Owner {
+	return db.owners[cleanPath(path)]
+}
+
+// SetLabel records the SELinux security context of path, which is
+// absolute within the tree.
+func (db *DB) SetLabel(path string, label string) {
+	path = cleanPath(path)
+	if label == "" {
+		delete(db.labels, path)
+		return
+	}
+	db.labels[path] = label
+}
+
+// Label returns the SELinux security context of path, or an empty string
+// if none was recorded.
+func (db *DB) Label(path string) string {
+	return db.labels[cleanPath(path)]
+}
+
+// HasLabels returns whether any label is recorded.
+func (db *DB) HasLabels() bool {
+	return len(db.labels) > 0
+}
+
+// Delete removes the owner and label recorded for path.
+func (db *DB) Delete(path string) {
+	path = cleanPath(path)
+	delete(db.owners, path)
+	delete(db.labels, path)
+}
+
+// Paths returns the paths recorded, sorted.
+func (db *DB) Paths() []string {
+	paths := make([]string, 0, len(db.owners)+len(db.labels))
+	for path := range db.owners {
+		paths = append(paths, path)
+	}
+	for path := range db.labels {
+		if _, ok := db.owners[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Read reads the database at path. Each line holds the user and group IDs,
+// the label if any, and the path, as in "0 42 /etc/shadow" or
+// "0 0 system_u:object_r:bin_t:s0 /usr/bin/app".
+func Read(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ownership database: %w", err)
+	}
+	db := New()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if lineNum == 1 {
+			if line != header {
+				return nil, fmt.Errorf("cannot parse ownership database %s: unknown format", path)
+			}
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("cannot parse ownership database %s: invalid line %d", path, lineNum)
+		}
+		var label string
+		entryPath := fields[2]
+		if !strings.HasPrefix(entryPath, "/") {
+			// Labels never start with a slash, unlike paths.
+			label, entryPath, _ = strings.Cut(entryPath, " ")
+		}
+		uid, uidErr := strconv.Atoi(fields[0])
+		gid, gidErr := strconv.Atoi(fields[1])
+		if uidErr != nil || gidErr != nil || uid < 0 || gid < 0 || !strings.HasPrefix(entryPath, "/") {
+			return nil, fmt.Errorf("cannot parse ownership database %s: invalid line %d", path, lineNum)
+		}
+		db.Set(entryPath, Owner{UID: uid, GID: gid})
+		db.SetLabel(entryPath, label)
+	}
+	if lineNum == 0 {
+		return nil, fmt.Errorf("cannot parse ownership database %s: unknown format", path)
+	}
+	return db, nil
+}
+
+// Write writes the database to path, sorted by path.
+func Write(path string, db *DB) error {
+	var buf bytes.Buffer
+	buf.WriteString(header + "\n")
+	for _, p := range db.Paths() {
+		owner := db.owners[p]
+		if label := db.labels[p]; label != "" {
+			fmt.Fprintf(&buf, "%d %d %s %s\n", owner.UID, owner.GID, label, p)
+		} else {
+			fmt.Fprintf(&buf, "%d %d %s\n", owner.UID, owner.GID, p)
+		}
+	}
+	err := os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write ownership database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package ownership_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/ownership"
+)
+
+func (s *S) TestSetOwner(c *C) {
+	db := ownership.New()
+	db.Set("/etc/shadow", ownership.Owner{UID: 0, GID: 42})
+	db.Set("/var/mail/", ownership.Owner{UID: 0, GID: 8})
+	db.Set("/root", ownership.Owner{})
+
+	c.Assert(db.Paths(), DeepEquals, []string{"/etc/shadow", "/var/mail"})
+	c.Assert(db.Owner("/etc/shadow"), Equals, ownership.Owner{UID: 0, GID: 42})
+	c.Assert(db.Owner("/var/mail"), Equals, ownership.Owner{UID: 0, GID: 8})
+	c.Assert(db.Owner("var/mail/"), Equals, ownership.Owner{UID: 0, GID: 8})
+	c.Assert(db.Owner("/etc/passwd"), Equals, ownership.Owner{})
+
+	// Setting root removes the entry.
+	db.Set("/var/mail", ownership.Owner{})
+	c.Assert(db.Paths(), DeepEquals, []string{"/etc/shadow"})
+}
+
+func (s *S) TestSetLabel(c *C) {
+	db := ownership.New()
+	c.Assert(db.HasLabels(), Equals, false)
+	db.SetLabel("/usr/bin/app", "system_u:object_r:bin_t:s0")
+	db.Set("/usr/bin/app", ownership.Owner{UID: 0, GID: 42})
+	c.Assert(db.HasLabels(), Equals, true)
+	c.Assert(db.Label("/usr/bin/app"), Equals, "system_u:object_r:bin_t:s0")
+	c.Assert(db.Label("/usr/bin/other"), Equals, "")
+
+	// Owners and labels are independent.
+	db.Set("/usr/bin/app", ownership.Owner{})
+	c.Assert(db.Paths(), DeepEquals, []string{"/usr/bin/app"})
+
+	db.Delete("/usr/bin/app")
+	c.Assert(db.Paths(), HasLen, 0)
+	c.Assert(db.HasLabels(), Equals, false)
+}
+
+func (s *S) TestWriteRead(c *C) {
+	db := ownership.New()
+	db.Set("/var/mail/", ownership.Owner{UID: 0, GID: 8})
+	db.Set("/etc/shadow", ownership.Owner{UID: 0, GID: 42})
+	db.Set("/home/some user", ownership.Owner{UID: 1000, GID: 1000})
+	db.SetLabel("/usr/bin/app", "system_u:object_r:bin_t:s0")
+	db.SetLabel("/etc/shadow", "system_u:object_r:shadow_t:s0")
+
+	path := filepath.Join(c.MkDir(), "ownership")
+	err := ownership.Write(path, db)
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"# chisel ownership v1\n"+
+		"0 42 system_u:object_r:shadow_t:s0 /etc/shadow\n"+
+		"1000 1000 /home/some user\n"+
+		"0 0 system_u:object_r:bin_t:s0 /usr/bin/app\n"+
+		"0 8 /var/mail\n")
+
+	read, err := ownership.Read(path)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, db)
+}
+
+var readErrorTests = []struct {
+	data  string
+	error string
+}{{
+	data:  "",
+	error: `cannot parse ownership database .*: unknown format`,
+}, {
+	data:  "# chisel ownership v2\n",
+	error: `cannot parse ownership database .*: unknown format`,
+}, {
+	data:  "# chisel ownership v1\n0 42\n",
+	error: `cannot parse ownership database .*: invalid line 2`,
+}, {
+	data:  "# chisel ownership v1\n0 x /etc/shadow\n",
+	error: `cannot parse ownership database .*: invalid line 2`,
+}, {
+	data:  "# chisel ownership v1\n0 -1 /etc/shadow\n",
+	error: `cannot parse ownership database .*: invalid line 2`,
+}, {
+	data:  "# chisel ownership v1\n0 42 etc/shadow\n",
+	error: `cannot parse ownership database .*: invalid line 2`,
+}, {
+	data:  "# chisel ownership v1\n0 42 system_u:object_r:shadow_t:s0\n",
+	error: `cannot parse ownership database .*: invalid line 2`,
+}}
+
+func (s *S) TestReadErrors(c *C) {
+	for _, test := range readErrorTests {
+		path := filepath.Join(c.MkDir(), "ownership")
+		err := os.WriteFile(path, []byte(test.data), 0644)
+		c.Assert(err, IsNil)
+		_, err = ownership.Read(path)
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
@@ -0,0 +1,116 @@
+// Package policy implements the checks which organizations may enforce on
+// the slices, packages and licenses used to cut a tree, so that a cut which
+// does not comply with their rules fails before any content is written.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// Policy decides whether the inputs of a cut are allowed. Each method
+// returns an error explaining why the input is denied, or nil. Policies
+// are enforced by setting slicer.RunOptions.Policy, either to the one
+// returned by Exec or to any other implementation.
+type Policy interface {
+	// CheckSelection is called once the slices to cut are selected.
+	CheckSelection(selection *setup.Selection) error
+	// CheckPackage is called with the name of the archive and the
+	// information of each package before it is fetched.
+	CheckPackage(archive string, info *archive.PackageInfo) error
+	// CheckLicenses is called with the licenses declared in the copyright
+	// file of each package fetched, which may be none.
+	CheckLicenses(pkg string, licenses []string) error
+}
+
+// Exec returns a policy which runs command to decide on each check, as in
+// "<command> selection", "<command> package" or "<command> licenses", with
+// the input of the check written to its standard input in JSON format. The
+// command allows the input by exiting with status 0, and denies it by
+// exiting with any other status, explaining why in its output.
+func Exec(command string) Policy {
+	return &execPolicy{command: command}
+}
+
+type execPolicy struct {
+	command string
+}
+
+type selectionInput struct {
+	Slices []string `json:"slices"`
+}
+
+type packageInput struct {
+	Archive string `json:"archive"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	SHA256  string `json:"sha256"`
+}
+
+type licensesInput struct {
+	Package  string   `json:"package"`
+	Licenses []string `json:"licenses"`
+}
+
+func (p *execPolicy) CheckSelection(selection *setup.Selection) error {
+	input := selectionInput{Slices: []string{}}
+	for _, slice := range selection.Slices {
+		input.Slices = append(input.Slices, slice.String())
+	}
+	return p.run("selection", "selection", input)
+}
+
+func (p *execPolicy) CheckPackage(archive string, info *archive.PackageInfo) error {
+	input := packageInput{
+		Archive: archive,
+		Name:    info.Name,
+		Version: info.Version,
+		Arch:    info.Arch,
+		SHA256:  info.SHA256,
+	}
+	return p.run("package", "package "+info.Name, input)
+}
+
+func (p *execPolicy) CheckLicenses(pkg string, licenses []string) error {
+	input := licensesInput{Package: pkg, Licenses: licenses}
+	if input.Licenses == nil {
+		input.Licenses = []string{}
+	}
+	return p.run("licenses", "licenses of package "+pkg, input)
+}
+
+// run runs the policy command for the check with the given input, and
+// returns an error denying subject if the command exits with a non-zero
+// status.
+func (p *execPolicy) run(check string, subject string, input any) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(p.command, check)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		message := strings.TrimSpace(output.String())
+		if message == "" {
+			message = fmt.Sprintf("exit status %d", exitErr.ExitCode())
+		}
+		return fmt.Errorf("policy denies %s: %s", subject, message)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot run policy command: %w", err)
+	}
+	return nil
+}
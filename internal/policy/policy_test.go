@@ -0,0 +1,85 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/policy"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// policyScript records its arguments and input, and denies any input
+// mentioning "denied".
+const policyScript = `#!/bin/sh
+input=$(cat)
+echo "$1 $input" >> "$(dirname "$0")/log"
+case "$input" in
+*denied*)
+	echo "$1 not allowed by the organization"
+	exit 1
+	;;
+esac
+`
+
+func writePolicyScript(c *C) (command string, logPath string) {
+	dir := c.MkDir()
+	command = filepath.Join(dir, "policy")
+	err := os.WriteFile(command, []byte(policyScript), 0755)
+	c.Assert(err, IsNil)
+	return command, filepath.Join(dir, "log")
+}
+
+func (s *S) TestExec(c *C) {
+	command, logPath := writePolicyScript(c)
+	p := policy.Exec(command)
+
+	selection := &setup.Selection{Slices: []*setup.Slice{
+		{Package: "mypkg", Name: "bins"},
+		{Package: "mypkg", Name: "config"},
+	}}
+	err := p.CheckSelection(selection)
+	c.Assert(err, IsNil)
+	selection.Slices[1].Name = "denied"
+	err = p.CheckSelection(selection)
+	c.Assert(err, ErrorMatches, "policy denies selection: selection not allowed by the organization")
+
+	info := &archive.PackageInfo{Name: "mypkg", Version: "1.0", Arch: "amd64", SHA256: "abcd"}
+	err = p.CheckPackage("ubuntu", info)
+	c.Assert(err, IsNil)
+	info.Version = "1.0-denied"
+	err = p.CheckPackage("ubuntu", info)
+	c.Assert(err, ErrorMatches, "policy denies package mypkg: package not allowed by the organization")
+
+	err = p.CheckLicenses("mypkg", nil)
+	c.Assert(err, IsNil)
+	err = p.CheckLicenses("mypkg", []string{"MIT", "denied"})
+	c.Assert(err, ErrorMatches, "policy denies licenses of package mypkg: licenses not allowed by the organization")
+
+	data, err := os.ReadFile(logPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ``+
+		`selection {"slices":["mypkg_bins","mypkg_config"]}`+"\n"+
+		`selection {"slices":["mypkg_bins","mypkg_denied"]}`+"\n"+
+		`package {"archive":"ubuntu","name":"mypkg","version":"1.0","arch":"amd64","sha256":"abcd"}`+"\n"+
+		`package {"archive":"ubuntu","name":"mypkg","version":"1.0-denied","arch":"amd64","sha256":"abcd"}`+"\n"+
+		`licenses {"package":"mypkg","licenses":[]}`+"\n"+
+		`licenses {"package":"mypkg","licenses":["MIT","denied"]}`+"\n")
+}
+
+func (s *S) TestExecNoOutput(c *C) {
+	command := filepath.Join(c.MkDir(), "policy")
+	err := os.WriteFile(command, []byte("#!/bin/sh\nexit 3\n"), 0755)
+	c.Assert(err, IsNil)
+
+	err = policy.Exec(command).CheckLicenses("mypkg", []string{"MIT"})
+	c.Assert(err, ErrorMatches, "policy denies licenses of package mypkg: exit status 3")
+}
+
+func (s *S) TestExecNotFound(c *C) {
+	command := filepath.Join(c.MkDir(), "missing")
+	err := policy.Exec(command).CheckSelection(&setup.Selection{})
+	c.Assert(err, ErrorMatches, "cannot run policy command: .*no such file or directory")
+}
@@ -0,0 +1,338 @@
+// Package refresh suggests the changes slice definitions need when their
+// package is updated, by comparing the contents of two of its versions, and
+// applies them to slice definition files.
+package refresh
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
+)
+
+type ChangeKind string
+
+const (
+	// Removed paths are not in the new version of the package.
+	Removed ChangeKind = "removed"
+	// Renamed paths are shared libraries whose soname changed in the new
+	// version of the package.
+	Renamed ChangeKind = "renamed"
+	// Added paths are binaries new to the package.
+	Added ChangeKind = "added"
+)
+
+// Change is a change suggested to a slice.
+type Change struct {
+	Kind ChangeKind
+	// Slice is the name of the slice changed, which is empty for added
+	// paths for which no slice could be chosen.
+	Slice   string
+	Path    string
+	NewPath string
+}
+
+func (c *Change) String() string {
+	switch {
+	case c.Kind == Removed:
+		return fmt.Sprintf("Remove %s from slice %s", c.Path, c.Slice)
+	case c.Kind == Renamed:
+		return fmt.Sprintf("Rename %s to %s in slice %s", c.Path, c.NewPath, c.Slice)
+	case c.Slice == "":
+		return fmt.Sprintf("Add %s to some slice", c.Path)
+	}
+	return fmt.Sprintf("Add %s to slice %s", c.Path, c.Slice)
+}
+
+// binDirs lists the directories whose new files are suggested to be added
+// to slices.
+var binDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/libexec"}
+
+// Suggest returns the changes the slices of the package need for the
+// architecture given the paths in the old and new versions of the package,
+// with the paths of directories ending in "/".
+func Suggest(pkg *setup.Package, arch string, oldPaths, newPaths []string) []*Change {
+	oldFiles := fileSet(oldPaths)
+	newFiles := fileSet(newPaths)
+	var added []string
+	for filePath := range newFiles {
+		if !oldFiles[filePath] {
+			added = append(added, filePath)
+		}
+	}
+	slices.Sort(added)
+
+	listed := make(map[string]bool)
+	for _, slice := range pkg.Slices {
+		for targetPath := range slice.Contents {
+			listed[targetPath] = true
+		}
+	}
+
+	var changes []*Change
+	renamed := make(map[string]bool)
+	for _, sliceName := range slices.Sorted(maps.Keys(pkg.Slices)) {
+		slice := pkg.Slices[sliceName]
+		for _, targetPath := range slices.Sorted(maps.Keys(slice.Contents)) {
+			pathInfo := slice.Contents[targetPath]
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+			switch pathInfo.Kind {
+			case setup.CopyPath:
+				if pathInfo.Info != "" || !oldFiles[targetPath] || newFiles[targetPath] {
+					continue
+				}
+				newPath := renamedLib(targetPath, added)
+				if newPath != "" && !listed[newPath] {
+					renamed[newPath] = true
+					changes = append(changes, &Change{Kind: Renamed, Slice: sliceName, Path: targetPath, NewPath: newPath})
+				} else {
+					changes = append(changes, &Change{Kind: Removed, Slice: sliceName, Path: targetPath})
+				}
+			case setup.GlobPath:
+				if matchesAny(targetPath, oldFiles) && !matchesAny(targetPath, newFiles) {
+					changes = append(changes, &Change{Kind: Removed, Slice: sliceName, Path: targetPath})
+				}
+			}
+		}
+	}
+
+	for _, newPath := range added {
+		if !slices.Contains(binDirs, path.Dir(newPath)) || renamed[newPath] || listed[newPath] {
+			continue
+		}
+		covered := false
+		for _, slice := range pkg.Slices {
+			for targetPath, pathInfo := range slice.Contents {
+				if pathInfo.Kind == setup.GlobPath && strdist.GlobPath(targetPath, newPath) {
+					covered = true
+				}
+			}
+		}
+		if !covered {
+			changes = append(changes, &Change{Kind: Added, Slice: binSlice(pkg, path.Dir(newPath)), Path: newPath})
+		}
+	}
+	return changes
+}
+
+// fileSet returns the paths which are not directories.
+func fileSet(paths []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, p := range paths {
+		if !strings.HasSuffix(p, "/") {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+func matchesAny(glob string, files map[string]bool) bool {
+	for filePath := range files {
+		if strdist.GlobPath(glob, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// libVersionExp matches library names versioned after the ".so"
+	// suffix, as in "libssl.so.3".
+	libVersionExp = regexp.MustCompile(`^(lib.+\.so)((?:\.[0-9]+)+)$`)
+	// libReleaseExp matches library names versioned before the ".so"
+	// suffix, as in "libicuuc-74.2.so".
+	libReleaseExp = regexp.MustCompile(`^(lib.+?)-([0-9][0-9.]*)(\.so)$`)
+)
+
+// renamedLib returns the path among the added ones which is the same
+// shared library as libPath with another version, or an empty string if
+// there is none. Candidates with as many version components as libPath
+// are preferred, so that symlinks and files are renamed alike.
+func renamedLib(libPath string, added []string) string {
+	stem, version := libStem(libPath)
+	if stem == "" {
+		return ""
+	}
+	var found string
+	for _, addedPath := range added {
+		addedStem, addedVersion := libStem(addedPath)
+		if addedStem != stem {
+			continue
+		}
+		if strings.Count(addedVersion, ".") == strings.Count(version, ".") {
+			return addedPath
+		}
+		if found == "" {
+			found = addedPath
+		}
+	}
+	return found
+}
+
+// libStem returns the path of the shared library without its version, and
+// the version, or empty strings if the path is not a versioned library.
+func libStem(libPath string) (stem, version string) {
+	dir, name := path.Split(libPath)
+	if m := libVersionExp.FindStringSubmatch(name); m != nil {
+		return dir + m[1], m[2]
+	}
+	if m := libReleaseExp.FindStringSubmatch(name); m != nil {
+		return dir + m[1] + "-*" + m[3], m[2]
+	}
+	return "", ""
+}
+
+// binSlice returns the name of the slice with the most paths in dir, or an
+// empty string if there is none.
+func binSlice(pkg *setup.Package, dir string) string {
+	var chosen string
+	chosenCount := 0
+	for _, sliceName := range slices.Sorted(maps.Keys(pkg.Slices)) {
+		count := 0
+		for targetPath, pathInfo := range pkg.Slices[sliceName].Contents {
+			if pathInfo.Kind == setup.CopyPath && path.Dir(targetPath) == dir {
+				count++
+			}
+		}
+		if count > chosenCount {
+			chosen = sliceName
+			chosenCount = count
+		}
+	}
+	return chosen
+}
+
+// Apply returns the slice definition file with the changes applied. Lines
+// are removed, changed or added in place so that the rest of the file is
+// left untouched. Changes without a slice are ignored.
+func Apply(data []byte, changes []*Change) ([]byte, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse slice definitions: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("cannot parse slice definitions: empty document")
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	removed := make(map[int]bool)
+	replaced := make(map[int]string)
+	// inserted maps line indexes to the lines inserted after them, with
+	// -1 for the lines inserted at the start of the file.
+	inserted := make(map[int][]string)
+	for _, change := range changes {
+		if change.Slice == "" {
+			continue
+		}
+		contents := mappingValue(mappingValue(mappingValue(doc.Content[0], "slices"), change.Slice), "contents")
+		if contents == nil || contents.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("cannot find contents of slice %q", change.Slice)
+		}
+		switch change.Kind {
+		case Removed, Renamed:
+			key, value := mappingEntry(contents, change.Path)
+			if key == nil {
+				return nil, fmt.Errorf("cannot find %s in slice %q", change.Path, change.Slice)
+			}
+			if change.Kind == Renamed {
+				index := key.Line - 1
+				column := key.Column - 1
+				line := lines[index]
+				replaced[index] = line[:column] + strings.Replace(line[column:], change.Path, change.NewPath, 1)
+				continue
+			}
+			for line := key.Line; line <= lastLine(value); line++ {
+				removed[line-1] = true
+			}
+		case Added:
+			// Add the path after the last one sorting before it in the
+			// same directory, or before the first one otherwise.
+			var after, before *yaml.Node
+			var afterValue *yaml.Node
+			for i := 0; i+1 < len(contents.Content); i += 2 {
+				key := contents.Content[i]
+				if path.Dir(key.Value) != path.Dir(change.Path) {
+					continue
+				}
+				if key.Value < change.Path {
+					after, afterValue = key, contents.Content[i+1]
+				} else if before == nil {
+					before = key
+				}
+			}
+			anchor := after
+			if anchor == nil {
+				anchor = before
+			}
+			if anchor == nil {
+				anchor = contents.Content[len(contents.Content)-2]
+				afterValue = contents.Content[len(contents.Content)-1]
+			}
+			line := lines[anchor.Line-1]
+			entry := line[:anchor.Column-1] + change.Path + ":\n"
+			if anchor == before {
+				inserted[anchor.Line-2] = append(inserted[anchor.Line-2], entry)
+			} else {
+				index := lastLine(afterValue) - 1
+				inserted[index] = append(inserted[index], entry)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range inserted[-1] {
+		buf.WriteString(entry)
+	}
+	for i, line := range lines {
+		if !removed[i] {
+			if replacement, ok := replaced[i]; ok {
+				line = replacement
+			}
+			buf.WriteString(line)
+		}
+		for _, entry := range inserted[i] {
+			buf.WriteString(entry)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	_, value := mappingEntry(node, key)
+	return value
+}
+
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// lastLine returns the last line of the node and its children.
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	for _, child := range node.Content {
+		last = max(last, lastLine(child))
+	}
+	return last
+}
@@ -0,0 +1,240 @@
+package refresh_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/refresh"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var refreshTests = []struct {
+	summary  string
+	slices   string
+	oldPaths []string
+	newPaths []string
+	changes  []string
+	result   string
+}{{
+	summary: "Nothing to change",
+	slices: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+	`,
+	oldPaths: []string{"/usr/", "/usr/bin/", "/usr/bin/app"},
+	newPaths: []string{"/usr/", "/usr/bin/", "/usr/bin/app", "/usr/share/doc/README"},
+}, {
+	summary: "Removed paths",
+	slices: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+					/usr/bin/gone:
+						arch: [amd64, arm64]
+					/usr/bin/other:
+					/usr/share/gone/**:
+	`,
+	oldPaths: []string{"/usr/bin/app", "/usr/bin/gone", "/usr/bin/other", "/usr/share/gone/file"},
+	newPaths: []string{"/usr/bin/app", "/usr/bin/other"},
+	changes: []string{
+		"Remove /usr/bin/gone from slice bins",
+		"Remove /usr/share/gone/** from slice bins",
+	},
+	result: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app:
+					/usr/bin/other:
+	`,
+}, {
+	summary: "Paths of other architectures are ignored",
+	slices: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/app: {arch: arm64}
+	`,
+	oldPaths: []string{"/usr/bin/app"},
+	newPaths: []string{},
+}, {
+	summary: "Renamed sonames",
+	slices: `
+		package: libfoo
+		slices:
+			libs:
+				contents:
+					/usr/lib/libfoo.so.1:
+					/usr/lib/libfoo.so.1.2.3:
+					/usr/lib/libbar-1.0.so: {mode: 0644}
+	`,
+	oldPaths: []string{"/usr/lib/libfoo.so.1", "/usr/lib/libfoo.so.1.2.3", "/usr/lib/libbar-1.0.so"},
+	newPaths: []string{"/usr/lib/libfoo.so.2", "/usr/lib/libfoo.so.2.0.0", "/usr/lib/libbar-1.1.so"},
+	changes: []string{
+		"Rename /usr/lib/libbar-1.0.so to /usr/lib/libbar-1.1.so in slice libs",
+		"Rename /usr/lib/libfoo.so.1 to /usr/lib/libfoo.so.2 in slice libs",
+		"Rename /usr/lib/libfoo.so.1.2.3 to /usr/lib/libfoo.so.2.0.0 in slice libs",
+	},
+	result: `
+		package: libfoo
+		slices:
+			libs:
+				contents:
+					/usr/lib/libfoo.so.2:
+					/usr/lib/libfoo.so.2.0.0:
+					/usr/lib/libbar-1.1.so: {mode: 0644}
+	`,
+}, {
+	summary: "Added binaries",
+	slices: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/bar:
+					/usr/bin/foo:
+			config:
+				contents:
+					/etc/app.conf:
+			tools:
+				contents:
+					/usr/sbin/tool:
+			libexec:
+				contents:
+					/usr/libexec/app/**:
+	`,
+	oldPaths: []string{"/etc/app.conf", "/usr/bin/bar", "/usr/bin/foo", "/usr/sbin/tool"},
+	newPaths: []string{
+		"/etc/app.conf", "/usr/bin/bar", "/usr/bin/foo", "/usr/sbin/tool",
+		"/usr/bin/aaa", "/usr/bin/baz", "/usr/sbin/zzz", "/sbin/init", "/usr/libexec/app/helper",
+		"/usr/share/doc/new",
+	},
+	changes: []string{
+		"Add /sbin/init to some slice",
+		"Add /usr/bin/aaa to slice bins",
+		"Add /usr/bin/baz to slice bins",
+		"Add /usr/sbin/zzz to slice tools",
+	},
+	result: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/aaa:
+					/usr/bin/bar:
+					/usr/bin/baz:
+					/usr/bin/foo:
+			config:
+				contents:
+					/etc/app.conf:
+			tools:
+				contents:
+					/usr/sbin/tool:
+					/usr/sbin/zzz:
+			libexec:
+				contents:
+					/usr/libexec/app/**:
+	`,
+}}
+
+func (s *S) TestSuggestAndApply(c *C) {
+	for _, test := range refreshTests {
+		c.Logf("Summary: %s", test.summary)
+		pkg, data := readPackage(c, test.slices)
+		changes := refresh.Suggest(pkg, "amd64", test.oldPaths, test.newPaths)
+		var descriptions []string
+		for _, change := range changes {
+			descriptions = append(descriptions, change.String())
+		}
+		c.Assert(descriptions, DeepEquals, test.changes)
+
+		result, err := refresh.Apply(data, changes)
+		c.Assert(err, IsNil)
+		if test.result == "" {
+			c.Assert(string(result), Equals, string(data))
+		} else {
+			c.Assert(string(result), Equals, string(testutil.Reindent(test.result)))
+		}
+	}
+}
+
+func (s *S) TestDiff(c *C) {
+	old := []byte("" +
+		"package: mypkg\n" +
+		"slices:\n" +
+		"  bins:\n" +
+		"    contents:\n" +
+		"      /usr/bin/app:\n" +
+		"      /usr/bin/gone:\n" +
+		"      /usr/bin/other:\n" +
+		"  config:\n" +
+		"    contents:\n" +
+		"      /etc/a:\n" +
+		"      /etc/b:\n" +
+		"      /etc/c:\n" +
+		"      /etc/d:\n" +
+		"      /etc/e:\n" +
+		"      /etc/f:\n" +
+		"      /etc/g:\n")
+	new := []byte("" +
+		"package: mypkg\n" +
+		"slices:\n" +
+		"  bins:\n" +
+		"    contents:\n" +
+		"      /usr/bin/app:\n" +
+		"      /usr/bin/other:\n" +
+		"  config:\n" +
+		"    contents:\n" +
+		"      /etc/a:\n" +
+		"      /etc/b:\n" +
+		"      /etc/c:\n" +
+		"      /etc/d:\n" +
+		"      /etc/e:\n" +
+		"      /etc/f:\n" +
+		"      /etc/g:\n" +
+		"      /etc/h:\n")
+	c.Assert(refresh.Diff("slices/mypkg.yaml", old, new), Equals, ""+
+		"--- a/slices/mypkg.yaml\n"+
+		"+++ b/slices/mypkg.yaml\n"+
+		"@@ -3,7 +3,6 @@\n"+
+		"   bins:\n"+
+		"     contents:\n"+
+		"       /usr/bin/app:\n"+
+		"-      /usr/bin/gone:\n"+
+		"       /usr/bin/other:\n"+
+		"   config:\n"+
+		"     contents:\n"+
+		"@@ -14,3 +13,4 @@\n"+
+		"       /etc/e:\n"+
+		"       /etc/f:\n"+
+		"       /etc/g:\n"+
+		"+      /etc/h:\n")
+	c.Assert(refresh.Diff("slices/mypkg.yaml", old, old), Equals, "")
+}
+
+func readPackage(c *C, slices string) (*setup.Package, []byte) {
+	dir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+	data := testutil.Reindent(slices)
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	pkgName := strings.TrimPrefix(firstLine, "package: ")
+	err = os.WriteFile(filepath.Join(dir, "slices", pkgName+".yaml"), data, 0644)
+	c.Assert(err, IsNil)
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+	return release.Packages[pkgName], data
+}
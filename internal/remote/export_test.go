@@ -0,0 +1,9 @@
+package remote
+
+func FakeSSHCommand(command string) (restore func()) {
+	old := sshCommand
+	sshCommand = command
+	return func() {
+		sshCommand = old
+	}
+}
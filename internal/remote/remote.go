@@ -0,0 +1,131 @@
+// Package remote implements copying the content of a local directory onto
+// a remote machine over SSH.
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/tarutil"
+)
+
+// Target is a directory on a remote machine reachable over SSH.
+type Target struct {
+	User string
+	Host string
+	Port string
+	Dir  string
+}
+
+// sshCommand is the command used to connect to the remote machine.
+var sshCommand = "ssh"
+
+// ParseTarget parses a location in the format
+// "ssh://[<user>@]<host>[:<port>]/<dir>". It returns false if the location
+// does not use the ssh scheme.
+func ParseTarget(location string) (*Target, bool, error) {
+	if !strings.HasPrefix(location, "ssh://") {
+		return nil, false, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid remote location %q: %w", location, err)
+	}
+	if u.Hostname() == "" {
+		return nil, true, fmt.Errorf("invalid remote location %q: missing host", location)
+	}
+	if strings.HasPrefix(u.Hostname(), "-") || strings.HasPrefix(u.User.Username(), "-") {
+		// Would be taken as an option by ssh.
+		return nil, true, fmt.Errorf("invalid remote location %q: host and user cannot start with \"-\"", location)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return nil, true, fmt.Errorf("invalid remote location %q: missing directory", location)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, true, fmt.Errorf("invalid remote location %q: unexpected query or fragment", location)
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return nil, true, fmt.Errorf("invalid remote location %q: passwords are not supported", location)
+	}
+	target := &Target{
+		User: u.User.Username(),
+		Host: u.Hostname(),
+		Port: u.Port(),
+		Dir:  filepath.Clean(u.Path),
+	}
+	return target, true, nil
+}
+
+func (t *Target) String() string {
+	var buf strings.Builder
+	buf.WriteString("ssh://")
+	if t.User != "" {
+		buf.WriteString(t.User + "@")
+	}
+	buf.WriteString(t.Host)
+	if t.Port != "" {
+		buf.WriteString(":" + t.Port)
+	}
+	buf.WriteString(t.Dir)
+	return buf.String()
+}
+
+// Upload copies the content of localDir into the target directory, which is
+// created if missing. The content is streamed to tar running on the remote
+// machine, so tar must be available there. Owners and labels recorded in
+// db, if any, are applied to the uploaded entries. Labels require a tar
+// supporting the --selinux option.
+func (t *Target) Upload(localDir string, db *ownership.DB) error {
+	var args []string
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	host := t.Host
+	if t.User != "" {
+		host = t.User + "@" + host
+	}
+	dir := shellQuote(t.Dir)
+	tarArgs := "-x -p"
+	if db != nil && db.HasLabels() {
+		tarArgs += " --selinux"
+	}
+	args = append(args, host, "--", fmt.Sprintf("mkdir -p %s && tar %s -f - -C %s", dir, tarArgs, dir))
+
+	cmd := exec.Command(sshCommand, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("cannot connect to %s: %w", t, err)
+	}
+	writeErr := tarutil.Write(stdin, localDir, db)
+	closeErr := stdin.Close()
+	waitErr := cmd.Wait()
+	if waitErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("cannot upload to %s: %s", t, msg)
+		}
+		return fmt.Errorf("cannot upload to %s: %w", t, waitErr)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("cannot upload to %s: %w", t, writeErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("cannot upload to %s: %w", t, closeErr)
+	}
+	return nil
+}
+
+// shellQuote quotes s to be used as a single word in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
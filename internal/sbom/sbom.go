@@ -0,0 +1,275 @@
+// Package sbom implements the generation of software bills of materials
+// listing the packages recorded in the manifest of a tree.
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/canonical/chisel/public/manifest"
+)
+
+// Options describe the document generated.
+type Options struct {
+	// Name of the document, such as the name of the image.
+	Name string
+	// Created is the time the document is considered created at, which
+	// is recorded for the generation to be reproducible.
+	Created time.Time
+}
+
+// Package is a package listed in a bill of materials.
+type Package struct {
+	Name     string
+	Version  string
+	Arch     string
+	Digest   string
+	Licenses []string
+	// Source and SourceVersion identify the source package the package is
+	// built from, when known.
+	Source        string
+	SourceVersion string
+}
+
+// PURL returns the package URL of the package, as in
+// "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64".
+func (p *Package) PURL() string {
+	purl := "pkg:deb/ubuntu/" + url.PathEscape(p.Name) + "@" + url.PathEscape(p.Version)
+	var qualifiers []string
+	if p.Arch != "" {
+		qualifiers = append(qualifiers, "arch="+url.QueryEscape(p.Arch))
+	}
+	if p.Source != "" && p.Source != p.Name {
+		upstream := p.Source
+		if p.SourceVersion != "" && p.SourceVersion != p.Version {
+			upstream += "@" + p.SourceVersion
+		}
+		qualifiers = append(qualifiers, "upstream="+url.QueryEscape(upstream))
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// Packages returns the packages recorded in the manifest.
+func Packages(mfest *manifest.Manifest) ([]*Package, error) {
+	var pkgs []*Package
+	err := mfest.IteratePackages(func(pkg *manifest.Package) error {
+		pkgs = append(pkgs, &Package{
+			Name:          pkg.Name,
+			Version:       pkg.Version,
+			Arch:          pkg.Arch,
+			Digest:        pkg.Digest,
+			Licenses:      pkg.Licenses,
+			Source:        pkg.Source,
+			SourceVersion: pkg.SourceVersion,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+type spdxDocument struct {
+	SPDXVersion       string          `json:"spdxVersion"`
+	DataLicense       string          `json:"dataLicense"`
+	SPDXID            string          `json:"SPDXID"`
+	Name              string          `json:"name"`
+	DocumentNamespace string          `json:"documentNamespace"`
+	CreationInfo      spdxCreation    `json:"creationInfo"`
+	Packages          []*spdxPackage  `json:"packages"`
+	Relationships     []*spdxRelation `json:"relationships"`
+}
+
+type spdxCreation struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string         `json:"name"`
+	SPDXID           string         `json:"SPDXID"`
+	VersionInfo      string         `json:"versionInfo"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	LicenseDeclared  string         `json:"licenseDeclared"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+	ExternalRefs     []spdxRef      `json:"externalRefs"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxRelation struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// WriteSPDX writes the packages as an SPDX 2.3 document in JSON format.
+func WriteSPDX(w io.Writer, pkgs []*Package, options *Options) error {
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              options.Name,
+		DocumentNamespace: "https://ubuntu.com/chisel/spdx/" + url.PathEscape(options.Name) + "-" + digest(pkgs),
+		CreationInfo: spdxCreation{
+			Created:  options.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: chisel"},
+		},
+		Packages:      []*spdxPackage{},
+		Relationships: []*spdxRelation{},
+	}
+	for _, pkg := range pkgs {
+		id := "SPDXRef-Package-deb-" + spdxID(pkg.Name)
+		license := "NOASSERTION"
+		if len(pkg.Licenses) > 0 {
+			license = strings.Join(pkg.Licenses, " AND ")
+		}
+		spdxPkg := &spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           id,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseDeclared:  license,
+			ExternalRefs: []spdxRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  pkg.PURL(),
+			}},
+		}
+		if pkg.Digest != "" {
+			spdxPkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", Value: pkg.Digest}}
+		}
+		doc.Packages = append(doc.Packages, spdxPkg)
+		doc.Relationships = append(doc.Relationships, &spdxRelation{
+			Element: "SPDXRef-DOCUMENT",
+			Type:    "DESCRIBES",
+			Related: id,
+		})
+	}
+	return writeJSON(w, doc)
+}
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []*cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     []cdxTool     `json:"tools"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxTool struct {
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type     string       `json:"type"`
+	BOMRef   string       `json:"bom-ref,omitempty"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	PURL     string       `json:"purl,omitempty"`
+	Hashes   []cdxHash    `json:"hashes,omitempty"`
+	Licenses []cdxLicense `json:"licenses,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseName `json:"license"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+// WriteCycloneDX writes the packages as a CycloneDX 1.5 document in JSON
+// format.
+func WriteCycloneDX(w io.Writer, pkgs []*Package, options *Options) error {
+	sum := digest(pkgs)
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: fmt.Sprintf("urn:uuid:%s-%s-%s-%s-%s", sum[0:8], sum[8:12], sum[12:16], sum[16:20], sum[20:32]),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: options.Created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "chisel"}},
+			Component: &cdxComponent{Type: "container", Name: options.Name},
+		},
+		Components: []*cdxComponent{},
+	}
+	for _, pkg := range pkgs {
+		purl := pkg.PURL()
+		component := &cdxComponent{
+			Type:    "library",
+			BOMRef:  purl,
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    purl,
+		}
+		if pkg.Digest != "" {
+			component.Hashes = []cdxHash{{Alg: "SHA-256", Content: pkg.Digest}}
+		}
+		for _, license := range pkg.Licenses {
+			component.Licenses = append(component.Licenses, cdxLicense{cdxLicenseName{Name: license}})
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	return writeJSON(w, doc)
+}
+
+// digest returns a digest of the packages, which identifies the documents
+// listing them.
+func digest(pkgs []*Package) string {
+	h := sha256.New()
+	for _, pkg := range pkgs {
+		fmt.Fprintf(h, "%s %s %s %s\n", pkg.Name, pkg.Version, pkg.Arch, pkg.Digest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// spdxID returns name with the characters not allowed in SPDX identifiers
+// replaced.
+func spdxID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func writeJSON(w io.Writer, doc any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,141 @@
+package sbom_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/sbom"
+)
+
+var testPackages = []*sbom.Package{{
+	Name:     "libc6",
+	Version:  "2.35-0ubuntu3",
+	Arch:     "amd64",
+	Digest:   "digest1",
+	Licenses: []string{"LGPL-2.1+"},
+	Source:   "glibc",
+}, {
+	Name:    "base-files",
+	Version: "12ubuntu4",
+	Arch:    "amd64",
+}}
+
+var testOptions = &sbom.Options{
+	Name:    "myimage",
+	Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+var purlTests = []struct {
+	pkg  *sbom.Package
+	purl string
+}{{
+	pkg:  &sbom.Package{Name: "libc6", Version: "2.35-0ubuntu3", Arch: "amd64"},
+	purl: "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64",
+}, {
+	pkg:  &sbom.Package{Name: "libc6", Version: "2.35-0ubuntu3", Arch: "amd64", Source: "glibc"},
+	purl: "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64&upstream=glibc",
+}, {
+	pkg:  &sbom.Package{Name: "libssl3", Version: "3.0.2-0ubuntu1.10", Source: "openssl", SourceVersion: "3.0.2-0ubuntu1"},
+	purl: "pkg:deb/ubuntu/libssl3@3.0.2-0ubuntu1.10?upstream=openssl%403.0.2-0ubuntu1",
+}, {
+	pkg:  &sbom.Package{Name: "mypkg", Version: "1:1.0", Source: "mypkg"},
+	purl: "pkg:deb/ubuntu/mypkg@1:1.0",
+}}
+
+func (s *S) TestPURL(c *C) {
+	for _, test := range purlTests {
+		c.Assert(test.pkg.PURL(), Equals, test.purl)
+	}
+}
+
+func (s *S) TestWriteSPDX(c *C) {
+	var buf bytes.Buffer
+	err := sbom.WriteSPDX(&buf, testPackages, testOptions)
+	c.Assert(err, IsNil)
+
+	var doc map[string]any
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc["spdxVersion"], Equals, "SPDX-2.3")
+	c.Assert(doc["name"], Equals, "myimage")
+	c.Assert(doc["documentNamespace"], Matches, "https://ubuntu.com/chisel/spdx/myimage-[0-9a-f]{64}")
+	c.Assert(doc["creationInfo"], DeepEquals, map[string]any{
+		"created":  "2024-01-02T03:04:05Z",
+		"creators": []any{"Tool: chisel"},
+	})
+	c.Assert(doc["packages"], DeepEquals, []any{
+		map[string]any{
+			"name":             "libc6",
+			"SPDXID":           "SPDXRef-Package-deb-libc6",
+			"versionInfo":      "2.35-0ubuntu3",
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseDeclared":  "LGPL-2.1+",
+			"checksums":        []any{map[string]any{"algorithm": "SHA256", "checksumValue": "digest1"}},
+			"externalRefs": []any{map[string]any{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64&upstream=glibc",
+			}},
+		},
+		map[string]any{
+			"name":             "base-files",
+			"SPDXID":           "SPDXRef-Package-deb-base-files",
+			"versionInfo":      "12ubuntu4",
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseDeclared":  "NOASSERTION",
+			"externalRefs": []any{map[string]any{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  "pkg:deb/ubuntu/base-files@12ubuntu4?arch=amd64",
+			}},
+		},
+	})
+	c.Assert(doc["relationships"], HasLen, 2)
+
+	// The document is the same when generated again.
+	var again bytes.Buffer
+	err = sbom.WriteSPDX(&again, testPackages, testOptions)
+	c.Assert(err, IsNil)
+	c.Assert(again.String(), Equals, buf.String())
+}
+
+func (s *S) TestWriteCycloneDX(c *C) {
+	var buf bytes.Buffer
+	err := sbom.WriteCycloneDX(&buf, testPackages, testOptions)
+	c.Assert(err, IsNil)
+
+	var doc map[string]any
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc["bomFormat"], Equals, "CycloneDX")
+	c.Assert(doc["specVersion"], Equals, "1.5")
+	c.Assert(doc["serialNumber"], Matches, "urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}")
+	c.Assert(doc["metadata"], DeepEquals, map[string]any{
+		"timestamp": "2024-01-02T03:04:05Z",
+		"tools":     []any{map[string]any{"name": "chisel"}},
+		"component": map[string]any{"type": "container", "name": "myimage"},
+	})
+	c.Assert(doc["components"], DeepEquals, []any{
+		map[string]any{
+			"type":     "library",
+			"bom-ref":  "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64&upstream=glibc",
+			"name":     "libc6",
+			"version":  "2.35-0ubuntu3",
+			"purl":     "pkg:deb/ubuntu/libc6@2.35-0ubuntu3?arch=amd64&upstream=glibc",
+			"hashes":   []any{map[string]any{"alg": "SHA-256", "content": "digest1"}},
+			"licenses": []any{map[string]any{"license": map[string]any{"name": "LGPL-2.1+"}}},
+		},
+		map[string]any{
+			"type":    "library",
+			"bom-ref": "pkg:deb/ubuntu/base-files@12ubuntu4?arch=amd64",
+			"name":    "base-files",
+			"version": "12ubuntu4",
+			"purl":    "pkg:deb/ubuntu/base-files@12ubuntu4?arch=amd64",
+		},
+	})
+}
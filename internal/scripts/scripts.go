@@ -2,10 +2,14 @@ package scripts
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
@@ -15,14 +19,56 @@ import (
 
 type Value = starlark.Value
 
+// Limits used when the respective RunOptions fields are unset.
+const (
+	DefaultMaxSteps = 100_000_000
+	DefaultTimeout  = time.Minute
+)
+
 type RunOptions struct {
 	Label     string
 	Namespace map[string]Value
 	Script    string
+	// MaxSteps and Timeout limit the computation steps and the wall-clock
+	// time allowed while running the script. Memory is not limited, as
+	// the interpreter does not account for the memory of a script: it
+	// only refuses to build single strings and lists of 1GiB or more.
+	MaxSteps uint64
+	Timeout  time.Duration
+	// Context optionally cancels the script before it completes.
+	Context context.Context
 }
 
+// Run runs the script with the values in the namespace. Scripts are not
+// able to observe anything besides the namespace, so their results only
+// depend on it: loading modules is not supported, and printed messages go
+// to the log instead of the output.
 func Run(opts *RunOptions) error {
-	thread := &starlark.Thread{Name: opts.Label}
+	thread := &starlark.Thread{
+		Name: opts.Label,
+		Load: func(_ *starlark.Thread, _ string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("modules are not supported")
+		},
+		Print: func(_ *starlark.Thread, msg string) {
+			logf("%s: %s", opts.Label, msg)
+		},
+	}
+	maxSteps := opts.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	stop := watch(ctx, thread, timeout)
+	defer stop()
+
 	fileOptions := &syntax.FileOptions{
 		TopLevelControl: true,
 		GlobalReassign:  true,
@@ -32,6 +78,29 @@ func Run(opts *RunOptions) error {
 	return err
 }
 
+// watch cancels the thread once ctx is done or once the timeout expires.
+// The returned function stops watching.
+func watch(ctx context.Context, thread *starlark.Thread, timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-timer.C:
+			thread.Cancel(fmt.Sprintf("timeout after %s", timeout))
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
 type ContentValue struct {
 	RootDir    string
 	CheckRead  func(path string) error
@@ -39,6 +108,9 @@ type ContentValue struct {
 	// OnWrite has to be called after a successful write with the entry resulting
 	// from the write.
 	OnWrite func(entry *fsutil.Entry) error
+	// MapPath optionally maps the paths given to the scripts to the paths
+	// of the content, before they are checked.
+	MapPath func(path string) string
 }
 
 // Content starlark.Value interface
@@ -76,12 +148,16 @@ func (c *ContentValue) Attr(name string) (Value, error) {
 		return starlark.NewBuiltin("Content.write", c.Write), nil
 	case "list":
 		return starlark.NewBuiltin("Content.list", c.List), nil
+	case "patch":
+		return starlark.NewBuiltin("Content.patch", c.Patch), nil
+	case "sha256":
+		return starlark.NewBuiltin("Content.sha256", c.SHA256), nil
 	}
 	return nil, nil
 }
 
 func (c *ContentValue) AttrNames() []string {
-	return []string{"read", "write", "list"}
+	return []string{"read", "write", "list", "patch", "sha256"}
 }
 
 // Content methods
@@ -106,6 +182,9 @@ func (c *ContentValue) RealPath(path string, what Check) (string, error) {
 	if cpath != "/" && strings.HasSuffix(path, "/") {
 		cpath += "/"
 	}
+	if c.MapPath != nil {
+		cpath = c.MapPath(cpath)
+	}
 	if c.CheckRead != nil && what&CheckRead != 0 {
 		err := c.CheckRead(cpath)
 		if err != nil {
@@ -137,9 +216,12 @@ func (c *ContentValue) polishError(path starlark.String, err error) error {
 	return err
 }
 
+// Read returns the content of the file as a string, or as bytes when
+// binary is true.
 func (c *ContentValue) Read(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
 	var path starlark.String
-	err := starlark.UnpackArgs("Content.read", args, kwargs, "path", &path)
+	var binary bool
+	err := starlark.UnpackArgs("Content.read", args, kwargs, "path", &path, "binary?", &binary)
 	if err != nil {
 		return nil, err
 	}
@@ -152,39 +234,117 @@ func (c *ContentValue) Read(thread *starlark.Thread, fn *starlark.Builtin, args
 	if err != nil {
 		return nil, c.polishError(path, err)
 	}
+	if binary {
+		return starlark.Bytes(data), nil
+	}
 	return starlark.String(data), nil
 }
 
+// Write replaces the content of the file with data, which may be either a
+// string or bytes.
 func (c *ContentValue) Write(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
 	var path starlark.String
-	var data starlark.String
+	var data Value
 	err := starlark.UnpackArgs("Content.write", args, kwargs, "path", &path, "data", &data)
 	if err != nil {
 		return nil, err
 	}
+	fdata, err := unpackData("Content.write", data)
+	if err != nil {
+		return nil, err
+	}
 
 	fpath, err := c.RealPath(path.GoString(), CheckWrite)
 	if err != nil {
 		return nil, err
 	}
-	fdata := []byte(data.GoString())
+	err = c.write(path, fpath, fdata)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// Patch overwrites the content of the file at the given offset with data,
+// which may be either a string or bytes. The data must fit within the
+// current size of the file.
+func (c *ContentValue) Patch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
+	var path starlark.String
+	var offset int
+	var data Value
+	err := starlark.UnpackArgs("Content.patch", args, kwargs, "path", &path, "offset", &offset, "data", &data)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := unpackData("Content.patch", data)
+	if err != nil {
+		return nil, err
+	}
+
+	fpath, err := c.RealPath(path.GoString(), CheckRead|CheckWrite)
+	if err != nil {
+		return nil, err
+	}
+	fdata, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, c.polishError(path, err)
+	}
+	if offset < 0 || offset+len(patch) > len(fdata) {
+		return nil, fmt.Errorf("cannot patch %s: range %d-%d is out of the file size %d",
+			path.GoString(), offset, offset+len(patch), len(fdata))
+	}
+	copy(fdata[offset:], patch)
+	err = c.write(path, fpath, fdata)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// SHA256 returns the hex-encoded SHA256 digest of the content of the file.
+func (c *ContentValue) SHA256(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
+	var path starlark.String
+	err := starlark.UnpackArgs("Content.sha256", args, kwargs, "path", &path)
+	if err != nil {
+		return nil, err
+	}
 
+	fpath, err := c.RealPath(path.GoString(), CheckRead)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, c.polishError(path, err)
+	}
+	sum := sha256.Sum256(data)
+	return starlark.String(hex.EncodeToString(sum[:])), nil
+}
+
+func (c *ContentValue) write(path starlark.String, fpath string, data []byte) error {
 	// No mode parameter for now as slices are supposed to list files
 	// explicitly instead.
 	entry, err := fsutil.Create(&fsutil.CreateOptions{
 		Root: "/",
 		Path: fpath,
-		Data: bytes.NewReader(fdata),
+		Data: bytes.NewReader(data),
 		Mode: 0644,
 	})
 	if err != nil {
-		return nil, c.polishError(path, err)
+		return c.polishError(path, err)
 	}
-	err = c.OnWrite(entry)
-	if err != nil {
-		return nil, err
+	return c.OnWrite(entry)
+}
+
+// unpackData returns the content of a string or bytes value.
+func unpackData(fnName string, data Value) ([]byte, error) {
+	switch data := data.(type) {
+	case starlark.String:
+		return []byte(data), nil
+	case starlark.Bytes:
+		return []byte(data), nil
 	}
-	return starlark.None, nil
+	return nil, fmt.Errorf("%s: for parameter data: got %s, want string or bytes", fnName, data.Type())
 }
 
 func (c *ContentValue) List(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
@@ -1,10 +1,12 @@
 package scripts_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -22,6 +24,7 @@ type scriptsTest struct {
 	mutated map[string]string
 	checkr  func(path string) error
 	checkw  func(path string) error
+	hackopt func(opts *scripts.RunOptions)
 	error   string
 }
 
@@ -134,6 +137,89 @@ var scriptsTests = []scriptsTest{{
 		"/foo/file1.txt": "file 0744 5b41362b",
 		"/foo/file2.txt": "file 0644 d98cf53e",
 	},
+}, {
+	summary: "Read and write binary data",
+	content: map[string]string{
+		"foo/file1.bin": "\x00\x01\x02\x03",
+	},
+	script: `
+		data = content.read("/foo/file1.bin", binary=True)
+		if type(data) != "bytes":
+		    fail("unexpected type: " + type(data))
+		content.write("/foo/file2.bin", data)
+	`,
+	result: map[string]string{
+		"/foo/":          "dir 0755",
+		"/foo/file1.bin": "file 0644 054edec1",
+		"/foo/file2.bin": "file 0644 054edec1",
+	},
+	mutated: map[string]string{
+		"/foo/file2.bin": "file 0644 054edec1",
+	},
+}, {
+	summary: "Write rejects other data types",
+	content: map[string]string{
+		"foo/file1.txt": ``,
+	},
+	script: `
+		content.write("/foo/file1.txt", 1)
+	`,
+	error: `Content.write: for parameter data: got int, want string or bytes`,
+}, {
+	summary: "Patch files at an offset",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+		"foo/file2.bin": "\x00\x01\x02\x03",
+	},
+	hackdir: func(c *C, dir string) {
+		fpath1 := filepath.Join(dir, "foo/file1.txt")
+		_ = os.Chmod(fpath1, 0744)
+	},
+	script: `
+		content.patch("/foo/file1.txt", 2, "XY")
+		content.patch("/foo/file2.bin", 1, b"\xff\xfe")
+	`,
+	result: map[string]string{
+		"/foo/":          "dir 0755",
+		"/foo/file1.txt": "file 0744 380c7a34", // "daXY1"
+		"/foo/file2.bin": "file 0644 d1000e91",
+	},
+	mutated: map[string]string{
+		"/foo/file1.txt": "file 0744 380c7a34",
+		"/foo/file2.bin": "file 0644 d1000e91",
+	},
+}, {
+	summary: "Patch must fit within the file",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+	},
+	script: `
+		content.patch("/foo/file1.txt", 4, "XY")
+	`,
+	error: `cannot patch /foo/file1.txt: range 4-6 is out of the file size 5`,
+}, {
+	summary: "Patch offset cannot be negative",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+	},
+	script: `
+		content.patch("/foo/file1.txt", -1, "X")
+	`,
+	error: `cannot patch /foo/file1.txt: range -1-0 is out of the file size 5`,
+}, {
+	summary: "Compute the checksum of a file",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+		"foo/file2.txt": ``,
+	},
+	script: `
+		content.write("/foo/file2.txt", content.sha256("/foo/file1.txt"))
+	`,
+	result: map[string]string{
+		"/foo/":          "dir 0755",
+		"/foo/file1.txt": "file 0644 5b41362b",
+		"/foo/file2.txt": "file 0644 a924fa6e", // sha256("data1")
+	},
 }, {
 	summary: "Forbid relative paths",
 	content: map[string]string{
@@ -301,6 +387,30 @@ var scriptsTests = []scriptsTest{{
 		return nil
 	},
 	error: `no write: /foo/file2.txt`,
+}, {
+	summary: "Check reads on patches",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+	},
+	script: `
+		content.patch("/foo/file1.txt", 0, "X")
+	`,
+	checkr: func(p string) error {
+		return fmt.Errorf("no read: %s", p)
+	},
+	error: `no read: /foo/file1.txt`,
+}, {
+	summary: "Check reads on checksums",
+	content: map[string]string{
+		"foo/file1.txt": `data1`,
+	},
+	script: `
+		content.sha256("/foo/file1.txt")
+	`,
+	checkr: func(p string) error {
+		return fmt.Errorf("no read: %s", p)
+	},
+	error: `no read: /foo/file1.txt`,
 }, {
 	summary: "Top level for loop is allowed",
 	script: `
@@ -308,6 +418,50 @@ var scriptsTests = []scriptsTest{{
 		    pass
 	`,
 	result: map[string]string{},
+}, {
+	summary: "Computation steps are limited",
+	script: `
+		for x in range(1000000):
+		    pass
+	`,
+	hackopt: func(opts *scripts.RunOptions) {
+		opts.MaxSteps = 1000
+	},
+	error: `Starlark computation cancelled: too many steps`,
+}, {
+	summary: "Execution time is limited",
+	script: `
+		for x in range(1000000000):
+		    pass
+	`,
+	hackopt: func(opts *scripts.RunOptions) {
+		opts.Timeout = 10 * time.Millisecond
+	},
+	error: `Starlark computation cancelled: timeout after 10ms`,
+}, {
+	summary: "Execution may be cancelled",
+	script: `
+		for x in range(1000000000):
+		    pass
+	`,
+	hackopt: func(opts *scripts.RunOptions) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		opts.Context = ctx
+	},
+	error: `Starlark computation cancelled: context canceled`,
+}, {
+	summary: "Huge values cannot be built at once",
+	script: `
+		data = "x" * (1024 * 1024 * 1024)
+	`,
+	error: `excessive repeat \(1 \* 1073741824 elements\)`,
+}, {
+	summary: "Modules cannot be loaded",
+	script: `
+		load("time.star", "now")
+	`,
+	error: `cannot load time.star: modules are not supported`,
 }}
 
 func (s *S) TestScripts(c *C) {
@@ -341,10 +495,14 @@ func (s *S) TestScripts(c *C) {
 		namespace := map[string]scripts.Value{
 			"content": content,
 		}
-		err := scripts.Run(&scripts.RunOptions{
+		opts := &scripts.RunOptions{
 			Namespace: namespace,
 			Script:    string(testutil.Reindent(test.script)),
-		})
+		}
+		if test.hackopt != nil {
+			test.hackopt(opts)
+		}
+		err := scripts.Run(opts)
 		if test.error == "" {
 			c.Assert(err, IsNil)
 		} else {
@@ -0,0 +1,9 @@
+package security
+
+func FakeBaseURL(url string) (restore func()) {
+	old := baseURL
+	baseURL = url
+	return func() {
+		baseURL = old
+	}
+}
@@ -0,0 +1,323 @@
+// Package security implements looking up the Ubuntu Security Notices (USN)
+// affecting particular package versions, based on the OVAL data published
+// by Canonical.
+package security
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// Notice is a security notice fixing issues in a set of packages.
+type Notice struct {
+	// ID is the notice identifier, as in "USN-5569-1".
+	ID    string
+	Title string
+	URL   string
+	CVEs  []string
+	// Fixed maps the names of the binary packages affected to the first
+	// version where the issues are fixed.
+	Fixed map[string]string
+}
+
+// Vulnerability is a notice affecting a particular package version.
+type Vulnerability struct {
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	FixedVersion string   `json:"fixed-version"`
+	Notice       string   `json:"notice"`
+	Title        string   `json:"title"`
+	URL          string   `json:"url,omitempty"`
+	CVEs         []string `json:"cves,omitempty"`
+}
+
+type FetchOptions struct {
+	// Codename is the codename of the Ubuntu release, as in "jammy".
+	Codename string
+	CacheDir string
+}
+
+var bulkClient = &http.Client{
+	Timeout: 5 * time.Minute,
+}
+
+var baseURL = "https://security-metadata.canonical.com/oval/"
+
+// Fetch returns the notices published for the Ubuntu release. The data is
+// cached and only downloaded again when it changes.
+func Fetch(options *FetchOptions) ([]*Notice, error) {
+	logf("Fetching security notices for %s...", options.Codename)
+
+	cacheDir := options.CacheDir
+	if cacheDir == "" {
+		cacheDir = cache.DefaultDir("chisel")
+	}
+	dirName := filepath.Join(cacheDir, "security")
+	err := os.MkdirAll(dirName, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	fileName := "com.ubuntu." + options.Codename + ".usn.oval.xml.bz2"
+	dataPath := filepath.Join(dirName, fileName)
+	tagPath := dataPath + ".etag"
+
+	req, err := http.NewRequest("GET", baseURL+fileName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request for security notices: %w", err)
+	}
+	if _, err := os.Stat(dataPath); err == nil {
+		tagData, err := os.ReadFile(tagPath)
+		if err == nil {
+			req.Header.Add("If-None-Match", string(tagData))
+		}
+	}
+
+	resp, err := bulkClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch security notices: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		err = writeFile(dataPath, resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write security notices: %w", err)
+		}
+		os.Remove(tagPath)
+		if tag := resp.Header.Get("ETag"); tag != "" {
+			err := os.WriteFile(tagPath, []byte(tag), 0644)
+			if err != nil {
+				return nil, fmt.Errorf("cannot write security notices tag file: %w", err)
+			}
+		}
+	case 304:
+		logf("Cached security notices for %s are still up-to-date.", options.Codename)
+	case 404:
+		return nil, fmt.Errorf("no security notices for %q", options.Codename)
+	default:
+		return nil, fmt.Errorf("error from security notices server: %v", resp.Status)
+	}
+
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// The data is published compressed, but is also accepted as is.
+	reader := bufio.NewReader(file)
+	var data io.Reader = reader
+	if magic, _ := reader.Peek(3); string(magic) == "BZh" {
+		data = bzip2.NewReader(reader)
+	}
+	notices, err := ParseOVAL(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse security notices: %w", err)
+	}
+	return notices, nil
+}
+
+// writeFile writes the data to path atomically.
+func writeFile(path string, data io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Audit returns the vulnerabilities affecting the packages, sorted by
+// package name and notice.
+func Audit(notices []*Notice, pkgs []*archive.PackageInfo) []*Vulnerability {
+	var vulns []*Vulnerability
+	for _, pkg := range pkgs {
+		for _, notice := range notices {
+			fixed, ok := notice.Fixed[pkg.Name]
+			if !ok || deb.CompareVersions(pkg.Version, fixed) >= 0 {
+				continue
+			}
+			vulns = append(vulns, &Vulnerability{
+				Package:      pkg.Name,
+				Version:      pkg.Version,
+				FixedVersion: fixed,
+				Notice:       notice.ID,
+				Title:        notice.Title,
+				URL:          notice.URL,
+				CVEs:         notice.CVEs,
+			})
+		}
+	}
+	slices.SortFunc(vulns, func(a, b *Vulnerability) int {
+		if a.Package != b.Package {
+			return strings.Compare(a.Package, b.Package)
+		}
+		return strings.Compare(a.Notice, b.Notice)
+	})
+	return vulns
+}
+
+type ovalReference struct {
+	Source string `xml:"source,attr"`
+	RefID  string `xml:"ref_id,attr"`
+	RefURL string `xml:"ref_url,attr"`
+}
+
+type ovalCriteria struct {
+	Criteria  []ovalCriteria `xml:"criteria"`
+	Criterion []struct {
+		TestRef string `xml:"test_ref,attr"`
+	} `xml:"criterion"`
+}
+
+type ovalDefinitions struct {
+	Definitions []struct {
+		Class    string `xml:"class,attr"`
+		Metadata struct {
+			Title      string          `xml:"title"`
+			References []ovalReference `xml:"reference"`
+		} `xml:"metadata"`
+		Criteria ovalCriteria `xml:"criteria"`
+	} `xml:"definitions>definition"`
+	Tests []struct {
+		ID        string `xml:"id,attr"`
+		ObjectRef struct {
+			Ref string `xml:"object_ref,attr"`
+		} `xml:"object"`
+		StateRef struct {
+			Ref string `xml:"state_ref,attr"`
+		} `xml:"state"`
+	} `xml:"tests>dpkginfo_test"`
+	Objects []struct {
+		ID   string `xml:"id,attr"`
+		Name struct {
+			VarRef string `xml:"var_ref,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"name"`
+	} `xml:"objects>dpkginfo_object"`
+	States []struct {
+		ID  string `xml:"id,attr"`
+		EVR struct {
+			Operation string `xml:"operation,attr"`
+			Value     string `xml:",chardata"`
+		} `xml:"evr"`
+	} `xml:"states>dpkginfo_state"`
+	Variables []struct {
+		ID     string   `xml:"id,attr"`
+		Values []string `xml:"value"`
+	} `xml:"variables>constant_variable"`
+}
+
+// ParseOVAL parses the notices in the OVAL data for USNs. Only the tests
+// on the version of installed packages are considered.
+func ParseOVAL(r io.Reader) ([]*Notice, error) {
+	var oval ovalDefinitions
+	err := xml.NewDecoder(r).Decode(&oval)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string][]string)
+	for _, variable := range oval.Variables {
+		variables[variable.ID] = variable.Values
+	}
+	objects := make(map[string][]string)
+	for _, object := range oval.Objects {
+		if object.Name.VarRef != "" {
+			objects[object.ID] = variables[object.Name.VarRef]
+		} else if name := strings.TrimSpace(object.Name.Value); name != "" {
+			objects[object.ID] = []string{name}
+		}
+	}
+	states := make(map[string]string)
+	for _, state := range oval.States {
+		if state.EVR.Operation == "less than" {
+			states[state.ID] = strings.TrimSpace(state.EVR.Value)
+		}
+	}
+	type packageTest struct {
+		names []string
+		fixed string
+	}
+	tests := make(map[string]packageTest)
+	for _, test := range oval.Tests {
+		fixed, ok := states[test.StateRef.Ref]
+		if !ok {
+			continue
+		}
+		tests[test.ID] = packageTest{objects[test.ObjectRef.Ref], fixed}
+	}
+
+	var notices []*Notice
+	for _, definition := range oval.Definitions {
+		if definition.Class != "patch" {
+			continue
+		}
+		notice := &Notice{
+			Title: definition.Metadata.Title,
+			Fixed: make(map[string]string),
+		}
+		for _, ref := range definition.Metadata.References {
+			switch ref.Source {
+			case "USN":
+				notice.ID = ref.RefID
+				notice.URL = ref.RefURL
+			case "CVE":
+				notice.CVEs = append(notice.CVEs, ref.RefID)
+			}
+		}
+		if notice.ID == "" {
+			continue
+		}
+		// The title is in the format "USN-5569-1 -- Unbound vulnerabilities".
+		if _, title, ok := strings.Cut(notice.Title, " -- "); ok {
+			notice.Title = title
+		}
+		var walk func(criteria *ovalCriteria)
+		walk = func(criteria *ovalCriteria) {
+			for _, criterion := range criteria.Criterion {
+				test, ok := tests[criterion.TestRef]
+				if !ok {
+					continue
+				}
+				for _, name := range test.names {
+					notice.Fixed[name] = stripZeroEpoch(test.fixed)
+				}
+			}
+			for i := range criteria.Criteria {
+				walk(&criteria.Criteria[i])
+			}
+		}
+		walk(&definition.Criteria)
+		if len(notice.Fixed) > 0 {
+			notices = append(notices, notice)
+		}
+	}
+	return notices, nil
+}
+
+// stripZeroEpoch removes the "0:" epoch which OVAL data always includes,
+// so that versions are displayed as in the package indexes.
+func stripZeroEpoch(version string) string {
+	return strings.TrimPrefix(version, "0:")
+}
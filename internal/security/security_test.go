@@ -0,0 +1,181 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/security"
+)
+
+var testOVAL = `<?xml version="1.0" ?>
+<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5" xmlns:linux-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux">
+  <definitions>
+    <definition id="oval:com.ubuntu.jammy:def:100" version="1" class="inventory">
+      <metadata>
+        <title>Check that Ubuntu 22.04 LTS (jammy) is installed.</title>
+      </metadata>
+    </definition>
+    <definition id="oval:com.ubuntu.jammy:def:55691000000" version="1" class="patch">
+      <metadata>
+        <title>USN-5569-1 -- Unbound vulnerabilities</title>
+        <reference source="USN" ref_id="USN-5569-1" ref_url="https://ubuntu.com/security/notices/USN-5569-1"/>
+        <reference source="CVE" ref_id="CVE-2022-30698" ref_url="https://ubuntu.com/security/CVE-2022-30698"/>
+        <reference source="CVE" ref_id="CVE-2022-30699" ref_url="https://ubuntu.com/security/CVE-2022-30699"/>
+      </metadata>
+      <criteria>
+        <extend_definition definition_ref="oval:com.ubuntu.jammy:def:100" applicability_check="true"/>
+        <criteria operator="OR">
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:556910000000" comment="Long Term Support"/>
+        </criteria>
+      </criteria>
+    </definition>
+    <definition id="oval:com.ubuntu.jammy:def:60001000000" version="1" class="patch">
+      <metadata>
+        <title>USN-6000-1 -- OpenSSL vulnerability</title>
+        <reference source="USN" ref_id="USN-6000-1" ref_url="https://ubuntu.com/security/notices/USN-6000-1"/>
+        <reference source="CVE" ref_id="CVE-2023-0001" ref_url="https://ubuntu.com/security/CVE-2023-0001"/>
+      </metadata>
+      <criteria>
+        <criterion test_ref="oval:com.ubuntu.jammy:tst:600010000000" comment="Long Term Support"/>
+      </criteria>
+    </definition>
+  </definitions>
+  <tests>
+    <linux-def:dpkginfo_test id="oval:com.ubuntu.jammy:tst:556910000000" version="1" check="at least one">
+      <linux-def:object object_ref="oval:com.ubuntu.jammy:obj:556910000000"/>
+      <linux-def:state state_ref="oval:com.ubuntu.jammy:ste:556910000000"/>
+    </linux-def:dpkginfo_test>
+    <linux-def:dpkginfo_test id="oval:com.ubuntu.jammy:tst:600010000000" version="1" check="at least one">
+      <linux-def:object object_ref="oval:com.ubuntu.jammy:obj:600010000000"/>
+      <linux-def:state state_ref="oval:com.ubuntu.jammy:ste:600010000000"/>
+    </linux-def:dpkginfo_test>
+  </tests>
+  <objects>
+    <linux-def:dpkginfo_object id="oval:com.ubuntu.jammy:obj:556910000000" version="1">
+      <linux-def:name var_ref="oval:com.ubuntu.jammy:var:556910000000" var_check="at least one"/>
+    </linux-def:dpkginfo_object>
+    <linux-def:dpkginfo_object id="oval:com.ubuntu.jammy:obj:600010000000" version="1">
+      <linux-def:name>libssl3</linux-def:name>
+    </linux-def:dpkginfo_object>
+  </objects>
+  <states>
+    <linux-def:dpkginfo_state id="oval:com.ubuntu.jammy:ste:556910000000" version="1">
+      <linux-def:evr datatype="debian_evr_string" operation="less than">0:1.13.1-1ubuntu5.1</linux-def:evr>
+    </linux-def:dpkginfo_state>
+    <linux-def:dpkginfo_state id="oval:com.ubuntu.jammy:ste:600010000000" version="1">
+      <linux-def:evr datatype="debian_evr_string" operation="less than">0:3.0.2-0ubuntu1.9</linux-def:evr>
+    </linux-def:dpkginfo_state>
+  </states>
+  <variables>
+    <constant_variable id="oval:com.ubuntu.jammy:var:556910000000" version="1" datatype="string">
+      <value>libunbound8</value>
+      <value>unbound</value>
+    </constant_variable>
+  </variables>
+</oval_definitions>
+`
+
+var testNotices = []*security.Notice{{
+	ID:    "USN-5569-1",
+	Title: "Unbound vulnerabilities",
+	URL:   "https://ubuntu.com/security/notices/USN-5569-1",
+	CVEs:  []string{"CVE-2022-30698", "CVE-2022-30699"},
+	Fixed: map[string]string{
+		"libunbound8": "1.13.1-1ubuntu5.1",
+		"unbound":     "1.13.1-1ubuntu5.1",
+	},
+}, {
+	ID:    "USN-6000-1",
+	Title: "OpenSSL vulnerability",
+	URL:   "https://ubuntu.com/security/notices/USN-6000-1",
+	CVEs:  []string{"CVE-2023-0001"},
+	Fixed: map[string]string{
+		"libssl3": "3.0.2-0ubuntu1.9",
+	},
+}}
+
+func (s *S) TestParseOVAL(c *C) {
+	notices, err := security.ParseOVAL(strings.NewReader(testOVAL))
+	c.Assert(err, IsNil)
+	c.Assert(notices, DeepEquals, testNotices)
+}
+
+func (s *S) TestParseOVALError(c *C) {
+	_, err := security.ParseOVAL(strings.NewReader("<oval_definitions>"))
+	c.Assert(err, ErrorMatches, "XML syntax error .*")
+}
+
+func (s *S) TestAudit(c *C) {
+	pkgs := []*archive.PackageInfo{{
+		Name:    "unbound",
+		Version: "1.13.1-1ubuntu5",
+	}, {
+		Name:    "libssl3",
+		Version: "3.0.2-0ubuntu1.9",
+	}, {
+		Name:    "libunbound8",
+		Version: "1.13.1-1ubuntu5",
+	}, {
+		Name:    "base-files",
+		Version: "12ubuntu4",
+	}}
+	vulns := security.Audit(testNotices, pkgs)
+	c.Assert(vulns, DeepEquals, []*security.Vulnerability{{
+		Package:      "libunbound8",
+		Version:      "1.13.1-1ubuntu5",
+		FixedVersion: "1.13.1-1ubuntu5.1",
+		Notice:       "USN-5569-1",
+		Title:        "Unbound vulnerabilities",
+		URL:          "https://ubuntu.com/security/notices/USN-5569-1",
+		CVEs:         []string{"CVE-2022-30698", "CVE-2022-30699"},
+	}, {
+		Package:      "unbound",
+		Version:      "1.13.1-1ubuntu5",
+		FixedVersion: "1.13.1-1ubuntu5.1",
+		Notice:       "USN-5569-1",
+		Title:        "Unbound vulnerabilities",
+		URL:          "https://ubuntu.com/security/notices/USN-5569-1",
+		CVEs:         []string{"CVE-2022-30698", "CVE-2022-30699"},
+	}})
+}
+
+func (s *S) TestFetch(c *C) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/com.ubuntu.jammy.usn.oval.xml.bz2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testOVAL))
+	}))
+	defer server.Close()
+	defer security.FakeBaseURL(server.URL + "/")()
+
+	options := &security.FetchOptions{
+		Codename: "jammy",
+		CacheDir: c.MkDir(),
+	}
+	notices, err := security.Fetch(options)
+	c.Assert(err, IsNil)
+	c.Assert(notices, DeepEquals, testNotices)
+
+	// The cached data is used when unchanged.
+	notices, err = security.Fetch(options)
+	c.Assert(err, IsNil)
+	c.Assert(notices, DeepEquals, testNotices)
+	c.Assert(requests, Equals, 2)
+
+	options.Codename = "unknown"
+	_, err = security.Fetch(options)
+	c.Assert(err, ErrorMatches, `no security notices for "unknown"`)
+}
@@ -0,0 +1,100 @@
+package setup
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// ArchGroups maps the names of groups of architectures, which may be listed
+// in place of the architectures themselves, to the architectures in each.
+var ArchGroups = map[string][]string{
+	"any-arm": {"armhf", "arm64"},
+	"any-x86": {"i386", "amd64"},
+}
+
+// validateArchRef checks that ref is a known architecture or group of
+// architectures, optionally preceded by "!" to exclude it.
+func validateArchRef(ref string) error {
+	name := strings.TrimPrefix(ref, "!")
+	if _, ok := ArchGroups[name]; ok {
+		return nil
+	}
+	return deb.ValidateArch(name)
+}
+
+// isPlainArchList returns whether the list only holds architectures, which
+// need not be resolved.
+func isPlainArchList(list []string) bool {
+	for _, ref := range list {
+		if _, ok := ArchGroups[ref]; ok || strings.HasPrefix(ref, "!") {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveArchList returns the architectures among the ones of the release
+// which are selected by the list. Groups of architectures are expanded, and
+// lists with only exclusions select all of the architectures of the release
+// but the excluded ones.
+func resolveArchList(list []string, archs []string) []string {
+	expand := func(ref string) []string {
+		if group, ok := ArchGroups[ref]; ok {
+			return group
+		}
+		return []string{ref}
+	}
+	var included, excluded []string
+	for _, ref := range list {
+		if name, ok := strings.CutPrefix(ref, "!"); ok {
+			excluded = append(excluded, expand(name)...)
+		} else {
+			included = append(included, expand(ref)...)
+		}
+	}
+	var resolved []string
+	for _, arch := range archs {
+		if len(included) > 0 && !slices.Contains(included, arch) {
+			continue
+		}
+		if !slices.Contains(excluded, arch) {
+			resolved = append(resolved, arch)
+		}
+	}
+	return resolved
+}
+
+// resolveArchs resolves the architecture lists of the paths and essentials
+// of the slices of pkg against the architectures of the release.
+func resolveArchs(pkg *Package, release *Release) error {
+	archs := release.Archs
+	if len(archs) == 0 {
+		archs = deb.Archs()
+	}
+	for _, slice := range pkg.Slices {
+		for path, info := range slice.Contents {
+			if isPlainArchList(info.Arch) {
+				continue
+			}
+			info.Arch = resolveArchList(info.Arch, archs)
+			if len(info.Arch) == 0 {
+				return fmt.Errorf("slice %s has 'arch' for path %s matching no architecture of the release", slice, path)
+			}
+			slice.Contents[path] = info
+		}
+		for key, info := range slice.Essential {
+			if isPlainArchList(info.Arch) {
+				continue
+			}
+			info.Arch = resolveArchList(info.Arch, archs)
+			if len(info.Arch) == 0 {
+				return fmt.Errorf("slice %s has essential %s matching no architecture of the release", slice, key)
+			}
+			slice.Essential[key] = info
+		}
+	}
+	return nil
+}
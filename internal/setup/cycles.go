@@ -0,0 +1,266 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EssentialLink is a slice requiring another slice as essential.
+type EssentialLink struct {
+	Slice     SliceKey
+	Essential SliceKey
+	// Location is the file of the release defining the requirement, with
+	// the line when known, as in "slices/mypkg.yaml:7".
+	Location string
+}
+
+// FormatEssentialCycle returns the links of an essential loop as a single
+// line, such as "a_x (a.yaml:5) -> b_y (b.yaml:9) -> a_x".
+func FormatEssentialCycle(links []EssentialLink) string {
+	var b strings.Builder
+	for _, link := range links {
+		b.WriteString(link.Slice.String())
+		if link.Location != "" {
+			fmt.Fprintf(&b, " (%s)", link.Location)
+		}
+		b.WriteString(" -> ")
+	}
+	if len(links) > 0 {
+		b.WriteString(links[0].Slice.String())
+	}
+	return b.String()
+}
+
+// EssentialCycles returns all the loops in the essentials of the slices in
+// the release, each one as the links from every slice in the loop to the
+// next one. As when the release is validated, when arch is empty only the
+// essentials which are not specific to some architectures are considered.
+func (r *Release) EssentialCycles(arch string) ([][]EssentialLink, error) {
+	var keys []SliceKey
+	for _, pkg := range r.Packages {
+		for _, slice := range pkg.Slices {
+			keys = append(keys, SliceKey{pkg.Name, slice.Name})
+		}
+	}
+	successors, err := essentialGraph(r.Packages, keys, arch)
+	if err != nil {
+		return nil, err
+	}
+	var cycles [][]EssentialLink
+	for _, names := range tarjanSort(successors) {
+		if len(names) < 2 {
+			continue
+		}
+		for _, cycle := range elementaryCycles(successors, names) {
+			links, err := r.essentialLinks(cycle)
+			if err != nil {
+				return nil, err
+			}
+			cycles = append(cycles, links)
+		}
+	}
+	return cycles, nil
+}
+
+// shortestCycle returns one of the shortest cycles going through the nodes
+// of the strongly connected component of the graph, starting at its first
+// node in sorted order.
+func shortestCycle(successors map[string][]string, component []string) []string {
+	var best []string
+	for _, start := range component {
+		parent := make(map[string]string)
+		queue := []string{start}
+		last := ""
+		for len(queue) > 0 && last == "" {
+			node := queue[0]
+			queue = queue[1:]
+			for _, next := range successors[node] {
+				if !slices.Contains(component, next) {
+					continue
+				}
+				if next == start {
+					last = node
+					break
+				}
+				if _, ok := parent[next]; !ok {
+					parent[next] = node
+					queue = append(queue, next)
+				}
+			}
+		}
+		if last == "" {
+			continue
+		}
+		var cycle []string
+		for node := last; node != start; node = parent[node] {
+			cycle = append(cycle, node)
+		}
+		cycle = append(cycle, start)
+		slices.Reverse(cycle)
+		if best == nil || len(cycle) < len(best) {
+			best = cycle
+		}
+	}
+	return best
+}
+
+// elementaryCycles returns all the cycles going through the nodes of the
+// strongly connected component of the graph without repeating any node.
+// Each cycle is returned once, starting at its first node in sorted order.
+func elementaryCycles(successors map[string][]string, component []string) [][]string {
+	component = slices.Clone(component)
+	slices.Sort(component)
+	index := make(map[string]int, len(component))
+	for i, node := range component {
+		index[node] = i
+	}
+	var cycles [][]string
+	for i, start := range component {
+		var path []string
+		onPath := make(map[string]bool)
+		var visit func(node string)
+		visit = func(node string) {
+			path = append(path, node)
+			onPath[node] = true
+			for _, next := range successors[node] {
+				if j, ok := index[next]; !ok || j < i {
+					continue
+				}
+				if next == start {
+					cycles = append(cycles, slices.Clone(path))
+				} else if !onPath[next] {
+					visit(next)
+				}
+			}
+			path = path[:len(path)-1]
+			onPath[node] = false
+		}
+		visit(start)
+	}
+	return cycles
+}
+
+// essentialLinks returns the links between the consecutive slices of the
+// cycle, with the location where each requirement is defined.
+func (r *Release) essentialLinks(cycle []string) ([]EssentialLink, error) {
+	aliases, err := sliceAliases(r.Packages)
+	if err != nil {
+		return nil, err
+	}
+	locator := &essentialLocator{
+		release: r,
+		aliases: aliases,
+		docs:    make(map[string]*yaml.Node),
+	}
+	links := make([]EssentialLink, len(cycle))
+	for i, name := range cycle {
+		link := EssentialLink{
+			Slice:     nodeKey(name),
+			Essential: nodeKey(cycle[(i+1)%len(cycle)]),
+		}
+		link.Location = locator.locate(link.Slice, link.Essential)
+		links[i] = link
+	}
+	return links, nil
+}
+
+// essentialLocator finds where the essentials of slices are defined by
+// reading the slice definition files again, which is only worth doing when
+// reporting issues.
+type essentialLocator struct {
+	release *Release
+	aliases map[SliceKey]SliceKey
+	docs    map[string]*yaml.Node
+}
+
+// locate returns the file and line where slice requires essential, or just
+// the file if the line cannot be found.
+func (l *essentialLocator) locate(slice, essential SliceKey) string {
+	pkg := l.release.Packages[slice.Package]
+	doc := l.doc(pkg.Path)
+	if doc == nil {
+		return pkg.Path
+	}
+	// Slice essentials take precedence over the package ones, as when
+	// the definitions are parsed.
+	sliceNode := mappingValue(mappingValue(doc, "slices"), slice.Slice)
+	for _, node := range []*yaml.Node{sliceNode, doc} {
+		if line := l.line(node, essential); line > 0 {
+			return fmt.Sprintf("%s:%d", pkg.Path, line)
+		}
+	}
+	return pkg.Path
+}
+
+// line returns the line of the entry referring to essential in the
+// "essential" or "v3-essential" fields of node, or zero if there is none.
+func (l *essentialLocator) line(node *yaml.Node, essential SliceKey) int {
+	matches := func(ref string) bool {
+		key, err := ParseSliceKey(ref)
+		if err != nil {
+			return false
+		}
+		if provider, ok := l.aliases[key]; ok {
+			key = provider
+		}
+		return key == essential
+	}
+	if list := mappingValue(node, "essential"); list != nil && list.Kind == yaml.SequenceNode {
+		for _, item := range list.Content {
+			ref := item
+			if item.Kind == yaml.MappingNode {
+				ref = mappingValue(item, "slice")
+			}
+			if ref != nil && matches(ref.Value) {
+				return item.Line
+			}
+		}
+	}
+	if refs := mappingValue(node, "v3-essential"); refs != nil && refs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(refs.Content); i += 2 {
+			if matches(refs.Content[i].Value) {
+				return refs.Content[i].Line
+			}
+		}
+	}
+	return 0
+}
+
+// doc returns the top-level mapping of the slice definitions file at path,
+// relative to the release directory, or nil if it cannot be read.
+func (l *essentialLocator) doc(path string) *yaml.Node {
+	if doc, ok := l.docs[path]; ok {
+		return doc
+	}
+	l.docs[path] = nil
+	data, err := os.ReadFile(filepath.Join(l.release.Path, path))
+	if err != nil {
+		return nil
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(data, &node)
+	if err != nil || node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		return nil
+	}
+	l.docs[path] = node.Content[0]
+	return node.Content[0]
+}
+
+// mappingValue returns the value of key in the mapping node, or nil if node
+// is not a mapping or does not hold key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -1,3 +1,23 @@
 package setup
 
 type YAMLPath = yamlPath
+
+func FakeBaseURL(url string) (restore func()) {
+	old := baseURL
+	baseURL = url
+	return func() {
+		baseURL = old
+	}
+}
+
+func FakeParallelism(n int) (restore func()) {
+	old := parallelism
+	parallelism = n
+	return func() {
+		parallelism = old
+	}
+}
+
+func PathIndexCandidates(paths []string, glob string) []string {
+	return newPathIndex(paths).candidates(glob)
+}
@@ -3,23 +3,35 @@ package setup
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/juju/fslock"
-
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/fsutil"
 )
 
 type FetchOptions struct {
-	Label    string
-	Version  string
+	Label   string
+	Version string
+	// Ref optionally pins the release to a commit of the release
+	// repository, given by its full SHA1, or to one of its tags.
+	Ref string
+	// Refresh fetches the release again even if pinned and cached, and
+	// accepts content differing from the one fetched before for the same
+	// commit.
+	Refresh  bool
 	CacheDir string
 }
 
@@ -27,97 +39,221 @@ var bulkClient = &http.Client{
 	Timeout: 5 * time.Minute,
 }
 
-const baseURL = "https://codeload.github.com/canonical/chisel-releases/tar.gz/refs/heads/"
+var baseURL = "https://codeload.github.com/canonical/chisel-releases/tar.gz/"
+
+var commitExp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ChangedError reports release content which differs from the one fetched
+// before for the same commit.
+type ChangedError struct {
+	Release string
+	Commit  string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("release %s has changed since it was fetched at commit %s", e.Release, e.Commit)
+}
 
 func FetchRelease(options *FetchOptions) (*Release, error) {
+	dirName, err := FetchReleaseDir(options)
+	if err != nil {
+		return nil, err
+	}
+	return ReadRelease(dirName)
+}
+
+// FetchReleaseDir fetches the release into the cache, if not there already,
+// and returns the directory holding it.
+//
+// The commit fetched and the digest of its content are recorded in the
+// cache. Releases pinned to a ref are fetched only once, and their content
+// in the cache is verified against the recorded digest every time. Other
+// releases are fetched again when their branch changes, but content which
+// differs from the one fetched before for the same commit is refused.
+func FetchReleaseDir(options *FetchOptions) (string, error) {
 	logf("Consulting release repository...")
 
-	cacheDir := options.CacheDir
-	if cacheDir == "" {
-		cacheDir = cache.DefaultDir("chisel")
+	name := options.Label + "-" + options.Version
+	if options.Ref != "" {
+		name += "@" + options.Ref
 	}
-
-	dirName := filepath.Join(cacheDir, "releases", options.Label+"-"+options.Version)
+	dirName := ReleaseCacheDir(options)
 	err := os.MkdirAll(dirName, 0755)
 	if err == nil {
-		lockFile := fslock.New(filepath.Join(cacheDir, "releases", ".lock"))
-		err = lockFile.LockWithTimeout(10 * time.Second)
+		var unlock func()
+		unlock, err = fsutil.Lock(context.Background(), filepath.Join(filepath.Dir(dirName), ".lock"), "release cache")
 		if err == nil {
-			defer lockFile.Unlock()
+			defer unlock()
 		}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot create cache directory: %w", err)
+		return "", fmt.Errorf("cannot create cache directory: %w", err)
+	}
+
+	commit, err := readCacheFile(dirName, ".commit")
+	if err != nil {
+		return "", err
+	}
+	digest, err := readCacheFile(dirName, ".digest")
+	if err != nil {
+		return "", err
+	}
+	if options.Ref != "" && digest != "" && !options.Refresh {
+		current, err := ReleaseDigest(dirName)
+		if err != nil {
+			return "", err
+		}
+		if current != digest {
+			return "", &ChangedError{Release: name, Commit: commit}
+		}
+		logf("Cached %s release is pinned at commit %s.", name, commit)
+		return dirName, nil
 	}
 
 	tagName := filepath.Join(dirName, ".etag")
 	tagData, err := os.ReadFile(tagName)
 	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+		return "", err
 	}
 
-	req, err := http.NewRequest("GET", baseURL+options.Label+"-"+options.Version, nil)
+	var url string
+	switch {
+	case options.Ref == "":
+		url = baseURL + "refs/heads/" + options.Label + "-" + options.Version
+	case commitExp.MatchString(options.Ref):
+		url = baseURL + options.Ref
+	default:
+		url = baseURL + "refs/tags/" + options.Ref
+	}
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create request for release information: %w", err)
+		return "", fmt.Errorf("cannot create request for release information: %w", err)
+	}
+	if options.Ref == "" && !options.Refresh {
+		req.Header.Add("If-None-Match", string(tagData))
 	}
-	req.Header.Add("If-None-Match", string(tagData))
 
 	resp, err := bulkClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("cannot talk to release repository: %w", err)
+		return "", &archive.FetchError{Err: fmt.Errorf("cannot talk to release repository: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	cacheIsValid := false
 	switch resp.StatusCode {
 	case 200:
 		// ok
 	case 304:
-		cacheIsValid = true
+		logf("Cached %s release is still up-to-date.", name)
+		return dirName, nil
 	case 401, 404:
-		return nil, fmt.Errorf("no information for %s-%s release", options.Label, options.Version)
+		return "", fmt.Errorf("no information for %s release", name)
 	default:
-		return nil, fmt.Errorf("error from release repository: %v", resp.Status)
+		return "", &archive.FetchError{Err: fmt.Errorf("error from release repository: %v", resp.Status)}
 	}
 
-	if cacheIsValid {
-		logf("Cached %s-%s release is still up-to-date.", options.Label, options.Version)
+	if options.Ref == "" {
+		logf("Fetching current %s release...", name)
 	} else {
-		logf("Fetching current %s-%s release...", options.Label, options.Version)
-		if !strings.Contains(dirName, "/releases/") {
-			// Better safe than sorry.
-			return nil, fmt.Errorf("internal error: will not remove something unexpected: %s", dirName)
-		}
-		err = os.RemoveAll(dirName)
-		if err != nil {
-			return nil, fmt.Errorf("cannot remove previously cached release: %w", err)
+		logf("Fetching %s release...", name)
+	}
+	if !strings.Contains(dirName, "/releases/") {
+		// Better safe than sorry.
+		return "", fmt.Errorf("internal error: will not remove something unexpected: %s", dirName)
+	}
+	// The release is extracted next to the cached one, which is kept in
+	// case the new content is refused.
+	newDirName := dirName + ".new"
+	err = os.RemoveAll(newDirName)
+	if err != nil {
+		return "", fmt.Errorf("cannot remove partially fetched release: %w", err)
+	}
+	defer os.RemoveAll(newDirName)
+	newCommit, err := extractTarGz(resp.Body, newDirName)
+	if err != nil {
+		return "", err
+	}
+	if options.Ref != "" && newCommit == "" {
+		return "", fmt.Errorf("cannot verify %s release: commit not found", name)
+	}
+	if commitExp.MatchString(options.Ref) && newCommit != options.Ref {
+		return "", fmt.Errorf("cannot verify %s release: fetched commit %s", name, newCommit)
+	}
+	newDigest, err := ReleaseDigest(newDirName)
+	if err != nil {
+		return "", err
+	}
+	if newCommit != "" && newCommit == commit && newDigest != digest && !options.Refresh {
+		return "", &ChangedError{Release: name, Commit: commit}
+	}
+
+	files := map[string]string{
+		".commit": newCommit,
+		".digest": newDigest,
+		".etag":   resp.Header.Get("ETag"),
+	}
+	for fileName, data := range files {
+		if data == "" {
+			continue
 		}
-		err = extractTarGz(resp.Body, dirName)
+		err := os.WriteFile(filepath.Join(newDirName, fileName), []byte(data), 0644)
 		if err != nil {
-			return nil, err
-		}
-		tag := resp.Header.Get("ETag")
-		if tag != "" {
-			err := os.WriteFile(tagName, []byte(tag), 0644)
-			if err != nil {
-				return nil, fmt.Errorf("cannot write remote release tag file: %v", err)
-			}
+			return "", fmt.Errorf("cannot write release cache: %w", err)
 		}
 	}
+	err = os.RemoveAll(dirName)
+	if err != nil {
+		return "", fmt.Errorf("cannot remove previously cached release: %w", err)
+	}
+	err = os.Rename(newDirName, dirName)
+	if err != nil {
+		return "", fmt.Errorf("cannot write release cache: %w", err)
+	}
+	return dirName, nil
+}
 
-	return ReadRelease(dirName)
+// ReleaseCommit returns the commit of the release repository fetched into
+// the release directory, or an empty string if it was not fetched or the
+// commit is not known.
+func ReleaseCommit(releaseDir string) (string, error) {
+	return readCacheFile(releaseDir, ".commit")
+}
+
+func readCacheFile(dirName, fileName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dirName, fileName))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read release cache: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ReleaseCacheDir returns the directory holding the release once fetched.
+func ReleaseCacheDir(options *FetchOptions) string {
+	cacheDir := options.CacheDir
+	if cacheDir == "" {
+		cacheDir = cache.DefaultDir("chisel")
+	}
+	name := options.Label + "-" + options.Version
+	if options.Ref != "" {
+		name += "@" + options.Ref
+	}
+	return filepath.Join(cacheDir, "releases", name)
 }
 
-func extractTarGz(dataReader io.Reader, targetDir string) error {
+// extractTarGz extracts the release tarball into targetDir, and returns
+// the commit it was made from, as recorded by git archive, if known.
+func extractTarGz(dataReader io.Reader, targetDir string) (commit string, err error) {
 	gzipReader, err := gzip.NewReader(dataReader)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer gzipReader.Close()
 	return extractTar(gzipReader, targetDir)
 }
 
-func extractTar(dataReader io.Reader, targetDir string) error {
+func extractTar(dataReader io.Reader, targetDir string) (commit string, err error) {
 	tarReader := tar.NewReader(dataReader)
 	for {
 		tarHeader, err := tarReader.Next()
@@ -125,7 +261,13 @@ func extractTar(dataReader io.Reader, targetDir string) error {
 			break
 		}
 		if err != nil {
-			return err
+			return "", err
+		}
+		if tarHeader.Typeflag == tar.TypeXGlobalHeader {
+			if c := tarHeader.PAXRecords["comment"]; commitExp.MatchString(c) {
+				commit = c
+			}
+			continue
 		}
 
 		sourcePath := filepath.Clean(tarHeader.Name)
@@ -145,9 +287,56 @@ func extractTar(dataReader io.Reader, targetDir string) error {
 			Link:        tarHeader.Linkname,
 			MakeParents: true,
 		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return commit, nil
+}
+
+// ReleaseDigest returns the SHA256 of the files in the release directory,
+// covering both their paths and content. Hidden files are not considered.
+func ReleaseDigest(releaseDir string) (string, error) {
+	return ReleaseDigestFS(os.DirFS(releaseDir))
+}
+
+// ReleaseDigestFS is like ReleaseDigest, but for the release at the root
+// of fsys.
+func ReleaseDigestFS(fsys fs.FS) (string, error) {
+	var paths []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if strings.HasPrefix(d.Name(), ".") && path != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot compute release digest: %w", err)
+	}
+	slices.Sort(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		file, err := fsys.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot compute release digest: %w", err)
+		}
+		fileHash := sha256.New()
+		_, err = io.Copy(fileHash, file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("cannot compute release digest: %w", err)
+		}
+		fmt.Fprintf(h, "%s %x\n", path, fileHash.Sum(nil))
 	}
-	return nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
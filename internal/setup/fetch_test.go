@@ -1,11 +1,17 @@
 package setup_test
 
 import (
-	. "gopkg.in/check.v1"
-
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 
+	. "gopkg.in/check.v1"
+
 	"github.com/canonical/chisel/internal/setup"
 )
 
@@ -48,3 +54,136 @@ func (s *S) TestFetch(c *C) {
 		}
 	}
 }
+
+const (
+	commit1 = "0123456789abcdef0123456789abcdef01234567"
+	commit2 = "89abcdef0123456789abcdef0123456789abcdef"
+)
+
+// releaseTarball returns a release tarball as made by git archive for the
+// commit, holding a chisel.yaml file with the given content.
+func releaseTarball(c *C, commit, content string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": commit},
+	})
+	c.Assert(err, IsNil)
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "chisel-releases-" + commit + "/",
+		Mode:     0755,
+	})
+	c.Assert(err, IsNil)
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "chisel-releases-" + commit + "/chisel.yaml",
+		Mode:     0644,
+		Size:     int64(len(content)),
+	})
+	c.Assert(err, IsNil)
+	_, err = tarWriter.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(tarWriter.Close(), IsNil)
+	c.Assert(gzipWriter.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func (s *S) TestFetchPinned(c *C) {
+	tarballs := map[string][]byte{
+		"/refs/heads/ubuntu-24.04": releaseTarball(c, commit1, "format: v1\n"),
+		"/" + commit1:              releaseTarball(c, commit1, "format: v1\n"),
+		"/refs/tags/stable":        releaseTarball(c, commit1, "format: v1\n"),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, ok := tarballs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+	defer setup.FakeBaseURL(server.URL + "/")()
+
+	cacheDir := c.MkDir()
+	options := &setup.FetchOptions{
+		Label:    "ubuntu",
+		Version:  "24.04",
+		Ref:      commit1,
+		CacheDir: cacheDir,
+	}
+	dir, err := setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	c.Assert(dir, Equals, filepath.Join(cacheDir, "releases", "ubuntu-24.04@"+commit1))
+	commit, err := setup.ReleaseCommit(dir)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, commit1)
+	c.Assert(requests, Equals, 1)
+
+	// Pinned releases are not fetched again.
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 1)
+
+	// Changes to the cached content are refused, unless refreshing.
+	err = os.WriteFile(filepath.Join(dir, "chisel.yaml"), []byte("format: v2\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, ErrorMatches, `release ubuntu-24.04@`+commit1+` has changed since it was fetched at commit `+commit1)
+	var changedErr *setup.ChangedError
+	c.Assert(errors.As(err, &changedErr), Equals, true)
+	options.Refresh = true
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(filepath.Join(dir, "chisel.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "format: v1\n")
+
+	// The commit fetched must match the one pinned.
+	options = &setup.FetchOptions{
+		Label:    "ubuntu",
+		Version:  "24.04",
+		Ref:      commit2,
+		CacheDir: cacheDir,
+	}
+	tarballs["/"+commit2] = releaseTarball(c, commit1, "format: v1\n")
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, ErrorMatches, `cannot verify ubuntu-24.04@`+commit2+` release: fetched commit `+commit1)
+
+	// Tags are fetched and recorded at the commit they point to.
+	options.Ref = "stable"
+	dir, err = setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	c.Assert(dir, Equals, filepath.Join(cacheDir, "releases", "ubuntu-24.04@stable"))
+	commit, err = setup.ReleaseCommit(dir)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, commit1)
+
+	options.Ref = "missing"
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, ErrorMatches, `no information for ubuntu-24.04@missing release`)
+
+	// The branch is fetched again when it changes, but different content
+	// for the commit fetched before is refused.
+	options.Ref = ""
+	dir, err = setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	tarballs["/refs/heads/ubuntu-24.04"] = releaseTarball(c, commit1, "format: v2\n")
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, ErrorMatches, `release ubuntu-24.04 has changed since it was fetched at commit `+commit1)
+	data, err = os.ReadFile(filepath.Join(dir, "chisel.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "format: v1\n")
+	tarballs["/refs/heads/ubuntu-24.04"] = releaseTarball(c, commit2, "format: v2\n")
+	_, err = setup.FetchReleaseDir(options)
+	c.Assert(err, IsNil)
+	commit, err = setup.ReleaseCommit(dir)
+	c.Assert(err, IsNil)
+	c.Assert(commit, Equals, commit2)
+}
@@ -0,0 +1,40 @@
+package setup
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+// parallelism is the number of goroutines reading and validating the slice
+// definitions of releases, which matters for releases with thousands of
+// slices.
+var parallelism = runtime.GOMAXPROCS(0)
+
+// runShards calls fn concurrently for each of the shards from 0 to n-1, and
+// returns the error of the lowest shard failing, if any.
+func runShards(n int, fn func(shard int) error) error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for shard := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[shard] = fn(shard)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathShard returns the shard of path among n shards.
+func pathShard(path string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,121 @@
+package setup
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// pathIndex is a trie of content paths by the directories of their literal
+// prefix, the part before any wildcard. Two paths may only match each other
+// if the literal prefix of one is a prefix of the literal prefix of the
+// other, so the paths which may match a glob are found in the branch of the
+// trie leading to its literal prefix and in the subtrees under it, instead
+// of comparing the glob with every path of the release.
+type pathIndex struct {
+	root *pathNode
+}
+
+type pathNode struct {
+	children map[string]*pathNode
+	// names holds the keys of children in order.
+	names []string
+	// paths holds the paths with the literal prefix in this directory.
+	paths []string
+}
+
+func newPathIndex(paths []string) *pathIndex {
+	index := &pathIndex{root: &pathNode{}}
+	for _, path := range paths {
+		node := index.root
+		for _, name := range prefixDirs(literalPrefix(path)) {
+			child, ok := node.children[name]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*pathNode)
+				}
+				child = &pathNode{}
+				node.children[name] = child
+			}
+			node = child
+		}
+		node.paths = append(node.paths, path)
+	}
+	index.root.sort()
+	return index
+}
+
+func (n *pathNode) sort() {
+	slices.Sort(n.paths)
+	n.names = slices.Sorted(maps.Keys(n.children))
+	for _, child := range n.children {
+		child.sort()
+	}
+}
+
+// candidates returns the paths in the index which may match glob, in order
+// of their directories. Whether they do match must still be checked with
+// strdist.GlobPath.
+func (idx *pathIndex) candidates(glob string) []string {
+	prefix := literalPrefix(glob)
+	var result []string
+	node := idx.root
+	for _, name := range prefixDirs(prefix) {
+		result = appendCompatible(result, node.paths, prefix)
+		node = node.children[name]
+		if node == nil {
+			return result
+		}
+	}
+	result = appendCompatible(result, node.paths, prefix)
+
+	// The literal prefix of the paths in deeper directories is longer, so
+	// they may only match if it starts with the prefix of glob.
+	rest := prefix[strings.LastIndex(prefix, "/")+1:]
+	for _, name := range node.names {
+		if strings.HasPrefix(name, rest) {
+			result = node.children[name].appendAll(result)
+		}
+	}
+	return result
+}
+
+func (n *pathNode) appendAll(result []string) []string {
+	result = append(result, n.paths...)
+	for _, name := range n.names {
+		result = n.children[name].appendAll(result)
+	}
+	return result
+}
+
+// appendCompatible appends to result the paths with a literal prefix which
+// is a prefix of prefix, or the other way around.
+func appendCompatible(result, paths []string, prefix string) []string {
+	for _, path := range paths {
+		other := literalPrefix(path)
+		if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// literalPrefix returns the part of path before its first wildcard. An
+// opening bracket is taken as a wildcard even if it does not start a class,
+// which only makes the prefix shorter than it could be.
+func literalPrefix(path string) string {
+	if i := strings.IndexAny(path, "*?["); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// prefixDirs returns the names of the directories which the literal prefix
+// is in, from the root.
+func prefixDirs(prefix string) []string {
+	dir := strings.TrimPrefix(prefix[:strings.LastIndex(prefix, "/")+1], "/")
+	if dir == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(dir, "/"), "/")
+}
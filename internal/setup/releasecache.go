@@ -0,0 +1,305 @@
+package setup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+
+	"github.com/canonical/chisel/internal/cache"
+)
+
+// parsedDir is the directory of the cache holding the releases read before,
+// named by the digest of the content of their release directory.
+const parsedDir = "parsed"
+
+// parsedTimeout is how long a release is kept in the cache without being
+// used. As with packages, the modification time of the record is updated
+// whenever it is used, and records of releases which changed since are
+// removed once they expire.
+const parsedTimeout = 30 * 24 * time.Hour
+
+// cachedRelease is the release as stored in the cache. The public keys of
+// the archives are stored serialized, by archive name, as they cannot be
+// encoded along with the rest.
+type cachedRelease struct {
+	Release *Release
+	PubKeys map[string][][]byte
+}
+
+// ReadCachedRelease is like ReadRelease, but reuses the release as read and
+// validated before from the same content of dir, which is stored in the cache
+// at cacheDir, or at the default location if empty. The release is read and
+// stored in the cache when the content changed. The warnings logged when the
+// release was read are logged again when it is reused.
+func ReadCachedRelease(dir, cacheDir string) (*Release, error) {
+	if cacheDir == "" {
+		cacheDir = cache.DefaultDir("chisel")
+	}
+	dir = filepath.Clean(dir)
+	key, err := releaseDigest(dir)
+	if err != nil {
+		// The error is reported by ReadRelease, if it matters.
+		debugf("Cannot use cached release: %v", err)
+		return ReadRelease(dir)
+	}
+	recordPath := filepath.Join(cacheDir, parsedDir, key)
+	release, err := loadCachedRelease(recordPath)
+	if err != nil {
+		debugf("Discarding cached release: %v", err)
+		os.Remove(recordPath)
+	} else if release != nil {
+		logReadRelease(dir)
+		for _, warning := range release.Warnings {
+			logf("%s", warning)
+		}
+		debugf("Using cached release %s", key[:16])
+		release.Path = dir
+		return release, nil
+	}
+
+	release, err = ReadRelease(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = storeCachedRelease(recordPath, release)
+	if err != nil {
+		debugf("Cannot cache release: %v", err)
+	}
+	err = expireCachedReleases(filepath.Join(cacheDir, parsedDir), parsedTimeout)
+	if err != nil {
+		debugf("Cannot expire cached releases: %v", err)
+	}
+	return release, nil
+}
+
+// expireCachedReleases removes the records in dir which were not used
+// within timeout.
+func expireCachedReleases(dir string, timeout time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	expired := time.Now().Add(-timeout)
+	for _, entry := range entries {
+		finfo, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if finfo.ModTime().After(expired) {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, finfo.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseDigest returns the digest of the files of the release in dir,
+// along with the layout of the types holding the release, so that releases
+// cached by other versions of Chisel are not reused.
+func releaseDigest(dir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", releaseTypeLayout())
+	addFile := func(path string) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		relPath, _ := filepath.Rel(dir, path)
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %d\n", filepath.ToSlash(relPath), info.Size())
+		_, err = io.Copy(h, file)
+		return err
+	}
+	err := addFile(filepath.Join(dir, "chisel.yaml"))
+	if err != nil {
+		return "", err
+	}
+	// The slice definition files are the ones readSlices reads, in the
+	// same order, with symlinks resolved as when reading them.
+	pkgPaths := make(map[string]string)
+	var pkgNames []string
+	err = findReleaseSliceFiles(pkgPaths, &pkgNames, dir, filepath.Join(dir, "slices"))
+	if err != nil {
+		return "", err
+	}
+	for _, pkgName := range pkgNames {
+		err = addFile(pkgPaths[pkgName])
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var releaseTypeLayout = sync.OnceValue(func() string {
+	var b strings.Builder
+	writeTypeLayout(&b, reflect.TypeOf(Release{}), make(map[reflect.Type]bool))
+	return b.String()
+})
+
+// writeTypeLayout writes the fields of the types of chisel found from t,
+// recursively.
+func writeTypeLayout(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	b.WriteString(t.String())
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		b.WriteString("<")
+		writeTypeLayout(b, t.Elem(), seen)
+		b.WriteString(">")
+	case reflect.Map:
+		b.WriteString("<")
+		writeTypeLayout(b, t.Key(), seen)
+		b.WriteString(",")
+		writeTypeLayout(b, t.Elem(), seen)
+		b.WriteString(">")
+	case reflect.Struct:
+		if seen[t] || !strings.HasPrefix(t.PkgPath(), "github.com/canonical/chisel/") {
+			return
+		}
+		seen[t] = true
+		b.WriteString("{")
+		for i := range t.NumField() {
+			field := t.Field(i)
+			b.WriteString(field.Name)
+			b.WriteString(" ")
+			writeTypeLayout(b, field.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	}
+}
+
+// loadCachedRelease returns the release stored at recordPath, or nil if
+// there is none.
+func loadCachedRelease(recordPath string) (*Release, error) {
+	data, err := os.ReadFile(recordPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cached cachedRelease
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&cached)
+	if err != nil {
+		return nil, err
+	}
+	// Use mtime as last reuse time, as the package cache does.
+	now := time.Now()
+	err = os.Chtimes(recordPath, now, now)
+	if err != nil {
+		return nil, err
+	}
+	release := cached.Release
+	if release == nil {
+		return nil, fmt.Errorf("no release in cache record")
+	}
+	for name, keys := range cached.PubKeys {
+		archive, ok := release.Archives[name]
+		if !ok {
+			return nil, fmt.Errorf("public keys of unknown archive %q in cache record", name)
+		}
+		for _, data := range keys {
+			p, err := packet.Read(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			key, ok := p.(*packet.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("invalid public key of archive %q in cache record", name)
+			}
+			archive.PubKeys = append(archive.PubKeys, key)
+		}
+	}
+	restoreMaps(release)
+	return release, nil
+}
+
+// restoreMaps makes the maps which are set even when empty when reading a
+// release non-nil, as gob does not tell empty maps apart from nil ones.
+func restoreMaps(release *Release) {
+	if release.Packages == nil {
+		release.Packages = make(map[string]*Package)
+	}
+	if release.Archives == nil {
+		release.Archives = make(map[string]*Archive)
+	}
+	for _, pkg := range release.Packages {
+		if pkg.Slices == nil {
+			pkg.Slices = make(map[string]*Slice)
+		}
+	}
+}
+
+// storeCachedRelease writes the release to recordPath.
+func storeCachedRelease(recordPath string, release *Release) error {
+	cached := cachedRelease{
+		Release: &Release{},
+		PubKeys: make(map[string][][]byte),
+	}
+	*cached.Release = *release
+	cached.Release.Path = ""
+	cached.Release.Archives = make(map[string]*Archive, len(release.Archives))
+	for name, archive := range release.Archives {
+		archiveCopy := *archive
+		archiveCopy.PubKeys = nil
+		cached.Release.Archives[name] = &archiveCopy
+		for _, key := range archive.PubKeys {
+			var buf bytes.Buffer
+			err := key.Serialize(&buf)
+			if err != nil {
+				return err
+			}
+			cached.PubKeys[name] = append(cached.PubKeys[name], buf.Bytes())
+		}
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&cached)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(recordPath)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	// As with other files in the cache, the record is written to its own
+	// temporary file so that concurrent processes may share the cache.
+	file, err := os.CreateTemp(dir, "tmp.*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), recordPath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
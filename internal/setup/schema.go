@@ -0,0 +1,129 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ReleaseSchema returns the JSON Schema of the chisel.yaml file, generated
+// from the types the file is parsed into.
+func ReleaseSchema() ([]byte, error) {
+	return marshalSchema("Chisel release definition", reflect.TypeOf(yamlRelease{}))
+}
+
+// PackageSchema returns the JSON Schema of the slice definition files,
+// generated from the types the files are parsed into.
+func PackageSchema() ([]byte, error) {
+	return marshalSchema("Chisel slice definitions", reflect.TypeOf(yamlPackage{}))
+}
+
+func marshalSchema(title string, t reflect.Type) ([]byte, error) {
+	schema, err := typeSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	schema["$schema"] = schemaDialect
+	schema["title"] = title
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) (map[string]any, error) {
+	// Types decoded in a custom way do not follow their Go type.
+	switch t {
+	case reflect.TypeOf(yamlArch{}), reflect.TypeOf(yamlArchiveList{}):
+		return anyOf(
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		), nil
+	case reflect.TypeOf(yamlEssentialRef{}):
+		ref, err := structSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		return anyOf(map[string]any{"type": "string"}, ref), nil
+	case reflect.TypeOf(yamlMode(0)):
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.TypeOf(StrategyPriority):
+		return map[string]any{"enum": []ArchiveStrategy{StrategyPriority, StrategyVersion, StrategySecurity, StrategyPinned}}, nil
+	case reflect.TypeOf(OverwriteError):
+		return map[string]any{"enum": []OverwritePolicy{OverwriteError, OverwriteKeepFirst, OverwriteLast, OverwriteMerge}}, nil
+	case reflect.TypeOf(UntilNone):
+		return map[string]any{"enum": []PathUntil{UntilMutate}}, nil
+	case reflect.TypeOf(GenerateNone):
+		return anyOf(
+			map[string]any{"enum": []GenerateKind{GenerateManifest, GenerateCACertificates, GenerateConcat, GenerateMachineID, GenerateHostname, GenerateResolvConf}},
+			map[string]any{"type": "string", "pattern": customGenerateExp.String()},
+		), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		if kind := t.Elem().Kind(); kind == reflect.Struct || kind == reflect.Pointer {
+			// Entries such as "/path:" or "slice:" decode into the zero value.
+			values = anyOf(values, map[string]any{"type": "null"})
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return nil, fmt.Errorf("internal error: no schema for type %s", t)
+}
+
+// structSchema returns the schema of the mapping decoded into the struct
+// type t. Fields tagged with `schema:"required"` must be present. As the
+// decoder ignores unknown fields, so does the schema.
+func structSchema(t reflect.Type) (map[string]any, error) {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			return nil, fmt.Errorf("internal error: field %s.%s has no name in YAML", t.Name(), field.Name)
+		}
+		schema, err := typeSchema(field.Type)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = schema
+		if field.Tag.Get("schema") == "required" {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func anyOf(schemas ...map[string]any) map[string]any {
+	return map[string]any{"anyOf": schemas}
+}
@@ -3,8 +3,11 @@ package setup
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -12,6 +15,7 @@ import (
 	"golang.org/x/crypto/openpgp/packet"
 
 	"github.com/canonical/chisel/internal/apacheutil"
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/strdist"
 )
 
@@ -22,8 +26,51 @@ type Release struct {
 	Packages    map[string]*Package
 	Archives    map[string]*Archive
 	Maintenance *Maintenance
+	// Groups maps the names of curated sets of slices, which may be
+	// selected together by name, to the slices in each set.
+	Groups map[string][]SliceKey
+	// ArchiveStrategy is how a package found in more than one of the
+	// archives it may be fetched from is resolved to one of them. It is
+	// StrategyPriority when empty.
+	ArchiveStrategy ArchiveStrategy
+	// UsrMerge is set for releases where /bin, /sbin and /lib, among
+	// others, are symlinks to their counterparts under /usr. The paths
+	// under those directories are then listed in the slices by their /usr
+	// counterparts, which is also where they are extracted to.
+	UsrMerge bool
+	// Archs lists the architectures the release is cut for, which arch
+	// lists with groups and exclusions are resolved against. All of the
+	// known architectures are considered when empty.
+	Archs []string
+	// Warnings holds the problems found while reading the release which
+	// did not prevent it from being used, as they were logged.
+	Warnings []string
 }
 
+// warnf logs the warning and records it in the release.
+func (r *Release) warnf(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	logf("%s", warning)
+	r.Warnings = append(r.Warnings, warning)
+}
+
+type ArchiveStrategy string
+
+const (
+	// StrategyPriority uses the first archive holding the package, in the
+	// order returned by PackageArchives.
+	StrategyPriority ArchiveStrategy = "priority"
+	// StrategyVersion uses the archive with the highest version of the
+	// package, and the one with the highest priority on ties.
+	StrategyVersion ArchiveStrategy = "version"
+	// StrategySecurity uses the first archive with a security suite, such
+	// as jammy-security, and otherwise falls back to priority.
+	StrategySecurity ArchiveStrategy = "security"
+	// StrategyPinned requires packages found in more than one archive to
+	// list the archives they are fetched from.
+	StrategyPinned ArchiveStrategy = "pinned"
+)
+
 type Maintenance struct {
 	Standard  time.Time
 	Expanded  time.Time
@@ -45,14 +92,35 @@ type Archive struct {
 	// OldRelease is set for Ubuntu releases which are moved from the regular
 	// archive which happens after the release's end of life date.
 	OldRelease bool
+	// Verify holds the policy for verifying the content of the archive.
+	Verify archive.VerifyOptions
+	// HTTP configures the requests made to the archive.
+	HTTP archive.HTTPOptions
+	// Mirrors lists the locations serving the same content as the archive,
+	// among which the fastest one is used, as in archive.Options.
+	Mirrors []string
 }
 
 // Package holds a collection of slices that represent parts of themselves.
 type Package struct {
-	Name    string
-	Path    string
-	Archive string
-	Slices  map[string]*Slice
+	Name string
+	Path string
+	// Archives optionally restricts the archives the package is fetched
+	// from to the ones listed, which are searched in order.
+	Archives []string
+	// Source is set for packages which are not obtained from any archive.
+	Source *PackageSource
+	Slices map[string]*Slice
+}
+
+// PackageSource is the location of a package distributed outside of the
+// release archives.
+type PackageSource struct {
+	// Local is the path of the .deb file, relative to the release directory.
+	Local string
+	URL   string
+	// SHA256 is the expected digest of the .deb file.
+	SHA256 string
 }
 
 // Slice holds the details about a package slice.
@@ -62,10 +130,43 @@ type Slice struct {
 	Essential map[SliceKey]EssentialInfo
 	Contents  map[string]PathInfo
 	Scripts   SliceScripts
+	// Provides lists the aliases other slices may use to refer to this
+	// slice, so they remain stable when the package is renamed.
+	Provides []SliceKey
+	// Deprecated holds the notice shown when the slice is selected, if the
+	// slice is deprecated.
+	Deprecated string
+	// Summary, Notes and Owners are informational only.
+	Summary string
+	Notes   string
+	Owners  []string
+	// LibraryPaths lists the directories added to the search path of the
+	// dynamic linker, in the file at LibraryPathsFile.
+	LibraryPaths []string
+	// Environment holds the variables added to EnvironmentFile.
+	Environment map[string]string
+	// Divert maps the paths the slice claims from other packages, as
+	// dpkg-divert would, to the paths the content of those packages is
+	// moved to when the slice is selected.
+	Divert map[string]string
+}
+
+// EnvironmentFile is the file generated from the environment of the
+// selected slices.
+const EnvironmentFile = "/etc/environment"
+
+// LibraryPathsFile returns the file generated from the library paths of
+// the selected slices of the package.
+func LibraryPathsFile(pkg string) string {
+	return "/etc/ld.so.conf.d/" + pkg + ".conf"
 }
 
 type EssentialInfo struct {
 	Arch []string
+	// Op and Version constrain the versions of the package of the slice
+	// which are accepted, when set. Op is one of <<, <=, =, >= and >>.
+	Op      string
+	Version string
 }
 
 type SliceScripts struct {
@@ -96,10 +197,40 @@ const (
 type GenerateKind string
 
 const (
-	GenerateNone     GenerateKind = ""
-	GenerateManifest GenerateKind = "manifest"
+	GenerateNone           GenerateKind = ""
+	GenerateManifest       GenerateKind = "manifest"
+	GenerateCACertificates GenerateKind = "ca-certificates"
+	GenerateConcat         GenerateKind = "concat"
+	GenerateMachineID      GenerateKind = "machine-id"
+	GenerateHostname       GenerateKind = "hostname"
+	GenerateResolvConf     GenerateKind = "resolv-conf"
 )
 
+// VolatileFile is a file which only makes sense on the running system, and
+// which is thus created empty for it to be set when the system first boots.
+type VolatileFile struct {
+	Path string
+	Mode uint
+}
+
+// VolatileFiles maps the generate kinds of volatile files to the files
+// created for them. An empty /etc/machine-id is read-only so that systemd
+// treats the first boot as such and initializes it, while /etc/hostname and
+// /etc/resolv.conf are stubs for the runtime to set or mount over.
+var VolatileFiles = map[GenerateKind]VolatileFile{
+	GenerateMachineID:  {Path: "/etc/machine-id", Mode: 0444},
+	GenerateHostname:   {Path: "/etc/hostname", Mode: 0644},
+	GenerateResolvConf: {Path: "/etc/resolv.conf", Mode: 0644},
+}
+
+var customGenerateExp = regexp.MustCompile(`^x-[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsCustom returns whether the kind is provided outside of Chisel, which is
+// the case for kinds named with the "x-" prefix, such as "x-machine-id".
+func (kind GenerateKind) IsCustom() bool {
+	return customGenerateExp.MatchString(string(kind))
+}
+
 type PathInfo struct {
 	Kind PathKind
 	Info string
@@ -109,9 +240,40 @@ type PathInfo struct {
 	Until    PathUntil
 	Arch     []string
 	Generate GenerateKind
+	// Priority orders the fragments of paths with "generate: concat",
+	// which hold the text of the fragment in Info.
+	Priority int
 	Prefer   string
+	// Label is the SELinux security context of the path, overriding the
+	// one carried by the package, if any.
+	Label string
+	// Same allows other packages to provide the path as well, as long as
+	// they also set it, which is checked when cutting by comparing the
+	// content extracted from every package.
+	Same bool
+	// Overwrite allows other slices to write different content to the
+	// mutable path as well, as long as they set the same policy, which
+	// decides the content the path ends up with.
+	Overwrite OverwritePolicy
 }
 
+// OverwritePolicy decides the content of a mutable path written by more than
+// one slice, either extracted from their packages or generated. The content
+// extracted comes first, in the order the packages are extracted, followed
+// by the content generated, in the order of the selection.
+type OverwritePolicy string
+
+const (
+	// OverwriteError fails when slices write different content to the
+	// path, which is the behavior when no policy is set.
+	OverwriteError     OverwritePolicy = "error"
+	OverwriteKeepFirst OverwritePolicy = "keep-first"
+	OverwriteLast      OverwritePolicy = "overwrite-last"
+	// OverwriteMerge concatenates the distinct content written, separated
+	// by newlines.
+	OverwriteMerge OverwritePolicy = "merge"
+)
+
 // SameContent returns whether the path has the same content properties as some
 // other path. In other words, the resulting file/dir entry is the same. The
 // Mutable flag must also match, as that's a common agreement that the actual
@@ -121,7 +283,8 @@ func (pi *PathInfo) SameContent(other *PathInfo) bool {
 		pi.Info == other.Info &&
 		pi.Mode == other.Mode &&
 		pi.Mutable == other.Mutable &&
-		pi.Generate == other.Generate)
+		pi.Generate == other.Generate &&
+		pi.Label == other.Label)
 }
 
 type SliceKey = apacheutil.SliceKey
@@ -132,6 +295,47 @@ func ParseSliceKey(sliceKey string) (SliceKey, error) {
 
 func (s *Slice) String() string { return s.Package + "_" + s.Name }
 
+var groupNameExp = regexp.MustCompile(`^[a-z](?:-?[a-z0-9]){2,}$`)
+
+// IsGroupName returns whether ref is the name of a group of slices, such as
+// "runtime-common", rather than the name of a slice.
+func IsGroupName(ref string) bool {
+	return groupNameExp.MatchString(ref)
+}
+
+// IsSlicePattern returns whether ref holds wildcards matching the names of
+// multiple slices, as in "python3.12_*" or "libfoo*_libs".
+func IsSlicePattern(ref string) bool {
+	return strings.ContainsAny(ref, "*?[")
+}
+
+// MatchSlices returns the slices in the release with names matching the
+// pattern, which may hold "*", "?" and "[...]" wildcards, sorted by name.
+// Deprecated slices are not matched, as they may only be selected by name.
+func MatchSlices(release *Release, pattern string) ([]SliceKey, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid slice pattern: %q", pattern)
+	}
+	var keys []SliceKey
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			if slice.Deprecated != "" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, slice.String()); ok {
+				keys = append(keys, SliceKey{slice.Package, slice.Name})
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no slices match %q", pattern)
+	}
+	slices.SortFunc(keys, func(a, b SliceKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return keys, nil
+}
+
 // Selection holds the required configuration to create a Build for a selection
 // of slices from a Release. It's still an abstract proposal in the sense that
 // the real information coming from packages is still unknown, so referenced
@@ -139,6 +343,14 @@ func (s *Slice) String() string { return s.Package + "_" + s.Name }
 type Selection struct {
 	Release *Release
 	Slices  []*Slice
+	// ExcludedPaths holds the path patterns given to Exclude. The entries
+	// of the selected slices they match were dropped already, and the
+	// paths they match within the globs left must not be extracted.
+	ExcludedPaths []string
+
+	// requested holds the slices given to Select, which Exclude keeps
+	// along with their essentials.
+	requested []SliceKey
 }
 
 // Prefers uses the prefer relationships and returns a map from each path to
@@ -179,25 +391,162 @@ func (s *Selection) Prefers() (map[string]*Package, error) {
 	return pathPreferredPkg, nil
 }
 
-func ReadRelease(dir string) (*Release, error) {
-	logDir := dir
-	if strings.Contains(dir, "/.cache/") {
-		logDir = filepath.Base(dir)
+// PreferredPackage returns the package from which the path is extracted
+// among the packages of the selected slices listing it, following the prefer
+// relationships, or nil if no selected slice lists the path.
+func (s *Selection) PreferredPackage(path string) (*Package, error) {
+	prefers, err := s.Release.prefers()
+	if err != nil {
+		return nil, err
 	}
-	logf("Processing %s release...", logDir)
+	preferred := ""
+	for _, slice := range s.Slices {
+		if _, ok := slice.Contents[path]; !ok || slice.Package == preferred {
+			continue
+		}
+		if preferred == "" {
+			preferred = slice.Package
+			continue
+		}
+		choice, err := preferredPathPackage(path, preferred, slice.Package, prefers)
+		if err == preferNone {
+			pkg1, pkg2 := sortPair(preferred, slice.Package)
+			return nil, fmt.Errorf("package %q and %q conflict on %s without prefer relationship", pkg1, pkg2, path)
+		} else if err != nil {
+			return nil, err
+		}
+		preferred = choice
+	}
+	if preferred == "" {
+		return nil, nil
+	}
+	return s.Release.Packages[preferred], nil
+}
 
-	release, err := readRelease(dir)
+// PackageArchives returns the archives the named package may be fetched
+// from, in the order they are searched: the ones listed in the package, if
+// any, or otherwise the archives with non-negative priority, from the
+// highest priority to the lowest.
+func (r *Release) PackageArchives(pkgName string) []*Archive {
+	if pkg, ok := r.Packages[pkgName]; ok && len(pkg.Archives) > 0 {
+		archives := make([]*Archive, 0, len(pkg.Archives))
+		for _, archiveName := range pkg.Archives {
+			archives = append(archives, r.Archives[archiveName])
+		}
+		return archives
+	}
+	archives := make([]*Archive, 0, len(r.Archives))
+	for _, archive := range r.Archives {
+		if archive.Priority < 0 {
+			// Ignore negative priority archives unless a package
+			// specifically asks for it with the "archive" field.
+			continue
+		}
+		archives = append(archives, archive)
+	}
+	slices.SortFunc(archives, func(a, b *Archive) int {
+		return b.Priority - a.Priority
+	})
+	return archives
+}
+
+// PathPrefers holds the prefer relationships among the packages listing a
+// path in their slices.
+type PathPrefers struct {
+	// Packages lists the packages listing the path, sorted by name.
+	Packages []string
+	// Prefers maps packages to the package they prefer for the path.
+	Prefers map[string]string
+}
+
+// PathPrefers returns the prefer relationships of the release, indexed by
+// the paths which are part of any.
+func (r *Release) PathPrefers() (map[string]*PathPrefers, error) {
+	prefers, err := r.prefers()
 	if err != nil {
 		return nil, err
 	}
+	pathPrefers := make(map[string]*PathPrefers)
+	for key, pkg := range prefers {
+		if key.side != preferTarget {
+			continue
+		}
+		info, ok := pathPrefers[key.path]
+		if !ok {
+			info = &PathPrefers{Prefers: make(map[string]string)}
+			pathPrefers[key.path] = info
+		}
+		info.Prefers[key.pkg] = pkg
+	}
+	for _, pkg := range r.Packages {
+		for _, slice := range pkg.Slices {
+			for path := range slice.Contents {
+				info, ok := pathPrefers[path]
+				if ok && !slices.Contains(info.Packages, pkg.Name) {
+					info.Packages = append(info.Packages, pkg.Name)
+				}
+			}
+		}
+	}
+	for _, info := range pathPrefers {
+		slices.Sort(info.Packages)
+	}
+	return pathPrefers, nil
+}
+
+// ParseError reports release or slice definitions which cannot be read
+// or parsed.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ValidationError reports definitions which were parsed but are not valid
+// as a whole, such as slices which conflict with each other, or a selection
+// which cannot be satisfied.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// ParseRelease reads the release definitions in dir like ReadRelease, but
+// without validating them as a whole, so that releases with issues such as
+// essential loops may still be inspected.
+func ParseRelease(dir string) (*Release, error) {
+	release, err := readRelease(dir)
+	if err != nil {
+		return nil, &ParseError{err}
+	}
+	return release, nil
+}
+
+func ReadRelease(dir string) (*Release, error) {
+	logReadRelease(dir)
+
+	release, err := readRelease(dir)
+	if err != nil {
+		return nil, &ParseError{err}
+	}
 
 	err = release.validate()
 	if err != nil {
-		return nil, err
+		return nil, &ValidationError{err}
 	}
 	return release, nil
 }
 
+func logReadRelease(dir string) {
+	logDir := dir
+	if strings.Contains(dir, "/.cache/") {
+		logDir = filepath.Base(dir)
+	}
+	logf("Processing %s release...", logDir)
+}
+
 func (r *Release) validate() error {
 	prefers, err := r.prefers()
 	if err != nil {
@@ -217,36 +566,103 @@ func (r *Release) validate() error {
 	// The above also means that generated content (e.g. text files, directories
 	// with make:true) will always conflict with extracted content, because we
 	// cannot validate that they are the same without downloading the package.
-	paths := make(map[string][]*Slice)
-	for _, pkg := range r.Packages {
-		for _, new := range pkg.Slices {
+	diverts, err := r.diverts()
+	if err != nil {
+		return err
+	}
+	// The paths are sharded by their hash so that the conflicts are checked
+	// concurrently, as slices may only conflict on the same path.
+	type pathSlice struct {
+		path  string
+		slice *Slice
+	}
+	// Slices are visited in order so that the conflict reported, when
+	// more than two slices list a path, does not depend on map order.
+	shards := make([][]pathSlice, parallelism)
+	for _, pkgName := range slices.Sorted(maps.Keys(r.Packages)) {
+		pkg := r.Packages[pkgName]
+		for _, sliceName := range slices.Sorted(maps.Keys(pkg.Slices)) {
+			new := pkg.Slices[sliceName]
 			keys = append(keys, SliceKey{pkg.Name, new.Name})
-			for newPath, newInfo := range new.Contents {
-				if oldSlices, ok := paths[newPath]; ok {
-					for _, old := range oldSlices {
-						if new.Package != old.Package {
-							_, err := preferredPathPackage(newPath, new.Package, old.Package, prefers)
-							if err == nil {
-								continue
-							} else if err != preferNone {
-								return err
-							}
-						}
+			for newPath := range new.Contents {
+				shard := pathShard(newPath, parallelism)
+				shards[shard] = append(shards[shard], pathSlice{newPath, new})
+			}
+		}
+	}
+	shardPaths := make([]map[string][]*Slice, parallelism)
+	err = runShards(parallelism, func(shard int) error {
+		paths := make(map[string][]*Slice)
+		shardPaths[shard] = paths
+		for _, entry := range shards[shard] {
+			newPath, new := entry.path, entry.slice
+			newInfo := new.Contents[newPath]
+			for _, old := range paths[newPath] {
+				if div, ok := diverts[newPath]; ok && new.Package != old.Package && (div.Package == new.Package || div.Package == old.Package) {
+					// The content of the other package is moved
+					// away when the diverting slice is selected.
+					continue
+				}
+				if new.Package != old.Package {
+					_, err := preferredPathPackage(newPath, new.Package, old.Package, prefers)
+					if err == nil {
+						continue
+					} else if err != preferNone {
+						return err
+					}
+				}
 
-						oldInfo := old.Contents[newPath]
-						if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
-							if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
-								old, new = new, old
-							}
-							return fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
-						}
+				oldInfo := old.Contents[newPath]
+				if newInfo.Generate == GenerateConcat && oldInfo.Generate == GenerateConcat && newInfo.Mode == oldInfo.Mode && newInfo.Label == oldInfo.Label {
+					// Each slice contributes its own fragment.
+					continue
+				}
+				if newInfo.Overwrite != "" && oldInfo.Overwrite != "" && newInfo.Overwrite != oldInfo.Overwrite {
+					if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
+						old, new = new, old
 					}
-					paths[newPath] = append(paths[newPath], new)
-				} else {
-					paths[newPath] = append(paths[newPath], new)
+					return fmt.Errorf("slices %s and %s have different overwrite policies on %s", old, new, newPath)
 				}
+				if newInfo.Overwrite != "" && newInfo.Overwrite == oldInfo.Overwrite && newInfo.Overwrite != OverwriteError {
+					// The content of every slice is combined
+					// when cutting.
+					continue
+				}
+				if newInfo.Same && oldInfo.Same && newInfo.SameContent(&oldInfo) {
+					// The content of every package is compared
+					// when cutting.
+					continue
+				}
+				if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
+					if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
+						old, new = new, old
+					}
+					return fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
+				}
+			}
+			paths[newPath] = append(paths[newPath], new)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	paths := make(map[string][]*Slice)
+	for _, shard := range shardPaths {
+		maps.Copy(paths, shard)
+	}
+
+	// Check that paths are not diverted to where other content is.
+	for divPath, div := range diverts {
+		divertTo := div.Divert[divPath]
+		for newPath, newSlices := range paths {
+			if newPath == divertTo || newPath == divertTo+"/" || strdist.GlobPath(newPath, divertTo) {
+				return fmt.Errorf("slice %s diverts %s to %s, which slice %s lists", div, divPath, divertTo, newSlices[0])
 			}
 		}
+		if other, ok := diverts[divertTo]; ok {
+			return fmt.Errorf("slice %s diverts %s to %s, which slice %s diverts", div, divPath, divertTo, other)
+		}
 	}
 
 	// Check for invalid prefer relationships where the package does not have
@@ -269,36 +685,100 @@ func (r *Release) validate() error {
 		}
 	}
 
-	// Check for glob and generate conflicts.
-	for oldPath, oldSlices := range paths {
-		for _, old := range oldSlices {
-			oldInfo := old.Contents[oldPath]
-			if oldInfo.Kind != GeneratePath && oldInfo.Kind != GlobPath {
-				break
-			}
-			for newPath, newSlices := range paths {
-				if oldPath == newPath {
-					// Identical paths have been filtered earlier.
-					continue
+	// Check for glob and generate conflicts. Only the paths which may match
+	// each glob are compared with it, as found in the index of all paths.
+	var globPaths []string
+	for path, pathSlices := range paths {
+		kind := pathSlices[0].Contents[path].Kind
+		if kind == GeneratePath || kind == GlobPath {
+			globPaths = append(globPaths, path)
+		}
+	}
+	slices.Sort(globPaths)
+	index := newPathIndex(slices.Collect(maps.Keys(paths)))
+	err = runShards(parallelism, func(shard int) error {
+		for i := shard; i < len(globPaths); i += parallelism {
+			oldPath := globPaths[i]
+			for _, old := range paths[oldPath] {
+				oldInfo := old.Contents[oldPath]
+				if oldInfo.Kind != GeneratePath && oldInfo.Kind != GlobPath {
+					break
 				}
-				for _, new := range newSlices {
-					newInfo := new.Contents[newPath]
-					if oldInfo.Kind == GlobPath && (newInfo.Kind == GlobPath || newInfo.Kind == CopyPath) {
-						if new.Package == old.Package {
-							continue
-						}
+				for _, newPath := range index.candidates(oldPath) {
+					if oldPath == newPath {
+						// Identical paths have been filtered earlier.
+						continue
 					}
-					if strdist.GlobPath(newPath, oldPath) {
-						if (old.Package > new.Package) || (old.Package == new.Package && old.Name > new.Name) ||
-							(old.Package == new.Package && old.Name == new.Name && oldPath > newPath) {
-							old, new = new, old
-							oldPath, newPath = newPath, oldPath
+					for _, new := range paths[newPath] {
+						newInfo := new.Contents[newPath]
+						if oldInfo.Kind == GlobPath && (newInfo.Kind == GlobPath || newInfo.Kind == CopyPath) {
+							if new.Package == old.Package {
+								continue
+							}
+						}
+						if strdist.GlobPath(newPath, oldPath) {
+							if (old.Package > new.Package) || (old.Package == new.Package && old.Name > new.Name) ||
+								(old.Package == new.Package && old.Name == new.Name && oldPath > newPath) {
+								old, new = new, old
+								oldPath, newPath = newPath, oldPath
+							}
+							return fmt.Errorf("slices %s and %s conflict on %s and %s", old, new, oldPath, newPath)
 						}
-						return fmt.Errorf("slices %s and %s conflict on %s and %s", old, new, oldPath, newPath)
 					}
 				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Check for conflicts on the files generated from the library paths and
+	// the environment of slices, which are not part of their contents.
+	envSlices := make(map[string]*Slice)
+	generated := make(map[string]*Slice)
+	addGenerated := func(path string, slice *Slice) {
+		if old, ok := generated[path]; !ok || slice.String() < old.String() {
+			generated[path] = slice
+		}
+	}
+	for _, pkg := range r.Packages {
+		for _, new := range pkg.Slices {
+			if len(new.LibraryPaths) > 0 {
+				addGenerated(LibraryPathsFile(pkg.Name), new)
+			}
+			if len(new.Environment) > 0 {
+				addGenerated(EnvironmentFile, new)
+			}
+			for name, value := range new.Environment {
+				old, ok := envSlices[name]
+				if ok && old.Environment[name] != value {
+					if old.String() > new.String() {
+						old, new = new, old
+					}
+					return fmt.Errorf("slices %s and %s conflict on environment variable %s", old, new, name)
+				}
+				envSlices[name] = new
+			}
+		}
+	}
+	for genPath, genSlice := range generated {
+		for _, newPath := range index.candidates(genPath) {
+			if newPath != genPath && !strdist.GlobPath(newPath, genPath) {
+				continue
+			}
+			old, new := genSlice, paths[newPath][0]
+			oldPath := genPath
+			if old.String() > new.String() {
+				old, new = new, old
+				oldPath, newPath = newPath, oldPath
+			}
+			if oldPath == newPath {
+				return fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
+			}
+			return fmt.Errorf("slices %s and %s conflict on %s and %s", old, new, oldPath, newPath)
+		}
 	}
 
 	// Check for cycles.
@@ -307,7 +787,7 @@ func (r *Release) validate() error {
 	// partition the dependency set. If we were to use arch, we would allow
 	// combinations of dependencies which are overly complex and brittle, that
 	// is why it is better to be more strict here.
-	_, err = order(r.Packages, keys, "")
+	_, err = order(r, keys, "")
 	if err != nil {
 		return err
 	}
@@ -326,11 +806,31 @@ func (r *Release) validate() error {
 
 	// Check that archives pinned in packages are defined.
 	for _, pkg := range r.Packages {
-		if pkg.Archive == "" {
-			continue
+		for _, archiveName := range pkg.Archives {
+			if _, ok := r.Archives[archiveName]; !ok {
+				return fmt.Errorf("%s: package refers to undefined archive %q", pkg.Path, archiveName)
+			}
 		}
-		if _, ok := r.Archives[pkg.Archive]; !ok {
-			return fmt.Errorf("%s: package refers to undefined archive %q", pkg.Path, pkg.Archive)
+	}
+
+	// Check that the slices in groups are defined.
+	aliases, err := sliceAliases(r.Packages)
+	if err != nil {
+		return err
+	}
+	groupNames := make([]string, 0, len(r.Groups))
+	for name := range r.Groups {
+		groupNames = append(groupNames, name)
+	}
+	slices.Sort(groupNames)
+	for _, name := range groupNames {
+		for _, key := range r.Groups[name] {
+			if _, ok := aliases[key]; ok {
+				continue
+			}
+			if pkg, ok := r.Packages[key.Package]; !ok || pkg.Slices[key.Slice] == nil {
+				return fmt.Errorf("chisel.yaml: group %q refers to undefined slice %s", name, key)
+			}
 		}
 	}
 
@@ -342,10 +842,47 @@ func (r *Release) validate() error {
 //
 // If arch is supplied, essential(s) not specific to that arch are not
 // considered.
-func order(pkgs map[string]*Package, keys []SliceKey, arch string) ([]SliceKey, error) {
+func order(release *Release, keys []SliceKey, arch string) ([]SliceKey, error) {
+	successors, err := essentialGraph(release.Packages, keys, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort them up.
+	var order []SliceKey
+	for _, names := range tarjanSort(successors) {
+		if len(names) > 1 {
+			links, err := release.essentialLinks(shortestCycle(successors, names))
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("essential loop detected: %s", FormatEssentialCycle(links))
+		}
+		order = append(order, nodeKey(names[0]))
+	}
+
+	return order, nil
+}
+
+// essentialGraph returns the essential slices of the slices in keys and of
+// all the slices reached from them, indexed by slice name.
+func essentialGraph(pkgs map[string]*Package, keys []SliceKey, arch string) (map[string][]string, error) {
+	aliases, err := sliceAliases(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(key SliceKey) SliceKey {
+		if provider, ok := aliases[key]; ok {
+			return provider
+		}
+		return key
+	}
 
 	// Preprocess the list to improve error messages.
-	for _, key := range keys {
+	keys = slices.Clone(keys)
+	for i, key := range keys {
+		key = resolve(key)
+		keys[i] = key
 		if pkg, ok := pkgs[key.Package]; !ok {
 			return nil, fmt.Errorf("slices of package %q not found", key.Package)
 		} else if _, ok := pkg.Slices[key.Slice]; !ok {
@@ -355,7 +892,7 @@ func order(pkgs map[string]*Package, keys []SliceKey, arch string) ([]SliceKey,
 
 	// Collect all relevant package slices.
 	successors := map[string][]string{}
-	pending := slices.Clone(keys)
+	pending := keys
 
 	seen := make(map[SliceKey]bool)
 	for i := 0; i < len(pending); i++ {
@@ -372,6 +909,11 @@ func order(pkgs map[string]*Package, keys []SliceKey, arch string) ([]SliceKey,
 			if len(info.Arch) > 0 && !slices.Contains(info.Arch, arch) {
 				continue
 			}
+			req = resolve(req)
+			if req == key {
+				// Package essentials may refer to the slice by its alias.
+				continue
+			}
 			fqreq := req.String()
 			if reqpkg, ok := pkgs[req.Package]; !ok || reqpkg.Slices[req.Slice] == nil {
 				return nil, fmt.Errorf("%s requires %s, but slice is missing", fqslice, fqreq)
@@ -379,21 +921,43 @@ func order(pkgs map[string]*Package, keys []SliceKey, arch string) ([]SliceKey,
 			predecessors = append(predecessors, fqreq)
 			pending = append(pending, req)
 		}
+		// Essentials are visited in a stable order so that the
+		// resulting order does not depend on map iteration.
+		slices.Sort(predecessors)
 		successors[fqslice] = predecessors
 	}
+	return successors, nil
+}
 
-	// Sort them up.
-	var order []SliceKey
-	for _, names := range tarjanSort(successors) {
-		if len(names) > 1 {
-			return nil, fmt.Errorf("essential loop detected: %s", strings.Join(names, ", "))
+// nodeKey returns the key of the slice named by a node of the essential graph.
+func nodeKey(name string) SliceKey {
+	dot := strings.IndexByte(name, '_')
+	return SliceKey{name[:dot], name[dot+1:]}
+}
+
+// sliceAliases returns the slices providing each alias. It returns an error
+// if an alias is provided by more than one slice or if it names an existing
+// slice.
+func sliceAliases(pkgs map[string]*Package) (map[SliceKey]SliceKey, error) {
+	aliases := make(map[SliceKey]SliceKey)
+	for _, pkg := range pkgs {
+		for _, slice := range pkg.Slices {
+			provider := SliceKey{slice.Package, slice.Name}
+			for _, alias := range slice.Provides {
+				if aliasPkg, ok := pkgs[alias.Package]; ok && aliasPkg.Slices[alias.Slice] != nil {
+					return nil, fmt.Errorf("slice %s provides %s, which is an existing slice", provider, alias)
+				}
+				if old, ok := aliases[alias]; ok {
+					if old.String() > provider.String() {
+						old, provider = provider, old
+					}
+					return nil, fmt.Errorf("slices %s and %s both provide %s", old, provider, alias)
+				}
+				aliases[alias] = provider
+			}
 		}
-		name := names[0]
-		dot := strings.IndexByte(name, '_')
-		order = append(order, SliceKey{name[:dot], name[dot+1:]})
 	}
-
-	return order, nil
+	return aliases, nil
 }
 
 func readRelease(baseDir string) (*Release, error) {
@@ -415,6 +979,41 @@ func readRelease(baseDir string) (*Release, error) {
 }
 
 func readSlices(release *Release, baseDir, dirName string) error {
+	pkgPaths := make(map[string]string)
+	var pkgNames []string
+	err := findReleaseSliceFiles(pkgPaths, &pkgNames, baseDir, dirName)
+	if err != nil {
+		return err
+	}
+
+	// The files are parsed concurrently. Each shard stops at its first
+	// error, so the error reported is the one of the first file failing,
+	// in the order the files were found.
+	pkgs := make([]*Package, len(pkgNames))
+	errs := make([]error, len(pkgNames))
+	runShards(parallelism, func(shard int) error {
+		for i := shard; i < len(pkgNames); i += parallelism {
+			pkgs[i], errs[i] = readSliceFile(release, baseDir, pkgNames[i], pkgPaths[pkgNames[i]])
+			if errs[i] != nil {
+				return errs[i]
+			}
+		}
+		return nil
+	})
+	for i, pkg := range pkgs {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if pkg != nil {
+			release.Packages[pkg.Name] = pkg
+		}
+	}
+	return nil
+}
+
+// findReleaseSliceFiles finds the slice definition files under dirName,
+// recording their paths by package name, and the names in the order found.
+func findReleaseSliceFiles(pkgPaths map[string]string, pkgNames *[]string, baseDir, dirName string) error {
 	entries, err := os.ReadDir(dirName)
 	if err != nil {
 		return fmt.Errorf("cannot read %s%c directory", stripBase(baseDir, dirName), filepath.Separator)
@@ -422,7 +1021,7 @@ func readSlices(release *Release, baseDir, dirName string) error {
 
 	for _, entry := range entries {
 		if entry.IsDir() {
-			err := readSlices(release, baseDir, filepath.Join(dirName, entry.Name()))
+			err := findReleaseSliceFiles(pkgPaths, pkgNames, baseDir, filepath.Join(dirName, entry.Name()))
 			if err != nil {
 				return err
 			}
@@ -438,25 +1037,280 @@ func readSlices(release *Release, baseDir, dirName string) error {
 
 		pkgName := match[1]
 		pkgPath := filepath.Join(dirName, entry.Name())
-		if pkg, ok := release.Packages[pkgName]; ok {
-			return fmt.Errorf("package %q slices defined more than once: %s and %s\")", pkgName, pkg.Path, pkgPath)
+		if oldPath, ok := pkgPaths[pkgName]; ok {
+			return fmt.Errorf("package %q slices defined more than once: %s and %s\")", pkgName, stripBase(baseDir, oldPath), pkgPath)
 		}
-		data, err := os.ReadFile(pkgPath)
+		pkgPaths[pkgName] = pkgPath
+		*pkgNames = append(*pkgNames, pkgName)
+	}
+	return nil
+}
+
+// readSliceFile reads and parses the slice definitions of the package in
+// the file at pkgPath.
+func readSliceFile(release *Release, baseDir, pkgName, pkgPath string) (*Package, error) {
+	data, err := os.ReadFile(pkgPath)
+	if err != nil {
+		// Errors from package os generally include the path.
+		return nil, fmt.Errorf("cannot read slice definition file: %v", err)
+	}
+
+	pkg, err := parsePackage(baseDir, pkgName, stripBase(baseDir, pkgPath), data)
+	if err != nil {
+		return nil, err
+	}
+	if release.UsrMerge {
+		err = mergeUsrPaths(pkg)
 		if err != nil {
-			// Errors from package os generally include the path.
-			return fmt.Errorf("cannot read slice definition file: %v", err)
+			return nil, err
 		}
+	}
+	err = resolveArchs(pkg, release)
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// CheckPackage parses the slice definitions in the file at pkgPath and
+// validates them against the release in dir, in place of the definitions
+// of the same package in the release, if any. Only the definitions of the
+// packages reached through the essentials and prefers of the package are
+// read, so conflicts with other packages in the release are not detected
+// unless full is set, in which case all of the definitions are read.
+func CheckPackage(dir string, pkgPath string, full bool) (*Package, error) {
+	data, err := os.ReadFile(pkgPath)
+	if err != nil {
+		return nil, &ParseError{fmt.Errorf("cannot read slice definition file: %v", err)}
+	}
+	return CheckPackageData(dir, pkgPath, data, full)
+}
+
+// CheckPackageData is like CheckPackage, but takes the content of the file
+// at pkgPath from data, such as the unsaved content of an editor.
+func CheckPackageData(dir string, pkgPath string, data []byte, full bool) (*Package, error) {
+	dir = filepath.Clean(dir)
+	pkg, err := parseCheckedPackage(dir, pkgPath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var release *Release
+	if full {
+		release, err = readRelease(dir)
+	} else {
+		release, err = readReachable(dir, pkg)
+	}
+	if err != nil {
+		return nil, &ParseError{err}
+	}
+	return checkPackage(release, pkg)
+}
+
+// CheckCachedPackage is like CheckPackage, but the release in dir is read
+// with ReadCachedRelease using the cache at cacheDir, so that it is not
+// parsed again while it does not change, as when the package is checked
+// whenever it is edited. The release is read as CheckPackage does when it
+// cannot be read as a whole, as the package may only be checked against
+// part of it.
+func CheckCachedPackage(dir, cacheDir, pkgPath string, full bool) (*Package, error) {
+	data, err := os.ReadFile(pkgPath)
+	if err != nil {
+		return nil, &ParseError{fmt.Errorf("cannot read slice definition file: %v", err)}
+	}
+	dir = filepath.Clean(dir)
+	pkg, err := parseCheckedPackage(dir, pkgPath, data)
+	if err != nil {
+		return nil, err
+	}
 
-		pkg, err := parsePackage(baseDir, pkgName, stripBase(baseDir, pkgPath), data)
+	release, err := ReadCachedRelease(dir, cacheDir)
+	if err != nil {
+		debugf("Cannot use cached release: %v", err)
+		return CheckPackageData(dir, pkgPath, data, full)
+	}
+	if !full {
+		release = reachableRelease(release, pkg)
+	}
+	return checkPackage(release, pkg)
+}
+
+// parseCheckedPackage parses the slice definitions of the file at pkgPath
+// from data, as CheckPackage does.
+func parseCheckedPackage(dir, pkgPath string, data []byte) (*Package, error) {
+	match := apacheutil.FnameExp.FindStringSubmatch(filepath.Base(pkgPath))
+	if match == nil || !strings.HasSuffix(pkgPath, ".yaml") {
+		return nil, &ParseError{fmt.Errorf("invalid slice definition filename: %q", filepath.Base(pkgPath))}
+	}
+	pkg, err := parsePackage(dir, match[1], pkgPath, data)
+	if err != nil {
+		return nil, &ParseError{err}
+	}
+	return pkg, nil
+}
+
+// checkPackage validates pkg against release, in place of the definitions
+// of the same package in the release, if any.
+func checkPackage(release *Release, pkg *Package) (*Package, error) {
+	var err error
+	if release.UsrMerge {
+		err = mergeUsrPaths(pkg)
 		if err != nil {
-			return err
+			return nil, &ParseError{err}
+		}
+	}
+	err = resolveArchs(pkg, release)
+	if err != nil {
+		return nil, &ParseError{err}
+	}
+	release.Packages[pkg.Name] = pkg
+	err = release.validate()
+	if err != nil {
+		return nil, &ValidationError{err}
+	}
+	return pkg, nil
+}
+
+// reachableRelease returns a copy of release with only the packages reached
+// from pkg through essentials and prefers, as readReachable reads them. The
+// release is returned as it is if any of those packages is not defined, as
+// it may be an alias provided by another package.
+func reachableRelease(release *Release, pkg *Package) *Release {
+	reachable := *release
+	// Groups may refer to any of the slices in the release.
+	reachable.Groups = nil
+	reachable.Packages = make(map[string]*Package)
+	seen := map[string]bool{pkg.Name: true}
+	pending := []*Package{pkg}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for _, pkgName := range reachedPackages(current) {
+			if seen[pkgName] {
+				continue
+			}
+			seen[pkgName] = true
+			reached, ok := release.Packages[pkgName]
+			if !ok {
+				return release
+			}
+			reachable.Packages[pkgName] = reached
+			pending = append(pending, reached)
+		}
+	}
+	return &reachable
+}
+
+// readReachable reads the release in baseDir with only the slice definitions
+// of the packages reached from pkg through essentials and prefers. All of the
+// definitions are read if any of those packages has no definition file of
+// its own, as it may be an alias provided by another package.
+func readReachable(baseDir string, pkg *Package) (*Release, error) {
+	filePath := filepath.Join(baseDir, "chisel.yaml")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read release definition: %s", err)
+	}
+	release, err := parseRelease(baseDir, filePath, data)
+	if err != nil {
+		return nil, err
+	}
+	// Groups may refer to any of the slices in the release.
+	release.Groups = nil
+
+	pkgPaths := make(map[string]string)
+	err = findSliceFiles(pkgPaths, baseDir, filepath.Join(baseDir, "slices"))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{pkg.Name: true}
+	pending := []*Package{pkg}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for _, pkgName := range reachedPackages(current) {
+			if seen[pkgName] {
+				continue
+			}
+			seen[pkgName] = true
+			pkgPath, ok := pkgPaths[pkgName]
+			if !ok {
+				return readRelease(baseDir)
+			}
+			data, err := os.ReadFile(pkgPath)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read slice definition file: %v", err)
+			}
+			reached, err := parsePackage(baseDir, pkgName, stripBase(baseDir, pkgPath), data)
+			if err != nil {
+				return nil, err
+			}
+			if release.UsrMerge {
+				err = mergeUsrPaths(reached)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = resolveArchs(reached, release)
+			if err != nil {
+				return nil, err
+			}
+			release.Packages[pkgName] = reached
+			pending = append(pending, reached)
 		}
+	}
+	return release, nil
+}
 
-		release.Packages[pkg.Name] = pkg
+// findSliceFiles records in pkgPaths the path of the slice definition file
+// of each package found in dirName, without parsing them.
+func findSliceFiles(pkgPaths map[string]string, baseDir, dirName string) error {
+	entries, err := os.ReadDir(dirName)
+	if err != nil {
+		return fmt.Errorf("cannot read %s%c directory", stripBase(baseDir, dirName), filepath.Separator)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			err := findSliceFiles(pkgPaths, baseDir, filepath.Join(dirName, entry.Name()))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		match := apacheutil.FnameExp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return fmt.Errorf("invalid slice definition filename: %q", entry.Name())
+		}
+		pkgPath := filepath.Join(dirName, entry.Name())
+		if oldPath, ok := pkgPaths[match[1]]; ok {
+			return fmt.Errorf("package %q slices defined more than once: %s and %s", match[1], stripBase(baseDir, oldPath), stripBase(baseDir, pkgPath))
+		}
+		pkgPaths[match[1]] = pkgPath
 	}
 	return nil
 }
 
+// reachedPackages returns the names of the packages which pkg refers to
+// through essentials and prefers, in sorted order.
+func reachedPackages(pkg *Package) []string {
+	var names []string
+	for _, slice := range pkg.Slices {
+		for skey := range slice.Essential {
+			names = append(names, skey.Package)
+		}
+		for _, info := range slice.Contents {
+			if info.Prefer != "" {
+				names = append(names, info.Prefer)
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
 func stripBase(baseDir, path string) string {
 	// Paths must be clean for this to work correctly.
 	return strings.TrimPrefix(path, baseDir+string(filepath.Separator))
@@ -466,27 +1320,38 @@ func Select(release *Release, slices []SliceKey, arch string) (*Selection, error
 	logf("Selecting slices...")
 
 	selection := &Selection{
-		Release: release,
+		Release:   release,
+		requested: slices,
 	}
 
-	sorted, err := order(release.Packages, slices, arch)
+	sorted, err := order(release, slices, arch)
 	if err != nil {
-		return nil, err
+		return nil, &ValidationError{err}
 	}
 	selection.Slices = make([]*Slice, len(sorted))
 	for i, key := range sorted {
 		selection.Slices[i] = release.Packages[key.Package].Slices[key.Slice]
 	}
 
+	for _, slice := range selection.Slices {
+		if slice.Deprecated != "" {
+			logf("Warning: Slice %s is deprecated: %s", slice, slice.Deprecated)
+		}
+	}
+
 	for _, new := range selection.Slices {
 		for newPath, newInfo := range new.Contents {
 			// An invalid "generate" value should only throw an error if that
 			// particular slice is selected. Hence, the check is here.
 			switch newInfo.Generate {
-			case GenerateNone, GenerateManifest:
+			case GenerateNone, GenerateManifest, GenerateCACertificates, GenerateConcat,
+				GenerateMachineID, GenerateHostname, GenerateResolvConf:
 			default:
-				return nil, fmt.Errorf("slice %s has invalid 'generate' for path %s: %q",
-					new, newPath, newInfo.Generate)
+				if newInfo.Generate.IsCustom() {
+					continue
+				}
+				return nil, &ValidationError{fmt.Errorf("slice %s has invalid 'generate' for path %s: %q",
+					new, newPath, newInfo.Generate)}
 			}
 		}
 	}
@@ -494,6 +1359,159 @@ func Select(release *Release, slices []SliceKey, arch string) (*Selection, error
 	return selection, nil
 }
 
+// Exclude removes from the selection the slices matching any of the
+// patterns, which are slice names that may hold "*" and "?" wildcards, as
+// in "*_docs". Removing a slice which is essential to another one left
+// in the selection fails, unless force is true. Slices which were only
+// selected as essentials of the removed ones are removed as well.
+//
+// Patterns starting with "/" are path patterns instead, as in
+// "/usr/share/doc/**", which drop the paths they match from the selected
+// slices. They are recorded in ExcludedPaths, as the paths they match
+// within the globs of the slices are only known once extracted.
+func (s *Selection) Exclude(patterns []string, arch string, force bool) error {
+	excluded := make(map[SliceKey]bool)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "/") {
+			err := s.excludePath(pattern)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		matched := false
+		for _, slice := range s.Slices {
+			ok, err := path.Match(pattern, slice.String())
+			if err != nil {
+				return fmt.Errorf("invalid slice pattern: %q", pattern)
+			}
+			if ok {
+				excluded[SliceKey{slice.Package, slice.Name}] = true
+				matched = true
+			}
+		}
+		if !matched {
+			logf("Warning: No selected slice matches %q", pattern)
+		}
+	}
+	if len(excluded) == 0 {
+		return nil
+	}
+
+	aliases, err := sliceAliases(s.Release.Packages)
+	if err != nil {
+		return err
+	}
+	resolve := func(key SliceKey) SliceKey {
+		if provider, ok := aliases[key]; ok {
+			return provider
+		}
+		return key
+	}
+	selected := make(map[SliceKey]*Slice, len(s.Slices))
+	for _, slice := range s.Slices {
+		selected[SliceKey{slice.Package, slice.Name}] = slice
+	}
+
+	// Keep the slices reached from the requested ones which are left.
+	// Without them, as when the selection was not built by Select, all
+	// the slices left are kept.
+	var pending []SliceKey
+	if s.requested != nil {
+		for _, key := range s.requested {
+			pending = append(pending, resolve(key))
+		}
+	} else {
+		for key := range selected {
+			pending = append(pending, key)
+		}
+	}
+	kept := make(map[SliceKey]bool)
+	for len(pending) > 0 {
+		key := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		slice := selected[key]
+		if kept[key] || excluded[key] || slice == nil {
+			continue
+		}
+		kept[key] = true
+		for req, info := range slice.Essential {
+			if len(info.Arch) > 0 && !slices.Contains(info.Arch, arch) {
+				continue
+			}
+			pending = append(pending, resolve(req))
+		}
+	}
+
+	keptSlices := make([]*Slice, 0, len(kept))
+	for _, slice := range s.Slices {
+		key := SliceKey{slice.Package, slice.Name}
+		if !kept[key] {
+			if !excluded[key] {
+				logf("Excluding slice %s only essential to excluded slices", slice)
+			}
+			continue
+		}
+		reqs := make([]SliceKey, 0, len(slice.Essential))
+		for req, info := range slice.Essential {
+			if len(info.Arch) > 0 && !slices.Contains(info.Arch, arch) {
+				continue
+			}
+			if req = resolve(req); excluded[req] {
+				reqs = append(reqs, req)
+			}
+		}
+		slices.SortFunc(reqs, func(a, b SliceKey) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		for _, req := range reqs {
+			if !force {
+				return fmt.Errorf("cannot exclude slice %s: essential to slice %s", req, slice)
+			}
+			logf("Warning: Excluding slice %s essential to slice %s", req, slice)
+		}
+		keptSlices = append(keptSlices, slice)
+	}
+	s.Slices = keptSlices
+	return nil
+}
+
+// excludePath drops the paths matching pattern from the selected slices,
+// which are replaced by copies so that the release is left unchanged.
+// Globs which pattern matches only in part are left to the extraction.
+func (s *Selection) excludePath(pattern string) error {
+	if path.Clean(pattern) != strings.TrimSuffix(pattern, "/") && pattern != "/" {
+		return fmt.Errorf("invalid path pattern: %q", pattern)
+	}
+	s.ExcludedPaths = append(s.ExcludedPaths, pattern)
+	matched := false
+	for i, slice := range s.Slices {
+		var contents map[string]PathInfo
+		for slicePath := range slice.Contents {
+			if !strdist.GlobPath(pattern, slicePath) {
+				continue
+			}
+			matched = true
+			if slicePath != pattern && strdist.ContainsWildcard(slicePath) {
+				continue
+			}
+			if contents == nil {
+				contents = maps.Clone(slice.Contents)
+			}
+			delete(contents, slicePath)
+		}
+		if contents != nil {
+			clone := *slice
+			clone.Contents = contents
+			s.Slices[i] = &clone
+		}
+	}
+	if !matched {
+		logf("Warning: No path of the selected slices matches %q", pattern)
+	}
+	return nil
+}
+
 const (
 	preferSource = 1
 	preferTarget = 2
@@ -603,3 +1621,30 @@ func sortPair(name1, name2 string) (sorted1, sorted2 string) {
 	}
 	return name2, name1
 }
+
+// diverts returns the slices diverting each path. Slices of different
+// packages cannot divert the same path, and slices of the same package must
+// divert it to the same place.
+func (r *Release) diverts() (map[string]*Slice, error) {
+	diverts := make(map[string]*Slice)
+	for _, pkgName := range slices.Sorted(maps.Keys(r.Packages)) {
+		pkg := r.Packages[pkgName]
+		for _, sliceName := range slices.Sorted(maps.Keys(pkg.Slices)) {
+			new := pkg.Slices[sliceName]
+			for divPath, divertTo := range new.Divert {
+				old, ok := diverts[divPath]
+				if !ok {
+					diverts[divPath] = new
+					continue
+				}
+				if old.Package != new.Package {
+					return nil, fmt.Errorf("slices %s and %s both divert %s", old, new, divPath)
+				}
+				if old.Divert[divPath] != divertTo {
+					return nil, fmt.Errorf("slices %s and %s divert %s differently", old, new, divPath)
+				}
+			}
+		}
+	}
+	return diverts, nil
+}
@@ -1,8 +1,13 @@
 package setup_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -10,7 +15,9 @@ import (
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v3"
 
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
 	"github.com/canonical/chisel/internal/testutil"
 )
 
@@ -46,6 +53,40 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `chisel.yaml: no archives defined`,
+}, {
+	summary: "Groups must have valid names",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\tBase_Set: [mypkg_myslice]\n",
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+		`,
+	},
+	relerror: `chisel.yaml: invalid group name: "Base_Set"`,
+}, {
+	summary: "Groups must list slices",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\tbase: []\n",
+	},
+	relerror: `chisel.yaml: group "base" has no slices`,
+}, {
+	summary: "Groups must list valid slice references",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\tbase: [mypkg]\n",
+	},
+	relerror: `chisel.yaml: group "base" has invalid slice reference: "mypkg"`,
+}, {
+	summary: "Groups must list existing slices",
+	input: map[string]string{
+		"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\tbase: [mypkg_myslice, mypkg_other]\n",
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+		`,
+	},
+	relerror: `chisel.yaml: group "base" refers to undefined slice mypkg_other`,
 }, {
 	summary: "Enforce matching filename and package name",
 	input: map[string]string{
@@ -236,7 +277,8 @@ var setupTests = []setupTest{{
 						- mypkg_myslice1
 		`,
 	},
-	relerror: `essential loop detected: mypkg_myslice1, mypkg_myslice2, mypkg_myslice3`,
+	relerror: `essential loop detected: mypkg_myslice1 \(slices/mydir/mypkg.yaml:5\) -> ` +
+		`mypkg_myslice2 \(slices/mydir/mypkg.yaml:8\) -> mypkg_myslice3 \(slices/mydir/mypkg.yaml:11\) -> mypkg_myslice1`,
 }, {
 	summary: "Cycles are detected across packages",
 	input: map[string]string{
@@ -262,7 +304,8 @@ var setupTests = []setupTest{{
 						- mypkg1_myslice
 		`,
 	},
-	relerror: `essential loop detected: mypkg1_myslice, mypkg2_myslice, mypkg3_myslice`,
+	relerror: `essential loop detected: mypkg1_myslice \(slices/mydir/mypkg1.yaml:5\) -> ` +
+		`mypkg2_myslice \(slices/mydir/mypkg2.yaml:5\) -> mypkg3_myslice \(slices/mydir/mypkg3.yaml:5\) -> mypkg1_myslice`,
 }, {
 	summary: "Missing package dependency",
 	input: map[string]string{
@@ -339,6 +382,128 @@ var setupTests = []setupTest{{
 			},
 		}},
 	},
+}, {
+	summary: "Selection order is stable for unrelated essentials",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {essential: [mypkg3_myslice1, mypkg2_myslice1]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1: {}
+		`,
+		"slices/mydir/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice1: {}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg2",
+			Name:    "myslice1",
+		}, {
+			Package: "mypkg3",
+			Name:    "myslice1",
+		}, {
+			Package: "mypkg1",
+			Name:    "myslice1",
+			Essential: map[setup.SliceKey]setup.EssentialInfo{
+				{"mypkg2", "myslice1"}: {},
+				{"mypkg3", "myslice1"}: {},
+			},
+		}},
+	},
+}, {
+	summary: "Selection with dependencies on aliases",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {essential: [openssl_libs]}
+		`,
+		"slices/mydir/libssl3t64.yaml": `
+			package: libssl3t64
+			slices:
+				libs: {provides: [openssl_libs]}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package:  "libssl3t64",
+			Name:     "libs",
+			Provides: []setup.SliceKey{{"openssl", "libs"}},
+		}, {
+			Package: "mypkg1",
+			Name:    "myslice1",
+			Essential: map[setup.SliceKey]setup.EssentialInfo{
+				{"openssl", "libs"}: {},
+			},
+		}},
+	},
+}, {
+	summary: "Aliases may be selected directly",
+	input: map[string]string{
+		"slices/mydir/libssl3t64.yaml": `
+			package: libssl3t64
+			essential: [openssl_libs]
+			slices:
+				libs: {provides: [openssl_libs]}
+				config: {}
+		`,
+	},
+	selslices: []setup.SliceKey{{"openssl", "libs"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "libssl3t64",
+			Name:    "libs",
+			Essential: map[setup.SliceKey]setup.EssentialInfo{
+				{"openssl", "libs"}: {},
+			},
+			Provides: []setup.SliceKey{{"openssl", "libs"}},
+		}},
+	},
+}, {
+	summary: "Aliases cannot be provided by more than one slice",
+	input: map[string]string{
+		"slices/mydir/libssl3.yaml": `
+			package: libssl3
+			slices:
+				libs: {provides: [openssl_libs]}
+		`,
+		"slices/mydir/libssl3t64.yaml": `
+			package: libssl3t64
+			slices:
+				libs: {provides: [openssl_libs]}
+		`,
+	},
+	relerror: `slices libssl3_libs and libssl3t64_libs both provide openssl_libs`,
+}, {
+	summary: "Aliases cannot name existing slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1: {provides: [mypkg_myslice2]}
+				myslice2: {}
+		`,
+	},
+	relerror: `slice mypkg_myslice1 provides mypkg_myslice2, which is an existing slice`,
+}, {
+	summary: "Invalid alias reference",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1: {provides: [openssl-libs]}
+		`,
+	},
+	relerror: `slice mypkg_myslice1 has invalid 'provides' reference: "openssl-libs"`,
 }, {
 	summary: "Selection with matching paths don't conflict",
 	input: map[string]string{
@@ -401,53 +566,83 @@ var setupTests = []setupTest{{
 	},
 	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
 }, {
-	summary: "Directories must be suffixed with /",
+	summary: "Same paths across packages",
 	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/foo: {make: true}
+						/path1: {same: true}
 		`,
-	},
-	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
-}, {
-	summary: "Slice path must be clean",
-	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/foo/../:
+						/path1: {same: true}
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg1": {
+				Name: "mypkg1",
+				Path: "slices/mydir/mypkg1.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg1",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/path1": {Kind: "copy", Same: true},
+						},
+					},
+				},
+			},
+			"mypkg2": {
+				Name: "mypkg2",
+				Path: "slices/mydir/mypkg2.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg2",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/path1": {Kind: "copy", Same: true},
+						},
+					},
+				},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
 }, {
-	summary: "Slice path must be absolute",
+	summary: "Paths with the same overwrite policy across packages",
 	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						./foo/:
+						/path1: {mutable: true, overwrite: merge}
 		`,
-	},
-	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
-}, {
-	summary: "Globbing support",
-	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
 			slices:
 				myslice1:
 					contents:
-						/file/*:
-				myslice2:
-					contents:
-						/another/**:
+						/path1: {text: foo, mutable: true, overwrite: merge}
 		`,
 	},
 	release: &setup.Release{
@@ -462,22 +657,28 @@ var setupTests = []setupTest{{
 			},
 		},
 		Packages: map[string]*setup.Package{
-			"mypkg": {
-				Name: "mypkg",
-				Path: "slices/mydir/mypkg.yaml",
+			"mypkg1": {
+				Name: "mypkg1",
+				Path: "slices/mydir/mypkg1.yaml",
 				Slices: map[string]*setup.Slice{
 					"myslice1": {
-						Package: "mypkg",
+						Package: "mypkg1",
 						Name:    "myslice1",
 						Contents: map[string]setup.PathInfo{
-							"/file/*": {Kind: "glob"},
+							"/path1": {Kind: "copy", Mutable: true, Overwrite: "merge"},
 						},
 					},
-					"myslice2": {
-						Package: "mypkg",
-						Name:    "myslice2",
+				},
+			},
+			"mypkg2": {
+				Name: "mypkg2",
+				Path: "slices/mydir/mypkg2.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg2",
+						Name:    "myslice1",
 						Contents: map[string]setup.PathInfo{
-							"/another/**": {Kind: "glob"},
+							"/path1": {Kind: "text", Info: "foo", Mutable: true, Overwrite: "merge"},
 						},
 					},
 				},
@@ -489,85 +690,311 @@ var setupTests = []setupTest{{
 		},
 	},
 }, {
-	summary: "Conflicting globs",
+	summary: "Paths must agree on the overwrite policy",
 	input: map[string]string{
 		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/f*obar:
+						/path1: {mutable: true, overwrite: merge}
 		`,
 		"slices/mydir/mypkg2.yaml": `
 			package: mypkg2
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/foob*r:
+						/path1: {text: foo, mutable: true, overwrite: keep-first}
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 have different overwrite policies on /path1",
 }, {
-	summary: "Conflicting globs and plain copies",
+	summary: "Overwrite policy error keeps conflicts",
 	input: map[string]string{
 		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/foobar:
+						/path1: {mutable: true, overwrite: error}
 		`,
 		"slices/mydir/mypkg2.yaml": `
 			package: mypkg2
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/foob*r:
+						/path1: {text: foo, mutable: true, overwrite: error}
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
 }, {
-	summary: "Conflicting matching globs",
+	summary: "Overwrite policy requires mutable paths",
 	input: map[string]string{
 		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
-				myslice:
-					contents:
-						/file/foob*r:
-		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
-			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/foob*r:
+						/path1: {overwrite: merge}
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
+	relerror: "slice mypkg1_myslice1 path /path1 has 'overwrite' but is not mutable",
 }, {
-	summary: "Conflicting globs in same package is okay",
+	summary: "Invalid overwrite policy",
 	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
 			slices:
 				myslice1:
 					contents:
-						/file/foob*r:
-						/file/f*r:
-				myslice2:
-					contents:
-						/file/foob*r:
-						/file/f*obar:
+						/path1: {mutable: true, overwrite: append}
 		`,
 	},
+	relerror: `slice mypkg1_myslice1 has invalid 'overwrite' for path /path1: "append"`,
 }, {
-	summary: "Invalid glob options",
+	summary: "Same paths must be set in every package",
 	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
 			slices:
-				myslice:
+				myslice1:
+					contents:
+						/path1: {same: true}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/path1:
+		`,
+	},
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
+}, {
+	summary: "Same paths must agree on the content",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1:
+					contents:
+						/path1: {same: true, mode: 0644}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/path1: {same: true, mode: 0755}
+		`,
+	},
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
+}, {
+	summary: "Same is only valid for files extracted as is",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1:
+					contents:
+						/path*: {same: true}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/path2: {text: foo, same: true}
+		`,
+	},
+	relerror: `slice mypkg1_myslice1 path /path\* has 'same' but is not a file extracted as is`,
+}, {
+	summary: "Directories must be suffixed with /",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo: {make: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
+}, {
+	summary: "Slice path must be clean",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo/../:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+}, {
+	summary: "Slice path must be absolute",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						./foo/:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
+}, {
+	summary: "Globbing support",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/file/*:
+				myslice2:
+					contents:
+						/another/**:
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/file/*": {Kind: "glob"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/another/**": {Kind: "glob"},
+						},
+					},
+				},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Conflicting globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/f*obar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+}, {
+	summary: "Conflicting character classes",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foo[a-m]:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foo[!a-k]:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foo\[a-m\] and /file/foo\[!a-k\]`,
+}, {
+	summary: "Conflicting globs and plain copies",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foobar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+}, {
+	summary: "Conflicting matching globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
+}, {
+	summary: "Conflicting globs in same package is okay",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/file/foob*r:
+						/file/f*r:
+				myslice2:
+					contents:
+						/file/foob*r:
+						/file/f*obar:
+		`,
+	},
+}, {
+	summary: "Invalid glob options",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
 					contents:
 						/file/foob*r: {text: foo}
 		`,
@@ -848,7 +1275,7 @@ var setupTests = []setupTest{{
 		},
 	},
 }, {
-	summary: "Multiple archives inconsistent use of priorities",
+	summary: "Package lists the archives it is fetched from",
 	input: map[string]string{
 		"chisel.yaml": `
 			format: v1
@@ -866,6 +1293,7 @@ var setupTests = []setupTest{{
 					version: 22.04
 					components: [universe]
 					suites: [jammy-updates]
+					priority: -10
 					public-keys: [test-key]
 			public-keys:
 				test-key:
@@ -874,36 +1302,100 @@ var setupTests = []setupTest{{
 		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
+			archive: [bar, foo]
 		`,
 	},
-	relerror: `chisel.yaml: archive "bar" is missing the priority setting`,
-}, {
-	summary: "Multiple archives with no priorities",
-	input: map[string]string{
-		"chisel.yaml": `
-			format: v1
-			maintenance:
-				standard: 2025-01-01
-				end-of-life: 2100-01-01
-			archives:
-				foo:
-					version: 22.04
-					components: [main, universe]
-					suites: [jammy]
-					public-keys: [test-key]
-				bar:
-					version: 22.04
-					components: [universe]
-					suites: [jammy-updates]
-					public-keys: [test-key]
-			public-keys:
-				test-key:
-					id: ` + testKey.ID + `
-					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
-		`,
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
-		`,
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"foo": {
+				Name:       "foo",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				Priority:   20,
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+			"bar": {
+				Name:       "bar",
+				Version:    "22.04",
+				Suites:     []string{"jammy-updates"},
+				Components: []string{"universe"},
+				Priority:   -10,
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:     "mypkg",
+				Path:     "slices/mydir/mypkg.yaml",
+				Archives: []string{"bar", "foo"},
+				Slices:   map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Multiple archives inconsistent use of priorities",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [universe]
+					suites: [jammy-updates]
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "bar" is missing the priority setting`,
+}, {
+	summary: "Multiple archives with no priorities",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [universe]
+					suites: [jammy-updates]
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
 	},
 	relerror: `chisel.yaml: archive "bar" is missing the priority setting`,
 }, {
@@ -952,6 +1444,12 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `chisel.yaml: archives "bar" and "foo" have the same priority value of 20`,
+}, {
+	summary: "Invalid archive strategy",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "archive-strategy: newest\n\tarchives:", 1),
+	},
+	relerror: `chisel.yaml: invalid archive-strategy: "newest"`,
 }, {
 	summary: "Invalid archive priority",
 	input: map[string]string{
@@ -1422,7 +1920,7 @@ var setupTests = []setupTest{{
 				slice2:
 		`,
 	},
-	relerror: "essential loop detected: mypkg_slice1, mypkg_slice2",
+	relerror: `essential loop detected: mypkg_slice1 \(slices/mydir/mypkg.yaml:4\) -> mypkg_slice2 \(slices/mydir/mypkg.yaml:3\) -> mypkg_slice1`,
 }, {
 	summary: "Cannot add slice to itself as essential",
 	input: map[string]string{
@@ -1526,6 +2024,33 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `slices/test-package.yaml: package refers to undefined archive "non-existing"`,
+}, {
+	summary: "Archive in the package list is not defined",
+	input: map[string]string{
+		"slices/test-package.yaml": `
+			package: test-package
+			archive: [ubuntu, non-existing]
+		`,
+	},
+	relerror: `slices/test-package.yaml: package refers to undefined archive "non-existing"`,
+}, {
+	summary: "Package lists an archive twice",
+	input: map[string]string{
+		"slices/test-package.yaml": `
+			package: test-package
+			archive: [ubuntu, ubuntu]
+		`,
+	},
+	relerror: `slices/test-package.yaml: package lists archive "ubuntu" twice`,
+}, {
+	summary: "Package has an empty archive list",
+	input: map[string]string{
+		"slices/test-package.yaml": `
+			package: test-package
+			archive: []
+		`,
+	},
+	relerror: `slices/test-package.yaml: package has an empty archive list`,
 }, {
 	summary: "Specify generate: manifest",
 	input: map[string]string{
@@ -1622,6 +2147,40 @@ var setupTests = []setupTest{{
 	},
 	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
 	selerror:  `slice mypkg_myslice has invalid 'generate' for path /dir/\*\*: "foo"`,
+}, {
+	summary: "Slices with custom generate kinds may be selected",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/machine/**: {generate: "x-machine-id"}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/etc/machine/**": {Kind: "generate", Generate: "x-machine-id"},
+			},
+		}},
+	},
+}, {
+	summary: "Custom generate kinds must be well formed",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/dir/**: {generate: "x-Machine_ID"}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selerror:  `slice mypkg_myslice has invalid 'generate' for path /dir/\*\*: "x-Machine_ID"`,
 }, {
 	summary: "Paths with generate: manifest must have trailing /**",
 	input: map[string]string{
@@ -1783,107 +2342,763 @@ var setupTests = []setupTest{{
 	},
 	relerror: `slice mypkg_myslice path /path/\*\* has invalid generate options`,
 }, {
-	summary: "chisel-v1 is deprecated",
+	summary: "Diverting slices claim paths of other packages",
 	input: map[string]string{
-		"chisel.yaml": `
-			format: chisel-v1
-			archives:
-				foo:
-					version: 22.04
-					components: [main, universe]
-					suites: [jammy]
-					v1-public-keys: [test-key]
-			v1-public-keys:
-				test-key:
-					id: ` + testKey.ID + `
-					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/sh: {symlink: dash}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/bin/sh:
 		`,
 	},
-	relerror: `chisel.yaml: unknown format "chisel-v1"`,
+	selslices: []setup.SliceKey{{"mypkg1", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg1",
+			Name:    "myslice",
+			Divert: map[string]string{
+				"/usr/bin/sh": "/usr/bin/sh.distrib",
+			},
+			Contents: map[string]setup.PathInfo{
+				"/usr/bin/sh": {Kind: "symlink", Info: "dash"},
+			},
+		}},
+	},
 }, {
-	summary: "Default archive compatibility",
+	summary: "Diverted paths must be valid",
 	input: map[string]string{
-		"chisel.yaml": `
-			format: v1
-			maintenance:
-				standard: 2025-01-01
-				end-of-life: 2100-01-01
-			archives:
-				default:
-					default: true
-					version: 22.04
-					components: [main]
-					suites: [jammy]
-					public-keys: [test-key]
-				other-1:
-					version: 22.04
-					components: [main]
-					suites: [jammy]
-					public-keys: [test-key]
-				other-2:
-					version: 22.04
-					components: [main]
-					suites: [jammy]
-					public-keys: [test-key]
-			public-keys:
-				test-key:
-					id: ` + testKey.ID + `
-					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
-		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: sh.distrib
+					contents:
+						/usr/bin/sh:
 		`,
 	},
-	release: &setup.Release{
-		Archives: map[string]*setup.Archive{
-			"default": {
-				Name:       "default",
-				Version:    "22.04",
-				Suites:     []string{"jammy"},
-				Components: []string{"main"},
-				PubKeys:    []*packet.PublicKey{testKey.PubKey},
-				Maintained: true,
-				Priority:   1,
-			},
-			"other-1": {
-				Name:       "other-1",
-				Version:    "22.04",
-				Suites:     []string{"jammy"},
-				Components: []string{"main"},
-				PubKeys:    []*packet.PublicKey{testKey.PubKey},
-				Maintained: true,
-				Priority:   -2,
-			},
-			"other-2": {
-				Name:       "other-2",
-				Version:    "22.04",
-				Suites:     []string{"jammy"},
-				Components: []string{"main"},
-				PubKeys:    []*packet.PublicKey{testKey.PubKey},
-				Maintained: true,
-				Priority:   -3,
-			},
-		},
-		Packages: map[string]*setup.Package{
-			"mypkg": {
-				Name:   "mypkg",
-				Path:   "slices/mydir/mypkg.yaml",
-				Slices: map[string]*setup.Slice{},
-			},
-		},
-		Maintenance: &setup.Maintenance{
-			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
-			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
-		},
+	relerror: `slice mypkg_myslice has invalid 'divert' path: "sh.distrib"`,
+}, {
+	summary: "Diverted paths must be listed in the contents",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+		`,
 	},
+	relerror: `slice mypkg_myslice diverts /usr/bin/sh but does not list it in its contents`,
 }, {
-	summary: "Pro values in archives",
+	summary: "Paths cannot be diverted to listed paths",
 	input: map[string]string{
-		"chisel.yaml": `
-			format: v1
-			maintenance:
-				standard:    2025-01-01
-				expanded:    2100-01-01
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/sh:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/bin/sh.*:
+		`,
+	},
+	relerror: `slice mypkg1_myslice diverts /usr/bin/sh to /usr/bin/sh.distrib, which slice mypkg2_myslice lists`,
+}, {
+	summary: "Packages cannot divert the same path",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/sh:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/sh:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice both divert /usr/bin/sh`,
+}, {
+	summary: "Usrmerge lists paths by their /usr counterparts",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "usrmerge: true\n\tarchives:", 1),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/bin/foo:
+						/bin:
+						/lib/x86_64-linux-gnu/**:
+						/usr/sbin/bar: {copy: /sbin/bar}
+						/etc/baz:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/usr/bin/foo":                 {Kind: "copy"},
+				"/bin":                         {Kind: "copy"},
+				"/usr/lib/x86_64-linux-gnu/**": {Kind: "glob"},
+				"/usr/sbin/bar":                {Kind: "copy", Info: "/usr/sbin/bar"},
+				"/etc/baz":                     {Kind: "copy"},
+			},
+		}},
+	},
+}, {
+	summary: "Arch exclusions and groups are resolved",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					essential:
+						- slice: mypkg_other
+						  when: arch in [any-arm, !armhf]
+					contents:
+						/a: {arch: ["!s390x"]}
+						/b: {arch: any-arm}
+						/c: {arch: [any-x86, "!i386"]}
+						/d:
+							arch: !any-arm
+						/e: {arch: [i386, amd64]}
+				other:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Essential: map[setup.SliceKey]setup.EssentialInfo{
+				{"mypkg", "other"}: {Arch: []string{"arm64"}},
+			},
+			Contents: map[string]setup.PathInfo{
+				"/a": {Kind: "copy", Arch: []string{"i386", "amd64", "armhf", "arm64", "ppc64el", "riscv64"}},
+				"/b": {Kind: "copy", Arch: []string{"armhf", "arm64"}},
+				"/c": {Kind: "copy", Arch: []string{"amd64"}},
+				"/d": {Kind: "copy", Arch: []string{"i386", "amd64", "ppc64el", "riscv64", "s390x"}},
+				"/e": {Kind: "copy", Arch: []string{"i386", "amd64"}},
+			},
+		}},
+	},
+}, {
+	summary: "Arch exclusions are resolved against the architectures of the release",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "architectures: [amd64, arm64, s390x]\n\tarchives:", 1),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/a:
+							arch:
+								- !s390x
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/a": {Kind: "copy", Arch: []string{"amd64", "arm64"}},
+			},
+		}},
+	},
+}, {
+	summary: "Arch lists must match some architecture of the release",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/a: {arch: [any-arm, "!arm64", "!armhf"]}
+		`,
+	},
+	relerror: `slice mypkg_myslice has 'arch' for path /a matching no architecture of the release`,
+}, {
+	summary: "Arch exclusions are checked for validity",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/a: {arch: "!foo"}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'arch' for path /a: "!foo"`,
+}, {
+	summary: "Release architectures are checked for validity",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "architectures: [amd64, foo]\n\tarchives:", 1),
+	},
+	relerror: `chisel.yaml: invalid architecture: "foo"`,
+}, {
+	summary: "Usrmerge conflicts are checked on the /usr counterparts",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "usrmerge: true\n\tarchives:", 1),
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/bin/foo:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/bin/foo:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /usr/bin/foo`,
+}, {
+	summary: "Usrmerge does not allow listing the same path twice",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "usrmerge: true\n\tarchives:", 1),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/bin/foo:
+						/usr/bin/foo:
+		`,
+	},
+	relerror: `slice mypkg_myslice lists /bin/foo and /usr/bin/foo, which are the same path with usrmerge`,
+}, {
+	summary: "Paths are kept as they are without usrmerge",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/bin/foo:
+						/usr/bin/foo:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/bin/foo":     {Kind: "copy"},
+				"/usr/bin/foo": {Kind: "copy"},
+			},
+		}},
+	},
+}, {
+	summary: "Concat fragments may be declared by several slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/etc/file: {generate: concat, text: "data1\n", priority: 10}
+				myslice2:
+					contents:
+						/etc/file: {generate: concat, text: "data2\n"}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/etc/file: {generate: concat, text: "data3\n", arch: amd64}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice1"}, {"mypkg2", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg2",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/etc/file": {Kind: "generate", Info: "data3\n", Generate: "concat", Arch: []string{"amd64"}},
+			},
+		}, {
+			Package: "mypkg",
+			Name:    "myslice1",
+			Contents: map[string]setup.PathInfo{
+				"/etc/file": {Kind: "generate", Info: "data1\n", Generate: "concat", Priority: 10},
+			},
+		}},
+	},
+}, {
+	summary: "Concat fragments must agree on the mode",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/etc/file: {generate: concat, text: data1}
+				myslice2:
+					contents:
+						/etc/file: {generate: concat, text: data2, mode: 0600}
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /etc/file`,
+}, {
+	summary: "Concat fragments conflict with other kinds of paths",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/etc/file: {generate: concat, text: data1}
+				myslice2:
+					contents:
+						/etc/file: {text: data1}
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /etc/file`,
+}, {
+	summary: "Concat fragments require text",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/file: {generate: concat}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/file has invalid generate options`,
+}, {
+	summary: "Concat fragments do not support other options",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/file: {generate: concat, text: data1, mutable: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/file has invalid generate options`,
+}, {
+	summary: "Concat paths must be files",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/**: {generate: concat, text: data1}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid generate path: /etc/\*\* is not a file path`,
+}, {
+	summary: "Priority requires generate: concat",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/file: {text: data1, priority: 10}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/file has 'priority' without 'generate: concat'`,
+}, {
+	summary: "Volatile files may be declared by several slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/etc/machine-id: {generate: machine-id}
+						/etc/hostname: {generate: hostname}
+				myslice2:
+					contents:
+						/etc/machine-id: {generate: machine-id}
+						/etc/resolv.conf: {generate: resolv-conf}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice1"}, {"mypkg", "myslice2"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice1",
+			Contents: map[string]setup.PathInfo{
+				"/etc/machine-id": {Kind: "generate", Generate: "machine-id"},
+				"/etc/hostname":   {Kind: "generate", Generate: "hostname"},
+			},
+		}, {
+			Package: "mypkg",
+			Name:    "myslice2",
+			Contents: map[string]setup.PathInfo{
+				"/etc/machine-id":  {Kind: "generate", Generate: "machine-id"},
+				"/etc/resolv.conf": {Kind: "generate", Generate: "resolv-conf"},
+			},
+		}},
+	},
+}, {
+	summary: "Volatile files are only generated at their own paths",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/machine/id: {generate: machine-id}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid generate path: machine-id is only generated at /etc/machine-id`,
+}, {
+	summary: "Volatile files do not support other options",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/hostname: {generate: hostname, mode: 0600}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/hostname has invalid generate options`,
+}, {
+	summary: "Volatile files conflict with other kinds of paths",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/etc/resolv.conf: {generate: resolv-conf}
+				myslice2:
+					contents:
+						/etc/resolv.conf: {text: "nameserver 127.0.0.53\n"}
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /etc/resolv.conf`,
+}, {
+	summary: "Paths may declare SELinux labels",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/app: {label: "system_u:object_r:bin_t:s0"}
+						/usr/lib/**: {label: "system_u:object_r:lib_t:s0:c0,c1023"}
+						/etc/file: {text: data1, label: "system_u:object_r:etc_t"}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/usr/bin/app": {Kind: "copy", Label: "system_u:object_r:bin_t:s0"},
+				"/usr/lib/**":  {Kind: "glob", Label: "system_u:object_r:lib_t:s0:c0,c1023"},
+				"/etc/file":    {Kind: "text", Info: "data1", Label: "system_u:object_r:etc_t"},
+			},
+		}},
+	},
+}, {
+	summary: "Labels must be SELinux security contexts",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/app: {label: "bin_t"}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'label' for path /usr/bin/app: "bin_t"`,
+}, {
+	summary: "Slices must agree on the label of a path",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/usr/bin/app: {label: "system_u:object_r:bin_t:s0"}
+				myslice2:
+					contents:
+						/usr/bin/app:
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /usr/bin/app`,
+}, {
+	summary: "Generated paths other than concat cannot have labels",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/var/lib/chisel/**: {generate: manifest, label: "system_u:object_r:var_lib_t:s0"}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /var/lib/chisel/\*\* has invalid generate options`,
+}, {
+	summary: "Slices may declare library paths and environment variables",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					library-paths:
+						- /usr/lib/mypkg
+					environment:
+						MYPKG_HOME: /usr/share/mypkg
+				myslice2:
+					environment:
+						MYPKG_HOME: /usr/share/mypkg
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package:      "mypkg",
+			Name:         "myslice1",
+			LibraryPaths: []string{"/usr/lib/mypkg"},
+			Environment:  map[string]string{"MYPKG_HOME": "/usr/share/mypkg"},
+		}},
+	},
+}, {
+	summary: "Library paths must be absolute and clean",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					library-paths: [/usr/lib/../lib]
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid library path: "/usr/lib/../lib"`,
+}, {
+	summary: "Library paths cannot be repeated",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					library-paths: [/usr/lib/mypkg, /usr/lib/mypkg]
+		`,
+	},
+	relerror: `slice mypkg_myslice repeats library path /usr/lib/mypkg`,
+}, {
+	summary: "Invalid environment variable name",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					environment:
+						1FOO: bar
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid environment variable name: "1FOO"`,
+}, {
+	summary: "Invalid environment variable value",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					environment:
+						FOO: 'b"ar'
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid value for environment variable FOO: "b\\"ar"`,
+}, {
+	summary: "Environment variables cannot have different values across slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					environment:
+						FOO: bar
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					environment:
+						FOO: baz
+		`,
+	},
+	relerror: `slices mypkg2_myslice and mypkg_myslice conflict on environment variable FOO`,
+}, {
+	summary: "Generated environment conflicts with contents",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					environment:
+						FOO: bar
+				myslice2:
+					contents:
+						/etc/environment: {text: "FOO=bar"}
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /etc/environment`,
+}, {
+	summary: "Generated library paths conflict with globs",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					library-paths: [/usr/lib/mypkg]
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/etc/ld.so.conf.d/*:
+		`,
+	},
+	relerror: `slices mypkg2_myslice and mypkg_myslice conflict on /etc/ld.so.conf.d/\* and /etc/ld.so.conf.d/mypkg.conf`,
+}, {
+	summary: "chisel-v1 is deprecated",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: chisel-v1
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					v1-public-keys: [test-key]
+			v1-public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+	},
+	relerror: `chisel.yaml: unknown format "chisel-v1"`,
+}, {
+	summary: "Default archive compatibility",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				default:
+					default: true
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+				other-1:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+				other-2:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"default": {
+				Name:       "default",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				Priority:   1,
+			},
+			"other-1": {
+				Name:       "other-1",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				Priority:   -2,
+			},
+			"other-2": {
+				Name:       "other-2",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				Priority:   -3,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Pro values in archives",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard:    2025-01-01
+				expanded:    2100-01-01
 				legacy:      2100-01-01
 				end-of-life: 2100-01-01
 			archives:
@@ -2002,7 +3217,326 @@ var setupTests = []setupTest{{
 			Legacy:    time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
 			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
 		},
+		Warnings: []string{`Archive "ignored" ignored: invalid pro value: "unknown-value"`},
+	},
+}, {
+	summary: "Archive verification policy",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					verify:
+						min-digest: sha512
+						require-by-hash: true
+						reject-md5-only: true
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				Verify: archive.VerifyOptions{
+					MinDigest:     archive.DigestSHA512,
+					RequireByHash: true,
+					RejectMD5Only: true,
+				},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Invalid archive verification digest",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					verify:
+						min-digest: md5
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid digest algorithm: "md5"`,
+}, {
+	summary: "Archive HTTP configuration",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					http:
+						timeout: 1m
+						bulk-timeout: 1h30m
+						headers:
+							X-CDN-Token: secret
+						user-agent: my-agent/1.0
+						chunk-threshold: 64MiB
+						chunk-size: 4MiB
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				HTTP: archive.HTTPOptions{
+					Timeout:        time.Minute,
+					BulkTimeout:    90 * time.Minute,
+					Headers:        map[string]string{"X-CDN-Token": "secret"},
+					UserAgent:      "my-agent/1.0",
+					ChunkThreshold: 64 << 20,
+					ChunkSize:      4 << 20,
+				},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Invalid archive HTTP timeout",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					http:
+						timeout: soon
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid timeout: "soon"`,
+}, {
+	summary: "Invalid archive HTTP chunk size",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					http:
+						chunk-size: 4MB
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid chunk size: "4MB"`,
+}, {
+	summary: "Invalid archive HTTP header",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					http:
+						headers:
+							"X Token": secret
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid header name: "X Token"`,
+}, {
+	summary: "Archive mirrors",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					mirrors: [auto, "http://mirror.example.com/ubuntu/"]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+				Mirrors:    []string{"auto", "http://mirror.example.com/ubuntu/"},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Invalid archive mirror",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					mirrors: [mirror.example.com]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid mirror: "mirror.example.com"`,
+}, {
+	summary: "Pro archives cannot have mirrors",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+					pro: fips
+					mirrors: ["http://mirror.example.com/ubuntu/"]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
 	},
+	relerror: `chisel.yaml: archive "ubuntu" has mirrors but is a pro archive`,
 }, {
 	summary: "Default is ignored",
 	input: map[string]string{
@@ -3519,35 +5053,380 @@ var setupTests = []setupTest{{
 		},
 	},
 }, {
-	summary: "'essential' and 'v3-essential' cannot intersect",
+	summary: "Conditional essentials",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- slice: mypkg_myslice3
+				  when: arch == i386
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2
+						- {slice: mypkg_myslice4, when: "arch in [amd64, arm64]"}
+				myslice2:
+				myslice3:
+				myslice4:
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Essential: map[setup.SliceKey]setup.EssentialInfo{
+							{"mypkg", "myslice2"}: {Arch: nil},
+							{"mypkg", "myslice3"}: {Arch: []string{"i386"}},
+							{"mypkg", "myslice4"}: {Arch: []string{"amd64", "arm64"}},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Essential: map[setup.SliceKey]setup.EssentialInfo{
+							{"mypkg", "myslice3"}: {Arch: []string{"i386"}},
+						},
+					},
+					"myslice3": {
+						Package: "mypkg",
+						Name:    "myslice3",
+					},
+					"myslice4": {
+						Package: "mypkg",
+						Name:    "myslice4",
+						Essential: map[setup.SliceKey]setup.EssentialInfo{
+							{"mypkg", "myslice3"}: {Arch: []string{"i386"}},
+						},
+					},
+				},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Conditional essential with unsupported condition",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- slice: mypkg_myslice2
+						  when: release == 22.04
+				myslice2:
+		`,
+	},
+	relerror: `slice mypkg_myslice1 has invalid essential mypkg_myslice2: unsupported condition "release == 22.04"`,
+}, {
+	summary: "Conditional essential with invalid arch",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- slice: mypkg_myslice2
+				  when: arch in [amd64, foo]
+			slices:
+				myslice1:
+				myslice2:
+		`,
+	},
+	relerror: `package "mypkg" has invalid essential mypkg_myslice2: invalid architecture "foo" in condition "arch in \[amd64, foo\]"`,
+}, {
+	summary: "Essential with version constraint",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2 (>= 1.2-3)
+						- slice: mypkg_myslice3 (<< 2)
+						  when: arch == amd64
+					v3-essential:
+						mypkg_myslice4: {version: "= 1.0"}
+				myslice2:
+				myslice3:
+				myslice4:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice2",
+		}, {
+			Package: "mypkg",
+			Name:    "myslice4",
+		}, {
+			Package: "mypkg",
+			Name:    "myslice1",
+			Essential: map[setup.SliceKey]setup.EssentialInfo{
+				{"mypkg", "myslice2"}: {Op: ">=", Version: "1.2-3"},
+				{"mypkg", "myslice3"}: {Arch: []string{"amd64"}, Op: "<<", Version: "2"},
+				{"mypkg", "myslice4"}: {Op: "=", Version: "1.0"},
+			},
+		}},
+	},
+}, {
+	summary: "Essential with invalid version constraint",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2 (~ 1.0)
+				myslice2:
+		`,
+	},
+	relerror: `slice mypkg_myslice1 has invalid essential mypkg_myslice2: invalid version constraint "~ 1.0"`,
+}, {
+	summary: "Conditional essential requires a slice",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- when: arch == amd64
+		`,
+	},
+	relerror: `cannot parse package "mypkg" slice definitions: line 5: essential entry is missing 'slice'`,
+}, {
+	summary: "Deprecated slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					deprecated: use mypkg_myslice2 instead
+				myslice2:
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package:    "mypkg",
+						Name:       "myslice1",
+						Deprecated: "use mypkg_myslice2 instead",
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+					},
+				},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "Slice metadata",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					summary: The main binaries
+					notes: |
+						Requires the configuration
+						from mypkg_config.
+					owners: [alice@example.com, bob@example.com]
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Summary: "The main binaries",
+						Notes:   "Requires the configuration\nfrom mypkg_config.\n",
+						Owners:  []string{"alice@example.com", "bob@example.com"},
+					},
+				},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "'essential' and 'v3-essential' cannot intersect",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2
+					v3-essential:
+						mypkg_myslice2: {arch: [amd64, i386]}
+				myslice2:
+		`,
+	},
+	relerror: `slice mypkg_myslice1 repeats mypkg_myslice2 in essential fields`,
+}, {
+	summary: "'essential' and 'v3-essential' cannot intersect at pkg level",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- mypkg_myslice2
+			v3-essential:
+				mypkg_myslice2: {arch: [amd64, i386]}
+			slices:
+				myslice1:
+				myslice2:
+		`,
+	},
+	relerror: `package "mypkg" repeats mypkg_myslice2 in essential fields`,
+}, {
+	summary: "External package source",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			source:
+				url: https://example.com/mypkg1_1.0_amd64.deb
+				sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			source:
+				local: debs/mypkg2_1.0_all.deb
+				sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				Maintained: true,
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg1": {
+				Name: "mypkg1",
+				Path: "slices/mydir/mypkg1.yaml",
+				Source: &setup.PackageSource{
+					URL:    "https://example.com/mypkg1_1.0_amd64.deb",
+					SHA256: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				},
+				Slices: map[string]*setup.Slice{},
+			},
+			"mypkg2": {
+				Name: "mypkg2",
+				Path: "slices/mydir/mypkg2.yaml",
+				Source: &setup.PackageSource{
+					Local:  "debs/mypkg2_1.0_all.deb",
+					SHA256: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				},
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+		Maintenance: &setup.Maintenance{
+			Standard:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndOfLife: time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	summary: "External package source requires a single location",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
-			slices:
-				myslice1:
-					essential:
-						- mypkg_myslice2
-					v3-essential:
-						mypkg_myslice2: {arch: [amd64, i386]}
-				myslice2:
+			source:
+				url: https://example.com/mypkg_1.0_amd64.deb
+				local: debs/mypkg_1.0_amd64.deb
+				sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
 		`,
 	},
-	relerror: `slice mypkg_myslice1 repeats mypkg_myslice2 in essential fields`,
+	relerror: `slices/mydir/mypkg.yaml: invalid source: exactly one of 'local' or 'url' must be set`,
 }, {
-	summary: "'essential' and 'v3-essential' cannot intersect at pkg level",
+	summary: "External package source requires a valid digest",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
-			essential:
-				- mypkg_myslice2
-			v3-essential:
-				mypkg_myslice2: {arch: [amd64, i386]}
-			slices:
-				myslice1:
-				myslice2:
+			source:
+				url: https://example.com/mypkg_1.0_amd64.deb
+				sha256: foo
 		`,
 	},
-	relerror: `package "mypkg" repeats mypkg_myslice2 in essential fields`,
+	relerror: `slices/mydir/mypkg.yaml: invalid source: 'sha256' must be a lowercase hex-encoded digest`,
+}, {
+	summary: "External package local source must be within the release",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			source:
+				local: ../mypkg_1.0_amd64.deb
+				sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+		`,
+	},
+	relerror: `slices/mydir/mypkg.yaml: invalid source: 'local' must be a clean path within the release: "../mypkg_1.0_amd64.deb"`,
+}, {
+	summary: "External package cannot be pinned to an archive",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			archive: ubuntu
+			source:
+				url: https://example.com/mypkg_1.0_amd64.deb
+				sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+		`,
+	},
+	relerror: `slices/mydir/mypkg.yaml: package cannot have both 'archive' and 'source'`,
 }}
 
 func (s *S) TestParseRelease(c *C) {
@@ -3640,14 +5519,180 @@ func runParseReleaseTests(c *C, tests []setupTest) {
 				prefers[path] = pkg.Name
 			}
 			c.Assert(prefers, DeepEquals, test.prefers)
-			selection.Release = nil
 			if test.selection != nil {
-				c.Assert(selection, DeepEquals, test.selection)
+				c.Assert(selection.Slices, DeepEquals, test.selection.Slices)
 			}
 		}
 	}
 }
 
+func (s *S) TestReadCachedRelease(c *C) {
+	for _, test := range setupTests {
+		if test.relerror != "" {
+			continue
+		}
+		c.Logf("Summary: %s", test.summary)
+
+		if _, ok := test.input["chisel.yaml"]; !ok {
+			test.input["chisel.yaml"] = string(testutil.DefaultChiselYaml)
+		}
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		release, err := setup.ReadRelease(dir)
+		c.Assert(err, IsNil)
+
+		cacheDir := c.MkDir()
+		for i := range 2 {
+			cached, err := setup.ReadCachedRelease(dir, cacheDir)
+			c.Assert(err, IsNil)
+			c.Assert(cached, DeepEquals, release, Commentf("read %d", i))
+			records, err := filepath.Glob(filepath.Join(cacheDir, "parsed", "*"))
+			c.Assert(err, IsNil)
+			c.Assert(records, HasLen, 1)
+		}
+	}
+}
+
+func (s *S) TestReadCachedReleaseChanged(c *C) {
+	dir := c.MkDir()
+	cacheDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+	pkgPath := filepath.Join(dir, "slices", "mypkg.yaml")
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n"), 0644)
+	c.Assert(err, IsNil)
+
+	release, err := setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 1)
+	// Changes to the cached release do not leak into later reads.
+	release.Packages["mypkg"].Slices["bins"].Contents["/usr/bin/other"] = setup.PathInfo{Kind: setup.CopyPath}
+
+	// The release is read again once its content changes.
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n      /usr/bin/tool:\n"), 0644)
+	c.Assert(err, IsNil)
+	release, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 2)
+
+	// Errors are not cached.
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices: ["), 0644)
+	c.Assert(err, IsNil)
+	_, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, ErrorMatches, `cannot parse package "mypkg" slice definitions: .*`)
+
+	// Broken records are discarded.
+	records, err := filepath.Glob(filepath.Join(cacheDir, "parsed", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 2)
+	for _, record := range records {
+		err = os.WriteFile(record, []byte("broken"), 0644)
+		c.Assert(err, IsNil)
+	}
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n"), 0644)
+	c.Assert(err, IsNil)
+	release, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 1)
+	release, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 1)
+}
+
+func (s *S) TestReadCachedReleaseSymlink(c *C) {
+	dir := c.MkDir()
+	cacheDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+	pkgPath := filepath.Join(c.MkDir(), "mypkg.yaml")
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n"), 0644)
+	c.Assert(err, IsNil)
+	err = os.Symlink(pkgPath, filepath.Join(dir, "slices", "mypkg.yaml"))
+	c.Assert(err, IsNil)
+
+	release, err := setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 1)
+
+	// Changes to the file the symlink points to are noticed.
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n      /usr/bin/tool:\n"), 0644)
+	c.Assert(err, IsNil)
+	release, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["bins"].Contents, HasLen, 2)
+}
+
+func (s *S) TestReadCachedReleaseWarnings(c *C) {
+	dir := c.MkDir()
+	cacheDir := c.MkDir()
+	chiselYaml := strings.Replace(testutil.DefaultChiselYaml, "\t\t\tsuites: [jammy]\n",
+		"\t\t\tsuites: [jammy]\n\t\t\tpro: unknown-value\n", 1)
+	c.Assert(chiselYaml, Not(Equals), testutil.DefaultChiselYaml)
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(chiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+
+	warning := `Archive "ubuntu" ignored: invalid pro value: "unknown-value"`
+	for i := range 2 {
+		release, err := setup.ReadCachedRelease(dir, cacheDir)
+		c.Assert(err, IsNil)
+		c.Assert(release.Warnings, DeepEquals, []string{warning})
+		c.Assert(strings.Count(c.GetTestLog(), warning), Equals, i+1)
+	}
+}
+
+func (s *S) TestReadCachedReleaseExpire(c *C) {
+	dir := c.MkDir()
+	cacheDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+	pkgPath := filepath.Join(dir, "slices", "mypkg.yaml")
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	records, err := filepath.Glob(filepath.Join(cacheDir, "parsed", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	oldRecord := records[0]
+
+	// Using a record refreshes its modification time.
+	old := time.Now().Add(-365 * 24 * time.Hour)
+	err = os.Chtimes(oldRecord, old, old)
+	c.Assert(err, IsNil)
+	_, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	info, err := os.Stat(oldRecord)
+	c.Assert(err, IsNil)
+	c.Assert(info.ModTime().After(old), Equals, true)
+
+	// Records not used for long are removed once another one is stored.
+	err = os.Chtimes(oldRecord, old, old)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(pkgPath, []byte("package: mypkg\nslices:\n  bins:\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = setup.ReadCachedRelease(dir, cacheDir)
+	c.Assert(err, IsNil)
+	records, err = filepath.Glob(filepath.Join(cacheDir, "parsed", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0], Not(Equals), oldRecord)
+}
+
 func (s *S) TestPackageMarshalYAML(c *C) {
 	for _, test := range setupTests {
 		c.Logf("Summary: %s", test.summary)
@@ -3715,6 +5760,7 @@ func (s *S) TestPackageYAMLFormat(c *C) {
 							/dir/copy: {copy: /dir/file}
 							/dir/empty-file: {text: ""}
 							/dir/glob*: {}
+							/dir/layered: {mutable: true, overwrite: merge}
 							/dir/manifest/**: {generate: manifest}
 							/dir/mutable: {text: TODO, mutable: true, arch: riscv64}
 							/dir/other-file: {}
@@ -3769,6 +5815,20 @@ func (s *S) TestPackageYAMLFormat(c *C) {
 							/dir/file3: {}
 			`,
 		},
+	}, {
+		summary: "External source",
+		input: map[string]string{
+			"slices/mypkg.yaml": `
+				package: mypkg
+				source:
+					url: https://example.com/mypkg_1.0_amd64.deb
+					sha256: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+				slices:
+					myslice:
+						contents:
+							/dir/file: {}
+			`,
+		},
 	}, {
 		summary: "Path with prefer",
 		input: map[string]string{
@@ -3850,3 +5910,671 @@ func (s *S) TestYAMLPathGenerate(c *C) {
 		c.Assert(result, Equals, test.result)
 	}
 }
+
+// matchRelease is used to test the functions matching slices in a release.
+var matchRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml) + "\tgroups:\n\t\truntime-common: [mypkg_libs, otherpkg_doc]\n",
+	"slices/mydir/mypkg.yaml": `
+		package: mypkg
+		essential:
+			- mypkg_config
+		slices:
+			bins:
+				essential:
+					- mypkg_libs
+			libs:
+			config:
+			doc:
+				contents:
+					/usr/share/doc/mypkg/copyright:
+					/usr/share/doc/mypkg/**/*.html:
+	`,
+	"slices/mydir/otherpkg.yaml": `
+		package: otherpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_libs
+			doc:
+			old-doc:
+				deprecated: use otherpkg_doc instead
+	`,
+}
+
+var excludeTests = []struct {
+	summary  string
+	slices   []setup.SliceKey
+	patterns []string
+	force    bool
+	result   []string
+	// contents holds the paths left in the slices with contents.
+	contents map[string][]string
+	err      string
+}{{
+	summary:  "Exclude slices by name",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"mypkg", "doc"}},
+	patterns: []string{"mypkg_doc"},
+	result:   []string{"mypkg_config", "mypkg_libs", "mypkg_bins"},
+}, {
+	summary:  "Exclude slices by pattern",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"mypkg", "doc"}, {"otherpkg", "doc"}},
+	patterns: []string{"*_doc"},
+	result:   []string{"mypkg_config", "mypkg_libs", "mypkg_bins"},
+}, {
+	summary:  "Patterns need not match",
+	slices:   []setup.SliceKey{{"mypkg", "libs"}},
+	patterns: []string{"*_doc"},
+	result:   []string{"mypkg_config", "mypkg_libs"},
+}, {
+	summary:  "Essential slices cannot be excluded",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"otherpkg", "bins"}},
+	patterns: []string{"mypkg_libs"},
+	err:      "cannot exclude slice mypkg_libs: essential to slice mypkg_bins",
+}, {
+	summary:  "Package essentials cannot be excluded",
+	slices:   []setup.SliceKey{{"mypkg", "libs"}},
+	patterns: []string{"mypkg_config"},
+	err:      "cannot exclude slice mypkg_config: essential to slice mypkg_libs",
+}, {
+	summary:  "Essential slices may be excluded by force",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"otherpkg", "bins"}},
+	patterns: []string{"mypkg_libs", "mypkg_config"},
+	force:    true,
+	result:   []string{"mypkg_bins", "otherpkg_bins"},
+}, {
+	summary:  "Slices which are essential only to excluded ones may be excluded",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"otherpkg", "doc"}},
+	patterns: []string{"mypkg_bins", "mypkg_libs"},
+	result:   []string{"otherpkg_doc"},
+}, {
+	summary:  "Slices only essential to excluded ones are dropped",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"otherpkg", "doc"}},
+	patterns: []string{"mypkg_bins"},
+	result:   []string{"otherpkg_doc"},
+}, {
+	summary:  "Requested slices are kept along with their essentials",
+	slices:   []setup.SliceKey{{"mypkg", "bins"}, {"mypkg", "libs"}},
+	patterns: []string{"mypkg_bins"},
+	result:   []string{"mypkg_config", "mypkg_libs"},
+}, {
+	summary:  "Exclude paths",
+	slices:   []setup.SliceKey{{"mypkg", "doc"}},
+	patterns: []string{"/usr/share/doc/*/copyright"},
+	result:   []string{"mypkg_config", "mypkg_doc"},
+	contents: map[string][]string{
+		"mypkg_doc": {"/usr/share/doc/mypkg/**/*.html"},
+	},
+}, {
+	summary:  "Globs partly matching excluded paths are kept",
+	slices:   []setup.SliceKey{{"mypkg", "doc"}},
+	patterns: []string{"/usr/share/doc/mypkg/api/*"},
+	result:   []string{"mypkg_config", "mypkg_doc"},
+	contents: map[string][]string{
+		"mypkg_doc": {"/usr/share/doc/mypkg/**/*.html", "/usr/share/doc/mypkg/copyright"},
+	},
+}, {
+	summary:  "Globs equal to excluded paths are dropped",
+	slices:   []setup.SliceKey{{"mypkg", "doc"}},
+	patterns: []string{"/usr/share/doc/mypkg/**/*.html", "/etc/missing"},
+	result:   []string{"mypkg_config", "mypkg_doc"},
+	contents: map[string][]string{
+		"mypkg_doc": {"/usr/share/doc/mypkg/copyright"},
+	},
+}, {
+	summary:  "Invalid path pattern",
+	slices:   []setup.SliceKey{{"mypkg", "doc"}},
+	patterns: []string{"/usr/share/../doc"},
+	err:      `invalid path pattern: "/usr/share/../doc"`,
+}, {
+	summary:  "Invalid pattern",
+	slices:   []setup.SliceKey{{"mypkg", "libs"}},
+	patterns: []string{"mypkg_[doc"},
+	err:      `invalid slice pattern: "mypkg_\[doc"`,
+}}
+
+func readMatchRelease(c *C) *setup.Release {
+	dir := c.MkDir()
+	for path, data := range matchRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+	return release
+}
+
+func (s *S) TestReleaseGroups(c *C) {
+	release := readMatchRelease(c)
+	c.Assert(release.Groups, DeepEquals, map[string][]setup.SliceKey{
+		"runtime-common": {{"mypkg", "libs"}, {"otherpkg", "doc"}},
+	})
+	c.Assert(setup.IsGroupName("runtime-common"), Equals, true)
+	c.Assert(setup.IsGroupName("mypkg_libs"), Equals, false)
+}
+
+func (s *S) TestSelectionExclude(c *C) {
+	release := readMatchRelease(c)
+	for _, test := range excludeTests {
+		c.Logf("Summary: %s", test.summary)
+
+		selection, err := setup.Select(release, test.slices, "amd64")
+		c.Assert(err, IsNil)
+		err = selection.Exclude(test.patterns, "amd64", test.force)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		var result []string
+		for _, slice := range selection.Slices {
+			result = append(result, slice.String())
+		}
+		c.Assert(result, DeepEquals, test.result)
+
+		var excludedPaths []string
+		for _, pattern := range test.patterns {
+			if strings.HasPrefix(pattern, "/") {
+				excludedPaths = append(excludedPaths, pattern)
+			}
+		}
+		c.Assert(selection.ExcludedPaths, DeepEquals, excludedPaths)
+		contents := make(map[string][]string)
+		for _, slice := range selection.Slices {
+			if len(slice.Contents) == 0 {
+				continue
+			}
+			paths := slices.Sorted(maps.Keys(slice.Contents))
+			contents[slice.String()] = paths
+		}
+		if test.contents == nil {
+			continue
+		}
+		c.Assert(contents, DeepEquals, test.contents)
+	}
+
+	// The slices of the release are left unchanged.
+	c.Assert(release.Packages["mypkg"].Slices["doc"].Contents, HasLen, 2)
+}
+
+var matchSlicesTests = []struct {
+	pattern string
+	result  []string
+	err     string
+}{{
+	pattern: "mypkg_*",
+	result:  []string{"mypkg_bins", "mypkg_config", "mypkg_doc", "mypkg_libs"},
+}, {
+	pattern: "*_bins",
+	result:  []string{"mypkg_bins", "otherpkg_bins"},
+}, {
+	pattern: "*pkg_?oc",
+	result:  []string{"mypkg_doc", "otherpkg_doc"},
+}, {
+	pattern: "other*_[bd]*",
+	result:  []string{"otherpkg_bins", "otherpkg_doc"},
+}, {
+	pattern: "nopkg_*",
+	err:     `no slices match "nopkg_\*"`,
+}, {
+	pattern: "mypkg_[doc",
+	err:     `invalid slice pattern: "mypkg_\[doc"`,
+}}
+
+func (s *S) TestMatchSlices(c *C) {
+	release := readMatchRelease(c)
+	for _, test := range matchSlicesTests {
+		c.Logf("Pattern: %s", test.pattern)
+		c.Assert(setup.IsSlicePattern(test.pattern), Equals, true)
+
+		keys, err := setup.MatchSlices(release, test.pattern)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		var result []string
+		for _, key := range keys {
+			result = append(result, key.String())
+		}
+		c.Assert(result, DeepEquals, test.result)
+	}
+	c.Assert(setup.IsSlicePattern("mypkg_bins"), Equals, false)
+}
+
+var checkPackageRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/mydir/mypkg.yaml": `
+		package: mypkg
+		slices:
+			libs:
+				contents:
+					/usr/lib/libmy.so:
+	`,
+	"slices/otherpkg.yaml": `
+		package: otherpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_libs
+				contents:
+					/usr/bin/other:
+	`,
+	"slices/unrelated.yaml": `
+		package: unrelated
+		slices:
+			bins:
+				contents:
+					/usr/bin/tool:
+	`,
+}
+
+var checkPackageTests = []struct {
+	summary string
+	name    string
+	data    string
+	full    bool
+	err     string
+}{{
+	summary: "Essentials are read from the release",
+	name:    "newpkg.yaml",
+	data: `
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- otherpkg_bins
+				contents:
+					/usr/bin/new:
+	`,
+}, {
+	summary: "Missing essential",
+	name:    "newpkg.yaml",
+	data: `
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- mypkg_bins
+	`,
+	err: `newpkg_bins requires mypkg_bins, but slice is missing`,
+}, {
+	summary: "Conflict with a package reached through essentials",
+	name:    "newpkg.yaml",
+	data: `
+		package: newpkg
+		slices:
+			bins:
+				essential:
+					- otherpkg_bins
+				contents:
+					/usr/lib/libmy.so:
+	`,
+	err: `slices mypkg_libs and newpkg_bins conflict on /usr/lib/libmy.so`,
+}, {
+	summary: "Conflict with an unrelated package is not detected",
+	name:    "newpkg.yaml",
+	data: `
+		package: newpkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/tool:
+	`,
+}, {
+	summary: "Conflict with an unrelated package is detected in full",
+	name:    "newpkg.yaml",
+	data: `
+		package: newpkg
+		slices:
+			bins:
+				contents:
+					/usr/bin/tool:
+	`,
+	full: true,
+	err:  `slices newpkg_bins and unrelated_bins conflict on /usr/bin/tool`,
+}, {
+	summary: "Package replaces its definitions in the release",
+	name:    "mypkg.yaml",
+	data: `
+		package: mypkg
+		slices:
+			libs:
+				contents:
+					/usr/lib/libmy.so.1:
+	`,
+	full: true,
+}, {
+	summary: "Package dropping a slice required by others",
+	name:    "mypkg.yaml",
+	data: `
+		package: mypkg
+		slices:
+			bins:
+	`,
+	full: true,
+	err:  `otherpkg_bins requires mypkg_libs, but slice is missing`,
+}, {
+	summary: "Filename and package disagree",
+	name:    "newpkg.yaml",
+	data: `
+		package: otherpkg
+	`,
+	err: `.*newpkg.yaml: filename and 'package' field \("otherpkg"\) disagree`,
+}, {
+	summary: "Invalid filename",
+	name:    "newpkg.yml",
+	data: `
+		package: newpkg
+	`,
+	err: `invalid slice definition filename: "newpkg.yml"`,
+}}
+
+func (s *S) TestCheckPackage(c *C) {
+	releaseDir := c.MkDir()
+	for path, data := range checkPackageRelease {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	cacheDir := c.MkDir()
+	for _, test := range checkPackageTests {
+		c.Logf("Summary: %s", test.summary)
+
+		pkgPath := filepath.Join(c.MkDir(), test.name)
+		err := os.WriteFile(pkgPath, testutil.Reindent(test.data), 0644)
+		c.Assert(err, IsNil)
+
+		pkg, err := setup.CheckPackage(releaseDir, pkgPath, test.full)
+		cachedPkg, cachedErr := setup.CheckCachedPackage(releaseDir, cacheDir, pkgPath, test.full)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			c.Assert(cachedErr, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(pkg.Path, Equals, pkgPath)
+		c.Assert(cachedErr, IsNil)
+		c.Assert(cachedPkg.Path, Equals, pkgPath)
+	}
+	records, err := filepath.Glob(filepath.Join(cacheDir, "parsed", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+}
+
+func (s *S) TestReleaseSchema(c *C) {
+	data, err := setup.ReleaseSchema()
+	c.Assert(err, IsNil)
+	var schema map[string]any
+	err = json.Unmarshal(data, &schema)
+	c.Assert(err, IsNil)
+	c.Assert(schema["$schema"], Equals, "https://json-schema.org/draft/2020-12/schema")
+	c.Assert(schema["required"], DeepEquals, []any{"format", "archives"})
+	archive := schema["properties"].(map[string]any)["archives"].(map[string]any)["additionalProperties"]
+	c.Assert(archive, DeepEquals, map[string]any{"anyOf": []any{map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"version":     map[string]any{"type": "string"},
+			"suites":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"components":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"priority":    map[string]any{"type": "integer"},
+			"pro":         map[string]any{"type": "string"},
+			"default":     map[string]any{"type": "boolean"},
+			"public-keys": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"verify": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"min-digest":      map[string]any{"type": "string"},
+					"require-by-hash": map[string]any{"type": "boolean"},
+					"reject-md5-only": map[string]any{"type": "boolean"},
+				},
+			},
+			"http": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"timeout":         map[string]any{"type": "string"},
+					"bulk-timeout":    map[string]any{"type": "string"},
+					"headers":         map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+					"user-agent":      map[string]any{"type": "string"},
+					"chunk-threshold": map[string]any{"type": "string"},
+					"chunk-size":      map[string]any{"type": "string"},
+				},
+			},
+			"mirrors": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required": []any{"version", "suites", "components", "public-keys"},
+	}, map[string]any{"type": "null"}}})
+}
+
+func (s *S) TestPackageSchema(c *C) {
+	data, err := setup.PackageSchema()
+	c.Assert(err, IsNil)
+	var schema map[string]any
+	err = json.Unmarshal(data, &schema)
+	c.Assert(err, IsNil)
+	c.Assert(schema["required"], DeepEquals, []any{"package"})
+	slices := schema["properties"].(map[string]any)["slices"].(map[string]any)
+	slice := slices["additionalProperties"].(map[string]any)["anyOf"].([]any)[0].(map[string]any)
+	c.Assert(slice["properties"].(map[string]any)["essential"], DeepEquals, map[string]any{
+		"type": "array",
+		"items": map[string]any{"anyOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"slice": map[string]any{"type": "string"},
+					"when":  map[string]any{"type": "string"},
+				},
+				"required": []any{"slice"},
+			},
+		}},
+	})
+	contents := slice["properties"].(map[string]any)["contents"].(map[string]any)
+	path := contents["additionalProperties"].(map[string]any)["anyOf"].([]any)[0].(map[string]any)
+	properties := path["properties"].(map[string]any)
+	c.Assert(properties["until"], DeepEquals, map[string]any{"enum": []any{"mutate"}})
+	c.Assert(properties["generate"], DeepEquals, map[string]any{"anyOf": []any{
+		map[string]any{"enum": []any{"manifest", "ca-certificates", "concat", "machine-id", "hostname", "resolv-conf"}},
+		map[string]any{"type": "string", "pattern": "^x-[a-z0-9]+(-[a-z0-9]+)*$"},
+	}})
+	c.Assert(properties["arch"], DeepEquals, map[string]any{"anyOf": []any{
+		map[string]any{"type": "string"},
+		map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	}})
+}
+
+func (s *S) TestReleaseErrorKinds(c *C) {
+	var parseErr *setup.ParseError
+	var validationErr *setup.ValidationError
+
+	_, err := setup.ReadRelease(c.MkDir())
+	c.Assert(err, ErrorMatches, "cannot read release definition: .*")
+	c.Assert(errors.As(err, &parseErr), Equals, true)
+
+	release := readMatchRelease(c)
+	_, err = setup.Select(release, []setup.SliceKey{{"mypkg", "none"}}, "amd64")
+	c.Assert(err, ErrorMatches, `slice mypkg_none not found`)
+	c.Assert(errors.As(err, &validationErr), Equals, true)
+
+	dir := c.MkDir()
+	err = os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	c.Assert(err, IsNil)
+	for _, pkg := range []string{"mypkg", "otherpkg"} {
+		data := "package: " + pkg + "\nslices:\n  bins:\n    contents:\n      /usr/bin/app:\n"
+		err = os.WriteFile(filepath.Join(dir, "slices", pkg+".yaml"), []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	_, err = setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `slices mypkg_bins and otherpkg_bins conflict on /usr/bin/app`)
+	c.Assert(errors.As(err, &validationErr), Equals, true)
+	c.Assert(errors.As(err, &parseErr), Equals, false)
+}
+
+func (s *S) TestReadReleaseParallel(c *C) {
+	restore := setup.FakeParallelism(4)
+	defer restore()
+
+	dir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(testutil.DefaultChiselYaml), 0644)
+	c.Assert(err, IsNil)
+	err = os.MkdirAll(filepath.Join(dir, "slices", "sub"), 0755)
+	c.Assert(err, IsNil)
+	writePkg := func(name, data string) {
+		err := os.WriteFile(filepath.Join(dir, "slices", name+".yaml"), []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	for i := range 200 {
+		pkg := fmt.Sprintf("pkg%03d", i)
+		data := fmt.Sprintf("package: %s\nslices:\n  bins:\n    contents:\n      /usr/bin/%s:\n      /usr/share/%s/**:\n", pkg, pkg, pkg)
+		if i%2 == 1 {
+			pkg = "sub/" + pkg
+		}
+		writePkg(pkg, data)
+	}
+
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages, HasLen, 200)
+	c.Assert(release.Packages["pkg123"].Path, Equals, "slices/sub/pkg123.yaml")
+	c.Assert(release.Packages["pkg123"].Slices["bins"].Contents, HasLen, 2)
+
+	// The error of the first file failing, in the order the directories
+	// are read, is reported regardless of which is parsed first.
+	writePkg("pkg050", "package: pkg050\nslices: [")
+	writePkg("sub/pkg011", "package: pkg011\nslices: [")
+	writePkg("pkg198", "package: pkg198\nslices: [")
+	for range 10 {
+		_, err = setup.ReadRelease(dir)
+		c.Assert(err, ErrorMatches, `cannot parse package "pkg050" slice definitions: .*`)
+	}
+
+	// Conflicts are found across the shards of paths.
+	writePkg("pkg050", "package: pkg050\nslices:\n  bins:\n    contents:\n      /usr/bin/pkg050:\n")
+	writePkg("sub/pkg011", "package: pkg011\nslices:\n  bins:\n    contents:\n      /usr/share/pkg150/doc:\n")
+	writePkg("pkg198", "package: pkg198\nslices:\n  bins:\n    contents:\n      /usr/bin/pkg198:\n")
+	_, err = setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `slices pkg011_bins and pkg150_bins conflict on /usr/share/pkg150/doc and /usr/share/pkg150/\*\*`)
+}
+
+var pathIndexPaths = []string{
+	"/",
+	"/etc/",
+	"/etc/foo.conf",
+	"/etc/foo.d/**",
+	"/usr/",
+	"/usr/bin/app",
+	"/usr/bin/app-*",
+	"/usr/lib/",
+	"/usr/lib/*/libfoo.so*",
+	"/usr/lib/x86_64-linux-gnu/libbar.so.1",
+	"/usr/lib/x86_64-linux-gnu/libfoo.so.1",
+	"/usr/lib/libfoo[0-9].so",
+	"/usr/share/**",
+	"/usr/share/doc/app/copyright",
+	"/var/lib/chisel/**",
+	"/**/*.pyc",
+}
+
+func (s *S) TestPathIndexCandidates(c *C) {
+	for _, glob := range pathIndexPaths {
+		candidates := setup.PathIndexCandidates(pathIndexPaths, glob)
+		// No path matching the glob may be missed.
+		for _, path := range pathIndexPaths {
+			if strdist.GlobPath(path, glob) {
+				c.Assert(candidates, testutil.Contains, path, Commentf("glob %s", glob))
+			}
+		}
+	}
+
+	c.Assert(setup.PathIndexCandidates(pathIndexPaths, "/usr/lib/*/libfoo.so*"), DeepEquals, []string{
+		"/",
+		"/**/*.pyc",
+		"/usr/",
+		"/usr/lib/",
+		"/usr/lib/*/libfoo.so*",
+		"/usr/lib/libfoo[0-9].so",
+		"/usr/lib/x86_64-linux-gnu/libbar.so.1",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1",
+	})
+	c.Assert(setup.PathIndexCandidates(pathIndexPaths, "/usr/share/**"), DeepEquals, []string{
+		"/",
+		"/**/*.pyc",
+		"/usr/",
+		"/usr/share/**",
+		"/usr/share/doc/app/copyright",
+	})
+	c.Assert(setup.PathIndexCandidates(pathIndexPaths, "/etc/foo*"), DeepEquals, []string{
+		"/",
+		"/**/*.pyc",
+		"/etc/",
+		"/etc/foo.conf",
+		"/etc/foo.d/**",
+	})
+	c.Assert(setup.PathIndexCandidates(pathIndexPaths, "/opt/**"), DeepEquals, []string{
+		"/",
+		"/**/*.pyc",
+	})
+}
+
+var cyclesRelease = map[string]string{
+	"chisel.yaml": string(testutil.DefaultChiselYaml),
+	"slices/pkga.yaml": `
+		package: pkga
+		slices:
+			one:
+				essential:
+					- pkga_two
+					- slice: pkgb_alias
+			two:
+				v3-essential:
+					pkga_one:
+	`,
+	"slices/pkgb.yaml": `
+		package: pkgb
+		slices:
+			one:
+				provides:
+					- pkgb_alias
+				essential:
+					- pkga_two
+	`,
+}
+
+func (s *S) TestEssentialCycles(c *C) {
+	dir := c.MkDir()
+	for path, data := range cyclesRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	// The shortest loop is reported when validating the release.
+	_, err := setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `essential loop detected: pkga_one \(slices/pkga.yaml:5\) -> pkga_two \(slices/pkga.yaml:9\) -> pkga_one`)
+
+	release, err := setup.ParseRelease(dir)
+	c.Assert(err, IsNil)
+	cycles, err := release.EssentialCycles("")
+	c.Assert(err, IsNil)
+	var formatted []string
+	for _, links := range cycles {
+		formatted = append(formatted, setup.FormatEssentialCycle(links))
+	}
+	c.Assert(formatted, DeepEquals, []string{
+		"pkga_one (slices/pkga.yaml:5) -> pkga_two (slices/pkga.yaml:9) -> pkga_one",
+		"pkga_one (slices/pkga.yaml:6) -> pkgb_one (slices/pkgb.yaml:7) -> pkga_two (slices/pkga.yaml:9) -> pkga_one",
+	})
+	c.Assert(cycles[0][0], DeepEquals, setup.EssentialLink{
+		Slice:     setup.SliceKey{Package: "pkga", Slice: "one"},
+		Essential: setup.SliceKey{Package: "pkga", Slice: "two"},
+		Location:  "slices/pkga.yaml:5",
+	})
+}
@@ -0,0 +1,56 @@
+package setup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsrMergeDirs are the directories which are symlinks to their counterparts
+// under /usr in usrmerged releases.
+var UsrMergeDirs = []string{"/bin", "/lib", "/lib32", "/lib64", "/libx32", "/sbin"}
+
+// UsrMergePath returns the path under /usr which path refers to in a
+// usrmerged release, or path itself when it is not within any of the
+// UsrMergeDirs. The symlinks replacing those directories, such as /bin, are
+// left as they are.
+func UsrMergePath(path string) string {
+	for _, dir := range UsrMergeDirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return "/usr" + path
+		}
+	}
+	return path
+}
+
+// mergeUsrPaths lists the paths of the slices of pkg by their counterparts
+// under /usr, so that slices written for releases before and after usrmerge
+// refer to the same paths.
+func mergeUsrPaths(pkg *Package) error {
+	for _, slice := range pkg.Slices {
+		contents := make(map[string]PathInfo, len(slice.Contents))
+		listed := make(map[string]string, len(slice.Contents))
+		for path, info := range slice.Contents {
+			merged := UsrMergePath(path)
+			if other, ok := listed[merged]; ok {
+				if other > path {
+					other, path = path, other
+				}
+				return fmt.Errorf("slice %s lists %s and %s, which are the same path with usrmerge", slice, other, path)
+			}
+			listed[merged] = path
+			if info.Kind == CopyPath && info.Info != "" {
+				info.Info = UsrMergePath(info.Info)
+			}
+			contents[merged] = info
+		}
+		slice.Contents = contents
+		if len(slice.Divert) > 0 {
+			divert := make(map[string]string, len(slice.Divert))
+			for divPath, divertTo := range slice.Divert {
+				divert[UsrMergePath(divPath)] = UsrMergePath(divertTo)
+			}
+			slice.Divert = divert
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 func (p *Package) MarshalYAML() (any, error) {
@@ -25,10 +27,16 @@ func (p *Package) MarshalYAML() (any, error) {
 var _ yaml.Marshaler = (*Package)(nil)
 
 type yamlRelease struct {
-	Format      string                 `yaml:"format"`
+	Format      string                 `yaml:"format" schema:"required"`
 	Maintenance yamlMaintenance        `yaml:"maintenance"`
-	Archives    map[string]yamlArchive `yaml:"archives"`
+	Archives    map[string]yamlArchive `yaml:"archives" schema:"required"`
 	PubKeys     map[string]yamlPubKey  `yaml:"public-keys"`
+	Groups      map[string][]string    `yaml:"groups"`
+	// ArchiveStrategy resolves packages found in several archives.
+	ArchiveStrategy ArchiveStrategy `yaml:"archive-strategy"`
+	UsrMerge        bool            `yaml:"usrmerge"`
+	// Architectures are the ones the release is cut for.
+	Architectures []string `yaml:"architectures"`
 	// "v2-archives" is used for backwards compatibility with Chisel <= 1.0.0,
 	// where it will be ignored. In new versions, it will be parsed with the new
 	// fields that break said compatibility (e.g. "pro" archives) and merged
@@ -42,19 +50,38 @@ const (
 )
 
 type yamlArchive struct {
-	Version    string   `yaml:"version"`
-	Suites     []string `yaml:"suites"`
-	Components []string `yaml:"components"`
-	Priority   *int     `yaml:"priority"`
-	Pro        string   `yaml:"pro"`
-	Default    bool     `yaml:"default"`
-	PubKeys    []string `yaml:"public-keys"`
+	Version    string      `yaml:"version" schema:"required"`
+	Suites     []string    `yaml:"suites" schema:"required"`
+	Components []string    `yaml:"components" schema:"required"`
+	Priority   *int        `yaml:"priority"`
+	Pro        string      `yaml:"pro"`
+	Default    bool        `yaml:"default"`
+	PubKeys    []string    `yaml:"public-keys" schema:"required"`
+	Verify     *yamlVerify `yaml:"verify"`
+	HTTP       *yamlHTTP   `yaml:"http"`
+	Mirrors    []string    `yaml:"mirrors"`
+}
+
+type yamlVerify struct {
+	MinDigest     string `yaml:"min-digest"`
+	RequireByHash bool   `yaml:"require-by-hash"`
+	RejectMD5Only bool   `yaml:"reject-md5-only"`
+}
+
+type yamlHTTP struct {
+	Timeout        string            `yaml:"timeout"`
+	BulkTimeout    string            `yaml:"bulk-timeout"`
+	Headers        map[string]string `yaml:"headers"`
+	UserAgent      string            `yaml:"user-agent"`
+	ChunkThreshold string            `yaml:"chunk-threshold"`
+	ChunkSize      string            `yaml:"chunk-size"`
 }
 
 type yamlPackage struct {
-	Name      string               `yaml:"package"`
-	Archive   string               `yaml:"archive,omitempty"`
-	Essential []string             `yaml:"essential,omitempty"`
+	Name      string               `yaml:"package" schema:"required"`
+	Archive   yamlArchiveList      `yaml:"archive,omitempty"`
+	Source    *yamlSource          `yaml:"source,omitempty"`
+	Essential []yamlEssentialRef   `yaml:"essential,omitempty"`
 	Slices    map[string]yamlSlice `yaml:"slices,omitempty"`
 	// "v3-essential" is used for backwards porting of arch-specific essential
 	// to releases that use "v1" or "v2". When using older versions of Chisel
@@ -62,6 +89,12 @@ type yamlPackage struct {
 	V3Essential map[string]*yamlEssential `yaml:"v3-essential,omitempty"`
 }
 
+type yamlSource struct {
+	Local  string `yaml:"local,omitempty"`
+	URL    string `yaml:"url,omitempty"`
+	SHA256 string `yaml:"sha256,omitempty"`
+}
+
 type yamlPath struct {
 	Dir      bool         `yaml:"make,omitempty"`
 	Mode     yamlMode     `yaml:"mode,omitempty"`
@@ -72,7 +105,12 @@ type yamlPath struct {
 	Until    PathUntil    `yaml:"until,omitempty"`
 	Arch     yamlArch     `yaml:"arch,omitempty"`
 	Generate GenerateKind `yaml:"generate,omitempty"`
+	Priority int          `yaml:"priority,omitempty"`
 	Prefer   string       `yaml:"prefer,omitempty"`
+	Label    string       `yaml:"label,omitempty"`
+	Same     bool         `yaml:"same,omitempty"`
+
+	Overwrite OverwritePolicy `yaml:"overwrite,omitempty"`
 }
 
 func (yp *yamlPath) MarshalYAML() (any, error) {
@@ -107,14 +145,22 @@ type yamlArch struct {
 }
 
 func (ya *yamlArch) UnmarshalYAML(value *yaml.Node) error {
-	var s string
-	var l []string
-	if value.Decode(&s) == nil {
-		ya.List = []string{s}
-	} else if value.Decode(&l) == nil {
-		ya.List = l
-	} else {
-		return fmt.Errorf("cannot decode arch")
+	nodes := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		nodes = value.Content
+	}
+	ya.List = nil
+	for _, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("cannot decode arch")
+		}
+		// Unquoted exclusions such as !s390x are read as tags on an empty
+		// value.
+		if node.Value == "" && strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+			ya.List = append(ya.List, node.Tag)
+		} else {
+			ya.List = append(ya.List, node.Value)
+		}
 	}
 	// Validate arch correctness later for a better error message.
 	return nil
@@ -129,6 +175,34 @@ func (ya yamlArch) MarshalYAML() (any, error) {
 
 var _ yaml.Marshaler = yamlArch{}
 
+// yamlArchiveList holds the archives a package is pinned to, given either as
+// a single archive name or as a list of them in the order they are searched.
+type yamlArchiveList struct {
+	List []string
+}
+
+func (yl *yamlArchiveList) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	var l []string
+	if value.Decode(&s) == nil {
+		yl.List = []string{s}
+	} else if value.Decode(&l) == nil {
+		yl.List = l
+	} else {
+		return fmt.Errorf("cannot decode archive")
+	}
+	return nil
+}
+
+func (yl yamlArchiveList) MarshalYAML() (any, error) {
+	if len(yl.List) == 1 {
+		return yl.List[0], nil
+	}
+	return yl.List, nil
+}
+
+var _ yaml.Marshaler = yamlArchiveList{}
+
 type yamlMode uint
 
 func (ym yamlMode) MarshalYAML() (any, error) {
@@ -146,9 +220,19 @@ func (ym yamlMode) MarshalYAML() (any, error) {
 var _ yaml.Marshaler = yamlMode(0)
 
 type yamlSlice struct {
-	Essential []string             `yaml:"essential,omitempty"`
-	Contents  map[string]*yamlPath `yaml:"contents,omitempty"`
-	Mutate    string               `yaml:"mutate,omitempty"`
+	Summary    string               `yaml:"summary,omitempty"`
+	Notes      string               `yaml:"notes,omitempty"`
+	Owners     []string             `yaml:"owners,omitempty"`
+	Deprecated string               `yaml:"deprecated,omitempty"`
+	Essential  []yamlEssentialRef   `yaml:"essential,omitempty"`
+	Contents   map[string]*yamlPath `yaml:"contents,omitempty"`
+	Mutate     string               `yaml:"mutate,omitempty"`
+	Provides   []string             `yaml:"provides,omitempty"`
+	// LibraryPaths and Environment are used to generate the entries in
+	// /etc/ld.so.conf.d and /etc/environment.
+	LibraryPaths []string          `yaml:"library-paths,omitempty"`
+	Environment  map[string]string `yaml:"environment,omitempty"`
+	Divert       map[string]string `yaml:"divert,omitempty"`
 	// "v3-essential" is used for backwards porting of arch-specific essential
 	// to releases that use "v1" or "v2". When using older versions of Chisel
 	// the field will be ignored and `essential` is used as a fallback.
@@ -156,12 +240,34 @@ type yamlSlice struct {
 }
 
 type yamlPubKey struct {
-	ID    string `yaml:"id"`
-	Armor string `yaml:"armor"`
+	ID    string `yaml:"id" schema:"required"`
+	Armor string `yaml:"armor" schema:"required"`
 }
 
 type yamlEssential struct {
 	Arch yamlArch `yaml:"arch,omitempty"`
+	// Version constrains the versions of the package of the slice which
+	// are accepted, as in ">= 3.0.10".
+	Version string `yaml:"version,omitempty"`
+}
+
+var essentialVersionExp = regexp.MustCompile(`^(<<|<=|=|>=|>>)\s*([^\s()]+)$`)
+
+// info returns the essential information for the entry.
+func (ye *yamlEssential) info() (EssentialInfo, error) {
+	if ye == nil {
+		return EssentialInfo{}, nil
+	}
+	info := EssentialInfo{Arch: ye.Arch.List}
+	if ye.Version != "" {
+		match := essentialVersionExp.FindStringSubmatch(strings.TrimSpace(ye.Version))
+		if match == nil {
+			return EssentialInfo{}, fmt.Errorf("invalid version constraint %q", ye.Version)
+		}
+		info.Op = match[1]
+		info.Version = match[2]
+	}
+	return info, nil
 }
 
 func (ye *yamlEssential) MarshalYAML() (any, error) {
@@ -177,6 +283,95 @@ func (ye *yamlEssential) MarshalYAML() (any, error) {
 
 var _ yaml.Marshaler = (*yamlEssential)(nil)
 
+// yamlEssentialRef is an entry in the "essential" list. It is either the
+// name of a slice or a mapping holding the name of the slice and the
+// condition under which it is required, as in:
+//
+//	essential:
+//	  - libc6_libs
+//	  - slice: libssl3_libs
+//	    when: arch in [amd64, arm64]
+//
+// The name of the slice may be followed by a constraint on the version of
+// its package, as in "libssl3_libs (>= 3.0.10)".
+type yamlEssentialRef struct {
+	Slice string `yaml:"slice" schema:"required"`
+	When  string `yaml:"when,omitempty"`
+}
+
+func (ye *yamlEssentialRef) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&ye.Slice)
+	}
+	type plainRef yamlEssentialRef
+	err := value.Decode((*plainRef)(ye))
+	if err != nil {
+		return err
+	}
+	if ye.Slice == "" {
+		return fmt.Errorf("line %d: essential entry is missing 'slice'", value.Line)
+	}
+	return nil
+}
+
+func (ye yamlEssentialRef) MarshalYAML() (any, error) {
+	if ye.When == "" {
+		return ye.Slice, nil
+	}
+	type plainRef yamlEssentialRef
+	return plainRef(ye), nil
+}
+
+var _ yaml.Marshaler = yamlEssentialRef{}
+
+var (
+	essentialCondExp = regexp.MustCompile(`^arch\s*(==|\s+in)\s*(.*)$`)
+	essentialRefExp  = regexp.MustCompile(`^(\S+)\s*\((.*)\)$`)
+)
+
+// essential returns the name of the slice of the entry and its essential
+// information, with the version constraint following the name and the
+// architectures taken from its condition, if any. The supported conditions
+// are "arch == <arch>" and "arch in [<arch>, ...]".
+func (ye *yamlEssentialRef) essential() (string, *yamlEssential, error) {
+	sliceName := strings.TrimSpace(ye.Slice)
+	essential := &yamlEssential{}
+	if match := essentialRefExp.FindStringSubmatch(sliceName); match != nil {
+		sliceName = match[1]
+		essential.Version = strings.TrimSpace(match[2])
+	}
+	when := strings.TrimSpace(ye.When)
+	if when == "" {
+		return sliceName, essential, nil
+	}
+	match := essentialCondExp.FindStringSubmatch(when)
+	if match == nil {
+		return "", nil, fmt.Errorf("unsupported condition %q", ye.When)
+	}
+	var archList []string
+	if match[1] == "==" {
+		archList = []string{match[2]}
+	} else {
+		list, ok := strings.CutPrefix(match[2], "[")
+		if ok {
+			list, ok = strings.CutSuffix(list, "]")
+		}
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported condition %q", ye.When)
+		}
+		for _, arch := range strings.Split(list, ",") {
+			archList = append(archList, strings.TrimSpace(arch))
+		}
+	}
+	for _, arch := range archList {
+		if validateArchRef(arch) != nil {
+			return "", nil, fmt.Errorf("invalid architecture %q in condition %q", arch, ye.When)
+		}
+	}
+	essential.Arch = yamlArch{List: archList}
+	return sliceName, essential, nil
+}
+
 func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 	release := &Release{
 		Path:     baseDir,
@@ -251,7 +446,7 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 		switch details.Pro {
 		case "", archive.ProApps, archive.ProFIPS, archive.ProFIPSUpdates, archive.ProInfra:
 		default:
-			logf("Archive %q ignored: invalid pro value: %q", archiveName, details.Pro)
+			release.warnf("Archive %q ignored: invalid pro value: %q", archiveName, details.Pro)
 			continue
 		}
 
@@ -277,6 +472,37 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 			archiveKeys = append(archiveKeys, key)
 		}
 
+		var verify archive.VerifyOptions
+		if details.Verify != nil {
+			err := archive.ValidateDigest(details.Verify.MinDigest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: archive %q has %w", fileName, archiveName, err)
+			}
+			verify = archive.VerifyOptions{
+				MinDigest:     details.Verify.MinDigest,
+				RequireByHash: details.Verify.RequireByHash,
+				RejectMD5Only: details.Verify.RejectMD5Only,
+			}
+		}
+
+		var httpOptions archive.HTTPOptions
+		if details.HTTP != nil {
+			httpOptions, err = parseHTTP(details.HTTP)
+			if err != nil {
+				return nil, fmt.Errorf("%s: archive %q has %w", fileName, archiveName, err)
+			}
+		}
+
+		if len(details.Mirrors) > 0 && details.Pro != "" {
+			return nil, fmt.Errorf("%s: archive %q has mirrors but is a pro archive", fileName, archiveName)
+		}
+		for _, mirror := range details.Mirrors {
+			err := archive.ValidateMirror(mirror)
+			if err != nil {
+				return nil, fmt.Errorf("%s: archive %q has %w", fileName, archiveName, err)
+			}
+		}
+
 		priority := 0
 		if details.Priority != nil {
 			hasPriority = true
@@ -299,6 +525,9 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 			Pro:        details.Pro,
 			Priority:   priority,
 			PubKeys:    archiveKeys,
+			Verify:     verify,
+			HTTP:       httpOptions,
+			Mirrors:    details.Mirrors,
 		}
 	}
 	if (hasPriority && archiveNoPriority != "") ||
@@ -321,6 +550,41 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 		release.Archives[defaultArchive].Priority = 1
 	}
 
+	switch yamlVar.ArchiveStrategy {
+	case "", StrategyPriority, StrategyVersion, StrategySecurity, StrategyPinned:
+		release.ArchiveStrategy = yamlVar.ArchiveStrategy
+	default:
+		return nil, fmt.Errorf("%s: invalid archive-strategy: %q", fileName, yamlVar.ArchiveStrategy)
+	}
+	release.UsrMerge = yamlVar.UsrMerge
+	for _, arch := range yamlVar.Architectures {
+		if deb.ValidateArch(arch) != nil {
+			return nil, fmt.Errorf("%s: invalid architecture: %q", fileName, arch)
+		}
+	}
+	release.Archs = yamlVar.Architectures
+
+	for name, refs := range yamlVar.Groups {
+		if !IsGroupName(name) {
+			return nil, fmt.Errorf("%s: invalid group name: %q", fileName, name)
+		}
+		if len(refs) == 0 {
+			return nil, fmt.Errorf("%s: group %q has no slices", fileName, name)
+		}
+		keys := make([]SliceKey, 0, len(refs))
+		for _, ref := range refs {
+			key, err := ParseSliceKey(ref)
+			if err != nil {
+				return nil, fmt.Errorf("%s: group %q has %w", fileName, name, err)
+			}
+			keys = append(keys, key)
+		}
+		if release.Groups == nil {
+			release.Groups = make(map[string][]SliceKey)
+		}
+		release.Groups[name] = keys
+	}
+
 	var maintenance Maintenance
 	if yamlVar.Maintenance == (yamlMaintenance{}) {
 		// Use default if key not present in yaml, best effort if "ubuntu"
@@ -393,16 +657,41 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 	if yamlPkg.V3Essential == nil {
 		yamlPkg.V3Essential = map[string]*yamlEssential{}
 	}
-	for _, refName := range yamlPkg.Essential {
+	for _, ref := range yamlPkg.Essential {
+		refName, essential, err := ref.essential()
+		if err != nil {
+			return nil, fmt.Errorf("package %q has invalid essential %s: %v", pkgName, ref.Slice, err)
+		}
 		if _, ok := yamlPkg.V3Essential[refName]; ok {
 			// This check is only needed because the list format can contain
 			// duplicates. It should be removed when format "v2" is deprecated.
 			return nil, fmt.Errorf("package %q repeats %s in essential fields", pkgName, refName)
 		}
-		yamlPkg.V3Essential[refName] = &yamlEssential{}
+		yamlPkg.V3Essential[refName] = essential
 	}
 
-	pkg.Archive = yamlPkg.Archive
+	if yamlPkg.Archive.List != nil && len(yamlPkg.Archive.List) == 0 {
+		return nil, fmt.Errorf("%s: package has an empty archive list", pkgPath)
+	}
+	for i, archiveName := range yamlPkg.Archive.List {
+		if archiveName == "" {
+			return nil, fmt.Errorf("%s: package has an empty archive name", pkgPath)
+		}
+		if slices.Contains(yamlPkg.Archive.List[:i], archiveName) {
+			return nil, fmt.Errorf("%s: package lists archive %q twice", pkgPath, archiveName)
+		}
+	}
+	pkg.Archives = yamlPkg.Archive.List
+	if yamlPkg.Source != nil {
+		source, err := parseSource(yamlPkg.Source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid source: %s", pkgPath, err)
+		}
+		if len(pkg.Archives) > 0 {
+			return nil, fmt.Errorf("%s: package cannot have both 'archive' and 'source'", pkgPath)
+		}
+		pkg.Source = source
+	}
 	zeroPath := yamlPath{}
 	for sliceName, yamlSlice := range yamlPkg.Slices {
 		match := apacheutil.SnameExp.FindStringSubmatch(sliceName)
@@ -410,8 +699,12 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			return nil, fmt.Errorf("invalid slice name %q in %s (start with a-z, len >= 3, only a-z / 0-9 / -)", sliceName, pkgPath)
 		}
 		slice := &Slice{
-			Package: pkgName,
-			Name:    sliceName,
+			Package:    pkgName,
+			Name:       sliceName,
+			Summary:    yamlSlice.Summary,
+			Notes:      yamlSlice.Notes,
+			Owners:     yamlSlice.Owners,
+			Deprecated: yamlSlice.Deprecated,
 			Scripts: SliceScripts{
 				Mutate: yamlSlice.Mutate,
 			},
@@ -420,13 +713,17 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 		if yamlSlice.V3Essential == nil {
 			yamlSlice.V3Essential = map[string]*yamlEssential{}
 		}
-		for _, refName := range yamlSlice.Essential {
+		for _, ref := range yamlSlice.Essential {
+			refName, essential, err := ref.essential()
+			if err != nil {
+				return nil, fmt.Errorf("slice %s has invalid essential %s: %v", slice, ref.Slice, err)
+			}
 			if _, ok := yamlSlice.V3Essential[refName]; ok {
 				// This check is only needed because the list format can contain
 				// duplicates. It should be removed when format "v2" is deprecated.
 				return nil, fmt.Errorf("slice %s repeats %s in essential fields", slice, refName)
 			}
-			yamlSlice.V3Essential[refName] = &yamlEssential{}
+			yamlSlice.V3Essential[refName] = essential
 		}
 		for refName, essentialInfo := range yamlPkg.V3Essential {
 			sliceKey, err := ParseSliceKey(refName)
@@ -443,11 +740,11 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			if slice.Essential == nil {
 				slice.Essential = map[SliceKey]EssentialInfo{}
 			}
-			var archList []string
-			if essentialInfo != nil {
-				archList = essentialInfo.Arch.List
+			info, err := essentialInfo.info()
+			if err != nil {
+				return nil, fmt.Errorf("package %q has invalid essential %s: %v", pkgName, refName, err)
 			}
-			slice.Essential[sliceKey] = EssentialInfo{Arch: archList}
+			slice.Essential[sliceKey] = info
 		}
 		for refName, essentialInfo := range yamlSlice.V3Essential {
 			sliceKey, err := ParseSliceKey(refName)
@@ -463,11 +760,46 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			if slice.Essential == nil {
 				slice.Essential = map[SliceKey]EssentialInfo{}
 			}
-			var archList []string
-			if essentialInfo != nil {
-				archList = essentialInfo.Arch.List
+			info, err := essentialInfo.info()
+			if err != nil {
+				return nil, fmt.Errorf("slice %s has invalid essential %s: %v", slice, refName, err)
 			}
-			slice.Essential[sliceKey] = EssentialInfo{Arch: archList}
+			slice.Essential[sliceKey] = info
+		}
+
+		for _, refName := range yamlSlice.Provides {
+			alias, err := ParseSliceKey(refName)
+			if err != nil {
+				return nil, fmt.Errorf("slice %s has invalid 'provides' reference: %q", slice, refName)
+			}
+			if alias.Package == slice.Package && alias.Slice == slice.Name {
+				return nil, fmt.Errorf("slice %s cannot provide itself", slice)
+			}
+			if slices.Contains(slice.Provides, alias) {
+				return nil, fmt.Errorf("slice %s repeats %s in 'provides'", slice, refName)
+			}
+			slice.Provides = append(slice.Provides, alias)
+		}
+
+		for _, libPath := range yamlSlice.LibraryPaths {
+			if !path.IsAbs(libPath) || path.Clean(libPath) != libPath {
+				return nil, fmt.Errorf("slice %s has invalid library path: %q", slice, libPath)
+			}
+			if slices.Contains(slice.LibraryPaths, libPath) {
+				return nil, fmt.Errorf("slice %s repeats library path %s", slice, libPath)
+			}
+			slice.LibraryPaths = append(slice.LibraryPaths, libPath)
+		}
+		for name, value := range yamlSlice.Environment {
+			if !envNameExp.MatchString(name) {
+				return nil, fmt.Errorf("slice %s has invalid environment variable name: %q", slice, name)
+			}
+			if strings.ContainsAny(value, "\"\\\n") {
+				return nil, fmt.Errorf("slice %s has invalid value for environment variable %s: %q", slice, name, value)
+			}
+		}
+		if len(yamlSlice.Environment) > 0 {
+			slice.Environment = yamlSlice.Environment
 		}
 
 		if len(yamlSlice.Contents) > 0 {
@@ -489,19 +821,46 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			var until PathUntil
 			var arch []string
 			var generate GenerateKind
+			var priority int
 			var prefer string
-			if yamlPath != nil && yamlPath.Generate != "" {
+			var label string
+			if yamlPath != nil && yamlPath.Generate == GenerateConcat {
+				// Fragments are assembled from the text of every slice
+				// declaring the path, so only a few options apply.
+				fragmentPath := *yamlPath
+				fragmentPath.Text = nil
+				fragmentPath.Mode = 0
+				fragmentPath.Arch = yamlArch{}
+				fragmentPath.Priority = 0
+				fragmentPath.Label = ""
+				fragmentPath.Generate = ""
+				if yamlPath.Text == nil || !fragmentPath.SameContent(&zeroPath) || yamlPath.Prefer != "" || yamlPath.Until != UntilNone {
+					return nil, fmt.Errorf("slice %s_%s path %s has invalid generate options",
+						pkgName, sliceName, contPath)
+				}
+				if isDir || strdist.ContainsWildcard(contPath) {
+					return nil, fmt.Errorf("slice %s_%s has invalid generate path: %s is not a file path", pkgName, sliceName, contPath)
+				}
+				kinds = append(kinds, GeneratePath)
+				info = *yamlPath.Text
+				priority = yamlPath.Priority
+			} else if yamlPath != nil && yamlPath.Generate != "" {
 				zeroPathGenerate := zeroPath
 				zeroPathGenerate.Generate = yamlPath.Generate
-				if !yamlPath.SameContent(&zeroPathGenerate) || yamlPath.Prefer != "" || yamlPath.Until != UntilNone {
+				if !yamlPath.SameContent(&zeroPathGenerate) || yamlPath.Prefer != "" || yamlPath.Until != UntilNone || yamlPath.Label != "" {
 					return nil, fmt.Errorf("slice %s_%s path %s has invalid generate options",
 						pkgName, sliceName, contPath)
 				}
-				if _, err := validateGeneratePath(contPath); err != nil {
+				if volatile, ok := VolatileFiles[yamlPath.Generate]; ok {
+					if contPath != volatile.Path {
+						return nil, fmt.Errorf("slice %s_%s has invalid generate path: %s is only generated at %s",
+							pkgName, sliceName, yamlPath.Generate, volatile.Path)
+					}
+				} else if _, err := validateGeneratePath(contPath); err != nil {
 					return nil, fmt.Errorf("slice %s_%s has invalid generate path: %s", pkgName, sliceName, err)
 				}
 				kinds = append(kinds, GeneratePath)
-			} else if strings.ContainsAny(contPath, "*?") {
+			} else if strdist.ContainsWildcard(contPath) {
 				if yamlPath != nil {
 					if !yamlPath.SameContent(&zeroPath) || yamlPath.Prefer != "" {
 						return nil, fmt.Errorf("slice %s_%s path %s has invalid wildcard options",
@@ -515,6 +874,14 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 				mutable = yamlPath.Mutable
 				generate = yamlPath.Generate
 				prefer = yamlPath.Prefer
+				label = yamlPath.Label
+				if label != "" && !labelExp.MatchString(label) {
+					return nil, fmt.Errorf("slice %s_%s has invalid 'label' for path %s: %q", pkgName, sliceName, contPath, label)
+				}
+				if yamlPath.Priority != 0 && generate != GenerateConcat {
+					return nil, fmt.Errorf("slice %s_%s path %s has 'priority' without 'generate: concat'",
+						pkgName, sliceName, contPath)
+				}
 				if yamlPath.Dir {
 					if !strings.HasSuffix(contPath, "/") {
 						return nil, fmt.Errorf("slice %s_%s path %s must end in / for 'make' to be valid",
@@ -522,7 +889,7 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 					}
 					kinds = append(kinds, DirPath)
 				}
-				if yamlPath.Text != nil {
+				if yamlPath.Text != nil && generate != GenerateConcat {
 					kinds = append(kinds, TextPath)
 					info = *yamlPath.Text
 				}
@@ -545,7 +912,7 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 				}
 				arch = yamlPath.Arch.List
 				for _, s := range arch {
-					if deb.ValidateArch(s) != nil {
+					if validateArchRef(s) != nil {
 						return nil, fmt.Errorf("slice %s_%s has invalid 'arch' for path %s: %q", pkgName, sliceName, contPath, s)
 					}
 				}
@@ -566,6 +933,22 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			if mutable && kinds[0] != TextPath && (kinds[0] != CopyPath || isDir) {
 				return nil, fmt.Errorf("slice %s_%s mutable is not a regular file: %s", pkgName, sliceName, contPath)
 			}
+			same := yamlPath != nil && yamlPath.Same
+			if same && (kinds[0] != CopyPath || isDir || mutable) {
+				return nil, fmt.Errorf("slice %s_%s path %s has 'same' but is not a file extracted as is", pkgName, sliceName, contPath)
+			}
+			var overwrite OverwritePolicy
+			if yamlPath != nil {
+				overwrite = yamlPath.Overwrite
+			}
+			switch overwrite {
+			case "", OverwriteError, OverwriteKeepFirst, OverwriteLast, OverwriteMerge:
+			default:
+				return nil, fmt.Errorf("slice %s_%s has invalid 'overwrite' for path %s: %q", pkgName, sliceName, contPath, overwrite)
+			}
+			if overwrite != "" && !mutable {
+				return nil, fmt.Errorf("slice %s_%s path %s has 'overwrite' but is not mutable", pkgName, sliceName, contPath)
+			}
 			slice.Contents[contPath] = PathInfo{
 				Kind:     kinds[0],
 				Info:     info,
@@ -574,9 +957,34 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 				Until:    until,
 				Arch:     arch,
 				Generate: generate,
+				Priority: priority,
 				Prefer:   prefer,
+				Label:    label,
+				Same:     same,
+
+				Overwrite: overwrite,
+			}
+		}
+
+		for divPath, divertTo := range yamlSlice.Divert {
+			for _, p := range []string{divPath, divertTo} {
+				if !path.IsAbs(p) || path.Clean(p) != p || strings.ContainsAny(p, "*?") {
+					return nil, fmt.Errorf("slice %s has invalid 'divert' path: %q", slice, p)
+				}
+			}
+			if divPath == divertTo {
+				return nil, fmt.Errorf("slice %s diverts %s to itself", slice, divPath)
+			}
+			if _, ok := slice.Contents[divPath]; !ok {
+				return nil, fmt.Errorf("slice %s diverts %s but does not list it in its contents", slice, divPath)
+			}
+			if _, ok := yamlSlice.Divert[divertTo]; ok {
+				return nil, fmt.Errorf("slice %s diverts %s to %s, which it diverts too", slice, divPath, divertTo)
 			}
 		}
+		if len(yamlSlice.Divert) > 0 {
+			slice.Divert = yamlSlice.Divert
+		}
 
 		pkg.Slices[sliceName] = slice
 	}
@@ -584,6 +992,72 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 	return &pkg, err
 }
 
+var sha256Exp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+var envNameExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// labelExp matches SELinux security contexts in the user:role:type[:level]
+// format, such as "system_u:object_r:bin_t:s0".
+var labelExp = regexp.MustCompile(`^[A-Za-z0-9_.-]+:[A-Za-z0-9_.-]+:[A-Za-z0-9_.-]+(:[A-Za-z0-9_.,:-]+)?$`)
+
+func parseHTTP(yamlHTTP *yamlHTTP) (archive.HTTPOptions, error) {
+	options := archive.HTTPOptions{
+		Headers:   yamlHTTP.Headers,
+		UserAgent: yamlHTTP.UserAgent,
+	}
+	var err error
+	if yamlHTTP.Timeout != "" {
+		options.Timeout, err = time.ParseDuration(yamlHTTP.Timeout)
+		if err != nil {
+			return options, fmt.Errorf("invalid timeout: %q", yamlHTTP.Timeout)
+		}
+	}
+	if yamlHTTP.BulkTimeout != "" {
+		options.BulkTimeout, err = time.ParseDuration(yamlHTTP.BulkTimeout)
+		if err != nil {
+			return options, fmt.Errorf("invalid bulk timeout: %q", yamlHTTP.BulkTimeout)
+		}
+	}
+	if yamlHTTP.ChunkThreshold != "" {
+		options.ChunkThreshold, err = archive.ParseSize(yamlHTTP.ChunkThreshold)
+		if err != nil {
+			return options, fmt.Errorf("invalid chunk threshold: %q", yamlHTTP.ChunkThreshold)
+		}
+	}
+	if yamlHTTP.ChunkSize != "" {
+		options.ChunkSize, err = archive.ParseSize(yamlHTTP.ChunkSize)
+		if err != nil {
+			return options, fmt.Errorf("invalid chunk size: %q", yamlHTTP.ChunkSize)
+		}
+	}
+	return options, options.Validate()
+}
+
+func parseSource(yamlSource *yamlSource) (*PackageSource, error) {
+	if (yamlSource.Local == "") == (yamlSource.URL == "") {
+		return nil, fmt.Errorf("exactly one of 'local' or 'url' must be set")
+	}
+	if yamlSource.Local != "" {
+		local := yamlSource.Local
+		if path.IsAbs(local) || path.Clean(local) != local || strings.HasPrefix(local, "../") || local == ".." {
+			return nil, fmt.Errorf("'local' must be a clean path within the release: %q", local)
+		}
+	}
+	if yamlSource.URL != "" {
+		if !strings.HasPrefix(yamlSource.URL, "https://") && !strings.HasPrefix(yamlSource.URL, "http://") {
+			return nil, fmt.Errorf("'url' must use http or https: %q", yamlSource.URL)
+		}
+	}
+	if !sha256Exp.MatchString(yamlSource.SHA256) {
+		return nil, fmt.Errorf("'sha256' must be a lowercase hex-encoded digest")
+	}
+	return &PackageSource{
+		Local:  yamlSource.Local,
+		URL:    yamlSource.URL,
+		SHA256: yamlSource.SHA256,
+	}, nil
+}
+
 // validateGeneratePath validates that the path follows the following format:
 //   - /slashed/path/to/dir/**
 //
@@ -594,7 +1068,7 @@ func validateGeneratePath(path string) (string, error) {
 		return "", fmt.Errorf("%s does not end with /**", path)
 	}
 	dirPath := strings.TrimSuffix(path, "**")
-	if strings.ContainsAny(dirPath, "*?") {
+	if strdist.ContainsWildcard(dirPath) {
 		return "", fmt.Errorf("%s contains wildcard characters in addition to trailing **", path)
 	}
 	return dirPath, nil
@@ -609,7 +1083,12 @@ func pathInfoToYAML(pi *PathInfo) (*yamlPath, error) {
 		Until:    pi.Until,
 		Arch:     yamlArch{List: pi.Arch},
 		Generate: pi.Generate,
+		Priority: pi.Priority,
 		Prefer:   pi.Prefer,
+		Label:    pi.Label,
+		Same:     pi.Same,
+
+		Overwrite: pi.Overwrite,
 	}
 	switch pi.Kind {
 	case DirPath:
@@ -620,8 +1099,12 @@ func pathInfoToYAML(pi *PathInfo) (*yamlPath, error) {
 		path.Text = &pi.Info
 	case SymlinkPath:
 		path.Symlink = pi.Info
-	case GlobPath, GeneratePath:
-		// Nothing more needs to be done for these types.
+	case GeneratePath:
+		if pi.Generate == GenerateConcat {
+			path.Text = &pi.Info
+		}
+	case GlobPath:
+		// Nothing more needs to be done for this type.
 	default:
 		return nil, fmt.Errorf("internal error: unrecognised PathInfo type: %s", pi.Kind)
 	}
@@ -631,12 +1114,26 @@ func pathInfoToYAML(pi *PathInfo) (*yamlPath, error) {
 // sliceToYAML converts a Slice object to a yamlSlice object.
 func sliceToYAML(s *Slice) (*yamlSlice, error) {
 	slice := &yamlSlice{
-		Contents:    make(map[string]*yamlPath, len(s.Contents)),
-		Mutate:      s.Scripts.Mutate,
-		V3Essential: make(map[string]*yamlEssential, len(s.Essential)),
+		Summary:      s.Summary,
+		Notes:        s.Notes,
+		Owners:       s.Owners,
+		Deprecated:   s.Deprecated,
+		Contents:     make(map[string]*yamlPath, len(s.Contents)),
+		Mutate:       s.Scripts.Mutate,
+		LibraryPaths: s.LibraryPaths,
+		Environment:  s.Environment,
+		Divert:       s.Divert,
+		V3Essential:  make(map[string]*yamlEssential, len(s.Essential)),
 	}
 	for key, info := range s.Essential {
-		slice.V3Essential[key.String()] = &yamlEssential{Arch: yamlArch{info.Arch}}
+		essential := &yamlEssential{Arch: yamlArch{info.Arch}}
+		if info.Op != "" {
+			essential.Version = info.Op + " " + info.Version
+		}
+		slice.V3Essential[key.String()] = essential
+	}
+	for _, alias := range s.Provides {
+		slice.Provides = append(slice.Provides, alias.String())
 	}
 	for path, info := range s.Contents {
 		yamlPath, err := pathInfoToYAML(&info)
@@ -652,9 +1149,16 @@ func sliceToYAML(s *Slice) (*yamlSlice, error) {
 func packageToYAML(p *Package) (*yamlPackage, error) {
 	pkg := &yamlPackage{
 		Name:    p.Name,
-		Archive: p.Archive,
+		Archive: yamlArchiveList{List: p.Archives},
 		Slices:  make(map[string]yamlSlice, len(p.Slices)),
 	}
+	if p.Source != nil {
+		pkg.Source = &yamlSource{
+			Local:  p.Source.Local,
+			URL:    p.Source.URL,
+			SHA256: p.Source.SHA256,
+		}
+	}
 	for name, slice := range p.Slices {
 		yamlSlice, err := sliceToYAML(slice)
 		if err != nil {
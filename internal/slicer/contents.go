@@ -0,0 +1,147 @@
+package slicer
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
+)
+
+// PackagePath is a path listed in the data archive of a package.
+type PackagePath struct {
+	// Path is absolute, and ends with "/" for directories.
+	Path string
+	Mode fs.FileMode
+	// Size is the size of regular files, and zero otherwise.
+	Size int64
+	// Link is the target of symlinks.
+	Link string
+}
+
+// ListPackage returns the paths in the data archive of the package read
+// from pkgReader, in the order they are found there, without extracting
+// any of them. The paths are mapped with mapPath, if set, as with
+// deb.ExtractOptions.MapPath, so that they match the paths of the slices of
+// the release as PathMapper returns.
+func ListPackage(pkgReader io.ReadSeeker, mapPath func(path string) string) ([]PackagePath, error) {
+	dataReader, err := deb.DataReader(pkgReader)
+	if err != nil {
+		return nil, err
+	}
+	defer dataReader.Close()
+	var paths []PackagePath
+	tarReader := tar.NewReader(dataReader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(tarHeader.Name) < 3 || !strings.HasPrefix(tarHeader.Name, "./") {
+			continue
+		}
+		entry := PackagePath{
+			Path: tarHeader.Name[1:],
+			Mode: tarHeader.FileInfo().Mode(),
+		}
+		if mapPath != nil {
+			entry.Path = mapPath(entry.Path)
+		}
+		switch tarHeader.Typeflag {
+		case tar.TypeDir:
+			if !strings.HasSuffix(entry.Path, "/") {
+				entry.Path += "/"
+			}
+		case tar.TypeReg:
+			entry.Size = tarHeader.Size
+		case tar.TypeSymlink:
+			entry.Link = tarHeader.Linkname
+		}
+		paths = append(paths, entry)
+	}
+	return paths, nil
+}
+
+// PathMapper returns the function mapping the paths of packages to the
+// paths the slices of the release list, or nil if they are the same.
+func PathMapper(release *setup.Release) func(path string) string {
+	if release.UsrMerge {
+		return setup.UsrMergePath
+	}
+	return nil
+}
+
+// ResolvedPath is a path of a package extracted by a slice.
+type ResolvedPath struct {
+	PackagePath
+	// Target is the path the content is extracted to, which differs
+	// from Path when copied from elsewhere in the package.
+	Target string
+}
+
+// ResolveSlice returns the paths of the package contents listed by
+// ListPackage which the slice extracts for the architecture, sorted by
+// their target, along with the paths of the slice not found in the package.
+// Parent directories created when extracting are not included, and nor is
+// content not coming from the package, such as text files or generated
+// paths.
+func ResolveSlice(slice *setup.Slice, arch string, contents []PackagePath) (resolved []ResolvedPath, missing []string) {
+	for targetPath, pathInfo := range slice.Contents {
+		sourcePath, ok := extractedPath(targetPath, &pathInfo, arch)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, entry := range contents {
+			target := entry.Path
+			if pathInfo.Kind == setup.GlobPath {
+				if !strdist.GlobPath(sourcePath, entry.Path) {
+					continue
+				}
+			} else if entry.Path == sourcePath || entry.Path == sourcePath+"/" {
+				target = targetPath
+				if strings.HasSuffix(entry.Path, "/") && !strings.HasSuffix(target, "/") {
+					target += "/"
+				}
+			} else {
+				continue
+			}
+			resolved = append(resolved, ResolvedPath{PackagePath: entry, Target: target})
+			found = true
+		}
+		if !found {
+			missing = append(missing, sourcePath)
+		}
+	}
+	slices.SortFunc(resolved, func(a, b ResolvedPath) int {
+		return strings.Compare(a.Target, b.Target)
+	})
+	resolved = slices.CompactFunc(resolved, func(a, b ResolvedPath) bool {
+		return a.Target == b.Target
+	})
+	slices.Sort(missing)
+	return resolved, missing
+}
+
+// extractedPath returns the path in the package which the slice path at
+// targetPath is extracted from, and whether it is extracted from the package
+// at all for the architecture.
+func extractedPath(targetPath string, pathInfo *setup.PathInfo, arch string) (string, bool) {
+	if pathInfo.Kind != setup.CopyPath && pathInfo.Kind != setup.GlobPath {
+		return "", false
+	}
+	if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+		return "", false
+	}
+	if pathInfo.Info != "" {
+		return pathInfo.Info, true
+	}
+	return targetPath, true
+}
@@ -0,0 +1,86 @@
+package slicer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/canonical/chisel/internal/copyright"
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// readLicenses returns the licenses declared in the copyright file of each
+// of the packages, indexed by package name. Packages without a copyright
+// file in the machine-readable format are not included. The readers are
+// rewound so that the packages may be extracted afterwards.
+func readLicenses(packages map[string]io.ReadSeekCloser) (map[string][]string, error) {
+	logf("Reading copyright files...")
+	licenses := make(map[string][]string)
+	links := make(map[string]string)
+	for pkg, reader := range packages {
+		data, link, err := readCopyright(reader, pkg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read copyright of package %q: %w", pkg, err)
+		}
+		_, err = reader.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		if link != "" {
+			links[pkg] = link
+		} else if found := copyright.Licenses(data); len(found) > 0 {
+			licenses[pkg] = found
+		}
+	}
+	// Packages built from the same source often point their copyright
+	// file to the one of another package, as in "../libssl3/copyright".
+	for pkg, link := range links {
+		target := link
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(copyright.Path(pkg)), link)
+		}
+		target = path.Clean(target)
+		for other, found := range licenses {
+			if copyright.Path(other) == target {
+				licenses[pkg] = found
+				break
+			}
+		}
+	}
+	return licenses, nil
+}
+
+// readCopyright returns the content of the copyright file of the package,
+// or the target of the symlink at its location. It returns no data if the
+// package has no copyright file.
+func readCopyright(pkgReader io.ReadSeeker, pkg string) (data []byte, link string, err error) {
+	dataReader, err := deb.DataReader(pkgReader)
+	if err != nil {
+		return nil, "", err
+	}
+	defer dataReader.Close()
+
+	copyrightPath := "." + copyright.Path(pkg)
+	tarReader := tar.NewReader(dataReader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if tarHeader.Name != copyrightPath {
+			continue
+		}
+		switch tarHeader.Typeflag {
+		case tar.TypeSymlink:
+			return nil, tarHeader.Linkname, nil
+		case tar.TypeReg:
+			data, err := io.ReadAll(tarReader)
+			return data, "", err
+		}
+		return nil, "", nil
+	}
+}
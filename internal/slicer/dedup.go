@@ -0,0 +1,122 @@
+package slicer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/ownership"
+)
+
+// DedupMode decides how the regular files with identical content in the
+// tree share their storage, as is common for the license and locale files
+// shipped by several packages.
+type DedupMode string
+
+const (
+	// DedupNone leaves every file with its own copy of the content. It is
+	// the mode used when none is set.
+	DedupNone DedupMode = "none"
+	// DedupHardlink replaces the duplicates with hard links, which are
+	// recorded in the manifests as such.
+	DedupHardlink DedupMode = "hardlink"
+	// DedupReflink makes the duplicates share the content of the first
+	// copy-on-write, where the filesystem supports it. The paths remain
+	// independent files.
+	DedupReflink DedupMode = "reflink"
+)
+
+// dedupKey identifies the files which may share their storage. Files must
+// have the same content, mode, owner and label, as hard links share them
+// all.
+type dedupKey struct {
+	sha256      string
+	finalSHA256 string
+	size        int
+	mode        fs.FileMode
+	owner       ownership.Owner
+	label       string
+}
+
+// dedupFiles makes the reported regular files with identical content share
+// their storage according to mode. Empty files and files which are hard
+// links already are left alone. The first path of each set of duplicates,
+// in lexical order, is the one kept.
+func dedupFiles(targetDir string, mode DedupMode, report *manifestutil.Report, db *ownership.DB) error {
+	if mode == "" || mode == DedupNone {
+		return nil
+	}
+	if mode != DedupHardlink && mode != DedupReflink {
+		return fmt.Errorf("invalid dedup mode: %q", mode)
+	}
+
+	groups := make(map[dedupKey][]string)
+	for path, entry := range report.Entries {
+		if !entry.Mode.IsRegular() || entry.Inode != 0 || entry.Size == 0 {
+			continue
+		}
+		key := dedupKey{
+			sha256:      entry.SHA256,
+			finalSHA256: entry.FinalSHA256,
+			size:        entry.Size,
+			mode:        entry.Mode,
+		}
+		if db != nil {
+			key.owner = db.Owner(path)
+			key.label = db.Label(path)
+		}
+		groups[key] = append(groups[key], path)
+	}
+	var sets [][]string
+	for _, paths := range groups {
+		if len(paths) > 1 {
+			slices.Sort(paths)
+			sets = append(sets, paths)
+		}
+	}
+	slices.SortFunc(sets, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+
+	count := 0
+	for _, paths := range sets {
+		source := filepath.Join(targetDir, paths[0])
+		for _, path := range paths[1:] {
+			debugf("Deduplicating %s with %s", path, paths[0])
+			realPath := filepath.Join(targetDir, path)
+			var err error
+			if mode == DedupHardlink {
+				err = os.Remove(realPath)
+				if err == nil {
+					err = os.Link(source, realPath)
+				}
+			} else {
+				err = fsutil.Reflink(source, realPath)
+				if errors.Is(err, errors.ErrUnsupported) {
+					logf("Files with identical content left as they are: reflinks not supported by the filesystem")
+					return nil
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("cannot deduplicate %s: %w", path, err)
+			}
+			count++
+		}
+		if mode == DedupHardlink {
+			err := report.Link(paths)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if count > 0 {
+		logf("Deduplicated %d files with identical content", count)
+	}
+	return nil
+}
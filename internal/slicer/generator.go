@@ -0,0 +1,180 @@
+package slicer
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// Generator creates the content of the directories marked with a custom
+// "generate" kind in the slices, such as "generate: x-machine-id".
+type Generator interface {
+	// Generate returns the files to create in dir, which is absolute
+	// within the tree at rootDir. The tree holds all the content extracted
+	// and must not be changed.
+	Generate(rootDir string, dir string) ([]*GeneratedFile, error)
+}
+
+// GeneratedFile is a regular file created by a Generator.
+type GeneratedFile struct {
+	// Path is relative to the directory the file is generated in.
+	Path string
+	// Mode holds the permission bits, 0644 if unset.
+	Mode fs.FileMode
+	Data []byte
+}
+
+// GeneratorFunc adapts a function to the Generator interface.
+type GeneratorFunc func(rootDir string, dir string) ([]*GeneratedFile, error)
+
+func (f GeneratorFunc) Generate(rootDir string, dir string) ([]*GeneratedFile, error) {
+	return f(rootDir, dir)
+}
+
+// ExecGenerator returns a generator which runs command as in
+// "<command> <root> <dir> <output>", where output is an empty directory in
+// which the command writes the files to create in dir.
+func ExecGenerator(command string) Generator {
+	return &execGenerator{command: command}
+}
+
+type execGenerator struct {
+	command string
+}
+
+func (g *execGenerator) Generate(rootDir string, dir string) ([]*GeneratedFile, error) {
+	outputDir, err := os.MkdirTemp("", "chisel-generate-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outputDir)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(g.command, rootDir, dir, outputDir)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	var files []*GeneratedFile
+	err = filepath.WalkDir(outputDir, func(fpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(outputDir, fpath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("unsupported file type: %s", relPath)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		files = append(files, &GeneratedFile{
+			Path: relPath,
+			Mode: info.Mode().Perm(),
+			Data: data,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// checkGenerators returns an error if any of the custom kinds of generated
+// paths in the selection has no generator.
+func checkGenerators(selection *setup.Selection, generators map[setup.GenerateKind]Generator) error {
+	for _, slice := range selection.Slices {
+		for path, info := range slice.Contents {
+			if info.Generate.IsCustom() && generators[info.Generate] == nil {
+				return fmt.Errorf("slice %s has no generator for path %s: %q", slice, path, info.Generate)
+			}
+		}
+	}
+	return nil
+}
+
+// generateCustom creates the content of the directories marked with custom
+// "generate" kinds using the respective generators, reporting the files
+// created as part of the slices marking the directories.
+func generateCustom(targetDir string, selection *setup.Selection, generators map[setup.GenerateKind]Generator, report *manifestutil.Report) error {
+	type generateKey struct {
+		kind setup.GenerateKind
+		dir  string
+	}
+	dirSlices := make(map[generateKey][]*setup.Slice)
+	for _, slice := range selection.Slices {
+		for path, info := range slice.Contents {
+			if info.Generate.IsCustom() {
+				key := generateKey{info.Generate, strings.TrimSuffix(path, "**")}
+				dirSlices[key] = append(dirSlices[key], slice)
+			}
+		}
+	}
+	keys := make([]generateKey, 0, len(dirSlices))
+	for key := range dirSlices {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b generateKey) int {
+		return strings.Compare(a.dir+string(a.kind), b.dir+string(b.kind))
+	})
+	for _, key := range keys {
+		logf("Generating %s at %s...", key.kind, key.dir)
+		files, err := generators[key.kind].Generate(targetDir, key.dir)
+		if err != nil {
+			return fmt.Errorf("cannot generate %s at %s: %w", key.kind, key.dir, err)
+		}
+		for _, file := range files {
+			if !fs.ValidPath(file.Path) || file.Path == "." {
+				return fmt.Errorf("cannot generate %s at %s: invalid path %q", key.kind, key.dir, file.Path)
+			}
+			mode := file.Mode.Perm()
+			if mode == 0 {
+				mode = 0644
+			}
+			entry, err := fsutil.Create(&fsutil.CreateOptions{
+				Root:        targetDir,
+				Path:        path.Join(key.dir, file.Path),
+				Mode:        mode,
+				Data:        bytes.NewReader(file.Data),
+				MakeParents: true,
+			})
+			if err != nil {
+				return err
+			}
+			for _, slice := range dirSlices[key] {
+				err := report.Add(slice, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package slicer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+func (s *S) TestExecGenerator(c *C) {
+	rootDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(rootDir, "hostname"), []byte("myhost\n"), 0644)
+	c.Assert(err, IsNil)
+
+	command := filepath.Join(c.MkDir(), "generator")
+	err = os.WriteFile(command, []byte(`#!/bin/sh
+set -e
+test "$2" = /etc/machine/
+mkdir "$3/hints"
+cp "$1/hostname" "$3/hints/hostname"
+printf 'uninitialized\n' > "$3/machine-id"
+chmod 0444 "$3/machine-id"
+`), 0755)
+	c.Assert(err, IsNil)
+
+	files, err := slicer.ExecGenerator(command).Generate(rootDir, "/etc/machine/")
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []*slicer.GeneratedFile{
+		{Path: "hints/hostname", Mode: 0644, Data: []byte("myhost\n")},
+		{Path: "machine-id", Mode: 0444, Data: []byte("uninitialized\n")},
+	})
+}
+
+func (s *S) TestExecGeneratorError(c *C) {
+	command := filepath.Join(c.MkDir(), "generator")
+	err := os.WriteFile(command, []byte("#!/bin/sh\necho 'no machine id' >&2\nexit 1\n"), 0755)
+	c.Assert(err, IsNil)
+
+	_, err = slicer.ExecGenerator(command).Generate(c.MkDir(), "/etc/machine/")
+	c.Assert(err, ErrorMatches, "exit status 1: no machine id")
+}
@@ -0,0 +1,70 @@
+package slicer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+)
+
+// HostFile is a file copied from the host into the tree, which is recorded
+// in the manifests with the path it was copied from.
+type HostFile struct {
+	// Source is the path of the file on the host.
+	Source string
+	// Path is the absolute path of the file in the tree.
+	Path string
+	// Mode holds the permission bits, 0644 if unset.
+	Mode fs.FileMode
+}
+
+// copyHostFiles copies the host files into the tree and reports them.
+// Missing parent directories are created, as with the implicit parent
+// directories of extracted content, but paths already in the tree are
+// never replaced.
+func copyHostFiles(targetDir string, hostFiles []HostFile, report *manifestutil.Report) error {
+	for _, hostFile := range hostFiles {
+		_, err := os.Lstat(filepath.Join(targetDir, hostFile.Path))
+		if err == nil {
+			return fmt.Errorf("cannot copy host file to %s: path already exists in the tree", hostFile.Path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		source, err := os.Open(hostFile.Source)
+		if err != nil {
+			return fmt.Errorf("cannot copy host file: %w", err)
+		}
+		info, err := source.Stat()
+		if err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("%s is not a regular file", hostFile.Source)
+		}
+		if err != nil {
+			source.Close()
+			return fmt.Errorf("cannot copy host file: %w", err)
+		}
+		mode := hostFile.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		debugf("Copying host file %s to %s", hostFile.Source, hostFile.Path)
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        targetDir,
+			Path:        hostFile.Path,
+			Mode:        mode,
+			Data:        source,
+			MakeParents: true,
+		})
+		source.Close()
+		if err != nil {
+			return fmt.Errorf("cannot copy host file to %s: %w", hostFile.Path, err)
+		}
+		err = report.AddHost(entry, hostFile.Source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package slicer
+
+import (
+	"bytes"
+	"io/fs"
+	"maps"
+	"slices"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// layer is the content written to a path with an overwrite policy by some
+// of the slices listing it.
+type layer struct {
+	slices []*setup.Slice
+	data   []byte
+	mode   fs.FileMode
+}
+
+// layeredPath holds the layers written to a path with an overwrite policy,
+// in the order they are written.
+type layeredPath struct {
+	policy setup.OverwritePolicy
+	layers []*layer
+}
+
+// add records that the slices write data to the path. Slices writing the
+// same content as an earlier layer are attributed to it instead.
+func (lp *layeredPath) add(slices []*setup.Slice, data []byte, mode fs.FileMode) {
+	for _, l := range lp.layers {
+		if l.mode == mode && bytes.Equal(l.data, data) {
+			l.slices = append(l.slices, slices...)
+			return
+		}
+	}
+	lp.layers = append(lp.layers, &layer{slices: slices, data: data, mode: mode})
+}
+
+// layeredPaths returns the paths of the selection with an overwrite policy,
+// other than failing, listed by slices for the architecture.
+func layeredPaths(selection *setup.Selection, arch func(pkg string) string) map[string]*layeredPath {
+	paths := make(map[string]*layeredPath)
+	for _, slice := range selection.Slices {
+		for relPath, pathInfo := range slice.Contents {
+			if pathInfo.Overwrite == "" || pathInfo.Overwrite == setup.OverwriteError {
+				continue
+			}
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch(slice.Package)) {
+				continue
+			}
+			paths[relPath] = &layeredPath{policy: pathInfo.Overwrite}
+		}
+	}
+	return paths
+}
+
+// writeLayered creates the paths with an overwrite policy from the layers
+// written to them, and reports them as provided by all the slices listing
+// them.
+func writeLayered(targetDir string, paths map[string]*layeredPath, report *manifestutil.Report, knownPaths map[string]pathData, db *ownership.DB) error {
+	for _, relPath := range slices.Sorted(maps.Keys(paths)) {
+		lp := paths[relPath]
+		if len(lp.layers) == 0 {
+			continue
+		}
+		var data []byte
+		mode := lp.layers[0].mode
+		switch lp.policy {
+		case setup.OverwriteKeepFirst:
+			data = lp.layers[0].data
+		case setup.OverwriteLast:
+			last := lp.layers[len(lp.layers)-1]
+			data, mode = last.data, last.mode
+		case setup.OverwriteMerge:
+			for _, l := range lp.layers {
+				data = append(data, l.data...)
+				if len(l.data) > 0 && l.data[len(l.data)-1] != '\n' {
+					data = append(data, '\n')
+				}
+			}
+		}
+		if len(lp.layers) > 1 {
+			debugf("Path %s written by %d layers with the %s overwrite policy", relPath, len(lp.layers), lp.policy)
+		}
+
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        targetDir,
+			Path:        relPath,
+			Mode:        mode,
+			Data:        bytes.NewReader(data),
+			MakeParents: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		var pathSlices []*setup.Slice
+		for _, l := range lp.layers {
+			pathSlices = append(pathSlices, l.slices...)
+		}
+		until := setup.UntilMutate
+		label := ""
+		for _, slice := range pathSlices {
+			pathInfo := slice.Contents[relPath]
+			if pathInfo.Until == setup.UntilNone {
+				until = setup.UntilNone
+			}
+			if label == "" {
+				label = pathInfo.Label
+			}
+		}
+		addKnownPath(knownPaths, relPath, pathData{until: until, mutableBy: pathSlices})
+		if db != nil {
+			db.SetLabel(relPath, label)
+		}
+		if until != setup.UntilMutate {
+			for _, slice := range pathSlices {
+				err := report.Add(slice, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package slicer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+)
+
+// compilePythonScript reads lines in the format "<source>\t<output>\t<name>"
+// and compiles each source into output, recording name as the path of the
+// source. Hash based pycs are used so that the result is reproducible and
+// does not depend on the modification time of the sources.
+const compilePythonScript = `
+import py_compile, sys
+for line in sys.stdin:
+    src, out, name = line.rstrip("\n").split("\t")
+    py_compile.compile(src, cfile=out, dfile=name, doraise=True,
+        invalidation_mode=py_compile.PycInvalidationMode.CHECKED_HASH)
+`
+
+// compilePython byte-compiles the Python sources in the report with the
+// given interpreter, writing the result to the __pycache__ directories next
+// to them, as the postinst scripts of Python packages would. The compiled
+// files are reported as part of the same slices as their sources.
+func compilePython(interpreter string, report *manifestutil.Report) error {
+	var sources []string
+	for path, entry := range report.Entries {
+		if strings.HasSuffix(path, ".py") && entry.Mode.IsRegular() {
+			sources = append(sources, path)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	slices.Sort(sources)
+	logf("Compiling Python sources...")
+
+	output, err := exec.Command(interpreter, "-c", "import sys; print(sys.implementation.cache_tag)").Output()
+	if err != nil {
+		return fmt.Errorf("cannot obtain Python cache tag from %s: %w", interpreter, err)
+	}
+	cacheTag := strings.TrimSpace(string(output))
+	if cacheTag == "" {
+		return fmt.Errorf("cannot obtain Python cache tag from %s: no output", interpreter)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "chisel-pyc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var input bytes.Buffer
+	for i, source := range sources {
+		fmt.Fprintf(&input, "%s\t%s\t%s\n", filepath.Join(report.Root, source), filepath.Join(tmpDir, fmt.Sprint(i)), source)
+	}
+	cmd := exec.Command(interpreter, "-c", compilePythonScript)
+	cmd.Stdin = &input
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("cannot compile Python sources: %s", msg)
+		}
+		return fmt.Errorf("cannot compile Python sources: %w", err)
+	}
+
+	for i, source := range sources {
+		data, err := os.ReadFile(filepath.Join(tmpDir, fmt.Sprint(i)))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(source), ".py") + "." + cacheTag + ".pyc"
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        report.Root,
+			Path:        filepath.Join(filepath.Dir(source), "__pycache__", name),
+			Mode:        0644,
+			Data:        bytes.NewReader(data),
+			MakeParents: true,
+		})
+		if err != nil {
+			return err
+		}
+		for slice := range report.Entries[source].Slices {
+			err := report.Add(slice, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package slicer
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// SetuidPolicy decides what happens to the regular files extracted from
+// packages with setuid or setgid bits. Whatever the policy, such files are
+// recorded in the security entry of the manifests.
+type SetuidPolicy string
+
+const (
+	// SetuidAllow creates the files as they are in the packages. It is the
+	// policy used when none is set.
+	SetuidAllow SetuidPolicy = "allow"
+	// SetuidWarn creates the files as they are and logs a warning.
+	SetuidWarn SetuidPolicy = "warn"
+	// SetuidStrip creates the files without the setuid and setgid bits.
+	SetuidStrip SetuidPolicy = "strip"
+	// SetuidFail fails the cut.
+	SetuidFail SetuidPolicy = "fail"
+)
+
+// privilegedBits returns a description of the setuid and setgid bits set in
+// mode, or an empty string if there are none.
+func privilegedBits(mode fs.FileMode) string {
+	switch mode & (fs.ModeSetuid | fs.ModeSetgid) {
+	case fs.ModeSetuid:
+		return "setuid"
+	case fs.ModeSetgid:
+		return "setgid"
+	case fs.ModeSetuid | fs.ModeSetgid:
+		return "setuid and setgid"
+	}
+	return ""
+}
+
+// apply returns the mode the file at relPath is created with, or an error
+// if the policy rejects it.
+func (p SetuidPolicy) apply(relPath string, mode fs.FileMode) (fs.FileMode, error) {
+	bits := privilegedBits(mode)
+	if bits == "" || !mode.IsRegular() {
+		return mode, nil
+	}
+	switch p {
+	case SetuidWarn:
+		logf("Warning: Path %s is %s", relPath, bits)
+	case SetuidStrip:
+		debugf("Clearing the %s bits of %s", bits, relPath)
+		mode &^= fs.ModeSetuid | fs.ModeSetgid
+	case SetuidFail:
+		return 0, fmt.Errorf("cannot create %s: %s bits rejected by the setuid policy", relPath, bits)
+	}
+	return mode, nil
+}
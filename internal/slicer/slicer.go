@@ -3,24 +3,34 @@ package slicer
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strings"
-	"syscall"
-
-	"github.com/klauspost/compress/zstd"
 
 	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cacerts"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/copyright"
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/ldcache"
 	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/metrics"
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/policy"
 	"github.com/canonical/chisel/internal/scripts"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/sysusers"
+	"github.com/canonical/chisel/internal/tmpfiles"
+	"github.com/canonical/chisel/internal/tracing"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 const manifestMode fs.FileMode = 0644
@@ -28,26 +38,135 @@ const manifestMode fs.FileMode = 0644
 type RunOptions struct {
 	Selection *setup.Selection
 	Archives  map[string]archive.Archive
+	// Local optionally provides packages which take precedence over the
+	// ones found in Archives, regardless of priorities or pinning.
+	Local     archive.Archive
 	TargetDir string
+	// DpkgStatus enables writing the dpkg status database with the
+	// packages which had content extracted.
+	DpkgStatus bool
+	// Locales, when not nil, lists the only locales which are extracted.
+	Locales []string
+	// Timezones, when not nil, lists the only timezones which are
+	// extracted.
+	Timezones []string
+	// PythonInterpreter, when set, is used to byte-compile the extracted
+	// Python sources. It must match the Python version in the root.
+	PythonInterpreter string
+	// LDConfig enables generating the dynamic linker cache for the
+	// libraries extracted.
+	LDConfig bool
+	// Sysusers and Tmpfiles enable the creation of the users, groups and
+	// paths declared in the sysusers.d and tmpfiles.d configuration
+	// files of the root, as systemd would do at boot.
+	Sysusers bool
+	Tmpfiles bool
+	// CheckPackage, when set, is called with the name of the archive and
+	// the information of each package before it is fetched. An error
+	// stops the run.
+	CheckPackage func(archive string, info *archive.PackageInfo) error
+	// CheckLicenses, when set, is called with the name of each package
+	// fetched and the licenses declared in its copyright file, before any
+	// content is extracted. An error stops the run.
+	CheckLicenses func(pkg string, licenses []string) error
+	// Policy, when set, decides whether the inputs of the run are allowed.
+	// The selection is checked before anything else, and the packages and
+	// their licenses after CheckPackage and CheckLicenses. A denied input
+	// stops the run.
+	Policy policy.Policy
+	// Generators create the content of the paths with the respective
+	// custom "generate" kinds, after all the content is extracted.
+	Generators map[setup.GenerateKind]Generator
+	// HostFiles are copied into the tree once the mutation scripts ran,
+	// and recorded in the manifests with the path they were copied from.
+	HostFiles []HostFile
+	// Copyright enables recording in the manifest the licenses declared in
+	// the copyright file of every package with content. The copyright
+	// files are also extracted, even when not listed in the slices, unless
+	// ExcludeCopyrightFiles is set. Extracted copyright files are not
+	// reported as part of any slice.
+	Copyright             bool
+	ExcludeCopyrightFiles bool
+	// Changelogs enables recording in the manifest the location of the
+	// changelog of every package with content, when one is published for
+	// the package version.
+	Changelogs bool
+	// Ownership, when set, records the owners of the paths extracted from
+	// packages and the SELinux labels of the paths, which are not applied
+	// to the filesystem. Labels declared in the slices override the ones
+	// carried by the packages. Only the paths present in the final tree
+	// are kept.
+	Ownership *ownership.DB
+	// ManifestEncoding is the format the manifests are written in,
+	// manifestutil.EncodingZstd by default.
+	ManifestEncoding manifestutil.Encoding
+	// ReportManifest optionally receives the manifest of the tree in the
+	// manifestutil.EncodingJSON format, even when no selected slice
+	// generates a manifest.
+	ReportManifest io.Writer
+	// ManifestBuild optionally records in the manifests how the tree was
+	// produced.
+	ManifestBuild *manifest.Build
+	// SymlinkPolicy decides what happens to the symlinks extracted from
+	// packages with absolute targets or targets escaping the root. Rewritten
+	// symlinks record their original target in the manifests.
+	SymlinkPolicy SymlinkPolicy
+	// Normalize optionally normalizes the permissions of all the paths in
+	// the tree once all the content is created, with the manifests
+	// recording the final modes.
+	Normalize *fsutil.NormalizeOptions
+	// SetuidPolicy decides what happens to the regular files extracted
+	// from packages with setuid or setgid bits, which are recorded in the
+	// manifests with the policy.
+	SetuidPolicy SetuidPolicy
+	// Dedup optionally makes the regular files with identical content
+	// share their storage once the tree is complete.
+	Dedup DedupMode
+	// UnpackCache optionally keeps the packages unpacked in the cache, with
+	// the content of their files stored once. Later runs with the same
+	// packages read them from the cache instead of decompressing them, and
+	// clone the content into the tree where the filesystem supports it.
+	// Runs extracting the same paths from a package replay the previous
+	// extraction without reading the package at all.
+	UnpackCache *cache.Cache
+	// JournalDir optionally keeps the records of the packages extracted
+	// into TargetDir, so that running the same cut into TargetDir after an
+	// interruption keeps the content of the packages already extracted
+	// rather than extracting them again.
+	JournalDir string
+	// Context optionally cancels the run, which then stops at the next
+	// package fetched, entry extracted or step of a mutation script. The
+	// archives must be opened with the same context for their downloads to
+	// be cancelled as well.
+	Context context.Context
 }
 
 type pathData struct {
-	until    setup.PathUntil
-	mutable  bool
-	hardLink bool
+	until setup.PathUntil
+	// mutableBy lists the slices which declare the path as mutable.
+	mutableBy []*setup.Slice
+	hardLink  bool
 }
 
+// contentChecker validates the access of the mutation script of slice to
+// the content. The content of all the selected slices may be read, but
+// only paths declared as mutable by the slice itself may be written to.
 type contentChecker struct {
 	knownPaths map[string]pathData
+	slice      *setup.Slice
 }
 
 func (cc *contentChecker) checkMutable(path string) error {
-	if !cc.knownPaths[path].mutable {
+	data := cc.knownPaths[path]
+	if len(data.mutableBy) == 0 {
 		return fmt.Errorf("cannot write file which is not mutable: %s", path)
 	}
-	if cc.knownPaths[path].hardLink {
+	if data.hardLink {
 		return fmt.Errorf("cannot mutate a hard link: %s", path)
 	}
+	if !slices.Contains(data.mutableBy, cc.slice) {
+		return fmt.Errorf("cannot write file which is only mutable by other slices: %s", path)
+	}
 	return nil
 }
 
@@ -75,11 +194,142 @@ func (cc *contentChecker) checkKnown(path string) error {
 	return err
 }
 
+// ExtractError reports a failure to extract the content of a package.
+type ExtractError struct {
+	Package string
+	Err     error
+}
+
+func (e *ExtractError) Error() string { return e.Err.Error() }
+func (e *ExtractError) Unwrap() error { return e.Err }
+
+// samePath holds the content extracted for a path which multiple packages
+// may provide.
+type samePath struct {
+	pkg   string
+	entry fsutil.Entry
+}
+
+// checkSamePath records the entry extracted from pkg for the path, and
+// returns an error if another package has provided different content for it,
+// noting when one of the packages declares that it replaces the other.
+func checkSamePath(samePaths map[string]samePath, path string, pkg string, entry *fsutil.Entry, infos map[string]*archive.PackageInfo) error {
+	old, ok := samePaths[path]
+	if !ok {
+		samePaths[path] = samePath{pkg: pkg, entry: *entry}
+		return nil
+	}
+	if old.pkg == pkg {
+		return nil
+	}
+	if old.entry.Mode != entry.Mode || old.entry.SHA256 != entry.SHA256 || old.entry.Link != entry.Link {
+		pkgs := []string{old.pkg, pkg}
+		slices.Sort(pkgs)
+		for i, name := range pkgs {
+			info, other := infos[name], infos[pkgs[1-i]]
+			if info == nil || other == nil {
+				continue
+			}
+			for _, relation := range info.Replaces {
+				if relation.Matches(other.Name, other.Version) {
+					return fmt.Errorf("packages %s and %s have different content on %s (%s replaces %s)",
+						pkgs[0], pkgs[1], path, name, relation)
+				}
+			}
+		}
+		return fmt.Errorf("packages %s and %s have different content on %s", pkgs[0], pkgs[1], path)
+	}
+	return nil
+}
+
+// readConffiles returns the conffiles of the package, leaving the reader at
+// its start.
+func readConffiles(reader io.ReadSeeker) ([]string, error) {
+	conffiles, err := deb.ReadConffiles(reader)
+	if err != nil {
+		return nil, err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return conffiles, nil
+}
+
+// markConffiles marks the entries of the report extracted from the
+// conffiles of the packages of their slices.
+func markConffiles(report *manifestutil.Report, conffiles map[string][]string) {
+	for path, entry := range report.Entries {
+		pkgPath := path
+		if entry.DivertedFrom != "" {
+			pkgPath = entry.DivertedFrom
+		}
+		for slice := range entry.Slices {
+			if slices.Contains(conffiles[slice.Package], pkgPath) {
+				entry.Conffile = true
+				report.Entries[path] = entry
+				break
+			}
+		}
+	}
+}
+
+// checkEssentialVersions returns an error if the version of a package in
+// the archive it is fetched from is not accepted by the version constraint
+// of an essential on one of its slices.
+func checkEssentialVersions(selection *setup.Selection, pkgArchive map[string]archive.Archive) error {
+	for _, slice := range selection.Slices {
+		arch := pkgArchive[slice.Package].Options().Arch
+		for key, info := range slice.Essential {
+			if info.Op == "" || (len(info.Arch) > 0 && !slices.Contains(info.Arch, arch)) {
+				continue
+			}
+			essArchive, ok := pkgArchive[key.Package]
+			if !ok {
+				continue
+			}
+			pkgInfo, err := essArchive.Info(key.Package)
+			if err != nil {
+				return err
+			}
+			relation := archive.Relation{Name: key.Package, Op: info.Op, Version: info.Version}
+			if !relation.Matches(key.Package, pkgInfo.Version) {
+				return fmt.Errorf("slice %s requires %s (%s %s), archive %q has version %s",
+					slice, key, info.Op, info.Version, essArchive.Options().Label, pkgInfo.Version)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRelations warns about the packages which declare that they conflict
+// with or break other packages in infos, as they are not meant to be
+// installed together.
+func checkRelations(infos []*archive.PackageInfo) {
+	for _, info := range infos {
+		fields := []struct {
+			name      string
+			relations []archive.Relation
+		}{
+			{"Conflicts", info.Conflicts},
+			{"Breaks", info.Breaks},
+		}
+		for _, field := range fields {
+			for _, relation := range field.relations {
+				for _, other := range infos {
+					if other.Name != info.Name && relation.Matches(other.Name, other.Version) {
+						logf("Warning: Package %s declares \"%s: %s\" and %s %s is selected too",
+							info.Name, field.name, relation, other.Name, other.Version)
+					}
+				}
+			}
+		}
+	}
+}
+
 func Run(options *RunOptions) error {
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
 
 	targetDir := filepath.Clean(options.TargetDir)
 	if !filepath.IsAbs(targetDir) {
@@ -90,7 +340,28 @@ func Run(options *RunOptions) error {
 		targetDir = filepath.Join(dir, targetDir)
 	}
 
-	pkgArchive, err := selectPkgArchives(options.Archives, options.Selection)
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if options.Policy != nil {
+		err := options.Policy.CheckSelection(options.Selection)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := checkGenerators(options.Selection, options.Generators)
+	if err != nil {
+		return err
+	}
+
+	pkgArchive, err := selectPkgArchives(options.Archives, options.Local, options.Selection)
+	if err != nil {
+		return err
+	}
+	err = checkEssentialVersions(options.Selection, pkgArchive)
 	if err != nil {
 		return err
 	}
@@ -99,6 +370,19 @@ func Run(options *RunOptions) error {
 	if err != nil {
 		return err
 	}
+	layered := layeredPaths(options.Selection, func(pkg string) string {
+		return pkgArchive[pkg].Options().Arch
+	})
+
+	// Record the slices diverting paths, and the paths content of other
+	// packages was moved from.
+	diverts := make(map[string]*setup.Slice)
+	divertedFrom := make(map[string]string)
+	for _, slice := range options.Selection.Slices {
+		for divPath := range slice.Divert {
+			diverts[divPath] = slice
+		}
+	}
 
 	// Build information to process the selection.
 	extract := make(map[string]map[string][]deb.ExtractInfo)
@@ -125,8 +409,13 @@ func Run(options *RunOptions) error {
 				if sourcePath == "" {
 					sourcePath = targetPath
 				}
+				extractPath := targetPath
+				if div, ok := diverts[targetPath]; ok && div.Package != slice.Package && pathInfo.Kind == setup.CopyPath {
+					extractPath = div.Divert[targetPath]
+					divertedFrom[extractPath] = targetPath
+				}
 				extractPackage[sourcePath] = append(extractPackage[sourcePath], deb.ExtractInfo{
-					Path:    targetPath,
+					Path:    extractPath,
 					Context: slice,
 				})
 			} else {
@@ -145,21 +434,107 @@ func Run(options *RunOptions) error {
 		}
 	}
 
+	if options.Copyright && !options.ExcludeCopyrightFiles {
+		for pkg, extractPackage := range extract {
+			copyrightPath := copyright.Path(pkg)
+			extractPackage[copyrightPath] = append(extractPackage[copyrightPath], deb.ExtractInfo{
+				Path:     copyrightPath,
+				Optional: true,
+			})
+		}
+	}
+
+	// Each phase is timed and traced until the next one starts.
+	phaseCtx, endPhase := startPhase(ctx, metrics.PhaseFetch)
+	defer func() { endPhase() }()
+
 	// Fetch all packages, using the selection order.
 	packages := make(map[string]io.ReadSeekCloser)
+	conffiles := make(map[string][]string)
 	var pkgInfos []*archive.PackageInfo
 	for _, slice := range options.Selection.Slices {
 		if packages[slice.Package] != nil {
 			continue
 		}
-		reader, info, err := pkgArchive[slice.Package].Fetch(slice.Package)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkgArch := pkgArchive[slice.Package]
+		if options.CheckPackage != nil || options.Policy != nil {
+			info, err := pkgArch.Info(slice.Package)
+			if err != nil {
+				return err
+			}
+			if options.CheckPackage != nil {
+				err = options.CheckPackage(pkgArch.Options().Label, info)
+				if err != nil {
+					return err
+				}
+			}
+			if options.Policy != nil {
+				err = options.Policy.CheckPackage(pkgArch.Options().Label, info)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, span := tracing.Start(phaseCtx, "fetch-package")
+		span.SetAttribute("package", slice.Package)
+		span.SetAttribute("archive", pkgArch.Options().Label)
+		reader, info, err := pkgArch.Fetch(slice.Package)
+		span.SetError(err)
+		span.Finish()
 		if err != nil {
 			return err
 		}
 		defer reader.Close()
+		conffiles[slice.Package], err = readConffiles(reader)
+		if err != nil {
+			return fmt.Errorf("cannot read conffiles of package %q: %w", slice.Package, err)
+		}
 		packages[slice.Package] = reader
 		pkgInfos = append(pkgInfos, info)
 	}
+	checkRelations(pkgInfos)
+	infoByName := make(map[string]*archive.PackageInfo, len(pkgInfos))
+	for _, info := range pkgInfos {
+		infoByName[info.Name] = info
+	}
+
+	endPhase()
+	_, endPhase = startPhase(ctx, metrics.PhaseVerify)
+
+	var licenses map[string][]string
+	if options.Copyright || options.CheckLicenses != nil || options.Policy != nil {
+		licenses, err = readLicenses(packages)
+		if err != nil {
+			return err
+		}
+	}
+	for _, info := range pkgInfos {
+		if options.CheckLicenses != nil {
+			err = options.CheckLicenses(info.Name, licenses[info.Name])
+			if err != nil {
+				return err
+			}
+		}
+		if options.Policy != nil {
+			err = options.Policy.CheckLicenses(info.Name, licenses[info.Name])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !options.Copyright {
+		licenses = nil
+	}
+	var changelogs map[string]string
+	if options.Changelogs {
+		changelogs = make(map[string]string)
+		for _, info := range pkgInfos {
+			changelogs[info.Name] = archive.ChangelogURL(pkgArchive[info.Name], info.Name)
+		}
+	}
 
 	// When creating content, record if a path is known and whether they are
 	// listed as until: mutate in all the slices that reference them.
@@ -175,21 +550,85 @@ func Run(options *RunOptions) error {
 	notInSliceContents := map[string]fs.FileMode{}
 	// Record directories which may be an implicit conflict.
 	var implicitConflicts []string
+	// Record the package and entry of paths which other packages may
+	// provide as well when their content is the same.
+	samePaths := map[string]samePath{}
+	// Record the original target of the symlinks rewritten by the policy.
+	originalLinks := map[string]string{}
 	// Creates the filesystem entry and adds it to the report. It also updates
 	// knownPaths with the files created.
-	create := func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+	trim := &trimmer{
+		locales:   options.Locales,
+		timezones: options.Timezones,
+		excluded:  options.Selection.ExcludedPaths,
+	}
+	var create func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error
+	create = func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+		relPath := filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(o.Path, targetDir)))
+		if o.Mode.IsDir() {
+			relPath = relPath + "/"
+		}
+		if trim.skip(relPath) {
+			return trim.hold(relPath, extractInfos, o)
+		}
+		for _, held := range trim.release(relPath, o) {
+			err := create(held.extractInfos, &held.options)
+			if err != nil {
+				return err
+			}
+		}
+		if o.Mode.IsRegular() && privilegedBits(o.Mode) != "" {
+			mode, err := options.SetuidPolicy.apply(relPath, o.Mode)
+			if err != nil {
+				return err
+			}
+			report.Privileged[relPath] = o.Mode
+			o.Mode = mode
+		}
+		if lp, ok := layered[relPath]; ok {
+			// The content is written once all the layers are known.
+			if !o.Mode.IsRegular() || o.Link != "" {
+				return fmt.Errorf("cannot extract %s with overwrite policy: not a regular file", relPath)
+			}
+			data, err := io.ReadAll(o.Data)
+			if err != nil {
+				return err
+			}
+			var layerSlices []*setup.Slice
+			for _, extractInfo := range extractInfos {
+				if slice, ok := extractInfo.Context.(*setup.Slice); ok {
+					layerSlices = append(layerSlices, slice)
+				}
+			}
+			lp.add(layerSlices, data, o.Mode)
+			return nil
+		}
+
+		originalLink := ""
+		if o.Mode&fs.ModeSymlink != 0 {
+			link, err := options.SymlinkPolicy.apply(relPath, o.Link)
+			if err != nil {
+				return err
+			}
+			if link != o.Link {
+				originalLink = o.Link
+				o.Link = link
+			}
+		}
 		entry, err := fsutil.Create(o)
 		if err != nil {
 			return err
 		}
-
-		relPath := filepath.Clean("/" + strings.TrimPrefix(o.Path, targetDir))
-		if o.Mode.IsDir() {
-			relPath = relPath + "/"
+		if originalLink != "" {
+			originalLinks[relPath] = originalLink
+		}
+		if options.Ownership != nil {
+			options.Ownership.Set(relPath, ownership.Owner{UID: o.UID, GID: o.GID})
 		}
 		inSliceContents := false
 		until := setup.UntilMutate
-		mutable := false
+		var mutableBy []*setup.Slice
+		label := o.Label
 		for _, extractInfo := range extractInfos {
 			if extractInfo.Context == nil {
 				continue
@@ -199,14 +638,28 @@ func Run(options *RunOptions) error {
 				return fmt.Errorf("internal error: invalid Context of type %T in extractInfo", extractInfo.Context)
 			}
 			pathInfo, ok := slice.Contents[extractInfo.Path]
+			if !ok {
+				pathInfo, ok = slice.Contents[divertedFrom[extractInfo.Path]]
+			}
 			if !ok {
 				return fmt.Errorf("internal error: path %q not listed in slice contents", extractInfo.Path)
 			}
 			inSliceContents = true
-			mutable = mutable || pathInfo.Mutable
+			if pathInfo.Same {
+				err := checkSamePath(samePaths, relPath, slice.Package, entry, infoByName)
+				if err != nil {
+					return err
+				}
+			}
+			if pathInfo.Mutable {
+				mutableBy = append(mutableBy, slice)
+			}
 			if pathInfo.Until == setup.UntilNone {
 				until = setup.UntilNone
 			}
+			if pathInfo.Label != "" {
+				label = pathInfo.Label
+			}
 			// Do not add paths with "until: mutate".
 			if pathInfo.Until != setup.UntilMutate {
 				err := report.Add(slice, entry)
@@ -216,11 +669,15 @@ func Run(options *RunOptions) error {
 			}
 		}
 
+		if options.Ownership != nil {
+			options.Ownership.SetLabel(relPath, label)
+		}
+
 		if inSliceContents {
 			data := pathData{
-				mutable:  mutable,
-				until:    until,
-				hardLink: entry.Mode.IsRegular() && entry.Link != "",
+				mutableBy: mutableBy,
+				until:     until,
+				hardLink:  entry.Mode.IsRegular() && entry.Link != "",
 			}
 			addKnownPath(knownPaths, relPath, data)
 		} else {
@@ -232,22 +689,34 @@ func Run(options *RunOptions) error {
 		return nil
 	}
 
+	endPhase()
+	_, endPhase = startPhase(ctx, metrics.PhaseExtract)
+
 	// Extract all packages, also using the selection order.
 	for _, slice := range options.Selection.Slices {
 		reader := packages[slice.Package]
 		if reader == nil {
 			continue
 		}
-		err := deb.Extract(reader, &deb.ExtractOptions{
-			Package:   slice.Package,
-			Extract:   extract[slice.Package],
-			TargetDir: targetDir,
-			Create:    create,
-		})
+		extractOptions := &deb.ExtractOptions{
+			Package:    slice.Package,
+			Extract:    extract[slice.Package],
+			TargetDir:  targetDir,
+			Create:     create,
+			Context:    ctx,
+			Cache:      options.UnpackCache,
+			Digest:     infoByName[slice.Package].SHA256,
+			JournalDir: options.JournalDir,
+		}
+		if options.Selection.Release.UsrMerge {
+			extractOptions.MapPath = setup.UsrMergePath
+			extractOptions.MapPathKey = "usrmerge"
+		}
+		err := deb.Extract(reader, extractOptions)
 		reader.Close()
 		packages[slice.Package] = nil
 		if err != nil {
-			return err
+			return &ExtractError{Package: slice.Package, Err: err}
 		}
 	}
 
@@ -261,6 +730,19 @@ func Run(options *RunOptions) error {
 			logf("Warning: Path %q has diverging modes in different packages. Please report.", path)
 		}
 	}
+	for path, link := range originalLinks {
+		if entry, ok := report.Entries[path]; ok {
+			entry.OriginalLink = link
+			report.Entries[path] = entry
+		}
+	}
+	for path, from := range divertedFrom {
+		if entry, ok := report.Entries[path]; ok {
+			entry.DivertedFrom = from
+			report.Entries[path] = entry
+		}
+	}
+	markConffiles(report, conffiles)
 
 	// Create new content not extracted from packages, e.g. TextPath or DirPath
 	// with {make: true}. The only exception is the manifest which will be created
@@ -281,6 +763,14 @@ func Run(options *RunOptions) error {
 			if preferredPkg, ok := prefers[relPath]; ok && preferredPkg.Name != slice.Package {
 				continue
 			}
+			if lp, ok := layered[relPath]; ok {
+				mode := fs.FileMode(pathInfo.Mode)
+				if mode == 0 {
+					mode = 0644
+				}
+				lp.add([]*setup.Slice{slice}, []byte(pathInfo.Info), mode)
+				continue
+			}
 			relPaths[relPath] = append(relPaths[relPath], slice)
 		}
 	}
@@ -299,14 +789,19 @@ func Run(options *RunOptions) error {
 		pathInfo := slices[0].Contents[relPath]
 		pathInfo.Until = until
 		data := pathData{
-			until:   pathInfo.Until,
-			mutable: pathInfo.Mutable,
+			until: pathInfo.Until,
+		}
+		if pathInfo.Mutable {
+			data.mutableBy = slices
 		}
 		addKnownPath(knownPaths, relPath, data)
 		entry, err := createFile(targetDir, relPath, pathInfo)
 		if err != nil {
 			return err
 		}
+		if options.Ownership != nil {
+			options.Ownership.SetLabel(relPath, pathInfo.Label)
+		}
 
 		// Do not add paths with "until: mutate".
 		if pathInfo.Until != setup.UntilMutate {
@@ -319,22 +814,63 @@ func Run(options *RunOptions) error {
 		}
 	}
 
-	// Run mutation scripts. Order is fundamental here as
-	// dependencies must run before dependents.
-	checker := contentChecker{knownPaths}
-	content := &scripts.ContentValue{
-		RootDir:    targetDir,
-		CheckWrite: checker.checkMutable,
-		CheckRead:  checker.checkKnown,
-		OnWrite:    report.Mutate,
+	err = writeLayered(targetDir, layered, report, knownPaths, options.Ownership)
+	if err != nil {
+		return err
 	}
+
+	err = generateConcat(targetDir, options.Selection, pkgArchive, report, knownPaths, options.Ownership)
+	if err != nil {
+		return err
+	}
+
+	err = generateVolatile(targetDir, options.Selection, pkgArchive, report, knownPaths)
+	if err != nil {
+		return err
+	}
+
+	err = generateLibraryPaths(targetDir, options.Selection, report, knownPaths)
+	if err != nil {
+		return err
+	}
+
+	err = generateEnvironment(targetDir, options.Selection, report, knownPaths)
+	if err != nil {
+		return err
+	}
+
+	if options.Selection.Release.UsrMerge {
+		err = createUsrMergeLinks(targetDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	endPhase()
+	_, endPhase = startPhase(ctx, metrics.PhaseMutate)
+
+	// Run mutation scripts. Order is fundamental here as
+	// dependencies must run before dependents. The selection is sorted so
+	// that essentials come first and unrelated slices are sorted by name,
+	// which makes the outcome of scripts mutating shared files predictable.
 	for _, slice := range options.Selection.Slices {
+		checker := &contentChecker{knownPaths: knownPaths, slice: slice}
+		content := &scripts.ContentValue{
+			RootDir:    targetDir,
+			CheckWrite: checker.checkMutable,
+			CheckRead:  checker.checkKnown,
+			OnWrite:    report.Mutate,
+		}
+		if options.Selection.Release.UsrMerge {
+			content.MapPath = setup.UsrMergePath
+		}
 		opts := scripts.RunOptions{
 			Label:  "mutate",
 			Script: slice.Scripts.Mutate,
 			Namespace: map[string]scripts.Value{
 				"content": content,
 			},
+			Context: ctx,
 		}
 		err := scripts.Run(&opts)
 		if err != nil {
@@ -342,18 +878,388 @@ func Run(options *RunOptions) error {
 		}
 	}
 
+	endPhase()
+	_, endPhase = startPhase(ctx, metrics.PhaseGenerate)
+
 	err = removeAfterMutate(targetDir, knownPaths)
 	if err != nil {
 		return err
 	}
 
-	return generateManifests(targetDir, options.Selection, report, pkgInfos)
+	err = copyHostFiles(targetDir, options.HostFiles, report)
+	if err != nil {
+		return err
+	}
+
+	err = generateCACertificates(targetDir, options.Selection, report)
+	if err != nil {
+		return err
+	}
+
+	err = generateCustom(targetDir, options.Selection, options.Generators, report)
+	if err != nil {
+		return err
+	}
+
+	if options.PythonInterpreter != "" {
+		err = compilePython(options.PythonInterpreter, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Sysusers {
+		logf("Creating system users and groups...")
+		entries, err := sysusers.Apply(targetDir)
+		if err != nil {
+			return err
+		}
+		err = reportApplied(report, entries, confSlices(report, sysusers.ConfDirs, options.Selection.Slices))
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Tmpfiles {
+		logf("Creating paths from tmpfiles.d...")
+		entries, err := tmpfiles.Apply(targetDir)
+		if err != nil {
+			return err
+		}
+		err = reportApplied(report, entries, confSlices(report, tmpfiles.ConfDirs, options.Selection.Slices))
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.LDConfig {
+		err = generateLDCache(targetDir, report, options.Selection.Slices)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.DpkgStatus {
+		err = generateDpkgStatus(targetDir, pkgInfos, diverts, options.Selection.Slices, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Ownership != nil {
+		err = pruneOwnership(targetDir, options.Ownership)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Normalize != nil {
+		modes, err := fsutil.Normalize(targetDir, options.Normalize)
+		if err != nil {
+			return err
+		}
+		for path, mode := range modes {
+			if entry, ok := report.Entries[path]; ok {
+				entry.Mode = mode
+				report.Entries[path] = entry
+			}
+		}
+	}
+
+	err = dedupFiles(targetDir, options.Dedup, report, options.Ownership)
+	if err != nil {
+		return err
+	}
+
+	endPhase()
+	_, endPhase = startPhase(ctx, metrics.PhaseManifest)
+
+	return generateManifests(targetDir, options, report, pkgInfos, licenses, changelogs)
+}
+
+// startPhase starts timing and tracing the phase of the cut, and returns
+// the context of its span and the function which ends it.
+func startPhase(ctx context.Context, phase string) (context.Context, func()) {
+	stop := metrics.Start(phase)
+	ctx, span := tracing.Start(ctx, phase)
+	return ctx, func() {
+		stop()
+		span.Finish()
+	}
+}
+
+// pruneOwnership removes from db the paths which are not present in the
+// tree, such as the ones removed after the mutation scripts ran.
+func pruneOwnership(targetDir string, db *ownership.DB) error {
+	for _, path := range db.Paths() {
+		_, err := os.Lstat(filepath.Join(targetDir, path))
+		if os.IsNotExist(err) {
+			db.Delete(path)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateCACertificates creates the certificate bundle and the symlinks
+// used by OpenSSL to look certificates up, in the directories marked with
+// "generate: ca-certificates", based on the certificates present in the root.
+func generateCACertificates(targetDir string, selection *setup.Selection, report *manifestutil.Report) error {
+	dirSlices := make(map[string][]*setup.Slice)
+	for _, slice := range selection.Slices {
+		for path, info := range slice.Contents {
+			if info.Generate == setup.GenerateCACertificates {
+				dir := strings.TrimSuffix(path, "**")
+				dirSlices[dir] = append(dirSlices[dir], slice)
+			}
+		}
+	}
+	if len(dirSlices) == 0 {
+		return nil
+	}
+	certs, err := cacerts.Find(targetDir)
+	if err != nil {
+		return err
+	}
+	links := cacerts.Links(certs)
+	for dir, slices := range dirSlices {
+		logf("Generating CA certificates at %s...", dir)
+		var entries []*fsutil.Entry
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        targetDir,
+			Path:        filepath.Join(dir, cacerts.BundleName),
+			Mode:        0644,
+			Data:        bytes.NewReader(cacerts.Bundle(certs)),
+			MakeParents: true,
+		})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		for _, cert := range certs {
+			entry, err := fsutil.Create(&fsutil.CreateOptions{
+				Root:        targetDir,
+				Path:        filepath.Join(dir, cacerts.PemName(cert)),
+				Mode:        fs.ModeSymlink | 0777,
+				Link:        cert.Path,
+				MakeParents: true,
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		for link, target := range links {
+			entry, err := fsutil.Create(&fsutil.CreateOptions{
+				Root:        targetDir,
+				Path:        filepath.Join(dir, link),
+				Mode:        fs.ModeSymlink | 0777,
+				Link:        target,
+				MakeParents: true,
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		for _, entry := range entries {
+			for _, slice := range slices {
+				err := report.Add(slice, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	dpkgStatusPath     = "/var/lib/dpkg/status"
+	dpkgStatusDirPath  = "/var/lib/dpkg/status.d/"
+	dpkgDiversionsPath = "/var/lib/dpkg/diversions"
+)
+
+// generateDpkgStatus writes a minimal dpkg status database listing the
+// packages as installed, so that tools which inventory images based on it
+// recognize the content. Packages are listed both in the status file and in
+// individual files inside status.d. The paths diverted by the slices are
+// listed in the diversions file, as dpkg-divert would. The files are
+// reported as belonging to the slices they describe.
+func generateDpkgStatus(targetDir string, pkgInfos []*archive.PackageInfo, diverts map[string]*setup.Slice, selected []*setup.Slice, report *manifestutil.Report) error {
+	logf("Generating dpkg status...")
+	pkgSlices := make(map[string]map[*setup.Slice]bool)
+	allSlices := make(map[*setup.Slice]bool)
+	for _, slice := range selected {
+		if pkgSlices[slice.Package] == nil {
+			pkgSlices[slice.Package] = make(map[*setup.Slice]bool)
+		}
+		pkgSlices[slice.Package][slice] = true
+		allSlices[slice] = true
+	}
+	sorted := slices.Clone(pkgInfos)
+	slices.SortFunc(sorted, func(a, b *archive.PackageInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	var status bytes.Buffer
+	for i, info := range sorted {
+		stanza := fmt.Sprintf("Package: %s\nStatus: install ok installed\nVersion: %s\nArchitecture: %s\n",
+			info.Name, info.Version, info.Arch)
+		if i > 0 {
+			status.WriteString("\n")
+		}
+		status.WriteString(stanza)
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        targetDir,
+			Path:        dpkgStatusDirPath + info.Name,
+			Mode:        0644,
+			Data:        strings.NewReader(stanza),
+			MakeParents: true,
+		})
+		if err != nil {
+			return err
+		}
+		err = reportGenerated(report, entry, pkgSlices[info.Name])
+		if err != nil {
+			return err
+		}
+	}
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Root:        targetDir,
+		Path:        dpkgStatusPath,
+		Mode:        0644,
+		Data:        &status,
+		MakeParents: true,
+	})
+	if err != nil {
+		return err
+	}
+	err = reportGenerated(report, entry, allSlices)
+	if err != nil || len(diverts) == 0 {
+		return err
+	}
+	var diversions bytes.Buffer
+	divertSlices := make(map[*setup.Slice]bool)
+	for _, divPath := range slices.Sorted(maps.Keys(diverts)) {
+		div := diverts[divPath]
+		fmt.Fprintf(&diversions, "%s\n%s\n%s\n", divPath, div.Divert[divPath], div.Package)
+		divertSlices[div] = true
+	}
+	entry, err = fsutil.Create(&fsutil.CreateOptions{
+		Root:        targetDir,
+		Path:        dpkgDiversionsPath,
+		Mode:        0644,
+		Data:        &diversions,
+		MakeParents: true,
+	})
+	if err != nil {
+		return err
+	}
+	return reportGenerated(report, entry, divertSlices)
+}
+
+// reportGenerated adds to the report the file generated from the content
+// of the slices. Files replacing content already reported are recorded as
+// mutated instead.
+func reportGenerated(report *manifestutil.Report, entry *fsutil.Entry, slices map[*setup.Slice]bool) error {
+	relPath := filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(entry.Path, report.Root)))
+	if _, ok := report.Entries[relPath]; ok {
+		return report.Mutate(entry)
+	}
+	for slice := range slices {
+		err := report.Add(slice, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportApplied updates the report for the entries created or modified
+// when applying configuration files. Regular files which were extracted
+// before are recorded as mutated, and entries which did not exist are
+// reported as belonging to slices. Other entries are not reported.
+func reportApplied(report *manifestutil.Report, entries []*fsutil.Entry, slices map[*setup.Slice]bool) error {
+	for _, entry := range entries {
+		relPath := filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(entry.Path, report.Root)))
+		if entry.Mode.IsDir() {
+			relPath += "/"
+		}
+		reported, ok := report.Entries[relPath]
+		if ok && (!entry.Mode.IsRegular() || !reported.Mode.IsRegular()) {
+			continue
+		}
+		err := reportGenerated(report, entry, slices)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confSlices returns the slices which own the configuration files found in
+// confDirs, or all the selected slices if none is reported as owning them.
+func confSlices(report *manifestutil.Report, confDirs []string, selected []*setup.Slice) map[*setup.Slice]bool {
+	slices := make(map[*setup.Slice]bool)
+	for relPath, entry := range report.Entries {
+		for _, dir := range confDirs {
+			if strings.HasPrefix(relPath, dir) && relPath != dir {
+				maps.Copy(slices, entry.Slices)
+			}
+		}
+	}
+	if len(slices) == 0 {
+		for _, slice := range selected {
+			slices[slice] = true
+		}
+	}
+	return slices
+}
+
+// generateLDCache writes the cache used by the dynamic linker to look the
+// shared libraries in the root up, as running ldconfig inside it would. The
+// cache is reported as belonging to the slices of the libraries listed, or
+// to all the selected slices if none of the libraries belongs to a slice,
+// as with the libraries already in the root or copied from the host.
+func generateLDCache(targetDir string, report *manifestutil.Report, selected []*setup.Slice) error {
+	libs, err := ldcache.Find(targetDir)
+	if err != nil {
+		return err
+	}
+	if len(libs) == 0 {
+		return nil
+	}
+	logf("Generating %s...", ldcache.CachePath)
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Root:        targetDir,
+		Path:        ldcache.CachePath,
+		Mode:        0644,
+		Data:        bytes.NewReader(ldcache.Cache(libs)),
+		MakeParents: true,
+	})
+	if err != nil {
+		return err
+	}
+	libSlices := make(map[*setup.Slice]bool)
+	for _, lib := range libs {
+		for slice := range report.Entries[lib.Path].Slices {
+			libSlices[slice] = true
+		}
+	}
+	if len(libSlices) == 0 {
+		for _, slice := range selected {
+			libSlices[slice] = true
+		}
+	}
+	return reportGenerated(report, entry, libSlices)
 }
 
-func generateManifests(targetDir string, selection *setup.Selection,
-	report *manifestutil.Report, pkgInfos []*archive.PackageInfo) error {
-	manifestSlices := manifestutil.FindPaths(selection.Slices)
-	if len(manifestSlices) == 0 {
+func generateManifests(targetDir string, options *RunOptions,
+	report *manifestutil.Report, pkgInfos []*archive.PackageInfo, licenses map[string][]string,
+	changelogs map[string]string) error {
+	manifestSlices := manifestutil.FindPaths(options.Selection.Slices)
+	if len(manifestSlices) == 0 && options.ReportManifest == nil {
 		// Nothing to do.
 		return nil
 	}
@@ -363,7 +1269,7 @@ func generateManifests(targetDir string, selection *setup.Selection,
 		createOptions := &fsutil.CreateOptions{
 			Root:        targetDir,
 			Path:        relPath,
-			Mode:        manifestMode,
+			Mode:        options.Normalize.Mode(manifestMode),
 			MakeParents: true,
 		}
 		writer, info, err := fsutil.CreateWriter(createOptions)
@@ -379,18 +1285,27 @@ func generateManifests(targetDir string, selection *setup.Selection,
 			}
 		}
 	}
-	w, err := zstd.NewWriter(io.MultiWriter(writers...))
-	if err != nil {
-		return err
-	}
-	defer w.Close()
 	writeOptions := &manifestutil.WriteOptions{
-		PackageInfo: pkgInfos,
-		Selection:   selection.Slices,
-		Report:      report,
+		PackageInfo:  pkgInfos,
+		Licenses:     licenses,
+		Changelogs:   changelogs,
+		Selection:    options.Selection.Slices,
+		Report:       report,
+		Build:        options.ManifestBuild,
+		SetuidPolicy: string(options.SetuidPolicy),
 	}
-	err = manifestutil.Write(writeOptions, w)
-	return err
+	if options.ReportManifest != nil {
+		// Written once the manifests of the tree are reported, so that
+		// they are listed too.
+		err := manifestutil.WriteEncoded(writeOptions, manifestutil.EncodingJSON, options.ReportManifest)
+		if err != nil {
+			return err
+		}
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+	return manifestutil.WriteEncoded(writeOptions, options.ManifestEncoding, io.MultiWriter(writers...))
 }
 
 // removeAfterMutate removes entries marked with until: mutate. A path is marked
@@ -452,6 +1367,202 @@ func addKnownPath(knownPaths map[string]pathData, path string, data pathData) {
 	}
 }
 
+// generateConcat creates the files marked with "generate: concat" by
+// assembling the fragments declared by the selected slices, sorted by
+// priority and then by slice name. Their labels are recorded in db, if set.
+func generateConcat(targetDir string, selection *setup.Selection, pkgArchive map[string]archive.Archive, report *manifestutil.Report, knownPaths map[string]pathData, db *ownership.DB) error {
+	type fragment struct {
+		slice *setup.Slice
+		info  setup.PathInfo
+	}
+	fragments := make(map[string][]fragment)
+	for _, slice := range selection.Slices {
+		arch := pkgArchive[slice.Package].Options().Arch
+		for relPath, pathInfo := range slice.Contents {
+			if pathInfo.Generate != setup.GenerateConcat {
+				continue
+			}
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+			fragments[relPath] = append(fragments[relPath], fragment{slice, pathInfo})
+		}
+	}
+	for relPath, pathFragments := range fragments {
+		slices.SortFunc(pathFragments, func(a, b fragment) int {
+			if a.info.Priority != b.info.Priority {
+				return a.info.Priority - b.info.Priority
+			}
+			return strings.Compare(a.slice.String(), b.slice.String())
+		})
+		var data strings.Builder
+		for _, f := range pathFragments {
+			data.WriteString(f.info.Info)
+			if f.info.Info != "" && !strings.HasSuffix(f.info.Info, "\n") {
+				data.WriteString("\n")
+			}
+		}
+		// The release validation guarantees that all fragments agree on
+		// the mode.
+		pathInfo := setup.PathInfo{
+			Kind: setup.TextPath,
+			Info: data.String(),
+			Mode: pathFragments[0].info.Mode,
+		}
+		fragmentSlices := make([]*setup.Slice, 0, len(pathFragments))
+		for _, f := range pathFragments {
+			fragmentSlices = append(fragmentSlices, f.slice)
+		}
+		err := createGenerated(targetDir, relPath, pathInfo, fragmentSlices, report, knownPaths)
+		if err != nil {
+			return err
+		}
+		if db != nil {
+			// As with the mode, all fragments agree on the label.
+			db.SetLabel(relPath, pathFragments[0].info.Label)
+		}
+	}
+	return nil
+}
+
+// generateVolatile creates the volatile files, such as /etc/machine-id,
+// declared by the selected slices. They are created empty, for the system
+// to set them when it first boots.
+func generateVolatile(targetDir string, selection *setup.Selection, pkgArchive map[string]archive.Archive, report *manifestutil.Report, knownPaths map[string]pathData) error {
+	volatileSlices := make(map[setup.GenerateKind][]*setup.Slice)
+	for _, slice := range selection.Slices {
+		arch := pkgArchive[slice.Package].Options().Arch
+		for _, pathInfo := range slice.Contents {
+			if _, ok := setup.VolatileFiles[pathInfo.Generate]; !ok {
+				continue
+			}
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+			volatileSlices[pathInfo.Generate] = append(volatileSlices[pathInfo.Generate], slice)
+		}
+	}
+	for kind, genSlices := range volatileSlices {
+		volatile := setup.VolatileFiles[kind]
+		pathInfo := setup.PathInfo{Kind: setup.TextPath, Mode: volatile.Mode}
+		err := createGenerated(targetDir, volatile.Path, pathInfo, genSlices, report, knownPaths)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateLibraryPaths creates a file in /etc/ld.so.conf.d for each package
+// with selected slices declaring library paths.
+func generateLibraryPaths(targetDir string, selection *setup.Selection, report *manifestutil.Report, knownPaths map[string]pathData) error {
+	pkgSlices := make(map[string][]*setup.Slice)
+	for _, slice := range selection.Slices {
+		if len(slice.LibraryPaths) > 0 {
+			pkgSlices[slice.Package] = append(pkgSlices[slice.Package], slice)
+		}
+	}
+	for pkg, pkgSlices := range pkgSlices {
+		var libPaths []string
+		for _, slice := range pkgSlices {
+			libPaths = append(libPaths, slice.LibraryPaths...)
+		}
+		slices.Sort(libPaths)
+		libPaths = slices.Compact(libPaths)
+		var data strings.Builder
+		for _, libPath := range libPaths {
+			data.WriteString(libPath + "\n")
+		}
+		pathInfo := setup.PathInfo{Kind: setup.TextPath, Info: data.String()}
+		err := createGenerated(targetDir, setup.LibraryPathsFile(pkg), pathInfo, pkgSlices, report, knownPaths)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateEnvironment creates /etc/environment with the variables declared
+// by the selected slices, sorted by name.
+func generateEnvironment(targetDir string, selection *setup.Selection, report *manifestutil.Report, knownPaths map[string]pathData) error {
+	var envSlices []*setup.Slice
+	environment := make(map[string]string)
+	for _, slice := range selection.Slices {
+		if len(slice.Environment) == 0 {
+			continue
+		}
+		envSlices = append(envSlices, slice)
+		for name, value := range slice.Environment {
+			// The release validation guarantees that slices agree on
+			// the values.
+			environment[name] = value
+		}
+	}
+	if len(envSlices) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var data strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&data, "%s=\"%s\"\n", name, environment[name])
+	}
+	pathInfo := setup.PathInfo{Kind: setup.TextPath, Info: data.String()}
+	return createGenerated(targetDir, setup.EnvironmentFile, pathInfo, envSlices, report, knownPaths)
+}
+
+// createUsrMergeLinks creates the symlinks of a usrmerged tree, such as /bin
+// pointing to usr/bin, for the directories under /usr with content, unless
+// the path already exists. As with implicit parent directories, the
+// symlinks are not reported as part of any slice.
+func createUsrMergeLinks(targetDir string) error {
+	for _, dir := range setup.UsrMergeDirs {
+		_, err := os.Lstat(filepath.Join(targetDir, "usr", dir))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = os.Lstat(filepath.Join(targetDir, dir))
+		if err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		debugf("Creating usrmerge symlink %s => usr%s", dir, dir)
+		_, err = fsutil.Create(&fsutil.CreateOptions{
+			Root: targetDir,
+			Path: dir,
+			Mode: fs.ModeSymlink | 0777,
+			Link: "usr" + dir,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createGenerated creates the file at relPath and reports it as part of
+// the slices it was generated for.
+func createGenerated(targetDir, relPath string, pathInfo setup.PathInfo, genSlices []*setup.Slice, report *manifestutil.Report, knownPaths map[string]pathData) error {
+	entry, err := createFile(targetDir, relPath, pathInfo)
+	if err != nil {
+		return err
+	}
+	addKnownPath(knownPaths, relPath, pathData{})
+	for _, slice := range genSlices {
+		err := report.Add(slice, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createFile(targetDir, relPath string, pathInfo setup.PathInfo) (*fsutil.Entry, error) {
 	targetMode := pathInfo.Mode
 	if targetMode == 0 {
@@ -489,51 +1600,91 @@ func createFile(targetDir, relPath string, pathInfo setup.PathInfo) (*fsutil.Ent
 	})
 }
 
-// selectPkgArchives selects the highest priority archive containing the package
-// unless a particular archive is pinned within the slice definition file. It
-// returns a map of archives indexed by package names.
-func selectPkgArchives(archives map[string]archive.Archive, selection *setup.Selection) (map[string]archive.Archive, error) {
-	sortedArchives := make([]*setup.Archive, 0, len(selection.Release.Archives))
-	for _, archive := range selection.Release.Archives {
-		if archive.Priority < 0 {
-			// Ignore negative priority archives unless a package specifically
-			// asks for it with the "archive" field.
-			continue
-		}
-		sortedArchives = append(sortedArchives, archive)
-	}
-	slices.SortFunc(sortedArchives, func(a, b *setup.Archive) int {
-		return b.Priority - a.Priority
-	})
-
+// selectPkgArchives selects the archive each package is fetched from with
+// ResolveArchive. The local archive, if any, is always chosen for the
+// packages it contains. It returns a map of archives indexed by package
+// names.
+func selectPkgArchives(archives map[string]archive.Archive, local archive.Archive, selection *setup.Selection) (map[string]archive.Archive, error) {
 	pkgArchive := make(map[string]archive.Archive)
 	for _, s := range selection.Slices {
 		if _, ok := pkgArchive[s.Package]; ok {
 			continue
 		}
 		pkg := selection.Release.Packages[s.Package]
+		if local != nil && local.Exists(pkg.Name) {
+			pkgArchive[pkg.Name] = local
+			continue
+		}
+		chosen, err := ResolveArchive(selection.Release, archives, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("cannot find package %q in archive(s)", pkg.Name)
+		}
+		pkgArchive[pkg.Name] = chosen
+	}
+	return pkgArchive, nil
+}
 
-		var candidates []*setup.Archive
-		if pkg.Archive == "" {
-			// If the package has not pinned any archive, choose the highest
-			// priority archive in which the package exists.
-			candidates = sortedArchives
-		} else {
-			candidates = []*setup.Archive{selection.Release.Archives[pkg.Archive]}
+// ResolveArchive returns the archive the package is fetched from, among the
+// ones it may be fetched from as listed by PackageArchives, following the
+// archive strategy of the release. It returns nil if no archive holds the
+// package.
+func ResolveArchive(release *setup.Release, archives map[string]archive.Archive, pkgName string) (archive.Archive, error) {
+	var candidates []*setup.Archive
+	for _, archiveInfo := range release.PackageArchives(pkgName) {
+		pkgArchive := archives[archiveInfo.Name]
+		if pkgArchive != nil && pkgArchive.Exists(pkgName) {
+			candidates = append(candidates, archiveInfo)
 		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	if len(candidates) == 1 {
+		return archives[candidates[0].Name], nil
+	}
 
-		var chosen archive.Archive
+	names := make([]string, len(candidates))
+	for i, archiveInfo := range candidates {
+		names[i] = archiveInfo.Name
+	}
+	strategy := release.ArchiveStrategy
+	if strategy == "" {
+		strategy = setup.StrategyPriority
+	}
+	chosen := candidates[0]
+	switch strategy {
+	case setup.StrategyVersion:
+		var chosenVersion string
 		for _, archiveInfo := range candidates {
-			archive := archives[archiveInfo.Name]
-			if archive != nil && archive.Exists(pkg.Name) {
-				chosen = archive
+			info, err := archives[archiveInfo.Name].Info(pkgName)
+			if err != nil {
+				return nil, err
+			}
+			debugf("Package %q has version %s in archive %q", pkgName, info.Version, archiveInfo.Name)
+			if chosenVersion == "" || deb.CompareVersions(info.Version, chosenVersion) > 0 {
+				chosen = archiveInfo
+				chosenVersion = info.Version
+			}
+		}
+	case setup.StrategySecurity:
+		for _, archiveInfo := range candidates {
+			if slices.ContainsFunc(archiveInfo.Suites, func(suite string) bool {
+				return strings.HasSuffix(suite, "-security")
+			}) {
+				chosen = archiveInfo
 				break
 			}
 		}
-		if chosen == nil {
-			return nil, fmt.Errorf("cannot find package %q in archive(s)", pkg.Name)
+	case setup.StrategyPinned:
+		if pkg, ok := release.Packages[pkgName]; !ok || len(pkg.Archives) == 0 {
+			return nil, fmt.Errorf("package %q found in archives %s: must list the archive to use with the pinned archive strategy",
+				pkgName, strings.Join(names, ", "))
 		}
-		pkgArchive[pkg.Name] = chosen
 	}
-	return pkgArchive, nil
+	debugf("Package %q found in archives %s, using %q with the %s archive strategy",
+		pkgName, strings.Join(names, ", "), chosen.Name, strategy)
+	return archives[chosen.Name], nil
 }
@@ -2,6 +2,9 @@ package slicer_test
 
 import (
 	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
 	"fmt"
 	"io/fs"
 	"os"
@@ -15,7 +18,10 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/ownership"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
 	"github.com/canonical/chisel/internal/testutil"
@@ -36,8 +42,20 @@ type slicerTest struct {
 	filesystem    map[string]string
 	manifestPaths map[string]string
 	manifestPkgs  map[string]string
-	logOutput     string
-	error         string
+	// manifestPrivileged dumps the privileged paths of the security entry.
+	manifestPrivileged map[string]string
+	ownership          map[string]string
+	logOutput          string
+	error              string
+}
+
+var symlinkPolicyEntries = []testutil.TarEntry{
+	testutil.Dir(0755, "./"),
+	testutil.Dir(0755, "./usr/"),
+	testutil.Dir(0755, "./usr/bin/"),
+	testutil.Lnk(0777, "./usr/bin/absolute", "/etc/file"),
+	testutil.Lnk(0777, "./usr/bin/escaping", "../../../etc/file"),
+	testutil.Lnk(0777, "./usr/bin/relative", "../../etc/file"),
 }
 
 var packageEntries = map[string][]testutil.TarEntry{
@@ -65,6 +83,17 @@ var packageEntries = map[string][]testutil.TarEntry{
 		{Header: tar.Header{Name: "./usr/share/doc/copyright-symlink-openssl/"}},
 		{Header: tar.Header{Name: "./usr/share/doc/copyright-symlink-openssl/copyright", Linkname: "../libssl3/copyright"}},
 	},
+	"ownership": {
+		{Header: tar.Header{Name: "./"}},
+		{Header: tar.Header{Name: "./etc/"}},
+		{Header: tar.Header{Name: "./etc/shadow", Mode: 00640, Gid: 42, Format: tar.FormatPAX, PAXRecords: map[string]string{
+			"SCHILY.xattr.security.selinux": "system_u:object_r:shadow_t:s0\x00",
+		}}},
+		{Header: tar.Header{Name: "./etc/temp", Gid: 42}},
+		{Header: tar.Header{Name: "./var/"}},
+		{Header: tar.Header{Name: "./var/mail/", Mode: 02775, Gid: 8}},
+		{Header: tar.Header{Name: "./var/mail/file", Uid: 1000, Gid: 8}},
+	},
 }
 
 var testPackageCopyrightEntries = []testutil.TarEntry{
@@ -76,6 +105,46 @@ var testPackageCopyrightEntries = []testutil.TarEntry{
 	testutil.Reg(0644, "./usr/share/doc/test-package/copyright", "copyright"),
 }
 
+var testPackageDEP5Entries = []testutil.TarEntry{
+	testutil.Dir(0755, "./usr/"),
+	testutil.Dir(0755, "./usr/share/"),
+	testutil.Dir(0755, "./usr/share/doc/"),
+	testutil.Dir(0755, "./usr/share/doc/test-package/"),
+	testutil.Reg(0644, "./usr/share/doc/test-package/copyright", `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+Files: *
+License: MIT or GPL-2+
+`),
+}
+
+// denyPolicy denies the slices, packages and licenses named deny.
+type denyPolicy struct {
+	deny string
+}
+
+func (p *denyPolicy) CheckSelection(selection *setup.Selection) error {
+	for _, slice := range selection.Slices {
+		if slice.String() == p.deny {
+			return fmt.Errorf("slice %s denied", slice)
+		}
+	}
+	return nil
+}
+
+func (p *denyPolicy) CheckPackage(archive string, info *archive.PackageInfo) error {
+	if info.Name == p.deny {
+		return fmt.Errorf("package %s denied", info.Name)
+	}
+	return nil
+}
+
+func (p *denyPolicy) CheckLicenses(pkg string, licenses []string) error {
+	if slices.Contains(licenses, p.deny) {
+		return fmt.Errorf("license %s of package %s denied", p.deny, pkg)
+	}
+	return nil
+}
+
 var slicerTests = []slicerTest{{
 	summary: "Basic slicing",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -314,6 +383,76 @@ var slicerTests = []slicerTest{{
 		"/bar/":     "dir 0755 {other-package_myslice}",
 		"/file":     "file 0644 fc02ca0e {other-package_myslice}",
 	},
+}, {
+	summary: "Essential version constraints accept the version in the archive",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Version: "1.0",
+		Data:    testutil.PackageData["test-package"],
+	}, {
+		Name:    "other-package",
+		Version: "2.1-1",
+		Data:    testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					essential:
+						- other-package_myslice (>= 2.1)
+					contents:
+						/dir/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+		"/file":     "file 0644 fc02ca0e",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+		"/file":     "file 0644 fc02ca0e {other-package_myslice}",
+	},
+}, {
+	summary: "Essential version constraints reject the version in the archive",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Version: "1.0",
+		Data:    testutil.PackageData["test-package"],
+	}, {
+		Name:    "other-package",
+		Version: "2.0-1",
+		Data:    testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					essential:
+						- slice: other-package_myslice (>= 2.1)
+					contents:
+						/dir/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	error: `slice test-package_myslice requires other-package_myslice \(>= 2.1\), archive "ubuntu" has version 2.0-1`,
 }, {
 	summary: "Install two packages, explicit path has preference over implicit parent",
 	slices: []setup.SliceKey{
@@ -407,6 +546,185 @@ var slicerTests = []slicerTest{{
 	manifestPaths: map[string]string{
 		"/textFile": "file 0644 c6c83d10 {other-package_myslice,test-package_myslice}",
 	},
+}, {
+	summary: "Generate a file from concatenated fragments",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice1"},
+		{"test-package", "myslice2"},
+		{"test-package", "myslice3"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/etc/file: {generate: concat, text: one, priority: 20}
+				myslice2:
+					contents:
+						/etc/file: {generate: concat, text: "two\n", priority: 10}
+				myslice3:
+					contents:
+						/etc/file: {generate: concat, text: "four\n", arch: s390x}
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/etc/file: {generate: concat, text: "three\n", priority: 10}
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":     "dir 0755",
+		"/etc/file": "file 0644 da5482e6",
+	},
+	manifestPaths: map[string]string{
+		"/etc/file": "file 0644 da5482e6 {other-package_myslice,test-package_myslice1,test-package_myslice2}",
+	},
+}, {
+	summary: "Generate volatile files",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice1"},
+		{"test-package", "myslice2"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/etc/machine-id: {generate: machine-id}
+						/etc/hostname: {generate: hostname}
+				myslice2:
+					contents:
+						/etc/resolv.conf: {generate: resolv-conf, arch: s390x}
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/etc/machine-id: {generate: machine-id}
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":           "dir 0755",
+		"/etc/hostname":   "file 0644 empty",
+		"/etc/machine-id": "file 0444 empty",
+	},
+	manifestPaths: map[string]string{
+		"/etc/hostname":   "file 0644 empty {test-package_myslice1}",
+		"/etc/machine-id": "file 0444 empty {other-package_myslice,test-package_myslice1}",
+	},
+}, {
+	summary: "Copy host files",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		source := filepath.Join(c.MkDir(), "app")
+		c.Assert(os.WriteFile(source, []byte("hello"), 0600), IsNil)
+		opts.HostFiles = []slicer.HostFile{
+			{Source: source, Path: "/usr/bin/app", Mode: 0755},
+			{Source: source, Path: "/dir/app.conf"},
+		}
+	},
+	filesystem: map[string]string{
+		"/dir/":         "dir 0755",
+		"/dir/file":     "file 0644 cc55e2ec",
+		"/dir/app.conf": "file 0644 2cf24dba",
+		"/usr/":         "dir 0755",
+		"/usr/bin/":     "dir 0755",
+		"/usr/bin/app":  "file 0755 2cf24dba",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":     "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/app.conf": "file 0644 2cf24dba (host app) {}",
+		"/usr/bin/app":  "file 0755 2cf24dba (host app) {}",
+	},
+}, {
+	summary: "Host files cannot replace paths in the tree",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		source := filepath.Join(c.MkDir(), "app")
+		c.Assert(os.WriteFile(source, []byte("hello"), 0600), IsNil)
+		opts.HostFiles = []slicer.HostFile{{Source: source, Path: "/dir/file"}}
+	},
+	error: `cannot copy host file to /dir/file: path already exists in the tree`,
+}, {
+	summary: "Generate library paths and environment",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice1"},
+		{"test-package", "myslice2"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					library-paths: [/usr/lib/test]
+					environment:
+						B: "2"
+				myslice2:
+					library-paths: [/usr/lib/other, /usr/lib/test]
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					environment:
+						A: "1"
+						B: "2"
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":                               "dir 0755",
+		"/etc/environment":                    "file 0644 20c6f865",
+		"/etc/ld.so.conf.d/":                  "dir 0755",
+		"/etc/ld.so.conf.d/test-package.conf": "file 0644 a329e3ca",
+	},
+	manifestPaths: map[string]string{
+		"/etc/environment":                    "file 0644 20c6f865 {other-package_myslice,test-package_myslice1}",
+		"/etc/ld.so.conf.d/test-package.conf": "file 0644 a329e3ca {test-package_myslice1,test-package_myslice2}",
+	},
 }, {
 	summary: "Script: write a file",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -613,6 +931,68 @@ var slicerTests = []slicerTest{{
 						content.read("/dir/nested/file")
 		`,
 	},
+}, {
+	summary: "Script: scripts run after the ones of essentials",
+	slices:  []setup.SliceKey{{"test-package", "a-slice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				a-slice:
+					essential:
+						- test-package_z-slice
+					contents:
+						/etc/services: {text: "", mutable: true}
+					mutate: |
+						content.write("/etc/services", content.read("/etc/services") + "a\n")
+				z-slice:
+					contents:
+						/etc/services: {text: "", mutable: true}
+					mutate: |
+						content.write("/etc/services", content.read("/etc/services") + "z\n")
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/services": "file 0644 bea3d766",
+	},
+	manifestPaths: map[string]string{
+		"/etc/services": "file 0644 e3b0c442 bea3d766 {test-package_a-slice,test-package_z-slice}",
+	},
+}, {
+	summary: "Script: cannot write files which are only mutable by other slices",
+	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/text-file: {text: data1, mutable: true}
+				myslice2:
+					mutate: |
+						content.write("/dir/text-file", "data2")
+		`,
+	},
+	error: `slice test-package_myslice2: cannot write file which is only mutable by other slices: /dir/text-file`,
+}, {
+	summary: "Cancelled run",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		opts.Context = ctx
+	},
+	error: `context canceled`,
 }, {
 	summary: "Relative content root directory must not error",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -713,8 +1093,164 @@ var slicerTests = []slicerTest{{
 	},
 	error: `slice test-package_myslice: cannot list directory which is not selected: /other-dir/`,
 }, {
-	summary: "Duplicate copyright symlink is ignored",
-	slices:  []setup.SliceKey{{"copyright-symlink-openssl", "bins"}},
+	summary: "Copyright files are extracted with the licenses",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Copyright = true
+	},
+	filesystem: map[string]string{
+		"/dir/":                                 "dir 0755",
+		"/dir/file":                             "file 0644 cc55e2ec",
+		"/usr/":                                 "dir 0755",
+		"/usr/share/":                           "dir 0755",
+		"/usr/share/doc/":                       "dir 0755",
+		"/usr/share/doc/test-package/":          "dir 0755",
+		"/usr/share/doc/test-package/copyright": "file 0644 d6676e3d",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package version arch hash {GPL-2+,MIT}",
+	},
+}, {
+	summary: "Copyright files may be excluded while recording the licenses",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Copyright = true
+		opts.ExcludeCopyrightFiles = true
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package version arch hash {GPL-2+,MIT}",
+	},
+}, {
+	summary: "Licenses may be checked without being recorded",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.CheckLicenses = func(pkg string, licenses []string) error {
+			c.Assert(pkg, Equals, "test-package")
+			c.Assert(licenses, DeepEquals, []string{"GPL-2+", "MIT"})
+			return nil
+		}
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+}, {
+	summary: "Packages with denied licenses stop the run",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.CheckLicenses = func(pkg string, licenses []string) error {
+			return fmt.Errorf("policy denies licenses of package %s: GPL-2+ not allowed", pkg)
+		}
+	},
+	error: `policy denies licenses of package test-package: GPL-2\+ not allowed`,
+}, {
+	summary: "Licenses are found through copyright symlinks",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}, {"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}, {
+		Name: "other-package",
+		Data: testutil.MustMakeDeb(append(testutil.OtherPackageEntries,
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/doc/"),
+			testutil.Dir(0755, "./usr/share/doc/other-package/"),
+			testutil.Lnk(0777, "./usr/share/doc/other-package/copyright", "../test-package/copyright"),
+		)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Copyright = true
+		opts.ExcludeCopyrightFiles = true
+	},
+	manifestPkgs: map[string]string{
+		"test-package":  "test-package version arch hash {GPL-2+,MIT}",
+		"other-package": "other-package version arch hash {GPL-2+,MIT}",
+	},
+}, {
+	summary: "Duplicate copyright symlink is ignored",
+	slices:  []setup.SliceKey{{"copyright-symlink-openssl", "bins"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "copyright-symlink-openssl",
 		Data: testutil.MustMakeDeb(packageEntries["copyright-symlink-openssl"]),
@@ -931,14 +1467,35 @@ var slicerTests = []slicerTest{{
 		"test-package": "test-package v2 a2 h2",
 	},
 }, {
-	summary: "Pinned archive does not have the package",
-	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	summary: "Archives listed in the package are searched in order",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}, {"other-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
-		Name: "test-package",
+		Name:    "test-package",
+		Hash:    "h1",
+		Version: "v1",
+		Arch:    "a1",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Reg(0644, "./file", "from foo"),
 		}),
 		Archives: []string{"foo"},
+	}, {
+		Name:    "test-package",
+		Hash:    "h2",
+		Version: "v2",
+		Arch:    "a2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from bar"),
+		}),
+		Archives: []string{"bar"},
+	}, {
+		Name:    "other-package",
+		Hash:    "h3",
+		Version: "v3",
+		Arch:    "a3",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./other-file", "from foo"),
+		}),
+		Archives: []string{"foo"},
 	}},
 	release: map[string]string{
 		"chisel.yaml": `
@@ -959,6 +1516,12 @@ var slicerTests = []slicerTest{{
 					suites: [jammy]
 					priority: 10
 					public-keys: [test-key]
+				ppa:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: -10
+					public-keys: [test-key]
 			public-keys:
 				test-key:
 					id: ` + testKey.ID + `
@@ -966,30 +1529,67 @@ var slicerTests = []slicerTest{{
 		`,
 		"slices/mydir/test-package.yaml": `
 			package: test-package
-			archive: bar
+			archive: [ppa, bar, foo]
 			slices:
 				myslice:
 					contents:
 						/file:
 		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/other-file:
+		`,
+	},
+	filesystem: map[string]string{
+		// test-package is not in "ppa", so it is fetched from "bar", while
+		// other packages are fetched by priority.
+		"/file":       "file 0644 fa0c9cdb",
+		"/other-file": "file 0644 7a3e00f5",
+	},
+	manifestPaths: map[string]string{
+		"/file":       "file 0644 fa0c9cdb {test-package_myslice}",
+		"/other-file": "file 0644 7a3e00f5 {other-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"other-package": "other-package v3 a3 h3",
+		"test-package":  "test-package v2 a2 h2",
 	},
-	// Although archive "foo" does have the package, since archive "bar" has
-	// been pinned in the slice definition, no other archives will be checked.
-	error: `cannot find package "test-package" in archive\(s\)`,
 }, {
-	summary: "No archives have the package",
+	summary: "Version archive strategy uses the highest version",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
-	pkgs:    []*testutil.TestPackage{},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Hash:    "h1",
+		Version: "1.0",
+		Arch:    "a1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from foo"),
+		}),
+		Archives: []string{"foo"},
+	}, {
+		Name:    "test-package",
+		Hash:    "h2",
+		Version: "1.1",
+		Arch:    "a2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from bar"),
+		}),
+		Archives: []string{"bar"},
+	}},
 	release: map[string]string{
 		"chisel.yaml": `
 			format: v1
 			maintenance:
 				standard: 2025-01-01
 				end-of-life: 2100-01-01
+			archive-strategy: version
 			archives:
 				foo:
 					version: 22.04
-					components: [main, universe]
+					components: [main]
 					suites: [jammy]
 					priority: 20
 					public-keys: [test-key]
@@ -1012,16 +1612,36 @@ var slicerTests = []slicerTest{{
 						/file:
 		`,
 	},
-	error: `cannot find package "test-package" in archive\(s\)`,
+	filesystem: map[string]string{
+		"/file": "file 0644 fa0c9cdb",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 fa0c9cdb {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package 1.1 a2 h2",
+	},
 }, {
-	summary: "Negative priority archives are ignored when not explicitly pinned in package",
+	summary: "Security archive strategy uses security suites first",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
-		Name: "test-package",
+		Name:    "test-package",
+		Hash:    "h1",
+		Version: "1.0",
+		Arch:    "a1",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Reg(0644, "./file", "from foo"),
 		}),
 		Archives: []string{"foo"},
+	}, {
+		Name:    "test-package",
+		Hash:    "h2",
+		Version: "1.1",
+		Arch:    "a2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from bar"),
+		}),
+		Archives: []string{"bar"},
 	}},
 	release: map[string]string{
 		"chisel.yaml": `
@@ -1029,12 +1649,19 @@ var slicerTests = []slicerTest{{
 			maintenance:
 				standard: 2025-01-01
 				end-of-life: 2100-01-01
+			archive-strategy: security
 			archives:
 				foo:
 					version: 22.04
-					components: [main, universe]
+					components: [main]
 					suites: [jammy]
-					priority: -20
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [main]
+					suites: [jammy-security]
+					priority: 10
 					public-keys: [test-key]
 			public-keys:
 				test-key:
@@ -1049,19 +1676,36 @@ var slicerTests = []slicerTest{{
 						/file:
 		`,
 	},
-	error: `cannot find package "test-package" in archive\(s\)`,
+	filesystem: map[string]string{
+		"/file": "file 0644 fa0c9cdb",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 fa0c9cdb {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package 1.1 a2 h2",
+	},
 }, {
-	summary: "Negative priority archive explicitly pinned in package",
+	summary: "Pinned archive strategy requires listing the archive",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name:    "test-package",
 		Hash:    "h1",
-		Version: "v1",
+		Version: "1.0",
 		Arch:    "a1",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Reg(0644, "./file", "from foo"),
 		}),
 		Archives: []string{"foo"},
+	}, {
+		Name:    "test-package",
+		Hash:    "h2",
+		Version: "1.1",
+		Arch:    "a2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from bar"),
+		}),
+		Archives: []string{"bar"},
 	}},
 	release: map[string]string{
 		"chisel.yaml": `
@@ -1069,12 +1713,19 @@ var slicerTests = []slicerTest{{
 			maintenance:
 				standard: 2025-01-01
 				end-of-life: 2100-01-01
+			archive-strategy: pinned
 			archives:
 				foo:
 					version: 22.04
-					components: [main, universe]
+					components: [main]
 					suites: [jammy]
-					priority: -20
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: 10
 					public-keys: [test-key]
 			public-keys:
 				test-key:
@@ -1083,22 +1734,247 @@ var slicerTests = []slicerTest{{
 		`,
 		"slices/mydir/test-package.yaml": `
 			package: test-package
-			archive: foo
 			slices:
 				myslice:
 					contents:
 						/file:
 		`,
 	},
-	filesystem: map[string]string{
-		"/file": "file 0644 7a3e00f5",
-	},
-	manifestPaths: map[string]string{
-		"/file": "file 0644 7a3e00f5 {test-package_myslice}",
-	},
-	manifestPkgs: map[string]string{
-		"test-package": "test-package v1 a1 h1",
-	},
+	error: `package "test-package" found in archives foo, bar: must list the archive to use with the pinned archive strategy`,
+}, {
+	summary: "Pinned archive strategy uses the archive listed",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Hash:    "h1",
+		Version: "1.0",
+		Arch:    "a1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from foo"),
+		}),
+		Archives: []string{"foo"},
+	}, {
+		Name:    "test-package",
+		Hash:    "h2",
+		Version: "1.1",
+		Arch:    "a2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from bar"),
+		}),
+		Archives: []string{"bar"},
+	}},
+	release: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archive-strategy: pinned
+			archives:
+				foo:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: 10
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			archive: [bar, foo]
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/file": "file 0644 fa0c9cdb",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 fa0c9cdb {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package 1.1 a2 h2",
+	},
+}, {
+	summary: "Pinned archive does not have the package",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from foo"),
+		}),
+		Archives: []string{"foo"},
+	}},
+	release: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: 10
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			archive: bar
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	// Although archive "foo" does have the package, since archive "bar" has
+	// been pinned in the slice definition, no other archives will be checked.
+	error: `cannot find package "test-package" in archive\(s\)`,
+}, {
+	summary: "No archives have the package",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs:    []*testutil.TestPackage{},
+	release: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					priority: 10
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	error: `cannot find package "test-package" in archive\(s\)`,
+}, {
+	summary: "Negative priority archives are ignored when not explicitly pinned in package",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from foo"),
+		}),
+		Archives: []string{"foo"},
+	}},
+	release: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: -20
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	error: `cannot find package "test-package" in archive\(s\)`,
+}, {
+	summary: "Negative priority archive explicitly pinned in package",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Hash:    "h1",
+		Version: "v1",
+		Arch:    "a1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Reg(0644, "./file", "from foo"),
+		}),
+		Archives: []string{"foo"},
+	}},
+	release: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			maintenance:
+				standard: 2025-01-01
+				end-of-life: 2100-01-01
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: -20
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			archive: foo
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/file": "file 0644 7a3e00f5",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 7a3e00f5 {test-package_myslice}",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package v1 a1 h1",
+	},
 }, {
 	summary: "Multiple slices of same package",
 	slices: []setup.SliceKey{
@@ -1568,17 +2444,27 @@ var slicerTests = []slicerTest{{
 		"/hardlink2": "file 0644 2c26b46b <1> {test-package_myslice}",
 	},
 }, {
-	summary: "Hard link identifier for different groups",
+	summary: "Extract packages unpacked in the cache",
 	slices: []setup.SliceKey{
-		{"test-package", "myslice"}},
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "test-package",
+		Hash: "test-package-hash",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file1", "text for file1"),
-			testutil.Reg(0644, "./file2", "text for file2"),
-			testutil.Hrd(0644, "./hardlink1", "./file1"),
-			testutil.Hrd(0644, "./hardlink2", "./file2"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+			testutil.Reg(0755, "./script", "text"),
+		}),
+	}, {
+		Name: "other-package",
+		Hash: "other-package-hash",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./other/"),
+			testutil.Reg(0644, "./other/license", "text"),
+			testutil.Lnk(0777, "./other/link", "license"),
 		}),
 	}},
 	release: map[string]string{
@@ -1587,13 +2473,195 @@ var slicerTests = []slicerTest{{
 			slices:
 				myslice:
 					contents:
-						/**:
+						/hardlink:
+						/file:
+						/script:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/other/license:
+						/other/link:
 		`,
 	},
-	filesystem: map[string]string{
-		"/file1":     "file 0644 df82bbbd <1>",
-		"/file2":     "file 0644 dcddda2e <2>",
-		"/hardlink1": "file 0644 df82bbbd <1>",
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.UnpackCache = &cache.Cache{Dir: c.MkDir()}
+		// Cut the slices once, so that the packages are read from the
+		// cache in the run being tested.
+		firstRun := *opts
+		firstRun.TargetDir = c.MkDir()
+		c.Assert(slicer.Run(&firstRun), IsNil)
+		for _, digest := range []string{"test-package-hash", "other-package-hash"} {
+			_, err := os.Stat(filepath.Join(opts.UnpackCache.Dir, "unpacked", digest))
+			c.Assert(err, IsNil)
+		}
+		// The extractions of the same paths are replayed, without the
+		// unpacked packages.
+		records, err := os.ReadDir(filepath.Join(opts.UnpackCache.Dir, "extracted"))
+		c.Assert(err, IsNil)
+		c.Assert(records, HasLen, 2)
+		c.Assert(os.RemoveAll(filepath.Join(opts.UnpackCache.Dir, "unpacked")), IsNil)
+	},
+	filesystem: map[string]string{
+		"/hardlink":      "file 0644 2c26b46b <1>",
+		"/file":          "file 0644 2c26b46b <1>",
+		"/script":        "file 0755 982d9e3e",
+		"/other/":        "dir 0755",
+		"/other/license": "file 0644 982d9e3e",
+		"/other/link":    "symlink license",
+	},
+	manifestPaths: map[string]string{
+		"/hardlink":      "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/file":          "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/script":        "file 0755 982d9e3e {test-package_myslice}",
+		"/other/license": "file 0644 982d9e3e {other-package_myslice}",
+		"/other/link":    "symlink license {other-package_myslice}",
+	},
+}, {
+	summary: "Deduplicate identical files with hard links",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+			testutil.Reg(0644, "./license", "text"),
+			testutil.Reg(0644, "./empty", ""),
+		}),
+	}, {
+		Name: "other-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./other/file", "foo"),
+			testutil.Reg(0644, "./other/license", "text"),
+			testutil.Reg(0755, "./other/script", "text"),
+			testutil.Reg(0644, "./other/empty", ""),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/hardlink:
+						/license:
+						/empty:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/other/file:
+						/other/license:
+						/other/script:
+						/other/empty:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Dedup = slicer.DedupHardlink
+	},
+	filesystem: map[string]string{
+		"/file":          "file 0644 2c26b46b <1>",
+		"/hardlink":      "file 0644 2c26b46b <1>",
+		"/license":       "file 0644 982d9e3e <2>",
+		"/empty":         "file 0644 empty",
+		"/other/":        "dir 0755",
+		"/other/file":    "file 0644 2c26b46b",
+		"/other/license": "file 0644 982d9e3e <2>",
+		"/other/script":  "file 0755 982d9e3e",
+		"/other/empty":   "file 0644 empty",
+	},
+	manifestPaths: map[string]string{
+		"/file":          "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/hardlink":      "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/license":       "file 0644 982d9e3e <2> {test-package_myslice}",
+		"/empty":         "file 0644 empty {test-package_myslice}",
+		"/other/file":    "file 0644 2c26b46b {other-package_myslice}",
+		"/other/license": "file 0644 982d9e3e <2> {other-package_myslice}",
+		"/other/script":  "file 0755 982d9e3e {other-package_myslice}",
+		"/other/empty":   "file 0644 empty {other-package_myslice}",
+	},
+}, {
+	summary: "Deduplicate identical files with reflinks",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./license", "text"),
+		}),
+	}, {
+		Name: "other-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./other/license", "text"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/license:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/other/license:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Dedup = slicer.DedupReflink
+	},
+	filesystem: map[string]string{
+		"/license":       "file 0644 982d9e3e",
+		"/other/":        "dir 0755",
+		"/other/license": "file 0644 982d9e3e",
+	},
+	manifestPaths: map[string]string{
+		"/license":       "file 0644 982d9e3e {test-package_myslice}",
+		"/other/license": "file 0644 982d9e3e {other-package_myslice}",
+	},
+}, {
+	summary: "Hard link identifier for different groups",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file1", "text for file1"),
+			testutil.Reg(0644, "./file2", "text for file2"),
+			testutil.Hrd(0644, "./hardlink1", "./file1"),
+			testutil.Hrd(0644, "./hardlink2", "./file2"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/**:
+		`,
+	},
+	filesystem: map[string]string{
+		"/file1":     "file 0644 df82bbbd <1>",
+		"/file2":     "file 0644 dcddda2e <2>",
+		"/hardlink1": "file 0644 df82bbbd <1>",
 		"/hardlink2": "file 0644 dcddda2e <2>",
 	},
 	manifestPaths: map[string]string{
@@ -1701,28 +2769,1371 @@ var slicerTests = []slicerTest{{
 		`,
 	},
 	filesystem: map[string]string{
-		"/file1":     "file 0644 2c26b46b <1>",
-		"/hardlink1": "file 0644 2c26b46b <1>",
-		"/file2":     "file 0644 2c26b46b <2>",
-		"/hardlink2": "file 0644 2c26b46b <2>",
+		"/file1":     "file 0644 2c26b46b <1>",
+		"/hardlink1": "file 0644 2c26b46b <1>",
+		"/file2":     "file 0644 2c26b46b <2>",
+		"/hardlink2": "file 0644 2c26b46b <2>",
+	},
+	manifestPaths: map[string]string{
+		"/file1":     "file 0644 2c26b46b <1> {test-package1_myslice}",
+		"/hardlink1": "file 0644 2c26b46b <1> {test-package1_myslice}",
+		"/file2":     "file 0644 2c26b46b <2> {test-package2_myslice}",
+		"/hardlink2": "file 0644 2c26b46b <2> {test-package2_myslice}",
+	},
+}, {
+	summary: "Mutations for hard links are forbidden",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/hardlink: {mutable: true}
+					mutate: |
+						content.write("/hardlink", "new content")
+		`,
+	},
+	error: `slice test-package_myslice: cannot mutate a hard link: /hardlink`,
+}, {
+	summary: "Hard links can be marked as mutable, but not mutated",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/hardlink: {mutable: true}
+		`,
+	},
+	filesystem: map[string]string{
+		"/file":     "file 0644 2c26b46b <1>",
+		"/hardlink": "file 0644 2c26b46b <1>",
+	},
+	manifestPaths: map[string]string{
+		"/file":     "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/hardlink": "file 0644 2c26b46b <1> {test-package_myslice}",
+	},
+}, {
+	summary: "Hard links cannot escape the target directory",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Hrd(0644, "./hardlink", "/etc/group"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/hardlink:
+		`,
+	},
+	error: `cannot extract from package "test-package": invalid link target /etc/group`,
+}, {
+	summary: "Cannot extract outside of target directory",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./../file", "hijacking system file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/**:
+		`,
+	},
+	error: `cannot extract from package "test-package": cannot create path /[a-z0-9\-\/]*/file outside of root /[a-z0-9\-\/]*`,
+}, {
+	summary: "Extract conflicting paths with prefer from proper package",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "bar"),
+		}),
+	}, {
+		Name: "test-package3",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/file: {prefer: test-package2}
+						/link: {symlink: /file1}
+						/text: {text: foo, prefer: test-package3}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/file:
+						/link: {symlink: /file2, prefer: test-package3}
+		`,
+		"slices/mydir/test-package3.yaml": `
+			package: test-package3
+			slices:
+				myslice:
+					contents:
+						/link: {symlink: /file2, prefer: test-package1}
+						/text: {text: bar}
+		`,
+	},
+	filesystem: map[string]string{
+		"/file": "file 0644 fcde2b2e",
+		"/link": "symlink /file1",
+		"/text": "file 0644 2c26b46b",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 fcde2b2e {test-package2_myslice}",
+		"/link": "symlink /file1 {test-package1_myslice}",
+		"/text": "file 0644 2c26b46b {test-package1_myslice}",
+	},
+}, {
+	summary: "Extract the same path from multiple packages with identical content",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+	},
+	filesystem: map[string]string{
+		"/file": "file 0644 2c26b46b",
+	},
+	manifestPaths: map[string]string{
+		"/file": "file 0644 2c26b46b {test-package1_myslice,test-package2_myslice}",
+	},
+}, {
+	summary: "Cannot extract the same path from multiple packages with different content",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "bar"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+	},
+	error: `cannot extract from package "test-package2": packages test-package1 and test-package2 have different content on /file`,
+}, {
+	summary: "Different content on the same path notes the package replacing the other",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package1",
+		Version: "1.0",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+		}),
+	}, {
+		Name:     "test-package2",
+		Version:  "2.0",
+		Replaces: []archive.Relation{{Name: "test-package1", Op: "<<", Version: "1.1"}},
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "bar"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/file: {same: true}
+		`,
+	},
+	error: `cannot extract from package "test-package2": packages test-package1 and test-package2 have different content on /file \(test-package2 replaces test-package1 \(<< 1.1\)\)`,
+}, {
+	summary: "Overwrite policy merges extracted and generated content",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/file", "foo\n"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/etc/file: {mutable: true, overwrite: merge}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/etc/file: {text: bar, mutable: true, overwrite: merge}
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":     "dir 0755",
+		"/etc/file": "file 0644 d78931fc",
+	},
+	manifestPaths: map[string]string{
+		"/etc/file": "file 0644 d78931fc {test-package1_myslice,test-package2_myslice}",
+	},
+}, {
+	summary: "Overwrite policy keeps the content extracted first",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/file", "foo\n"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/etc/file: {mutable: true, overwrite: keep-first}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/etc/file: {text: bar, mutable: true, overwrite: keep-first}
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":     "dir 0755",
+		"/etc/file": "file 0644 b5bb9d80",
+	},
+	manifestPaths: map[string]string{
+		"/etc/file": "file 0644 b5bb9d80 {test-package1_myslice,test-package2_myslice}",
+	},
+}, {
+	summary: "Overwrite policy keeps the content generated last",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/file", "foo\n"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/etc/file: {mutable: true, overwrite: overwrite-last}
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/etc/file: {text: bar, mutable: true, overwrite: overwrite-last}
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":     "dir 0755",
+		"/etc/file": "file 0644 fcde2b2e",
+	},
+	manifestPaths: map[string]string{
+		"/etc/file": "file 0644 fcde2b2e {test-package1_myslice,test-package2_myslice}",
+	},
+}, {
+	summary: "Warning when selected packages conflict",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name:      "test-package1",
+		Version:   "1.0",
+		Conflicts: []archive.Relation{{Name: "test-package2"}, {Name: "test-package1"}},
+		Breaks:    []archive.Relation{{Name: "test-package2", Op: ">>", Version: "2.0"}},
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./foo", "foo"),
+		}),
+	}, {
+		Name:    "test-package2",
+		Version: "2.0",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./bar", "bar"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/foo:
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/bar:
+		`,
+	},
+	filesystem: map[string]string{
+		"/foo": "file 0644 2c26b46b",
+		"/bar": "file 0644 fcde2b2e",
+	},
+	manifestPaths: map[string]string{
+		"/foo": "file 0644 2c26b46b {test-package1_myslice}",
+		"/bar": "file 0644 fcde2b2e {test-package2_myslice}",
+	},
+	logOutput: `(?s).*Warning: Package test-package1 declares "Conflicts: test-package2" and test-package2 2\.0 is selected too\n[^\n]*Generating manifest.*`,
+}, {
+	summary: "Warning when implicit parent directories conflict",
+	slices: []setup.SliceKey{
+		{"test-package1", "myslice"},
+		{"test-package2", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package1",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			// Note that both implicit parents have different permissions.
+			testutil.Dir(0766, "./parent/"),
+			testutil.Reg(0644, "./parent/foo", "whatever"),
+		}),
+	}, {
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			// And here.
+			testutil.Dir(0755, "./parent/"),
+			testutil.Reg(0644, "./parent/bar", "whatever"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/parent/foo:
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/parent/bar:
+		`,
+	},
+	logOutput: `(?s).*Warning: Path "/parent/" has diverging modes in different packages\. Please report\..*`,
+}, {
+	summary: "Arch specific slice is not installed when it does not match requested arch",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	arch:    "amd64",
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					v3-essential:
+						test-package_not-installed: {arch: arm64}
+					contents:
+				not-installed:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem:    map[string]string{},
+	manifestPaths: map[string]string{},
+}, {
+	summary: "Arch specific slice is installed when it matches requested arch",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	arch:    "arm64",
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					v3-essential:
+						test-package_installed: {arch: arm64}
+					contents:
+				installed:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_installed}",
+	},
+}, {
+	summary: "Conditional essentials are installed when they match requested arch",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	arch:    "arm64",
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					essential:
+						- slice: test-package_installed
+						  when: arch in [amd64, arm64]
+						- slice: test-package_not-installed
+						  when: arch == amd64
+					contents:
+				installed:
+					contents:
+						/dir/file:
+				not-installed:
+					contents:
+						/dir/other-file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_installed}",
+	},
+}, {
+	summary: "Transitive essential",
+	slices:  []setup.SliceKey{{"test-package", "first"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				first:
+					essential:
+						- test-package_second
+					contents:
+				second:
+					essential:
+						- test-package_third
+					contents:
+				third:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_third}",
+	},
+}, {
+	summary: "Local archive takes precedence over archives",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Local = &testutil.TestArchive{
+			Opts: archive.Options{Label: "local"},
+			Packages: map[string]*testutil.TestPackage{
+				"test-package": {
+					Name:    "test-package",
+					Version: "local-version",
+					Hash:    "local-hash",
+					Arch:    "local-arch",
+					Data: testutil.MustMakeDeb([]testutil.TarEntry{
+						testutil.Dir(0755, "./"),
+						testutil.Reg(0644, "./file", "local"),
+					}),
+				},
+			},
+		}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/file": "file 0644 25bf8e1a",
+	},
+	manifestPkgs: map[string]string{
+		"test-package": "test-package local-version local-arch local-hash",
+	},
+}, {
+	summary: "Generate dpkg status",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.DpkgStatus = true
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                               "dir 0755",
+		"/dir/file":                           "file 0644 cc55e2ec",
+		"/var/":                               "dir 0755",
+		"/var/lib/":                           "dir 0755",
+		"/var/lib/dpkg/":                      "dir 0755",
+		"/var/lib/dpkg/status":                "file 0644 91adac11",
+		"/var/lib/dpkg/status.d/":             "dir 0755",
+		"/var/lib/dpkg/status.d/test-package": "file 0644 91adac11",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":                           "file 0644 cc55e2ec {test-package_myslice}",
+		"/var/lib/dpkg/status":                "file 0644 91adac11 {test-package_manifest,test-package_myslice}",
+		"/var/lib/dpkg/status.d/test-package": "file 0644 91adac11 {test-package_manifest,test-package_myslice}",
+	},
+}, {
+	summary: "Diverted paths of other packages are moved away",
+	slices:  []setup.SliceKey{{"diverter", "bins"}, {"other", "bins"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.DpkgStatus = true
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "diverter",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/dash", "dash"),
+			testutil.Lnk(0777, "./usr/bin/sh", "dash"),
+		}),
+	}, {
+		Name: "other",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/sh", "other sh"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/diverter.yaml": `
+			package: diverter
+			slices:
+				bins:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/dash:
+						/usr/bin/sh:
+		`,
+		"slices/mydir/other.yaml": `
+			package: other
+			slices:
+				bins:
+					contents:
+						/usr/bin/sh:
+		`,
+	},
+	filesystem: map[string]string{
+		"/usr/":                           "dir 0755",
+		"/usr/bin/":                       "dir 0755",
+		"/usr/bin/dash":                   "file 0755 af9d2c92",
+		"/usr/bin/sh":                     "symlink dash",
+		"/usr/bin/sh.distrib":             "file 0755 8963dfb1",
+		"/var/":                           "dir 0755",
+		"/var/lib/":                       "dir 0755",
+		"/var/lib/dpkg/":                  "dir 0755",
+		"/var/lib/dpkg/diversions":        "file 0644 afe4ca29",
+		"/var/lib/dpkg/status":            "file 0644 9f18fef4",
+		"/var/lib/dpkg/status.d/":         "dir 0755",
+		"/var/lib/dpkg/status.d/diverter": "file 0644 ca2f9cb7",
+		"/var/lib/dpkg/status.d/other":    "file 0644 772ba5f4",
+	},
+	manifestPaths: map[string]string{
+		"/usr/bin/dash":                   "file 0755 af9d2c92 {diverter_bins}",
+		"/usr/bin/sh":                     "symlink dash {diverter_bins}",
+		"/usr/bin/sh.distrib":             "file 0755 8963dfb1 (from /usr/bin/sh) {other_bins}",
+		"/var/lib/dpkg/diversions":        "file 0644 afe4ca29 {diverter_bins}",
+		"/var/lib/dpkg/status":            "file 0644 9f18fef4 {diverter_bins,diverter_manifest,other_bins}",
+		"/var/lib/dpkg/status.d/diverter": "file 0644 ca2f9cb7 {diverter_bins,diverter_manifest}",
+		"/var/lib/dpkg/status.d/other":    "file 0644 772ba5f4 {other_bins}",
+	},
+}, {
+	summary: "Diverted paths are left in place when the diverting slice is not selected",
+	slices:  []setup.SliceKey{{"other", "bins"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "other",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/sh", "other sh"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/diverter.yaml": `
+			package: diverter
+			slices:
+				bins:
+					divert:
+						/usr/bin/sh: /usr/bin/sh.distrib
+					contents:
+						/usr/bin/sh:
+		`,
+		"slices/mydir/other.yaml": `
+			package: other
+			slices:
+				bins:
+					contents:
+						/usr/bin/sh:
+		`,
+	},
+	filesystem: map[string]string{
+		"/usr/":       "dir 0755",
+		"/usr/bin/":   "dir 0755",
+		"/usr/bin/sh": "file 0755 8963dfb1",
+	},
+	manifestPaths: map[string]string{
+		"/usr/bin/sh": "file 0755 8963dfb1 {other_bins}",
+	},
+}, {
+	summary: "Conffiles are marked in the manifest",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDebWithControlFiles(map[string]string{
+			"control":   "Package: test-package\n",
+			"conffiles": "/etc/app.conf\n/etc/other.conf\n",
+		}, []testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/app.conf", "conf"),
+			testutil.Reg(0644, "./etc/app.data", "app"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/app.conf:
+						/etc/app.data:
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":         "dir 0755",
+		"/etc/app.conf": "file 0644 0c326c4f",
+		"/etc/app.data": "file 0644 a172cedc",
+	},
+	manifestPaths: map[string]string{
+		"/etc/app.conf": "file 0644 0c326c4f conffile {test-package_myslice}",
+		"/etc/app.data": "file 0644 a172cedc {test-package_myslice}",
+	},
+}, {
+	summary: "Generate ca-certificates",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/ca-certificates/"),
+			testutil.Reg(0644, "./usr/share/ca-certificates/root1.crt", testutil.TestCerts["root1"].PEM),
+			testutil.Reg(0644, "./usr/share/ca-certificates/root2.crt", testutil.TestCerts["root2"].PEM),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/share/ca-certificates/*.crt:
+						/etc/ssl/certs/**: {generate: ca-certificates}
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/etc/ssl/certs/26db9893.0":            "symlink root1.pem {test-package_myslice}",
+		"/etc/ssl/certs/85cb99c3.0":            "symlink root2.pem {test-package_myslice}",
+		"/etc/ssl/certs/ca-certificates.crt":   "file 0644 506a3956 {test-package_myslice}",
+		"/etc/ssl/certs/root1.pem":             "symlink /usr/share/ca-certificates/root1.crt {test-package_myslice}",
+		"/etc/ssl/certs/root2.pem":             "symlink /usr/share/ca-certificates/root2.crt {test-package_myslice}",
+		"/usr/share/ca-certificates/root1.crt": "file 0644 ba16ab28 {test-package_myslice}",
+		"/usr/share/ca-certificates/root2.crt": "file 0644 f037b717 {test-package_myslice}",
+	},
+}, {
+	summary: "Generate with custom generators",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(testutil.TestPackageEntries),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/etc/machine/**: {generate: x-machine-id}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Generators = map[setup.GenerateKind]slicer.Generator{
+			"x-machine-id": slicer.GeneratorFunc(func(rootDir string, dir string) ([]*slicer.GeneratedFile, error) {
+				c.Assert(dir, Equals, "/etc/machine/")
+				data, err := os.ReadFile(filepath.Join(rootDir, "dir/file"))
+				c.Assert(err, IsNil)
+				return []*slicer.GeneratedFile{
+					{Path: "machine-id", Mode: 0444, Data: []byte("uninitialized\n")},
+					{Path: "hints/file", Data: data},
+				}, nil
+			}),
+		}
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":               "file 0644 cc55e2ec {test-package_myslice}",
+		"/etc/machine/hints/file": "file 0644 cc55e2ec {test-package_myslice}",
+		"/etc/machine/machine-id": "file 0444 b1f13196 {test-package_myslice}",
+	},
+}, {
+	summary: "Custom generate kinds require a generator",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(testutil.TestPackageEntries),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/machine/**: {generate: x-machine-id}
+		`,
+	},
+	error: `slice test-package_myslice has no generator for path /etc/machine/\*\*: "x-machine-id"`,
+}, {
+	summary: "Custom generators may not write outside of their directory",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(testutil.TestPackageEntries),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/machine/**: {generate: x-machine-id}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Generators = map[setup.GenerateKind]slicer.Generator{
+			"x-machine-id": slicer.GeneratorFunc(func(rootDir string, dir string) ([]*slicer.GeneratedFile, error) {
+				return []*slicer.GeneratedFile{{Path: "../shadow"}}, nil
+			}),
+		}
+	},
+	error: `cannot generate x-machine-id at /etc/machine/: invalid path "../shadow"`,
+}, {
+	summary: "Trim locales and timezones",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Locales = []string{"pt_BR"}
+		opts.Timezones = []string{"Europe/Lisbon", "UTC"}
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/locale/"),
+			testutil.Reg(0644, "./usr/share/locale/locale.alias", "alias"),
+			testutil.Dir(0755, "./usr/share/locale/de/"),
+			testutil.Reg(0644, "./usr/share/locale/de/msg.mo", "de"),
+			testutil.Dir(0755, "./usr/share/locale/pt/"),
+			testutil.Reg(0644, "./usr/share/locale/pt/msg.mo", "pt"),
+			testutil.Dir(0755, "./usr/share/locale/pt_BR/"),
+			testutil.Reg(0644, "./usr/share/locale/pt_BR/msg.mo", "pt_BR"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/zone.tab", "tab"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/UTC", "utc"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/Asia/"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Asia/Tokyo", "tokyo"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/Europe/"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Europe/Berlin", "berlin"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Europe/Lisbon", "lisbon"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/share/locale/**:
+						/usr/share/zoneinfo/**:
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/usr/share/locale/":                "dir 0755 {test-package_myslice}",
+		"/usr/share/locale/locale.alias":    "file 0644 1a0a6a36 {test-package_myslice}",
+		"/usr/share/locale/pt/":             "dir 0755 {test-package_myslice}",
+		"/usr/share/locale/pt/msg.mo":       "file 0644 e75b11da {test-package_myslice}",
+		"/usr/share/locale/pt_BR/":          "dir 0755 {test-package_myslice}",
+		"/usr/share/locale/pt_BR/msg.mo":    "file 0644 11bb446e {test-package_myslice}",
+		"/usr/share/zoneinfo/":              "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/Europe/":       "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/Europe/Lisbon": "file 0644 2c146785 {test-package_myslice}",
+		"/usr/share/zoneinfo/UTC":           "file 0644 eed2036c {test-package_myslice}",
+		"/usr/share/zoneinfo/zone.tab":      "file 0644 7508386a {test-package_myslice}",
+	},
+}, {
+	summary: "Trimmed timezones are kept when kept ones point to them",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Timezones = []string{"Europe/Lisbon", "UTC"}
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/Etc/"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Etc/GMT", "gmt"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Etc/UTC", "utc"),
+			testutil.Lnk(0777, "./usr/share/zoneinfo/UTC", "Etc/UTC"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/Europe/"),
+			testutil.Lnk(0777, "./usr/share/zoneinfo/Europe/Lisbon", "../Portugal"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Poland", "warsaw"),
+			testutil.Reg(0644, "./usr/share/zoneinfo/Portugal", "lisbon"),
+			testutil.Dir(0755, "./usr/share/zoneinfo/posix/"),
+			testutil.Lnk(0777, "./usr/share/zoneinfo/posix/UTC", "/usr/share/zoneinfo/Etc/UTC"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/share/zoneinfo/**:
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/usr/share/zoneinfo/":              "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/Etc/":          "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/Etc/UTC":       "file 0644 eed2036c {test-package_myslice}",
+		"/usr/share/zoneinfo/Europe/":       "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/Europe/Lisbon": "symlink ../Portugal {test-package_myslice}",
+		"/usr/share/zoneinfo/Portugal":      "file 0644 2c146785 {test-package_myslice}",
+		"/usr/share/zoneinfo/UTC":           "symlink Etc/UTC {test-package_myslice}",
+		"/usr/share/zoneinfo/posix/":        "dir 0755 {test-package_myslice}",
+		"/usr/share/zoneinfo/posix/UTC":     "symlink /usr/share/zoneinfo/Etc/UTC {test-package_myslice}",
+	},
+}, {
+	summary: "Exclude paths from the selection",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		err := opts.Selection.Exclude([]string{"/dir/other-file", "/dir/nested/**"}, "amd64", false)
+		c.Assert(err, IsNil)
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/other-file:
+						/dir/**/file:
+						/dir/text: {text: data}
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/dir/several/levels/deep/file": "file 0644 6bc26dff {test-package_myslice}",
+		"/dir/text":                     "file 0644 3a6eb079 {test-package_myslice}",
+	},
+}, {
+	summary: "Compile Python sources",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		// Fake interpreter writing the name of the source as the output.
+		interpreter := filepath.Join(c.MkDir(), "python3")
+		err := os.WriteFile(interpreter, []byte(fakePython), 0755)
+		c.Assert(err, IsNil)
+		opts.PythonInterpreter = interpreter
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/script.py: {text: "print(1)"}
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":                          "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/script.py":                     "file 0644 d287bb7f {test-package_myslice}",
+		"/dir/__pycache__/script.fake-3.pyc": "file 0644 3431c467 {test-package_myslice}",
+	},
+}, {
+	summary: "Generate ldconfig cache",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.LDConfig = true
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/lib/"),
+			testutil.Dir(0755, "./usr/lib/x86_64-linux-gnu/"),
+			testutil.Reg(0644, "./usr/lib/x86_64-linux-gnu/libfoo.so.1.0", string(testutil.MakeSharedLib(elf.EM_X86_64, "libfoo.so.1"))),
+			testutil.Lnk(0777, "./usr/lib/x86_64-linux-gnu/libfoo.so.1", "libfoo.so.1.0"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/lib/x86_64-linux-gnu/libfoo.so*:
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":                                 "dir 0755",
+		"/etc/ld.so.cache":                      "file 0644 3d63388d",
+		"/usr/":                                 "dir 0755",
+		"/usr/lib/":                             "dir 0755",
+		"/usr/lib/x86_64-linux-gnu/":            "dir 0755",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1": "symlink libfoo.so.1.0",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1.0": "file 0644 30c8f0d4",
+	},
+	manifestPaths: map[string]string{
+		"/etc/ld.so.cache":                        "file 0644 3d63388d {test-package_myslice}",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1":   "symlink libfoo.so.1.0 {test-package_myslice}",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1.0": "file 0644 30c8f0d4 {test-package_myslice}",
+	},
+}, {
+	summary: "Generate ldconfig cache for libraries already in the root",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.LDConfig = true
+		libDir := filepath.Join(opts.TargetDir, "usr/lib/x86_64-linux-gnu")
+		c.Assert(os.MkdirAll(libDir, 0755), IsNil)
+		lib := testutil.MakeSharedLib(elf.EM_X86_64, "libfoo.so.1")
+		c.Assert(os.WriteFile(filepath.Join(libDir, "libfoo.so.1.0"), lib, 0644), IsNil)
+		c.Assert(os.Symlink("libfoo.so.1.0", filepath.Join(libDir, "libfoo.so.1")), IsNil)
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                                 "dir 0755",
+		"/dir/file":                             "file 0644 cc55e2ec",
+		"/etc/":                                 "dir 0755",
+		"/etc/ld.so.cache":                      "file 0644 3d63388d",
+		"/usr/":                                 "dir 0755",
+		"/usr/lib/":                             "dir 0755",
+		"/usr/lib/x86_64-linux-gnu/":            "dir 0755",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1": "symlink libfoo.so.1.0",
+		"/usr/lib/x86_64-linux-gnu/libfoo.so.1.0": "file 0644 30c8f0d4",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":        "file 0644 cc55e2ec {test-package_myslice}",
+		"/etc/ld.so.cache": "file 0644 3d63388d {test-package_manifest,test-package_myslice}",
+	},
+}, {
+	summary: "Apply sysusers.d and tmpfiles.d",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Sysusers = true
+		opts.Tmpfiles = true
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/passwd", "root:x:0:0:root:/root:/bin/bash\n"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/lib/"),
+			testutil.Dir(0755, "./usr/lib/sysusers.d/"),
+			testutil.Reg(0644, "./usr/lib/sysusers.d/svc.conf", "u svc -\n"),
+			testutil.Dir(0755, "./usr/lib/tmpfiles.d/"),
+			testutil.Reg(0644, "./usr/lib/tmpfiles.d/svc.conf", "d /var/lib/svc 0750\n"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/passwd:
+						/usr/lib/sysusers.d/svc.conf:
+						/usr/lib/tmpfiles.d/svc.conf:
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":                        "dir 0755",
+		"/etc/group":                   "file 0644 c121f604",
+		"/etc/passwd":                  "file 0644 31d94688",
+		"/usr/":                        "dir 0755",
+		"/usr/lib/":                    "dir 0755",
+		"/usr/lib/sysusers.d/":         "dir 0755",
+		"/usr/lib/sysusers.d/svc.conf": "file 0644 2fb179a1",
+		"/usr/lib/tmpfiles.d/":         "dir 0755",
+		"/usr/lib/tmpfiles.d/svc.conf": "file 0644 f3d3a7ae",
+		"/var/":                        "dir 0755",
+		"/var/lib/":                    "dir 0755",
+		"/var/lib/svc/":                "dir 0750",
+	},
+	manifestPaths: map[string]string{
+		"/etc/group":                   "file 0644 c121f604 {test-package_myslice}",
+		"/etc/passwd":                  "file 0644 e787b373 31d94688 {test-package_myslice}",
+		"/usr/lib/sysusers.d/svc.conf": "file 0644 2fb179a1 {test-package_myslice}",
+		"/usr/lib/tmpfiles.d/svc.conf": "file 0644 f3d3a7ae {test-package_myslice}",
+		"/var/lib/svc/":                "dir 0750 {test-package_myslice}",
+	},
+}, {
+	summary: "Packages are checked before they are fetched",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.CheckPackage = func(archive string, info *archive.PackageInfo) error {
+			c.Assert(archive, Equals, "ubuntu")
+			return fmt.Errorf("package %q rejected", info.Name)
+		}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `package "test-package" rejected`,
+}, {
+	summary: "Policy denies the selection",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Policy = &denyPolicy{deny: "test-package_myslice"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `slice test-package_myslice denied`,
+}, {
+	summary: "Policy denies packages before they are fetched",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Policy = &denyPolicy{deny: "test-package"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `package test-package denied`,
+}, {
+	summary: "Policy denies licenses",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageDEP5Entries...)),
+	}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Policy = &denyPolicy{deny: "MIT"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `license MIT of package test-package denied`,
+}, {
+	summary: "Owners are recorded in the ownership database",
+	slices:  []setup.SliceKey{{"ownership", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "ownership",
+		Data: testutil.MustMakeDeb(packageEntries["ownership"]),
+	}},
+	release: map[string]string{
+		"slices/mydir/ownership.yaml": `
+			package: ownership
+			slices:
+				myslice:
+					contents:
+						/etc/shadow:
+						/etc/temp: {until: mutate}
+						/var/mail/file:
+		`,
+	},
+	ownership: map[string]string{
+		"/etc/shadow":    "0:42 system_u:object_r:shadow_t:s0",
+		"/var/mail":      "0:8",
+		"/var/mail/file": "1000:8",
+	},
+}, {
+	summary: "Labels declared in slices override the ones from packages",
+	slices:  []setup.SliceKey{{"ownership", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "ownership",
+		Data: testutil.MustMakeDeb(packageEntries["ownership"]),
+	}},
+	release: map[string]string{
+		"slices/mydir/ownership.yaml": `
+			package: ownership
+			slices:
+				myslice:
+					contents:
+						/etc/shadow: {label: "system_u:object_r:etc_t:s0"}
+						/var/mail/*: {label: "system_u:object_r:mail_spool_t:s0"}
+						/etc/app.conf: {text: data, label: "system_u:object_r:etc_t:s0"}
+						/etc/app.d/: {make: true, label: "system_u:object_r:etc_t:s0"}
+		`,
+	},
+	ownership: map[string]string{
+		"/etc/app.conf":  "0:0 system_u:object_r:etc_t:s0",
+		"/etc/app.d":     "0:0 system_u:object_r:etc_t:s0",
+		"/etc/shadow":    "0:42 system_u:object_r:etc_t:s0",
+		"/var/mail":      "0:8",
+		"/var/mail/file": "1000:8 system_u:object_r:mail_spool_t:s0",
+	},
+}, {
+	summary: "Symlinks with absolute and escaping targets are allowed by default",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(symlinkPolicyEntries),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/*:
+		`,
+	},
+	manifestPaths: map[string]string{
+		"/usr/bin/absolute": "symlink /etc/file {test-package_myslice}",
+		"/usr/bin/escaping": "symlink ../../../etc/file {test-package_myslice}",
+		"/usr/bin/relative": "symlink ../../etc/file {test-package_myslice}",
+	},
+}, {
+	summary: "Symlink policy rewrites absolute and escaping targets",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(symlinkPolicyEntries),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/*:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SymlinkPolicy = slicer.SymlinkPolicy{
+			Absolute: slicer.SymlinkRewrite,
+			Escaping: slicer.SymlinkRewrite,
+		}
+	},
+	filesystem: map[string]string{
+		"/usr/":             "dir 0755",
+		"/usr/bin/":         "dir 0755",
+		"/usr/bin/absolute": "symlink ../../etc/file",
+		"/usr/bin/escaping": "symlink ../../etc/file",
+		"/usr/bin/relative": "symlink ../../etc/file",
 	},
 	manifestPaths: map[string]string{
-		"/file1":     "file 0644 2c26b46b <1> {test-package1_myslice}",
-		"/hardlink1": "file 0644 2c26b46b <1> {test-package1_myslice}",
-		"/file2":     "file 0644 2c26b46b <2> {test-package2_myslice}",
-		"/hardlink2": "file 0644 2c26b46b <2> {test-package2_myslice}",
+		"/usr/bin/absolute": "symlink ../../etc/file (was /etc/file) {test-package_myslice}",
+		"/usr/bin/escaping": "symlink ../../etc/file (was ../../../etc/file) {test-package_myslice}",
+		"/usr/bin/relative": "symlink ../../etc/file {test-package_myslice}",
 	},
 }, {
-	summary: "Mutations for hard links are forbidden",
-	slices: []setup.SliceKey{
-		{"test-package", "myslice"}},
+	summary: "Symlink policy rejects escaping targets",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "test-package",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-			testutil.Hrd(0644, "./hardlink", "./file"),
-		}),
+		Data: testutil.MustMakeDeb(symlinkPolicyEntries),
 	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
@@ -1730,23 +4141,22 @@ var slicerTests = []slicerTest{{
 			slices:
 				myslice:
 					contents:
-						/file:
-						/hardlink: {mutable: true}
-					mutate: |
-						content.write("/hardlink", "new content")
+						/usr/bin/*:
 		`,
 	},
-	error: `slice test-package_myslice: cannot mutate a hard link: /hardlink`,
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SymlinkPolicy = slicer.SymlinkPolicy{Escaping: slicer.SymlinkReject}
+	},
+	error: `cannot extract from package "test-package": cannot create symlink /usr/bin/escaping: escaping target ../../../etc/file rejected by the symlink policy`,
 }, {
-	summary: "Hard links can be marked as mutable, but not mutated",
-	slices: []setup.SliceKey{
-		{"test-package", "myslice"}},
+	summary: "Content is not written through symlinks resolving outside of the root",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-			testutil.Hrd(0644, "./hardlink", "./file"),
+			testutil.Lnk(0777, "./dir", "/"),
+			testutil.Reg(0644, "./dir/file", "data"),
 		}),
 	}},
 	release: map[string]string{
@@ -1755,46 +4165,70 @@ var slicerTests = []slicerTest{{
 			slices:
 				myslice:
 					contents:
-						/file:
-						/hardlink: {mutable: true}
+						/dir:
+						/dir/file:
 		`,
 	},
-	filesystem: map[string]string{
-		"/file":     "file 0644 2c26b46b <1>",
-		"/hardlink": "file 0644 2c26b46b <1>",
-	},
-	manifestPaths: map[string]string{
-		"/file":     "file 0644 2c26b46b <1> {test-package_myslice}",
-		"/hardlink": "file 0644 2c26b46b <1> {test-package_myslice}",
-	},
+	error: `cannot extract from package "test-package": cannot create path /[^ ]*/dir/file: /[^ ]*/dir resolves to / outside of root /[^ ]*`,
 }, {
-	summary: "Hard links cannot escape the target directory",
+	summary: "Usrmerge extracts the paths of packages to their /usr counterparts",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			testutil.Hrd(0644, "./hardlink", "/etc/group"),
+			testutil.Dir(0755, "./bin/"),
+			testutil.Reg(0755, "./bin/foo", "foo"),
+			testutil.Hrd(0755, "./bin/foo-link", "./bin/foo"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/lib/"),
+			testutil.Reg(0644, "./usr/lib/bar", "bar"),
 		}),
 	}},
 	release: map[string]string{
+		"chisel.yaml": strings.Replace(testutil.DefaultChiselYaml, "archives:", "usrmerge: true\n\tarchives:", 1),
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
 					contents:
-						/hardlink:
+						/usr/bin/foo:
+						/bin/foo-link:
+						/lib/bar:
+						/etc/out: {text: FIXME, mutable: true}
+					mutate: |
+						content.write("/etc/out", content.read("/bin/foo"))
 		`,
 	},
-	error: `cannot extract from package "test-package": invalid link target /etc/group`,
+	filesystem: map[string]string{
+		"/bin":              "symlink usr/bin",
+		"/etc/":             "dir 0755",
+		"/etc/out":          "file 0644 2c26b46b",
+		"/lib":              "symlink usr/lib",
+		"/usr/":             "dir 0755",
+		"/usr/bin/":         "dir 0755",
+		"/usr/bin/foo":      "file 0755 2c26b46b <1>",
+		"/usr/bin/foo-link": "file 0755 2c26b46b <1>",
+		"/usr/lib/":         "dir 0755",
+		"/usr/lib/bar":      "file 0644 fcde2b2e",
+	},
+	manifestPaths: map[string]string{
+		"/etc/out":          "file 0644 8f2adf96 2c26b46b {test-package_myslice}",
+		"/usr/bin/foo":      "file 0755 2c26b46b <1> {test-package_myslice}",
+		"/usr/bin/foo-link": "file 0755 2c26b46b <1> {test-package_myslice}",
+		"/usr/lib/bar":      "file 0644 fcde2b2e {test-package_myslice}",
+	},
 }, {
-	summary: "Cannot extract outside of target directory",
+	summary: "Permissions are normalized once the tree is cut",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
 		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./../file", "hijacking system file"),
+			testutil.Dir(0777, "./dir/"),
+			testutil.Reg(04755, "./dir/setuid", "foo"),
+			testutil.Reg(0666, "./dir/file", "bar"),
+			testutil.Lnk(0777, "./dir/link", "file"),
 		}),
 	}},
 	release: map[string]string{
@@ -1803,183 +4237,180 @@ var slicerTests = []slicerTest{{
 			slices:
 				myslice:
 					contents:
-						/**:
+						/dir/:
+						/dir/setuid:
+						/dir/file:
+						/dir/link:
+						/dir/text: {text: data, mode: 0664}
 		`,
 	},
-	error: `cannot extract from package "test-package": cannot create path /[a-z0-9\-\/]*/file outside of root /[a-z0-9\-\/]*`,
-}, {
-	summary: "Extract conflicting paths with prefer from proper package",
-	slices: []setup.SliceKey{
-		{"test-package1", "myslice"},
-		{"test-package2", "myslice"},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Normalize = &fsutil.NormalizeOptions{Umask: 022, StripSetuid: true}
+	},
+	manifestPaths: map[string]string{
+		"/dir/":       "dir 0755 {test-package_myslice}",
+		"/dir/setuid": "file 0755 2c26b46b {test-package_myslice}",
+		"/dir/file":   "file 0644 fcde2b2e {test-package_myslice}",
+		"/dir/link":   "symlink file {test-package_myslice}",
+		"/dir/text":   "file 0644 3a6eb079 {test-package_myslice}",
+	},
+	manifestPrivileged: map[string]string{
+		"/dir/setuid": "04755 {test-package_myslice} stripped",
 	},
+}, {
+	summary: "Setuid and setgid bits are recorded in the manifest",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
-		Name: "test-package1",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-		}),
-	}, {
-		Name: "test-package2",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "bar"),
-		}),
-	}, {
-		Name: "test-package3",
+		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./setuid", "foo"),
+			testutil.Reg(02755, "./setgid", "bar"),
 		}),
 	}},
 	release: map[string]string{
-		"slices/mydir/test-package1.yaml": `
-			package: test-package1
-			slices:
-				myslice:
-					contents:
-						/file: {prefer: test-package2}
-						/link: {symlink: /file1}
-						/text: {text: foo, prefer: test-package3}
-		`,
-		"slices/mydir/test-package2.yaml": `
-			package: test-package2
-			slices:
-				myslice:
-					contents:
-						/file:
-						/link: {symlink: /file2, prefer: test-package3}
-		`,
-		"slices/mydir/test-package3.yaml": `
-			package: test-package3
+		"slices/mydir/test-package.yaml": `
+			package: test-package
 			slices:
 				myslice:
 					contents:
-						/link: {symlink: /file2, prefer: test-package1}
-						/text: {text: bar}
+						/setuid:
+						/setgid:
 		`,
 	},
-	filesystem: map[string]string{
-		"/file": "file 0644 fcde2b2e",
-		"/link": "symlink /file1",
-		"/text": "file 0644 2c26b46b",
-	},
 	manifestPaths: map[string]string{
-		"/file": "file 0644 fcde2b2e {test-package2_myslice}",
-		"/link": "symlink /file1 {test-package1_myslice}",
-		"/text": "file 0644 2c26b46b {test-package1_myslice}",
+		"/setuid": "file 04755 2c26b46b {test-package_myslice}",
+		"/setgid": "file 02755 fcde2b2e {test-package_myslice}",
 	},
-}, {
-	summary: "Warning when implicit parent directories conflict",
-	slices: []setup.SliceKey{
-		{"test-package1", "myslice"},
-		{"test-package2", "myslice"},
+	manifestPrivileged: map[string]string{
+		"/setuid": "04755 {test-package_myslice}",
+		"/setgid": "02755 {test-package_myslice}",
 	},
+}, {
+	summary: "Setuid policy warns about setuid and setgid files",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	pkgs: []*testutil.TestPackage{{
-		Name: "test-package1",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			// Note that both implicit parents have different permissions.
-			testutil.Dir(0766, "./parent/"),
-			testutil.Reg(0644, "./parent/foo", "whatever"),
-		}),
-	}, {
-		Name: "test-package2",
+		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			// And here.
-			testutil.Dir(0755, "./parent/"),
-			testutil.Reg(0644, "./parent/bar", "whatever"),
+			testutil.Dir(02775, "./dir/"),
+			testutil.Reg(06755, "./dir/setuid", "foo"),
 		}),
 	}},
 	release: map[string]string{
-		"slices/mydir/test-package1.yaml": `
-			package: test-package1
-			slices:
-				myslice:
-					contents:
-						/parent/foo:
-		`,
-		"slices/mydir/test-package2.yaml": `
-			package: test-package2
+		"slices/mydir/test-package.yaml": `
+			package: test-package
 			slices:
 				myslice:
 					contents:
-						/parent/bar:
+						/dir/setuid:
 		`,
 	},
-	logOutput: `(?s).*Warning: Path "/parent/" has diverging modes in different packages\. Please report\..*`,
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SetuidPolicy = slicer.SetuidWarn
+	},
+	manifestPrivileged: map[string]string{
+		"/dir/setuid": "06755 {test-package_myslice}",
+	},
+	logOutput: `(?s).*Warning: Path /dir/setuid is setuid and setgid\n.*`,
 }, {
-	summary: "Arch specific slice is not installed when it does not match requested arch",
+	summary: "Setuid policy strips setuid and setgid bits",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
-	arch:    "amd64",
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./setuid", "foo"),
+			testutil.Reg(02755, "./setgid", "bar"),
+			testutil.Reg(0755, "./plain", "data"),
+		}),
+	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
-					v3-essential:
-						test-package_not-installed: {arch: arm64}
-					contents:
-				not-installed:
 					contents:
-						/dir/file:
+						/setuid:
+						/setgid:
+						/plain:
 		`,
 	},
-	filesystem:    map[string]string{},
-	manifestPaths: map[string]string{},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SetuidPolicy = slicer.SetuidStrip
+	},
+	manifestPaths: map[string]string{
+		"/setuid": "file 0755 2c26b46b {test-package_myslice}",
+		"/setgid": "file 0755 fcde2b2e {test-package_myslice}",
+		"/plain":  "file 0755 3a6eb079 {test-package_myslice}",
+	},
+	manifestPrivileged: map[string]string{
+		"/setuid": "04755 {test-package_myslice} stripped",
+		"/setgid": "02755 {test-package_myslice} stripped",
+	},
 }, {
-	summary: "Arch specific slice is installed when it matches requested arch",
+	summary: "Setuid policy fails on setuid files",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
-	arch:    "arm64",
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./setuid", "foo"),
+		}),
+	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
-					v3-essential:
-						test-package_installed: {arch: arm64}
-					contents:
-				installed:
 					contents:
-						/dir/file:
+						/setuid:
 		`,
 	},
-	filesystem: map[string]string{
-		"/dir/":     "dir 0755",
-		"/dir/file": "file 0644 cc55e2ec",
-	},
-	manifestPaths: map[string]string{
-		"/dir/file": "file 0644 cc55e2ec {test-package_installed}",
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SetuidPolicy = slicer.SetuidFail
 	},
+	error: `cannot extract from package "test-package": cannot create /setuid: setuid bits rejected by the setuid policy`,
 }, {
-	summary: "Transitive essential",
-	slices:  []setup.SliceKey{{"test-package", "first"}},
+	summary: "Setuid files removed after mutation are not recorded",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./setuid", "foo"),
+			testutil.Reg(0644, "./file", "bar"),
+		}),
+	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
-				first:
-					essential:
-						- test-package_second
-					contents:
-				second:
-					essential:
-						- test-package_third
-					contents:
-				third:
+				myslice:
 					contents:
-						/dir/file:
+						/setuid: {until: mutate}
+						/file:
 		`,
 	},
-	filesystem: map[string]string{
-		"/dir/":     "dir 0755",
-		"/dir/file": "file 0644 cc55e2ec",
-	},
-	manifestPaths: map[string]string{
-		"/dir/file": "file 0644 cc55e2ec {test-package_third}",
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SetuidPolicy = slicer.SetuidAllow
 	},
+	manifestPrivileged: map[string]string{},
 }}
 
+const fakePython = `#!/bin/sh
+case "$2" in
+*cache_tag*)
+	echo fake-3
+	;;
+*)
+	while IFS="$(printf '\t')" read -r src out name; do
+		echo "$name" > "$out"
+	done
+	;;
+esac
+`
+
 func (s *S) TestRun(c *C) {
 	// Run tests for "archives" field in "v1" format.
 	runSlicerTests(s, c, slicerTests)
@@ -2108,6 +4539,9 @@ func runSlicerTests(s *S, c *C, tests []slicerTest) {
 				Archives:  archives,
 				TargetDir: c.MkDir(),
 			}
+			if test.ownership != nil {
+				options.Ownership = ownership.New()
+			}
 			if test.hackopt != nil {
 				test.hackopt(c, &options)
 			}
@@ -2118,7 +4552,19 @@ func runSlicerTests(s *S, c *C, tests []slicerTest) {
 			}
 			c.Assert(err, IsNil)
 
-			if test.filesystem == nil && test.manifestPaths == nil && test.manifestPkgs == nil && test.logOutput == "" {
+			if test.ownership != nil {
+				owners := make(map[string]string)
+				for _, path := range options.Ownership.Paths() {
+					owner := options.Ownership.Owner(path)
+					owners[path] = fmt.Sprintf("%d:%d", owner.UID, owner.GID)
+					if label := options.Ownership.Label(path); label != "" {
+						owners[path] += " " + label
+					}
+				}
+				c.Assert(owners, DeepEquals, test.ownership)
+			}
+
+			if test.filesystem == nil && test.manifestPaths == nil && test.manifestPkgs == nil && test.manifestPrivileged == nil && test.logOutput == "" {
 				continue
 			}
 			mfest := readManifest(c, options.TargetDir, manifestPath)
@@ -2149,6 +4595,13 @@ func runSlicerTests(s *S, c *C, tests []slicerTest) {
 				c.Assert(pkgsDump, DeepEquals, test.manifestPkgs)
 			}
 
+			// Assert state of the privileged paths recorded in the manifest.
+			if test.manifestPrivileged != nil {
+				privilegedDump, err := dumpManifestPrivileged(mfest)
+				c.Assert(err, IsNil)
+				c.Assert(privilegedDump, DeepEquals, test.manifestPrivileged)
+			}
+
 			// Find the log output of this test by trimming the suite output
 			// until we find the last occurrence of the summary.
 			testLogs := strings.Split(c.GetTestLog(), logMarker)
@@ -2172,18 +4625,30 @@ func treeDumpManifestPaths(mfest *manifest.Manifest) (map[string]string, error)
 		switch {
 		case strings.HasSuffix(path.Path, "/"):
 			fsDump = fmt.Sprintf("dir %s", path.Mode)
+		case path.Link != "" && path.OriginalLink != "":
+			fsDump = fmt.Sprintf("symlink %s (was %s)", path.Link, path.OriginalLink)
 		case path.Link != "":
 			fsDump = fmt.Sprintf("symlink %s", path.Link)
 		default: // Regular
 			if path.Size == 0 {
 				fsDump = fmt.Sprintf("file %s empty", path.Mode)
-			} else if path.FinalSHA256 != "" {
+			} else if path.FinalSHA256 != path.SHA256 {
 				fsDump = fmt.Sprintf("file %s %s %s", path.Mode, path.SHA256[:8], path.FinalSHA256[:8])
 			} else {
 				fsDump = fmt.Sprintf("file %s %s", path.Mode, path.SHA256[:8])
 			}
 		}
 
+		if path.Conffile {
+			fsDump += " conffile"
+		}
+		if path.DivertedFrom != "" {
+			fsDump = fmt.Sprintf("%s (from %s)", fsDump, path.DivertedFrom)
+		}
+		if path.HostSource != "" {
+			fsDump = fmt.Sprintf("%s (host %s)", fsDump, filepath.Base(path.HostSource))
+		}
+
 		if path.Inode != 0 {
 			// Append <inode> to the end of the path dump.
 			fsDump = fmt.Sprintf("%s <%d>", fsDump, path.Inode)
@@ -2208,6 +4673,9 @@ func dumpManifestPkgs(mfest *manifest.Manifest) (map[string]string, error) {
 	result := map[string]string{}
 	err := mfest.IteratePackages(func(pkg *manifest.Package) error {
 		result[pkg.Name] = fmt.Sprintf("%s %s %s %s", pkg.Name, pkg.Version, pkg.Arch, pkg.Digest)
+		if len(pkg.Licenses) > 0 {
+			result[pkg.Name] += " {" + strings.Join(pkg.Licenses, ",") + "}"
+		}
 		return nil
 	})
 	if err != nil {
@@ -2216,6 +4684,21 @@ func dumpManifestPkgs(mfest *manifest.Manifest) (map[string]string, error) {
 	return result, nil
 }
 
+func dumpManifestPrivileged(mfest *manifest.Manifest) (map[string]string, error) {
+	result := map[string]string{}
+	security, err := mfest.Security()
+	if err != nil || security == nil {
+		return result, err
+	}
+	for _, path := range security.Privileged {
+		result[path.Path] = fmt.Sprintf("%s {%s}", path.Mode, strings.Join(path.Slices, ","))
+		if path.Stripped {
+			result[path.Path] += " stripped"
+		}
+	}
+	return result, nil
+}
+
 func readManifest(c *C, targetDir, manifestPath string) *manifest.Manifest {
 	f, err := os.Open(path.Join(targetDir, manifestPath))
 	c.Assert(err, IsNil)
@@ -2241,3 +4724,83 @@ func readManifest(c *C, targetDir, manifestPath string) *manifest.Manifest {
 
 	return mfest
 }
+
+func (s *S) TestResolveSlice(c *C) {
+	data := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(04755, "./usr/bin/app", "app"),
+		testutil.Lnk(0777, "./usr/bin/app-link", "app"),
+		testutil.Dir(0755, "./usr/lib/"),
+		testutil.Reg(0644, "./usr/lib/liba.so.1", "liba"),
+		testutil.Reg(0644, "./usr/lib/libb.so.1", "libb-data"),
+		testutil.Reg(0644, "./etc/app.conf.default", "conf"),
+	})
+	contents, err := slicer.ListPackage(bytes.NewReader(data), nil)
+	c.Assert(err, IsNil)
+	c.Assert(contents, HasLen, 8)
+	c.Assert(contents[2], DeepEquals, slicer.PackagePath{Path: "/usr/bin/app", Mode: 0755 | fs.ModeSetuid, Size: 3})
+	c.Assert(contents[3], DeepEquals, slicer.PackagePath{Path: "/usr/bin/app-link", Mode: 0777 | fs.ModeSymlink, Link: "app"})
+
+	slice := &setup.Slice{
+		Package: "mypkg",
+		Name:    "all",
+		Contents: map[string]setup.PathInfo{
+			"/usr/bin/app*":      {Kind: setup.GlobPath},
+			"/usr/lib/":          {Kind: setup.CopyPath},
+			"/usr/lib/lib*.so.1": {Kind: setup.GlobPath, Arch: []string{"amd64"}},
+			"/etc/app.conf":      {Kind: setup.CopyPath, Info: "/etc/app.conf.default"},
+			"/etc/other.conf":    {Kind: setup.CopyPath},
+			"/etc/text":          {Kind: setup.TextPath, Info: "text"},
+		},
+	}
+	resolved, missing := slicer.ResolveSlice(slice, "arm64", contents)
+	var targets []string
+	for _, path := range resolved {
+		targets = append(targets, path.Target)
+	}
+	c.Assert(targets, DeepEquals, []string{"/etc/app.conf", "/usr/bin/app", "/usr/bin/app-link", "/usr/lib/"})
+	c.Assert(resolved[0].Path, Equals, "/etc/app.conf.default")
+	c.Assert(missing, DeepEquals, []string{"/etc/other.conf"})
+
+	resolved, _ = slicer.ResolveSlice(slice, "amd64", contents)
+	c.Assert(resolved, HasLen, 6)
+	c.Assert(resolved[4].Target, Equals, "/usr/lib/liba.so.1")
+	c.Assert(resolved[5].Size, Equals, int64(9))
+}
+
+func (s *S) TestResolveSliceUsrMerge(c *C) {
+	data := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./bin/"),
+		testutil.Reg(0755, "./bin/app", "app"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/other", "other"),
+	})
+	release := &setup.Release{UsrMerge: true}
+	contents, err := slicer.ListPackage(bytes.NewReader(data), slicer.PathMapper(release))
+	c.Assert(err, IsNil)
+	var paths []string
+	for _, entry := range contents {
+		paths = append(paths, entry.Path)
+	}
+	c.Assert(paths, DeepEquals, []string{"/usr/bin/", "/usr/bin/app", "/usr/", "/usr/bin/", "/usr/bin/other"})
+
+	slice := &setup.Slice{
+		Package: "mypkg",
+		Name:    "bins",
+		Contents: map[string]setup.PathInfo{
+			"/usr/bin/app":   {Kind: setup.CopyPath},
+			"/usr/bin/other": {Kind: setup.CopyPath},
+		},
+	}
+	resolved, missing := slicer.ResolveSlice(slice, "amd64", contents)
+	c.Assert(resolved, HasLen, 2)
+	c.Assert(missing, HasLen, 0)
+
+	release.UsrMerge = false
+	contents, err = slicer.ListPackage(bytes.NewReader(data), slicer.PathMapper(release))
+	c.Assert(err, IsNil)
+	_, missing = slicer.ResolveSlice(slice, "amd64", contents)
+	c.Assert(missing, DeepEquals, []string{"/usr/bin/app"})
+}
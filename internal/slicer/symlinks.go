@@ -0,0 +1,132 @@
+package slicer
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkTarget classifies the targets of symlinks.
+type SymlinkTarget string
+
+const (
+	// SymlinkRelative targets are relative paths within the root.
+	SymlinkRelative SymlinkTarget = "relative"
+	// SymlinkAbsolute targets are absolute paths, which refer to the host
+	// when the tree is accessed from outside of it.
+	SymlinkAbsolute SymlinkTarget = "absolute"
+	// SymlinkEscaping targets are relative paths going above the root.
+	SymlinkEscaping SymlinkTarget = "escaping"
+)
+
+// SymlinkAction is what happens to a symlink extracted from a package.
+type SymlinkAction string
+
+const (
+	// SymlinkAllow creates the symlink as it is in the package.
+	SymlinkAllow SymlinkAction = "allow"
+	// SymlinkReject fails the cut.
+	SymlinkReject SymlinkAction = "reject"
+	// SymlinkRewrite creates the symlink with a relative target pointing
+	// to the path the original target has when the root is the
+	// filesystem root.
+	SymlinkRewrite SymlinkAction = "rewrite"
+)
+
+// SymlinkPolicy decides what happens to the symlinks extracted from
+// packages, depending on their targets. Symlinks with relative targets
+// within the root are always allowed. The zero value allows all symlinks.
+//
+// Regardless of the policy, content is never written through symlinks
+// which resolve outside of the root.
+type SymlinkPolicy struct {
+	Absolute SymlinkAction
+	Escaping SymlinkAction
+}
+
+// Set sets the action for the symlinks with the given kind of target.
+func (p *SymlinkPolicy) Set(target SymlinkTarget, action SymlinkAction) error {
+	switch action {
+	case SymlinkAllow, SymlinkReject, SymlinkRewrite:
+	default:
+		return fmt.Errorf("invalid symlink action: %q", action)
+	}
+	switch target {
+	case SymlinkAbsolute:
+		p.Absolute = action
+	case SymlinkEscaping:
+		p.Escaping = action
+	default:
+		return fmt.Errorf("invalid symlink target: %q", target)
+	}
+	return nil
+}
+
+func (p SymlinkPolicy) String() string {
+	absolute, escaping := p.Absolute, p.Escaping
+	if absolute == "" {
+		absolute = SymlinkAllow
+	}
+	if escaping == "" {
+		escaping = SymlinkAllow
+	}
+	return fmt.Sprintf("%s=%s,%s=%s", SymlinkAbsolute, absolute, SymlinkEscaping, escaping)
+}
+
+// symlinkTarget returns the kind of target of the symlink at relPath.
+func symlinkTarget(relPath, target string) SymlinkTarget {
+	if path.IsAbs(target) {
+		return SymlinkAbsolute
+	}
+	depth := strings.Count(strings.Trim(path.Dir(relPath), "/"), "/")
+	if path.Dir(relPath) != "/" {
+		depth++
+	}
+	for _, name := range strings.Split(target, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return SymlinkEscaping
+			}
+		default:
+			depth++
+		}
+	}
+	return SymlinkRelative
+}
+
+// apply returns the target the symlink at relPath is created with, or an
+// error if the policy rejects it.
+func (p *SymlinkPolicy) apply(relPath, target string) (string, error) {
+	kind := symlinkTarget(relPath, target)
+	var action SymlinkAction
+	switch kind {
+	case SymlinkAbsolute:
+		action = p.Absolute
+	case SymlinkEscaping:
+		action = p.Escaping
+	}
+	switch action {
+	case SymlinkReject:
+		return "", fmt.Errorf("cannot create symlink %s: %s target %s rejected by the symlink policy", relPath, kind, target)
+	case SymlinkRewrite:
+		dir := path.Dir(relPath)
+		// Joining with the absolute directory stops ".." at the root, as
+		// the kernel does when resolving paths.
+		resolved := path.Clean(target)
+		if kind == SymlinkEscaping {
+			resolved = path.Join(dir, target)
+		}
+		rewritten, err := filepath.Rel(dir, resolved)
+		if err != nil {
+			return "", err
+		}
+		rewritten = filepath.ToSlash(rewritten)
+		debugf("Symlink %s rewritten: %s => %s", relPath, target, rewritten)
+		return rewritten, nil
+	}
+	return target, nil
+}
@@ -0,0 +1,227 @@
+package slicer
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/strdist"
+)
+
+// localeDirs lists the directories holding one subdirectory per locale.
+var localeDirs = []string{
+	"/usr/share/locale/",
+	"/usr/lib/locale/",
+}
+
+const zoneinfoDir = "/usr/share/zoneinfo/"
+
+// alwaysKeptLocales are never trimmed as they are expected to be present
+// in every system.
+var alwaysKeptLocales = map[string]bool{
+	"C":     true,
+	"POSIX": true,
+}
+
+// trimmer decides which locale and timezone data, and which paths excluded
+// from the selection, are left out of the extracted content. A nil list
+// means nothing is trimmed.
+//
+// Timezones are often symlinks to others, as UTC is to Etc/UTC, which may
+// come before or after them in the package. The timezone entries trimmed
+// are thus held, so that they are extracted after all if a timezone kept
+// turns out to point to them, and the targets not seen yet are kept once
+// they come.
+type trimmer struct {
+	locales   []string
+	timezones []string
+	excluded  []string
+
+	held   map[string]*heldEntry
+	needed map[string]bool
+}
+
+// heldEntry is a timezone entry trimmed, which may still be extracted.
+type heldEntry struct {
+	extractInfos []deb.ExtractInfo
+	options      fsutil.CreateOptions
+	data         []byte
+}
+
+// hold keeps the timezone entry at relPath, which was trimmed, in case a
+// timezone extracted later points to it. Other entries are dropped.
+func (t *trimmer) hold(relPath string, extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+	if t.timezones == nil || !strings.HasPrefix(relPath, zoneinfoDir) || t.isExcluded(relPath) {
+		return nil
+	}
+	if _, ok := t.held[relPath]; ok && extractInfos == nil {
+		// Parent directories are created again for each entry in them,
+		// without the slices which selected them.
+		return nil
+	}
+	entry := &heldEntry{
+		extractInfos: extractInfos,
+		options:      *o,
+	}
+	if o.Data != nil {
+		data, err := io.ReadAll(o.Data)
+		if err != nil {
+			return err
+		}
+		entry.data = data
+		entry.options.Data = nil
+	}
+	if t.held == nil {
+		t.held = make(map[string]*heldEntry)
+	}
+	t.held[relPath] = entry
+	return nil
+}
+
+// release returns the entries held which the timezone entry at relPath,
+// about to be extracted, depends on: its parent directories and, for
+// links, the entry it points to. They must be extracted before it, in
+// the order returned. Targets not seen yet are kept once they come.
+func (t *trimmer) release(relPath string, o *fsutil.CreateOptions) []*heldEntry {
+	if t.timezones == nil || !strings.HasPrefix(relPath, zoneinfoDir) {
+		return nil
+	}
+	if t.needed == nil {
+		t.needed = make(map[string]bool)
+	}
+	var released []*heldEntry
+	take := func(heldPath string) bool {
+		entry, ok := t.held[heldPath]
+		if ok {
+			delete(t.held, heldPath)
+			// The entry is not trimmed again once extracted.
+			t.needed[strings.TrimSuffix(heldPath, "/")] = true
+			if entry.data != nil {
+				entry.options.Data = bytes.NewReader(entry.data)
+			}
+			released = append(released, entry)
+		}
+		return ok
+	}
+	parent := zoneinfoDir
+	for _, name := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(relPath, zoneinfoDir), "/"), "/") {
+		take(parent)
+		parent += name + "/"
+	}
+	if o.Link == "" {
+		return released
+	}
+	if o.Mode.IsRegular() {
+		// Hard links point to the real path of their target, and those
+		// to entries not extracted are extracted as files instead.
+		target := filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(o.Link, o.Root)))
+		take(target)
+		return released
+	}
+	target := o.Link
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(strings.TrimSuffix(relPath, "/")), target)
+	}
+	target = path.Clean(target)
+	if !strings.HasPrefix(target, zoneinfoDir) {
+		return released
+	}
+	if !take(target) && !take(target+"/") {
+		t.needed[target] = true
+	}
+	return released
+}
+
+// skip reports whether the entry at relPath should not be extracted. Paths
+// of directories must end in "/".
+func (t *trimmer) skip(relPath string) bool {
+	if t.isExcluded(relPath) {
+		return true
+	}
+	if t.locales != nil {
+		for _, dir := range localeDirs {
+			rest, ok := strings.CutPrefix(relPath, dir)
+			if !ok {
+				continue
+			}
+			name, _, ok := strings.Cut(rest, "/")
+			// Files directly under the directory, such as locale.alias or
+			// locale-archive, are not locale specific.
+			if ok && !t.keepLocale(name) {
+				return true
+			}
+		}
+	}
+	if t.timezones != nil {
+		if rest, ok := strings.CutPrefix(relPath, zoneinfoDir); ok {
+			return !t.keepZone(rest) && !t.isNeeded(relPath)
+		}
+	}
+	return false
+}
+
+// isNeeded reports whether relPath is, or is a parent directory of, the
+// target of a timezone symlink extracted before.
+func (t *trimmer) isNeeded(relPath string) bool {
+	for target := range t.needed {
+		if target == strings.TrimSuffix(relPath, "/") || strings.HasSuffix(relPath, "/") && strings.HasPrefix(target, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether relPath was excluded from the selection.
+func (t *trimmer) isExcluded(relPath string) bool {
+	for _, pattern := range t.excluded {
+		if strdist.GlobPath(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepLocale reports whether the locale directory name matches one of the
+// selected locales. A selected locale such as "pt_BR" also keeps variants
+// like "pt_BR.utf8" and the language fallback "pt".
+func (t *trimmer) keepLocale(name string) bool {
+	base, _, _ := strings.Cut(name, "@")
+	base, _, _ = strings.Cut(base, ".")
+	if alwaysKeptLocales[base] {
+		return true
+	}
+	for _, locale := range t.locales {
+		lang, _, _ := strings.Cut(locale, "_")
+		if base == locale || base == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// keepZone reports whether the path relative to the zoneinfo directory
+// should be kept. Zone names start with an uppercase letter, anything else
+// (zone.tab, tzdata.zi, etc) is kept. The posix/ and right/ variants follow
+// the same rules as the main tree.
+func (t *trimmer) keepZone(rest string) bool {
+	for _, prefix := range []string{"posix/", "right/"} {
+		if after, ok := strings.CutPrefix(rest, prefix); ok {
+			rest = after
+			break
+		}
+	}
+	if rest == "" || rest[0] < 'A' || rest[0] > 'Z' {
+		return true
+	}
+	isDir := strings.HasSuffix(rest, "/")
+	for _, zone := range t.timezones {
+		if rest == zone || isDir && strings.HasPrefix(zone+"/", rest) {
+			return true
+		}
+	}
+	return false
+}
@@ -101,9 +101,15 @@ func Distance(a, b string, f CostFunc, cut int64) int64 {
 //
 // Supported wildcards:
 //
-//	?  - Any one character, except for /
-//	*  - Any zero or more characters, except for /
-//	** - Any zero or more characters, including /
+//	?     - Any one character, except for /
+//	*     - Any zero or more characters, except for /
+//	**    - Any zero or more characters, including /
+//	[...] - Any one character in the class, except for /, as in [a-z0-9],
+//	        or not in the class when it starts with ! or ^
+//
+// A [ which is not closed by a ] is matched literally. When both a and b
+// hold classes at the same position, they match if the classes share any
+// character.
 func GlobPath(a, b string) bool {
 	if !wildcardPrefixMatch(a, b) {
 		// Fast path.
@@ -116,7 +122,29 @@ func GlobPath(a, b string) bool {
 
 	a = strings.ReplaceAll(a, "**", "⁑")
 	b = strings.ReplaceAll(b, "**", "⁑")
-	return Distance(a, b, globCost, 1) == 0
+	if !strings.Contains(a, "[") && !strings.Contains(b, "[") {
+		return Distance(a, b, globCost, 1) == 0
+	}
+	var classes globClasses
+	a = classes.replace(a)
+	b = classes.replace(b)
+	return Distance(a, b, classes.cost, 1) == 0
+}
+
+// ContainsWildcard returns true if path holds any of the wildcards
+// supported by GlobPath.
+func ContainsWildcard(path string) bool {
+	if strings.ContainsAny(path, "*?") {
+		return true
+	}
+	for i := range path {
+		if path[i] == '[' {
+			if _, n := parseClass(path[i:]); n > 0 {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func globCost(ar, br rune) Cost {
@@ -135,12 +163,174 @@ func globCost(ar, br rune) Cost {
 	return Cost{SwapAB: 1, DeleteA: 1, InsertB: 1}
 }
 
+// classBase is the first of the runes from the Unicode private use area
+// standing for the character classes while globbing.
+const classBase = '\uE000'
+
+// globClasses holds the character classes found in the globs being
+// matched, each one replaced by the rune classBase+i, where i is its
+// index.
+type globClasses struct {
+	texts   []string
+	classes []*charClass
+}
+
+// replace returns glob with its classes replaced by their runes.
+func (gc *globClasses) replace(glob string) string {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		if glob[i] != '[' {
+			buf.WriteByte(glob[i])
+			continue
+		}
+		class, n := parseClass(glob[i:])
+		if n == 0 {
+			buf.WriteByte(glob[i])
+			continue
+		}
+		text := glob[i : i+n]
+		index := -1
+		for j, t := range gc.texts {
+			if t == text {
+				index = j
+			}
+		}
+		if index == -1 {
+			index = len(gc.texts)
+			gc.texts = append(gc.texts, text)
+			gc.classes = append(gc.classes, class)
+		}
+		buf.WriteRune(classBase + rune(index))
+		i += n - 1
+	}
+	return buf.String()
+}
+
+func (gc *globClasses) class(r rune) *charClass {
+	if r >= classBase && int(r-classBase) < len(gc.classes) {
+		return gc.classes[r-classBase]
+	}
+	return nil
+}
+
+func (gc *globClasses) cost(ar, br rune) Cost {
+	ac, bc := gc.class(ar), gc.class(br)
+	if ac == nil && bc == nil || ar == '⁑' || br == '⁑' || ar == '/' || br == '/' ||
+		ar == '*' || br == '*' || ar == '?' || br == '?' || ar < 0 || br < 0 {
+		return globCost(ar, br)
+	}
+	var match bool
+	switch {
+	case ac != nil && bc != nil:
+		match = ac.intersects(bc)
+	case ac != nil:
+		match = ac.matches(br)
+	default:
+		match = bc.matches(ar)
+	}
+	if match {
+		return Cost{SwapAB: 0, DeleteA: 1, InsertB: 1}
+	}
+	return Cost{SwapAB: 1, DeleteA: 1, InsertB: 1}
+}
+
+// charClass is a set of characters as in [a-z0-9], or its complement as
+// in [!a-z0-9].
+type charClass struct {
+	negated bool
+	ranges  [][2]rune
+}
+
+// parseClass parses the class at the start of glob, returning it along
+// with its length, or a zero length if glob does not start with a class.
+func parseClass(glob string) (*charClass, int) {
+	class := &charClass{}
+	runes := []rune(glob)
+	i := 1
+	if i < len(runes) && (runes[i] == '!' || runes[i] == '^') {
+		class.negated = true
+		i++
+	}
+	for first := true; i < len(runes); first = false {
+		r := runes[i]
+		if r == ']' && !first {
+			return class, len(string(runes[:i+1]))
+		}
+		if r == '/' {
+			return nil, 0
+		}
+		if i+2 < len(runes) && runes[i+1] == '-' && runes[i+2] != ']' {
+			if runes[i+2] < r {
+				return nil, 0
+			}
+			class.ranges = append(class.ranges, [2]rune{r, runes[i+2]})
+			i += 3
+		} else {
+			class.ranges = append(class.ranges, [2]rune{r, r})
+			i++
+		}
+	}
+	return nil, 0
+}
+
+func (c *charClass) contains(r rune) bool {
+	for _, rng := range c.ranges {
+		if r >= rng[0] && r <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if the class matches r, which is never the case
+// for /.
+func (c *charClass) matches(r rune) bool {
+	return r != '/' && c.contains(r) != c.negated
+}
+
+// intersects returns true if some character is matched by both classes.
+func (c *charClass) intersects(other *charClass) bool {
+	if c.negated && other.negated {
+		// Each one excludes a finite set of characters.
+		return true
+	}
+	if !c.negated && !other.negated {
+		for _, a := range c.ranges {
+			for _, b := range other.ranges {
+				lo, hi := max(a[0], b[0]), min(a[1], b[1])
+				if lo < hi || lo == hi && lo != '/' {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	pos, neg := c, other
+	if c.negated {
+		pos, neg = other, c
+	}
+	for _, rng := range pos.ranges {
+		for r := rng[0]; r <= rng[1]; r++ {
+			if neg.matches(r) {
+				return true
+			}
+			// Skip the characters excluded by the negated class.
+			for _, nrng := range neg.ranges {
+				if r >= nrng[0] && r < nrng[1] {
+					r = min(nrng[1], rng[1])
+				}
+			}
+		}
+	}
+	return false
+}
+
 // wildcardPrefixMatch compares whether the prefixes of a and b are equal up
 // to the shortest one. The prefix is defined as the longest substring that
 // starts at index 0 and does not contain a wildcard.
 func wildcardPrefixMatch(a, b string) bool {
-	ai := strings.IndexAny(a, "*?")
-	bi := strings.IndexAny(b, "*?")
+	ai := strings.IndexAny(a, "*?[")
+	bi := strings.IndexAny(b, "*?[")
 	if ai == -1 {
 		ai = len(a)
 	}
@@ -155,13 +345,13 @@ func wildcardPrefixMatch(a, b string) bool {
 // to the shortest one. The suffix is defined as the longest substring that ends
 // at the string length and does not contain a wildcard.
 func wildcardSuffixMatch(a, b string) bool {
-	ai := strings.LastIndexAny(a, "*?")
+	ai := strings.LastIndexAny(a, "*?]")
 	la := 0
 	if ai != -1 {
 		la = len(a) - ai - 1
 	}
 	lb := 0
-	bi := strings.LastIndexAny(b, "*?")
+	bi := strings.LastIndexAny(b, "*?]")
 	if bi != -1 {
 		lb = len(b) - bi - 1
 	}
@@ -77,6 +77,51 @@ func (s *S) TestDistance(c *C) {
 	}
 }
 
+var globPathTests = []struct {
+	a, b  string
+	match bool
+}{
+	{a: "/lib/libc[0-9].so", b: "/lib/libc6.so", match: true},
+	{a: "/lib/libc[0-9].so", b: "/lib/libcx.so", match: false},
+	{a: "/lib/libc[!0-9].so", b: "/lib/libcx.so", match: true},
+	{a: "/lib/libc[^0-9].so", b: "/lib/libc6.so", match: false},
+	{a: "/lib/libc[abc].so", b: "/lib/libcb.so", match: true},
+	{a: "/lib/libc[]a].so", b: "/lib/libc].so", match: true},
+	{a: "/lib/[a-z]*", b: "/lib/libc.so", match: true},
+	{a: "/lib/[a-z]*", b: "/lib/Libc.so", match: false},
+	{a: "/lib[/]x", b: "/lib/x", match: false},
+	{a: "/lib/a[/]x", b: "/lib/a[/]x", match: true},
+	{a: "/lib/[a-z]", b: "/lib/[a-z]", match: true},
+	{a: "/lib/[a-m]", b: "/lib/[k-z]", match: true},
+	{a: "/lib/[a-m]", b: "/lib/[n-z]", match: false},
+	{a: "/lib/[!a-m]", b: "/lib/[a-m]", match: false},
+	{a: "/lib/[!a-m]", b: "/lib/[a-n]", match: true},
+	{a: "/lib/[!a]", b: "/lib/[!b]", match: true},
+	{a: "/lib/[a-z]", b: "/lib/?", match: true},
+	{a: "/lib/[a-z]", b: "/lib/**", match: true},
+	{a: "/lib/[a-z]/x", b: "/lib/*/x", match: true},
+	{a: "/lib/[a-z]", b: "/lib/ab", match: false},
+	{a: "/usr/bin/[", b: "/usr/bin/[", match: true},
+	{a: "/usr/bin/[", b: "/usr/bin/x", match: false},
+}
+
+func (s *S) TestGlobPath(c *C) {
+	for _, test := range globPathTests {
+		c.Logf("Test: %v", test)
+		c.Assert(strdist.GlobPath(test.a, test.b), Equals, test.match)
+		c.Assert(strdist.GlobPath(test.b, test.a), Equals, test.match)
+	}
+}
+
+func (s *S) TestContainsWildcard(c *C) {
+	c.Assert(strdist.ContainsWildcard("/usr/bin/*"), Equals, true)
+	c.Assert(strdist.ContainsWildcard("/usr/bin/?"), Equals, true)
+	c.Assert(strdist.ContainsWildcard("/usr/bin/[a-z]"), Equals, true)
+	c.Assert(strdist.ContainsWildcard("/usr/bin/["), Equals, false)
+	c.Assert(strdist.ContainsWildcard("/usr/bin/[/]"), Equals, false)
+	c.Assert(strdist.ContainsWildcard("/usr/bin/app"), Equals, false)
+}
+
 func BenchmarkDistance(b *testing.B) {
 	const one = "abdefghijklmnopqrstuvwxyz"
 	const two = "a.d.f.h.j.l.n.p.r.t.v.x.z"
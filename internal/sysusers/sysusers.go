@@ -0,0 +1,432 @@
+// Package sysusers implements the creation of the users and groups declared
+// in sysusers.d configuration files, as systemd-sysusers would.
+package sysusers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+// ConfDirs lists the directories, relative to the root, where configuration
+// files are looked up, in order of precedence.
+var ConfDirs = []string{
+	"/etc/sysusers.d/",
+	"/usr/lib/sysusers.d/",
+}
+
+const (
+	passwdPath  = "/etc/passwd"
+	groupPath   = "/etc/group"
+	shadowPath  = "/etc/shadow"
+	gshadowPath = "/etc/gshadow"
+
+	// Range of ids allocated dynamically, from the top down.
+	minSystemID = 100
+	maxSystemID = 999
+
+	defaultHome  = "/"
+	defaultShell = "/usr/sbin/nologin"
+)
+
+// Item is an entry of a sysusers.d configuration file.
+type Item struct {
+	Type  byte
+	Name  string
+	ID    string
+	GECOS string
+	Home  string
+	Shell string
+}
+
+type database struct {
+	uids    map[int]bool
+	gids    map[int]bool
+	userIDs map[string]int
+	groupID map[string]int
+	// members holds the members of each group, by group name.
+	members map[string][]string
+	// membersChanged is set when members are added to groups.
+	membersChanged bool
+
+	newUsers  []string
+	newGroups []string
+}
+
+// Apply reads the sysusers.d configuration files in rootDir and adds the
+// users and groups declared to /etc/passwd and /etc/group, and to
+// /etc/shadow and /etc/gshadow when those are present. Users and groups
+// which already exist are left untouched. It returns the entries written.
+func Apply(rootDir string) ([]*fsutil.Entry, error) {
+	items, err := ReadConf(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	db := &database{
+		uids:    make(map[int]bool),
+		gids:    make(map[int]bool),
+		userIDs: make(map[string]int),
+		groupID: make(map[string]int),
+		members: make(map[string][]string),
+	}
+	passwd, err := readLines(rootDir, passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	group, err := readLines(rootDir, groupPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range passwd {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		db.uids[uid] = true
+		db.userIDs[fields[0]] = uid
+	}
+	for _, line := range group {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		db.gids[gid] = true
+		db.groupID[fields[0]] = gid
+		if len(fields) > 3 && fields[3] != "" {
+			db.members[fields[0]] = strings.Split(fields[3], ",")
+		}
+	}
+
+	// Groups go first so that users may refer to them by name.
+	for _, item := range items {
+		var err error
+		switch item.Type {
+		case 'g':
+			err = db.addGroup(item.Name, item.ID)
+		case 'u':
+			// Unless a group is given explicitly, the primary group of the
+			// user has the same name and, when possible, the same id.
+			_, userExists := db.userIDs[item.Name]
+			if !userExists && !strings.Contains(item.ID, ":") {
+				err = db.addGroup(item.Name, item.ID)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range items {
+		if item.Type == 'u' {
+			err := db.addUser(&item)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, item := range items {
+		if item.Type == 'm' {
+			if _, ok := db.groupID[item.ID]; !ok {
+				return nil, fmt.Errorf("cannot add user %q to group %q: group does not exist", item.Name, item.ID)
+			}
+			if !slices.Contains(db.members[item.ID], item.Name) {
+				db.members[item.ID] = append(db.members[item.ID], item.Name)
+				db.membersChanged = true
+			}
+		}
+	}
+
+	if len(db.newUsers) == 0 && len(db.newGroups) == 0 && !db.membersChanged {
+		return nil, nil
+	}
+
+	var entries []*fsutil.Entry
+	write := func(path string, lines []string, mode os.FileMode) error {
+		var buf bytes.Buffer
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Root:        rootDir,
+			Path:        path,
+			Mode:        mode,
+			Data:        &buf,
+			MakeParents: true,
+		})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	if len(db.newUsers) > 0 {
+		err = write(passwdPath, append(passwd, db.newUsers...), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	group = append(group, db.newGroups...)
+	for i, line := range group {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+		fields[3] = strings.Join(db.members[fields[0]], ",")
+		group[i] = strings.Join(fields, ":")
+	}
+	err = write(groupPath, group, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	shadowFiles := []struct {
+		path    string
+		entries []string
+	}{
+		{shadowPath, db.newUsers},
+		{gshadowPath, db.newGroups},
+	}
+	for _, shadow := range shadowFiles {
+		if len(shadow.entries) == 0 {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(rootDir, shadow.path))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		lines, err := readLines(rootDir, shadow.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range shadow.entries {
+			name, _, _ := strings.Cut(entry, ":")
+			if shadow.path == shadowPath {
+				lines = append(lines, name+":!*:::::::")
+			} else {
+				lines = append(lines, name+":!*::")
+			}
+		}
+		err = write(shadow.path, lines, info.Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// allocate returns the highest id in the system range which is not in use
+// by either users or groups.
+func (db *database) allocate() (int, error) {
+	for id := maxSystemID; id >= minSystemID; id-- {
+		if !db.uids[id] && !db.gids[id] {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot allocate id: no ids left in the system range")
+}
+
+func (db *database) addGroup(name, idSpec string) error {
+	if _, ok := db.groupID[name]; ok {
+		return nil
+	}
+	gid, err := strconv.Atoi(idSpec)
+	if err != nil || db.gids[gid] {
+		// Not a number (e.g. "-" or a path), or already used.
+		gid, err = db.allocate()
+		if err != nil {
+			return fmt.Errorf("cannot create group %q: %w", name, err)
+		}
+	}
+	db.gids[gid] = true
+	db.groupID[name] = gid
+	db.newGroups = append(db.newGroups, fmt.Sprintf("%s:x:%d:", name, gid))
+	return nil
+}
+
+func (db *database) addUser(item *Item) error {
+	if _, ok := db.userIDs[item.Name]; ok {
+		return nil
+	}
+	uidSpec, groupName, hasGroup := strings.Cut(item.ID, ":")
+	if !hasGroup {
+		groupName = item.Name
+	}
+	gid, ok := db.groupID[groupName]
+	if id, err := strconv.Atoi(groupName); err == nil && hasGroup {
+		gid, ok = id, true
+	}
+	if !ok {
+		return fmt.Errorf("cannot create user %q: group %q does not exist", item.Name, groupName)
+	}
+	uid, err := strconv.Atoi(uidSpec)
+	if err != nil || db.uids[uid] {
+		if !hasGroup && !db.uids[gid] {
+			uid = gid
+		} else {
+			uid, err = db.allocate()
+			if err != nil {
+				return fmt.Errorf("cannot create user %q: %w", item.Name, err)
+			}
+		}
+	}
+	home := item.Home
+	if home == "" || home == "-" {
+		home = defaultHome
+	}
+	shell := item.Shell
+	if shell == "" || shell == "-" {
+		shell = defaultShell
+	}
+	gecos := item.GECOS
+	if gecos == "-" {
+		gecos = ""
+	}
+	db.uids[uid] = true
+	db.userIDs[item.Name] = uid
+	db.newUsers = append(db.newUsers, fmt.Sprintf("%s:x:%d:%d:%s:%s:%s", item.Name, uid, gid, gecos, home, shell))
+	return nil
+}
+
+func readLines(rootDir, path string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(rootDir, path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// ReadConf returns the items in the configuration files found in rootDir.
+// Files are processed in the order of their names, and a file in /etc
+// replaces a file with the same name in /usr/lib.
+func ReadConf(rootDir string) ([]Item, error) {
+	files := make(map[string]string)
+	for i := len(ConfDirs) - 1; i >= 0; i-- {
+		matches, err := filepath.Glob(filepath.Join(rootDir, ConfDirs[i], "*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			files[filepath.Base(match)] = match
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var items []Item
+	for _, name := range names {
+		data, err := os.ReadFile(files[name])
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			fields, err := SplitFields(line)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse %s:%d: %w", name, i+1, err)
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("cannot parse %s:%d: missing name", name, i+1)
+			}
+			for len(fields) < 6 {
+				fields = append(fields, "-")
+			}
+			item := Item{
+				Name:  fields[1],
+				ID:    fields[2],
+				GECOS: fields[3],
+				Home:  fields[4],
+				Shell: fields[5],
+			}
+			switch fields[0] {
+			case "u", "u!":
+				item.Type = 'u'
+			case "g", "m":
+				item.Type = fields[0][0]
+			case "r":
+				// Ranges are not supported; ids are always allocated from
+				// the system range.
+				continue
+			default:
+				return nil, fmt.Errorf("cannot parse %s:%d: unknown type %q", name, i+1, fields[0])
+			}
+			if item.Type == 'm' && item.ID == "-" {
+				return nil, fmt.Errorf("cannot parse %s:%d: missing group", name, i+1)
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// SplitFields splits the line into whitespace separated fields, which may
+// be enclosed in single or double quotes.
+func SplitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
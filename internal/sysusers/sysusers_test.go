@@ -0,0 +1,127 @@
+package sysusers_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/sysusers"
+)
+
+func writeFiles(c *C, rootDir string, files map[string]string) {
+	for path, data := range files {
+		fpath := filepath.Join(rootDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func readFile(c *C, rootDir, path string) string {
+	data, err := os.ReadFile(filepath.Join(rootDir, path))
+	c.Assert(err, IsNil)
+	return string(data)
+}
+
+func (s *S) TestApply(c *C) {
+	rootDir := c.MkDir()
+	writeFiles(c, rootDir, map[string]string{
+		"/etc/passwd": "root:x:0:0:root:/root:/bin/bash\n",
+		"/etc/group":  "root:x:0:\n",
+		"/etc/shadow": "root:*:19000:0:99999:7:::\n",
+		"/usr/lib/sysusers.d/a.conf": `
+			# Comment.
+			g mygroup 500
+			u daemon1 - "Daemon One" /var/lib/daemon1
+			u daemon2 600:mygroup
+			u root 0
+			m daemon1 mygroup
+			r - 500-900
+		`,
+		"/usr/lib/sysusers.d/b.conf": "u shadowed -\n",
+		"/etc/sysusers.d/b.conf":     "u other -\n",
+	})
+
+	entries, err := sysusers.Apply(rootDir)
+	c.Assert(err, IsNil)
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	c.Assert(paths, DeepEquals, []string{
+		filepath.Join(rootDir, "/etc/passwd"),
+		filepath.Join(rootDir, "/etc/group"),
+		filepath.Join(rootDir, "/etc/shadow"),
+	})
+
+	c.Assert(readFile(c, rootDir, "/etc/passwd"), Equals, ""+
+		"root:x:0:0:root:/root:/bin/bash\n"+
+		"daemon1:x:999:999:Daemon One:/var/lib/daemon1:/usr/sbin/nologin\n"+
+		"daemon2:x:600:500::/:/usr/sbin/nologin\n"+
+		"other:x:998:998::/:/usr/sbin/nologin\n")
+	c.Assert(readFile(c, rootDir, "/etc/group"), Equals, ""+
+		"root:x:0:\n"+
+		"mygroup:x:500:daemon1\n"+
+		"daemon1:x:999:\n"+
+		"other:x:998:\n")
+	c.Assert(readFile(c, rootDir, "/etc/shadow"), Equals, ""+
+		"root:*:19000:0:99999:7:::\n"+
+		"daemon1:!*:::::::\n"+
+		"daemon2:!*:::::::\n"+
+		"other:!*:::::::\n")
+	_, err = os.Stat(filepath.Join(rootDir, "/etc/gshadow"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// Applying again changes nothing.
+	entries, err = sysusers.Apply(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *S) TestApplyNoConfig(c *C) {
+	entries, err := sysusers.Apply(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+var applyErrorTests = []struct {
+	conf  string
+	error string
+}{{
+	conf:  "x foo -\n",
+	error: `cannot parse a.conf:1: unknown type "x"`,
+}, {
+	conf:  "u\n",
+	error: `cannot parse a.conf:1: missing name`,
+}, {
+	conf:  "u foo - \"Foo\n",
+	error: `cannot parse a.conf:1: unterminated quote`,
+}, {
+	conf:  "m foo\n",
+	error: `cannot parse a.conf:1: missing group`,
+}, {
+	conf:  "m foo bar\n",
+	error: `cannot add user "foo" to group "bar": group does not exist`,
+}, {
+	conf:  "u foo 100:bar\n",
+	error: `cannot create user "foo": group "bar" does not exist`,
+}}
+
+func (s *S) TestApplyErrors(c *C) {
+	for _, test := range applyErrorTests {
+		rootDir := c.MkDir()
+		writeFiles(c, rootDir, map[string]string{
+			"/usr/lib/sysusers.d/a.conf": test.conf,
+		})
+		_, err := sysusers.Apply(rootDir)
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
+
+func (s *S) TestSplitFields(c *C) {
+	fields, err := sysusers.SplitFields(`u  foo	- "Foo Bar" '/home/foo bar'`)
+	c.Assert(err, IsNil)
+	c.Assert(fields, DeepEquals, []string{"u", "foo", "-", "Foo Bar", "/home/foo bar"})
+}
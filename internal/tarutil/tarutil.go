@@ -0,0 +1,102 @@
+// Package tarutil implements writing the content of a directory as a tar
+// stream, to export trees cut unprivileged.
+package tarutil
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/ownership"
+)
+
+// selinuxXattr is the PAX record holding the SELinux security context of
+// an entry, as understood by GNU tar with --selinux.
+const selinuxXattr = "SCHILY.xattr.security.selinux"
+
+// Write writes the content of dir as a tar stream to w. Entries are
+// owned by root unless other owners are recorded in db, which may be nil,
+// and files with multiple links are stored as hard links. Labels recorded
+// in db are stored as SELinux extended attributes.
+func Write(w io.Writer, dir string, db *ownership.DB) error {
+	tw := tar.NewWriter(w)
+	// Paths of files already written, by inode.
+	inodes := make(map[uint64]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			return nil
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = "./" + relPath
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "root", "root"
+		if db != nil {
+			if owner := db.Owner(relPath); owner != (ownership.Owner{}) {
+				// The names are unknown, so numeric IDs are used.
+				header.Uid, header.Gid = owner.UID, owner.GID
+				header.Uname, header.Gname = "", ""
+			}
+			if label := db.Label(relPath); label != "" {
+				header.PAXRecords = map[string]string{selinuxXattr: label}
+				header.Format = tar.FormatPAX
+			}
+		}
+		if info.Mode().IsRegular() {
+			if ino, nlink, ok := fsutil.Inode(info); ok && nlink > 1 {
+				if target, ok := inodes[ino]; ok {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = target
+					header.Size = 0
+					return tw.WriteHeader(header)
+				}
+				inodes[ino] = header.Name
+			}
+		}
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
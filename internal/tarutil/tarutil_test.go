@@ -0,0 +1,84 @@
+package tarutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/ownership"
+	"github.com/canonical/chisel/internal/tarutil"
+)
+
+func makeTree(c *C) string {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "etc/sub"), 0700), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "etc/file"), []byte("data"), 0640), IsNil)
+	c.Assert(os.Link(filepath.Join(dir, "etc/file"), filepath.Join(dir, "etc/hardlink")), IsNil)
+	c.Assert(os.Symlink("file", filepath.Join(dir, "etc/symlink")), IsNil)
+	return dir
+}
+
+func (s *S) TestWrite(c *C) {
+	var buf bytes.Buffer
+	err := tarutil.Write(&buf, makeTree(c), nil)
+	c.Assert(err, IsNil)
+
+	headers := make(map[string]string)
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		c.Assert(header.Uid, Equals, 0)
+		c.Assert(header.Gid, Equals, 0)
+		data, err := io.ReadAll(tr)
+		c.Assert(err, IsNil)
+		headers[header.Name] = string(header.Typeflag) + " " + header.Linkname + " " + string(data)
+	}
+	c.Assert(headers, DeepEquals, map[string]string{
+		"./etc/":         "5  ",
+		"./etc/file":     "0  data",
+		"./etc/hardlink": "1 ./etc/file ",
+		"./etc/sub/":     "5  ",
+		"./etc/symlink":  "2 file ",
+	})
+}
+
+func (s *S) TestWriteOwnership(c *C) {
+	db := ownership.New()
+	db.Set("/etc/file", ownership.Owner{UID: 0, GID: 42})
+	db.Set("/etc/sub/", ownership.Owner{UID: 1000, GID: 1000})
+	db.SetLabel("/etc/file", "system_u:object_r:etc_t:s0")
+
+	var buf bytes.Buffer
+	err := tarutil.Write(&buf, makeTree(c), db)
+	c.Assert(err, IsNil)
+
+	owners := make(map[string]string)
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		owners[header.Name] = fmt.Sprintf("%d:%d %s:%s", header.Uid, header.Gid, header.Uname, header.Gname)
+		if label, ok := header.PAXRecords["SCHILY.xattr.security.selinux"]; ok {
+			owners[header.Name] += " " + label
+		}
+	}
+	c.Assert(owners, DeepEquals, map[string]string{
+		"./etc/":         "0:0 root:root",
+		"./etc/file":     "0:42 : system_u:object_r:etc_t:s0",
+		"./etc/hardlink": "0:0 root:root",
+		"./etc/sub/":     "1000:1000 :",
+		"./etc/symlink":  "0:0 root:root",
+	})
+}
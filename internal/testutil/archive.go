@@ -20,23 +20,42 @@ type TestPackage struct {
 	Arch     string
 	Data     []byte
 	Archives []string
+	// InstalledSize is reported in the package info, in bytes.
+	InstalledSize int64
+	// Conflicts, Breaks and Replaces are reported in the package info.
+	Conflicts []archive.Relation
+	Breaks    []archive.Relation
+	Replaces  []archive.Relation
+	// Suite and Component are reported in the package info, and default
+	// to the first ones of the archive.
+	Suite     string
+	Component string
 }
 
 func (a *TestArchive) Options() *archive.Options {
 	return &a.Opts
 }
 
+func (a *TestArchive) Close() error {
+	return nil
+}
+
 func (a *TestArchive) Fetch(pkgName string) (io.ReadSeekCloser, *archive.PackageInfo, error) {
 	pkg, ok := a.Packages[pkgName]
 	if !ok {
 		return nil, nil, fmt.Errorf("cannot find package %q in archive", pkgName)
 	}
 	info := &archive.PackageInfo{
-		Name:    pkg.Name,
-		Version: pkg.Version,
-		SHA256:  pkg.Hash,
-		Arch:    pkg.Arch,
+		Name:          pkg.Name,
+		Version:       pkg.Version,
+		SHA256:        pkg.Hash,
+		Arch:          pkg.Arch,
+		InstalledSize: pkg.InstalledSize,
+		Conflicts:     pkg.Conflicts,
+		Breaks:        pkg.Breaks,
+		Replaces:      pkg.Replaces,
 	}
+	info.Suite, info.Component = a.location(pkg)
 	return ReadSeekNopCloser(bytes.NewReader(pkg.Data)), info, nil
 }
 
@@ -50,10 +69,28 @@ func (a *TestArchive) Info(pkgName string) (*archive.PackageInfo, error) {
 	if !ok {
 		return nil, fmt.Errorf("cannot find package %q in archive", pkgName)
 	}
-	return &archive.PackageInfo{
-		Name:    pkg.Name,
-		Version: pkg.Version,
-		SHA256:  pkg.Hash,
-		Arch:    pkg.Arch,
-	}, nil
+	info := &archive.PackageInfo{
+		Name:          pkg.Name,
+		Version:       pkg.Version,
+		SHA256:        pkg.Hash,
+		Arch:          pkg.Arch,
+		InstalledSize: pkg.InstalledSize,
+		Conflicts:     pkg.Conflicts,
+		Breaks:        pkg.Breaks,
+		Replaces:      pkg.Replaces,
+	}
+	info.Suite, info.Component = a.location(pkg)
+	return info, nil
+}
+
+// location returns the suite and component the package is reported in.
+func (a *TestArchive) location(pkg *TestPackage) (suite, component string) {
+	suite, component = pkg.Suite, pkg.Component
+	if suite == "" && len(a.Opts.Suites) > 0 {
+		suite = a.Opts.Suites[0]
+	}
+	if component == "" && len(a.Opts.Components) > 0 {
+		component = a.Opts.Components[0]
+	}
+	return suite, component
 }
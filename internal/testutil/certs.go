@@ -0,0 +1,44 @@
+package testutil
+
+type TestCert struct {
+	PEM string
+	// Hash is the subject hash as reported by "openssl x509 -subject_hash".
+	Hash string
+}
+
+// TestCerts holds self-signed certificates used for testing.
+var TestCerts = map[string]*TestCert{
+	"root1": {
+		// Subject: "/C=GB/O=Chisel  Test/CN=  Test Root CA ".
+		PEM: `-----BEGIN CERTIFICATE-----
+MIIB0jCCAXmgAwIBAgIUYss9yF3cNbBLGyHA0JubKRTRb6cwCgYIKoZIzj0EAwIw
+PjELMAkGA1UEBhMCR0IxFTATBgNVBAoMDENoaXNlbCAgVGVzdDEYMBYGA1UEAwwP
+ICBUZXN0IFJvb3QgQ0EgMCAXDTI2MTAxNzAzMTIwN1oYDzIxMjYwOTIzMDMxMjA3
+WjA+MQswCQYDVQQGEwJHQjEVMBMGA1UECgwMQ2hpc2VsICBUZXN0MRgwFgYDVQQD
+DA8gIFRlc3QgUm9vdCBDQSAwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAQaGAiR
+M+1qQiS33OsatvXhd0rHEZib9UDQih1DFfwAawocQ+7Pt0Dfb1GEksXRVEZh5dmN
+MY9sZafzuaTpohsjo1MwUTAdBgNVHQ4EFgQU3bfr0UCDhJmwRUw1cYDyiLmyAQMw
+HwYDVR0jBBgwFoAU3bfr0UCDhJmwRUw1cYDyiLmyAQMwDwYDVR0TAQH/BAUwAwEB
+/zAKBggqhkjOPQQDAgNHADBEAiBPm24ezfrHUT1tUqkPj7KraFSZ+IoIZnZZwl5E
+kQ2sFgIgM6gKFRC7UE9VOtTVZvSxnstsh/nHiWPUg1cl6K+EX8s=
+-----END CERTIFICATE-----
+`,
+		Hash: "26db9893",
+	},
+	"root2": {
+		// Subject: "/CN=Other Root".
+		PEM: `-----BEGIN CERTIFICATE-----
+MIIBgDCCASegAwIBAgIUUq+FF001FNUtByZlNLtx5LVGJqkwCgYIKoZIzj0EAwIw
+FTETMBEGA1UEAwwKT3RoZXIgUm9vdDAgFw0yNjEwMTcwMzEyMDdaGA8yMTI2MDky
+MzAzMTIwN1owFTETMBEGA1UEAwwKT3RoZXIgUm9vdDBZMBMGByqGSM49AgEGCCqG
+SM49AwEHA0IABDzO1PnpckxIfwg0yTrZuRPGdFMsghIFRD/f0d4HhJA0D6lHe8YV
+6WVK1kF4Q5xSKHc+SIBkApp800TF9JtlxXCjUzBRMB0GA1UdDgQWBBR2wcjYSuNO
+W9ntB1mX+NOaU0KkCDAfBgNVHSMEGDAWgBR2wcjYSuNOW9ntB1mX+NOaU0KkCDAP
+BgNVHRMBAf8EBTADAQH/MAoGCCqGSM49BAMCA0cAMEQCIGb7s0pn3nJdYnlMUvI9
+U/7LJgFtDXHWo+RfNEJCwqXPAiBdn2ShTzPrjMkljkIWzW2eimEkh1y5ifUPLvDp
+KW7vbg==
+-----END CERTIFICATE-----
+`,
+		Hash: "85cb99c3",
+	},
+}
@@ -0,0 +1,88 @@
+package testutil
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+)
+
+// MakeSharedLib returns a minimal 64-bit little-endian ELF shared object for
+// the given machine, holding only the dynamic section with the soname. If
+// soname is empty, the DT_SONAME entry is omitted.
+func MakeSharedLib(machine elf.Machine, soname string) []byte {
+	return MakeELF(machine, soname)
+}
+
+// MakeELF returns a minimal 64-bit little-endian ELF shared object as
+// MakeSharedLib does, which also requires the given libraries with
+// DT_NEEDED entries.
+func MakeELF(machine elf.Machine, soname string, needed ...string) []byte {
+	const (
+		headerSize  = 64
+		sectionSize = 64
+		dynSize     = 16
+	)
+	shstrtab := []byte("\x00.dynstr\x00.dynamic\x00.shstrtab\x00")
+	dynstr := []byte("\x00" + soname + "\x00")
+	var dynamic bytes.Buffer
+	if soname != "" {
+		binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_SONAME), Val: 1})
+	}
+	for _, lib := range needed {
+		binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: uint64(len(dynstr))})
+		dynstr = append(dynstr, lib+"\x00"...)
+	}
+	binary.Write(&dynamic, binary.LittleEndian, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+	dynstrOff := uint64(headerSize)
+	dynamicOff := dynstrOff + uint64(len(dynstr))
+	shstrtabOff := dynamicOff + uint64(dynamic.Len())
+	sectionsOff := shstrtabOff + uint64(len(shstrtab))
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     sectionsOff,
+		Ehsize:    headerSize,
+		Shentsize: sectionSize,
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	sections := []elf.Section64{{}, {
+		Name:      1,
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       dynstrOff,
+		Size:      uint64(len(dynstr)),
+		Addralign: 1,
+	}, {
+		Name:      9,
+		Type:      uint32(elf.SHT_DYNAMIC),
+		Off:       dynamicOff,
+		Size:      uint64(dynamic.Len()),
+		Link:      1,
+		Addralign: 8,
+		Entsize:   dynSize,
+	}, {
+		Name:      18,
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       shstrtabOff,
+		Size:      uint64(len(shstrtab)),
+		Addralign: 1,
+	}}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &header)
+	buf.Write(dynstr)
+	buf.Write(dynamic.Bytes())
+	buf.Write(shstrtab)
+	for _, section := range sections {
+		binary.Write(&buf, binary.LittleEndian, &section)
+	}
+	return buf.Bytes()
+}
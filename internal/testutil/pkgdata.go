@@ -3,6 +3,8 @@ package testutil
 import (
 	"archive/tar"
 	"bytes"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -123,33 +125,69 @@ func compressBytesZstd(input []byte) ([]byte, error) {
 }
 
 func MakeDeb(entries []TarEntry) ([]byte, error) {
+	return makeDeb(nil, entries)
+}
+
+// MakeDebWithControl is similar to MakeDeb but it also adds a control tarball
+// holding the provided control file content.
+func MakeDebWithControl(control string, entries []TarEntry) ([]byte, error) {
+	return makeDeb(map[string]string{"control": control}, entries)
+}
+
+// MakeDebWithControlFiles is similar to MakeDeb but it also adds a control
+// tarball holding the provided files, such as "control" and "conffiles".
+func MakeDebWithControlFiles(files map[string]string, entries []TarEntry) ([]byte, error) {
+	return makeDeb(files, entries)
+}
+
+func makeDeb(controlFiles map[string]string, entries []TarEntry) ([]byte, error) {
 	var buf bytes.Buffer
 
+	writer := ar.NewWriter(&buf)
+	if err := writer.WriteGlobalHeader(); err != nil {
+		return nil, err
+	}
+	if len(controlFiles) > 0 {
+		controlEntries := []TarEntry{Dir(0755, "./")}
+		for _, name := range slices.Sorted(maps.Keys(controlFiles)) {
+			controlEntries = append(controlEntries, Reg(0644, "./"+name, controlFiles[name]))
+		}
+		controlData, err := makeTar(controlEntries)
+		if err != nil {
+			return nil, err
+		}
+		err = writeArMember(writer, "control.tar.zst", controlData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	tarData, err := makeTar(entries)
 	if err != nil {
 		return nil, err
 	}
-	compTarData, err := compressBytesZstd(tarData)
+	err = writeArMember(writer, "data.tar.zst", tarData)
 	if err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	writer := ar.NewWriter(&buf)
-	if err := writer.WriteGlobalHeader(); err != nil {
-		return nil, err
+func writeArMember(writer *ar.Writer, name string, tarData []byte) error {
+	compTarData, err := compressBytesZstd(tarData)
+	if err != nil {
+		return err
 	}
-	dataHeader := ar.Header{
-		Name: "data.tar.zst",
+	header := ar.Header{
+		Name: name,
 		Mode: 0644,
 		Size: int64(len(compTarData)),
 	}
-	if err := writer.WriteHeader(&dataHeader); err != nil {
-		return nil, err
-	}
-	if _, err = writer.Write(compTarData); err != nil {
-		return nil, err
+	if err := writer.WriteHeader(&header); err != nil {
+		return err
 	}
-	return buf.Bytes(), nil
+	_, err = writer.Write(compTarData)
+	return err
 }
 
 func MustMakeDeb(entries []TarEntry) []byte {
@@ -160,6 +198,22 @@ func MustMakeDeb(entries []TarEntry) []byte {
 	return data
 }
 
+func MustMakeDebWithControl(control string, entries []TarEntry) []byte {
+	data, err := MakeDebWithControl(control, entries)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func MustMakeDebWithControlFiles(files map[string]string, entries []TarEntry) []byte {
+	data, err := MakeDebWithControlFiles(files, entries)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
 // Reg is a shortcut for creating a regular file TarEntry structure (with
 // tar.Typeflag set tar.TypeReg). Reg stands for "REGular file".
 func Reg(mode int64, path, content string) TarEntry {
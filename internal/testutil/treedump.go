@@ -6,7 +6,6 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"syscall"
 
 	"github.com/canonical/chisel/internal/fsutil"
 )
@@ -62,11 +61,10 @@ func TreeDump(dir string) map[string]string {
 		}
 		result[path] = entry
 		if ftype != fs.ModeDir {
-			stat, ok := finfo.Sys().(*syscall.Stat_t)
+			inode, _, ok := fsutil.Inode(finfo)
 			if !ok {
 				return fmt.Errorf("cannot get syscall stat info for %q", fpath)
 			}
-			inode := stat.Ino
 			if len(pathsByInodes[inode]) == 1 {
 				inodes = append(inodes, inode)
 			}
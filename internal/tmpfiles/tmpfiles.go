@@ -0,0 +1,221 @@
+// Package tmpfiles implements the creation of the directories, files and
+// symlinks declared in tmpfiles.d configuration files, as
+// systemd-tmpfiles --create would.
+package tmpfiles
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/sysusers"
+)
+
+// ConfDirs lists the directories, relative to the root, where configuration
+// files are looked up, in order of precedence.
+var ConfDirs = []string{
+	"/etc/tmpfiles.d/",
+	"/usr/lib/tmpfiles.d/",
+}
+
+const factoryDir = "/usr/share/factory"
+
+// Line is an entry of a tmpfiles.d configuration file.
+type Line struct {
+	Type string
+	// Replace is set when the type has the "+" modifier.
+	Replace  bool
+	Path     string
+	Mode     fs.FileMode
+	Argument string
+}
+
+// supportedTypes maps the supported line types to the type of entry they
+// create. Other types either act on existing content, depend on the running
+// system or require privileges, and are ignored.
+var supportedTypes = map[string]fs.FileMode{
+	"d": fs.ModeDir,
+	"D": fs.ModeDir,
+	"v": fs.ModeDir,
+	"q": fs.ModeDir,
+	"Q": fs.ModeDir,
+	"f": 0,
+	"L": fs.ModeSymlink,
+}
+
+// Apply reads the tmpfiles.d configuration files in rootDir and creates the
+// directories, files and symlinks declared, in the order they are listed.
+// Existing entries are left untouched unless the "+" modifier is used on a
+// file or symlink. Ownership is not applied. It returns the entries created.
+func Apply(rootDir string) ([]*fsutil.Entry, error) {
+	lines, err := ReadConf(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	restoreUmask := fsutil.ClearUmask()
+	defer restoreUmask()
+
+	var entries []*fsutil.Entry
+	for _, line := range lines {
+		absPath := filepath.Join(rootDir, line.Path)
+		info, err := os.Lstat(absPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		exists := err == nil
+		if exists && (!line.Replace || info.IsDir()) {
+			continue
+		}
+
+		options := &fsutil.CreateOptions{
+			Root:        rootDir,
+			Path:        line.Path,
+			Mode:        supportedTypes[line.Type] | line.Mode,
+			MakeParents: true,
+		}
+		switch line.Type {
+		case "f":
+			options.Data = strings.NewReader(line.Argument)
+		case "L":
+			options.Link = line.Argument
+			if options.Link == "" {
+				options.Link = path.Join(factoryDir, line.Path)
+			}
+			if exists {
+				err := os.Remove(absPath)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		entry, err := fsutil.Create(options)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create %s: %w", line.Path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReadConf returns the supported lines in the configuration files found in
+// rootDir. Files are processed in the order of their names, and a file in
+// /etc replaces a file with the same name in /usr/lib.
+func ReadConf(rootDir string) ([]*Line, error) {
+	files := make(map[string]string)
+	for i := len(ConfDirs) - 1; i >= 0; i-- {
+		matches, err := filepath.Glob(filepath.Join(rootDir, ConfDirs[i], "*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			files[filepath.Base(match)] = match
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var lines []*Line
+	for _, name := range names {
+		data, err := os.ReadFile(files[name])
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range strings.Split(string(data), "\n") {
+			line, err := parseLine(text)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse %s:%d: %w", name, i+1, err)
+			}
+			if line != nil {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// parseLine returns the line parsed, or nil if it is empty, a comment or
+// of an unsupported type.
+func parseLine(text string) (*Line, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text[0] == '#' {
+		return nil, nil
+	}
+	fields, err := sysusers.SplitFields(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("missing path")
+	}
+	for len(fields) < 6 {
+		fields = append(fields, "-")
+	}
+	if len(fields) > 7 {
+		// The argument may contain whitespace.
+		fields[6] = strings.Join(fields[6:], " ")
+	}
+
+	lineType := fields[0][:1]
+	line := &Line{
+		Type:    lineType,
+		Replace: strings.Contains(fields[0][1:], "+"),
+		Path:    fields[1],
+	}
+	if _, ok := supportedTypes[lineType]; !ok {
+		return nil, nil
+	}
+	if strings.Contains(line.Path, "%") {
+		// Specifiers depend on the running system.
+		return nil, nil
+	}
+	if !path.IsAbs(line.Path) {
+		return nil, fmt.Errorf("path %q is not absolute", line.Path)
+	}
+	line.Path = path.Clean(line.Path)
+
+	mode := strings.TrimLeft(fields[2], "~:")
+	switch {
+	case lineType == "L":
+		// Mode is ignored for symlinks.
+		line.Mode = 0777
+	case (mode == "-" || mode == "") && lineType == "f":
+		line.Mode = 0644
+	case mode == "-" || mode == "":
+		line.Mode = 0755
+	default:
+		value, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || value > 07777 {
+			return nil, fmt.Errorf("invalid mode %q", fields[2])
+		}
+		line.Mode = fs.FileMode(value & 0777)
+		if value&04000 != 0 {
+			line.Mode |= fs.ModeSetuid
+		}
+		if value&02000 != 0 {
+			line.Mode |= fs.ModeSetgid
+		}
+		if value&01000 != 0 {
+			line.Mode |= fs.ModeSticky
+		}
+	}
+	if len(fields) > 6 && fields[6] != "-" {
+		line.Argument = unescape(fields[6])
+	}
+	return line, nil
+}
+
+// unescape replaces the C-style escape sequences supported in arguments.
+func unescape(s string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\\`, `\`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,133 @@
+package tmpfiles_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/testutil"
+	"github.com/canonical/chisel/internal/tmpfiles"
+)
+
+func writeFiles(c *C, rootDir string, files map[string]string) {
+	for path, data := range files {
+		fpath := filepath.Join(rootDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, []byte(data), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *S) TestApply(c *C) {
+	rootDir := c.MkDir()
+	writeFiles(c, rootDir, map[string]string{
+		"/usr/lib/tmpfiles.d/a.conf": `
+			# Comment.
+			d /var/lib/a 0700 daemon daemon -
+			d /var/tmp 1777 root root 30d
+			f /etc/a.conf - - - - value=1\n
+			f /etc/existing - - - - new
+			f+ /etc/replaced - - - - new
+			L /etc/link - - - - /etc/a.conf
+			L /etc/factory
+			z /var/lib/a 0700 daemon daemon
+			d /run/user/%U
+		`,
+		"/usr/lib/tmpfiles.d/b.conf": "d /var/lib/shadowed\n",
+		"/etc/tmpfiles.d/b.conf":     "D /var/cache/b\n",
+		"/etc/existing":              "old",
+		"/etc/replaced":              "old",
+	})
+
+	entries, err := tmpfiles.Apply(rootDir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 7)
+
+	c.Assert(testutil.TreeDump(rootDir), DeepEquals, map[string]string{
+		"/etc/":                      "dir 0755",
+		"/etc/a.conf":                "file 0644 1e6c40f2",
+		"/etc/existing":              "file 0644 cba06b57",
+		"/etc/factory":               "symlink /usr/share/factory/etc/factory",
+		"/etc/link":                  "symlink /etc/a.conf",
+		"/etc/replaced":              "file 0644 11507a0e",
+		"/etc/tmpfiles.d/":           "dir 0755",
+		"/etc/tmpfiles.d/b.conf":     "file 0644 9c153e47",
+		"/usr/":                      "dir 0755",
+		"/usr/lib/":                  "dir 0755",
+		"/usr/lib/tmpfiles.d/":       "dir 0755",
+		"/usr/lib/tmpfiles.d/a.conf": "file 0644 2f9c6d75",
+		"/usr/lib/tmpfiles.d/b.conf": "file 0644 43323e86",
+		"/var/":                      "dir 0755",
+		"/var/cache/":                "dir 0755",
+		"/var/cache/b/":              "dir 0755",
+		"/var/lib/":                  "dir 0755",
+		"/var/lib/a/":                "dir 0700",
+		"/var/tmp/":                  "dir 01777",
+	})
+
+	info, err := os.Stat(filepath.Join(rootDir, "/var/tmp"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode()&fs.ModeSticky != 0, Equals, true)
+}
+
+func (s *S) TestApplyReplaceEscapingSymlink(c *C) {
+	outside := filepath.Join(c.MkDir(), "outside")
+	err := os.WriteFile(outside, []byte("outside"), 0644)
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	writeFiles(c, rootDir, map[string]string{
+		"/usr/lib/tmpfiles.d/a.conf": "f+ /etc/replaced - - - - new\n",
+	})
+	err = os.MkdirAll(filepath.Join(rootDir, "etc"), 0755)
+	c.Assert(err, IsNil)
+	err = os.Symlink(outside, filepath.Join(rootDir, "etc/replaced"))
+	c.Assert(err, IsNil)
+
+	_, err = tmpfiles.Apply(rootDir)
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(outside)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "outside")
+	info, err := os.Lstat(filepath.Join(rootDir, "etc/replaced"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().IsRegular(), Equals, true)
+}
+
+func (s *S) TestApplyNoConfig(c *C) {
+	entries, err := tmpfiles.Apply(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+var applyErrorTests = []struct {
+	conf  string
+	error string
+}{{
+	conf:  "d\n",
+	error: `cannot parse a.conf:1: missing path`,
+}, {
+	conf:  "d var/lib/a\n",
+	error: `cannot parse a.conf:1: path "var/lib/a" is not absolute`,
+}, {
+	conf:  "d /var/lib/a 0999\n",
+	error: `cannot parse a.conf:1: invalid mode "0999"`,
+}, {
+	conf:  "d /var/lib/a \"0755\n",
+	error: `cannot parse a.conf:1: unterminated quote`,
+}}
+
+func (s *S) TestApplyErrors(c *C) {
+	for _, test := range applyErrorTests {
+		rootDir := c.MkDir()
+		writeFiles(c, rootDir, map[string]string{
+			"/usr/lib/tmpfiles.d/a.conf": test.conf,
+		})
+		_, err := tmpfiles.Apply(rootDir)
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exporter sends spans to an OpenTelemetry collector, using the OTLP
+// protocol over HTTP with JSON encoding.
+type Exporter struct {
+	// URL is where the spans are sent, usually ending in /v1/traces.
+	URL         string
+	Headers     map[string]string
+	ServiceName string
+	Version     string
+	Timeout     time.Duration
+}
+
+// ExporterFromEnv returns the exporter configured with the standard
+// OpenTelemetry environment variables, or nil if the export of traces
+// is not configured.
+func ExporterFromEnv(version string) (*Exporter, error) {
+	if exporters := os.Getenv("OTEL_TRACES_EXPORTER"); exporters != "" &&
+		!slices.Contains(strings.Split(exporters, ","), "otlp") {
+		return nil, nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %q", endpoint)
+	}
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, only http/json is supported", protocol)
+	}
+
+	headers := make(map[string]string)
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		err := parseHeaders(headers, os.Getenv(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	timeout := 10 * time.Second
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_TIMEOUT", "OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"} {
+		if value := os.Getenv(name); value != "" {
+			ms, err := strconv.Atoi(value)
+			if err != nil || ms <= 0 {
+				return nil, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "chisel"
+	}
+	return &Exporter{
+		URL:         endpoint,
+		Headers:     headers,
+		ServiceName: serviceName,
+		Version:     version,
+		Timeout:     timeout,
+	}, nil
+}
+
+// parseHeaders adds to headers the ones listed in the format of the
+// OpenTelemetry environment variables: <name>=<value>[,<name>=<value>...],
+// with the values URL encoded.
+func parseHeaders(headers map[string]string, list string) error {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid header %q", item)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid header %q", item)
+		}
+		headers[name] = value
+	}
+	return nil
+}
+
+// Export sends the spans to the collector.
+func (e *Exporter) Export(ctx context.Context, spans []*Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", e.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot export traces: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("cannot export traces: %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below follow the JSON encoding of the OTLP trace service
+// request, in which IDs are hex encoded and 64 bit integers are strings.
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpKindInternal    = 1
+	otlpStatusCodeError = 2
+)
+
+func (e *Exporter) request(spans []*Span) *otlpRequest {
+	resource := otlpResource{Attributes: []otlpAttribute{
+		{Key: "service.name", Value: otlpValue{e.ServiceName}},
+	}}
+	if e.Version != "" {
+		resource.Attributes = append(resource.Attributes, otlpAttribute{
+			Key: "service.version", Value: otlpValue{e.Version},
+		})
+	}
+	scope := &otlpScopeSpans{Scope: otlpScope{Name: "github.com/canonical/chisel", Version: e.Version}}
+	for _, span := range spans {
+		s := &otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentID,
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: otlpValue{span.Attributes[key]}})
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: otlpStatusCodeError, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+	return &otlpRequest{ResourceSpans: []*otlpResourceSpans{{
+		Resource:   resource,
+		ScopeSpans: []*otlpScopeSpans{scope},
+	}}}
+}
@@ -0,0 +1,141 @@
+// Package tracing records the spans of the work done by Chisel and exports
+// them with the OpenTelemetry protocol, so that cuts show up in the traces
+// of the builds running them.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Span is an operation traced, from the time it starts until it ends.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	// Error is the reason the operation failed, if it did.
+	Error string
+
+	recorder *Recorder
+}
+
+// SetAttribute records a property of the operation.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// SetError records that the operation failed with err, if not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.recorder.mu.Lock()
+	s.Error = err.Error()
+	s.recorder.mu.Unlock()
+}
+
+// Finish ends the operation, and is a no-op on spans already finished.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	if !s.End.IsZero() {
+		return
+	}
+	s.End = time.Now()
+	s.recorder.spans = append(s.recorder.spans, s)
+}
+
+// Recorder collects the spans finished.
+type Recorder struct {
+	mu      sync.Mutex
+	spans   []*Span
+	traceID string
+	spanID  string
+}
+
+// NewRecorder returns a recorder whose spans belong to a new trace, or to
+// the trace of the W3C traceparent header given, if valid, so that they
+// show up within the operation which started Chisel.
+func NewRecorder(traceparent string) *Recorder {
+	r := &Recorder{}
+	if m := traceparentExp.FindStringSubmatch(traceparent); m != nil &&
+		m[1] != "00000000000000000000000000000000" && m[2] != "0000000000000000" {
+		r.traceID = m[1]
+		r.spanID = m[2]
+	} else {
+		r.traceID = randomID(16)
+	}
+	return r
+}
+
+var traceparentExp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// Spans returns the spans finished so far, in the order they finished.
+func (r *Recorder) Spans() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]*Span, len(r.spans))
+	copy(spans, r.spans)
+	return spans
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("cannot generate random ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+type recorderKey struct{}
+type spanKey struct{}
+
+// WithRecorder returns a copy of ctx in which spans are recorded by r.
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// Start starts a span with the given name, as a child of the span in ctx,
+// if any, and returns a copy of ctx holding the new span. Nothing is
+// recorded, and the span returned is nil, if ctx has no recorder.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	if ctx == nil {
+		return ctx, nil
+	}
+	r, _ := ctx.Value(recorderKey{}).(*Recorder)
+	if r == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		TraceID:  r.traceID,
+		SpanID:   randomID(8),
+		ParentID: r.spanID,
+		Name:     name,
+		Start:    time.Now(),
+		recorder: r,
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.ParentID = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
@@ -0,0 +1,231 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/tracing"
+)
+
+func (s *S) TestStart(c *C) {
+	// Nothing is recorded without a recorder.
+	ctx, span := tracing.Start(context.Background(), "cut")
+	c.Assert(span, IsNil)
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.Finish()
+
+	recorder := tracing.NewRecorder("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, root := tracing.Start(tracing.WithRecorder(ctx, recorder), "cut")
+	_, child := tracing.Start(ctx, "fetch")
+	child.SetAttribute("package", "mypkg")
+	child.Finish()
+	child.Finish()
+	root.SetError(errors.New("failed"))
+	root.Finish()
+
+	spans := recorder.Spans()
+	c.Assert(spans, HasLen, 2)
+	c.Assert(spans[0].Name, Equals, "fetch")
+	c.Assert(spans[0].TraceID, Equals, "0af7651916cd43dd8448eb211c80319c")
+	c.Assert(spans[0].ParentID, Equals, root.SpanID)
+	c.Assert(spans[0].Attributes, DeepEquals, map[string]string{"package": "mypkg"})
+	c.Assert(spans[1].Name, Equals, "cut")
+	c.Assert(spans[1].ParentID, Equals, "b7ad6b7169203331")
+	c.Assert(spans[1].Error, Equals, "failed")
+	c.Assert(spans[1].SpanID, Matches, "[0-9a-f]{16}")
+	c.Assert(spans[1].End.Before(spans[1].Start), Equals, false)
+
+	// Invalid traceparents start a new trace.
+	for _, traceparent := range []string{"", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", "00-00000000000000000000000000000000-b7ad6b7169203331-01"} {
+		recorder := tracing.NewRecorder(traceparent)
+		_, span := tracing.Start(tracing.WithRecorder(context.Background(), recorder), "cut")
+		c.Assert(span.TraceID, Matches, "[0-9a-f]{32}")
+		c.Assert(span.TraceID, Not(Equals), "0af7651916cd43dd8448eb211c80319c")
+		c.Assert(span.ParentID, Equals, "")
+	}
+}
+
+var exporterFromEnvTests = []struct {
+	summary  string
+	env      map[string]string
+	exporter *tracing.Exporter
+	error    string
+}{{
+	summary: "Not configured",
+}, {
+	summary: "Base endpoint",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318/",
+		"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization=Bearer%20token, X-Team = builds",
+		"OTEL_SERVICE_NAME":           "image-build",
+	},
+	exporter: &tracing.Exporter{
+		URL:         "http://localhost:4318/v1/traces",
+		Headers:     map[string]string{"Authorization": "Bearer token", "X-Team": "builds"},
+		ServiceName: "image-build",
+		Version:     "1.0",
+		Timeout:     10 * time.Second,
+	},
+}, {
+	summary: "Traces endpoint takes precedence",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://localhost:4318",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://collector.example.com/traces",
+		"OTEL_EXPORTER_OTLP_PROTOCOL":        "http/json",
+		"OTEL_EXPORTER_OTLP_TRACES_TIMEOUT":  "500",
+	},
+	exporter: &tracing.Exporter{
+		URL:         "https://collector.example.com/traces",
+		Headers:     map[string]string{},
+		ServiceName: "chisel",
+		Version:     "1.0",
+		Timeout:     500 * time.Millisecond,
+	},
+}, {
+	summary: "Other exporters",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+		"OTEL_TRACES_EXPORTER":        "none",
+	},
+}, {
+	summary: "Unsupported protocol",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4317",
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc",
+	},
+	error: `unsupported OTLP protocol "grpc", only http/json is supported`,
+}, {
+	summary: "Invalid endpoint",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "localhost:4318",
+	},
+	error: `invalid OTLP endpoint: "localhost:4318"`,
+}, {
+	summary: "Invalid headers",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+		"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization",
+	},
+	error: `invalid OTEL_EXPORTER_OTLP_HEADERS: invalid header "Authorization"`,
+}, {
+	summary: "Invalid timeout",
+	env: map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":  "1s",
+	},
+	error: `invalid OTEL_EXPORTER_OTLP_TIMEOUT: "1s"`,
+}}
+
+var otelEnv = []string{
+	"OTEL_TRACES_EXPORTER",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_PROTOCOL",
+	"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL",
+	"OTEL_EXPORTER_OTLP_HEADERS",
+	"OTEL_EXPORTER_OTLP_TRACES_HEADERS",
+	"OTEL_EXPORTER_OTLP_TIMEOUT",
+	"OTEL_EXPORTER_OTLP_TRACES_TIMEOUT",
+	"OTEL_SERVICE_NAME",
+}
+
+func (s *S) TestExporterFromEnv(c *C) {
+	for _, test := range exporterFromEnvTests {
+		c.Logf("Summary: %s", test.summary)
+		for _, name := range otelEnv {
+			old, ok := os.LookupEnv(name)
+			if ok {
+				defer os.Setenv(name, old)
+			} else {
+				defer os.Unsetenv(name)
+			}
+			os.Setenv(name, test.env[name])
+		}
+		exporter, err := tracing.ExporterFromEnv("1.0")
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(exporter, DeepEquals, test.exporter)
+	}
+}
+
+func (s *S) TestExport(c *C) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.URL.Path, Equals, "/v1/traces")
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	exporter := &tracing.Exporter{
+		URL:         server.URL + "/v1/traces",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		ServiceName: "chisel",
+		Version:     "1.0",
+		Timeout:     time.Second,
+	}
+	start := time.Unix(1700000000, 0)
+	err := exporter.Export(context.Background(), []*tracing.Span{{
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+		SpanID:     "b7ad6b7169203331",
+		ParentID:   "00f067aa0ba902b7",
+		Name:       "cut",
+		Start:      start,
+		End:        start.Add(time.Second),
+		Attributes: map[string]string{"chisel.release": "ubuntu-24.04", "chisel.arch": "amd64"},
+		Error:      "failed",
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(header.Get("Content-Type"), Equals, "application/json")
+	c.Assert(header.Get("Authorization"), Equals, "Bearer token")
+	var request any
+	c.Assert(json.Unmarshal(body, &request), IsNil)
+	c.Assert(request, DeepEquals, map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []any{
+				map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "chisel"}},
+				map[string]any{"key": "service.version", "value": map[string]any{"stringValue": "1.0"}},
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/canonical/chisel", "version": "1.0"},
+				"spans": []any{map[string]any{
+					"traceId":           "0af7651916cd43dd8448eb211c80319c",
+					"spanId":            "b7ad6b7169203331",
+					"parentSpanId":      "00f067aa0ba902b7",
+					"name":              "cut",
+					"kind":              1.0,
+					"startTimeUnixNano": "1700000000000000000",
+					"endTimeUnixNano":   "1700000001000000000",
+					"attributes": []any{
+						map[string]any{"key": "chisel.arch", "value": map[string]any{"stringValue": "amd64"}},
+						map[string]any{"key": "chisel.release", "value": map[string]any{"stringValue": "ubuntu-24.04"}},
+					},
+					"status": map[string]any{"code": 2.0, "message": "failed"},
+				}},
+			}},
+		}},
+	})
+
+	// Rejected exports are reported.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	exporter.URL = failing.URL
+	err = exporter.Export(context.Background(), []*tracing.Span{{Name: "cut"}})
+	c.Assert(err, ErrorMatches, "cannot export traces: 400 Bad Request")
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/canonical/chisel/public/jsonwall"
+)
+
+var (
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic     = []byte{0x1f, 0x8b}
+	jsonwallMagic = []byte(`{"jsonwall"`)
+)
+
+// flatManifest is the flat JSON encoding of a manifest, holding all of its
+// entries in a single document for tools which cannot read jsonwall.
+type flatManifest struct {
+	Schema   string     `json:"schema"`
+	Build    *Build     `json:"build,omitempty"`
+	Security *Security  `json:"security,omitempty"`
+	Packages []*Package `json:"packages"`
+	Slices   []*Slice   `json:"slices"`
+	Paths    []*Path    `json:"paths"`
+	Contents []*Content `json:"contents"`
+}
+
+// readDB reads the jsonwall database of a manifest from reader, which may
+// be compressed with zstd or gzip and may hold either jsonwall or the flat
+// JSON encoding written by WriteJSON.
+func readDB(reader io.Reader) (*jsonwall.DB, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		buffered = bufio.NewReader(decoder)
+	case bytes.HasPrefix(magic, gzipMagic):
+		decoder, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		buffered = bufio.NewReader(decoder)
+	}
+
+	// Flat JSON may be indented, so the start of jsonwall is matched
+	// instead.
+	magic, _ = buffered.Peek(len(jsonwallMagic))
+	if bytes.Equal(magic, jsonwallMagic) {
+		return jsonwall.ReadDB(buffered)
+	}
+	var flat flatManifest
+	err := json.NewDecoder(buffered).Decode(&flat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flat JSON: %w", err)
+	}
+	dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{Schema: flat.Schema})
+	var entries []any
+	if flat.Build != nil {
+		entries = append(entries, flat.Build)
+	}
+	if flat.Security != nil {
+		entries = append(entries, flat.Security)
+	}
+	for _, entry := range flat.Packages {
+		entries = append(entries, entry)
+	}
+	for _, entry := range flat.Slices {
+		entries = append(entries, entry)
+	}
+	for _, entry := range flat.Paths {
+		entries = append(entries, entry)
+	}
+	for _, entry := range flat.Contents {
+		entries = append(entries, entry)
+	}
+	for _, entry := range entries {
+		err := dbw.Add(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	_, err = dbw.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return jsonwall.ReadDB(&buf)
+}
+
+// WriteJSON writes the manifest as a single JSON document holding its schema,
+// its build and security information and lists of all the packages, slices,
+// paths and contents, for tools which cannot read jsonwall. The document may
+// be read back with Read.
+func (manifest *Manifest) WriteJSON(writer io.Writer) error {
+	flat := &flatManifest{
+		Schema:   manifest.Schema(),
+		Packages: []*Package{},
+		Slices:   []*Slice{},
+		Paths:    []*Path{},
+		Contents: []*Content{},
+	}
+	build, err := manifest.Build()
+	if err != nil {
+		return err
+	}
+	flat.Build = build
+	security, err := manifest.Security()
+	if err != nil {
+		return err
+	}
+	flat.Security = security
+	err = manifest.IteratePackages(func(pkg *Package) error {
+		flat.Packages = append(flat.Packages, pkg)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = manifest.IterateSlices("", func(slice *Slice) error {
+		flat.Slices = append(flat.Slices, slice)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = manifest.IteratePaths("", func(path *Path) error {
+		flat.Paths = append(flat.Paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = manifest.IterateContents("", func(content *Content) error {
+		flat.Contents = append(flat.Contents, content)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(flat)
+}
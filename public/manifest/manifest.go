@@ -9,14 +9,52 @@ import (
 	"github.com/canonical/chisel/public/jsonwall"
 )
 
-const Schema = "1.0"
+// Schema is the version of the manifest schema written by this package.
+//
+// The manifest is a jsonwall database holding entries of the following kinds:
+//
+//   - "package": the name, version, architecture and digest of every package
+//     contributing content and, when known, the name and version of the
+//     source package it is built from and the licenses declared in its
+//     copyright file.
+//   - "slice": the name of every selected slice.
+//   - "path": every path created, with its mode, the target of links, the
+//     full list of slices referencing it and, for regular files, the digest
+//     and size of its content. Symlinks rewritten while cutting also record
+//     their original target.
+//   - "content": one entry for each slice and path pair, to allow iterating
+//     over the paths of a given slice.
+//   - "build": optionally, a single entry recording how the manifest was
+//     produced, with the release, the Chisel version, the archives, the
+//     symlink policy and the command line used.
+//   - "security": optionally, a single entry recording the paths extracted
+//     from packages with setuid or setgid bits and the policy applied to
+//     them.
+//
+// Schema "2.0" extends "1.0" by always recording the final digest of regular
+// files, even when their content was not mutated. Manifests in schema "1.0"
+// can still be read, in which case the final digest is only present for
+// mutated files.
+const Schema = "2.0"
+
+// SchemaV1 is the previous version of the schema, which is still supported
+// for reading.
+const SchemaV1 = "1.0"
 
 type Package struct {
-	Kind    string `json:"kind"`
-	Name    string `json:"name,omitempty"`
-	Version string `json:"version,omitempty"`
-	Digest  string `json:"sha256,omitempty"`
-	Arch    string `json:"arch,omitempty"`
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Digest   string   `json:"sha256,omitempty"`
+	Arch     string   `json:"arch,omitempty"`
+	Licenses []string `json:"licenses,omitempty"`
+	// Source and SourceVersion identify the source package, which CVE data
+	// and license tooling refer to rather than the binary package.
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"source-version,omitempty"`
+	// Changelog is the location of the changelog of the package version,
+	// when recorded.
+	Changelog string `json:"changelog,omitempty"`
 }
 
 type Slice struct {
@@ -33,7 +71,19 @@ type Path struct {
 	FinalSHA256 string   `json:"final_sha256,omitempty"`
 	Size        uint64   `json:"size,omitempty"`
 	Link        string   `json:"link,omitempty"`
-	Inode       uint64   `json:"inode,omitempty"`
+	// OriginalLink is the target the symlink had in its package, when it
+	// was rewritten by the symlink policy of the cut.
+	OriginalLink string `json:"original_link,omitempty"`
+	// DivertedFrom is the path the content has in its package, when it was
+	// moved away by a diversion of another package.
+	DivertedFrom string `json:"diverted_from,omitempty"`
+	// Conffile is set for the paths listed in the conffiles of their
+	// package, which hold configuration.
+	Conffile bool `json:"conffile,omitempty"`
+	// HostSource is the path on the host the file was copied from, for
+	// files which are not part of any slice.
+	HostSource string `json:"host_source,omitempty"`
+	Inode      uint64 `json:"inode,omitempty"`
 }
 
 type Content struct {
@@ -42,12 +92,77 @@ type Content struct {
 	Path  string `json:"path,omitempty"`
 }
 
+// Build records how a manifest was produced, so that the manifest alone is
+// enough to reproduce the tree it describes.
+type Build struct {
+	Kind          string `json:"kind"`
+	ChiselVersion string `json:"chisel-version,omitempty"`
+	// Release is the name or directory the release was obtained with.
+	Release string `json:"release,omitempty"`
+	// ReleaseDigest is the SHA256 of the files defining the release, as
+	// recorded in lockfiles.
+	ReleaseDigest string `json:"release-digest,omitempty"`
+	// ReleaseCommit is the git commit of the release, when its directory is
+	// a git checkout.
+	ReleaseCommit string          `json:"release-commit,omitempty"`
+	Arch          string          `json:"arch,omitempty"`
+	Archives      []*BuildArchive `json:"archives,omitempty"`
+	Command       []string        `json:"command,omitempty"`
+	// SymlinkPolicy is the policy applied to the symlinks extracted from
+	// packages, as in "absolute=allow,escaping=reject".
+	SymlinkPolicy string `json:"symlink-policy,omitempty"`
+	// Dedup is how the files with identical content share their storage,
+	// as in "hardlink" or "reflink".
+	Dedup string `json:"dedup,omitempty"`
+	// Locales and Timezones list the only locales and timezones whose
+	// data was kept, when the rest was trimmed.
+	Locales   []string `json:"locales,omitempty"`
+	Timezones []string `json:"timezones,omitempty"`
+}
+
+// BuildArchive is the definition of an archive packages were fetched from.
+type BuildArchive struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version,omitempty"`
+	Suites     []string `json:"suites,omitempty"`
+	Components []string `json:"components,omitempty"`
+	Priority   int      `json:"priority,omitempty"`
+	Pro        string   `json:"pro,omitempty"`
+	// Mirror is the location the archive was fetched from when a mirror
+	// was selected instead of its default location.
+	Mirror string `json:"mirror,omitempty"`
+}
+
+// Security records the privileged bits which landed in the tree, so that
+// image owners know about them.
+type Security struct {
+	Kind string `json:"kind"`
+	// SetuidPolicy is what happened to the paths extracted with setuid or
+	// setgid bits, as in "warn" or "strip".
+	SetuidPolicy string            `json:"setuid-policy,omitempty"`
+	Privileged   []*PrivilegedPath `json:"privileged,omitempty"`
+}
+
+// PrivilegedPath is a path extracted from a package with setuid or setgid
+// bits.
+type PrivilegedPath struct {
+	Path string `json:"path"`
+	// Mode is the mode of the path in its package, as in "04755".
+	Mode   string   `json:"mode"`
+	Slices []string `json:"slices,omitempty"`
+	// Stripped reports whether the setuid and setgid bits were cleared in
+	// the tree.
+	Stripped bool `json:"stripped,omitempty"`
+}
+
 type Manifest struct {
 	db *jsonwall.DB
 }
 
 // Read loads a Manifest without performing any validation. The data is assumed
-// to be both valid jsonwall and a valid Manifest (see Validate).
+// to be both valid jsonwall and a valid Manifest (see Validate). The data
+// may also be compressed with zstd, as written by Chisel, or with gzip, and
+// may be in the flat JSON encoding written by WriteJSON.
 func Read(reader io.Reader) (manifest *Manifest, err error) {
 	defer func() {
 		if err != nil {
@@ -55,12 +170,12 @@ func Read(reader io.Reader) (manifest *Manifest, err error) {
 		}
 	}()
 
-	db, err := jsonwall.ReadDB(reader)
+	db, err := readDB(reader)
 	if err != nil {
 		return nil, err
 	}
 	mfestSchema := db.Schema()
-	if mfestSchema != Schema {
+	if mfestSchema != Schema && mfestSchema != SchemaV1 {
 		return nil, fmt.Errorf("unknown schema version %q", mfestSchema)
 	}
 
@@ -68,6 +183,37 @@ func Read(reader io.Reader) (manifest *Manifest, err error) {
 	return manifest, nil
 }
 
+// Schema returns the schema version of the manifest.
+func (manifest *Manifest) Schema() string {
+	return manifest.db.Schema()
+}
+
+// Build returns how the manifest was produced, or nil if it was not
+// recorded, as in manifests written by older versions of Chisel.
+func (manifest *Manifest) Build() (*Build, error) {
+	build := &Build{Kind: "build"}
+	err := manifest.db.Get(build)
+	if err == jsonwall.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %s", err)
+	}
+	return build, nil
+}
+
+// Security returns the privileged bits found while cutting the tree, or nil
+// if they were not recorded, as when no path had them.
+func (manifest *Manifest) Security() (*Security, error) {
+	security := &Security{Kind: "security"}
+	err := manifest.db.Get(security)
+	if err == jsonwall.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %s", err)
+	}
+	return security, nil
+}
+
 func (manifest *Manifest) IteratePaths(pathPrefix string, onMatch func(*Path) error) (err error) {
 	return iteratePrefix(manifest, &Path{Kind: "path", Path: pathPrefix}, onMatch)
 }
@@ -3,11 +3,14 @@
 package manifest_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"os"
 	"path"
 	"slices"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/apachetestutil"
@@ -70,13 +73,111 @@ var readManifestTests = []struct {
 			{Kind: "content", Slice: "pkg2_myotherslice", Path: "/dir/foo/bar/"},
 		},
 	},
+}, {
+	summary: "Schema 2.0",
+	input: `
+		{"jsonwall":"1.0","schema":"2.0","count":4}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","final_sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	mfest: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", FinalSHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", Size: 0x03},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/file"},
+		},
+	},
+}, {
+	summary: "Build",
+	input: `
+		{"jsonwall":"1.0","schema":"2.0","count":5}
+		{"kind":"build","chisel-version":"v1.0.0","release":"ubuntu-24.04","release-digest":"digest1","release-commit":"commit1","arch":"amd64","archives":[{"name":"ubuntu","version":"24.04","suites":["noble"],"components":["main"],"priority":10,"mirror":"http://mirror.example.com/ubuntu/"}],"command":["chisel","cut","pkg1_myslice"]}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","final_sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	mfest: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", FinalSHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", Size: 0x03},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/file"},
+		},
+		Build: &manifest.Build{
+			Kind:          "build",
+			ChiselVersion: "v1.0.0",
+			Release:       "ubuntu-24.04",
+			ReleaseDigest: "digest1",
+			ReleaseCommit: "commit1",
+			Arch:          "amd64",
+			Archives: []*manifest.BuildArchive{{
+				Name:       "ubuntu",
+				Version:    "24.04",
+				Suites:     []string{"noble"},
+				Components: []string{"main"},
+				Priority:   10,
+				Mirror:     "http://mirror.example.com/ubuntu/",
+			}},
+			Command: []string{"chisel", "cut", "pkg1_myslice"},
+		},
+	},
+}, {
+	summary: "Security",
+	input: `
+		{"jsonwall":"1.0","schema":"2.0","count":5}
+		{"kind":"content","slice":"pkg1_myslice","path":"/usr/bin/su"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/usr/bin/su","mode":"0755","slices":["pkg1_myslice"],"sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","final_sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","size":3}
+		{"kind":"security","setuid-policy":"strip","privileged":[{"path":"/usr/bin/su","mode":"04755","slices":["pkg1_myslice"],"stripped":true}]}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	mfest: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/usr/bin/su", Mode: "0755", Slices: []string{"pkg1_myslice"}, SHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", FinalSHA256: "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", Size: 0x03},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/usr/bin/su"},
+		},
+		Security: &manifest.Security{
+			Kind:         "security",
+			SetuidPolicy: "strip",
+			Privileged: []*manifest.PrivilegedPath{{
+				Path:     "/usr/bin/su",
+				Mode:     "04755",
+				Slices:   []string{"pkg1_myslice"},
+				Stripped: true,
+			}},
+		},
+	},
 }, {
 	summary: "Unknown schema",
 	input: `
-		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"jsonwall":"1.0","schema":"3.0","count":1}
 		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
 	`,
-	error: `cannot read manifest: unknown schema version "2.0"`,
+	error: `cannot read manifest: unknown schema version "3.0"`,
 }}
 
 func (s *S) TestManifestRead(c *C) {
@@ -118,3 +219,46 @@ func (s *S) TestManifestRead(c *C) {
 		}
 	}
 }
+
+func (s *S) TestManifestReadEncodings(c *C) {
+	input := strings.Join([]string{
+		`{"jsonwall":"1.0","schema":"2.0","count":5}`,
+		`{"kind":"build","chisel-version":"v1.0.0","release":"ubuntu-24.04","command":["chisel","cut","pkg1_myslice"]}`,
+		`{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}`,
+		`{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}`,
+		`{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","final_sha256":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c","size":3}`,
+		`{"kind":"slice","name":"pkg1_myslice"}`,
+	}, "\n") + "\n"
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	expected := apachetestutil.DumpManifestContents(c, mfest)
+	c.Assert(expected.Build, NotNil)
+
+	var flat bytes.Buffer
+	err = mfest.WriteJSON(&flat)
+	c.Assert(err, IsNil)
+	c.Assert(flat.String(), Matches, `(?s)\{\n  "schema": "2.0",\n  "build": \{.*\n  "packages": \[.*`)
+
+	var zstdBuf bytes.Buffer
+	zstdWriter, err := zstd.NewWriter(&zstdBuf)
+	c.Assert(err, IsNil)
+	_, err = zstdWriter.Write([]byte(input))
+	c.Assert(err, IsNil)
+	c.Assert(zstdWriter.Close(), IsNil)
+
+	var gzipBuf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipBuf)
+	_, err = gzipWriter.Write(flat.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(gzipWriter.Close(), IsNil)
+
+	for _, data := range [][]byte{zstdBuf.Bytes(), gzipBuf.Bytes(), flat.Bytes()} {
+		mfest, err := manifest.Read(bytes.NewReader(data))
+		c.Assert(err, IsNil)
+		c.Assert(mfest.Schema(), Equals, "2.0")
+		c.Assert(apachetestutil.DumpManifestContents(c, mfest), DeepEquals, expected)
+	}
+
+	_, err = manifest.Read(strings.NewReader(`{"schema": "2.0", "packages": {}}`))
+	c.Assert(err, ErrorMatches, `cannot read manifest: invalid flat JSON: .*`)
+}
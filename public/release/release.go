@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package release allows building chisel binaries with Chisel releases
+// embedded in them, such as private releases, so that they may be used
+// fully offline with "--release embedded:<name>".
+//
+// Releases are embedded by registering them when the binary starts, usually
+// from a file added to the chisel command along the lines of:
+//
+//	//go:embed all:myrelease
+//	var myRelease embed.FS
+//
+//	func init() {
+//	        fsys, err := fs.Sub(myRelease, "myrelease")
+//	        if err != nil {
+//	                panic(err)
+//	        }
+//	        release.Embed("myrelease", fsys)
+//	}
+//
+// The chisel command also embeds every directory within cmd/chisel/embedded
+// when built with the "embedrelease" build tag.
+package release
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+var (
+	embeddedLock sync.Mutex
+	embedded     = make(map[string]fs.FS)
+)
+
+var nameExp = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9._-]*[a-z0-9])?$`)
+
+// Embed registers the release whose chisel.yaml file and slice definitions
+// are at the root of fsys, to be used as "embedded:<name>". It panics if
+// the name is invalid or already registered, as it is meant to be called
+// on initialization.
+func Embed(name string, fsys fs.FS) {
+	if !nameExp.MatchString(name) {
+		panic(fmt.Sprintf("invalid embedded release name: %q", name))
+	}
+	embeddedLock.Lock()
+	defer embeddedLock.Unlock()
+	if _, ok := embedded[name]; ok {
+		panic(fmt.Sprintf("embedded release %q registered twice", name))
+	}
+	embedded[name] = fsys
+}
+
+// Embedded returns the release registered with the given name, if any.
+func Embedded(name string) (fsys fs.FS, ok bool) {
+	embeddedLock.Lock()
+	defer embeddedLock.Unlock()
+	fsys, ok = embedded[name]
+	return fsys, ok
+}
+
+// EmbeddedNames returns the names of the releases registered, sorted.
+func EmbeddedNames() []string {
+	embeddedLock.Lock()
+	defer embeddedLock.Unlock()
+	names := make([]string, 0, len(embedded))
+	for name := range embedded {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package release_test
+
+import (
+	"io/fs"
+	"testing/fstest"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/public/release"
+)
+
+func (s *S) TestEmbed(c *C) {
+	fsys := fstest.MapFS{"chisel.yaml": &fstest.MapFile{Data: []byte("format: v1\n")}}
+	release.Embed("test-embed", fsys)
+	release.Embed("test-embed.2", fstest.MapFS{})
+
+	got, ok := release.Embedded("test-embed")
+	c.Assert(ok, Equals, true)
+	data, err := fs.ReadFile(got, "chisel.yaml")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "format: v1\n")
+	_, ok = release.Embedded("missing")
+	c.Assert(ok, Equals, false)
+	c.Assert(release.EmbeddedNames(), DeepEquals, []string{"test-embed", "test-embed.2"})
+
+	c.Assert(func() { release.Embed("test-embed", fsys) }, PanicMatches, `embedded release "test-embed" registered twice`)
+	for _, name := range []string{"", "My-Release", "-release", "release/1"} {
+		c.Assert(func() { release.Embed(name, fsys) }, PanicMatches, `invalid embedded release name: .*`)
+	}
+}
@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package release_test
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})